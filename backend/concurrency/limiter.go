@@ -0,0 +1,220 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/jobs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Method classes. Each class has its own permits, so a burst of expensive
+// AI or export calls cannot starve cheap reads.
+const (
+	ClassRead   = "read"
+	ClassWrite  = "write"
+	ClassAI     = "ai"
+	ClassExport = "export"
+)
+
+// Classes lists every class in the order stats are reported
+var Classes = []string{ClassRead, ClassWrite, ClassAI, ClassExport}
+
+// methodClasses pins methods whose cost does not follow from their name.
+// Everything else is a read when its name starts with Get, List or Search,
+// and a write otherwise.
+var methodClasses = map[string]string{
+	"/clarity.ai.AIService/ScanPrescription":                              ClassAI,
+	"/clarity.ai.AIService/SummarizeHealth":                               ClassAI,
+	"/clarity.ai.AIService/DoctorChat":                                    ClassAI,
+	"/clarity.ai.AIService/VoiceChat":                                     ClassAI,
+	"/clarity.ai.AIService/SummarizeConversation":                         ClassAI,
+	"/clarity.health.HealthRecordsService/CreateExportLink":               ClassExport,
+	"/clarity.admin.AdminService/ReindexSearch":                           ClassExport,
+	"/clarity.organization.OrganizationService/ExportOrganizationRecords": ClassExport,
+	"/clarity.admin.AdminService/GenerateDataQualityReport":               ClassExport,
+	"/grpc.health.v1.Health/Check":                                        ClassRead,
+	"/grpc.health.v1.Health/Watch":                                        ClassRead,
+}
+
+// healthServicePrefix marks health checks, which bypass the limiter so a
+// saturated server still answers its load balancer
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// Classify returns the class of a full gRPC method name
+func Classify(fullMethod string) string {
+	if class, ok := methodClasses[fullMethod]; ok {
+		return class
+	}
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	for _, prefix := range []string{"Get", "List", "Search"} {
+		if strings.HasPrefix(name, prefix) {
+			return ClassRead
+		}
+	}
+	return ClassWrite
+}
+
+// ClassStats is a point-in-time view of one class
+type ClassStats struct {
+	Class    string
+	Limit    int // 0 when the class is unlimited
+	InFlight int64
+	Waiting  int64
+	Rejected int64 // since startup
+}
+
+type classLimiter struct {
+	limit    int
+	permits  *jobs.Limiter
+	inFlight atomic.Int64
+	waiting  atomic.Int64
+	rejected atomic.Int64
+}
+
+// Limiter caps concurrent RPCs per method class. Calls beyond a class's
+// limit wait briefly in a bounded queue and are then refused with
+// ResourceExhausted.
+type Limiter struct {
+	classes map[string]*classLimiter
+}
+
+func NewLimiter(cfg *config.ConcurrencyConfig) *Limiter {
+	limits := map[string]int{
+		ClassRead:   cfg.Read,
+		ClassWrite:  cfg.Write,
+		ClassAI:     cfg.AI,
+		ClassExport: cfg.Export,
+	}
+	timeout := time.Duration(cfg.QueueTimeout) * time.Millisecond
+
+	l := &Limiter{classes: make(map[string]*classLimiter, len(limits))}
+	for class, limit := range limits {
+		l.classes[class] = &classLimiter{
+			limit:   limit,
+			permits: jobs.NewLimiter(limit, cfg.MaxQueued, timeout),
+		}
+	}
+	return l
+}
+
+// Stats returns the in-flight and waiting calls of every class
+func (l *Limiter) Stats() []ClassStats {
+	stats := make([]ClassStats, 0, len(Classes))
+	for _, class := range Classes {
+		c := l.classes[class]
+		stats = append(stats, ClassStats{
+			Class:    class,
+			Limit:    max(c.limit, 0),
+			InFlight: c.inFlight.Load(),
+			Waiting:  c.waiting.Load(),
+			Rejected: c.rejected.Load(),
+		})
+	}
+	return stats
+}
+
+// acquire takes a permit for class. The returned release is safe to call
+// more than once.
+func (l *Limiter) acquire(ctx context.Context, class string) (func(), error) {
+	c := l.classes[class]
+
+	c.waiting.Add(1)
+	release, err := c.permits.Acquire(ctx)
+	c.waiting.Add(-1)
+	if err != nil {
+		if errors.Is(err, jobs.ErrAtCapacity) {
+			c.rejected.Add(1)
+			return nil, status.Error(codes.ResourceExhausted, fmt.Sprintf("too many %s requests in progress, try again shortly", class))
+		}
+		return nil, status.FromContextError(err).Err()
+	}
+
+	c.inFlight.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.inFlight.Add(-1)
+			release()
+		})
+	}, nil
+}
+
+// UnaryServerInterceptor holds a permit of the method's class for the
+// duration of the call. The permit is released in a defer, so it is
+// returned when the handler panics or the call is cancelled.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(ctx, req)
+		}
+		release, err := l.acquire(ctx, Classify(info.FullMethod))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor limits streams. A client-streaming call such as
+// DoctorChat holds no permit itself, so an idle open chat does not use up
+// its class; its handler takes one per message with Permit. Other streams
+// hold one for their lifetime.
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(srv, ss)
+		}
+		class := Classify(info.FullMethod)
+		if !info.IsClientStream {
+			release, err := l.acquire(ss.Context(), class)
+			if err != nil {
+				return err
+			}
+			defer release()
+			return handler(srv, ss)
+		}
+
+		ctx := context.WithValue(ss.Context(), permitKey{}, permitSource{limiter: l, class: class})
+		return handler(srv, &permitStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// permitKey carries the permitSource of a client stream in its context
+type permitKey struct{}
+
+type permitSource struct {
+	limiter *Limiter
+	class   string
+}
+
+// permitStream is a client stream whose context lets its handler take
+// permits with Permit
+type permitStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ps *permitStream) Context() context.Context { return ps.ctx }
+
+// Permit takes a permit of the class of the client stream ctx belongs to,
+// for handling one of its messages. Handlers answering messages
+// concurrently take one per message, so each in-flight message counts
+// against the class, and release it when the message is answered. Outside
+// a limited client stream it returns a release that does nothing.
+func Permit(ctx context.Context) (func(), error) {
+	source, ok := ctx.Value(permitKey{}).(permitSource)
+	if !ok {
+		return func() {}, nil
+	}
+	return source.limiter.acquire(ctx, source.class)
+}
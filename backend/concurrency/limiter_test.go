@@ -0,0 +1,292 @@
+package concurrency
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		method string
+		class  string
+	}{
+		{"/clarity.health.HealthRecordsService/GetRecord", ClassRead},
+		{"/clarity.health.HealthRecordsService/ListRecords", ClassRead},
+		{"/clarity.search.SearchService/SearchRecords", ClassRead},
+		{"/clarity.health.HealthRecordsService/CreateRecord", ClassWrite},
+		{"/clarity.health.HealthRecordsService/DeleteRecord", ClassWrite},
+		{"/clarity.health.HealthRecordsService/ParseAppointment", ClassWrite},
+		{"/clarity.ai.AIService/ScanPrescription", ClassAI},
+		{"/clarity.ai.AIService/SummarizeHealth", ClassAI},
+		{"/clarity.health.HealthRecordsService/CreateExportLink", ClassExport},
+		{"/clarity.organization.OrganizationService/ExportOrganizationRecords", ClassExport},
+		{"/grpc.health.v1.Health/Check", ClassRead},
+		{"/clarity.unknown.Service/Frobnicate", ClassWrite},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.method); got != tt.class {
+			t.Errorf("Classify(%s) = %s, want %s", tt.method, got, tt.class)
+		}
+	}
+}
+
+// callUnary runs method through the recovery interceptor and then the
+// limiter, as main chains them
+func callUnary(ctx context.Context, l *Limiter, method string, handler grpc.UnaryHandler) error {
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	limited := l.UnaryServerInterceptor()
+	_, err := logging.RecoveryUnaryInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return limited(ctx, req, info, handler)
+	})
+	return err
+}
+
+func ok(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+
+// classStats returns the stats of one class
+func classStats(l *Limiter, class string) ClassStats {
+	for _, stats := range l.Stats() {
+		if stats.Class == class {
+			return stats
+		}
+	}
+	return ClassStats{}
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+const (
+	scanMethod   = "/clarity.ai.AIService/ScanPrescription"
+	listMethod   = "/clarity.health.HealthRecordsService/ListRecords"
+	createMethod = "/clarity.health.HealthRecordsService/CreateRecord"
+)
+
+func TestSaturatedClassLeavesOthersUnaffected(t *testing.T) {
+	l := NewLimiter(&config.ConcurrencyConfig{Read: 10, Write: 5, AI: 2, Export: 1, MaxQueued: 0, QueueTimeout: 20})
+	ctx := context.Background()
+
+	// Fill the AI class with calls that block until released
+	unblock := make(chan struct{})
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- callUnary(ctx, l, scanMethod, func(ctx context.Context, req interface{}) (interface{}, error) {
+				<-unblock
+				return nil, nil
+			})
+		}()
+	}
+	waitFor(t, "two AI calls in flight", func() bool { return classStats(l, ClassAI).InFlight == 2 })
+
+	if err := callUnary(ctx, l, scanMethod, ok); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("AI call over the limit: error = %v, want ResourceExhausted", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := callUnary(ctx, l, listMethod, ok); err != nil {
+			t.Fatalf("read %d while AI is saturated: %v", i, err)
+		}
+	}
+	if err := callUnary(ctx, l, createMethod, ok); err != nil {
+		t.Errorf("write while AI is saturated: %v", err)
+	}
+
+	if stats := classStats(l, ClassAI); stats.Limit != 2 || stats.Rejected != 1 {
+		t.Errorf("AI stats = %+v, want limit 2 and 1 rejected", stats)
+	}
+	if stats := classStats(l, ClassRead); stats.InFlight != 0 || stats.Rejected != 0 {
+		t.Errorf("read stats = %+v, want nothing in flight or rejected", stats)
+	}
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("blocked AI call: %v", err)
+		}
+	}
+	if stats := classStats(l, ClassAI); stats.InFlight != 0 {
+		t.Errorf("AI calls still in flight after finishing: %d", stats.InFlight)
+	}
+}
+
+func TestQueuedCallGetsTheNextPermit(t *testing.T) {
+	l := NewLimiter(&config.ConcurrencyConfig{AI: 1, MaxQueued: 1, QueueTimeout: 60000})
+	ctx := context.Background()
+
+	unblock := make(chan struct{})
+	first := make(chan error, 1)
+	go func() {
+		first <- callUnary(ctx, l, scanMethod, func(ctx context.Context, req interface{}) (interface{}, error) {
+			<-unblock
+			return nil, nil
+		})
+	}()
+	waitFor(t, "the first call in flight", func() bool { return classStats(l, ClassAI).InFlight == 1 })
+
+	queued := make(chan error, 1)
+	go func() { queued <- callUnary(ctx, l, scanMethod, ok) }()
+	waitFor(t, "the second call queued", func() bool { return classStats(l, ClassAI).Waiting == 1 })
+
+	close(unblock)
+	if err := <-first; err != nil {
+		t.Errorf("first call: %v", err)
+	}
+	if err := <-queued; err != nil {
+		t.Errorf("queued call: %v", err)
+	}
+}
+
+func TestPermitReleasedWhenHandlerPanics(t *testing.T) {
+	l := NewLimiter(&config.ConcurrencyConfig{AI: 1, QueueTimeout: 20})
+	ctx := context.Background()
+
+	err := callUnary(ctx, l, scanMethod, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("panicking call: error = %v, want Internal", err)
+	}
+	if stats := classStats(l, ClassAI); stats.InFlight != 0 {
+		t.Errorf("%d calls in flight after a panic", stats.InFlight)
+	}
+	if err := callUnary(ctx, l, scanMethod, ok); err != nil {
+		t.Errorf("call after a panic: %v", err)
+	}
+}
+
+func TestPermitReleasedWhenCallCancelled(t *testing.T) {
+	l := NewLimiter(&config.ConcurrencyConfig{AI: 1, MaxQueued: 1, QueueTimeout: 60000})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan error, 1)
+	go func() {
+		running <- callUnary(ctx, l, scanMethod, func(ctx context.Context, req interface{}) (interface{}, error) {
+			<-ctx.Done()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		})
+	}()
+	waitFor(t, "the call in flight", func() bool { return classStats(l, ClassAI).InFlight == 1 })
+
+	// A queued call whose client gives up leaves the queue
+	queuedCtx, cancelQueued := context.WithCancel(context.Background())
+	queued := make(chan error, 1)
+	go func() { queued <- callUnary(queuedCtx, l, scanMethod, ok) }()
+	waitFor(t, "the second call queued", func() bool { return classStats(l, ClassAI).Waiting == 1 })
+	cancelQueued()
+	if err := <-queued; status.Code(err) != codes.Canceled {
+		t.Errorf("cancelled queued call: error = %v, want Canceled", err)
+	}
+
+	cancel()
+	if err := <-running; status.Code(err) != codes.Canceled {
+		t.Errorf("cancelled call: error = %v, want Canceled", err)
+	}
+	if stats := classStats(l, ClassAI); stats.InFlight != 0 || stats.Waiting != 0 {
+		t.Errorf("AI stats after cancelling = %+v", stats)
+	}
+	if err := callUnary(context.Background(), l, scanMethod, ok); err != nil {
+		t.Errorf("call after cancellations: %v", err)
+	}
+}
+
+func TestHealthChecksBypassTheLimiter(t *testing.T) {
+	l := NewLimiter(&config.ConcurrencyConfig{Read: 1, QueueTimeout: 20})
+	ctx := context.Background()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	go callUnary(ctx, l, listMethod, func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-unblock
+		return nil, nil
+	})
+	waitFor(t, "the read in flight", func() bool { return classStats(l, ClassRead).InFlight == 1 })
+
+	if err := callUnary(ctx, l, "/grpc.health.v1.Health/Check", ok); err != nil {
+		t.Errorf("health check with reads saturated: %v", err)
+	}
+}
+
+// fakeServerStream delivers a fixed number of messages then io.EOF
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages int
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	if s.messages == 0 {
+		return io.EOF
+	}
+	s.messages--
+	return nil
+}
+
+func TestClientStreamHoldsAPermitOnlyPerMessage(t *testing.T) {
+	l := NewLimiter(&config.ConcurrencyConfig{AI: 1, QueueTimeout: 20})
+	info := &grpc.StreamServerInfo{FullMethod: "/clarity.ai.AIService/DoctorChat", IsClientStream: true, IsServerStream: true}
+	stream := &fakeServerStream{ctx: context.Background(), messages: 3}
+
+	handled := 0
+	err := l.StreamServerInterceptor()(nil, stream, info, func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			if err := ss.RecvMsg(nil); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			// Idle between messages: the class is free for other calls
+			if stats := classStats(l, ClassAI); stats.InFlight != 0 {
+				t.Errorf("open stream holds %d permits before handling a message", stats.InFlight)
+			}
+			release, err := Permit(ss.Context())
+			if err != nil {
+				return err
+			}
+			if stats := classStats(l, ClassAI); stats.InFlight != 1 {
+				t.Errorf("handling a message with %d permits held, want 1", stats.InFlight)
+			}
+			// A second message at once finds the class full
+			if _, err := Permit(ss.Context()); status.Code(err) != codes.ResourceExhausted {
+				t.Errorf("second permit with the class full: %v, want ResourceExhausted", err)
+			}
+			release()
+			handled++
+		}
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if handled != 3 {
+		t.Errorf("handled %d messages, want 3", handled)
+	}
+	if stats := classStats(l, ClassAI); stats.InFlight != 0 {
+		t.Errorf("%d permits held after the stream ended", stats.InFlight)
+	}
+}
+
+func TestPermitOutsideALimitedStream(t *testing.T) {
+	release, err := Permit(context.Background())
+	if err != nil {
+		t.Fatalf("Permit: %v", err)
+	}
+	release()
+}
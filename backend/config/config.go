@@ -2,72 +2,629 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Database DatabaseConfig
-	Server   ServerConfig
-	Auth     AuthConfig
-	AI       AIConfig
+	Database    DatabaseConfig
+	Server      ServerConfig
+	Auth        AuthConfig
+	AI          AIConfig
+	Records     RecordsConfig
+	Jobs        JobsConfig
+	Delivery    DeliveryConfig
+	Export      ExportConfig
+	Cache       CacheConfig
+	Features    FeaturesConfig
+	Admin       AdminConfig
+	Reference   ReferenceConfig
+	Logging     LoggingConfig
+	Concurrency ConcurrencyConfig
+	Maintenance MaintenanceConfig
+	Versioning  VersioningConfig
+	Gateway     GatewayConfig
 }
 
 type DatabaseConfig struct {
-	Type     string // sqlite, postgres, mysql
-	Path     string // for sqlite
-	Host     string
-	Port     string
-	User     string
-	Password string
-	DbName   string
+	Type          string // sqlite, postgres, mysql
+	Path          string // for sqlite
+	Host          string
+	Port          string
+	User          string
+	Password      string
+	DbName        string
 	CloudProvider string // aws, gcp, azure, or local
+
+	StatementTimeout int // milliseconds before a statement is aborted, 0 disables
+	BusyTimeout      int // milliseconds a SQLite statement waits for a lock
+
+	// AutoMigrate updates tables to match the models after the versioned
+	// migrations run, for development before a migration is written
+	AutoMigrate bool
+
+	SSLMode           string // libpq sslmode, also mapped onto MySQL's tls: disable, require, verify-ca, verify-full
+	ConnectRetries    int    // extra connection attempts at startup
+	ConnectRetryDelay int    // seconds before the first retry, doubled per attempt
+
+	// Connection pool limits, per tenant database in multi-tenant mode
+	MaxOpenConns    int // 0 is unlimited
+	MaxIdleConns    int // 0 keeps the default of 2, negative keeps none idle
+	ConnMaxLifetime int // seconds a connection is reused, 0 is forever
+	ConnMaxIdleTime int // seconds a connection may sit idle, 0 is forever
+
+	// Tenants gives each tenant its own database, as "id=path" entries.
+	// Empty keeps all data in the database at Path.
+	Tenants []string
 }
 
 type ServerConfig struct {
 	Port string
 	Host string
+
+	HTTPPort      string // HTTP gateway for share links, empty disables it
+	PublicBaseURL string // externally reachable base URL of the HTTP gateway
 }
 
 type AuthConfig struct {
-	OTPExpiry int // seconds
-	JWTSecret string
-	OTPLength int
+	OTPExpiry   int // seconds
+	JWTSecret   string
+	OTPLength   int
+	OTPDailyCap int // OTPs per email per UTC day, 0 disables
+
+	// At most MaxOTPPerWindow OTPs are sent to an email in any
+	// RateLimitWindow seconds; 0 disables the limit
+	MaxOTPPerWindow int
+	RateLimitWindow int
+
+	// After OTPMaxAttempts wrong codes an email is locked out of signing
+	// in and requesting codes for OTPLockout seconds; 0 disables the limit
+	OTPMaxAttempts int
+	OTPLockout     int
+
+	TOTPIssuer string // shown in authenticator apps
+	TOTPSkew   int    // 30-second steps of clock drift accepted either side
+
+	// SessionIdleTimeout is how many seconds a session may go unused
+	// before it has to sign in again; 0 disables the limit
+	SessionIdleTimeout int
+}
+
+type RecordsConfig struct {
+	MaxMetadataSize        int // bytes of serialized JSON
+	MaxMetadataKeys        int
+	MaxMetadataKeyLength   int // characters
+	MaxMetadataValueLength int // bytes
+
+	// Record types or metadata categories that default to sensitive,
+	// requiring non-owners to state a reason before reading them
+	SensitiveCategories []string
+
+	// DetectLanguage stores each record's detected language in its
+	// metadata. Records in a language other than DefaultLanguage are
+	// marked for translation when passed to the AI provider.
+	DetectLanguage  bool
+	DefaultLanguage string // ISO 639-1
+
+	// TombstoneRetention is how many seconds deleted records stay visible
+	// to sync. Clients that have not synced for longer must resync fully.
+	TombstoneRetention int
+
+	// TypesPath is a JSON file of custom record types, each with a
+	// metadata schema, accepted alongside the built-in types
+	TypesPath string
+
+	// DeletedRetention is how many seconds deleted records stay in the
+	// trash, where their owner can restore them, before they are purged
+	DeletedRetention int
+
+	// DuplicateWindow is how many seconds a create repeating the type,
+	// title and description of one of the owner's records returns that
+	// record instead, for clients that retry without knowing whether the
+	// first attempt landed. 0 disables.
+	DuplicateWindow int
+}
+
+type JobsConfig struct {
+	ReminderInterval       int // seconds between reminder dispatch runs, 0 disables
+	SearchReindexInterval  int // seconds between incremental search reindex runs, 0 disables
+	DeliveryInterval       int // seconds between delivery queue runs, 0 disables
+	ExportPurgeInterval    int // seconds between purges of expired export links, 0 disables
+	MedicationInterval     int // seconds between medication dose reminder top-ups, 0 disables
+	ReprocessInterval      int // seconds between re-extraction batches, 0 disables
+	TombstonePurgeInterval int // seconds between purges of expired record tombstones, 0 disables
+
+	RevokedTokenPurgeInterval  int // seconds between purges of expired revoked tokens, 0 disables
+	DeletedRecordPurgeInterval int // seconds between purges of records out of the trash window, 0 disables
+	OTPPurgeInterval           int // seconds between purges of expired sign-in codes, 0 disables
+	ConversationPurgeInterval  int // seconds between purges of stale doctor chat conversations, 0 disables
+	DeliveryPurgeInterval      int // seconds between purges of finished deliveries, 0 disables
+
+	ReprocessBatchSize     int // records re-extracted per batch
+	ReprocessRatePerMinute int // provider calls per minute allowed for re-extraction
+
+	MaxConcurrentBatch int // batch jobs (reindex, reports, exports) running at once, 0 disables the limit
+	MaxQueuedBatch     int // batch jobs allowed to wait for a slot, 0 rejects immediately
+	BatchQueueTimeout  int // seconds a queued batch job waits before giving up, 0 waits until cancelled
+}
+
+type CacheConfig struct {
+	Backend    string // memory, redis
+	RedisURL   string // e.g. redis://localhost:6379/0
+	TTL        int    // seconds AI responses stay cached, 0 disables caching
+	MaxEntries int    // memory backend only
+}
+
+// FeaturesConfig holds the feature flags. Disabled features are reported
+// as unavailable to clients and refused by the server.
+type FeaturesConfig struct {
+	Disabled []string // scan, chat, summaries, search
+
+	// DisabledEndpoints are gRPC methods refused with Unavailable at
+	// startup, by name (ScanPrescription) or full name. Admins can switch
+	// them back on at runtime.
+	DisabledEndpoints []string
+}
+
+// VersioningConfig sets the oldest client releases the server accepts
+type VersioningConfig struct {
+	// MinClientVersions are "Method=1.4.0" entries, by name or full name;
+	// "*=1.2.0" applies to every method without its own entry
+	MinClientVersions []string
+}
+
+// MaintenanceConfig controls read-only maintenance mode, during which
+// mutating RPCs are refused with Unavailable
+type MaintenanceConfig struct {
+	Enabled      bool   // start in maintenance mode
+	Reason       string // shown to refused callers
+	RetryAfter   int    // seconds, suggested to refused callers
+	DrainTimeout int    // seconds to wait for running writes when entering
+}
+
+type ExportConfig struct {
+	LinkDefaultTTL int // seconds
+	LinkMaxTTL     int // seconds, longer requests are rejected
+	MaxLinkRecords int
+	PINMaxAttempts int // wrong PINs before the link locks
+	PINLockout     int // seconds a locked link stays locked
+
+	// MaxRecordShares caps the active links any one record is shared
+	// through; 0 disables the cap
+	MaxRecordShares int
+
+	// MaxLinkAccesses caps how many times one link can be opened; 0
+	// disables the cap
+	MaxLinkAccesses int
+	// A link opened from more than SuspiciousAccessors distinct addresses
+	// within SuspiciousWindow seconds is revoked and its owner alerted; 0
+	// disables the check
+	SuspiciousAccessors int
+	SuspiciousWindow    int
+}
+
+// GatewayConfig throttles callers of the unauthenticated HTTP gateway by
+// address. A caller over the rate limit is blocked for PenaltyBase
+// seconds, doubled for each further offence up to PenaltyMax.
+type GatewayConfig struct {
+	RateLimit   int // requests per address per RateWindow, 0 disables throttling
+	RateWindow  int // seconds
+	PenaltyBase int // seconds
+	PenaltyMax  int // seconds
+
+	// PINNonce makes each PIN form single use, so a captured PIN
+	// submission cannot be replayed. Nonces are held in memory, so it
+	// needs a single gateway instance or sticky sessions.
+	PINNonce bool
+	NonceTTL int // seconds a PIN form stays valid
+}
+
+type DeliveryConfig struct {
+	MaxAttempts int // attempts before a message is marked failed
+	BaseBackoff int // seconds before the first retry, doubled per attempt
+	MaxBackoff  int // seconds, upper bound on the retry delay
+
+	// RetryOverrides change which failed sends are retried, as
+	// "channel:category=retry" or "channel:status=fail" entries; channel
+	// may be "*"
+	RetryOverrides []string
+
+	// Retention is how many seconds a sent or failed message is kept, for
+	// support to look up by its reference, before it is purged
+	Retention int
+
+	// SMTP server for the email channel; without SMTPHost emails are only
+	// logged, for development
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string // empty sends without logging in
+	SMTPPassword string
+	SMTPFrom     string // From address, e.g. "Clarity <no-reply@example.com>"
+}
+
+type AdminConfig struct {
+	APIKey string // required in x-admin-key metadata for admin RPCs; empty disables them
+}
+
+type LoggingConfig struct {
+	Level            string // debug, info, warn, error
+	MaxDebugDuration int    // seconds a targeted debug session may last
+}
+
+// ConcurrencyConfig caps in-flight RPCs per method class (read, write, ai,
+// export); 0 leaves a class unlimited
+type ConcurrencyConfig struct {
+	Read   int
+	Write  int
+	AI     int
+	Export int
+
+	MaxQueued    int // calls per class allowed to wait for a permit
+	QueueTimeout int // milliseconds a queued call waits before ResourceExhausted
+}
+
+type ReferenceConfig struct {
+	MedicationDatasetPath string // CSV of generic,brands; empty disables normalization
+	RefreshInterval       int    // seconds between full reloads of reference datasets, 0 reloads only on change
+	WatchInterval         int    // seconds between checks for changed dataset files, 0 disables reloading
 }
 
 type AIConfig struct {
-	Provider string // openai, google, huggingface, etc.
-	APIKey   string
+	Provider string // openai, gemini, bedrock, sandbox, mock
+	APIKey   string // for Provider, unless ProviderAPIKeys has its key
+	Model    string // Provider's model; empty uses the provider's default
+	Region   string // AWS region of the bedrock provider
+
+	// ProviderAPIKeys holds each hosted provider's own key by name, so
+	// providers used as overrides can authenticate too
+	ProviderAPIKeys map[string]string
+
+	// Language summaries are written in, the records' default language
+	Language string
+
+	// StructuredOutput sends a JSON schema of the expected reply to
+	// providers whose APIs enforce one (OpenAI, Gemini); the others are
+	// only asked for JSON in the prompt
+	StructuredOutput bool
+
+	// ProviderOverrides are the providers an admin may route a single
+	// request to with x-ai-provider metadata; empty disables overrides
+	ProviderOverrides []string
+
+	SelfTest         bool // call the provider once at startup
+	SelfTestRequired bool // abort startup when the self-test fails
+	SelfTestTimeout  int  // seconds
+
+	STTProvider  string // openai, mock
+	STTAPIKey    string
+	MaxAudioSize int // bytes
+
+	// Scan pre-check thresholds, 0 disables each check
+	MinImageDimension  int // pixels, shorter side
+	MinImageSharpness  int // variance of the Laplacian
+	MinImageBrightness int // mean luminance, 0-255
+	MaxImageBrightness int // mean luminance, 0-255
+
+	// Enhancement of scanned images before they are sent for recognition
+	ImageEnhancer       string   // none, basic
+	EnhanceSteps        []string // denoise, sharpen, threshold; run in that order
+	EnhanceMaxSharpness int      // images at least this sharp are sent as taken, 0 enhances all
+
+	// Circuit breaker around provider calls
+	BreakerThreshold int // consecutive failures that open the breaker, 0 disables
+	BreakerCooldown  int // seconds the breaker stays open before a trial call
+
+	MaxStoredChatLength int // bytes of each chat message and response kept in history, 0 disables
+
+	// Tools doctor chat offers to providers that support tool calling
+	ChatTools     []string // tool names; empty disables tool calling
+	MaxToolRounds int      // rounds of tool calls per message before the model must answer
+
+	// ChatSummaryContext sends a conversation's stored summary with each
+	// new message, giving the provider its context without the full history
+	ChatSummaryContext  bool
+	ChatSummaryMaxInput int // bytes of conversation sent to be summarized
+
+	// ChatHistoryTurns is how many of a conversation's latest turns are
+	// sent with each new message; with ChatSummaryContext only turns after
+	// the summary count. 0 sends none.
+	ChatHistoryTurns int
+
+	// ChatStreamConcurrency is how many messages of one DoctorChat stream
+	// are answered at once. Messages to the same conversation always wait
+	// for the one before.
+	ChatStreamConcurrency int
+
+	// ConversationRetention is how many seconds a doctor chat conversation
+	// is kept after its last turn; 0 keeps conversations forever
+	ConversationRetention int
+
+	// Caps on health summaries, truncated with a marker; 0 disables each
+	MaxSummaryFindings        int // key findings, the marker included
+	MaxSummaryRecommendations int // bytes of recommendations
+
+	MaxSummaryRecords int // most recent records in the window summarized, 0 disables
 }
 
 func LoadConfig() *Config {
 	godotenv.Load()
 
+	dbType := getEnv("DB_TYPE", "sqlite")
+	defaultDBPort := "5432"
+	if dbType == "mysql" {
+		defaultDBPort = "3306"
+	}
+
 	return &Config{
 		Database: DatabaseConfig{
-			Type:          getEnv("DB_TYPE", "sqlite"),
+			Type:          dbType,
 			Path:          getEnv("DB_PATH", "./clarity.db"),
+			Host:          getEnv("DB_HOST", "localhost"),
+			Port:          getEnv("DB_PORT", defaultDBPort), // 3306 for MySQL
+			User:          getEnv("DB_USER", "clarity"),
+			Password:      getEnv("DB_PASSWORD", ""),
+			DbName:        getEnv("DB_NAME", "clarity"),
 			CloudProvider: getEnv("CLOUD_PROVIDER", "local"),
+
+			StatementTimeout: getEnvInt("DB_STATEMENT_TIMEOUT_MS", 30000),
+			BusyTimeout:      getEnvInt("DB_BUSY_TIMEOUT_MS", 5000),
+
+			AutoMigrate: getEnvBool("DB_AUTO_MIGRATE", false),
+
+			SSLMode:           getEnv("DB_SSLMODE", "require"),
+			ConnectRetries:    getEnvInt("DB_CONNECT_RETRIES", 5),
+			ConnectRetryDelay: getEnvInt("DB_CONNECT_RETRY_DELAY", 2),
+
+			MaxOpenConns:    getEnvInt("DB_MAX_OPEN_CONNS", 0),
+			MaxIdleConns:    getEnvInt("DB_MAX_IDLE_CONNS", 0),
+			ConnMaxLifetime: getEnvInt("DB_CONN_MAX_LIFETIME", 0),
+			ConnMaxIdleTime: getEnvInt("DB_CONN_MAX_IDLE_TIME", 0),
+
+			Tenants: getEnvList("DB_TENANTS", ""),
 		},
 		Server: ServerConfig{
 			Port: getEnv("SERVER_PORT", "50051"),
 			Host: getEnv("SERVER_HOST", "localhost"),
+
+			HTTPPort:      getEnv("HTTP_PORT", "8081"),
+			PublicBaseURL: getEnv("PUBLIC_BASE_URL", "http://localhost:8081"),
 		},
 		Auth: AuthConfig{
 			OTPExpiry: 600, // 10 minutes
 			JWTSecret: getEnv("JWT_SECRET", "your-secret-key"),
 			OTPLength: 6,
+
+			OTPDailyCap: getEnvInt("OTP_DAILY_CAP", 10),
+
+			MaxOTPPerWindow: getEnvInt("OTP_MAX_PER_WINDOW", 3),
+			RateLimitWindow: getEnvInt("OTP_RATE_LIMIT_WINDOW", 900), // 15 minutes
+
+			OTPMaxAttempts: getEnvInt("OTP_MAX_ATTEMPTS", 5),
+			OTPLockout:     getEnvInt("OTP_LOCKOUT", 900), // 15 minutes
+
+			TOTPIssuer: getEnv("TOTP_ISSUER", "Clarity"),
+			TOTPSkew:   getEnvInt("TOTP_SKEW", 1),
+
+			SessionIdleTimeout: getEnvInt("SESSION_IDLE_TIMEOUT", 0),
 		},
 		AI: AIConfig{
 			Provider: getEnv("AI_PROVIDER", "openai"),
 			APIKey:   getEnv("AI_API_KEY", ""),
+			Model:    getEnv("AI_MODEL", ""),
+			Region:   getEnv("AI_REGION", "us-east-1"),
+
+			ProviderAPIKeys: map[string]string{
+				"openai":  getEnv("OPENAI_API_KEY", ""),
+				"gemini":  getEnv("GEMINI_API_KEY", ""),
+				"bedrock": getEnv("BEDROCK_API_KEY", ""),
+			},
+
+			Language: getEnv("RECORD_DEFAULT_LANGUAGE", "en"),
+
+			StructuredOutput: getEnvBool("AI_STRUCTURED_OUTPUT", true),
+
+			ProviderOverrides: getEnvList("AI_PROVIDER_OVERRIDES", ""),
+
+			SelfTest:         getEnvBool("AI_SELF_TEST", false),
+			SelfTestRequired: getEnvBool("AI_SELF_TEST_REQUIRED", false),
+			SelfTestTimeout:  getEnvInt("AI_SELF_TEST_TIMEOUT", 10),
+
+			STTProvider:  getEnv("STT_PROVIDER", "mock"),
+			STTAPIKey:    getEnv("STT_API_KEY", getEnv("AI_API_KEY", "")),
+			MaxAudioSize: getEnvInt("STT_MAX_AUDIO_SIZE", 10*1024*1024), // 10 MB
+
+			MinImageDimension:  getEnvInt("SCAN_MIN_IMAGE_DIMENSION", 480),
+			MinImageSharpness:  getEnvInt("SCAN_MIN_IMAGE_SHARPNESS", 50),
+			MinImageBrightness: getEnvInt("SCAN_MIN_IMAGE_BRIGHTNESS", 50),
+			MaxImageBrightness: getEnvInt("SCAN_MAX_IMAGE_BRIGHTNESS", 240),
+
+			ImageEnhancer:       getEnv("SCAN_IMAGE_ENHANCER", "none"),
+			EnhanceSteps:        getEnvList("SCAN_ENHANCE_STEPS", "denoise,sharpen"),
+			EnhanceMaxSharpness: getEnvInt("SCAN_ENHANCE_MAX_SHARPNESS", 500),
+
+			BreakerThreshold: getEnvInt("AI_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:  getEnvInt("AI_BREAKER_COOLDOWN", 60),
+
+			MaxStoredChatLength: getEnvInt("CHAT_MAX_STORED_LENGTH", 16*1024), // 16 KB
+
+			ChatTools:     getEnvList("CHAT_TOOLS", "list_recent_records,get_health_summary"),
+			MaxToolRounds: getEnvInt("CHAT_MAX_TOOL_ROUNDS", 3),
+
+			ChatSummaryContext:  getEnvBool("CHAT_SUMMARY_CONTEXT", false),
+			ChatSummaryMaxInput: getEnvInt("CHAT_SUMMARY_MAX_INPUT", 32*1024), // 32 KB
+
+			ChatHistoryTurns: getEnvInt("CHAT_HISTORY_TURNS", 10),
+
+			ChatStreamConcurrency: getEnvInt("CHAT_STREAM_CONCURRENCY", 4),
+
+			ConversationRetention: getEnvInt("CHAT_CONVERSATION_RETENTION", 0),
+
+			MaxSummaryFindings:        getEnvInt("SUMMARY_MAX_FINDINGS", 20),
+			MaxSummaryRecommendations: getEnvInt("SUMMARY_MAX_RECOMMENDATIONS_LENGTH", 4*1024), // 4 KB
+
+			MaxSummaryRecords: getEnvInt("SUMMARY_MAX_RECORDS", 500),
+		},
+		Records: RecordsConfig{
+			MaxMetadataSize:        getEnvInt("RECORD_MAX_METADATA_SIZE", 16*1024), // 16 KB
+			MaxMetadataKeys:        getEnvInt("RECORD_MAX_METADATA_KEYS", 50),
+			MaxMetadataKeyLength:   getEnvInt("RECORD_MAX_METADATA_KEY_LENGTH", 64),
+			MaxMetadataValueLength: getEnvInt("RECORD_MAX_METADATA_VALUE_LENGTH", 2048),
+
+			SensitiveCategories: getEnvList("RECORD_SENSITIVE_CATEGORIES", "mental_health,reproductive_health,sexual_health,substance_use"),
+
+			DetectLanguage:  getEnvBool("RECORD_DETECT_LANGUAGE", true),
+			DefaultLanguage: getEnv("RECORD_DEFAULT_LANGUAGE", "en"),
+
+			TombstoneRetention: getEnvInt("RECORD_TOMBSTONE_RETENTION", 30*24*3600),
+
+			TypesPath: getEnv("RECORD_TYPES_PATH", ""),
+
+			DeletedRetention: getEnvInt("RECORD_DELETED_RETENTION", 30*24*3600),
+
+			DuplicateWindow: getEnvInt("RECORD_DUPLICATE_WINDOW", 0),
+		},
+		Jobs: JobsConfig{
+			ReminderInterval:       getEnvInt("REMINDER_DISPATCH_INTERVAL", 60),
+			SearchReindexInterval:  getEnvInt("SEARCH_REINDEX_INTERVAL", 3600),
+			DeliveryInterval:       getEnvInt("DELIVERY_DISPATCH_INTERVAL", 15),
+			ExportPurgeInterval:    getEnvInt("EXPORT_PURGE_INTERVAL", 3600),
+			MedicationInterval:     getEnvInt("MEDICATION_REMINDER_INTERVAL", 3600),
+			ReprocessInterval:      getEnvInt("REPROCESS_INTERVAL", 60),
+			TombstonePurgeInterval: getEnvInt("TOMBSTONE_PURGE_INTERVAL", 3600),
+
+			RevokedTokenPurgeInterval:  getEnvInt("REVOKED_TOKEN_PURGE_INTERVAL", 3600),
+			DeletedRecordPurgeInterval: getEnvInt("DELETED_RECORD_PURGE_INTERVAL", 3600),
+			OTPPurgeInterval:           getEnvInt("OTP_PURGE_INTERVAL", 3600),
+			ConversationPurgeInterval:  getEnvInt("CONVERSATION_PURGE_INTERVAL", 86400),
+			DeliveryPurgeInterval:      getEnvInt("DELIVERY_PURGE_INTERVAL", 3600),
+
+			ReprocessBatchSize:     getEnvInt("REPROCESS_BATCH_SIZE", 20),
+			ReprocessRatePerMinute: getEnvInt("REPROCESS_RATE_PER_MINUTE", 30),
+
+			MaxConcurrentBatch: getEnvInt("BATCH_MAX_CONCURRENT", 2),
+			MaxQueuedBatch:     getEnvInt("BATCH_MAX_QUEUED", 4),
+			BatchQueueTimeout:  getEnvInt("BATCH_QUEUE_TIMEOUT", 30),
+		},
+		Cache: CacheConfig{
+			Backend:    getEnv("CACHE_BACKEND", "memory"),
+			RedisURL:   getEnv("REDIS_URL", "redis://localhost:6379/0"),
+			TTL:        getEnvInt("CACHE_TTL", 3600),
+			MaxEntries: getEnvInt("CACHE_MAX_ENTRIES", 10000),
+		},
+		Features: FeaturesConfig{
+			Disabled:          getEnvList("FEATURES_DISABLED", ""),
+			DisabledEndpoints: getEnvList("ENDPOINTS_DISABLED", ""),
+		},
+		Versioning: VersioningConfig{
+			MinClientVersions: getEnvList("CLIENT_MIN_VERSIONS", ""),
+		},
+		Maintenance: MaintenanceConfig{
+			Enabled:      getEnvBool("MAINTENANCE_MODE", false),
+			Reason:       getEnv("MAINTENANCE_REASON", ""),
+			RetryAfter:   getEnvInt("MAINTENANCE_RETRY_AFTER", 300),
+			DrainTimeout: getEnvInt("MAINTENANCE_DRAIN_TIMEOUT", 30),
+		},
+		Concurrency: ConcurrencyConfig{
+			Read:   getEnvInt("GRPC_MAX_CONCURRENT_READ", 64),
+			Write:  getEnvInt("GRPC_MAX_CONCURRENT_WRITE", 32),
+			AI:     getEnvInt("GRPC_MAX_CONCURRENT_AI", 4),
+			Export: getEnvInt("GRPC_MAX_CONCURRENT_EXPORT", 2),
+
+			MaxQueued:    getEnvInt("GRPC_MAX_QUEUED", 16),
+			QueueTimeout: getEnvInt("GRPC_QUEUE_TIMEOUT_MS", 500),
+		},
+		Export: ExportConfig{
+			LinkDefaultTTL: getEnvInt("EXPORT_LINK_DEFAULT_TTL", 72*3600), // 3 days
+			LinkMaxTTL:     getEnvInt("EXPORT_LINK_MAX_TTL", 14*24*3600),  // 14 days
+			MaxLinkRecords: getEnvInt("EXPORT_LINK_MAX_RECORDS", 200),
+			PINMaxAttempts: getEnvInt("EXPORT_LINK_PIN_MAX_ATTEMPTS", 5),
+			PINLockout:     getEnvInt("EXPORT_LINK_PIN_LOCKOUT", 900),
+
+			MaxRecordShares: getEnvInt("EXPORT_MAX_RECORD_SHARES", 10),
+
+			MaxLinkAccesses:     getEnvInt("EXPORT_LINK_MAX_ACCESSES", 100),
+			SuspiciousAccessors: getEnvInt("EXPORT_LINK_SUSPICIOUS_ACCESSORS", 5),
+			SuspiciousWindow:    getEnvInt("EXPORT_LINK_SUSPICIOUS_WINDOW", 3600),
+		},
+		Gateway: GatewayConfig{
+			RateLimit:   getEnvInt("GATEWAY_RATE_LIMIT", 30),
+			RateWindow:  getEnvInt("GATEWAY_RATE_WINDOW", 60),
+			PenaltyBase: getEnvInt("GATEWAY_PENALTY_BASE", 60),
+			PenaltyMax:  getEnvInt("GATEWAY_PENALTY_MAX", 3600),
+			PINNonce:    getEnvBool("GATEWAY_PIN_NONCE", false),
+			NonceTTL:    getEnvInt("GATEWAY_NONCE_TTL", 900),
+		},
+		Delivery: DeliveryConfig{
+			MaxAttempts: getEnvInt("DELIVERY_MAX_ATTEMPTS", 6),
+			BaseBackoff: getEnvInt("DELIVERY_BASE_BACKOFF", 30),
+			MaxBackoff:  getEnvInt("DELIVERY_MAX_BACKOFF", 3600),
+
+			RetryOverrides: getEnvList("DELIVERY_RETRY_OVERRIDES", ""),
+
+			Retention: getEnvInt("DELIVERY_RETENTION", 7*24*3600),
+
+			SMTPHost:     getEnv("SMTP_HOST", ""),
+			SMTPPort:     getEnvInt("SMTP_PORT", 587),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			SMTPFrom:     getEnv("SMTP_FROM", "Clarity <no-reply@clarity.local>"),
+		},
+		Admin: AdminConfig{
+			APIKey: getEnv("ADMIN_API_KEY", ""),
+		},
+		Reference: ReferenceConfig{
+			MedicationDatasetPath: getEnv("MEDICATION_DATASET_PATH", "./datasets/medications.csv"),
+			RefreshInterval:       getEnvInt("REFERENCE_REFRESH_INTERVAL", 86400),
+			WatchInterval:         getEnvInt("REFERENCE_WATCH_INTERVAL", 30),
+		},
+		Logging: LoggingConfig{
+			Level:            getEnv("LOG_LEVEL", "info"),
+			MaxDebugDuration: getEnvInt("DEBUG_TARGET_MAX_DURATION", 3600), // 1 hour
 		},
 	}
 }
 
+// Reload re-reads the .env file, overriding previously loaded values, and
+// returns the resulting configuration
+func Reload() *Config {
+	godotenv.Overload()
+	return LoadConfig()
+}
+
 func getEnv(key, defaultVal string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 	return defaultVal
 }
+
+func getEnvInt(key string, defaultVal int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultVal
+}
+
+// getEnvList reads a comma-separated list, dropping blank entries
+func getEnvList(key, defaultVal string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultVal), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultVal
+}
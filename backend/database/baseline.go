@@ -0,0 +1,461 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The schema as of migration 2, which brings databases kept up to date by
+// AutoMigrate alone to it. These copies of the models are frozen: later
+// model changes belong in the steps that follow, so step 2 never creates
+// a column a later step adds.
+var baselineTables = []interface{}{
+	&baselineUser{},
+	&baselineOTPStore{},
+	&baselineOTPIssuance{},
+	&baselineOTPAttempts{},
+	&baselineTOTPCredential{},
+	&baselineSession{},
+	&baselineRefreshToken{},
+	&baselineRevokedToken{},
+	&baselineHealthRecord{},
+	&baselineSyncState{},
+	&baselineRecordTombstone{},
+	&baselineScanInput{},
+	&baselineRecordRevision{},
+	&baselineCorrection{},
+	&baselineMedication{},
+	&baselineRecordSearchIndex{},
+	&baselineRecordMetadataNumber{},
+	&baselineRecordLink{},
+	&baselineDoctorConversation{},
+	&baselineConversationSummary{},
+	&baselineReminder{},
+	&baselineDelivery{},
+	&baselineExportLink{},
+	&baselineExportLinkRecord{},
+	&baselineOrganization{},
+	&baselineOrgMembership{},
+	&baselineOrgInvite{},
+	&baselineOrgConsent{},
+	&baselineAuditLog{},
+	&baselineRecordAccessLog{},
+	&baselineChangeEvent{},
+	&baselineDataQualityReport{},
+	&baselineReprocessJob{},
+}
+
+type baselineUser struct {
+	ID           string `gorm:"primaryKey"`
+	Email        string `gorm:"uniqueIndex;size:254"`
+	Name         string
+	DateOfBirth  string
+	Gender       string
+	BloodType    string
+	PasswordHash string
+	OrgID        string `gorm:"index"`
+	Phone        string
+	OTPChannel   string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineOTPStore struct {
+	ID        string `gorm:"primaryKey"`
+	Email     string `gorm:"index"`
+	OTPHash   string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func (baselineOTPStore) TableName() string { return "otp_stores" }
+
+type baselineOTPIssuance struct {
+	Email string `gorm:"primaryKey"`
+	Day   string `gorm:"primaryKey"`
+	Count int
+}
+
+func (baselineOTPIssuance) TableName() string { return "otp_issuances" }
+
+type baselineOTPAttempts struct {
+	Email        string `gorm:"primaryKey"`
+	Failed       int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+func (baselineOTPAttempts) TableName() string { return "otp_attempts" }
+
+type baselineTOTPCredential struct {
+	UserID        string `gorm:"primaryKey"`
+	Secret        string
+	PendingSecret string
+	LastStep      int64
+	EnabledAt     *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (baselineTOTPCredential) TableName() string { return "totp_credentials" }
+
+type baselineSession struct {
+	ID           string `gorm:"primaryKey"`
+	UserID       string `gorm:"index"`
+	DeviceID     string
+	CreatedAt    time.Time
+	LastUsedAt   time.Time
+	RevokedAt    *time.Time
+	RevokeReason string
+}
+
+func (baselineSession) TableName() string { return "sessions" }
+
+type baselineRefreshToken struct {
+	ID        string `gorm:"primaryKey"`
+	SessionID string `gorm:"index"`
+	UserID    string `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex;size:191"`
+	ExpiresAt time.Time
+	RotatedAt *time.Time
+	CreatedAt time.Time
+}
+
+func (baselineRefreshToken) TableName() string { return "refresh_tokens" }
+
+type baselineRevokedToken struct {
+	TokenID   string    `gorm:"primaryKey"`
+	UserID    string    `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+	RevokedAt time.Time
+}
+
+func (baselineRevokedToken) TableName() string { return "revoked_tokens" }
+
+type baselineHealthRecord struct {
+	ID                 string `gorm:"primaryKey"`
+	UserID             string `gorm:"index;index:idx_record_user_sync,priority:1;index:idx_record_user_content,priority:1"`
+	RecordType         string
+	Title              string
+	Description        string
+	Metadata           string `gorm:"type:json"`
+	Sensitivity        string
+	ScanID             string `gorm:"index"`
+	ExtractionVersion  int
+	ExtractionProvider string
+	UserEditedAt       *time.Time
+	ContentHash        string `gorm:"size:64;index:idx_record_user_content,priority:2"`
+	SyncSeq            int64  `gorm:"index:idx_record_user_sync,priority:2"`
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	DeletedAt          gorm.DeletedAt `gorm:"index"`
+}
+
+func (baselineHealthRecord) TableName() string { return "health_records" }
+
+type baselineSyncState struct {
+	UserID    string `gorm:"primaryKey"`
+	Seq       int64
+	PurgedSeq int64
+}
+
+func (baselineSyncState) TableName() string { return "sync_states" }
+
+type baselineRecordTombstone struct {
+	RecordID  string    `gorm:"primaryKey"`
+	UserID    string    `gorm:"index:idx_tombstone_user_sync,priority:1"`
+	SyncSeq   int64     `gorm:"index:idx_tombstone_user_sync,priority:2"`
+	DeletedAt time.Time `gorm:"index"`
+}
+
+func (baselineRecordTombstone) TableName() string { return "record_tombstones" }
+
+type baselineScanInput struct {
+	ID                string `gorm:"primaryKey"`
+	UserID            string `gorm:"index"`
+	Image             []byte
+	ExtractionVersion int
+	Provider          string
+	CreatedAt         time.Time
+}
+
+func (baselineScanInput) TableName() string { return "scan_inputs" }
+
+type baselineRecordRevision struct {
+	ID                string `gorm:"primaryKey"`
+	RecordID          string `gorm:"index"`
+	Title             string
+	Description       string
+	Metadata          string `gorm:"type:json"`
+	ExtractionVersion int
+	ReplacedBy        string
+	CreatedAt         time.Time
+}
+
+func (baselineRecordRevision) TableName() string { return "record_revisions" }
+
+type baselineCorrection struct {
+	ID                string `gorm:"primaryKey"`
+	UserID            string `gorm:"index"`
+	RecordID          string `gorm:"index"`
+	ScanID            string
+	Field             string `gorm:"index:idx_correction_field_provider,priority:1"`
+	OriginalValue     string
+	CorrectedValue    string
+	Provider          string `gorm:"index:idx_correction_field_provider,priority:2"`
+	ExtractionVersion int
+	CreatedAt         time.Time `gorm:"index"`
+}
+
+func (baselineCorrection) TableName() string { return "corrections" }
+
+type baselineMedication struct {
+	ID             string `gorm:"primaryKey"`
+	UserID         string `gorm:"index"`
+	RecordID       string `gorm:"index"`
+	Name           string
+	Dosage         string
+	Frequency      string
+	TimesOfDay     string
+	EveryDays      int
+	AsNeeded       bool
+	Timezone       string
+	StartsAt       time.Time
+	EndsAt         *time.Time
+	ScheduledUntil time.Time `gorm:"index"`
+	CreatedAt      time.Time
+}
+
+func (baselineMedication) TableName() string { return "medications" }
+
+type baselineRecordSearchIndex struct {
+	RecordID        string `gorm:"primaryKey"`
+	UserID          string `gorm:"index"`
+	Terms           string
+	RecordUpdatedAt time.Time
+	IndexedAt       time.Time
+}
+
+func (baselineRecordSearchIndex) TableName() string { return "record_search_indices" }
+
+type baselineRecordMetadataNumber struct {
+	RecordID string  `gorm:"primaryKey"`
+	Key      string  `gorm:"primaryKey;column:metadata_key;index:idx_metadata_number,priority:2"`
+	UserID   string  `gorm:"index:idx_metadata_number,priority:1"`
+	Value    float64 `gorm:"index:idx_metadata_number,priority:3"`
+}
+
+func (baselineRecordMetadataNumber) TableName() string { return "record_metadata_numbers" }
+
+type baselineRecordLink struct {
+	ID           string `gorm:"primaryKey"`
+	UserID       string `gorm:"index"`
+	SourceID     string `gorm:"uniqueIndex:idx_record_link;index"`
+	TargetID     string `gorm:"uniqueIndex:idx_record_link;index"`
+	RelationType string `gorm:"uniqueIndex:idx_record_link;size:191"`
+	CreatedAt    time.Time
+}
+
+func (baselineRecordLink) TableName() string { return "record_links" }
+
+type baselineDoctorConversation struct {
+	ID             string `gorm:"primaryKey"`
+	UserID         string `gorm:"index"`
+	ConversationID string `gorm:"index"`
+	Message        string
+	Response       string
+	IsAI           bool
+	CreatedAt      time.Time
+}
+
+func (baselineDoctorConversation) TableName() string { return "doctor_conversations" }
+
+type baselineConversationSummary struct {
+	ConversationID    string `gorm:"primaryKey"`
+	UserID            string `gorm:"primaryKey"`
+	Summary           string
+	Turns             int
+	SummarizedThrough time.Time
+	Provider          string
+	UpdatedAt         time.Time
+}
+
+func (baselineConversationSummary) TableName() string { return "conversation_summaries" }
+
+type baselineReminder struct {
+	ID          string `gorm:"primaryKey"`
+	UserID      string `gorm:"index"`
+	RecordID    string `gorm:"index"`
+	Kind        string
+	Message     string
+	DueAt       time.Time `gorm:"index"`
+	SentAt      *time.Time
+	CancelledAt *time.Time
+	CreatedAt   time.Time
+}
+
+func (baselineReminder) TableName() string { return "reminders" }
+
+type baselineDelivery struct {
+	ID                string `gorm:"primaryKey"`
+	Channel           string
+	Recipient         string `gorm:"index"`
+	Subject           string
+	Body              string
+	Link              string
+	FallbackChannel   string
+	FallbackRecipient string
+	Status            string `gorm:"index"`
+	Attempts          int
+	MaxAttempts       int
+	NextAttemptAt     time.Time `gorm:"index"`
+	LastError         string
+	SentAt            *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func (baselineDelivery) TableName() string { return "deliveries" }
+
+type baselineExportLink struct {
+	ID                string `gorm:"primaryKey"`
+	UserID            string `gorm:"index"`
+	TokenHash         string `gorm:"uniqueIndex;size:191"`
+	PINHash           string
+	Snapshot          []byte
+	RecordCount       int
+	Redacted          bool
+	ExpiresAt         time.Time `gorm:"index"`
+	RevokedAt         *time.Time
+	AccessCount       int
+	LastAccessedAt    *time.Time
+	FailedPINAttempts int
+	LockedUntil       *time.Time
+	CreatedAt         time.Time
+}
+
+func (baselineExportLink) TableName() string { return "export_links" }
+
+type baselineExportLinkRecord struct {
+	LinkID   string `gorm:"primaryKey"`
+	RecordID string `gorm:"primaryKey;index"`
+}
+
+func (baselineExportLinkRecord) TableName() string { return "export_link_records" }
+
+type baselineOrganization struct {
+	ID        string `gorm:"primaryKey"`
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselineOrganization) TableName() string { return "organizations" }
+
+type baselineOrgMembership struct {
+	ID        string `gorm:"primaryKey"`
+	OrgID     string `gorm:"uniqueIndex:idx_org_member;size:191"`
+	UserID    string `gorm:"uniqueIndex:idx_org_member;index"`
+	Role      string
+	CreatedAt time.Time
+}
+
+func (baselineOrgMembership) TableName() string { return "org_memberships" }
+
+type baselineOrgInvite struct {
+	ID         string `gorm:"primaryKey"`
+	OrgID      string `gorm:"index"`
+	Email      string `gorm:"index"`
+	Role       string
+	Token      string `gorm:"uniqueIndex;size:191"`
+	InvitedBy  string
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (baselineOrgInvite) TableName() string { return "org_invites" }
+
+type baselineOrgConsent struct {
+	ID        string `gorm:"primaryKey"`
+	OrgID     string `gorm:"uniqueIndex:idx_org_patient;size:191"`
+	PatientID string `gorm:"uniqueIndex:idx_org_patient;index"`
+	GrantedAt time.Time
+	RevokedAt *time.Time
+}
+
+func (baselineOrgConsent) TableName() string { return "org_consents" }
+
+type baselineAuditLog struct {
+	ID         string `gorm:"primaryKey;index:idx_audit_created,priority:2"`
+	ActorID    string `gorm:"index:idx_audit_actor_created,priority:1"`
+	Action     string `gorm:"index:idx_audit_action_created,priority:1"`
+	TargetType string
+	TargetID   string
+	Details    string
+	CreatedAt  time.Time `gorm:"index:idx_audit_created,priority:1;index:idx_audit_actor_created,priority:2;index:idx_audit_action_created,priority:2"`
+}
+
+func (baselineAuditLog) TableName() string { return "audit_logs" }
+
+type baselineRecordAccessLog struct {
+	ID        string `gorm:"primaryKey"`
+	OwnerID   string `gorm:"index"`
+	RecordID  string `gorm:"index"`
+	ActorID   string
+	OrgID     string
+	Reason    string
+	CreatedAt time.Time
+}
+
+func (baselineRecordAccessLog) TableName() string { return "record_access_logs" }
+
+type baselineChangeEvent struct {
+	ID          string `gorm:"primaryKey;index:idx_change_user_created,priority:3"`
+	UserID      string `gorm:"index:idx_change_user_created,priority:1"`
+	ActorID     string
+	EntityType  string
+	EntityID    string
+	RecordID    string `gorm:"index"`
+	Kind        string
+	Summary     string
+	Sensitivity string
+	CreatedAt   time.Time `gorm:"index:idx_change_user_created,priority:2"`
+}
+
+func (baselineChangeEvent) TableName() string { return "change_events" }
+
+type baselineDataQualityReport struct {
+	ID          string `gorm:"primaryKey"`
+	Status      string
+	Fix         bool
+	Results     string `gorm:"type:json"`
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+func (baselineDataQualityReport) TableName() string { return "data_quality_reports" }
+
+type baselineReprocessJob struct {
+	ID              string `gorm:"primaryKey"`
+	Status          string `gorm:"index"`
+	RecordType      string
+	CreatedFrom     *time.Time
+	CreatedTo       *time.Time
+	BelowVersion    int
+	CursorCreatedAt time.Time
+	CursorID        string
+	Improved        int
+	Unchanged       int
+	Skipped         int
+	Failed          int
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	CompletedAt     *time.Time
+}
+
+func (baselineReprocessJob) TableName() string { return "reprocess_jobs" }
@@ -1,59 +1,151 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/clarity/backend/config"
-	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/tenancy"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 type Database interface {
 	GetConnection() *gorm.DB
+	// Tenants returns the tenant IDs of a multi-tenant deployment, or
+	// nothing when all data is in one database
+	Tenants() []string
+	// Migrate applies pending schema migrations
 	Migrate() error
+	// MigrationStatus reports the schema version of the database, or of
+	// each tenant's database
+	MigrationStatus() ([]MigrationStatus, error)
+	// Ping checks that the database, or every tenant's database, answers
+	Ping(ctx context.Context) error
 	Close() error
 }
 
 type SQLiteDB struct {
-	conn *gorm.DB
+	conn        *gorm.DB
+	tenants     *tenantPool // nil in single-database mode
+	autoMigrate bool
 }
 
 func NewDatabase(cfg *config.DatabaseConfig) (Database, error) {
 	switch cfg.Type {
 	case "sqlite":
 		return newSQLiteDB(cfg)
+	case "postgres":
+		return newPostgresDB(cfg)
+	case "mysql":
+		return newMySQLDB(cfg)
 	default:
 		return newSQLiteDB(cfg)
 	}
 }
 
 func newSQLiteDB(cfg *config.DatabaseConfig) (Database, error) {
-	db, err := gorm.Open(sqlite.Open(cfg.Path), &gorm.Config{})
+	if len(cfg.Tenants) > 0 {
+		return newTenantSQLiteDB(cfg)
+	}
+
+	db, err := gorm.Open(sqlite.Open(sqliteDSN(cfg)), &gorm.Config{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to SQLite: %w", err)
 	}
+	if err := configureConn(db, cfg); err != nil {
+		return nil, err
+	}
+	if err := registerStatementTimeout(db, time.Duration(cfg.StatementTimeout)*time.Millisecond); err != nil {
+		return nil, err
+	}
 
 	log.Printf("Connected to SQLite database at %s", cfg.Path)
 
-	return &SQLiteDB{conn: db}, nil
+	return &SQLiteDB{conn: db, autoMigrate: cfg.AutoMigrate}, nil
+}
+
+// newTenantSQLiteDB gives each configured tenant its own SQLite database.
+// The dialector checks the SQLite version on the connection it is given
+// before any statement has a tenant, so it is opened on the first tenant's
+// database and the routing pool swapped in afterwards.
+func newTenantSQLiteDB(cfg *config.DatabaseConfig) (Database, error) {
+	pool, err := newTenantPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+	first, err := pool.tenant(pool.ids[0])
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(sqlite.Dialector{Conn: first}, &gorm.Config{})
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to SQLite: %w", err)
+	}
+	db.ConnPool = pool
+	db.Statement.ConnPool = pool
+	if err := registerStatementTimeout(db, time.Duration(cfg.StatementTimeout)*time.Millisecond); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	log.Printf("Connected to SQLite databases of %d tenants", len(pool.ids))
+
+	return &SQLiteDB{conn: db, tenants: pool, autoMigrate: cfg.AutoMigrate}, nil
+}
+
+// sqliteDSN adds the busy timeout to the configured path. The driver
+// reads its own parameters from the query string of plain paths as well as
+// file: URIs.
+func sqliteDSN(cfg *config.DatabaseConfig) string {
+	if cfg.BusyTimeout <= 0 {
+		return cfg.Path
+	}
+	separator := "?"
+	if strings.Contains(cfg.Path, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%s_busy_timeout=%d", cfg.Path, separator, cfg.BusyTimeout)
 }
 
 func (s *SQLiteDB) GetConnection() *gorm.DB {
 	return s.conn
 }
 
+func (s *SQLiteDB) Tenants() []string {
+	if s.tenants == nil {
+		return nil
+	}
+	return s.tenants.Tenants()
+}
+
+// Migrate brings the schema up to date, in every tenant's database in
+// multi-tenant mode
 func (s *SQLiteDB) Migrate() error {
-	return s.conn.AutoMigrate(
-		&models.User{},
-		&models.OTPStore{},
-		&models.HealthRecord{},
-		&models.DoctorConversation{},
-	)
+	return tenancy.Each(context.Background(), s.Tenants(), func(ctx context.Context) error {
+		return migrate(s.conn.WithContext(ctx), s.autoMigrate)
+	})
+}
+
+func (s *SQLiteDB) MigrationStatus() ([]MigrationStatus, error) {
+	return eachMigrationStatus(s.conn, s.Tenants())
+}
+
+func (s *SQLiteDB) Ping(ctx context.Context) error {
+	if s.tenants != nil {
+		return s.tenants.Ping(ctx)
+	}
+	return pingConn(ctx, s.conn)
 }
 
 func (s *SQLiteDB) Close() error {
+	if s.tenants != nil {
+		return s.tenants.Close()
+	}
 	sqlDB, err := s.conn.DB()
 	if err != nil {
 		return err
@@ -0,0 +1,301 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/tenancy"
+	"gorm.io/gorm"
+)
+
+// migration is one step of the schema history. Migrate runs each step
+// once, in version order, inside a transaction that also records it in
+// schema_migrations. MySQL commits schema changes as it makes them, so
+// there a failed step may leave part of its work behind.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations is the schema history, oldest first. Append new steps with
+// the next version and never edit or reorder applied ones. A new database
+// is created from the current models and marked as having every step
+// applied, so a step only runs on databases that predate it; update the
+// models to match, and do not rely on a step running on a new database.
+// A step changes only what it owns, through frozen copies of the models or
+// by table and column name, never through the live models: a step that
+// created today's models would already hold the columns later steps add.
+var migrations = []migration{
+	{1, "drop plaintext sign-in codes", func(tx *gorm.DB) error {
+		// Pending sign-in codes used to be stored in plaintext
+		if tx.Migrator().HasTable(&models.OTPStore{}) && tx.Migrator().HasColumn(&models.OTPStore{}, "otp") {
+			return tx.Migrator().DropColumn(&models.OTPStore{}, "otp")
+		}
+		return nil
+	}},
+	{2, "baseline schema", func(tx *gorm.DB) error {
+		// Databases from before versioned migrations were kept up to date
+		// by AutoMigrate alone
+		return tx.AutoMigrate(baselineTables...)
+	}},
+	{3, "clear sent sign-in codes", func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&sensitiveDelivery{}, "Sensitive"); err != nil {
+			return err
+		}
+		// Sign-in codes already queued are sensitive too, and the ones
+		// already sent or given up on need not be kept
+		if err := tx.Model(&sensitiveDelivery{}).Where("subject = ?", otpSubject).Update("sensitive", true).Error; err != nil {
+			return err
+		}
+		return tx.Model(&sensitiveDelivery{}).Where("subject = ? AND status <> ?", otpSubject, "pending").Update("body", "").Error
+	}},
+	{4, "keep deleted records' medications and links", func(tx *gorm.DB) error {
+		// Both used to be removed outright when their record was deleted,
+		// so there are none in the trash to mark
+		for _, table := range []interface{}{&trashedMedication{}, &trashedRecordLink{}} {
+			if err := tx.Migrator().AddColumn(table, "DeletedAt"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().CreateIndex(table, "DeletedAt"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{5, "hash org invite tokens", func(tx *gorm.DB) error {
+		// Invite tokens used to be stored as sent; the column keeps its
+		// values, each replaced by its hash
+		if err := tx.Migrator().DropIndex(&hashedInvite{}, "idx_org_invites_token"); err != nil {
+			return err
+		}
+		if err := tx.Migrator().RenameColumn(&hashedInvite{}, "token", "token_hash"); err != nil {
+			return err
+		}
+		var invites []hashedInvite
+		if err := tx.Find(&invites).Error; err != nil {
+			return err
+		}
+		for _, invite := range invites {
+			if err := tx.Model(&hashedInvite{}).Where("id = ?", invite.ID).
+				Update("token_hash", hashInviteToken(invite.TokenHash)).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().CreateIndex(&hashedInvite{}, "TokenHash")
+	}},
+}
+
+// otpSubject is the subject sign-in code messages were queued with at
+// migration 3
+const otpSubject = "Your Clarity sign-in code"
+
+// sensitiveDelivery is the deliveries column migration 3 adds
+type sensitiveDelivery struct {
+	Sensitive bool
+	Body      string
+}
+
+func (sensitiveDelivery) TableName() string { return "deliveries" }
+
+// trashedMedication and trashedRecordLink are the columns migration 4 adds
+type trashedMedication struct {
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (trashedMedication) TableName() string { return "medications" }
+
+type trashedRecordLink struct {
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (trashedRecordLink) TableName() string { return "record_links" }
+
+// hashedInvite is the org_invites column migration 5 rewrites
+type hashedInvite struct {
+	ID        string `gorm:"primaryKey"`
+	TokenHash string `gorm:"uniqueIndex;size:191"`
+}
+
+func (hashedInvite) TableName() string { return "org_invites" }
+
+// hashInviteToken is the invite token hash at migration 5: hex-encoded
+// SHA-256
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tables are the models a new database is created with
+var tables = []interface{}{
+	&models.User{},
+	&models.OTPStore{},
+	&models.OTPIssuance{},
+	&models.OTPAttempts{},
+	&models.TOTPCredential{},
+	&models.Session{},
+	&models.RefreshToken{},
+	&models.RevokedToken{},
+	&models.HealthRecord{},
+	&models.SyncState{},
+	&models.RecordTombstone{},
+	&models.ScanInput{},
+	&models.RecordRevision{},
+	&models.Correction{},
+	&models.Medication{},
+	&models.RecordSearchIndex{},
+	&models.RecordMetadataNumber{},
+	&models.RecordLink{},
+	&models.DoctorConversation{},
+	&models.ConversationSummary{},
+	&models.Reminder{},
+	&models.Delivery{},
+	&models.ExportLink{},
+	&models.ExportLinkRecord{},
+	&models.Organization{},
+	&models.OrgMembership{},
+	&models.OrgInvite{},
+	&models.OrgConsent{},
+	&models.AuditLog{},
+	&models.RecordAccessLog{},
+	&models.ChangeEvent{},
+	&models.DataQualityReport{},
+	&models.ReprocessJob{},
+}
+
+// MigrationStatus is the schema version of one database
+type MigrationStatus struct {
+	Tenant  string // empty outside multi-tenant mode
+	Version int    // latest applied migration, 0 before any
+	Latest  int    // latest migration this build has
+	Pending []string
+}
+
+// migrate applies the pending migrations to db. With autoMigrate set,
+// AutoMigrate runs afterwards as well, so model changes show up during
+// development before their migration is written.
+func migrate(db *gorm.DB, autoMigrate bool) error {
+	if err := db.AutoMigrate(&models.SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 && isNewDatabase(db) {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(tables...); err != nil {
+				return err
+			}
+			for _, m := range migrations {
+				if err := recordMigration(tx, m); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+		log.Printf("Created database schema at version %d", latestMigration())
+	} else {
+		for _, m := range migrations {
+			if applied[m.version] {
+				continue
+			}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := m.up(tx); err != nil {
+					return err
+				}
+				return recordMigration(tx, m)
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+			}
+			log.Printf("Applied database migration %d: %s", m.version, m.name)
+		}
+	}
+
+	if autoMigrate {
+		return db.AutoMigrate(tables...)
+	}
+	return nil
+}
+
+// isNewDatabase reports whether db has none of the app's tables
+func isNewDatabase(db *gorm.DB) bool {
+	for _, table := range tables {
+		if db.Migrator().HasTable(table) {
+			return false
+		}
+	}
+	return true
+}
+
+// migrationStatus reports which migrations db has applied
+func migrationStatus(db *gorm.DB) (MigrationStatus, error) {
+	status := MigrationStatus{Latest: latestMigration()}
+	if !db.Migrator().HasTable(&models.SchemaMigration{}) {
+		for _, m := range migrations {
+			status.Pending = append(status.Pending, m.name)
+		}
+		return status, nil
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return status, err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			status.Version = max(status.Version, m.version)
+		} else {
+			status.Pending = append(status.Pending, m.name)
+		}
+	}
+	return status, nil
+}
+
+// eachMigrationStatus reports the status of db, or of every tenant's
+// database
+func eachMigrationStatus(db *gorm.DB, tenants []string) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := tenancy.Each(context.Background(), tenants, func(ctx context.Context) error {
+		status, err := migrationStatus(db.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		status.Tenant, _ = tenancy.FromContext(ctx)
+		statuses = append(statuses, status)
+		return nil
+	})
+	return statuses, err
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations
+func appliedMigrations(db *gorm.DB) (map[int]bool, error) {
+	var versions []int
+	if err := db.Model(&models.SchemaMigration{}).Pluck("version", &versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	applied := make(map[int]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// recordMigration marks m applied. Two servers migrating at once both try
+// to record it, and the second fails on the primary key.
+func recordMigration(tx *gorm.DB, m migration) error {
+	return tx.Create(&models.SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+}
+
+func latestMigration() int {
+	return migrations[len(migrations)-1].version
+}
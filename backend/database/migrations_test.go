@@ -0,0 +1,352 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// plaintextOTPStore is OTPStore as it was before codes were hashed
+type plaintextOTPStore struct {
+	ID        string `gorm:"primaryKey"`
+	Email     string `gorm:"index"`
+	OTP       string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func (plaintextOTPStore) TableName() string { return "otp_stores" }
+
+func TestMigrateDropsPlaintextSignInCodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clarity.db")
+
+	// A database from when sign-in codes were stored as they were sent
+	old, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := old.AutoMigrate(&plaintextOTPStore{}); err != nil {
+		t.Fatalf("create the old table: %v", err)
+	}
+	if err := old.Create(&plaintextOTPStore{ID: "otp-1", Email: "reader@example.com", OTP: "123456"}).Error; err != nil {
+		t.Fatalf("store a plaintext code: %v", err)
+	}
+	conn, _ := old.DB()
+	conn.Close()
+
+	db, err := NewDatabase(&config.DatabaseConfig{Type: "sqlite", Path: path})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	migrator := db.GetConnection().Migrator()
+	if migrator.HasColumn(&models.OTPStore{}, "otp") {
+		t.Error("the plaintext otp column survived the migration")
+	}
+	if !migrator.HasColumn(&models.OTPStore{}, "otp_hash") {
+		t.Error("otp_stores has no otp_hash column after the migration")
+	}
+	// The pending code is kept, but can no longer be used or read
+	var pending models.OTPStore
+	if err := db.GetConnection().First(&pending, "id = ?", "otp-1").Error; err != nil || pending.OTPHash != "" {
+		t.Errorf("pending code after the migration = %+v, %v", pending, err)
+	}
+}
+
+// newMigrationTestDB opens an empty SQLite database file
+func newMigrationTestDB(t *testing.T, autoMigrate bool) Database {
+	t.Helper()
+	db, err := NewDatabase(&config.DatabaseConfig{Type: "sqlite", Path: filepath.Join(t.TempDir(), "clarity.db"), AutoMigrate: autoMigrate})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// schemaStatus returns the migration status of a single database
+func schemaStatus(t *testing.T, db Database) MigrationStatus {
+	t.Helper()
+	statuses, err := db.MigrationStatus()
+	if err != nil || len(statuses) != 1 {
+		t.Fatalf("MigrationStatus = %+v, %v; want one status", statuses, err)
+	}
+	return statuses[0]
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := newMigrationTestDB(t, false)
+
+	before := schemaStatus(t, db)
+	if before.Version != 0 || len(before.Pending) != len(migrations) || before.Latest != latestMigration() {
+		t.Errorf("status before migrating = %+v, want version 0 with every migration pending", before)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("Migrate %d: %v", i+1, err)
+		}
+	}
+	after := schemaStatus(t, db)
+	if after.Version != latestMigration() || len(after.Pending) != 0 {
+		t.Errorf("status after migrating = %+v, want version %d with none pending", after, latestMigration())
+	}
+
+	conn := db.GetConnection()
+	for _, table := range tables {
+		if !conn.Migrator().HasTable(table) {
+			t.Errorf("no table for %T", table)
+		}
+	}
+	// Each step is recorded once, though Migrate ran twice
+	var applied []models.SchemaMigration
+	conn.Order("version").Find(&applied)
+	if len(applied) != len(migrations) {
+		t.Fatalf("schema_migrations = %+v, want %d rows", applied, len(migrations))
+	}
+	for i, m := range migrations {
+		if applied[i].Version != m.version || applied[i].Name != m.name || applied[i].AppliedAt.IsZero() {
+			t.Errorf("schema_migrations row %d = %+v, want %d %q", i, applied[i], m.version, m.name)
+		}
+	}
+}
+
+// newPreVersioningDB creates a database file as AutoMigrate kept it before
+// versioned migrations, with no steps recorded, and returns its path
+func newPreVersioningDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clarity.db")
+	old, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := old.AutoMigrate(baselineTables...); err != nil {
+		t.Fatalf("create the old schema: %v", err)
+	}
+	conn, _ := old.DB()
+	conn.Close()
+	return path
+}
+
+// schemaColumns lists the columns of every table of the models, sorted
+func schemaColumns(t *testing.T, db Database) map[string][]string {
+	t.Helper()
+	migrator := db.GetConnection().Migrator()
+	columns := make(map[string][]string, len(tables))
+	for _, table := range tables {
+		types, err := migrator.ColumnTypes(table)
+		if err != nil {
+			t.Fatalf("columns of %T: %v", table, err)
+		}
+		name := fmt.Sprintf("%T", table)
+		for _, column := range types {
+			columns[name] = append(columns[name], column.Name())
+		}
+		slices.Sort(columns[name])
+	}
+	return columns
+}
+
+func TestMigrateDatabaseOneVersionBehind(t *testing.T) {
+	db, err := NewDatabase(&config.DatabaseConfig{Type: "sqlite", Path: newPreVersioningDB(t)})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+
+	// Bring the database up to the step before the latest
+	saved := migrations
+	latest := saved[len(saved)-1]
+	migrations = saved[:len(saved)-1]
+	err = db.Migrate()
+	migrations = saved
+	if err != nil {
+		t.Fatalf("Migrate to the step before the latest: %v", err)
+	}
+	behind := schemaStatus(t, db)
+	if behind.Version != latest.version-1 || len(behind.Pending) != 1 || behind.Pending[0] != latest.name {
+		t.Fatalf("status one version behind = %+v, want version %d with %q pending", behind, latest.version-1, latest.name)
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if status := schemaStatus(t, db); status.Version != latest.version || len(status.Pending) != 0 {
+		t.Errorf("status after catching up = %+v", status)
+	}
+}
+
+func TestMigratedSchemaMatchesNewDatabase(t *testing.T) {
+	// Every step run in turn on the oldest database ends where a new
+	// database starts, so no step assumes the live models and no model
+	// change lacks its step
+	migrated, err := NewDatabase(&config.DatabaseConfig{Type: "sqlite", Path: newPreVersioningDB(t)})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer migrated.Close()
+	if err := migrated.Migrate(); err != nil {
+		t.Fatalf("Migrate the old database: %v", err)
+	}
+	fresh := newMigrationTestDB(t, false)
+	if err := fresh.Migrate(); err != nil {
+		t.Fatalf("Migrate a new database: %v", err)
+	}
+
+	want := schemaColumns(t, fresh)
+	for table, columns := range schemaColumns(t, migrated) {
+		if !slices.Equal(columns, want[table]) {
+			t.Errorf("%s migrated has columns %v, a new database %v", table, columns, want[table])
+		}
+	}
+}
+
+func TestMigrateClearsSentSignInCodes(t *testing.T) {
+	path := newPreVersioningDB(t)
+	old, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, d := range []baselineDelivery{
+		{ID: "sent", Subject: otpSubject, Body: "Your Clarity sign-in code is 123456.", Status: "sent"},
+		{ID: "pending", Subject: otpSubject, Body: "Your Clarity sign-in code is 654321.", Status: "pending"},
+		{ID: "other", Subject: "Security alert", Body: "We signed you out.", Status: "sent"},
+	} {
+		if err := old.Create(&d).Error; err != nil {
+			t.Fatalf("queue %s: %v", d.ID, err)
+		}
+	}
+	conn, _ := old.DB()
+	conn.Close()
+
+	db, err := NewDatabase(&config.DatabaseConfig{Type: "sqlite", Path: path})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	deliveries := map[string]models.Delivery{}
+	var rows []models.Delivery
+	db.GetConnection().Find(&rows)
+	for _, d := range rows {
+		deliveries[d.ID] = d
+	}
+	if d := deliveries["sent"]; !d.Sensitive || d.Body != "" {
+		t.Errorf("sent code after the migration = %+v, want sensitive and cleared", d)
+	}
+	// A code still to be delivered keeps its body until it is sent
+	if d := deliveries["pending"]; !d.Sensitive || d.Body == "" {
+		t.Errorf("pending code after the migration = %+v, want sensitive with its body", d)
+	}
+	if d := deliveries["other"]; d.Sensitive || d.Body == "" {
+		t.Errorf("other message after the migration = %+v, want it untouched", d)
+	}
+}
+
+func TestMigrateHashesInviteTokens(t *testing.T) {
+	path := newPreVersioningDB(t)
+	old, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, invite := range []baselineOrgInvite{{ID: "a", Token: "token-a"}, {ID: "b", Token: "token-b"}} {
+		if err := old.Create(&invite).Error; err != nil {
+			t.Fatalf("invite %s: %v", invite.ID, err)
+		}
+	}
+	conn, _ := old.DB()
+	conn.Close()
+
+	db, err := NewDatabase(&config.DatabaseConfig{Type: "sqlite", Path: path})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	var invites []models.OrgInvite
+	db.GetConnection().Order("id").Find(&invites)
+	for _, invite := range invites {
+		sum := sha256.Sum256([]byte("token-" + invite.ID))
+		if invite.TokenHash != hex.EncodeToString(sum[:]) {
+			t.Errorf("invite %s token hash = %q, want the SHA-256 of its old token", invite.ID, invite.TokenHash)
+		}
+	}
+	if len(invites) != 2 {
+		t.Errorf("%d invites after the migration, want 2", len(invites))
+	}
+	if !db.GetConnection().Migrator().HasIndex(&models.OrgInvite{}, "TokenHash") {
+		t.Error("token hashes are not indexed")
+	}
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	db := newMigrationTestDB(t, false)
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	type halfDone struct{ ID string }
+	broken := migration{latestMigration() + 1, "broken step", func(tx *gorm.DB) error {
+		if err := tx.Migrator().CreateTable(&halfDone{}); err != nil {
+			return err
+		}
+		return errors.New("backfill failed")
+	}}
+	saved := migrations
+	migrations = append(append([]migration(nil), saved...), broken)
+	t.Cleanup(func() { migrations = saved })
+
+	err := db.Migrate()
+	if err == nil || !strings.Contains(err.Error(), "broken step") {
+		t.Fatalf("Migrate = %v, want the failing step named", err)
+	}
+	if db.GetConnection().Migrator().HasTable(&halfDone{}) {
+		t.Error("the failed step's table was kept")
+	}
+	status := schemaStatus(t, db)
+	if status.Version != broken.version-1 || len(status.Pending) != 1 || status.Pending[0] != broken.name {
+		t.Errorf("status after the failure = %+v, want the step still pending", status)
+	}
+}
+
+func TestAutoMigrateOnlyWhenEnabled(t *testing.T) {
+	for _, autoMigrate := range []bool{false, true} {
+		db := newMigrationTestDB(t, autoMigrate)
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+		// A model change without a migration
+		conn := db.GetConnection()
+		if err := conn.Migrator().DropColumn(&models.HealthRecord{}, "content_hash"); err != nil {
+			t.Fatalf("drop column: %v", err)
+		}
+
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+		if got := conn.Migrator().HasColumn(&models.HealthRecord{}, "content_hash"); got != autoMigrate {
+			t.Errorf("DB_AUTO_MIGRATE=%v: column restored = %v", autoMigrate, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+	gomysql "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// mysqlTLSModes maps the libpq sslmode values DB_SSLMODE takes to the
+// driver's tls setting. MySQL has no CA-only check, so verify-ca verifies
+// the hostname as well.
+var mysqlTLSModes = map[string]string{
+	"disable":     "false",
+	"allow":       "preferred",
+	"prefer":      "preferred",
+	"require":     "skip-verify",
+	"verify-ca":   "true",
+	"verify-full": "true",
+}
+
+type MySQLDB struct {
+	conn        *gorm.DB
+	autoMigrate bool
+}
+
+// newMySQLDB connects to the configured MySQL server
+func newMySQLDB(cfg *config.DatabaseConfig) (Database, error) {
+	if len(cfg.Tenants) > 0 {
+		return nil, errors.New("per-tenant databases are only supported on SQLite")
+	}
+	if err := checkServerConfig("MySQL", cfg); err != nil {
+		return nil, err
+	}
+	if _, ok := mysqlTLSModes[cfg.SSLMode]; !ok {
+		return nil, fmt.Errorf("DB_SSLMODE %q is not one of %s", cfg.SSLMode, strings.Join(postgresSSLModes, ", "))
+	}
+
+	db, err := openWithRetry("MySQL", mysql.Open(mysqlDSN(cfg)), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := configureConn(db, cfg); err != nil {
+		return nil, err
+	}
+	if err := registerStatementTimeout(db, time.Duration(cfg.StatementTimeout)*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Connected to MySQL database %s at %s:%s", cfg.DbName, cfg.Host, cfg.Port)
+
+	return &MySQLDB{conn: db, autoMigrate: cfg.AutoMigrate}, nil
+}
+
+// mysqlDSN builds the driver's connection string from the configured
+// fields. Timestamps are parsed into time.Time in UTC so they round-trip,
+// and utf8mb4 stores any text a user can type.
+func mysqlDSN(cfg *config.DatabaseConfig) string {
+	dsn := gomysql.NewConfig()
+	dsn.User = cfg.User
+	dsn.Passwd = cfg.Password
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(cfg.Host, cfg.Port)
+	dsn.DBName = cfg.DbName
+	dsn.ParseTime = true
+	dsn.Loc = time.UTC
+	dsn.TLSConfig = mysqlTLSModes[cfg.SSLMode]
+	dsn.Params = map[string]string{"charset": "utf8mb4"}
+	return dsn.FormatDSN()
+}
+
+func (m *MySQLDB) GetConnection() *gorm.DB {
+	return m.conn
+}
+
+func (m *MySQLDB) Tenants() []string {
+	return nil
+}
+
+func (m *MySQLDB) Migrate() error {
+	return migrate(m.conn, m.autoMigrate)
+}
+
+func (m *MySQLDB) MigrationStatus() ([]MigrationStatus, error) {
+	return eachMigrationStatus(m.conn, nil)
+}
+
+func (m *MySQLDB) Ping(ctx context.Context) error {
+	return pingConn(ctx, m.conn)
+}
+
+func (m *MySQLDB) Close() error {
+	sqlDB, err := m.conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
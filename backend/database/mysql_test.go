@@ -0,0 +1,188 @@
+package database
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	gomysql "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm/schema"
+)
+
+// mysqlConfig is a complete MySQL configuration for tests to vary
+func mysqlConfig() config.DatabaseConfig {
+	return config.DatabaseConfig{
+		Type:     "mysql",
+		Host:     "db.internal",
+		Port:     "3306",
+		User:     "clarity",
+		Password: "secret",
+		DbName:   "clarity",
+		SSLMode:  "require",
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	cfg := mysqlConfig()
+	cfg.Password = "p@ss:w/rd?&"
+	parsed, err := gomysql.ParseDSN(mysqlDSN(&cfg))
+	if err != nil {
+		t.Fatalf("the driver cannot parse %q: %v", mysqlDSN(&cfg), err)
+	}
+	if parsed.User != "clarity" || parsed.Passwd != cfg.Password {
+		t.Errorf("credentials = %q, %q; want clarity and the password as set", parsed.User, parsed.Passwd)
+	}
+	if parsed.Net != "tcp" || parsed.Addr != "db.internal:3306" || parsed.DBName != "clarity" {
+		t.Errorf("address = %s(%s)/%s", parsed.Net, parsed.Addr, parsed.DBName)
+	}
+	// Timestamps come back as time.Time, in UTC, and text as utf8mb4
+	if !parsed.ParseTime || parsed.Loc != time.UTC {
+		t.Errorf("parseTime = %v, loc = %v; want true and UTC", parsed.ParseTime, parsed.Loc)
+	}
+	if parsed.Params["charset"] != "utf8mb4" {
+		t.Errorf("charset = %q, want utf8mb4", parsed.Params["charset"])
+	}
+
+	for sslMode, tls := range mysqlTLSModes {
+		cfg.SSLMode = sslMode
+		parsed, err := gomysql.ParseDSN(mysqlDSN(&cfg))
+		if err != nil || parsed.TLSConfig != tls {
+			t.Errorf("DB_SSLMODE=%s: tls = %q, %v; want %q", sslMode, parsed.TLSConfig, err, tls)
+		}
+	}
+
+	cfg.Host = "::1"
+	if parsed, _ := gomysql.ParseDSN(mysqlDSN(&cfg)); parsed == nil || parsed.Addr != "[::1]:3306" {
+		t.Errorf("IPv6 host address = %+v, want [::1]:3306", parsed)
+	}
+}
+
+func TestMySQLConfiguration(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*config.DatabaseConfig)
+		want string
+	}{
+		{"tenants", func(cfg *config.DatabaseConfig) { cfg.Tenants = []string{"acme=acme.db"} }, "only supported on SQLite"},
+		{"nothing set", func(cfg *config.DatabaseConfig) { *cfg = config.DatabaseConfig{Type: "mysql"} }, "MySQL is not configured, set DB_HOST, DB_PORT, DB_USER, DB_NAME"},
+		{"no database name", func(cfg *config.DatabaseConfig) { cfg.DbName = "" }, "DB_NAME"},
+		{"port not a number", func(cfg *config.DatabaseConfig) { cfg.Port = "mysql" }, `DB_PORT "mysql"`},
+		{"unknown SSL mode", func(cfg *config.DatabaseConfig) { cfg.SSLMode = "on" }, `DB_SSLMODE "on"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mysqlConfig()
+			tt.edit(&cfg)
+			_, err := NewDatabase(&cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewDatabase = %v, want an error naming %s", err, tt.want)
+			}
+		})
+	}
+}
+
+// MySQL stores a string without a size as longtext, which it cannot index.
+// The driver sizes strings that are keys or carry an index or unique tag;
+// every other indexed string needs an explicit size.
+func TestIndexedStringsFitMySQL(t *testing.T) {
+	cache := &sync.Map{}
+	for _, table := range tables {
+		s, err := schema.Parse(table, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatalf("parse %T: %v", table, err)
+		}
+		for _, field := range s.Fields {
+			if field.DataType != schema.String || field.TagSettings["UNIQUEINDEX"] == "" {
+				continue
+			}
+			sizedByDriver := field.PrimaryKey || field.HasDefaultValue || field.TagSettings["INDEX"] != "" || field.TagSettings["UNIQUE"] != ""
+			if field.Size == 0 && !sizedByDriver {
+				t.Errorf("%s.%s has a unique index but no size", s.Table, field.DBName)
+			}
+		}
+	}
+}
+
+// liveMySQLConfig configures the MySQL server named by TEST_MYSQL_HOST and
+// optionally TEST_MYSQL_PORT, _USER, _PASSWORD and _DB, skipping the test
+// when there is none
+func liveMySQLConfig(t *testing.T) *config.DatabaseConfig {
+	t.Helper()
+	host := os.Getenv("TEST_MYSQL_HOST")
+	if host == "" {
+		t.Skip("TEST_MYSQL_HOST not set")
+	}
+	env := func(key, fallback string) string {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+		return fallback
+	}
+	return &config.DatabaseConfig{
+		Type:     "mysql",
+		Host:     host,
+		Port:     env("TEST_MYSQL_PORT", "3306"),
+		User:     env("TEST_MYSQL_USER", "root"),
+		Password: os.Getenv("TEST_MYSQL_PASSWORD"),
+		DbName:   env("TEST_MYSQL_DB", "clarity_test"),
+		SSLMode:  "disable",
+	}
+}
+
+func TestMySQLMigrateAndRoundTrip(t *testing.T) {
+	cfg := liveMySQLConfig(t)
+	cfg.AutoMigrate = true
+	db, err := NewDatabase(cfg)
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+	if _, ok := db.(*MySQLDB); !ok {
+		t.Fatalf("NewDatabase with DB_TYPE=mysql returned a %T", db)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+
+	conn := db.GetConnection()
+	columns, err := conn.Migrator().ColumnTypes(&models.HealthRecord{})
+	if err != nil {
+		t.Fatalf("ColumnTypes: %v", err)
+	}
+	for _, column := range columns {
+		if column.Name() == "metadata" && !strings.EqualFold(column.DatabaseTypeName(), "json") {
+			t.Errorf("health_records.metadata is %s, want JSON", column.DatabaseTypeName())
+		}
+	}
+
+	// IDs of their own, as the database may be shared
+	id := "mysql-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	created := time.Date(2026, 3, 10, 8, 30, 15, 0, time.UTC)
+	user := models.User{ID: id, Email: id + "@example.com"}
+	record := models.HealthRecord{ID: id + "-rec", UserID: id, RecordType: "lab_result", Title: "Résultats 🩺", Metadata: `{"glucose":"90"}`, CreatedAt: created, UpdatedAt: created}
+	t.Cleanup(func() {
+		conn.Unscoped().Delete(&models.HealthRecord{}, "id = ?", record.ID)
+		conn.Delete(&models.User{}, "id = ?", user.ID)
+	})
+	if err := conn.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := conn.Create(&record).Error; err != nil {
+		t.Fatalf("create record: %v", err)
+	}
+	var stored models.HealthRecord
+	if err := conn.First(&stored, "id = ?", record.ID).Error; err != nil {
+		t.Fatalf("read record: %v", err)
+	}
+	if stored.Title != record.Title || !stored.CreatedAt.Equal(created) || !strings.Contains(stored.Metadata, "glucose") {
+		t.Errorf("stored record = %q created %v with %s; want %q created %v", stored.Title, stored.CreatedAt, stored.Metadata, record.Title, created)
+	}
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"gorm.io/gorm"
+)
+
+// configurePool applies the configured connection limits to a database
+// handle. Zero settings keep the database/sql defaults: unlimited open
+// connections, two idle ones, and no lifetime limits. A negative
+// MaxIdleConns keeps none idle.
+func configurePool(db *sql.DB, cfg *config.DatabaseConfig) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	// An in-memory SQLite database lasts only while a connection is open
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Second)
+	db.SetConnMaxIdleTime(time.Duration(cfg.ConnMaxIdleTime) * time.Second)
+}
+
+// configureConn applies the connection limits to the pool behind conn
+func configureConn(conn *gorm.DB, cfg *config.DatabaseConfig) error {
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	configurePool(sqlDB, cfg)
+	return nil
+}
+
+// pingConn checks that the database behind conn answers
+func pingConn(ctx context.Context, conn *gorm.DB) error {
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// newPoolTestDB opens a SQLite database file with the given pool settings
+// and returns the sql.DB behind it
+func newPoolTestDB(t *testing.T, cfg config.DatabaseConfig) (Database, *sql.DB) {
+	t.Helper()
+	cfg.Type = "sqlite"
+	cfg.Path = filepath.Join(t.TempDir(), "clarity.db")
+	db, err := NewDatabase(&cfg)
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlDB, err := db.GetConnection().DB()
+	if err != nil {
+		t.Fatalf("DB: %v", err)
+	}
+	return db, sqlDB
+}
+
+// holdConns takes n connections from the pool and returns a function
+// giving them back
+func holdConns(t *testing.T, db *sql.DB, n int) func() {
+	t.Helper()
+	conns := make([]*sql.Conn, n)
+	for i := range conns {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("connection %d: %v", i+1, err)
+		}
+		conns[i] = conn
+	}
+	return func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func TestPoolSettingsAreApplied(t *testing.T) {
+	_, sqlDB := newPoolTestDB(t, config.DatabaseConfig{MaxOpenConns: 2, MaxIdleConns: 1, ConnMaxLifetime: 1, ConnMaxIdleTime: 1})
+
+	if got := sqlDB.Stats().MaxOpenConnections; got != 2 {
+		t.Errorf("MaxOpenConnections = %d, want 2", got)
+	}
+
+	// A third connection waits for one of the two to be given back
+	release := holdConns(t, sqlDB, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err := sqlDB.Conn(ctx)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("third connection: %v, want it to wait past the deadline", err)
+	}
+	if stats := sqlDB.Stats(); stats.WaitCount == 0 {
+		t.Error("no wait recorded with the pool at its limit")
+	}
+
+	// Of the two given back, only one is kept idle
+	release()
+	if stats := sqlDB.Stats(); stats.Idle != 1 || stats.MaxIdleClosed != 1 {
+		t.Errorf("idle = %d, closed for the idle limit = %d; want 1 and 1", stats.Idle, stats.MaxIdleClosed)
+	}
+
+	// and it is closed once older than the lifetime or idle time
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		sqlDB.Ping()
+		stats := sqlDB.Stats()
+		if stats.MaxLifetimeClosed+stats.MaxIdleTimeClosed > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no connection closed for its age: %+v", stats)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestPoolDefaults(t *testing.T) {
+	_, sqlDB := newPoolTestDB(t, config.DatabaseConfig{})
+
+	if got := sqlDB.Stats().MaxOpenConnections; got != 0 {
+		t.Errorf("MaxOpenConnections = %d, want unlimited", got)
+	}
+	// database/sql keeps two idle connections by default
+	holdConns(t, sqlDB, 3)()
+	if stats := sqlDB.Stats(); stats.Idle != 2 || stats.MaxIdleClosed != 1 {
+		t.Errorf("idle = %d, closed for the idle limit = %d; want 2 and 1", stats.Idle, stats.MaxIdleClosed)
+	}
+}
+
+func TestNoIdleConnections(t *testing.T) {
+	_, sqlDB := newPoolTestDB(t, config.DatabaseConfig{MaxIdleConns: -1})
+
+	// The connection opened to connect was already let go
+	before := sqlDB.Stats().MaxIdleClosed
+	holdConns(t, sqlDB, 2)()
+	if stats := sqlDB.Stats(); stats.Idle != 0 || stats.MaxIdleClosed-before != 2 {
+		t.Errorf("idle = %d, closed for the idle limit = %d; want 0 and 2", stats.Idle, stats.MaxIdleClosed-before)
+	}
+}
+
+func TestTenantDatabasesUsePoolSettings(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(&config.DatabaseConfig{
+		Type:         "sqlite",
+		Tenants:      []string{"acme=" + filepath.Join(dir, "acme.db"), "globex=" + filepath.Join(dir, "globex.db")},
+		MaxOpenConns: 3,
+	})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+
+	pool := db.(*SQLiteDB).tenants
+	for _, id := range pool.Tenants() {
+		tenantDB, err := pool.tenant(id)
+		if err != nil {
+			t.Fatalf("tenant %s: %v", id, err)
+		}
+		if got := tenantDB.Stats().MaxOpenConnections; got != 3 {
+			t.Errorf("tenant %s: MaxOpenConnections = %d, want 3", id, got)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	db, _ := newPoolTestDB(t, config.DatabaseConfig{})
+	if err := db.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	db.Close()
+	if err := db.Ping(context.Background()); err == nil {
+		t.Error("Ping of a closed database succeeded")
+	}
+}
+
+func TestPingChecksEveryTenant(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(&config.DatabaseConfig{
+		Type: "sqlite",
+		// The database of globex is in a directory that does not exist, so
+		// it is not found until something connects to it
+		Tenants: []string{"acme=" + filepath.Join(dir, "acme.db"), "globex=" + filepath.Join(dir, "missing", "globex.db")},
+	})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+
+	err = db.Ping(context.Background())
+	if err == nil {
+		t.Fatal("Ping succeeded with a tenant database that cannot be opened")
+	}
+	if want := "database of tenant globex"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Ping error %q does not name the tenant", err)
+	}
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// postgresSSLModes are the sslmode values libpq accepts
+var postgresSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+type PostgresDB struct {
+	conn        *gorm.DB
+	autoMigrate bool
+}
+
+// newPostgresDB connects to the configured PostgreSQL server
+func newPostgresDB(cfg *config.DatabaseConfig) (Database, error) {
+	if len(cfg.Tenants) > 0 {
+		return nil, errors.New("per-tenant databases are only supported on SQLite")
+	}
+	if err := checkServerConfig("PostgreSQL", cfg); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(postgresSSLModes, cfg.SSLMode) {
+		return nil, fmt.Errorf("DB_SSLMODE %q is not one of %s", cfg.SSLMode, strings.Join(postgresSSLModes, ", "))
+	}
+
+	db, err := openWithRetry("PostgreSQL", postgres.Open(postgresDSN(cfg)), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := configureConn(db, cfg); err != nil {
+		return nil, err
+	}
+	if err := registerStatementTimeout(db, time.Duration(cfg.StatementTimeout)*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Connected to PostgreSQL database %s at %s:%s", cfg.DbName, cfg.Host, cfg.Port)
+
+	return &PostgresDB{conn: db, autoMigrate: cfg.AutoMigrate}, nil
+}
+
+// postgresDSN builds a connection URL from the configured fields, escaping
+// credentials that contain URL syntax. The statement timeout is also set
+// as the session's statement_timeout, so the server aborts a runaway
+// statement even if the client's cancel request never reaches it.
+func postgresDSN(cfg *config.DatabaseConfig) string {
+	query := url.Values{"sslmode": {cfg.SSLMode}}
+	if cfg.StatementTimeout > 0 {
+		query.Set("statement_timeout", strconv.Itoa(cfg.StatementTimeout))
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, cfg.Port),
+		Path:     "/" + cfg.DbName,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+func (p *PostgresDB) GetConnection() *gorm.DB {
+	return p.conn
+}
+
+func (p *PostgresDB) Tenants() []string {
+	return nil
+}
+
+func (p *PostgresDB) Migrate() error {
+	return migrate(p.conn, p.autoMigrate)
+}
+
+func (p *PostgresDB) MigrationStatus() ([]MigrationStatus, error) {
+	return eachMigrationStatus(p.conn, nil)
+}
+
+func (p *PostgresDB) Ping(ctx context.Context) error {
+	return pingConn(ctx, p.conn)
+}
+
+func (p *PostgresDB) Close() error {
+	sqlDB, err := p.conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
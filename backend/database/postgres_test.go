@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// postgresConfig is a complete PostgreSQL configuration for tests to vary
+func postgresConfig() config.DatabaseConfig {
+	return config.DatabaseConfig{
+		Type:     "postgres",
+		Host:     "db.internal",
+		Port:     "5432",
+		User:     "clarity",
+		Password: "secret",
+		DbName:   "clarity",
+		SSLMode:  "require",
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func(*config.DatabaseConfig)
+		host     string
+		user     string
+		password string
+	}{
+		{"plain", func(*config.DatabaseConfig) {}, "db.internal:5432", "clarity", "secret"},
+		{"credentials with URL syntax", func(cfg *config.DatabaseConfig) {
+			cfg.User = "app@clarity"
+			cfg.Password = "p@ss:w/rd?#%&="
+		}, "db.internal:5432", "app@clarity", "p@ss:w/rd?#%&="},
+		{"IPv6 host", func(cfg *config.DatabaseConfig) { cfg.Host = "::1" }, "[::1]:5432", "clarity", "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := postgresConfig()
+			tt.edit(&cfg)
+			dsn, err := url.Parse(postgresDSN(&cfg))
+			if err != nil {
+				t.Fatalf("DSN %q does not parse: %v", postgresDSN(&cfg), err)
+			}
+			password, _ := dsn.User.Password()
+			if dsn.Scheme != "postgres" || dsn.Host != tt.host || dsn.Path != "/clarity" {
+				t.Errorf("DSN %s, want postgres://%s/clarity", dsn, tt.host)
+			}
+			if dsn.User.Username() != tt.user || password != tt.password {
+				t.Errorf("credentials %q:%q, want %q:%q", dsn.User.Username(), password, tt.user, tt.password)
+			}
+			if got := dsn.Query().Get("sslmode"); got != "require" {
+				t.Errorf("sslmode = %q, want require", got)
+			}
+		})
+	}
+}
+
+func TestPostgresConfiguration(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*config.DatabaseConfig)
+		want string
+	}{
+		{"tenants", func(cfg *config.DatabaseConfig) { cfg.Tenants = []string{"acme=acme.db"} }, "only supported on SQLite"},
+		{"nothing set", func(cfg *config.DatabaseConfig) { *cfg = config.DatabaseConfig{Type: "postgres"} }, "set DB_HOST, DB_PORT, DB_USER, DB_NAME"},
+		{"no host", func(cfg *config.DatabaseConfig) { cfg.Host = " " }, "DB_HOST"},
+		{"no user or name", func(cfg *config.DatabaseConfig) { cfg.User, cfg.DbName = "", "" }, "DB_USER, DB_NAME"},
+		{"port not a number", func(cfg *config.DatabaseConfig) { cfg.Port = "postgres" }, `DB_PORT "postgres"`},
+		{"unknown SSL mode", func(cfg *config.DatabaseConfig) { cfg.SSLMode = "on" }, `DB_SSLMODE "on"`},
+		{"no SSL mode", func(cfg *config.DatabaseConfig) { cfg.SSLMode = "" }, "DB_SSLMODE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := postgresConfig()
+			tt.edit(&cfg)
+			_, err := NewDatabase(&cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewDatabase = %v, want an error naming %s", err, tt.want)
+			}
+		})
+	}
+}
+
+// flakyDialector fails the first failures connection attempts, then
+// connects to an in-memory SQLite database
+type flakyDialector struct {
+	gorm.Dialector
+	failures int
+	attempts *int
+}
+
+var errUnreachable = errors.New("connection refused")
+
+func (d flakyDialector) Initialize(db *gorm.DB) error {
+	*d.attempts++
+	if *d.attempts <= d.failures {
+		return errUnreachable
+	}
+	return d.Dialector.Initialize(db)
+}
+
+func TestOpenWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		retries      int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"first attempt", 0, 3, 1, false},
+		{"server still starting", 2, 3, 3, false},
+		{"last retry", 3, 3, 4, false},
+		{"server never up", 5, 3, 4, true},
+		{"retries disabled", 1, 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			dialector := flakyDialector{Dialector: sqlite.Open(":memory:"), failures: tt.failures, attempts: &attempts}
+			db, err := openWithRetry("PostgreSQL", dialector, &config.DatabaseConfig{ConnectRetries: tt.retries})
+			if attempts != tt.wantAttempts {
+				t.Errorf("%d connection attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, errUnreachable) || !strings.Contains(err.Error(), "PostgreSQL") {
+					t.Errorf("openWithRetry = %v, want the last connection error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openWithRetry: %v", err)
+			}
+			sqlDB, _ := db.DB()
+			defer sqlDB.Close()
+			var one int
+			if err := db.Raw("SELECT 1").Scan(&one).Error; err != nil || one != 1 {
+				t.Errorf("query on the connection = %d, %v", one, err)
+			}
+		})
+	}
+}
+
+// livePostgresConfig configures the PostgreSQL server named by
+// TEST_POSTGRES_HOST and optionally TEST_POSTGRES_PORT, _USER, _PASSWORD
+// and _DB, skipping the test when there is none
+func livePostgresConfig(t *testing.T) *config.DatabaseConfig {
+	t.Helper()
+	host := os.Getenv("TEST_POSTGRES_HOST")
+	if host == "" {
+		t.Skip("TEST_POSTGRES_HOST not set")
+	}
+	env := func(key, fallback string) string {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+		return fallback
+	}
+	return &config.DatabaseConfig{
+		Type:     "postgres",
+		Host:     host,
+		Port:     env("TEST_POSTGRES_PORT", "5432"),
+		User:     env("TEST_POSTGRES_USER", "postgres"),
+		Password: os.Getenv("TEST_POSTGRES_PASSWORD"),
+		DbName:   env("TEST_POSTGRES_DB", "postgres"),
+		SSLMode:  "disable",
+	}
+}
+
+func TestPostgresMigrateAndClose(t *testing.T) {
+	cfg := livePostgresConfig(t)
+	cfg.AutoMigrate = true
+	db, err := NewDatabase(cfg)
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	// Migrating an up to date database changes nothing
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	statuses, err := db.MigrationStatus()
+	if err != nil || len(statuses) != 1 || statuses[0].Version != statuses[0].Latest {
+		t.Errorf("MigrationStatus = %+v, %v; want one database, up to date", statuses, err)
+	}
+	if !db.GetConnection().Migrator().HasTable(&models.HealthRecord{}) {
+		t.Error("no health records table after Migrate")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := db.Ping(context.Background()); err == nil {
+		t.Error("Ping after Close succeeded")
+	}
+}
+
+func TestPostgresStoresRecords(t *testing.T) {
+	cfg := livePostgresConfig(t)
+	db, err := NewDatabase(cfg)
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+	if _, ok := db.(*PostgresDB); !ok {
+		t.Fatalf("NewDatabase with DB_TYPE=postgres returned a %T", db)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	conn := db.GetConnection()
+	// IDs of their own, as the database may be shared
+	id := "pg-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	user := models.User{ID: id, Email: id + "@example.com"}
+	record := models.HealthRecord{ID: user.ID + "-rec", UserID: user.ID, RecordType: "lab_result", Title: "Panel", Metadata: `{"glucose":"90"}`}
+	t.Cleanup(func() {
+		conn.Unscoped().Delete(&models.HealthRecord{}, "id = ?", record.ID)
+		conn.Delete(&models.User{}, "id = ?", user.ID)
+	})
+	if err := conn.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := conn.Create(&record).Error; err != nil {
+		t.Fatalf("create record: %v", err)
+	}
+	var stored models.HealthRecord
+	if err := conn.First(&stored, "id = ?", record.ID).Error; err != nil {
+		t.Fatalf("read record: %v", err)
+	}
+	if stored.UserID != user.ID || stored.Title != "Panel" || !strings.Contains(stored.Metadata, "glucose") {
+		t.Errorf("stored record = %+v", stored)
+	}
+	if err := db.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"gorm.io/gorm"
+)
+
+// maxConnectRetryDelay caps the doubling wait between connection attempts
+const maxConnectRetryDelay = 30 * time.Second
+
+// checkServerConfig names the connection settings of a database server
+// that are missing or invalid, so a misconfigured deployment fails with the
+// fix rather than a driver error
+func checkServerConfig(engine string, cfg *config.DatabaseConfig) error {
+	var missing []string
+	for _, field := range []struct{ value, env string }{
+		{cfg.Host, "DB_HOST"},
+		{cfg.Port, "DB_PORT"},
+		{cfg.User, "DB_USER"},
+		{cfg.DbName, "DB_NAME"},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			missing = append(missing, field.env)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is not configured, set %s", engine, strings.Join(missing, ", "))
+	}
+	if _, err := strconv.Atoi(cfg.Port); err != nil {
+		return fmt.Errorf("DB_PORT %q is not a port number", cfg.Port)
+	}
+	return nil
+}
+
+// openWithRetry connects to a database server. A managed instance may
+// still be starting, or briefly unreachable, when the server boots, so
+// failed connections are retried with a doubling delay before giving up.
+func openWithRetry(engine string, dialector gorm.Dialector, cfg *config.DatabaseConfig) (*gorm.DB, error) {
+	delay := time.Duration(cfg.ConnectRetryDelay) * time.Second
+	for attempt := 1; ; attempt++ {
+		// Open pings the server, so a reachable but refusing server fails here
+		db, err := gorm.Open(dialector, &gorm.Config{})
+		if err == nil {
+			return db, nil
+		}
+		if attempt > cfg.ConnectRetries {
+			return nil, fmt.Errorf("failed to connect to %s after %d attempts: %w", engine, attempt, err)
+		}
+		log.Printf("Failed to connect to %s (attempt %d of %d), retrying in %s: %v", engine, attempt, cfg.ConnectRetries+1, delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, maxConnectRetryDelay)
+	}
+}
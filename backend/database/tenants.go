@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/tenancy"
+	"gorm.io/driver/sqlite"
+)
+
+// tenantPool is the connection pool of a multi-tenant deployment. It sends
+// each statement, and each transaction, to the database of the tenant in
+// its context, opening a tenant's database on first use and keeping it
+// open after. A statement without a tenant fails rather than run against
+// any tenant's data.
+type tenantPool struct {
+	cfg   *config.DatabaseConfig
+	paths map[string]string // database path of each tenant
+	ids   []string
+
+	mu   sync.Mutex
+	open map[string]*sql.DB
+}
+
+// newTenantPool reads the tenants given as "id=path" entries
+func newTenantPool(cfg *config.DatabaseConfig) (*tenantPool, error) {
+	tp := &tenantPool{
+		cfg:   cfg,
+		paths: make(map[string]string, len(cfg.Tenants)),
+		open:  make(map[string]*sql.DB, len(cfg.Tenants)),
+	}
+	for _, entry := range cfg.Tenants {
+		id, path, ok := strings.Cut(entry, "=")
+		id, path = strings.TrimSpace(id), strings.TrimSpace(path)
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("tenant %q must look like id=path", entry)
+		}
+		if _, dup := tp.paths[id]; dup {
+			return nil, fmt.Errorf("tenant %s is configured twice", id)
+		}
+		tp.paths[id] = path
+		tp.ids = append(tp.ids, id)
+	}
+	sort.Strings(tp.ids)
+	return tp, nil
+}
+
+// Tenants returns the configured tenant IDs in order
+func (tp *tenantPool) Tenants() []string {
+	return append([]string(nil), tp.ids...)
+}
+
+// conn returns the database of the tenant ctx acts for
+func (tp *tenantPool) conn(ctx context.Context) (*sql.DB, error) {
+	id, ok := tenancy.FromContext(ctx)
+	if !ok {
+		return nil, tenancy.ErrNoTenant
+	}
+	return tp.tenant(id)
+}
+
+// tenant returns a tenant's database, opening it if this is its first use
+func (tp *tenantPool) tenant(id string) (*sql.DB, error) {
+	path, ok := tp.paths[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", tenancy.ErrUnknownTenant, id)
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if db, ok := tp.open[id]; ok {
+		return db, nil
+	}
+	tenantCfg := *tp.cfg
+	tenantCfg.Path = path
+	db, err := sql.Open(sqlite.DriverName, sqliteDSN(&tenantCfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database of tenant %s: %w", id, err)
+	}
+	configurePool(db, tp.cfg)
+	tp.open[id] = db
+	return db, nil
+}
+
+func (tp *tenantPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	db, err := tp.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.PrepareContext(ctx, query)
+}
+
+func (tp *tenantPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db, err := tp.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, query, args...)
+}
+
+func (tp *tenantPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db, err := tp.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext reports a missing or unknown tenant from the row's Scan,
+// since a *sql.Row can only carry an error that came from a database
+func (tp *tenantPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	db, err := tp.conn(ctx)
+	if err != nil {
+		failed := sql.OpenDB(failedConnector{err})
+		defer failed.Close()
+		return failed.QueryRowContext(ctx, query, args...)
+	}
+	return db.QueryRowContext(ctx, query, args...)
+}
+
+func (tp *tenantPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	db, err := tp.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.BeginTx(ctx, opts)
+}
+
+// Ping opens and pings every tenant's database, so a tenant whose database
+// cannot be reached is found at startup rather than on its first request
+func (tp *tenantPool) Ping(ctx context.Context) error {
+	for _, id := range tp.ids {
+		db, err := tp.tenant(id)
+		if err != nil {
+			return err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			return fmt.Errorf("database of tenant %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every tenant database opened so far
+func (tp *tenantPool) Close() error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	var errs []error
+	for id, db := range tp.open {
+		if err := db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+		delete(tp.open, id)
+	}
+	return errors.Join(errs...)
+}
+
+// failedConnector is a driver whose every connection fails with err
+type failedConnector struct {
+	err error
+}
+
+func (fc failedConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, fc.err
+}
+
+func (fc failedConnector) Driver() driver.Driver {
+	return fc
+}
+
+func (fc failedConnector) Open(string) (driver.Conn, error) {
+	return nil, fc.err
+}
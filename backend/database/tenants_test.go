@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/tenancy"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTenantTestDB opens a deployment with tenants acme and globex, each
+// with its own database file, and migrates both
+func newTenantTestDB(t *testing.T) (*SQLiteDB, map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	paths := map[string]string{
+		"acme":   filepath.Join(dir, "acme.db"),
+		"globex": filepath.Join(dir, "globex.db"),
+	}
+	db, err := NewDatabase(&config.DatabaseConfig{
+		Type:        "sqlite",
+		Tenants:     []string{"globex=" + paths["globex"], " acme = " + paths["acme"]},
+		AutoMigrate: true,
+	})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db.(*SQLiteDB), paths
+}
+
+// usersIn lists the user IDs stored in the database file at path, read
+// without going through the tenant pool
+func usersIn(t *testing.T, path string) []string {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	conn, _ := db.DB()
+	defer conn.Close()
+	var ids []string
+	if err := db.Model(&models.User{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		t.Fatalf("users in %s: %v", path, err)
+	}
+	return ids
+}
+
+func TestTenantsHaveSeparateDatabases(t *testing.T) {
+	db, paths := newTenantTestDB(t)
+	conn := db.GetConnection()
+	acme := tenancy.WithTenant(context.Background(), "acme")
+	globex := tenancy.WithTenant(context.Background(), "globex")
+
+	if got := db.Tenants(); len(got) != 2 || got[0] != "acme" || got[1] != "globex" {
+		t.Errorf("Tenants = %v, want [acme globex]", got)
+	}
+
+	// The same ID in both tenants is two different users
+	if err := conn.WithContext(acme).Create(&models.User{ID: "user-1", Email: "a@acme.example"}).Error; err != nil {
+		t.Fatalf("create in acme: %v", err)
+	}
+	if err := conn.WithContext(globex).Create(&models.User{ID: "user-1", Email: "a@globex.example"}).Error; err != nil {
+		t.Fatalf("create in globex: %v", err)
+	}
+	if err := conn.WithContext(acme).Create(&models.User{ID: "user-2", Email: "b@acme.example"}).Error; err != nil {
+		t.Fatalf("create in acme: %v", err)
+	}
+
+	var user models.User
+	if err := conn.WithContext(globex).First(&user, "id = ?", "user-1").Error; err != nil || user.Email != "a@globex.example" {
+		t.Errorf("globex user-1 = %+v, %v; want globex's", user, err)
+	}
+	if err := conn.WithContext(globex).First(&user, "id = ?", "user-2").Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("acme's user-2 read from globex: %v, want not found", err)
+	}
+	var count int64
+	conn.WithContext(acme).Model(&models.User{}).Count(&count)
+	if count != 2 {
+		t.Errorf("acme has %d users, want 2", count)
+	}
+
+	// Each tenant's rows are in its own file
+	if got := usersIn(t, paths["acme"]); len(got) != 2 {
+		t.Errorf("acme's database holds %v, want user-1 and user-2", got)
+	}
+	if got := usersIn(t, paths["globex"]); len(got) != 1 || got[0] != "user-1" {
+		t.Errorf("globex's database holds %v, want user-1", got)
+	}
+
+	// A transaction stays in the tenant it began in
+	err := conn.WithContext(globex).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.User{ID: "user-3", Email: "c@globex.example"}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", "user-1").Update("name", "Globex One").Error
+	})
+	if err != nil {
+		t.Fatalf("globex transaction: %v", err)
+	}
+	if err := conn.WithContext(acme).First(&user, "id = ?", "user-1").Error; err != nil || user.Name != "" {
+		t.Errorf("acme user-1 after globex's update = %+v, %v", user, err)
+	}
+	if got := usersIn(t, paths["globex"]); len(got) != 2 {
+		t.Errorf("globex's database holds %v after the transaction, want user-1 and user-3", got)
+	}
+}
+
+func TestTenantPoolRefusesStatementsWithoutATenant(t *testing.T) {
+	db, _ := newTenantTestDB(t)
+	conn := db.GetConnection()
+
+	var count int64
+	if err := conn.Model(&models.User{}).Count(&count).Error; !errors.Is(err, tenancy.ErrNoTenant) {
+		t.Errorf("count without a tenant: %v, want %v", err, tenancy.ErrNoTenant)
+	}
+	if err := conn.Create(&models.User{ID: "user-1"}).Error; !errors.Is(err, tenancy.ErrNoTenant) {
+		t.Errorf("create without a tenant: %v, want %v", err, tenancy.ErrNoTenant)
+	}
+	var user models.User
+	unknown := tenancy.WithTenant(context.Background(), "initech")
+	if err := conn.WithContext(unknown).First(&user).Error; !errors.Is(err, tenancy.ErrUnknownTenant) {
+		t.Errorf("read for an unknown tenant: %v, want %v", err, tenancy.ErrUnknownTenant)
+	}
+	err := conn.WithContext(unknown).Transaction(func(tx *gorm.DB) error { return nil })
+	if !errors.Is(err, tenancy.ErrUnknownTenant) {
+		t.Errorf("transaction for an unknown tenant: %v, want %v", err, tenancy.ErrUnknownTenant)
+	}
+}
+
+func TestTenantPoolCachesConnections(t *testing.T) {
+	db, _ := newTenantTestDB(t)
+	first, err := db.tenants.tenant("acme")
+	if err != nil {
+		t.Fatalf("tenant: %v", err)
+	}
+	again, err := db.tenants.tenant("acme")
+	if err != nil || again != first {
+		t.Errorf("second use of acme opened another connection pool")
+	}
+	if other, _ := db.tenants.tenant("globex"); other == first {
+		t.Error("globex shares acme's connection pool")
+	}
+	if err := db.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
+
+func TestMigrateRunsPerTenant(t *testing.T) {
+	db, _ := newTenantTestDB(t)
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	tenants := map[string]bool{}
+	for _, status := range statuses {
+		tenants[status.Tenant] = true
+		if status.Version != status.Latest || len(status.Pending) > 0 {
+			t.Errorf("tenant %s at migration %d of %d, pending %v", status.Tenant, status.Version, status.Latest, status.Pending)
+		}
+	}
+	if !tenants["acme"] || !tenants["globex"] || len(tenants) != 2 {
+		t.Errorf("migration status covers tenants %v, want acme and globex", tenants)
+	}
+	for _, id := range db.Tenants() {
+		ctx := tenancy.WithTenant(context.Background(), id)
+		if !db.GetConnection().WithContext(ctx).Migrator().HasTable(&models.HealthRecord{}) {
+			t.Errorf("tenant %s has no health records table", id)
+		}
+	}
+}
+
+func TestTenantConfiguration(t *testing.T) {
+	for name, tenants := range map[string][]string{
+		"missing path":     {"acme"},
+		"empty id":         {"=acme.db"},
+		"configured twice": {"acme=a.db", "acme=b.db"},
+	} {
+		if _, err := newTenantPool(&config.DatabaseConfig{Tenants: tenants}); err == nil {
+			t.Errorf("%s: tenants %v accepted", name, tenants)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// statementTimeoutKey holds a statement's deadline between the callbacks
+// that set and clear it
+const statementTimeoutKey = "clarity:statement_timeout"
+
+// statementDeadline is what the timeout callbacks pass between them
+type statementDeadline struct {
+	parent context.Context
+	cancel context.CancelFunc
+	rows   bool // Row or Rows, whose results outlive the callbacks
+}
+
+// registerStatementTimeout aborts any statement still running after
+// timeout. The driver interrupts a statement whose context ends (SQLite
+// through sqlite3_interrupt), so a runaway query stops inside the database
+// instead of holding its connection. Row and Rows results are read after
+// the callbacks return, so their deadline is left to expire rather than
+// cancelled. A zero timeout registers nothing.
+func registerStatementTimeout(db *gorm.DB, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+
+	start := func(rows bool) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			parent := tx.Statement.Context
+			if parent == nil {
+				parent = context.Background()
+			}
+			if deadline, ok := parent.Deadline(); ok && time.Until(deadline) <= timeout {
+				return
+			}
+			ctx, cancel := context.WithTimeout(parent, timeout)
+			tx.Statement.Context = ctx
+			tx.InstanceSet(statementTimeoutKey, statementDeadline{parent: parent, cancel: cancel, rows: rows})
+		}
+	}
+	// finish restores the caller's context, since a chained query may run
+	// another statement on the same Statement
+	finish := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(statementTimeoutKey)
+		if !ok {
+			return
+		}
+		deadline := value.(statementDeadline)
+		if !deadline.rows {
+			deadline.cancel()
+		}
+		tx.Statement.Context = deadline.parent
+	}
+
+	callbacks := db.Callback()
+	err := errors.Join(
+		callbacks.Create().Before("gorm:create").Register("clarity:timeout_start", start(false)),
+		callbacks.Create().After("gorm:create").Register("clarity:timeout_finish", finish),
+		callbacks.Query().Before("gorm:query").Register("clarity:timeout_start", start(false)),
+		callbacks.Query().After("gorm:query").Register("clarity:timeout_finish", finish),
+		callbacks.Update().Before("gorm:update").Register("clarity:timeout_start", start(false)),
+		callbacks.Update().After("gorm:update").Register("clarity:timeout_finish", finish),
+		callbacks.Delete().Before("gorm:delete").Register("clarity:timeout_start", start(false)),
+		callbacks.Delete().After("gorm:delete").Register("clarity:timeout_finish", finish),
+		callbacks.Raw().Before("gorm:raw").Register("clarity:timeout_start", start(false)),
+		callbacks.Raw().After("gorm:raw").Register("clarity:timeout_finish", finish),
+		callbacks.Row().Before("gorm:row").Register("clarity:timeout_start", start(true)),
+		callbacks.Row().After("gorm:row").Register("clarity:timeout_finish", finish),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register statement timeout: %w", err)
+	}
+	return nil
+}
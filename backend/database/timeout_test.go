@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"gorm.io/gorm"
+)
+
+// endless is a query that never finishes on its own
+const endless = "WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n) SELECT count(*) FROM n"
+
+func newTimeoutTestDB(t *testing.T, timeout int) *gorm.DB {
+	t.Helper()
+	db, err := NewDatabase(&config.DatabaseConfig{
+		Type:             "sqlite",
+		Path:             "file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared",
+		StatementTimeout: timeout,
+	})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db.GetConnection()
+}
+
+// runEndless runs the endless query and returns how long it ran for
+func runEndless(ctx context.Context, db *gorm.DB) (time.Duration, error) {
+	start := time.Now()
+	var count int64
+	err := db.WithContext(ctx).Raw(endless).Scan(&count).Error
+	return time.Since(start), err
+}
+
+func TestSlowStatementIsAborted(t *testing.T) {
+	db := newTimeoutTestDB(t, 100)
+
+	took, err := runEndless(context.Background(), db)
+	if err == nil {
+		t.Fatal("endless query finished")
+	}
+	if took > 5*time.Second {
+		t.Errorf("query aborted after %v, want about 100ms", took)
+	}
+
+	// The connection is still usable
+	var one int
+	if err := db.Raw("SELECT 1").Scan(&one).Error; err != nil || one != 1 {
+		t.Errorf("query after the abort = %d, %v", one, err)
+	}
+}
+
+func TestShorterCallerDeadlineIsKept(t *testing.T) {
+	db := newTimeoutTestDB(t, 60000)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	took, err := runEndless(ctx, db)
+	if err == nil {
+		t.Fatal("endless query finished")
+	}
+	if took > 5*time.Second {
+		t.Errorf("query aborted after %v, want the caller's 100ms", took)
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		path        string
+		busyTimeout int
+		want        string
+	}{
+		{"clarity.db", 5000, "clarity.db?_busy_timeout=5000"},
+		{"file:clarity.db?cache=shared", 5000, "file:clarity.db?cache=shared&_busy_timeout=5000"},
+		{"clarity.db", 0, "clarity.db"},
+	}
+	for _, tt := range tests {
+		if got := sqliteDSN(&config.DatabaseConfig{Path: tt.path, BusyTimeout: tt.busyTimeout}); got != tt.want {
+			t.Errorf("sqliteDSN(%q, %d) = %q, want %q", tt.path, tt.busyTimeout, got, tt.want)
+		}
+	}
+}
+
+func TestPostgresDSNSetsStatementTimeout(t *testing.T) {
+	cfg := &config.DatabaseConfig{Host: "db", Port: "5432", User: "clarity", Password: "p@ss/word", DbName: "clarity", SSLMode: "require", StatementTimeout: 30000}
+	dsn, err := url.Parse(postgresDSN(cfg))
+	if err != nil {
+		t.Fatalf("postgresDSN: %v", err)
+	}
+	if got := dsn.Query().Get("statement_timeout"); got != "30000" {
+		t.Errorf("statement_timeout = %q, want 30000", got)
+	}
+	if password, _ := dsn.User.Password(); password != "p@ss/word" {
+		t.Errorf("password = %q", password)
+	}
+
+	cfg.StatementTimeout = 0
+	if dsn, err := url.Parse(postgresDSN(cfg)); err != nil || dsn.Query().Has("statement_timeout") {
+		t.Errorf("postgresDSN with no timeout = %v, %v", dsn, err)
+	}
+}
+
+// TestPostgresStatementTimeout needs a PostgreSQL server, see
+// livePostgresConfig
+func TestPostgresStatementTimeout(t *testing.T) {
+	cfg := livePostgresConfig(t)
+	cfg.StatementTimeout = 200
+	db, err := NewDatabase(cfg)
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	defer db.Close()
+	conn := db.GetConnection()
+
+	// Set on the session, so the server enforces it by itself
+	var setting string
+	if err := conn.Raw("SHOW statement_timeout").Scan(&setting).Error; err != nil || setting != "200ms" {
+		t.Errorf("statement_timeout = %q, %v; want 200ms", setting, err)
+	}
+
+	start := time.Now()
+	if err := conn.Exec("SELECT pg_sleep(5)").Error; err == nil {
+		t.Fatal("pg_sleep(5) finished")
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("query aborted after %v, want about 200ms", took)
+	}
+}
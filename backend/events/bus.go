@@ -0,0 +1,133 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Event is a domain event. Services publish one after the change it
+// describes has committed, so subscribers never see a change that was
+// rolled back.
+type Event interface {
+	EventName() string
+}
+
+// Bus delivers domain events to the subscribers registered for them, so a
+// service can announce what happened without calling the services that
+// care. It is in-process only: work that must survive a restart or reach
+// another process should be queued by its subscriber, for example on the
+// delivery queue.
+//
+// Subscribers of an event run one at a time, in the order they subscribed,
+// on the publisher's goroutine, and Publish returns once all of them have
+// run. Events published from one goroutine therefore reach each subscriber
+// in order; there is no ordering between events published concurrently.
+// A subscriber's error or panic is logged and counted and does not stop
+// the others, nor fail the publisher.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions map[string][]*subscription // by event name
+}
+
+type subscription struct {
+	subscriber string
+	handle     func(context.Context, Event) error
+
+	delivered atomic.Int64
+	failed    atomic.Int64
+}
+
+func NewBus() *Bus {
+	return &Bus{subscriptions: make(map[string][]*subscription)}
+}
+
+// Subscribe registers handler for events of type E under a subscriber
+// name, which labels its logs and counters. Register subscribers at
+// startup, before anything publishes.
+func Subscribe[E Event](b *Bus, subscriber string, handler func(ctx context.Context, event E) error) {
+	var zero E
+	name := zero.EventName()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions[name] = append(b.subscriptions[name], &subscription{
+		subscriber: subscriber,
+		handle: func(ctx context.Context, event Event) error {
+			return handler(ctx, event.(E))
+		},
+	})
+}
+
+// Publish delivers event to its subscribers. Subscribers run even if the
+// caller's context is cancelled, since the change has already happened. A
+// nil Bus drops the event, for services built without one.
+func (b *Bus) Publish(ctx context.Context, event Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	subscriptions := b.subscriptions[event.EventName()]
+	b.mu.RUnlock()
+
+	ctx = context.WithoutCancel(ctx)
+	for _, sub := range subscriptions {
+		if err := deliver(ctx, sub, event); err != nil {
+			sub.failed.Add(1)
+			log.Printf("Event subscriber %s failed on %s: %v", sub.subscriber, event.EventName(), err)
+			continue
+		}
+		sub.delivered.Add(1)
+	}
+}
+
+// deliver runs one subscriber, turning a panic into an error
+func deliver(ctx context.Context, sub *subscription, event Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.ErrorContext(ctx, "Panic in event subscriber", "subscriber", sub.subscriber, "event", event.EventName(), "panic", p, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return sub.handle(ctx, event)
+}
+
+// SubscriberStats counts one subscriber's deliveries of one event
+type SubscriberStats struct {
+	Event      string
+	Subscriber string
+	Delivered  int64
+	Failed     int64 // returned an error or panicked
+}
+
+// Stats returns delivery counts for every subscription, by event name and
+// then in subscription order
+func (b *Bus) Stats() []SubscriberStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	names := make([]string, 0, len(b.subscriptions))
+	for name := range b.subscriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var stats []SubscriberStats
+	for _, name := range names {
+		for _, sub := range b.subscriptions[name] {
+			stats = append(stats, SubscriberStats{
+				Event:      name,
+				Subscriber: sub.subscriber,
+				Delivered:  sub.delivered.Load(),
+				Failed:     sub.failed.Load(),
+			})
+		}
+	}
+	return stats
+}
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSubscribersRunInOrder(t *testing.T) {
+	bus := NewBus()
+	var got []string
+	for _, name := range []string{"audit", "notifications", "counters"} {
+		name := name
+		Subscribe(bus, name, func(ctx context.Context, e RecordCreated) error {
+			got = append(got, name+":"+e.RecordID)
+			return nil
+		})
+	}
+
+	bus.Publish(context.Background(), RecordCreated{RecordID: "rec-1"})
+	bus.Publish(context.Background(), RecordCreated{RecordID: "rec-2"})
+
+	want := []string{"audit:rec-1", "notifications:rec-1", "counters:rec-1", "audit:rec-2", "notifications:rec-2", "counters:rec-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deliveries = %v, want %v", got, want)
+	}
+}
+
+func TestSubscribersAreIsolated(t *testing.T) {
+	bus := NewBus()
+	delivered := 0
+	Subscribe(bus, "failing", func(ctx context.Context, e ScanCompleted) error { return errors.New("disk full") })
+	Subscribe(bus, "panicking", func(ctx context.Context, e ScanCompleted) error { panic("nil map") })
+	Subscribe(bus, "healthy", func(ctx context.Context, e ScanCompleted) error {
+		delivered++
+		return nil
+	})
+
+	bus.Publish(context.Background(), ScanCompleted{ScanID: "scan-1"})
+	bus.Publish(context.Background(), ScanCompleted{ScanID: "scan-2"})
+
+	if delivered != 2 {
+		t.Errorf("healthy subscriber ran %d times, want 2", delivered)
+	}
+	want := []SubscriberStats{
+		{Event: "scan.completed", Subscriber: "failing", Failed: 2},
+		{Event: "scan.completed", Subscriber: "panicking", Failed: 2},
+		{Event: "scan.completed", Subscriber: "healthy", Delivered: 2},
+	}
+	if got := bus.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestEventsReachOnlyTheirSubscribers(t *testing.T) {
+	bus := NewBus()
+	var logins, records int
+	Subscribe(bus, "audit", func(ctx context.Context, e UserLoggedIn) error {
+		logins++
+		return nil
+	})
+	Subscribe(bus, "audit", func(ctx context.Context, e RecordCreated) error {
+		records++
+		return nil
+	})
+
+	bus.Publish(context.Background(), UserLoggedIn{UserID: "user-1"})
+	bus.Publish(context.Background(), ConversationMessageAdded{UserID: "user-1"})
+
+	if logins != 1 || records != 0 {
+		t.Errorf("logins %d, records %d; want 1 and 0", logins, records)
+	}
+}
+
+func TestPublishOutlivesTheCaller(t *testing.T) {
+	bus := NewBus()
+	var subscriberErr error
+	Subscribe(bus, "audit", func(ctx context.Context, e RecordCreated) error {
+		subscriberErr = ctx.Err()
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bus.Publish(ctx, RecordCreated{RecordID: "rec-1"})
+	if subscriberErr != nil {
+		t.Errorf("subscriber saw a cancelled context: %v", subscriberErr)
+	}
+}
+
+func TestNilBusDropsEvents(t *testing.T) {
+	var bus *Bus
+	bus.Publish(context.Background(), RecordCreated{RecordID: "rec-1"})
+}
+
+func TestConcurrentPublishers(t *testing.T) {
+	bus := NewBus()
+	var mu sync.Mutex
+	last := make(map[string]int)
+	outOfOrder := 0
+	Subscribe(bus, "counters", func(ctx context.Context, e ConversationMessageAdded) error {
+		mu.Lock()
+		defer mu.Unlock()
+		n, _ := strconv.Atoi(e.MessageID)
+		if n <= last[e.ConversationID] {
+			outOfOrder++
+		}
+		last[e.ConversationID] = n
+		return nil
+	})
+
+	// Each goroutine's events arrive in the order it published them;
+	// nothing is promised across goroutines
+	var wg sync.WaitGroup
+	for _, conversation := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func(conversation string) {
+			defer wg.Done()
+			for i := 1; i <= 200; i++ {
+				bus.Publish(context.Background(), ConversationMessageAdded{ConversationID: conversation, MessageID: strconv.Itoa(i)})
+			}
+		}(conversation)
+	}
+	wg.Wait()
+
+	if outOfOrder != 0 {
+		t.Errorf("%d events from one publisher arrived out of order", outOfOrder)
+	}
+	if stats := bus.Stats(); stats[0].Delivered != 800 {
+		t.Errorf("delivered %d, want 800", stats[0].Delivered)
+	}
+}
@@ -0,0 +1,73 @@
+package events
+
+import "time"
+
+// RecordCreated is published when a user adds a health record, directly
+// or through a scanned prescription
+type RecordCreated struct {
+	UserID     string
+	RecordID   string
+	RecordType string
+}
+
+func (RecordCreated) EventName() string { return "record.created" }
+
+// ScanCompleted is published when a prescription scan has been extracted
+// and stored
+type ScanCompleted struct {
+	UserID string
+	ScanID string
+}
+
+func (ScanCompleted) EventName() string { return "scan.completed" }
+
+// ConversationMessageAdded is published when a doctor chat turn is stored
+type ConversationMessageAdded struct {
+	UserID         string
+	ConversationID string
+	MessageID      string
+	Degraded       bool // answered by the rule-based fallback
+}
+
+func (ConversationMessageAdded) EventName() string { return "conversation.message_added" }
+
+// UserLoggedIn is published when a sign-in starts a new session
+type UserLoggedIn struct {
+	UserID   string
+	DeviceID string
+	Method   string // "otp" or "totp"
+	NewUser  bool   // the account was created by this sign-in
+}
+
+func (UserLoggedIn) EventName() string { return "user.logged_in" }
+
+// ExportLinkCreated is published when a user shares records through an
+// export link
+type ExportLinkCreated struct {
+	UserID    string
+	LinkID    string
+	Records   int
+	ExpiresAt time.Time
+	PIN       bool
+	Redacted  bool
+}
+
+func (ExportLinkCreated) EventName() string { return "export_link.created" }
+
+// ExportLinkAccessed is published each time an export link is opened
+type ExportLinkAccessed struct {
+	LinkID   string
+	Accessor string // client address
+}
+
+func (ExportLinkAccessed) EventName() string { return "export_link.accessed" }
+
+// ExportLinkRevoked is published when a link is revoked by its owner or,
+// with a reason, automatically
+type ExportLinkRevoked struct {
+	ActorID string
+	LinkID  string
+	Reason  string // empty when the owner revoked it
+}
+
+func (ExportLinkRevoked) EventName() string { return "export_link.revoked" }
@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/clarity/backend/services"
+	"github.com/clarity/backend/tenancy"
+)
+
+// sharePath is the URL prefix of export links, followed by the link token
+const sharePath = "/share/"
+
+// NewHandler returns the HTTP gateway that serves export links to people
+// without a Clarity account. Browsers get an HTML page; clients sending
+// Accept: application/json get the snapshot as JSON. Every request passes
+// through guard.
+func NewHandler(exports *services.ExportService, guard *Guard) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(sharePath, func(w http.ResponseWriter, r *http.Request) {
+		serveShare(exports, guard, w, r)
+	})
+	return guard.Wrap(mux)
+}
+
+func serveShare(exports *services.ExportService, guard *Guard, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, sharePath)
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The PIN is only accepted in a form body so it never lands in access logs
+	var pin string
+	if r.Method == http.MethodPost {
+		pin = r.PostFormValue("pin")
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	// A replayed or stale PIN form is refused before the PIN is checked
+	if pin != "" && guard.PINNonce() && !guard.UseNonce(r.PostFormValue("nonce")) {
+		renderPIN(w, guard, "This form has expired, please enter the PIN again.")
+		return
+	}
+
+	// Links from a multi-tenant deployment name their tenant; an unknown one
+	// finds no link. The PIN form posts back to the same URL, so the tenant
+	// stays with it.
+	ctx := r.Context()
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		ctx = tenancy.WithTenant(ctx, tenant)
+	}
+
+	snapshot, err := exports.OpenExportLink(ctx, token, pin, clientIP(r))
+	switch {
+	case err == nil:
+	case errors.Is(err, services.ErrPINRequired):
+		renderPIN(w, guard, "")
+		return
+	case errors.Is(err, services.ErrPermissionDenied):
+		renderPIN(w, guard, "Incorrect PIN, please try again.")
+		return
+	case errors.Is(err, services.ErrLocked):
+		render(w, http.StatusTooManyRequests, messageTemplate, "Too many incorrect PINs. Try again later.")
+		return
+	case errors.Is(err, services.ErrNotFound):
+		render(w, http.StatusNotFound, messageTemplate, "This link has expired or is no longer available.")
+		return
+	default:
+		log.Printf("Failed to open export link: %v", err)
+		render(w, http.StatusInternalServerError, messageTemplate, "Something went wrong. Please try again later.")
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot)
+		return
+	}
+	render(w, http.StatusOK, snapshotTemplate, snapshot)
+}
+
+func render(w http.ResponseWriter, code int, tmpl *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Failed to render gateway page: %v", err)
+	}
+}
+
+// renderPIN asks for the link's PIN, with a fresh nonce when PIN forms
+// are single use
+func renderPIN(w http.ResponseWriter, guard *Guard, message string) {
+	page := pinPage{Error: message}
+	if guard.PINNonce() {
+		nonce, err := guard.IssueNonce()
+		if err != nil {
+			log.Printf("Failed to issue PIN form nonce: %v", err)
+			render(w, http.StatusInternalServerError, messageTemplate, "Something went wrong. Please try again later.")
+			return
+		}
+		page.Nonce = nonce
+	}
+	render(w, http.StatusUnauthorized, pinTemplate, page)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type pinPage struct {
+	Error string
+	Nonce string // empty unless PIN forms are single use
+}
+
+const pageHead = `<!DOCTYPE html><html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Shared health records</title></head><body>`
+
+var pinTemplate = template.Must(template.New("pin").Parse(pageHead + `
+<h1>Shared health records</h1>
+<p>Enter the PIN the patient gave you.</p>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">{{if .Nonce}}<input type="hidden" name="nonce" value="{{.Nonce}}">{{end}}<input name="pin" inputmode="numeric" autocomplete="off" autofocus> <button type="submit">View records</button></form>
+</body></html>`))
+
+var messageTemplate = template.Must(template.New("message").Parse(pageHead + `
+<h1>Shared health records</h1>
+<p>{{.}}</p>
+</body></html>`))
+
+var snapshotTemplate = template.Must(template.New("snapshot").Parse(pageHead + `
+<h1>Health records{{if .PatientName}} for {{.PatientName}}{{end}}</h1>
+<p>Shared {{.CreatedAt.Format "2 Jan 2006"}}, available until {{.ExpiresAt.Format "2 Jan 2006 15:04 MST"}}. This is a snapshot; later changes by the patient are not shown.</p>
+{{if .Redacted}}<p><strong>Names and contact details have been removed from this copy.</strong></p>{{end}}
+<p>{{.Summary}}</p>
+{{range .Records}}
+<section>
+<h2>{{.Title}}</h2>
+<p><em>{{.RecordType}}</em>, {{.CreatedAt.Format "2 Jan 2006"}}</p>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Metadata}}<dl>{{range $key, $value := .Metadata}}<dt>{{$key}}</dt><dd>{{$value}}</dd>{{end}}</dl>{{end}}
+</section>
+{{end}}
+</body></html>`))
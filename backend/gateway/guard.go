@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// Guard protects the gateway's unauthenticated endpoints from scraping and
+// replay. It throttles each client address, blocking one over the rate
+// limit for a penalty that doubles with each further offence, and issues
+// single-use nonces for forms. Its state is in memory, per instance; the
+// per-link limits are enforced by ExportService.
+type Guard struct {
+	mu        sync.Mutex
+	clients   map[string]*guardClient
+	nonces    map[string]time.Time // expiry of each unused nonce
+	lastSweep time.Time
+	now       func() time.Time
+
+	limit       int
+	window      time.Duration
+	penaltyBase time.Duration
+	penaltyMax  time.Duration
+	pinNonce    bool
+	nonceTTL    time.Duration
+}
+
+// guardClient is the throttling state of one address
+type guardClient struct {
+	windowStart  time.Time
+	requests     int
+	offences     int // times blocked; reset once the address goes quiet
+	blockedUntil time.Time
+	lastSeen     time.Time
+}
+
+func NewGuard(cfg *config.GatewayConfig) *Guard {
+	return &Guard{
+		clients: make(map[string]*guardClient),
+		nonces:  make(map[string]time.Time),
+		now:     time.Now,
+
+		limit:       cfg.RateLimit,
+		window:      time.Duration(cfg.RateWindow) * time.Second,
+		penaltyBase: time.Duration(cfg.PenaltyBase) * time.Second,
+		penaltyMax:  time.Duration(cfg.PenaltyMax) * time.Second,
+		pinNonce:    cfg.PINNonce,
+		nonceTTL:    time.Duration(cfg.NonceTTL) * time.Second,
+	}
+}
+
+// Allow counts a request from addr. Once the address is over the rate
+// limit it returns false and how long the address is blocked for.
+func (g *Guard) Allow(addr string) (time.Duration, bool) {
+	if g.limit <= 0 {
+		return 0, true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+
+	c := g.clients[addr]
+	if c == nil {
+		c = &guardClient{windowStart: now}
+		g.clients[addr] = c
+	}
+	c.lastSeen = now
+	if now.Before(c.blockedUntil) {
+		return c.blockedUntil.Sub(now), false
+	}
+	if now.Sub(c.windowStart) >= g.window {
+		c.windowStart = now
+		c.requests = 0
+	}
+
+	c.requests++
+	if c.requests <= g.limit {
+		return 0, true
+	}
+
+	penalty := g.penalty(c.offences)
+	c.offences++
+	c.blockedUntil = now.Add(penalty)
+	c.windowStart = c.blockedUntil
+	c.requests = 0
+	return penalty, false
+}
+
+// penalty is the block for an address's offence after the given number of
+// earlier ones: the base doubled for each, up to the maximum
+func (g *Guard) penalty(offences int) time.Duration {
+	penalty := g.penaltyBase
+	for i := 0; i < offences && penalty < g.penaltyMax; i++ {
+		penalty *= 2
+	}
+	if g.penaltyMax > 0 && penalty > g.penaltyMax {
+		penalty = g.penaltyMax
+	}
+	return penalty
+}
+
+// sweep forgets addresses that have been quiet for longer than any
+// penalty, and so their offences, and expired nonces. It runs at most once
+// a rate window. Callers must hold mu.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.window {
+		return
+	}
+	g.lastSweep = now
+
+	idle := g.window
+	if g.penaltyMax > idle {
+		idle = g.penaltyMax
+	}
+	for addr, c := range g.clients {
+		if now.After(c.blockedUntil) && now.Sub(c.lastSeen) > idle {
+			delete(g.clients, addr)
+		}
+	}
+	for nonce, expires := range g.nonces {
+		if !now.Before(expires) {
+			delete(g.nonces, nonce)
+		}
+	}
+}
+
+// PINNonce reports whether PIN forms must carry a nonce from IssueNonce
+func (g *Guard) PINNonce() bool {
+	return g.pinNonce
+}
+
+// IssueNonce returns a nonce that UseNonce accepts once, until the
+// configured TTL passes
+func (g *Guard) IssueNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	nonce := hex.EncodeToString(b)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	g.sweep(now)
+	g.nonces[nonce] = now.Add(g.nonceTTL)
+	return nonce, nil
+}
+
+// UseNonce consumes nonce, reporting whether it was issued by IssueNonce
+// and has neither expired nor been used before
+func (g *Guard) UseNonce(nonce string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	expires, ok := g.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(g.nonces, nonce)
+	return g.now().Before(expires)
+}
+
+// Wrap throttles requests to next by client address, answering blocked
+// addresses with 429 Too Many Requests and a Retry-After header
+func (g *Guard) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter, ok := g.Allow(clientIP(r)); !ok {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+			render(w, http.StatusTooManyRequests, messageTemplate, "Too many requests. Try again later.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// newTestGuard returns a guard allowing 5 requests a minute per address,
+// with penalties from 30 seconds up to 4 minutes, on a clock the test
+// moves
+func newTestGuard(pinNonce bool) (*Guard, *time.Time) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	g := NewGuard(&config.GatewayConfig{
+		RateLimit:   5,
+		RateWindow:  60,
+		PenaltyBase: 30,
+		PenaltyMax:  240,
+		PINNonce:    pinNonce,
+		NonceTTL:    600,
+	})
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestScraperIsBlockedWithGrowingPenalties(t *testing.T) {
+	g, now := newTestGuard(false)
+	const scraper = "192.0.2.50"
+
+	for _, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		for i := 0; i < 5; i++ {
+			if _, ok := g.Allow(scraper); !ok {
+				t.Fatalf("request %d within the limit refused", i+1)
+			}
+		}
+		penalty, ok := g.Allow(scraper)
+		if ok || penalty != want {
+			t.Fatalf("request over the limit = %v, %v; want blocked for %v", penalty, ok, want)
+		}
+
+		*now = now.Add(penalty / 2)
+		if remaining, ok := g.Allow(scraper); ok || remaining != penalty/2 {
+			t.Errorf("request while blocked = %v, %v; want blocked for %v more", remaining, ok, penalty/2)
+		}
+		*now = now.Add(penalty / 2)
+	}
+
+	// Other addresses are unaffected
+	if _, ok := g.Allow("203.0.113.10"); !ok {
+		t.Error("another address was blocked")
+	}
+}
+
+func TestClinicianIsNeverThrottled(t *testing.T) {
+	g, now := newTestGuard(false)
+	// Opening the link, entering the PIN, and coming back to it during
+	// the day
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 3; j++ {
+			if _, ok := g.Allow("203.0.113.10"); !ok {
+				t.Fatalf("visit %d request %d refused", i+1, j+1)
+			}
+		}
+		*now = now.Add(20 * time.Minute)
+	}
+}
+
+func TestQuietAddressIsForgiven(t *testing.T) {
+	g, now := newTestGuard(false)
+	const addr = "192.0.2.50"
+	offend := func() time.Duration {
+		for i := 0; i < 5; i++ {
+			g.Allow(addr)
+		}
+		penalty, _ := g.Allow(addr)
+		*now = now.Add(penalty)
+		return penalty
+	}
+
+	offend()
+	if penalty := offend(); penalty != time.Minute {
+		t.Fatalf("second offence penalty = %v, want 1m", penalty)
+	}
+	// Quiet for longer than the longest penalty
+	*now = now.Add(5 * time.Minute)
+	g.Allow("203.0.113.10") // sweeps
+	if penalty := offend(); penalty != 30*time.Second {
+		t.Errorf("penalty after going quiet = %v, want the base 30s", penalty)
+	}
+}
+
+func TestThrottlingDisabled(t *testing.T) {
+	g := NewGuard(&config.GatewayConfig{})
+	for i := 0; i < 1000; i++ {
+		if _, ok := g.Allow("192.0.2.50"); !ok {
+			t.Fatalf("request %d refused with throttling off", i+1)
+		}
+	}
+}
+
+func TestNoncesAreSingleUse(t *testing.T) {
+	g, now := newTestGuard(true)
+	if !g.PINNonce() {
+		t.Fatal("PINNonce() = false")
+	}
+
+	nonce, err := g.IssueNonce()
+	if err != nil {
+		t.Fatalf("IssueNonce: %v", err)
+	}
+	if !g.UseNonce(nonce) {
+		t.Fatal("fresh nonce refused")
+	}
+	if g.UseNonce(nonce) {
+		t.Error("replayed nonce accepted")
+	}
+	if g.UseNonce("") || g.UseNonce("0123456789abcdef0123456789abcdef") {
+		t.Error("nonce that was never issued accepted")
+	}
+
+	stale, err := g.IssueNonce()
+	if err != nil {
+		t.Fatalf("IssueNonce: %v", err)
+	}
+	*now = now.Add(10 * time.Minute)
+	if g.UseNonce(stale) {
+		t.Error("expired nonce accepted")
+	}
+}
+
+func TestWrapAnswersBlockedAddressesWith429(t *testing.T) {
+	g, _ := newTestGuard(false)
+	served := 0
+	handler := g.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served++ }))
+
+	codes := make([]int, 0, 6)
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 6; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/share/token", nil)
+		req.RemoteAddr = "192.0.2.50:41000"
+		last = httptest.NewRecorder()
+		handler.ServeHTTP(last, req)
+		codes = append(codes, last.Code)
+	}
+	if served != 5 || last.Code != http.StatusTooManyRequests {
+		t.Fatalf("served %d with codes %v, want 5 then 429", served, codes)
+	}
+	if got := last.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+}
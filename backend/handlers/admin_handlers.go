@@ -0,0 +1,619 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/concurrency"
+	"github.com/clarity/backend/events"
+	adminpb "github.com/clarity/backend/gen/go/admin"
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/killswitch"
+	"github.com/clarity/backend/logging"
+	"github.com/clarity/backend/maintenance"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/services"
+	"github.com/clarity/backend/versioning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// adminKeyHeader is the metadata key carrying the admin API key
+const adminKeyHeader = "x-admin-key"
+
+// AdminServer implements the gRPC AdminService
+type AdminServer struct {
+	adminpb.UnimplementedAdminServiceServer
+	apiKey           string
+	searchService    *services.SearchService
+	records          *services.HealthRecordsService
+	auditService     *services.AuditService
+	corrections      *services.CorrectionService
+	deliveries       *services.DeliveryQueue
+	batchLimiter     *jobs.Limiter
+	dataQuality      *services.DataQualityService
+	reprocess        *services.ReprocessService
+	concurrency      *concurrency.Limiter
+	killSwitches     *killswitch.Switches
+	maintenance      *maintenance.Mode
+	versions         *versioning.Registry
+	events           *events.Bus
+	logControl       *logging.Controller
+	maxDebugDuration time.Duration
+}
+
+func NewAdminServer(apiKey string, searchService *services.SearchService, records *services.HealthRecordsService, auditService *services.AuditService, corrections *services.CorrectionService, deliveries *services.DeliveryQueue, batchLimiter *jobs.Limiter, dataQuality *services.DataQualityService, reprocess *services.ReprocessService, concurrencyLimiter *concurrency.Limiter, killSwitches *killswitch.Switches, maintenanceMode *maintenance.Mode, versions *versioning.Registry, bus *events.Bus, logControl *logging.Controller, maxDebugDuration time.Duration) *AdminServer {
+	return &AdminServer{
+		apiKey:           apiKey,
+		searchService:    searchService,
+		records:          records,
+		auditService:     auditService,
+		corrections:      corrections,
+		deliveries:       deliveries,
+		batchLimiter:     batchLimiter,
+		dataQuality:      dataQuality,
+		reprocess:        reprocess,
+		concurrency:      concurrencyLimiter,
+		killSwitches:     killSwitches,
+		maintenance:      maintenanceMode,
+		versions:         versions,
+		events:           bus,
+		logControl:       logControl,
+		maxDebugDuration: maxDebugDuration,
+	}
+}
+
+// requireAdmin checks the caller presented the configured admin key
+func (as *AdminServer) requireAdmin(ctx context.Context) error {
+	if as.apiKey == "" {
+		return status.Error(codes.PermissionDenied, "admin API is disabled")
+	}
+	if !hasAdminKey(ctx, as.apiKey) {
+		return status.Error(codes.PermissionDenied, "admin key required")
+	}
+	return nil
+}
+
+// hasAdminKey reports whether the caller presented apiKey in its metadata.
+// An empty apiKey matches nobody.
+func hasAdminKey(ctx context.Context, apiKey string) bool {
+	if apiKey == "" {
+		return false
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(adminKeyHeader)
+	return len(keys) > 0 && subtle.ConstantTimeCompare([]byte(keys[0]), []byte(apiKey)) == 1
+}
+
+// audit records an admin action; failures are logged but do not fail the call
+func (as *AdminServer) audit(ctx context.Context, action, targetType, targetID, details string) {
+	if err := as.auditService.Record(ctx, services.AuditActorAdmin, action, targetType, targetID, details); err != nil {
+		log.Printf("Failed to audit %s: %v", action, err)
+	}
+}
+
+func (as *AdminServer) ReindexSearch(ctx context.Context, req *adminpb.ReindexSearchRequest) (*adminpb.ReindexSearchResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	var result services.ReindexResult
+	err := as.batchLimiter.Run(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = as.searchService.Reindex(ctx, req.Full)
+		return err
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &adminpb.ReindexSearchResponse{
+		Indexed: int32(result.Indexed),
+		Removed: int32(result.Removed),
+	}, nil
+}
+
+func (as *AdminServer) SetLogLevel(ctx context.Context, req *adminpb.SetLogLevelRequest) (*adminpb.SetLogLevelResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	previous := as.logControl.Level()
+	if err := as.logControl.SetLevel(req.Level); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	as.audit(ctx, services.AuditActionSetLogLevel, "logging", "", fmt.Sprintf("%s -> %s", previous, as.logControl.Level()))
+
+	return &adminpb.SetLogLevelResponse{
+		PreviousLevel: previous,
+		Level:         as.logControl.Level(),
+	}, nil
+}
+
+func (as *AdminServer) EnableDebugLogging(ctx context.Context, req *adminpb.EnableDebugLoggingRequest) (*adminpb.DebugTarget, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if (req.UserId == "") == (req.RequestIdPrefix == "") {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of user_id or request_id_prefix is required")
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if duration <= 0 || duration > as.maxDebugDuration {
+		duration = as.maxDebugDuration
+	}
+
+	target, err := as.logControl.Targets.Add(req.UserId, req.RequestIdPrefix, duration)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	as.audit(ctx, services.AuditActionEnableDebugLogging, "debug_target", target.ID,
+		fmt.Sprintf("user_id=%q request_id_prefix=%q duration=%s reason=%q", req.UserId, req.RequestIdPrefix, duration, req.Reason))
+
+	return debugTargetToProto(target), nil
+}
+
+func (as *AdminServer) DisableDebugLogging(ctx context.Context, req *adminpb.DisableDebugLoggingRequest) (*adminpb.DisableDebugLoggingResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if !as.logControl.Targets.Remove(req.TargetId) {
+		return nil, status.Error(codes.NotFound, "debug target not found or already expired")
+	}
+	as.audit(ctx, services.AuditActionDisableDebugLogging, "debug_target", req.TargetId, "")
+
+	return &adminpb.DisableDebugLoggingResponse{Success: true}, nil
+}
+
+func (as *AdminServer) ListDebugTargets(ctx context.Context, req *adminpb.ListDebugTargetsRequest) (*adminpb.ListDebugTargetsResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	targets := as.logControl.Targets.List()
+	pbTargets := make([]*adminpb.DebugTarget, len(targets))
+	for i, target := range targets {
+		pbTargets[i] = debugTargetToProto(target)
+	}
+
+	return &adminpb.ListDebugTargetsResponse{Targets: pbTargets}, nil
+}
+
+func (as *AdminServer) GetDeliveryStatus(ctx context.Context, req *adminpb.GetDeliveryStatusRequest) (*adminpb.DeliveryStatus, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	delivery, err := as.deliveries.GetDelivery(ctx, req.Reference)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	pbStatus := &adminpb.DeliveryStatus{
+		Reference:   delivery.ID,
+		Channel:     delivery.Channel,
+		Recipient:   delivery.Recipient,
+		Status:      delivery.Status,
+		Attempts:    int32(delivery.Attempts),
+		MaxAttempts: int32(delivery.MaxAttempts),
+		LastError:   delivery.LastError,
+		CreatedAt:   delivery.CreatedAt.Unix(),
+	}
+	if delivery.Status == models.DeliveryStatusPending {
+		pbStatus.NextAttemptAt = delivery.NextAttemptAt.Unix()
+	}
+	if delivery.SentAt != nil {
+		pbStatus.SentAt = delivery.SentAt.Unix()
+	}
+
+	return pbStatus, nil
+}
+
+func (as *AdminServer) GenerateDataQualityReport(ctx context.Context, req *adminpb.GenerateDataQualityReportRequest) (*adminpb.DataQualityReport, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	report, err := as.dataQuality.StartReport(ctx, req.Fix)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	as.audit(ctx, services.AuditActionDataQualityReport, "data_quality_report", report.ID, fmt.Sprintf("fix=%t", req.Fix))
+
+	return dataQualityReportToProto(report, nil), nil
+}
+
+func (as *AdminServer) GetDataQualityReport(ctx context.Context, req *adminpb.GetDataQualityReportRequest) (*adminpb.DataQualityReport, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	report, results, err := as.dataQuality.GetReport(ctx, req.ReportId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return dataQualityReportToProto(report, results), nil
+}
+
+func (as *AdminServer) ListAuditLogs(ctx context.Context, req *adminpb.ListAuditLogsRequest) (*adminpb.ListAuditLogsResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	filter := services.AuditLogFilter{
+		ActorID: req.ActorId,
+		Action:  req.Action,
+	}
+	if req.Since > 0 {
+		filter.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until > 0 {
+		filter.Until = time.Unix(req.Until, 0)
+	}
+
+	entries, next, err := as.auditService.List(ctx, filter, req.Cursor, int(req.Limit))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	pbEntries := make([]*adminpb.AuditLogEntry, len(entries))
+	for i, entry := range entries {
+		pbEntries[i] = &adminpb.AuditLogEntry{
+			Id:         entry.ID,
+			ActorId:    entry.ActorID,
+			Action:     entry.Action,
+			TargetType: entry.TargetType,
+			TargetId:   entry.TargetID,
+			Details:    entry.Details,
+			CreatedAt:  entry.CreatedAt.Unix(),
+		}
+	}
+
+	return &adminpb.ListAuditLogsResponse{Entries: pbEntries, NextCursor: next}, nil
+}
+
+func (as *AdminServer) ListCorrections(ctx context.Context, req *adminpb.ListCorrectionsRequest) (*adminpb.ListCorrectionsResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	filter := services.CorrectionFilter{
+		Field:    req.Field,
+		Provider: req.Provider,
+	}
+	if req.Since > 0 {
+		filter.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until > 0 {
+		filter.Until = time.Unix(req.Until, 0)
+	}
+
+	groups, err := as.corrections.Aggregate(ctx, filter)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	corrections, next, err := as.corrections.List(ctx, filter, req.Cursor, int(req.Limit))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resp := &adminpb.ListCorrectionsResponse{NextCursor: next}
+	for _, group := range groups {
+		resp.Groups = append(resp.Groups, &adminpb.CorrectionGroup{
+			Field:             group.Field,
+			Provider:          group.Provider,
+			ExtractionVersion: int32(group.ExtractionVersion),
+			Corrections:       group.Corrections,
+			ExtractedRecords:  group.ExtractedRecords,
+		})
+	}
+	for _, correction := range corrections {
+		resp.Corrections = append(resp.Corrections, &adminpb.Correction{
+			Id:                correction.ID,
+			RecordId:          correction.RecordID,
+			ScanId:            correction.ScanID,
+			Field:             correction.Field,
+			OriginalValue:     correction.OriginalValue,
+			CorrectedValue:    correction.CorrectedValue,
+			Provider:          correction.Provider,
+			ExtractionVersion: int32(correction.ExtractionVersion),
+			CreatedAt:         correction.CreatedAt.Unix(),
+		})
+	}
+	return resp, nil
+}
+
+func (as *AdminServer) StartReprocessJob(ctx context.Context, req *adminpb.StartReprocessJobRequest) (*adminpb.ReprocessJob, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	cohort := services.ReprocessCohort{
+		RecordType:   req.RecordType,
+		BelowVersion: int(req.BelowVersion),
+	}
+	if req.CreatedFrom > 0 {
+		cohort.CreatedFrom = time.Unix(req.CreatedFrom, 0)
+	}
+	if req.CreatedTo > 0 {
+		cohort.CreatedTo = time.Unix(req.CreatedTo, 0)
+	}
+
+	job, err := as.reprocess.StartJob(ctx, cohort)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	as.audit(ctx, services.AuditActionReprocessStart, "reprocess_job", job.ID,
+		fmt.Sprintf("record_type=%s below_version=%d", job.RecordType, job.BelowVersion))
+
+	return reprocessJobToProto(job), nil
+}
+
+func (as *AdminServer) GetReprocessJob(ctx context.Context, req *adminpb.GetReprocessJobRequest) (*adminpb.ReprocessJob, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	job, err := as.reprocess.GetJob(ctx, req.JobId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return reprocessJobToProto(job), nil
+}
+
+func (as *AdminServer) GetConcurrencyStats(ctx context.Context, req *adminpb.GetConcurrencyStatsRequest) (*adminpb.ConcurrencyStats, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := &adminpb.ConcurrencyStats{}
+	for _, class := range as.concurrency.Stats() {
+		stats.Classes = append(stats.Classes, &adminpb.ConcurrencyClass{
+			Class:    class.Class,
+			Limit:    int32(class.Limit),
+			InFlight: class.InFlight,
+			Waiting:  class.Waiting,
+			Rejected: class.Rejected,
+		})
+	}
+	return stats, nil
+}
+
+func (as *AdminServer) SetEndpointEnabled(ctx context.Context, req *adminpb.SetEndpointEnabledRequest) (*adminpb.SetEndpointEnabledResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if req.Enabled {
+		method, changed, err := as.killSwitches.Enable(req.Method)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if changed {
+			as.audit(ctx, services.AuditActionEndpointEnable, "endpoint", method, "")
+		}
+		return &adminpb.SetEndpointEnabledResponse{Method: method, Enabled: true, Changed: changed}, nil
+	}
+
+	sw, changed, err := as.killSwitches.Disable(req.Method, req.Reason)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	as.audit(ctx, services.AuditActionEndpointDisable, "endpoint", sw.Method, fmt.Sprintf("reason=%q", req.Reason))
+	return &adminpb.SetEndpointEnabledResponse{Method: sw.Method, Enabled: false, Changed: changed}, nil
+}
+
+func (as *AdminServer) ListDisabledEndpoints(ctx context.Context, req *adminpb.ListDisabledEndpointsRequest) (*adminpb.ListDisabledEndpointsResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	switches := as.killSwitches.List()
+	endpoints := make([]*adminpb.DisabledEndpoint, len(switches))
+	for i, sw := range switches {
+		endpoints[i] = &adminpb.DisabledEndpoint{
+			Method:     sw.Method,
+			Reason:     sw.Reason,
+			DisabledAt: sw.DisabledAt.Unix(),
+		}
+	}
+	return &adminpb.ListDisabledEndpointsResponse{Endpoints: endpoints}, nil
+}
+
+func (as *AdminServer) SetMaintenanceMode(ctx context.Context, req *adminpb.SetMaintenanceModeRequest) (*adminpb.MaintenanceMode, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.RetryAfterSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "retry_after_seconds must not be negative")
+	}
+
+	if !req.Enabled {
+		if as.maintenance.Exit() {
+			as.audit(ctx, services.AuditActionMaintenanceExit, "server", "", "")
+			log.Printf("Maintenance mode off")
+		}
+		return maintenanceToProto(as.maintenance.State()), nil
+	}
+
+	// Audit before entering, while writes are still accepted
+	as.audit(ctx, services.AuditActionMaintenanceEnter, "server", "", fmt.Sprintf("reason=%q", req.Reason))
+	state, inFlight := as.maintenance.Enter(ctx, req.Reason, time.Duration(req.RetryAfterSeconds)*time.Second)
+	log.Printf("Maintenance mode on (%d writes still running): %s", inFlight, state.Reason)
+	return maintenanceToProto(state, inFlight), nil
+}
+
+func (as *AdminServer) GetMaintenanceMode(ctx context.Context, req *adminpb.GetMaintenanceModeRequest) (*adminpb.MaintenanceMode, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return maintenanceToProto(as.maintenance.State()), nil
+}
+
+func (as *AdminServer) GetDeprecationUsage(ctx context.Context, req *adminpb.GetDeprecationUsageRequest) (*adminpb.DeprecationUsage, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &adminpb.DeprecationUsage{}
+	for _, usage := range as.versions.Usage() {
+		resp.Fields = append(resp.Fields, &adminpb.DeprecatedFieldUsage{
+			Method:        usage.Method,
+			Field:         usage.Field,
+			ClientVersion: usage.ClientVersion,
+			ApiVersion:    int32(usage.APIVersion),
+			Count:         usage.Count,
+			LastSeen:      usage.LastSeen.Unix(),
+		})
+	}
+	for _, rejection := range as.versions.Rejections() {
+		resp.Rejections = append(resp.Rejections, &adminpb.ClientVersionRejection{
+			Method:        rejection.Method,
+			ClientVersion: rejection.ClientVersion,
+			Count:         rejection.Count,
+			LastSeen:      rejection.LastSeen.Unix(),
+		})
+	}
+	for _, minimum := range as.versions.Minimums() {
+		resp.Minimums = append(resp.Minimums, &adminpb.MinimumClientVersion{
+			Method:  minimum.Method,
+			Version: minimum.Version.String(),
+		})
+	}
+	return resp, nil
+}
+
+func (as *AdminServer) GetEventStats(ctx context.Context, req *adminpb.GetEventStatsRequest) (*adminpb.EventStats, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &adminpb.EventStats{}
+	for _, stats := range as.events.Stats() {
+		resp.Subscribers = append(resp.Subscribers, &adminpb.EventSubscriberStats{
+			Event:      stats.Event,
+			Subscriber: stats.Subscriber,
+			Delivered:  stats.Delivered,
+			Failed:     stats.Failed,
+		})
+	}
+	return resp, nil
+}
+
+func maintenanceToProto(state maintenance.State, inFlight int) *adminpb.MaintenanceMode {
+	pbMode := &adminpb.MaintenanceMode{
+		Enabled:           state.Enabled,
+		Reason:            state.Reason,
+		RetryAfterSeconds: int32(state.RetryAfter / time.Second),
+		InFlightWrites:    int32(inFlight),
+	}
+	if state.Enabled {
+		pbMode.Since = state.Since.Unix()
+	}
+	return pbMode
+}
+
+func reprocessJobToProto(job *models.ReprocessJob) *adminpb.ReprocessJob {
+	pbJob := &adminpb.ReprocessJob{
+		Id:           job.ID,
+		Status:       job.Status,
+		RecordType:   job.RecordType,
+		BelowVersion: int32(job.BelowVersion),
+		Improved:     int32(job.Improved),
+		Unchanged:    int32(job.Unchanged),
+		Skipped:      int32(job.Skipped),
+		Failed:       int32(job.Failed),
+		CreatedAt:    job.CreatedAt.Unix(),
+	}
+	if job.CompletedAt != nil {
+		pbJob.CompletedAt = job.CompletedAt.Unix()
+	}
+	return pbJob
+}
+
+func dataQualityReportToProto(report *models.DataQualityReport, results []services.DataQualityResult) *adminpb.DataQualityReport {
+	pbReport := &adminpb.DataQualityReport{
+		Id:        report.ID,
+		Status:    report.Status,
+		Fix:       report.Fix,
+		Error:     report.Error,
+		CreatedAt: report.CreatedAt.Unix(),
+	}
+	if report.CompletedAt != nil {
+		pbReport.CompletedAt = report.CompletedAt.Unix()
+	}
+	for _, result := range results {
+		pbReport.Checks = append(pbReport.Checks, &adminpb.DataQualityCheckResult{
+			Name:        result.Name,
+			Description: result.Description,
+			Affected:    int32(result.Affected),
+			SampleIds:   result.SampleIDs,
+			Fixed:       int32(result.Fixed),
+			Error:       result.Error,
+		})
+	}
+	return pbReport
+}
+
+func debugTargetToProto(target logging.DebugTarget) *adminpb.DebugTarget {
+	return &adminpb.DebugTarget{
+		Id:              target.ID,
+		UserId:          target.UserID,
+		RequestIdPrefix: target.RequestIDPrefix,
+		ExpiresAt:       target.ExpiresAt.Unix(),
+	}
+}
+
+func (as *AdminServer) ListDeletedRecords(ctx context.Context, req *adminpb.ListDeletedRecordsRequest) (*adminpb.ListDeletedRecordsResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	records, total, err := as.records.ListDeletedRecords(ctx, req.UserId, int(req.Limit), int(req.Offset))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resp := &adminpb.ListDeletedRecordsResponse{Total: int32(total)}
+	for _, record := range records {
+		resp.Records = append(resp.Records, &adminpb.DeletedRecord{
+			Id:         record.ID,
+			UserId:     record.UserID,
+			RecordType: record.RecordType,
+			CreatedAt:  record.CreatedAt.Unix(),
+			DeletedAt:  record.DeletedAt.Time.Unix(),
+		})
+	}
+	return resp, nil
+}
+
+func (as *AdminServer) PurgeDeletedRecords(ctx context.Context, req *adminpb.PurgeDeletedRecordsRequest) (*adminpb.PurgeDeletedRecordsResponse, error) {
+	if err := as.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.OlderThanSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "older_than_seconds cannot be negative")
+	}
+
+	var purged int
+	err := as.batchLimiter.Run(ctx, func(ctx context.Context) error {
+		var err error
+		if req.OlderThanSeconds == 0 {
+			purged, err = as.records.PurgeDeletedRecords(ctx)
+		} else {
+			purged, err = as.records.PurgeDeletedOlderThan(ctx, time.Duration(req.OlderThanSeconds)*time.Second)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	as.audit(ctx, services.AuditActionPurgeDeleted, "records", "", fmt.Sprintf("older_than=%ds purged=%d", req.OlderThanSeconds, purged))
+	return &adminpb.PurgeDeletedRecordsResponse{Purged: int32(purged)}, nil
+}
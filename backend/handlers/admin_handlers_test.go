@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	adminpb "github.com/clarity/backend/gen/go/admin"
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/killswitch"
+	"github.com/clarity/backend/middleware"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// withAdminKey returns ctx carrying key the way a gRPC client sends it
+func withAdminKey(ctx context.Context, key string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(adminKeyHeader, key))
+}
+
+func TestListAuditLogsRequiresAdmin(t *testing.T) {
+	db := newTestDB(t)
+	audit := services.NewAuditService(db)
+	if err := audit.Record(context.Background(), "user-1", services.AuditActionLogin, "user", "user-1", ""); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		apiKey string
+		ctx    context.Context
+		want   codes.Code
+	}{
+		{"admin key", "s3cret", withAdminKey(context.Background(), "s3cret"), codes.OK},
+		{"wrong key", "s3cret", withAdminKey(context.Background(), "guess"), codes.PermissionDenied},
+		{"no key", "s3cret", context.Background(), codes.PermissionDenied},
+		{"signed-in user", "s3cret", middleware.WithUserID(context.Background(), "user-1"), codes.PermissionDenied},
+		{"admin API disabled", "", withAdminKey(context.Background(), ""), codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &AdminServer{apiKey: tt.apiKey, auditService: audit}
+			resp, err := server.ListAuditLogs(tt.ctx, &adminpb.ListAuditLogsRequest{ActorId: "user-1"})
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("ListAuditLogs() code = %v, want %v (%v)", got, tt.want, err)
+			}
+			if tt.want == codes.OK && len(resp.Entries) != 1 {
+				t.Errorf("listed %d entries, want 1", len(resp.Entries))
+			}
+		})
+	}
+}
+
+func TestSetEndpointEnabled(t *testing.T) {
+	db := newTestDB(t)
+	audit := services.NewAuditService(db)
+	switches := killswitch.NewSwitches()
+	switches.Register(map[string]grpc.ServiceInfo{
+		"clarity.ai.AIService": {Methods: []grpc.MethodInfo{{Name: "ScanPrescription"}}},
+	})
+	server := &AdminServer{apiKey: "s3cret", auditService: audit, killSwitches: switches}
+	ctx := withAdminKey(context.Background(), "s3cret")
+	const scan = "/clarity.ai.AIService/ScanPrescription"
+
+	if _, err := server.SetEndpointEnabled(context.Background(), &adminpb.SetEndpointEnabledRequest{Method: "ScanPrescription"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("without the admin key: code = %v, want PermissionDenied", status.Code(err))
+	}
+	if len(switches.List()) != 0 {
+		t.Fatal("endpoint disabled without the admin key")
+	}
+
+	resp, err := server.SetEndpointEnabled(ctx, &adminpb.SetEndpointEnabledRequest{Method: "ScanPrescription", Reason: "provider outage"})
+	if err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if resp.Method != scan || resp.Enabled || !resp.Changed {
+		t.Errorf("disable response = %+v", resp)
+	}
+	listed, err := server.ListDisabledEndpoints(ctx, &adminpb.ListDisabledEndpointsRequest{})
+	if err != nil || len(listed.Endpoints) != 1 || listed.Endpoints[0].Reason != "provider outage" {
+		t.Fatalf("ListDisabledEndpoints = %+v, %v", listed, err)
+	}
+
+	if _, err := server.SetEndpointEnabled(ctx, &adminpb.SetEndpointEnabledRequest{Method: "Frobnicate"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unknown method: code = %v, want InvalidArgument", status.Code(err))
+	}
+
+	resp, err = server.SetEndpointEnabled(ctx, &adminpb.SetEndpointEnabledRequest{Method: scan, Enabled: true})
+	if err != nil || !resp.Enabled || !resp.Changed {
+		t.Fatalf("enable = %+v, %v", resp, err)
+	}
+	if resp, _ := server.SetEndpointEnabled(ctx, &adminpb.SetEndpointEnabledRequest{Method: scan, Enabled: true}); resp.Changed {
+		t.Error("enabling an enabled endpoint reported a change")
+	}
+
+	for action, want := range map[string]int{services.AuditActionEndpointDisable: 1, services.AuditActionEndpointEnable: 1} {
+		entries, _, err := audit.List(context.Background(), services.AuditLogFilter{Action: action}, "", 10)
+		if err != nil || len(entries) != want || entries[0].TargetID != scan {
+			t.Errorf("%s audit entries = %+v, %v; want %d for %s", action, entries, err, want, scan)
+		}
+	}
+}
+
+func TestPurgeDeletedRecords(t *testing.T) {
+	db := newTestDB(t)
+	audit := services.NewAuditService(db)
+	records := services.NewHealthRecordsService(db, &config.RecordsConfig{DeletedRetention: 24 * 3600}, nil, nil)
+	server := &AdminServer{apiKey: "s3cret", auditService: audit, records: records, batchLimiter: jobs.NewLimiter(1, 0, time.Second)}
+	ctx := withAdminKey(context.Background(), "s3cret")
+
+	db.Create(&models.User{ID: "user-1", Email: "user-1@example.com"})
+	for id, age := range map[string]time.Duration{"week-old": 7 * 24 * time.Hour, "two-days": 48 * time.Hour, "hour-old": time.Hour} {
+		record, err := records.CreateRecord(context.Background(), "user-1", "lab_result", id, "", nil)
+		if err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		if err := records.DeleteRecord(context.Background(), "user-1", record.ID); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+		db.Unscoped().Model(&models.HealthRecord{}).Where("id = ?", record.ID).Update("deleted_at", time.Now().Add(-age))
+	}
+
+	if _, err := server.PurgeDeletedRecords(context.Background(), &adminpb.PurgeDeletedRecordsRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("without the admin key: code = %v, want PermissionDenied", status.Code(err))
+	}
+	if _, err := server.PurgeDeletedRecords(ctx, &adminpb.PurgeDeletedRecordsRequest{OlderThanSeconds: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("negative age: code = %v, want InvalidArgument", status.Code(err))
+	}
+
+	tests := []struct {
+		name      string
+		olderThan int64
+		want      int32
+	}{
+		{"explicit cutoff", 3 * 24 * 3600, 1},
+		{"configured retention", 0, 1},
+		{"nothing old enough", 0, 0},
+	}
+	for _, tt := range tests {
+		resp, err := server.PurgeDeletedRecords(ctx, &adminpb.PurgeDeletedRecordsRequest{OlderThanSeconds: tt.olderThan})
+		if err != nil || resp.Purged != tt.want {
+			t.Errorf("%s: purged %v, %v; want %d", tt.name, resp, err, tt.want)
+		}
+	}
+	if _, total, _ := records.ListDeletedRecords(context.Background(), "", 10, 0); total != 1 {
+		t.Errorf("%d records left in the trash, want the hour-old one", total)
+	}
+
+	entries, _, err := audit.List(context.Background(), services.AuditLogFilter{Action: services.AuditActionPurgeDeleted}, "", 10)
+	if err != nil || len(entries) != 3 {
+		t.Errorf("purge audit entries = %d, %v; want 3", len(entries), err)
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/clarity/backend/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// callerID returns the user the call's access token was issued to.
+// Requests may still name their user in user_id, but only themselves.
+func callerID(ctx context.Context, requested string) (string, error) {
+	userID, ok := middleware.UserID(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "access token required")
+	}
+	if requested != "" && requested != userID {
+		return "", status.Error(codes.PermissionDenied, "user_id does not match the access token")
+	}
+	return userID, nil
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clarity/backend/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCallerID(t *testing.T) {
+	authenticated := middleware.WithUserID(context.Background(), "user-1")
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		requested string
+		want      codes.Code
+	}{
+		{"no user_id", authenticated, "", codes.OK},
+		{"own user_id", authenticated, "user-1", codes.OK},
+		{"someone else's user_id", authenticated, "user-2", codes.PermissionDenied},
+		{"not authenticated", context.Background(), "", codes.Unauthenticated},
+		{"not authenticated, naming a user", context.Background(), "user-1", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := callerID(tt.ctx, tt.requested)
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("code = %v, want %v (%v)", got, tt.want, err)
+			}
+			if tt.want == codes.OK && userID != "user-1" {
+				t.Errorf("caller = %q, want user-1", userID)
+			}
+		})
+	}
+}
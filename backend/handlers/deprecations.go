@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"github.com/clarity/backend/versioning"
+	"google.golang.org/grpc"
+)
+
+// RegisterDeprecations registers the request fields being phased out, so
+// AdminService.GetDeprecationUsage shows which client versions still rely
+// on them. Add a field here a release before changing or removing it.
+func RegisterDeprecations(registry *versioning.Registry, services map[string]grpc.ServiceInfo) {
+	// The caller will be taken from the access token instead
+	registry.DeprecateField(services, "user_id")
+}
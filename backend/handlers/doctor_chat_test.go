@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/concurrency"
+	"github.com/clarity/backend/config"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	"github.com/clarity/backend/middleware"
+	"github.com/clarity/backend/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// chatStream is a DoctorChat stream whose client sends requests and then
+// closes its side
+type chatStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests chan *aipb.DoctorChatRequest
+
+	mu        sync.Mutex
+	responses []*aipb.DoctorChatResponse
+}
+
+func newChatStream(userID string, requests ...*aipb.DoctorChatRequest) *chatStream {
+	ctx := context.Background()
+	if userID != "" {
+		ctx = middleware.WithUserID(ctx, userID)
+	}
+	s := &chatStream{ctx: ctx, requests: make(chan *aipb.DoctorChatRequest, len(requests))}
+	for _, req := range requests {
+		s.requests <- req
+	}
+	close(s.requests)
+	return s
+}
+
+func (s *chatStream) Context() context.Context { return s.ctx }
+
+func (s *chatStream) Recv() (*aipb.DoctorChatRequest, error) {
+	req, ok := <-s.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (s *chatStream) Send(response *aipb.DoctorChatResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, response)
+	return nil
+}
+
+// runDoctorChat serves stream, failing the test if it does not end in time
+func runDoctorChat(t *testing.T, server *AIServer, stream *chatStream) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- server.DoctorChat(stream) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("DoctorChat did not finish")
+		return nil
+	}
+}
+
+func chatRequest(conversationID, message string) *aipb.DoctorChatRequest {
+	return &aipb.DoctorChatRequest{ConversationId: conversationID, Message: message}
+}
+
+func TestDoctorChatReportsFailedTurns(t *testing.T) {
+	server := &AIServer{chatConcurrency: 2, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		switch message {
+		case "missing":
+			return "", false, fmt.Errorf("conversation %s: %w", conversationID, services.ErrNotFound)
+		case "refused":
+			return "", false, services.ErrContentRefused
+		}
+		return "re: " + message, message == "degraded", nil
+	}}
+	stream := newChatStream("user-1",
+		chatRequest("c1", "hello"),
+		chatRequest("c2", "missing"),
+		chatRequest("c3", "refused"),
+		chatRequest("c1", "degraded"),
+	)
+
+	if err := runDoctorChat(t, server, stream); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if len(stream.responses) != 4 {
+		t.Fatalf("%d replies, want one per message: %+v", len(stream.responses), stream.responses)
+	}
+	if r := stream.responses[0]; r.Response != "re: hello" || r.Error != "" || r.ConversationId != "c1" {
+		t.Errorf("reply to hello = %+v", r)
+	}
+	// The failed turn is answered with its error, and the stream goes on
+	if r := stream.responses[1]; r.ErrorCode != codes.NotFound.String() || r.Error == "" || r.Response != "" || r.ConversationId != "c2" {
+		t.Errorf("reply to the failed turn = %+v, want a NotFound error", r)
+	}
+	if r := stream.responses[2]; !r.Refused || r.Response != services.RefusalMessage || r.Error != "" {
+		t.Errorf("reply to the refused turn = %+v, want a refusal", r)
+	}
+	if r := stream.responses[3]; r.Response != "re: degraded" || !r.Degraded {
+		t.Errorf("reply to the last turn = %+v", r)
+	}
+}
+
+func TestDoctorChatAnswersConcurrentlyInOrder(t *testing.T) {
+	// The first message is only answered once the second has started, so
+	// the stream ends only if turns run at the same time
+	secondStarted := make(chan struct{})
+	server := &AIServer{chatConcurrency: 2, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		switch message {
+		case "first":
+			select {
+			case <-secondStarted:
+			case <-time.After(2 * time.Second):
+				return "", false, fmt.Errorf("the second turn never started")
+			}
+		case "second":
+			close(secondStarted)
+		}
+		return "re: " + message, false, nil
+	}}
+	stream := newChatStream("user-1", chatRequest("c1", "first"), chatRequest("c2", "second"))
+
+	if err := runDoctorChat(t, server, stream); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	var got []string
+	for _, r := range stream.responses {
+		got = append(got, r.Response+r.Error)
+	}
+	if len(got) != 2 || got[0] != "re: first" || got[1] != "re: second" {
+		t.Errorf("replies = %q, want the first message's reply first", got)
+	}
+}
+
+func TestDoctorChatLimitsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	server := &AIServer{chatConcurrency: 2, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return message, false, nil
+	}}
+	var requests []*aipb.DoctorChatRequest
+	for i := 0; i < 8; i++ {
+		requests = append(requests, chatRequest(fmt.Sprintf("c%d", i), fmt.Sprint(i)))
+	}
+	stream := newChatStream("user-1", requests...)
+
+	if err := runDoctorChat(t, server, stream); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if most > 2 {
+		t.Errorf("%d turns answered at once, want at most 2", most)
+	}
+	for i, r := range stream.responses {
+		if r.Response != fmt.Sprint(i) {
+			t.Errorf("reply %d = %q, want %q", i, r.Response, fmt.Sprint(i))
+		}
+	}
+}
+
+// limitedChat serves stream through the limiter's stream interceptor, the
+// way the server chains it
+func limitedChat(t *testing.T, server *AIServer, limiter *concurrency.Limiter, stream *chatStream) error {
+	t.Helper()
+	info := &grpc.StreamServerInfo{FullMethod: "/clarity.ai.AIService/DoctorChat", IsClientStream: true, IsServerStream: true}
+	done := make(chan error, 1)
+	go func() {
+		done <- limiter.StreamServerInterceptor()(nil, stream, info, func(srv interface{}, ss grpc.ServerStream) error {
+			stream.ctx = ss.Context()
+			return server.DoctorChat(stream)
+		})
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("DoctorChat did not finish")
+		return nil
+	}
+}
+
+func TestDoctorChatTurnsHoldAIPermits(t *testing.T) {
+	limiter := concurrency.NewLimiter(&config.ConcurrencyConfig{AI: 1, MaxQueued: 8, QueueTimeout: 2000})
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	server := &AIServer{chatConcurrency: 4, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return message, false, nil
+	}}
+	var requests []*aipb.DoctorChatRequest
+	for i := 0; i < 4; i++ {
+		requests = append(requests, chatRequest(fmt.Sprintf("c%d", i), fmt.Sprint(i)))
+	}
+
+	if err := limitedChat(t, server, limiter, newChatStream("user-1", requests...)); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	// The stream allows four turns at once, the AI class only one
+	if most != 1 {
+		t.Errorf("%d turns answered at once, want the AI limit of 1", most)
+	}
+	for _, stats := range limiter.Stats() {
+		if stats.Class == concurrency.ClassAI && stats.InFlight != 0 {
+			t.Errorf("%d AI permits held after the stream ended", stats.InFlight)
+		}
+	}
+}
+
+func TestDoctorChatTurnFindsTheAIClassFull(t *testing.T) {
+	limiter := concurrency.NewLimiter(&config.ConcurrencyConfig{AI: 1})
+	unblock := make(chan struct{})
+	server := &AIServer{chatConcurrency: 2, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		if message == "slow" {
+			<-unblock
+		}
+		return "re: " + message, false, nil
+	}}
+	stream := newChatStream("user-1")
+	requests := make(chan *aipb.DoctorChatRequest)
+	stream.requests = requests
+	go func() {
+		requests <- chatRequest("c1", "slow")
+		// The slow turn holds the only permit while the next one arrives
+		for !aiInFlight(limiter) {
+			time.Sleep(time.Millisecond)
+		}
+		requests <- chatRequest("c2", "crowded")
+		time.Sleep(50 * time.Millisecond)
+		close(unblock)
+		requests <- chatRequest("c1", "after")
+		close(requests)
+	}()
+
+	if err := limitedChat(t, server, limiter, stream); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if len(stream.responses) != 3 {
+		t.Fatalf("%d replies, want 3", len(stream.responses))
+	}
+	// The turn that found no permit is told so, and the stream carries on
+	if r := stream.responses[1]; r.ErrorCode != codes.ResourceExhausted.String() {
+		t.Errorf("reply to the turn with the class full = %+v, want ResourceExhausted", r)
+	}
+	if r := stream.responses[2]; r.Response != "re: after" {
+		t.Errorf("reply after the refused turn = %+v", r)
+	}
+}
+
+// aiInFlight reports whether an AI permit is held
+func aiInFlight(limiter *concurrency.Limiter) bool {
+	for _, stats := range limiter.Stats() {
+		if stats.Class == concurrency.ClassAI {
+			return stats.InFlight > 0
+		}
+	}
+	return false
+}
+
+func TestDoctorChatAnswersOneConversationInTurn(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	active := map[string]bool{}
+	overlapped := false
+	server := &AIServer{chatConcurrency: 4, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		mu.Lock()
+		if active[conversationID] {
+			overlapped = true
+		}
+		active[conversationID] = true
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active[conversationID] = false
+		order = append(order, message)
+		mu.Unlock()
+		return message, false, nil
+	}}
+	stream := newChatStream("user-1", chatRequest("c1", "a"), chatRequest("c1", "b"), chatRequest("c1", "c"))
+
+	if err := runDoctorChat(t, server, stream); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if overlapped {
+		t.Error("two turns of one conversation were answered at once")
+	}
+	if fmt.Sprint(order) != "[a b c]" {
+		t.Errorf("turns answered in order %v, want [a b c]", order)
+	}
+}
+
+func TestDoctorChatStreamsChunksBeforeTheReply(t *testing.T) {
+	server := &AIServer{chatConcurrency: 2, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		if onChunk != nil {
+			onChunk(message + "-1")
+			onChunk(message + "-2")
+		}
+		return message, false, nil
+	}}
+	streamed := chatRequest("c1", "x")
+	streamed.Stream = true
+	stream := newChatStream("user-1", streamed, chatRequest("c2", "y"))
+
+	if err := runDoctorChat(t, server, stream); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	var got []string
+	for _, r := range stream.responses {
+		got = append(got, fmt.Sprintf("%s/%t", r.Response, r.IsPartial))
+	}
+	if fmt.Sprint(got) != "[x-1/true x-2/true x/false y/false]" {
+		t.Errorf("responses = %v, want the chunks of x, then x, then y", got)
+	}
+}
+
+func TestDoctorChatRequiresACaller(t *testing.T) {
+	server := &AIServer{chatConcurrency: 2, chat: func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error) {
+		return message, false, nil
+	}}
+
+	err := runDoctorChat(t, server, newChatStream("", chatRequest("c1", "hello")))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("DoctorChat without an access token: %v, want %v", err, codes.Unauthenticated)
+	}
+	err = runDoctorChat(t, server, newChatStream("user-1", &aipb.DoctorChatRequest{UserId: "user-2", ConversationId: "c1", Message: "hello"}))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DoctorChat for another user: %v, want %v", err, codes.PermissionDenied)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/services"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReauthRequiredReason is the ErrorInfo reason on Unauthenticated errors
+// for a session that has ended, such as by going idle. Refreshing cannot
+// fix it; the client has to sign in again with a new code.
+const ReauthRequiredReason = "REAUTH_REQUIRED"
+
+const errorDomain = "clarity"
+
+// toStatusError maps service-layer sentinel errors to gRPC status errors.
+// Unrecognized errors are returned as codes.Internal.
+func toStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, services.ErrReauthRequired):
+		return reauthRequired(err)
+	case errors.Is(err, services.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, services.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, services.ErrAlreadyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, services.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrMetadataTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrPINRequired):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, services.ErrLocked):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, jobs.ErrAtCapacity):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, services.ErrOTPDailyCapReached):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, services.ErrOTPRateLimited):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, services.ErrOTPAttempts):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, services.ErrShareLimitReached):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, services.ErrImageQuality):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrInvalidAudio):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrAudioTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrContentRefused):
+		return status.Error(codes.FailedPrecondition, services.RefusalMessage)
+	case errors.Is(err, services.ErrProviderNotConfigured):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrProviderAuth):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, services.ErrAccessReasonRequired):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrSyncTokenExpired):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// reauthRequired builds the error for a session the user must sign in to
+// again, telling it apart from other Unauthenticated errors
+func reauthRequired(err error) error {
+	st := status.New(codes.Unauthenticated, err.Error())
+	if detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: ReauthRequiredReason,
+		Domain: errorDomain,
+	}); detailErr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
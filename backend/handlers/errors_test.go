@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/services"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatusError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"not found", fmt.Errorf("%w: record r1", services.ErrNotFound), codes.NotFound},
+		{"permission denied", services.ErrPermissionDenied, codes.PermissionDenied},
+		{"invalid argument", services.ErrInvalidArgument, codes.InvalidArgument},
+		{"metadata too large", fmt.Errorf("%w: 5 keys (max 4)", services.ErrMetadataTooLarge), codes.InvalidArgument},
+		{"access reason required", services.ErrAccessReasonRequired, codes.FailedPrecondition},
+		{"content refused", fmt.Errorf("%w: fake: reply was a refusal", services.ErrContentRefused), codes.FailedPrecondition},
+		{"daily OTP cap", services.ErrOTPDailyCapReached, codes.ResourceExhausted},
+		{"OTP send rate", services.ErrOTPRateLimited, codes.ResourceExhausted},
+		{"too many wrong codes", services.ErrOTPAttempts, codes.ResourceExhausted},
+		{"batch jobs at capacity", jobs.ErrAtCapacity, codes.ResourceExhausted},
+		{"provider not configured", &services.ProviderConfigError{Provider: "openai", EnvVar: "AI_API_KEY", Err: services.ErrProviderNotConfigured}, codes.FailedPrecondition},
+		{"provider rejected its key", &services.ProviderConfigError{Provider: "openai", EnvVar: "AI_API_KEY", Err: services.ErrProviderAuth}, codes.FailedPrecondition},
+		{"cancelled", fmt.Errorf("failed to list records: %w", context.Canceled), codes.Canceled},
+		{"deadline exceeded", fmt.Errorf("failed to list records: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{"already a status", status.Error(codes.Aborted, "conflict"), codes.Aborted},
+		{"unrecognized", fmt.Errorf("disk full"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(toStatusError(tt.err)); got != tt.want {
+				t.Errorf("toStatusError() code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if toStatusError(nil) != nil {
+		t.Error("toStatusError(nil) != nil")
+	}
+}
+
+func TestRefusalShowsTheGenericMessage(t *testing.T) {
+	err := toStatusError(fmt.Errorf("%w: fake: reply was a refusal", services.ErrContentRefused))
+	if msg := status.Convert(err).Message(); msg != services.RefusalMessage {
+		t.Errorf("refusal message = %q, want %q", msg, services.RefusalMessage)
+	}
+}
+
+func TestProviderConfigErrorNamesTheSetting(t *testing.T) {
+	err := toStatusError(fmt.Errorf("chat failed: %w", &services.ProviderConfigError{Provider: "gemini", EnvVar: "GEMINI_API_KEY", Err: services.ErrProviderAuth}))
+	if msg := status.Convert(err).Message(); !strings.Contains(msg, "GEMINI_API_KEY") {
+		t.Errorf("status message %q does not name GEMINI_API_KEY", msg)
+	}
+}
+
+func TestReauthRequiredHasItsOwnReason(t *testing.T) {
+	reasonOf := func(err error) string {
+		for _, detail := range status.Convert(err).Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				return info.Reason
+			}
+		}
+		return ""
+	}
+
+	err := toStatusError(fmt.Errorf("%w: session was inactive too long, sign in again", services.ErrReauthRequired))
+	if status.Code(err) != codes.Unauthenticated || reasonOf(err) != ReauthRequiredReason {
+		t.Errorf("re-auth required = %v with reason %q, want Unauthenticated with %q", err, reasonOf(err), ReauthRequiredReason)
+	}
+	if !strings.Contains(status.Convert(err).Message(), "inactive too long") {
+		t.Errorf("message = %q, want the service's explanation", status.Convert(err).Message())
+	}
+
+	// Other sign-in failures can be fixed by refreshing, and carry no reason
+	err = toStatusError(fmt.Errorf("%w: token expired", services.ErrUnauthenticated))
+	if status.Code(err) != codes.Unauthenticated || reasonOf(err) != "" {
+		t.Errorf("unauthenticated = %v with reason %q, want no reason", err, reasonOf(err))
+	}
+}
@@ -2,13 +2,22 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/clarity/backend/concurrency"
+	aipb "github.com/clarity/backend/gen/go/ai"
 	authpb "github.com/clarity/backend/gen/go/auth"
 	healthpb "github.com/clarity/backend/gen/go/health"
-	aipb "github.com/clarity/backend/gen/go/ai"
+	"github.com/clarity/backend/models"
 	"github.com/clarity/backend/services"
+	"google.golang.org/grpc/status"
 )
 
 // AuthServer implements the gRPC AuthService
@@ -22,7 +31,10 @@ func NewAuthServer(authService *services.AuthService) *AuthServer {
 }
 
 func (as *AuthServer) SendOTP(ctx context.Context, req *authpb.SendOTPRequest) (*authpb.SendOTPResponse, error) {
-	otp, err := as.authService.SendOTP(req.Email)
+	reference, channel, err := as.authService.SendOTP(ctx, req.Email)
+	if errors.Is(err, services.ErrOTPDailyCapReached) || errors.Is(err, services.ErrOTPRateLimited) || errors.Is(err, services.ErrOTPAttempts) {
+		return nil, toStatusError(err)
+	}
 	if err != nil {
 		return &authpb.SendOTPResponse{
 			Success: false,
@@ -30,17 +42,60 @@ func (as *AuthServer) SendOTP(ctx context.Context, req *authpb.SendOTPRequest) (
 		}, nil
 	}
 
-	// For testing only - in production, remove this
-	log.Printf("OTP sent to %s: %s", req.Email, otp)
-
 	return &authpb.SendOTPResponse{
-		Success: true,
-		Message: "OTP sent to email",
+		Success:           true,
+		Message:           "OTP sent via " + channel,
+		DeliveryReference: reference,
+		Channel:           channel,
 	}, nil
 }
 
+func (as *AuthServer) SetOTPChannel(ctx context.Context, req *authpb.SetOTPChannelRequest) (*authpb.SetOTPChannelResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := as.authService.SetOTPChannel(ctx, userID, req.Channel, req.Phone)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &authpb.SetOTPChannelResponse{Channel: user.OTPChannel}, nil
+}
+
+func (as *AuthServer) EnrollTOTP(ctx context.Context, req *authpb.EnrollTOTPRequest) (*authpb.EnrollTOTPResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, uri, err := as.authService.EnrollTOTP(ctx, userID)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &authpb.EnrollTOTPResponse{Secret: secret, ProvisioningUri: uri}, nil
+}
+
+func (as *AuthServer) ConfirmTOTP(ctx context.Context, req *authpb.ConfirmTOTPRequest) (*authpb.ConfirmTOTPResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.authService.ConfirmTOTP(ctx, userID, req.Code); err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &authpb.ConfirmTOTPResponse{Enabled: true}, nil
+}
+
 func (as *AuthServer) VerifyOTP(ctx context.Context, req *authpb.VerifyOTPRequest) (*authpb.VerifyOTPResponse, error) {
-	user, accessToken, refreshToken, err := as.authService.VerifyOTP(req.Email, req.Otp)
+	user, accessToken, refreshToken, err := as.authService.VerifyOTP(ctx, req.Email, req.Otp, req.DeviceId)
+	if errors.Is(err, services.ErrOTPAttempts) {
+		return nil, toStatusError(err)
+	}
 	if err != nil {
 		return &authpb.VerifyOTPResponse{
 			Success: false,
@@ -51,54 +106,112 @@ func (as *AuthServer) VerifyOTP(ctx context.Context, req *authpb.VerifyOTPReques
 		Success:      true,
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		User: &authpb.User{
-			Id:          user.ID,
-			Email:       user.Email,
-			Name:        user.Name,
-			DateOfBirth: user.DateOfBirth,
-			Gender:      user.Gender,
-			BloodType:   user.BloodType,
-			CreatedAt:   user.CreatedAt.Unix(),
-			UpdatedAt:   user.UpdatedAt.Unix(),
-		},
+		User:         userToPB(user),
 	}, nil
 }
 
-func (as *AuthServer) RefreshToken(ctx context.Context, req *authpb.RefreshTokenRequest) (*authpb.RefreshTokenResponse, error) {
-	accessToken, err := as.authService.RefreshToken(req.RefreshToken)
+func (as *AuthServer) GetProfile(ctx context.Context, req *authpb.GetProfileRequest) (*authpb.User, error) {
+	userID, err := callerID(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	user, err := as.authService.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return userToPB(user), nil
+}
+
+func (as *AuthServer) UpdateProfile(ctx context.Context, req *authpb.UpdateProfileRequest) (*authpb.User, error) {
+	userID, err := callerID(ctx, "")
 	if err != nil {
 		return nil, err
 	}
+	user, err := as.authService.UpdateProfile(ctx, userID, services.ProfileUpdate{
+		Name:        req.Name,
+		DateOfBirth: req.DateOfBirth,
+		Gender:      req.Gender,
+		BloodType:   req.BloodType,
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return userToPB(user), nil
+}
+
+func userToPB(user *models.User) *authpb.User {
+	return &authpb.User{
+		Id:          user.ID,
+		Email:       user.Email,
+		Name:        user.Name,
+		DateOfBirth: user.DateOfBirth,
+		Gender:      user.Gender,
+		BloodType:   user.BloodType,
+		CreatedAt:   user.CreatedAt.Unix(),
+		UpdatedAt:   user.UpdatedAt.Unix(),
+	}
+}
+
+func (as *AuthServer) RefreshToken(ctx context.Context, req *authpb.RefreshTokenRequest) (*authpb.RefreshTokenResponse, error) {
+	accessToken, refreshToken, err := as.authService.RefreshToken(ctx, req.RefreshToken, req.DeviceId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
 
 	return &authpb.RefreshTokenResponse{
 		AccessToken:  accessToken,
-		RefreshToken: req.RefreshToken, // In production, rotate this
+		RefreshToken: refreshToken,
 	}, nil
 }
 
+func (as *AuthServer) Logout(ctx context.Context, req *authpb.LogoutRequest) (*authpb.LogoutResponse, error) {
+	if err := as.authService.Logout(ctx, req.RefreshToken, req.AccessToken, req.AllSessions); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &authpb.LogoutResponse{Success: true}, nil
+}
+
 // HealthRecordsServer implements the gRPC HealthRecordsService
 type HealthRecordsServer struct {
 	healthpb.UnimplementedHealthRecordsServiceServer
-	healthService *services.HealthRecordsService
+	healthService   *services.HealthRecordsService
+	reminderService *services.ReminderService
+	searchService   *services.SearchService
+	exportService   *services.ExportService
+	medications     *services.MedicationService
 }
 
-func NewHealthRecordsServer(healthService *services.HealthRecordsService) *HealthRecordsServer {
-	return &HealthRecordsServer{healthService: healthService}
+func NewHealthRecordsServer(healthService *services.HealthRecordsService, reminderService *services.ReminderService, searchService *services.SearchService, exportService *services.ExportService, medications *services.MedicationService) *HealthRecordsServer {
+	return &HealthRecordsServer{
+		healthService:   healthService,
+		reminderService: reminderService,
+		searchService:   searchService,
+		exportService:   exportService,
+		medications:     medications,
+	}
 }
 
 func (hrs *HealthRecordsServer) CreateRecord(ctx context.Context, req *healthpb.CreateRecordRequest) (*healthpb.HealthRecord, error) {
-	record, err := hrs.healthService.CreateRecord(req.UserId, req.RecordType, req.Title, req.Description, req.Metadata)
+	userID, err := callerID(ctx, req.UserId)
 	if err != nil {
-		log.Printf("Error creating record: %v", err)
 		return nil, err
 	}
 
+	slog.DebugContext(ctx, "Creating record", "record_type", req.RecordType, "metadata_keys", len(req.Metadata))
+
+	record, err := hrs.healthService.CreateRecord(ctx, userID, req.RecordType, req.Title, req.Description, req.Metadata)
+	if err != nil {
+		log.Printf("Error creating record: %v", err)
+		return nil, toStatusError(err)
+	}
+
 	return &healthpb.HealthRecord{
 		Id:          record.ID,
 		UserId:      record.UserID,
 		RecordType:  record.RecordType,
 		Title:       record.Title,
 		Description: record.Description,
+		Sensitivity: record.Sensitivity,
 		Metadata:    req.Metadata,
 		CreatedAt:   record.CreatedAt.String(),
 		UpdatedAt:   record.UpdatedAt.String(),
@@ -106,28 +219,132 @@ func (hrs *HealthRecordsServer) CreateRecord(ctx context.Context, req *healthpb.
 }
 
 func (hrs *HealthRecordsServer) GetRecord(ctx context.Context, req *healthpb.GetRecordRequest) (*healthpb.HealthRecord, error) {
-	record, err := hrs.healthService.GetRecord(req.RecordId)
+	userID, err := callerID(ctx, "")
 	if err != nil {
 		return nil, err
 	}
+	record, err := hrs.healthService.GetRecord(ctx, userID, req.RecordId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	relatedIDs, err := hrs.healthService.RelatedRecordIDs(ctx, record.ID)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
 
 	return &healthpb.HealthRecord{
-		Id:          record.ID,
-		UserId:      record.UserID,
-		RecordType:  record.RecordType,
-		Title:       record.Title,
-		Description: record.Description,
-		CreatedAt:   record.CreatedAt.String(),
-		UpdatedAt:   record.UpdatedAt.String(),
+		Id:               record.ID,
+		UserId:           record.UserID,
+		RecordType:       record.RecordType,
+		Title:            record.Title,
+		Description:      record.Description,
+		Sensitivity:      record.Sensitivity,
+		CreatedAt:        record.CreatedAt.String(),
+		UpdatedAt:        record.UpdatedAt.String(),
+		RelatedRecordIds: relatedIDs,
 	}, nil
 }
 
 func (hrs *HealthRecordsServer) ListRecords(ctx context.Context, req *healthpb.ListRecordsRequest) (*healthpb.ListRecordsResponse, error) {
-	records, total, err := hrs.healthService.ListRecords(req.UserId, int(req.Limit), int(req.Offset))
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	view := services.RecordViewFull
+	if req.View == healthpb.RecordView_RECORD_VIEW_SUMMARY {
+		view = services.RecordViewSummary
+	}
+
+	ranges, err := metadataRanges(req.MetadataRanges)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	opts := services.ListRecordsOptions{
+		Limit:          int(req.Limit),
+		Offset:         int(req.Offset),
+		SortBy:         req.SortBy,
+		SortOrder:      req.SortOrder,
+		View:           view,
+		MetadataRanges: ranges,
+		RecordType:     req.RecordType,
+	}
+	if req.StartDate > 0 {
+		opts.StartDate = time.Unix(req.StartDate, 0)
+	}
+	if req.EndDate > 0 {
+		opts.EndDate = time.Unix(req.EndDate, 0)
+	}
+
+	records, total, err := hrs.healthService.ListRecords(ctx, userID, opts)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	pbRecords := make([]*healthpb.HealthRecord, len(records))
+	for i, record := range records {
+		pbRecords[i] = &healthpb.HealthRecord{
+			Id:          record.ID,
+			UserId:      record.UserID,
+			RecordType:  record.RecordType,
+			Title:       record.Title,
+			Description: record.Description,
+			Sensitivity: record.Sensitivity,
+			CreatedAt:   record.CreatedAt.String(),
+			UpdatedAt:   record.UpdatedAt.String(),
+		}
+		if view == services.RecordViewFull {
+			pbRecords[i].Metadata = decodeMetadata(record.Metadata)
+		}
+	}
+
+	return &healthpb.ListRecordsResponse{
+		Records: pbRecords,
+		Total:   int32(total),
+	}, nil
+}
+
+// decodeMetadata turns stored record metadata back into a map, returning
+// nil for metadata that is empty or does not parse
+func decodeMetadata(raw string) map[string]string {
+	var metadata map[string]string
+	if raw == "" || json.Unmarshal([]byte(raw), &metadata) != nil {
+		return nil
+	}
+	return metadata
+}
+
+// metadataRanges converts request range filters to service ranges
+func metadataRanges(pbRanges []*healthpb.MetadataRange) ([]services.MetadataRange, error) {
+	ranges := make([]services.MetadataRange, 0, len(pbRanges))
+	for _, r := range pbRanges {
+		parsed, err := services.ParseMetadataRange(r.Key, r.Min, r.Max)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, parsed)
+	}
+	return ranges, nil
+}
+
+func (hrs *HealthRecordsServer) SearchRecords(ctx context.Context, req *healthpb.SearchRecordsRequest) (*healthpb.ListRecordsResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
 	if err != nil {
 		return nil, err
 	}
 
+	ranges, err := metadataRanges(req.MetadataRanges)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	records, total, err := hrs.searchService.SearchRecords(ctx, userID, req.Query, ranges, int(req.Limit), int(req.Offset))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
 	pbRecords := make([]*healthpb.HealthRecord, len(records))
 	for i, record := range records {
 		pbRecords[i] = &healthpb.HealthRecord{
@@ -136,6 +353,7 @@ func (hrs *HealthRecordsServer) ListRecords(ctx context.Context, req *healthpb.L
 			RecordType:  record.RecordType,
 			Title:       record.Title,
 			Description: record.Description,
+			Sensitivity: record.Sensitivity,
 			CreatedAt:   record.CreatedAt.String(),
 			UpdatedAt:   record.UpdatedAt.String(),
 		}
@@ -148,24 +366,38 @@ func (hrs *HealthRecordsServer) ListRecords(ctx context.Context, req *healthpb.L
 }
 
 func (hrs *HealthRecordsServer) UpdateRecord(ctx context.Context, req *healthpb.UpdateRecordRequest) (*healthpb.HealthRecord, error) {
-	record, err := hrs.healthService.UpdateRecord(req.RecordId, req.Title, req.Description, req.Metadata)
+	userID, err := callerID(ctx, "")
 	if err != nil {
 		return nil, err
 	}
 
+	record, err := hrs.healthService.UpdateRecord(ctx, userID, req.RecordId, req.Title, req.Description, req.Metadata)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
 	return &healthpb.HealthRecord{
 		Id:          record.ID,
 		UserId:      record.UserID,
 		RecordType:  record.RecordType,
 		Title:       record.Title,
 		Description: record.Description,
+		Sensitivity: record.Sensitivity,
 		CreatedAt:   record.CreatedAt.String(),
 		UpdatedAt:   record.UpdatedAt.String(),
 	}, nil
 }
 
 func (hrs *HealthRecordsServer) DeleteRecord(ctx context.Context, req *healthpb.DeleteRecordRequest) (*healthpb.DeleteRecordResponse, error) {
-	err := hrs.healthService.DeleteRecord(req.RecordId)
+	userID, err := callerID(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = hrs.healthService.DeleteRecord(ctx, userID, req.RecordId)
+	if errors.Is(err, services.ErrNotFound) {
+		return nil, toStatusError(err)
+	}
 	if err != nil {
 		return &healthpb.DeleteRecordResponse{Success: false}, nil
 	}
@@ -173,18 +405,365 @@ func (hrs *HealthRecordsServer) DeleteRecord(ctx context.Context, req *healthpb.
 	return &healthpb.DeleteRecordResponse{Success: true}, nil
 }
 
+func (hrs *HealthRecordsServer) RestoreRecord(ctx context.Context, req *healthpb.RestoreRecordRequest) (*healthpb.HealthRecord, error) {
+	userID, err := callerID(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := hrs.healthService.RestoreRecord(ctx, userID, req.RecordId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &healthpb.HealthRecord{
+		Id:          record.ID,
+		UserId:      record.UserID,
+		RecordType:  record.RecordType,
+		Title:       record.Title,
+		Description: record.Description,
+		Metadata:    decodeMetadata(record.Metadata),
+		Sensitivity: record.Sensitivity,
+		CreatedAt:   record.CreatedAt.String(),
+		UpdatedAt:   record.UpdatedAt.String(),
+	}, nil
+}
+
+func (hrs *HealthRecordsServer) SetRecordReminder(ctx context.Context, req *healthpb.SetRecordReminderRequest) (*healthpb.Reminder, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	reminder, err := hrs.reminderService.SetRecordReminder(ctx, userID, req.RecordId, time.Unix(req.RemindAt, 0), req.Note)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &healthpb.Reminder{
+		Id:       reminder.ID,
+		RecordId: reminder.RecordID,
+		Kind:     reminder.Kind,
+		Message:  reminder.Message,
+		DueAt:    reminder.DueAt.Unix(),
+	}, nil
+}
+
+func (hrs *HealthRecordsServer) LinkRecords(ctx context.Context, req *healthpb.LinkRecordsRequest) (*healthpb.RecordLink, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := hrs.healthService.LinkRecords(ctx, userID, req.SourceId, req.TargetId, req.RelationType)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &healthpb.RecordLink{
+		Id:           link.ID,
+		SourceId:     link.SourceID,
+		TargetId:     link.TargetID,
+		RelationType: link.RelationType,
+		CreatedAt:    link.CreatedAt.Unix(),
+	}, nil
+}
+
+func (hrs *HealthRecordsServer) ParseAppointment(ctx context.Context, req *healthpb.ParseAppointmentRequest) (*healthpb.ParsedAppointment, error) {
+	loc := time.UTC
+	if req.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(req.Timezone); err != nil {
+			return nil, toStatusError(fmt.Errorf("%w: unknown timezone %q", services.ErrInvalidArgument, req.Timezone))
+		}
+	}
+
+	parsed := services.ParseAppointment(req.Text, time.Now().In(loc))
+
+	pbParsed := &healthpb.ParsedAppointment{
+		Title:             parsed.Title,
+		Location:          parsed.Location,
+		NeedsConfirmation: parsed.NeedsConfirmation,
+		Reasons:           parsed.Reasons,
+		Metadata:          map[string]string{},
+	}
+	if !parsed.StartsAt.IsZero() {
+		pbParsed.StartsAt = parsed.StartsAt.Unix()
+		pbParsed.Metadata["appointment_at"] = parsed.StartsAt.Format(time.RFC3339)
+	}
+	if parsed.Location != "" {
+		pbParsed.Metadata["location"] = parsed.Location
+	}
+
+	return pbParsed, nil
+}
+
+func (hrs *HealthRecordsServer) CreateExportLink(ctx context.Context, req *healthpb.CreateExportLinkRequest) (*healthpb.ExportLink, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := hrs.exportService.CreateExportLink(ctx, userID, req.RecordIds, time.Duration(req.ExpiresInSeconds)*time.Second, req.RequirePin, req.RecipientEmail, req.Redact)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &healthpb.ExportLink{
+		Id:          created.Link.ID,
+		Url:         created.URL,
+		Pin:         created.PIN,
+		RecordCount: int32(created.Link.RecordCount),
+		ExpiresAt:   created.Link.ExpiresAt.Unix(),
+		Redacted:    created.Link.Redacted,
+	}, nil
+}
+
+func (hrs *HealthRecordsServer) RevokeExportLink(ctx context.Context, req *healthpb.RevokeExportLinkRequest) (*healthpb.RevokeExportLinkResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := hrs.exportService.RevokeExportLink(ctx, userID, req.LinkId); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &healthpb.RevokeExportLinkResponse{Success: true}, nil
+}
+
+func (hrs *HealthRecordsServer) ConfirmMedicationSetup(ctx context.Context, req *healthpb.ConfirmMedicationSetupRequest) (*healthpb.MedicationSetup, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	setup := services.MedicationSetup{
+		Name:       req.Medication,
+		Dosage:     req.Dosage,
+		Frequency:  req.Frequency,
+		TimesOfDay: req.TimesOfDay,
+		EveryDays:  int(req.EveryDays),
+		AsNeeded:   req.AsNeeded,
+		CourseDays: int(req.CourseDays),
+		Timezone:   req.Timezone,
+		ScanID:     req.ScanId,
+	}
+	if req.StartsAt > 0 {
+		setup.StartsAt = time.Unix(req.StartsAt, 0)
+	}
+
+	medication, scheduled, err := hrs.medications.ConfirmMedicationSetup(ctx, userID, setup)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	pbSetup := &healthpb.MedicationSetup{
+		MedicationId:       medication.ID,
+		RecordId:           medication.RecordID,
+		EveryDays:          int32(medication.EveryDays),
+		AsNeeded:           medication.AsNeeded,
+		StartsAt:           medication.StartsAt.Unix(),
+		RemindersScheduled: int32(scheduled),
+	}
+	if medication.TimesOfDay != "" {
+		pbSetup.TimesOfDay = strings.Split(medication.TimesOfDay, ",")
+	}
+	if medication.EndsAt != nil {
+		pbSetup.EndsAt = medication.EndsAt.Unix()
+	}
+	return pbSetup, nil
+}
+
+func (hrs *HealthRecordsServer) SetRecordSensitivity(ctx context.Context, req *healthpb.SetRecordSensitivityRequest) (*healthpb.HealthRecord, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := hrs.healthService.SetRecordSensitivity(ctx, userID, req.RecordId, req.Sensitivity)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &healthpb.HealthRecord{
+		Id:          record.ID,
+		UserId:      record.UserID,
+		RecordType:  record.RecordType,
+		Title:       record.Title,
+		Description: record.Description,
+		Sensitivity: record.Sensitivity,
+		CreatedAt:   record.CreatedAt.String(),
+		UpdatedAt:   record.UpdatedAt.String(),
+	}, nil
+}
+
+func (hrs *HealthRecordsServer) ListRecordAccessLog(ctx context.Context, req *healthpb.ListRecordAccessLogRequest) (*healthpb.ListRecordAccessLogResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, total, err := hrs.healthService.ListRecordAccessLog(ctx, userID, int(req.Limit), int(req.Offset))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	pbEntries := make([]*healthpb.RecordAccess, len(entries))
+	for i, entry := range entries {
+		pbEntries[i] = &healthpb.RecordAccess{
+			RecordId:   entry.RecordID,
+			ActorId:    entry.ActorID,
+			OrgId:      entry.OrgID,
+			Reason:     entry.Reason,
+			AccessedAt: entry.CreatedAt.Unix(),
+		}
+	}
+
+	return &healthpb.ListRecordAccessLogResponse{
+		Entries: pbEntries,
+		Total:   int32(total),
+	}, nil
+}
+
+func (hrs *HealthRecordsServer) ListChanges(ctx context.Context, req *healthpb.ListChangesRequest) (*healthpb.ListChangesResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	if req.Since > 0 {
+		since = time.Unix(req.Since, 0)
+	}
+	page, err := hrs.healthService.ListChanges(ctx, userID, since, req.Cursor, int(req.Limit))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return changePageToProto(page), nil
+}
+
+func (hrs *HealthRecordsServer) SyncRecords(ctx context.Context, req *healthpb.SyncRecordsRequest) (*healthpb.SyncRecordsResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := hrs.healthService.SyncRecords(ctx, userID, req.SyncToken, int(req.Limit))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resp := &healthpb.SyncRecordsResponse{
+		Records:    make([]*healthpb.HealthRecord, len(page.Records)),
+		Tombstones: make([]*healthpb.RecordTombstone, len(page.Tombstones)),
+		SyncToken:  page.Token,
+		HasMore:    page.HasMore,
+	}
+	for i, record := range page.Records {
+		resp.Records[i] = &healthpb.HealthRecord{
+			Id:          record.ID,
+			UserId:      record.UserID,
+			RecordType:  record.RecordType,
+			Title:       record.Title,
+			Description: record.Description,
+			Metadata:    decodeMetadata(record.Metadata),
+			Sensitivity: record.Sensitivity,
+			CreatedAt:   record.CreatedAt.String(),
+			UpdatedAt:   record.UpdatedAt.String(),
+		}
+	}
+	for i, tombstone := range page.Tombstones {
+		resp.Tombstones[i] = &healthpb.RecordTombstone{
+			RecordId:  tombstone.RecordID,
+			DeletedAt: tombstone.DeletedAt.Unix(),
+		}
+	}
+	return resp, nil
+}
+
+func changePageToProto(page *services.ChangePage) *healthpb.ListChangesResponse {
+	events := make([]*healthpb.ChangeEvent, len(page.Events))
+	for i, event := range page.Events {
+		events[i] = &healthpb.ChangeEvent{
+			Id:         event.ID,
+			ActorId:    event.ActorID,
+			EntityType: event.EntityType,
+			EntityId:   event.EntityID,
+			RecordId:   event.RecordID,
+			Kind:       event.Kind,
+			Summary:    event.Summary,
+			ChangedAt:  event.CreatedAt.Unix(),
+		}
+	}
+	return &healthpb.ListChangesResponse{
+		Events:  events,
+		Cursor:  page.Cursor,
+		HasMore: page.HasMore,
+	}
+}
+
 // AIServer implements the gRPC AIService
 type AIServer struct {
 	aipb.UnimplementedAIServiceServer
-	aiService *services.AIService
+	aiService    *services.AIService
+	capabilities *services.CapabilityService
+
+	// chatConcurrency is how many messages of one DoctorChat stream are
+	// answered at once
+	chatConcurrency int
+	// chat answers one DoctorChat message; aiService.StreamDoctorChat
+	// outside tests
+	chat func(ctx context.Context, userID, conversationID, message string, onChunk func(string)) (string, bool, error)
 }
 
-func NewAIServer(aiService *services.AIService) *AIServer {
-	return &AIServer{aiService: aiService}
+func NewAIServer(aiService *services.AIService, capabilities *services.CapabilityService, chatConcurrency int) *AIServer {
+	return &AIServer{
+		aiService:       aiService,
+		capabilities:    capabilities,
+		chatConcurrency: chatConcurrency,
+		chat:            aiService.StreamDoctorChat,
+	}
 }
 
 func (ai *AIServer) ScanPrescription(ctx context.Context, req *aipb.ScanPrescriptionRequest) (*aipb.ScanPrescriptionResponse, error) {
-	extractedData, err := ai.aiService.ScanPrescription(req.UserId, req.ImageData)
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ai.aiService.ScanPrescription(ctx, userID, req.ImageData, services.ScanOptions{
+		Force:          req.Force,
+		IncludeRawText: req.IncludeRawText,
+	})
+	var qualityErr *services.ImageQualityError
+	if errors.As(err, &qualityErr) {
+		return &aipb.ScanPrescriptionResponse{
+			Success:      false,
+			ErrorMessage: err.Error(),
+			ErrorCode:    "IMAGE_QUALITY",
+			ImageQuality: &aipb.ImageQualityReport{
+				Width:      int32(qualityErr.Quality.Width),
+				Height:     int32(qualityErr.Quality.Height),
+				Sharpness:  qualityErr.Quality.Sharpness,
+				Brightness: qualityErr.Quality.Brightness,
+				Problems:   qualityErr.Problems,
+			},
+		}, nil
+	}
+	if errors.Is(err, services.ErrUnavailable) {
+		return &aipb.ScanPrescriptionResponse{
+			Success:      false,
+			ErrorMessage: err.Error(),
+			ErrorCode:    "UNAVAILABLE",
+		}, nil
+	}
+	if errors.Is(err, services.ErrContentRefused) {
+		return &aipb.ScanPrescriptionResponse{
+			Success:      false,
+			ErrorMessage: services.RefusalMessage,
+			ErrorCode:    "CONTENT_REFUSED",
+		}, nil
+	}
 	if err != nil {
 		return &aipb.ScanPrescriptionResponse{
 			Success:      false,
@@ -192,51 +771,293 @@ func (ai *AIServer) ScanPrescription(ctx context.Context, req *aipb.ScanPrescrip
 		}, nil
 	}
 
+	draft := services.DraftMedicationSchedule(result.ExtractedData)
 	return &aipb.ScanPrescriptionResponse{
-		Success:       true,
-		PrescriptionText: fmt.Sprintf("%v", extractedData),
-		ExtractedData: extractedData,
+		Success:          true,
+		PrescriptionText: fmt.Sprintf("%v", result.ExtractedData),
+		ExtractedData:    result.ExtractedData,
+		ScanId:           result.ScanID,
+		RecordId:         result.RecordID,
+		RawText:          ocrTextToPB(result.RawText),
+		ScheduleDraft: &aipb.MedicationScheduleDraft{
+			Medication: draft.Medication,
+			Dosage:     draft.Dosage,
+			Frequency:  draft.Frequency,
+			TimesOfDay: draft.TimesOfDay,
+			EveryDays:  int32(draft.EveryDays),
+			AsNeeded:   draft.AsNeeded,
+			Recognized: draft.Recognized,
+			CourseDays: int32(draft.CourseDays),
+		},
 	}, nil
 }
 
+// ocrTextToPB converts raw scan text, which is nil unless requested
+func ocrTextToPB(text *services.OCRText) *aipb.OCRText {
+	if text == nil {
+		return nil
+	}
+	tokens := make([]*aipb.OCRToken, len(text.Tokens))
+	for i, token := range text.Tokens {
+		tokens[i] = &aipb.OCRToken{Text: token.Text, Confidence: token.Confidence}
+	}
+	return &aipb.OCRText{Text: text.Text, Tokens: tokens}
+}
+
 func (ai *AIServer) SummarizeHealth(ctx context.Context, req *aipb.SummarizeHealthRequest) (*aipb.SummarizeHealthResponse, error) {
-	summary, findings, recommendations, err := ai.aiService.SummarizeHealth(req.UserId, int(req.Days))
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := ai.aiService.SummarizeHealth(ctx, userID, int(req.Days), req.Sections, req.ExcludeRecordIds)
+	if errors.Is(err, services.ErrInvalidArgument) || errors.Is(err, services.ErrNotFound) || errors.Is(err, services.ErrContentRefused) {
+		return nil, toStatusError(err)
+	}
 	if err != nil {
 		return &aipb.SummarizeHealthResponse{
 			Success: false,
 		}, nil
 	}
 
+	sections := make([]*aipb.SummarySection, len(summary.Sections))
+	for i, section := range summary.Sections {
+		sections[i] = &aipb.SummarySection{
+			Name:  section.Name,
+			Text:  section.Text,
+			Items: section.Items,
+		}
+	}
+
 	return &aipb.SummarizeHealthResponse{
-		Success:         true,
-		Summary:         summary,
-		KeyFindings:     findings,
-		Recommendations: recommendations,
+		Success:            true,
+		Summary:            summary.Summary,
+		KeyFindings:        summary.KeyFindings,
+		Recommendations:    summary.Recommendations,
+		Sections:           sections,
+		Degraded:           summary.Degraded,
+		RecordsTruncated:   summary.RecordsTruncated,
+		RecordsIncluded:    int32(summary.RecordCount),
+		IncompleteSections: summary.IncompleteSections,
 	}, nil
 }
 
+// chatReplyBuffer is how many responses of a turn queue up while earlier
+// turns are still being sent; a streaming provider waits beyond it
+const chatReplyBuffer = 64
+
+// DoctorChat answers each message on the stream, replying in the order the
+// messages arrived. Messages to different conversations are answered
+// concurrently, up to the configured limit per stream; messages to one
+// conversation are answered one at a time, since each builds on the history
+// the one before stored. Each message being answered holds a permit of the
+// AI class, so the server-wide limit bounds chat turns as it does other AI
+// calls. Messages that ask to stream get partial responses ahead of their
+// final reply. A message that fails, or finds the AI class full, gets a
+// reply carrying the error, and the stream stays open.
 func (ai *AIServer) DoctorChat(stream aipb.AIService_DoctorChatServer) error {
-	for {
-		req, err := stream.Recv()
-		if err != nil {
-			return err
-		}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
 
-		response, err := ai.aiService.DoctorChat(req.UserId, req.ConversationId, req.Message)
-		if err != nil {
-			log.Printf("Error in doctor chat: %v", err)
-			continue
+	limit := max(ai.chatConcurrency, 1)
+	slots := make(chan struct{}, limit)
+	// Each turn's responses, any partial ones then the final one, arrive on
+	// the turn's own channel, queued in message order
+	replies := make(chan chan *aipb.DoctorChatResponse, limit)
+	var recvErr error
+
+	go func() {
+		defer close(replies)
+		// The latest turn of each conversation, closed when it is answered
+		latest := make(map[string]chan struct{})
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr = err
+				return
+			}
+			userID, err := callerID(ctx, req.UserId)
+			if err != nil {
+				recvErr = err
+				return
+			}
+
+			reply := make(chan *aipb.DoctorChatResponse, chatReplyBuffer)
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case replies <- reply:
+			case <-ctx.Done():
+				return
+			}
+			previous := latest[req.ConversationId]
+			done := make(chan struct{})
+			latest[req.ConversationId] = done
+
+			go func() {
+				defer func() { <-slots }()
+				defer close(done)
+				defer close(reply)
+				if previous != nil {
+					<-previous
+				}
+				send := func(response *aipb.DoctorChatResponse) {
+					select {
+					case reply <- response:
+					case <-ctx.Done():
+					}
+				}
+				var onChunk func(string)
+				if req.Stream {
+					onChunk = func(chunk string) {
+						send(&aipb.DoctorChatResponse{
+							ConversationId: req.ConversationId,
+							Response:       chunk,
+							IsAI:           true,
+							Timestamp:      time.Now().Unix(),
+							IsPartial:      true,
+						})
+					}
+				}
+				send(ai.doctorChatTurn(ctx, userID, req, onChunk))
+			}()
 		}
+	}()
 
-		chatResponse := &aipb.DoctorChatResponse{
-			ConversationId: req.ConversationId,
-			Response:       response,
-			IsAI:           true,
-			Timestamp:      int64(0), // Will be set by server
+	for reply := range replies {
+		for response := range reply {
+			if err := stream.Send(response); err != nil {
+				return err
+			}
 		}
+	}
+	// The client closing its side ends the stream once every reply is sent
+	if recvErr == io.EOF {
+		return nil
+	}
+	return recvErr
+}
+
+// doctorChatTurn answers one chat message under a permit of the stream's
+// class, passing pieces of the reply to onChunk when it is set. Failures
+// are returned in the reply rather than ending the stream.
+func (ai *AIServer) doctorChatTurn(ctx context.Context, userID string, req *aipb.DoctorChatRequest, onChunk func(string)) *aipb.DoctorChatResponse {
+	slog.DebugContext(ctx, "Doctor chat turn", "conversation_id", req.ConversationId, "message_length", len(req.Message))
+
+	var response string
+	var degraded bool
+	release, err := concurrency.Permit(ctx)
+	if err == nil {
+		response, degraded, err = ai.chat(ctx, userID, req.ConversationId, req.Message, onChunk)
+		release()
+	}
+	reply := &aipb.DoctorChatResponse{
+		ConversationId: req.ConversationId,
+		IsAI:           true,
+		Timestamp:      time.Now().Unix(),
+	}
+	switch {
+	case errors.Is(err, services.ErrContentRefused):
+		// Keep the stream open so the user can rephrase
+		reply.Response = services.RefusalMessage
+		reply.Refused = true
+	case err != nil:
+		log.Printf("Error in doctor chat: %v", err)
+		st := status.Convert(toStatusError(err))
+		reply.Error = st.Message()
+		reply.ErrorCode = st.Code().String()
+	default:
+		reply.Response = response
+		reply.Degraded = degraded
+	}
+	return reply
+}
+
+func (ai *AIServer) VoiceChat(ctx context.Context, req *aipb.VoiceChatRequest) (*aipb.VoiceChatResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "Voice chat", "conversation_id", req.ConversationId, "audio_format", req.AudioFormat, "audio_bytes", len(req.AudioData))
+
+	transcript, response, degraded, err := ai.aiService.VoiceChat(ctx, userID, req.ConversationId, req.AudioData, req.AudioFormat)
+	if err != nil {
+		log.Printf("Error in voice chat: %v", err)
+		return nil, toStatusError(err)
+	}
+
+	return &aipb.VoiceChatResponse{
+		ConversationId: req.ConversationId,
+		Transcript:     transcript,
+		Response:       response,
+		Timestamp:      time.Now().Unix(),
+		Degraded:       degraded,
+	}, nil
+}
+
+func (ai *AIServer) SummarizeConversation(ctx context.Context, req *aipb.SummarizeConversationRequest) (*aipb.ConversationSummary, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := ai.aiService.SummarizeConversation(ctx, userID, req.ConversationId)
+	if err != nil {
+		log.Printf("Error summarizing conversation: %v", err)
+		return nil, toStatusError(err)
+	}
+
+	return &aipb.ConversationSummary{
+		ConversationId:    summary.ConversationID,
+		Summary:           summary.Summary,
+		Turns:             int32(summary.Turns),
+		SummarizedThrough: summary.SummarizedThrough.Unix(),
+	}, nil
+}
+
+func (ai *AIServer) SearchConversations(ctx context.Context, req *aipb.SearchConversationsRequest) (*aipb.SearchConversationsResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := ai.aiService.SearchConversations(ctx, userID, req.Query)
+	if err != nil {
+		log.Printf("Error searching conversations: %v", err)
+		return nil, toStatusError(err)
+	}
+
+	resp := &aipb.SearchConversationsResponse{}
+	for _, match := range matches {
+		resp.Conversations = append(resp.Conversations, &aipb.ConversationMatch{
+			ConversationId: match.ConversationID,
+			Matches:        int32(match.Matches),
+			MessageId:      match.MessageID,
+			Snippet:        match.Snippet,
+			InResponse:     match.InResponse,
+			MatchedAt:      match.MatchedAt.Unix(),
+		})
+	}
+	return resp, nil
+}
+
+func (ai *AIServer) GetServiceCapabilities(ctx context.Context, req *aipb.GetServiceCapabilitiesRequest) (*aipb.GetServiceCapabilitiesResponse, error) {
+	capabilities := ai.capabilities.Capabilities()
 
-		if err := stream.Send(chatResponse); err != nil {
-			return err
+	resp := &aipb.GetServiceCapabilitiesResponse{}
+	for _, feature := range services.Features {
+		fidelity := capabilities[feature]
+		resp.Features = append(resp.Features, &aipb.FeatureCapability{
+			Feature:  feature,
+			Fidelity: fidelity,
+		})
+		if fidelity != services.FidelityFull {
+			resp.Limited = true
 		}
 	}
+	return resp, nil
 }
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	"github.com/clarity/backend/middleware"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// benchmarkRecords is how many records BenchmarkListRecordsView pages through
+const benchmarkRecords = 10000
+
+// BenchmarkListRecordsView pages through 10k records in each view,
+// reporting the encoded response size alongside the time taken
+func BenchmarkListRecordsView(b *testing.B) {
+	db := newTestDB(b)
+	if err := db.Create(&models.User{ID: "user-1", Email: "user-1@example.com"}).Error; err != nil {
+		b.Fatalf("create user: %v", err)
+	}
+	description := strings.Repeat("Fasting glucose within range, repeat in six months. ", 20)
+	metadata := `{"lab":"Riverside Pathology","units":"mmol/L","value":"5.2","reference_range":"3.9-5.5","ordered_by":"Dr Patel"}`
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := make([]models.HealthRecord, benchmarkRecords)
+	for i := range records {
+		records[i] = models.HealthRecord{
+			ID:          fmt.Sprintf("rec-%05d", i),
+			UserID:      "user-1",
+			RecordType:  "lab_result",
+			Title:       fmt.Sprintf("Blood panel %d", i),
+			Description: description,
+			Metadata:    metadata,
+			Sensitivity: models.SensitivityStandard,
+			CreatedAt:   created.Add(time.Duration(i) * time.Minute),
+			UpdatedAt:   created.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	if err := db.CreateInBatches(records, 500).Error; err != nil {
+		b.Fatalf("seed records: %v", err)
+	}
+
+	server := NewHealthRecordsServer(services.NewHealthRecordsService(db, &config.RecordsConfig{}, nil, nil), nil, nil, nil, nil)
+	ctx := middleware.WithUserID(context.Background(), "user-1")
+
+	for _, view := range []healthpb.RecordView{healthpb.RecordView_RECORD_VIEW_FULL, healthpb.RecordView_RECORD_VIEW_SUMMARY} {
+		b.Run(view.String(), func(b *testing.B) {
+			var wireBytes int
+			for n := 0; n < b.N; n++ {
+				wireBytes = 0
+				for offset := 0; offset < benchmarkRecords; offset += 100 {
+					resp, err := server.ListRecords(ctx, &healthpb.ListRecordsRequest{Limit: 100, Offset: int32(offset), View: view})
+					if err != nil {
+						b.Fatalf("ListRecords: %v", err)
+					}
+					wireBytes += proto.Size(resp)
+				}
+			}
+			b.ReportMetric(float64(wireBytes), "wire-bytes/op")
+		})
+	}
+}
+
+func TestListRecordsRequestFilters(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Create(&models.User{ID: "user-1", Email: "user-1@example.com"}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	for i, recordType := range []string{"lab_result", "lab_result", "prescription", "lab_result"} {
+		at := day.AddDate(0, 0, i)
+		record := models.HealthRecord{ID: fmt.Sprintf("rec-%d", i), UserID: "user-1", RecordType: recordType, Title: "Record", CreatedAt: at, UpdatedAt: at}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatalf("create record: %v", err)
+		}
+	}
+	server := NewHealthRecordsServer(services.NewHealthRecordsService(db, &config.RecordsConfig{}, nil, nil), nil, nil, nil, nil)
+	ctx := middleware.WithUserID(context.Background(), "user-1")
+
+	// Dates are unix seconds, both ends inclusive
+	resp, err := server.ListRecords(ctx, &healthpb.ListRecordsRequest{
+		RecordType: "lab_result",
+		StartDate:  day.AddDate(0, 0, 1).Unix(),
+		EndDate:    day.AddDate(0, 0, 3).Unix(),
+		Limit:      1,
+	})
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if len(resp.Records) != 1 || resp.Total != 2 {
+		t.Errorf("%d records of %d, want 1 of 2", len(resp.Records), resp.Total)
+	}
+
+	// Without filters every record is listed, as before
+	resp, err = server.ListRecords(ctx, &healthpb.ListRecordsRequest{})
+	if err != nil || resp.Total != 4 {
+		t.Errorf("unfiltered ListRecords = %v, %v; want 4 records", resp, err)
+	}
+
+	for name, req := range map[string]*healthpb.ListRecordsRequest{
+		"unknown type":                  {RecordType: "dental"},
+		"range ending before it starts": {StartDate: day.Unix(), EndDate: day.Unix() - 1},
+	} {
+		if _, err := server.ListRecords(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: %v, want InvalidArgument", name, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/database"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns a migrated in-memory SQLite database private to tb
+func newTestDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	db, err := database.NewDatabase(&config.DatabaseConfig{
+		Type: "sqlite",
+		Path: "file:" + url.PathEscape(tb.Name()) + "?mode=memory&cache=shared",
+	})
+	if err != nil {
+		tb.Fatalf("NewDatabase: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		tb.Fatalf("Migrate: %v", err)
+	}
+	return db.GetConnection()
+}
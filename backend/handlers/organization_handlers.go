@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	healthpb "github.com/clarity/backend/gen/go/health"
+	orgpb "github.com/clarity/backend/gen/go/organization"
+	"github.com/clarity/backend/services"
+)
+
+// OrganizationServer implements the gRPC OrganizationService
+type OrganizationServer struct {
+	orgpb.UnimplementedOrganizationServiceServer
+	orgService *services.OrganizationService
+}
+
+func NewOrganizationServer(orgService *services.OrganizationService) *OrganizationServer {
+	return &OrganizationServer{orgService: orgService}
+}
+
+func (o *OrganizationServer) CreateOrganization(ctx context.Context, req *orgpb.CreateOrganizationRequest) (*orgpb.Organization, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := o.orgService.CreateOrganization(ctx, userID, req.Name)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &orgpb.Organization{
+		Id:        org.ID,
+		Name:      org.Name,
+		CreatedAt: org.CreatedAt.Unix(),
+	}, nil
+}
+
+func (o *OrganizationServer) InviteMember(ctx context.Context, req *orgpb.InviteMemberRequest) (*orgpb.InviteMemberResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := o.orgService.InviteMember(ctx, userID, req.OrgId, req.Email, req.Role)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &orgpb.InviteMemberResponse{
+		InviteId:   created.Invite.ID,
+		ExpiresAt:  created.Invite.ExpiresAt.Unix(),
+		DeliveryId: created.DeliveryID,
+	}, nil
+}
+
+func (o *OrganizationServer) AcceptInvite(ctx context.Context, req *orgpb.AcceptInviteRequest) (*orgpb.AcceptInviteResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := o.orgService.AcceptInvite(ctx, userID, req.Token)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &orgpb.AcceptInviteResponse{
+		Success: true,
+		OrgId:   user.OrgID,
+	}, nil
+}
+
+func (o *OrganizationServer) GrantConsent(ctx context.Context, req *orgpb.ConsentRequest) (*orgpb.ConsentResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := o.orgService.GrantConsent(ctx, userID, req.OrgId); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &orgpb.ConsentResponse{Success: true}, nil
+}
+
+func (o *OrganizationServer) RevokeConsent(ctx context.Context, req *orgpb.ConsentRequest) (*orgpb.ConsentResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.orgService.RevokeConsent(ctx, userID, req.OrgId); err != nil {
+		return nil, toStatusError(err)
+	}
+	return &orgpb.ConsentResponse{Success: true}, nil
+}
+
+func (o *OrganizationServer) ListConsentingPatients(ctx context.Context, req *orgpb.ListConsentingPatientsRequest) (*orgpb.ListConsentingPatientsResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	patients, err := o.orgService.ListConsentingPatients(ctx, userID, req.OrgId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	pbPatients := make([]*orgpb.Patient, len(patients))
+	for i, patient := range patients {
+		pbPatients[i] = &orgpb.Patient{
+			Id:    patient.ID,
+			Email: patient.Email,
+			Name:  patient.Name,
+		}
+	}
+
+	return &orgpb.ListConsentingPatientsResponse{Patients: pbPatients}, nil
+}
+
+func (o *OrganizationServer) ListPatientRecords(ctx context.Context, req *orgpb.ListPatientRecordsRequest) (*healthpb.ListRecordsResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	records, total, err := o.orgService.ListPatientRecords(ctx, userID, req.OrgId, req.PatientId, req.AccessReason, int(req.Limit), int(req.Offset))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	pbRecords := make([]*healthpb.HealthRecord, len(records))
+	for i, record := range records {
+		pbRecords[i] = &healthpb.HealthRecord{
+			Id:          record.ID,
+			UserId:      record.UserID,
+			RecordType:  record.RecordType,
+			Title:       record.Title,
+			Description: record.Description,
+			Sensitivity: record.Sensitivity,
+			CreatedAt:   record.CreatedAt.String(),
+			UpdatedAt:   record.UpdatedAt.String(),
+		}
+	}
+
+	return &healthpb.ListRecordsResponse{
+		Records: pbRecords,
+		Total:   int32(total),
+	}, nil
+}
+
+func (o *OrganizationServer) GetPatientRecord(ctx context.Context, req *orgpb.GetPatientRecordRequest) (*healthpb.HealthRecord, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := o.orgService.GetPatientRecord(ctx, userID, req.OrgId, req.RecordId, req.AccessReason)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &healthpb.HealthRecord{
+		Id:          record.ID,
+		UserId:      record.UserID,
+		RecordType:  record.RecordType,
+		Title:       record.Title,
+		Description: record.Description,
+		Metadata:    decodeMetadata(record.Metadata),
+		Sensitivity: record.Sensitivity,
+		CreatedAt:   record.CreatedAt.String(),
+		UpdatedAt:   record.UpdatedAt.String(),
+	}, nil
+}
+
+func (o *OrganizationServer) ListPatientChanges(ctx context.Context, req *orgpb.ListPatientChangesRequest) (*healthpb.ListChangesResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	if req.Since > 0 {
+		since = time.Unix(req.Since, 0)
+	}
+	page, err := o.orgService.ListPatientChanges(ctx, userID, req.OrgId, req.PatientId, since, req.Cursor, int(req.Limit))
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+	return changePageToProto(page), nil
+}
+
+func (o *OrganizationServer) GetOrganizationStats(ctx context.Context, req *orgpb.GetOrganizationStatsRequest) (*orgpb.OrganizationStats, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := o.orgService.GetOrgStats(ctx, userID, req.OrgId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &orgpb.OrganizationStats{
+		Admins:             stats.Admins,
+		Staff:              stats.Staff,
+		ConsentingPatients: stats.ConsentingPatients,
+		Records:            stats.Records,
+		SensitiveRecords:   stats.SensitiveRecords,
+	}, nil
+}
+
+func (o *OrganizationServer) ExportOrganizationRecords(ctx context.Context, req *orgpb.ExportOrganizationRecordsRequest) (*orgpb.ExportOrganizationRecordsResponse, error) {
+	userID, err := callerID(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	records, truncated, err := o.orgService.ExportRecords(ctx, userID, req.OrgId)
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	resp := &orgpb.ExportOrganizationRecordsResponse{Truncated: truncated}
+	for _, record := range records {
+		resp.Records = append(resp.Records, &healthpb.HealthRecord{
+			Id:          record.ID,
+			UserId:      record.UserID,
+			RecordType:  record.RecordType,
+			Title:       record.Title,
+			Description: record.Description,
+			Metadata:    decodeMetadata(record.Metadata),
+			Sensitivity: record.Sensitivity,
+			CreatedAt:   record.CreatedAt.String(),
+			UpdatedAt:   record.UpdatedAt.String(),
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	authpb "github.com/clarity/backend/gen/go/auth"
+	"github.com/clarity/backend/middleware"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProfileRPCs(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Create(&models.User{ID: "user-1", Email: "user-1@example.com", Name: "Ada"}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	server := NewAuthServer(services.NewAuthService(db, &config.AuthConfig{JWTSecret: "test-secret"}, nil, nil))
+	ctx := middleware.WithUserID(context.Background(), "user-1")
+
+	user, err := server.GetProfile(ctx, &authpb.GetProfileRequest{})
+	if err != nil || user.Id != "user-1" || user.Email != "user-1@example.com" || user.Name != "Ada" {
+		t.Fatalf("GetProfile = %+v, %v", user, err)
+	}
+
+	// Only the fields sent change
+	user, err = server.UpdateProfile(ctx, &authpb.UpdateProfileRequest{
+		DateOfBirth: proto.String("1990-12-10"),
+		BloodType:   proto.String("O-"),
+	})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if user.Name != "Ada" || user.DateOfBirth != "1990-12-10" || user.BloodType != "O-" || user.Gender != "" {
+		t.Errorf("UpdateProfile = %+v", user)
+	}
+	// An empty string clears one
+	user, err = server.UpdateProfile(ctx, &authpb.UpdateProfileRequest{Name: proto.String("")})
+	if err != nil || user.Name != "" || user.BloodType != "O-" {
+		t.Errorf("clearing the name = %+v, %v", user, err)
+	}
+	if user, err := server.GetProfile(ctx, &authpb.GetProfileRequest{}); err != nil || user.DateOfBirth != "1990-12-10" {
+		t.Errorf("stored profile = %+v, %v", user, err)
+	}
+
+	for name, req := range map[string]*authpb.UpdateProfileRequest{
+		"future date of birth": {DateOfBirth: proto.String("2999-01-01")},
+		"unknown gender":       {Gender: proto.String("robot")},
+		"unknown blood type":   {BloodType: proto.String("Q")},
+	} {
+		if _, err := server.UpdateProfile(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: %v, want InvalidArgument", name, err)
+		}
+	}
+
+	// The RPCs act on the signed-in user only
+	if _, err := server.GetProfile(context.Background(), &authpb.GetProfileRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetProfile without a user: %v, want Unauthenticated", err)
+	}
+	if _, err := server.UpdateProfile(context.Background(), &authpb.UpdateProfileRequest{Name: proto.String("x")}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("UpdateProfile without a user: %v, want Unauthenticated", err)
+	}
+	gone := middleware.WithUserID(context.Background(), "deleted-user")
+	if _, err := server.GetProfile(gone, &authpb.GetProfileRequest{}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetProfile of a deleted user: %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/clarity/backend/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// providerHeader is the metadata key an admin sets to route one request's
+// AI calls to another provider, for comparing models
+const providerHeader = "x-ai-provider"
+
+// aiServicePrefix marks the methods provider overrides apply to
+const aiServicePrefix = "/clarity.ai.AIService/"
+
+// providerOverride reads the provider override from an AI method's
+// metadata and returns ctx carrying it. Overrides need the admin key and a
+// provider listed in AI_PROVIDER_OVERRIDES; anything else is refused rather
+// than silently served by the default provider.
+func providerOverride(ctx context.Context, fullMethod, apiKey string, ai *services.AIService) (context.Context, error) {
+	if !strings.HasPrefix(fullMethod, aiServicePrefix) {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(providerHeader)
+	if len(values) == 0 {
+		return ctx, nil
+	}
+
+	if !hasAdminKey(ctx, apiKey) {
+		return nil, status.Error(codes.PermissionDenied, "admin key required to choose the AI provider")
+	}
+	name := strings.TrimSpace(values[0])
+	if !ai.HasProviderOverride(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "AI provider %q is not available for overrides", name)
+	}
+	return services.WithProviderOverride(ctx, name), nil
+}
+
+// ProviderOverrideUnaryInterceptor applies admin provider overrides to
+// unary AI calls
+func ProviderOverrideUnaryInterceptor(apiKey string, ai *services.AIService) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := providerOverride(ctx, info.FullMethod, apiKey, ai)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ProviderOverrideStreamInterceptor applies admin provider overrides to
+// streaming AI calls, for every message on the stream
+func ProviderOverrideStreamInterceptor(apiKey string, ai *services.AIService) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := providerOverride(ss.Context(), info.FullMethod, apiKey, ai)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &overrideStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type overrideStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *overrideStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	"github.com/clarity/backend/middleware"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// newOwnershipTestServer returns a records server over user-1's record
+// rec-1 and user-2's record rec-2
+func newOwnershipTestServer(t *testing.T) (*HealthRecordsServer, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	for _, user := range []string{"user-1", "user-2"} {
+		if err := db.Create(&models.User{ID: user, Email: user + "@example.com"}).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	for id, user := range map[string]string{"rec-1": "user-1", "rec-2": "user-2"} {
+		record := models.HealthRecord{ID: id, UserID: user, RecordType: "lab_result", Title: "Title of " + id, Metadata: "{}"}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatalf("create record: %v", err)
+		}
+	}
+	service := services.NewHealthRecordsService(db, &config.RecordsConfig{}, nil, nil)
+	return NewHealthRecordsServer(service, nil, nil, nil, nil), db
+}
+
+func TestRecordRPCsRejectOtherUsersRecords(t *testing.T) {
+	server, db := newOwnershipTestServer(t)
+	ctx := middleware.WithUserID(context.Background(), "user-1")
+
+	// The caller's own record works
+	record, err := server.GetRecord(ctx, &healthpb.GetRecordRequest{RecordId: "rec-1"})
+	if err != nil || record.Title != "Title of rec-1" {
+		t.Fatalf("GetRecord of the caller's record = %+v, %v", record, err)
+	}
+
+	// Another user's record looks the same as one that does not exist
+	for _, id := range []string{"rec-2", "missing"} {
+		if _, err := server.GetRecord(ctx, &healthpb.GetRecordRequest{RecordId: id}); status.Code(err) != codes.NotFound {
+			t.Errorf("GetRecord(%s): %v, want %v", id, err, codes.NotFound)
+		}
+		if _, err := server.UpdateRecord(ctx, &healthpb.UpdateRecordRequest{RecordId: id, Title: "Taken over"}); status.Code(err) != codes.NotFound {
+			t.Errorf("UpdateRecord(%s): %v, want %v", id, err, codes.NotFound)
+		}
+		if _, err := server.DeleteRecord(ctx, &healthpb.DeleteRecordRequest{RecordId: id}); status.Code(err) != codes.NotFound {
+			t.Errorf("DeleteRecord(%s): %v, want %v", id, err, codes.NotFound)
+		}
+	}
+
+	// and is left as it was
+	var other models.HealthRecord
+	if err := db.First(&other, "id = ?", "rec-2").Error; err != nil {
+		t.Fatalf("user-2's record is gone: %v", err)
+	}
+	if other.Title != "Title of rec-2" || other.UserID != "user-2" {
+		t.Errorf("user-2's record after user-1's attempts = %+v", other)
+	}
+
+	// Its owner still has it
+	owner := middleware.WithUserID(context.Background(), "user-2")
+	if record, err := server.GetRecord(owner, &healthpb.GetRecordRequest{RecordId: "rec-2"}); err != nil || record.Title != "Title of rec-2" {
+		t.Errorf("GetRecord by the owner = %+v, %v", record, err)
+	}
+}
+
+func TestRecordRPCsRequireACaller(t *testing.T) {
+	server, _ := newOwnershipTestServer(t)
+	ctx := context.Background()
+
+	if _, err := server.GetRecord(ctx, &healthpb.GetRecordRequest{RecordId: "rec-1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetRecord without a caller: %v, want %v", err, codes.Unauthenticated)
+	}
+	if _, err := server.UpdateRecord(ctx, &healthpb.UpdateRecordRequest{RecordId: "rec-1", Title: "x"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("UpdateRecord without a caller: %v, want %v", err, codes.Unauthenticated)
+	}
+	if _, err := server.DeleteRecord(ctx, &healthpb.DeleteRecordRequest{RecordId: "rec-1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("DeleteRecord without a caller: %v, want %v", err, codes.Unauthenticated)
+	}
+}
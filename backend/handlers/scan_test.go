@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	"github.com/clarity/backend/middleware"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/services"
+)
+
+// newTestAIServer returns an AIServer on the mock provider with user-1
+// signed up
+func newTestAIServer(t *testing.T) *AIServer {
+	t.Helper()
+	db := newTestDB(t)
+	if err := db.Create(&models.User{ID: "user-1", Email: "user-1@example.com"}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	aiService := services.NewAIService(db, &config.AIConfig{Provider: "mock"}, nil, nil, nil, nil, 0, nil, nil)
+	return NewAIServer(aiService, nil, 1)
+}
+
+func TestScanPrescriptionRawText(t *testing.T) {
+	server := newTestAIServer(t)
+	ctx := middleware.WithUserID(context.Background(), "user-1")
+
+	resp, err := server.ScanPrescription(ctx, &aipb.ScanPrescriptionRequest{ImageData: []byte("image"), Force: true})
+	if err != nil || !resp.Success || resp.RecordId == "" {
+		t.Fatalf("ScanPrescription = %+v, %v; want the saved record's ID", resp, err)
+	}
+	if resp.RawText != nil {
+		t.Errorf("raw text sent without being asked for: %+v", resp.RawText)
+	}
+
+	resp, err = server.ScanPrescription(ctx, &aipb.ScanPrescriptionRequest{ImageData: []byte("image"), Force: true, IncludeRawText: true})
+	if err != nil || !resp.Success {
+		t.Fatalf("ScanPrescription with raw text = %+v, %v", resp, err)
+	}
+	if resp.RawText == nil || !strings.Contains(resp.RawText.Text, resp.ExtractedData["medication"]) {
+		t.Fatalf("raw text = %+v, want the text holding %q", resp.RawText, resp.ExtractedData["medication"])
+	}
+	words := strings.Fields(resp.RawText.Text)
+	if len(resp.RawText.Tokens) != len(words) {
+		t.Fatalf("%d tokens for %d words", len(resp.RawText.Tokens), len(words))
+	}
+	for i, token := range resp.RawText.Tokens {
+		if token.Text != words[i] || token.Confidence <= 0 || token.Confidence > 1 {
+			t.Errorf("token %d = %+v", i, token)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/clarity/backend/services"
+	"github.com/clarity/backend/tenancy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tenantHeader is the metadata key a client names its tenant with before
+// it has an access token, e.g. to sign in
+const tenantHeader = "x-tenant-id"
+
+// healthServicePrefix marks the gRPC health service, which touches no
+// tenant's data
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// resolveTenant returns ctx acting for the caller's tenant in a
+// multi-tenant deployment. The tenant comes from the bearer access token
+// in the authorization metadata, or failing that from x-tenant-id; when a
+// call carries both they must agree. Calls without a tenant are refused,
+// so no statement runs against a database it was not meant for.
+func resolveTenant(ctx context.Context, fullMethod string, tenants map[string]bool, auth *services.AuthService) (context.Context, error) {
+	if len(tenants) == 0 || strings.HasPrefix(fullMethod, healthServicePrefix) {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var tenant string
+	if values := md.Get(tenantHeader); len(values) > 0 {
+		tenant = strings.TrimSpace(values[0])
+	}
+	if values := md.Get("authorization"); len(values) > 0 {
+		token, ok := strings.CutPrefix(values[0], "Bearer ")
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+		}
+		claims, err := auth.ParseToken(strings.TrimSpace(token))
+		if err != nil {
+			return nil, toStatusError(err)
+		}
+		if claims.Type != services.TokenTypeAccess {
+			return nil, status.Error(codes.Unauthenticated, "not an access token")
+		}
+		if tenant != "" && tenant != claims.Tenant {
+			return nil, status.Error(codes.PermissionDenied, "token belongs to another tenant")
+		}
+		tenant = claims.Tenant
+	}
+
+	if tenant == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "tenant required in %s metadata", tenantHeader)
+	}
+	if !tenants[tenant] {
+		return nil, status.Errorf(codes.PermissionDenied, "unknown tenant %q", tenant)
+	}
+	return tenancy.WithTenant(ctx, tenant), nil
+}
+
+// TenantUnaryInterceptor routes unary calls to the caller's tenant. With
+// no tenants configured it passes every call through.
+func TenantUnaryInterceptor(tenants []string, auth *services.AuthService) grpc.UnaryServerInterceptor {
+	known := tenantSet(tenants)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := resolveTenant(ctx, info.FullMethod, known, auth)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TenantStreamInterceptor routes streaming calls to the caller's tenant
+func TenantStreamInterceptor(tenants []string, auth *services.AuthService) grpc.StreamServerInterceptor {
+	known := tenantSet(tenants)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := resolveTenant(ss.Context(), info.FullMethod, known, auth)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &overrideStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func tenantSet(tenants []string) map[string]bool {
+	known := make(map[string]bool, len(tenants))
+	for _, id := range tenants {
+		known[id] = true
+	}
+	return known
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/services"
+	"github.com/clarity/backend/tenancy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+var otpInBody = regexp.MustCompile(`sign-in code is (\d+)`)
+
+// tenantTokens signs a user in for tenant and returns their access and
+// refresh tokens. One database stands in for every tenant's; only the
+// tokens' tenant claim matters here.
+func tenantTokens(t *testing.T, db *gorm.DB, auth *services.AuthService, tenant, email string) (string, string) {
+	t.Helper()
+	ctx := tenancy.WithTenant(context.Background(), tenant)
+	reference, _, err := auth.SendOTP(ctx, email)
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	var delivery models.Delivery
+	if err := db.First(&delivery, "id = ?", reference).Error; err != nil {
+		t.Fatalf("no delivery %s: %v", reference, err)
+	}
+	code := otpInBody.FindStringSubmatch(delivery.Body)
+	if code == nil {
+		t.Fatalf("delivery body carries no code: %q", delivery.Body)
+	}
+	_, access, refresh, err := auth.VerifyOTP(ctx, email, code[1], "device-1")
+	if err != nil {
+		t.Fatalf("VerifyOTP: %v", err)
+	}
+	return access, refresh
+}
+
+func TestTenantInterceptor(t *testing.T) {
+	db := newTestDB(t)
+	queue := services.NewDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 1}, map[string]services.Sender{
+		models.DeliveryChannelEmail: services.NewLogEmailSender(),
+	}, nil)
+	auth := services.NewAuthService(db, &config.AuthConfig{JWTSecret: "tenant-secret", OTPLength: 6, OTPExpiry: 600}, queue, nil)
+	acmeAccess, acmeRefresh := tenantTokens(t, db, auth, "acme", "a@acme.example")
+	otherSecret := services.NewAuthService(db, &config.AuthConfig{JWTSecret: "another-secret", OTPLength: 6, OTPExpiry: 600}, queue, nil)
+	forged, _ := tenantTokens(t, db, otherSecret, "acme", "b@acme.example")
+
+	const listRecords = "/clarity.health.HealthRecordsService/ListRecords"
+	tests := []struct {
+		name    string
+		tenants []string
+		method  string
+		md      metadata.MD
+		want    codes.Code
+		tenant  string
+	}{
+		{"tenant from the token", []string{"acme", "globex"}, listRecords, metadata.Pairs("authorization", "Bearer "+acmeAccess), codes.OK, "acme"},
+		{"tenant from the header", []string{"acme", "globex"}, "/clarity.auth.AuthService/SendOTP", metadata.Pairs(tenantHeader, "globex"), codes.OK, "globex"},
+		{"header agreeing with the token", []string{"acme", "globex"}, listRecords, metadata.Pairs("authorization", "Bearer "+acmeAccess, tenantHeader, "acme"), codes.OK, "acme"},
+		{"header naming another tenant", []string{"acme", "globex"}, listRecords, metadata.Pairs("authorization", "Bearer "+acmeAccess, tenantHeader, "globex"), codes.PermissionDenied, ""},
+		{"token of a tenant no longer configured", []string{"globex"}, listRecords, metadata.Pairs("authorization", "Bearer "+acmeAccess), codes.PermissionDenied, ""},
+		{"unknown tenant header", []string{"acme", "globex"}, "/clarity.auth.AuthService/SendOTP", metadata.Pairs(tenantHeader, "initech"), codes.PermissionDenied, ""},
+		{"refresh token", []string{"acme", "globex"}, listRecords, metadata.Pairs("authorization", "Bearer "+acmeRefresh), codes.Unauthenticated, ""},
+		{"token signed with another secret", []string{"acme", "globex"}, listRecords, metadata.Pairs("authorization", "Bearer "+forged, tenantHeader, "acme"), codes.Unauthenticated, ""},
+		{"no tenant", []string{"acme", "globex"}, "/clarity.auth.AuthService/SendOTP", metadata.MD{}, codes.Unauthenticated, ""},
+		{"health checks need no tenant", []string{"acme", "globex"}, "/grpc.health.v1.Health/Check", metadata.MD{}, codes.OK, ""},
+		{"single database", nil, listRecords, metadata.MD{}, codes.OK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tenant string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				tenant, _ = tenancy.FromContext(ctx)
+				return nil, nil
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			_, err := TenantUnaryInterceptor(tt.tenants, auth)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("code = %v, want %v (%v)", got, tt.want, err)
+			}
+			if tenant != tt.tenant {
+				t.Errorf("handler acted for tenant %q, want %q", tenant, tt.tenant)
+			}
+		})
+	}
+}
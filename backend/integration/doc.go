@@ -0,0 +1,5 @@
+// Package integration runs the server end to end: requests go over an
+// in-memory gRPC connection through the interceptor chain, handlers and
+// services to a SQLite database, with the sandbox AI provider, so journeys
+// spanning several RPCs can be tested without external services.
+package integration
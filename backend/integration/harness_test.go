@@ -0,0 +1,206 @@
+package integration
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/database"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	authpb "github.com/clarity/backend/gen/go/auth"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	"github.com/clarity/backend/logging"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// clock is a settable clock safe to read from the server's goroutines
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// emailOutbox is an email sender keeping every message it is given
+type emailOutbox struct {
+	mu   sync.Mutex
+	sent []models.Delivery
+}
+
+func (o *emailOutbox) Send(ctx context.Context, d *models.Delivery) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, *d)
+	return nil
+}
+
+var otpPattern = regexp.MustCompile(`sign-in code is (\d+)`)
+
+// lastOTP returns the code in the latest email sent to address
+func (o *emailOutbox) lastOTP(address string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.sent) - 1; i >= 0; i-- {
+		if o.sent[i].Recipient != address {
+			continue
+		}
+		if m := otpPattern.FindStringSubmatch(o.sent[i].Body); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// harness is a server running in memory and clients connected to it
+type harness struct {
+	t      *testing.T
+	server *server.Server
+	clock  *clock
+	email  *emailOutbox
+
+	auth    authpb.AuthServiceClient
+	records healthpb.HealthRecordsServiceClient
+	ai      aipb.AIServiceClient
+}
+
+// testConfig is the default configuration with a database private to t
+// and the sandbox AI provider
+func testConfig(t *testing.T) *config.Config {
+	cfg := config.LoadConfig()
+	cfg.Database = config.DatabaseConfig{
+		Type: "sqlite",
+		Path: "file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared",
+	}
+	cfg.Auth.JWTSecret = "integration-secret"
+	cfg.AI.Provider = "sandbox"
+	cfg.AI.ProviderOverrides = nil
+	cfg.AI.SelfTest = false
+	cfg.AI.STTProvider = "mock"
+	cfg.Delivery.SMTPHost = ""
+	cfg.Cache.Backend = "memory"
+	cfg.Records.TypesPath = ""
+	cfg.Reference.MedicationDatasetPath = ""
+	cfg.Versioning.MinClientVersions = nil
+	cfg.Features.Disabled = nil
+	cfg.Features.DisabledEndpoints = nil
+	cfg.Maintenance.Enabled = false
+	return cfg
+}
+
+// newHarness starts a server configured by testConfig, after letting
+// configure change it, and connects clients to it. Everything is shut
+// down when t ends.
+func newHarness(t *testing.T, configure func(*config.Config)) *harness {
+	t.Helper()
+	cfg := testConfig(t)
+	if configure != nil {
+		configure(cfg)
+	}
+
+	db, err := database.NewDatabase(&cfg.Database)
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	logControl, err := logging.Setup("error")
+	if err != nil {
+		t.Fatalf("logging.Setup: %v", err)
+	}
+
+	h := &harness{
+		t:     t,
+		clock: &clock{now: time.Now()},
+		email: &emailOutbox{},
+	}
+	h.server, err = server.New(cfg, db, logControl, server.Options{EmailSender: h.email, Now: h.clock.Now})
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	go h.server.GRPC.Serve(listener)
+	t.Cleanup(h.server.GRPC.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	h.auth = authpb.NewAuthServiceClient(conn)
+	h.records = healthpb.NewHealthRecordsServiceClient(conn)
+	h.ai = aipb.NewAIServiceClient(conn)
+	return h
+}
+
+// session is a signed-in user
+type session struct {
+	userID       string
+	deviceID     string
+	accessToken  string
+	refreshToken string
+}
+
+// ctx returns a context carrying the session's access token
+func (s *session) ctx() context.Context {
+	return withToken(s.accessToken)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+// signIn signs email in with the code emailed to it, the way the app does
+func (h *harness) signIn(email, deviceID string) *session {
+	h.t.Helper()
+	ctx := context.Background()
+	if _, err := h.auth.SendOTP(ctx, &authpb.SendOTPRequest{Email: email}); err != nil {
+		h.t.Fatalf("SendOTP(%s): %v", email, err)
+	}
+	if _, err := h.server.Deliveries.ProcessDue(ctx); err != nil {
+		h.t.Fatalf("deliver OTP: %v", err)
+	}
+	code := h.email.lastOTP(email)
+	if code == "" {
+		h.t.Fatalf("no sign-in code emailed to %s", email)
+	}
+
+	resp, err := h.auth.VerifyOTP(ctx, &authpb.VerifyOTPRequest{Email: email, Otp: code, DeviceId: deviceID})
+	if err != nil || !resp.Success {
+		h.t.Fatalf("VerifyOTP(%s) = %v, %v", email, resp, err)
+	}
+	return &session{
+		userID:       resp.User.Id,
+		deviceID:     deviceID,
+		accessToken:  resp.AccessToken,
+		refreshToken: resp.RefreshToken,
+	}
+}
@@ -0,0 +1,331 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	authpb "github.com/clarity/backend/gen/go/auth"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSignInWithEmailedCode(t *testing.T) {
+	h := newHarness(t, nil)
+
+	alice := h.signIn("alice@example.com", "phone-1")
+	profile, err := h.auth.GetProfile(alice.ctx(), &authpb.GetProfileRequest{})
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if profile.Id != alice.userID || profile.Email != "alice@example.com" {
+		t.Errorf("profile = %s <%s>, want %s <alice@example.com>", profile.Id, profile.Email, alice.userID)
+	}
+
+	// Signing in again reaches the same account
+	if again := h.signIn("alice@example.com", "tablet-1"); again.userID != alice.userID {
+		t.Errorf("second sign-in made user %s, want %s", again.userID, alice.userID)
+	}
+
+	if _, err := h.auth.GetProfile(context.Background(), &authpb.GetProfileRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetProfile without a token: %v, want Unauthenticated", err)
+	}
+	if _, err := h.auth.GetProfile(withToken(alice.accessToken+"x"), &authpb.GetProfileRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetProfile with a tampered token: %v, want Unauthenticated", err)
+	}
+
+	if _, err := h.auth.SendOTP(context.Background(), &authpb.SendOTPRequest{Email: "bob@example.com"}); err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	wrong := "000000"
+	if h.email.lastOTP("bob@example.com") == wrong {
+		wrong = "111111"
+	}
+	resp, err := h.auth.VerifyOTP(context.Background(), &authpb.VerifyOTPRequest{Email: "bob@example.com", Otp: wrong, DeviceId: "phone-2"})
+	if err != nil || resp.Success || resp.AccessToken != "" {
+		t.Errorf("VerifyOTP with a wrong code = %v, %v; want Success unset and no token", resp, err)
+	}
+}
+
+func TestRecordsBelongToTheirOwner(t *testing.T) {
+	h := newHarness(t, nil)
+	alice := h.signIn("alice@example.com", "phone-1")
+	bob := h.signIn("bob@example.com", "phone-2")
+
+	created, err := h.records.CreateRecord(alice.ctx(), &healthpb.CreateRecordRequest{
+		RecordType:  "lab_result",
+		Title:       "Fasting glucose",
+		Description: "Morning test",
+		Metadata:    map[string]string{"glucose": "95 mg/dL"},
+	})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if created.UserId != alice.userID {
+		t.Errorf("record owned by %s, want %s", created.UserId, alice.userID)
+	}
+
+	if _, err := h.records.UpdateRecord(alice.ctx(), &healthpb.UpdateRecordRequest{
+		RecordId:    created.Id,
+		Title:       "Fasting glucose",
+		Description: "Retested after a week",
+		Metadata:    map[string]string{"glucose": "101 mg/dL"},
+	}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	list, err := h.records.ListRecords(alice.ctx(), &healthpb.ListRecordsRequest{})
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if list.Total != 1 || list.Records[0].Description != "Retested after a week" || list.Records[0].Metadata["glucose"] != "101 mg/dL" {
+		t.Errorf("alice's records = %v, want the updated record", list.Records)
+	}
+
+	// Bob can neither see nor change Alice's record
+	if _, err := h.records.GetRecord(bob.ctx(), &healthpb.GetRecordRequest{RecordId: created.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("bob GetRecord: %v, want NotFound", err)
+	}
+	if _, err := h.records.UpdateRecord(bob.ctx(), &healthpb.UpdateRecordRequest{RecordId: created.Id, Title: "Mine now"}); status.Code(err) != codes.NotFound {
+		t.Errorf("bob UpdateRecord: %v, want NotFound", err)
+	}
+	if _, err := h.records.DeleteRecord(bob.ctx(), &healthpb.DeleteRecordRequest{RecordId: created.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("bob DeleteRecord: %v, want NotFound", err)
+	}
+	if _, err := h.records.ListRecords(bob.ctx(), &healthpb.ListRecordsRequest{UserId: alice.userID}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("bob listing alice's records: %v, want PermissionDenied", err)
+	}
+	if list, err := h.records.ListRecords(bob.ctx(), &healthpb.ListRecordsRequest{}); err != nil || list.Total != 0 {
+		t.Errorf("bob's records = %v, %v; want none", list, err)
+	}
+
+	got, err := h.records.GetRecord(alice.ctx(), &healthpb.GetRecordRequest{RecordId: created.Id})
+	if err != nil || got.Description != "Retested after a week" {
+		t.Fatalf("record after bob's attempts = %v, %v", got, err)
+	}
+
+	if _, err := h.records.DeleteRecord(alice.ctx(), &healthpb.DeleteRecordRequest{RecordId: created.Id}); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if _, err := h.records.GetRecord(alice.ctx(), &healthpb.GetRecordRequest{RecordId: created.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetRecord after delete: %v, want NotFound", err)
+	}
+	if list, err := h.records.ListRecords(alice.ctx(), &healthpb.ListRecordsRequest{}); err != nil || list.Total != 0 {
+		t.Errorf("records after delete = %v, %v; want none", list, err)
+	}
+}
+
+func TestScannedPrescriptionBecomesARecord(t *testing.T) {
+	h := newHarness(t, nil)
+	alice := h.signIn("alice@example.com", "phone-1")
+
+	scan, err := h.ai.ScanPrescription(alice.ctx(), &aipb.ScanPrescriptionRequest{
+		ImageData: []byte("photo of a prescription"),
+		ImageType: "jpeg",
+		Force:     true,
+	})
+	if err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if !scan.Success || scan.ScanId == "" || scan.RecordId == "" || scan.ExtractedData["medication"] == "" {
+		t.Fatalf("scan = %+v, want a medication, a scan ID and a record ID", scan)
+	}
+
+	record, err := h.records.GetRecord(alice.ctx(), &healthpb.GetRecordRequest{RecordId: scan.RecordId})
+	if err != nil {
+		t.Fatalf("GetRecord of the scanned record: %v", err)
+	}
+	if record.RecordType != "prescription" || record.Title != scan.ExtractedData["medication"] {
+		t.Errorf("scanned record = %+v, want a prescription for %s", record, scan.ExtractedData["medication"])
+	}
+
+	list, err := h.records.ListRecords(alice.ctx(), &healthpb.ListRecordsRequest{RecordType: "prescription"})
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if list.Total != 1 || list.Records[0].Id != scan.RecordId || list.Records[0].Metadata["scan_id"] != scan.ScanId {
+		t.Errorf("prescriptions = %v, want the scanned one linked to scan %s", list.Records, scan.ScanId)
+	}
+}
+
+func TestDoctorChatOverStream(t *testing.T) {
+	h := newHarness(t, nil)
+	alice := h.signIn("alice@example.com", "phone-1")
+
+	ctx, cancel := context.WithTimeout(alice.ctx(), 10*time.Second)
+	defer cancel()
+	stream, err := h.ai.DoctorChat(ctx)
+	if err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+
+	turns := []struct{ message, mentions string }{
+		{"I have had a headache since Monday", "headache"},
+		{"Now I also have a fever", "fever"},
+	}
+	for _, turn := range turns {
+		if err := stream.Send(&aipb.DoctorChatRequest{ConversationId: "conversation-1", Message: turn.message, Stream: true}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		var reply *aipb.DoctorChatResponse
+		for reply == nil || reply.IsPartial {
+			if reply, err = stream.Recv(); err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+		}
+		if reply.Error != "" || reply.ConversationId != "conversation-1" || !reply.IsAi {
+			t.Fatalf("reply = %+v", reply)
+		}
+		if !strings.Contains(reply.Response, "sandbox response") || !strings.Contains(reply.Response, turn.mentions) {
+			t.Errorf("reply to %q = %q, want a sandbox reply about %s", turn.message, reply.Response, turn.mentions)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend: %v", err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("stream after closing: %v, want EOF", err)
+	}
+
+	found, err := h.ai.SearchConversations(alice.ctx(), &aipb.SearchConversationsRequest{Query: "fever"})
+	if err != nil {
+		t.Fatalf("SearchConversations: %v", err)
+	}
+	if len(found.Conversations) != 1 || found.Conversations[0].ConversationId != "conversation-1" {
+		t.Errorf("conversations mentioning fever = %v, want conversation-1", found.Conversations)
+	}
+
+	// Chatting needs a signed-in user
+	anonymous, err := h.ai.DoctorChat(context.Background())
+	if err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	anonymous.Send(&aipb.DoctorChatRequest{Message: "hello"})
+	if _, err := anonymous.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("chat without a token: %v, want Unauthenticated", err)
+	}
+}
+
+func TestRefreshAfterAccessTokenExpires(t *testing.T) {
+	h := newHarness(t, nil)
+	alice := h.signIn("alice@example.com", "phone-1")
+
+	if _, err := h.records.ListRecords(alice.ctx(), &healthpb.ListRecordsRequest{}); err != nil {
+		t.Fatalf("ListRecords with a fresh token: %v", err)
+	}
+
+	h.clock.Advance(25 * time.Hour)
+	if _, err := h.records.ListRecords(alice.ctx(), &healthpb.ListRecordsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("ListRecords with an expired token: %v, want Unauthenticated", err)
+	}
+
+	if _, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: alice.refreshToken, DeviceId: "another-device"}); status.Code(err) == codes.OK {
+		t.Error("refresh token accepted from another device")
+	}
+	refreshed, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: alice.refreshToken, DeviceId: alice.deviceID})
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if _, err := h.records.ListRecords(withToken(refreshed.AccessToken), &healthpb.ListRecordsRequest{}); err != nil {
+		t.Errorf("ListRecords with the refreshed token: %v", err)
+	}
+
+	// Refresh tokens are single-use
+	if _, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: alice.refreshToken, DeviceId: alice.deviceID}); status.Code(err) == codes.OK {
+		t.Error("a used refresh token was accepted again")
+	}
+}
+
+func TestReplayedRefreshTokenEndsTheAttackersAccess(t *testing.T) {
+	h := newHarness(t, nil)
+	alice := h.signIn("alice@example.com", "phone-1")
+
+	// An attacker who copied the refresh token and device ID gets in first
+	stolen, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: alice.refreshToken, DeviceId: alice.deviceID})
+	if err != nil {
+		t.Fatalf("attacker's RefreshToken: %v", err)
+	}
+	attacker := withToken(stolen.AccessToken)
+	if _, err := h.records.ListRecords(attacker, &healthpb.ListRecordsRequest{}); err != nil {
+		t.Fatalf("ListRecords with the attacker's token: %v", err)
+	}
+
+	// Alice's app then presents the same token, which revokes the session
+	if _, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: alice.refreshToken, DeviceId: alice.deviceID}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("replayed RefreshToken: %v, want Unauthenticated", err)
+	}
+
+	// Every token of the session stops working, long before it would expire
+	for name, ctx := range map[string]context.Context{"attacker's": attacker, "Alice's": alice.ctx()} {
+		if _, err := h.records.ListRecords(ctx, &healthpb.ListRecordsRequest{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("ListRecords with the %s access token after the replay: %v, want Unauthenticated", name, err)
+		}
+	}
+	if _, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: stolen.RefreshToken, DeviceId: alice.deviceID}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("attacker's refresh token after the replay: %v, want Unauthenticated", err)
+	}
+
+	// Signing in again starts a session the revocation does not touch
+	again := h.signIn("alice@example.com", "phone-1")
+	if _, err := h.records.ListRecords(again.ctx(), &healthpb.ListRecordsRequest{}); err != nil {
+		t.Errorf("ListRecords after signing in again: %v", err)
+	}
+}
+
+func TestLogoutEndsTheSession(t *testing.T) {
+	h := newHarness(t, nil)
+	alice := h.signIn("alice@example.com", "phone-1")
+	tablet := h.signIn("alice@example.com", "tablet-1")
+
+	if _, err := h.auth.Logout(alice.ctx(), &authpb.LogoutRequest{RefreshToken: alice.refreshToken, AccessToken: alice.accessToken}); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: alice.refreshToken, DeviceId: alice.deviceID}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken after logout: %v, want Unauthenticated", err)
+	}
+	if _, err := h.records.ListRecords(alice.ctx(), &healthpb.ListRecordsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListRecords with the signed-out access token: %v, want Unauthenticated", err)
+	}
+	if _, err := h.records.ListRecords(tablet.ctx(), &healthpb.ListRecordsRequest{}); err != nil {
+		t.Errorf("ListRecords on the other device: %v", err)
+	}
+
+	if _, err := h.auth.Logout(tablet.ctx(), &authpb.LogoutRequest{RefreshToken: tablet.refreshToken, AllSessions: true}); err != nil {
+		t.Fatalf("Logout everywhere: %v", err)
+	}
+	if _, err := h.auth.RefreshToken(context.Background(), &authpb.RefreshTokenRequest{RefreshToken: tablet.refreshToken, DeviceId: tablet.deviceID}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("RefreshToken after signing out everywhere: %v, want Unauthenticated", err)
+	}
+}
+
+func TestGuessingSignInCodesLocksTheEmail(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) { cfg.Auth.OTPMaxAttempts = 3 })
+	ctx := context.Background()
+	if _, err := h.auth.SendOTP(ctx, &authpb.SendOTPRequest{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	if _, err := h.server.Deliveries.ProcessDue(ctx); err != nil {
+		t.Fatalf("deliver OTP: %v", err)
+	}
+	wrong := "000000"
+	if h.email.lastOTP("alice@example.com") == wrong {
+		wrong = "111111"
+	}
+
+	for i := 1; i < 3; i++ {
+		resp, err := h.auth.VerifyOTP(ctx, &authpb.VerifyOTPRequest{Email: "alice@example.com", Otp: wrong, DeviceId: "phone-1"})
+		if err != nil || resp.Success {
+			t.Fatalf("wrong code %d = %v, %v; want Success unset", i, resp, err)
+		}
+	}
+	if _, err := h.auth.VerifyOTP(ctx, &authpb.VerifyOTPRequest{Email: "alice@example.com", Otp: wrong, DeviceId: "phone-1"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("third wrong code: %v, want ResourceExhausted", err)
+	}
+	if _, err := h.auth.SendOTP(ctx, &authpb.SendOTPRequest{Email: "alice@example.com"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("SendOTP while locked out: %v, want ResourceExhausted", err)
+	}
+}
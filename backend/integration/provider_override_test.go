@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const adminKey = "integration-admin-key"
+
+// chatOnce sends one doctor chat message and returns the final reply
+func (h *harness) chatOnce(ctx context.Context, message string) (*aipb.DoctorChatResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	stream, err := h.ai.DoctorChat(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&aipb.DoctorChatRequest{ConversationId: "conversation-1", Message: message}); err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := stream.Recv()
+		if err != nil || !reply.IsPartial {
+			return reply, err
+		}
+	}
+}
+
+func TestAdminProviderOverride(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.Admin.APIKey = adminKey
+		cfg.AI.ProviderOverrides = []string{"mock"}
+	})
+	alice := h.signIn("alice@example.com", "phone-1")
+
+	reply, err := h.chatOnce(alice.ctx(), "I have a headache")
+	if err != nil || !strings.Contains(reply.Response, "sandbox response") {
+		t.Fatalf("chat with the configured provider = %v, %v; want the sandbox", reply, err)
+	}
+
+	tests := []struct {
+		name     string
+		md       []string
+		code     codes.Code
+		provider string // whose reply is expected when the call succeeds
+	}{
+		{"admin choosing a listed provider", []string{"x-admin-key", adminKey, "x-ai-provider", "mock"}, codes.OK, "AI Doctor:"},
+		{"user choosing a provider", []string{"x-ai-provider", "mock"}, codes.PermissionDenied, ""},
+		{"wrong admin key", []string{"x-admin-key", "guess", "x-ai-provider", "mock"}, codes.PermissionDenied, ""},
+		{"admin choosing an unlisted provider", []string{"x-admin-key", adminKey, "x-ai-provider", "openai"}, codes.InvalidArgument, ""},
+		{"admin key without an override", []string{"x-admin-key", adminKey}, codes.OK, "sandbox response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(alice.ctx(), tt.md...)
+			reply, err := h.chatOnce(ctx, "I have a headache")
+			if status.Code(err) != tt.code {
+				t.Fatalf("chat = %v, %v; want %v", reply, err, tt.code)
+			}
+			if tt.code == codes.OK && !strings.Contains(reply.Response, tt.provider) {
+				t.Errorf("reply %q did not come from the provider answering %q", reply.Response, tt.provider)
+			}
+		})
+	}
+
+	// Unary AI calls are routed too
+	ctx := metadata.AppendToOutgoingContext(alice.ctx(), "x-ai-provider", "mock")
+	if _, err := h.ai.SummarizeHealth(ctx, &aipb.SummarizeHealthRequest{Days: 30}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("user overriding the summary provider: %v, want PermissionDenied", err)
+	}
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrAtCapacity is returned when a batch job cannot start because the
+// concurrency limit is reached and the wait queue is full or timed out
+var ErrAtCapacity = errors.New("too many batch jobs running, try again later")
+
+// Limiter caps how many heavy batch jobs run at once. Callers beyond the
+// limit wait in a bounded queue for up to the queue timeout.
+type Limiter struct {
+	slots        chan struct{}
+	queue        chan struct{}
+	queueTimeout time.Duration
+}
+
+// NewLimiter allows maxConcurrent jobs to run and up to maxQueued more to
+// wait for a slot. A non-positive maxConcurrent disables the limit.
+func NewLimiter(maxConcurrent, maxQueued int, queueTimeout time.Duration) *Limiter {
+	if maxConcurrent <= 0 {
+		return &Limiter{}
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+	return &Limiter{
+		slots:        make(chan struct{}, maxConcurrent),
+		queue:        make(chan struct{}, maxQueued),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire reserves a slot, waiting in the queue if every slot is taken.
+// The returned function releases the slot and must be called exactly once.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	// Every slot is busy; wait only if there is room in the queue
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return nil, ErrAtCapacity
+	}
+	defer func() { <-l.queue }()
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-timeout:
+		return nil, ErrAtCapacity
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Run executes fn once a slot is available
+func (l *Limiter) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
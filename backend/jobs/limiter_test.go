@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n callers are waiting in l's queue
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(l.queue) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want %d", len(l.queue), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterRejectsAtCapacity(t *testing.T) {
+	l := NewLimiter(2, 0, time.Second)
+	ctx := context.Background()
+
+	release1, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("first Acquire: %v", err)
+	}
+	release2, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("second Acquire: %v", err)
+	}
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrAtCapacity) {
+		t.Fatalf("Acquire over the limit: error = %v, want ErrAtCapacity", err)
+	}
+
+	release1()
+	release3, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire after a release: %v", err)
+	}
+	release2()
+	release3()
+}
+
+func TestLimiterQueuesUntilSlotFree(t *testing.T) {
+	l := NewLimiter(1, 1, time.Minute)
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		queuedRelease, err := l.Acquire(ctx)
+		if err == nil {
+			queuedRelease()
+		}
+		acquired <- err
+	}()
+	waitQueued(t, l, 1)
+
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrAtCapacity) {
+		t.Fatalf("Acquire with a full queue: error = %v, want ErrAtCapacity", err)
+	}
+	select {
+	case err := <-acquired:
+		t.Fatalf("queued caller returned %v before a slot was free", err)
+	default:
+	}
+
+	release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("queued caller: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued caller never got the freed slot")
+	}
+	if len(l.queue) != 0 {
+		t.Error("queue place not given back")
+	}
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	l := NewLimiter(1, 1, 20*time.Millisecond)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer release()
+
+	start := time.Now()
+	if _, err := l.Acquire(context.Background()); !errors.Is(err, ErrAtCapacity) {
+		t.Fatalf("Acquire after the queue timeout: error = %v, want ErrAtCapacity", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("gave up after %v, before the queue timeout", waited)
+	}
+}
+
+func TestLimiterQueuedCallerCancelled(t *testing.T) {
+	l := NewLimiter(1, 1, time.Minute)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Acquire(ctx)
+		done <- err
+	}()
+	waitQueued(t, l, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller: error = %v, want context.Canceled", err)
+	}
+	waitQueued(t, l, 0)
+}
+
+func TestLimiterRunBoundsConcurrency(t *testing.T) {
+	const limit, jobs = 3, 12
+	l := NewLimiter(limit, jobs, time.Minute)
+
+	var running, peak, completed int32
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.Run(context.Background(), func(ctx context.Context) error {
+				now := atomic.AddInt32(&running, 1)
+				for {
+					old := atomic.LoadInt32(&peak)
+					if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&completed, 1)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Run: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > limit {
+		t.Errorf("%d jobs ran at once, limit is %d", peak, limit)
+	}
+	if completed != jobs {
+		t.Errorf("%d of %d jobs completed", completed, jobs)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(0, 0, 0)
+	for i := 0; i < 100; i++ {
+		if _, err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire %d with no limit: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scheduler runs registered background tasks on fixed intervals until its
+// context is cancelled.
+type Scheduler struct {
+	tasks []task
+	wg    sync.WaitGroup
+}
+
+type task struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Register adds a task that runs every interval. Tasks with a non-positive
+// interval are treated as disabled and never run.
+func (s *Scheduler) Register(name string, interval time.Duration, run func(ctx context.Context) error) {
+	if interval <= 0 {
+		log.Printf("Background job %s disabled", name)
+		return
+	}
+	s.tasks = append(s.tasks, task{name: name, interval: interval, run: run})
+}
+
+// Start launches every registered task in its own goroutine
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go func(t task) {
+			defer s.wg.Done()
+			s.loop(ctx, t)
+		}(t)
+	}
+}
+
+// Wait blocks until all tasks have stopped after context cancellation
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, t task) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	log.Printf("Background job %s started (every %s)", t.name, t.interval)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Background job %s stopped", t.name)
+			return
+		case <-ticker.C:
+			if err := t.run(ctx); err != nil {
+				log.Printf("Background job %s failed: %v", t.name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package killswitch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminServicePrefix marks methods that can never be switched off, so an
+// operator cannot lock themselves out of turning endpoints back on
+const adminServicePrefix = "/clarity.admin.AdminService/"
+
+// Switch is a disabled endpoint
+type Switch struct {
+	Method     string // full gRPC method name
+	Reason     string // shown to callers
+	DisabledAt time.Time
+}
+
+// Switches are per-endpoint kill switches. A disabled endpoint is refused
+// with Unavailable before its handler runs, and can be switched back on at
+// runtime without a redeploy.
+type Switches struct {
+	mu       sync.RWMutex
+	methods  map[string]bool     // full names of every registered method
+	bare     map[string][]string // method name without service -> full names
+	disabled map[string]Switch
+	now      func() time.Time
+}
+
+func NewSwitches() *Switches {
+	return &Switches{
+		methods:  make(map[string]bool),
+		bare:     make(map[string][]string),
+		disabled: make(map[string]Switch),
+		now:      time.Now,
+	}
+}
+
+// Register records the methods of the services on a gRPC server so
+// switches can be set by name. Call it after every service is registered.
+func (s *Switches) Register(services map[string]grpc.ServiceInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for service, info := range services {
+		for _, method := range info.Methods {
+			full := "/" + service + "/" + method.Name
+			if s.methods[full] {
+				continue
+			}
+			s.methods[full] = true
+			s.bare[method.Name] = append(s.bare[method.Name], full)
+		}
+	}
+}
+
+// resolve turns "ScanPrescription" or "/clarity.ai.AIService/ScanPrescription"
+// into the full method name. Callers must hold mu.
+func (s *Switches) resolve(method string) (string, error) {
+	method = strings.TrimSpace(method)
+	if s.methods[method] {
+		return method, nil
+	}
+	switch full := s.bare[method]; len(full) {
+	case 0:
+		return "", fmt.Errorf("unknown method %q", method)
+	case 1:
+		return full[0], nil
+	default:
+		sorted := append([]string(nil), full...)
+		sort.Strings(sorted)
+		return "", fmt.Errorf("method %q is ambiguous, use one of %s", method, strings.Join(sorted, ", "))
+	}
+}
+
+// Disable switches an endpoint off. Disabling an endpoint that is already
+// off updates its reason. It reports whether the endpoint was enabled.
+func (s *Switches) Disable(method, reason string) (Switch, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	full, err := s.resolve(method)
+	if err != nil {
+		return Switch{}, false, err
+	}
+	if strings.HasPrefix(full, adminServicePrefix) {
+		return Switch{}, false, fmt.Errorf("admin methods cannot be disabled")
+	}
+
+	sw, ok := s.disabled[full]
+	if !ok {
+		sw = Switch{Method: full, DisabledAt: s.now()}
+	}
+	sw.Reason = reason
+	s.disabled[full] = sw
+	return sw, !ok, nil
+}
+
+// Enable switches an endpoint back on. It reports whether the endpoint was
+// disabled.
+func (s *Switches) Enable(method string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	full, err := s.resolve(method)
+	if err != nil {
+		return "", false, err
+	}
+	_, ok := s.disabled[full]
+	delete(s.disabled, full)
+	return full, ok, nil
+}
+
+// List returns the disabled endpoints in method order
+func (s *Switches) List() []Switch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switches := make([]Switch, 0, len(s.disabled))
+	for _, sw := range s.disabled {
+		switches = append(switches, sw)
+	}
+	sort.Slice(switches, func(i, j int) bool { return switches[i].Method < switches[j].Method })
+	return switches
+}
+
+// check returns the Unavailable error for a disabled method
+func (s *Switches) check(fullMethod string) error {
+	s.mu.RLock()
+	sw, ok := s.disabled[fullMethod]
+	s.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	message := name + " is temporarily disabled"
+	if sw.Reason != "" {
+		message += ": " + sw.Reason
+	}
+	return status.Error(codes.Unavailable, message)
+}
+
+// UnaryServerInterceptor refuses calls to disabled endpoints
+func (s *Switches) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.check(info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor refuses new streams to disabled endpoints.
+// Streams already open when an endpoint is disabled run to completion.
+func (s *Switches) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := s.check(info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,196 @@
+package killswitch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	scanMethod   = "/clarity.ai.AIService/ScanPrescription"
+	chatMethod   = "/clarity.ai.AIService/DoctorChat"
+	healthExport = "/clarity.health.HealthRecordsService/ExportRecords"
+	orgExport    = "/clarity.organization.OrganizationService/ExportRecords"
+	adminMethod  = "/clarity.admin.AdminService/SetEndpointEnabled"
+)
+
+// newTestSwitches returns switches over a few services, one method name
+// shared by two of them
+func newTestSwitches() *Switches {
+	s := NewSwitches()
+	s.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+	s.Register(map[string]grpc.ServiceInfo{
+		"clarity.ai.AIService": {Methods: []grpc.MethodInfo{
+			{Name: "ScanPrescription"},
+			{Name: "DoctorChat", IsClientStream: true, IsServerStream: true},
+		}},
+		"clarity.health.HealthRecordsService":      {Methods: []grpc.MethodInfo{{Name: "ExportRecords"}}},
+		"clarity.organization.OrganizationService": {Methods: []grpc.MethodInfo{{Name: "ExportRecords"}}},
+		"clarity.admin.AdminService":               {Methods: []grpc.MethodInfo{{Name: "SetEndpointEnabled"}}},
+	})
+	return s
+}
+
+// callUnary runs method through the unary interceptor, reporting whether
+// the handler ran
+func callUnary(s *Switches, method string) (bool, error) {
+	ran := false
+	_, err := s.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			ran = true
+			return nil, nil
+		})
+	return ran, err
+}
+
+// callStream opens a stream to method through the stream interceptor,
+// reporting whether the handler ran
+func callStream(s *Switches, method string) (bool, error) {
+	ran := false
+	err := s.StreamServerInterceptor()(nil, nil, &grpc.StreamServerInfo{FullMethod: method, IsClientStream: true, IsServerStream: true},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			ran = true
+			return nil
+		})
+	return ran, err
+}
+
+func TestDisabledEndpointIsRefused(t *testing.T) {
+	s := newTestSwitches()
+
+	sw, changed, err := s.Disable("ScanPrescription", "provider outage")
+	if err != nil || !changed {
+		t.Fatalf("Disable = %v, %v; want a change", changed, err)
+	}
+	if sw.Method != scanMethod || sw.Reason != "provider outage" || sw.DisabledAt.IsZero() {
+		t.Errorf("switch = %+v", sw)
+	}
+
+	ran, err := callUnary(s, scanMethod)
+	if ran || status.Code(err) != codes.Unavailable {
+		t.Fatalf("disabled call: ran %v, error = %v; want Unavailable before the handler", ran, err)
+	}
+	if msg := status.Convert(err).Message(); msg != "ScanPrescription is temporarily disabled: provider outage" {
+		t.Errorf("message = %q", msg)
+	}
+
+	// Everything else keeps working
+	for _, method := range []string{chatMethod, healthExport, adminMethod} {
+		if ran, err := callUnary(s, method); !ran || err != nil {
+			t.Errorf("%s while ScanPrescription is off: ran %v, %v", method, ran, err)
+		}
+	}
+
+	// Disabling again keeps the original time and updates the reason
+	later, changed, err := s.Disable(scanMethod, "")
+	if err != nil || changed || later.DisabledAt != sw.DisabledAt {
+		t.Errorf("second Disable = %+v, %v, %v", later, changed, err)
+	}
+	if _, err := callUnary(s, scanMethod); status.Convert(err).Message() != "ScanPrescription is temporarily disabled" {
+		t.Errorf("message without a reason = %q", status.Convert(err).Message())
+	}
+
+	method, changed, err := s.Enable("ScanPrescription")
+	if err != nil || !changed || method != scanMethod {
+		t.Fatalf("Enable = %s, %v, %v", method, changed, err)
+	}
+	if ran, err := callUnary(s, scanMethod); !ran || err != nil {
+		t.Errorf("re-enabled call: ran %v, %v", ran, err)
+	}
+	if _, changed, _ := s.Enable(scanMethod); changed {
+		t.Error("enabling an enabled endpoint reported a change")
+	}
+}
+
+func TestDisabledStreamIsRefused(t *testing.T) {
+	s := newTestSwitches()
+	if _, _, err := s.Disable("DoctorChat", "maintenance"); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+
+	ran, err := callStream(s, chatMethod)
+	if ran || status.Code(err) != codes.Unavailable {
+		t.Fatalf("disabled stream: ran %v, error = %v; want Unavailable", ran, err)
+	}
+	if !strings.Contains(status.Convert(err).Message(), "DoctorChat is temporarily disabled: maintenance") {
+		t.Errorf("message = %q", status.Convert(err).Message())
+	}
+	if ran, err := callUnary(s, scanMethod); !ran || err != nil {
+		t.Errorf("ScanPrescription while DoctorChat is off: ran %v, %v", ran, err)
+	}
+
+	if _, _, err := s.Enable(chatMethod); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if ran, err := callStream(s, chatMethod); !ran || err != nil {
+		t.Errorf("re-enabled stream: ran %v, %v", ran, err)
+	}
+}
+
+func TestSwitchNamesAreResolved(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		want    string
+		wantErr string
+	}{
+		{"bare name", "ScanPrescription", scanMethod, ""},
+		{"full name", scanMethod, scanMethod, ""},
+		{"surrounding space", "  DoctorChat ", chatMethod, ""},
+		{"full name of a shared method", orgExport, orgExport, ""},
+		{"unknown", "Frobnicate", "", `unknown method "Frobnicate"`},
+		{"wrong case", "scanprescription", "", "unknown method"},
+		{"ambiguous", "ExportRecords", "", "is ambiguous, use one of " + healthExport + ", " + orgExport},
+		{"admin by name", "SetEndpointEnabled", "", "admin methods cannot be disabled"},
+		{"admin in full", adminMethod, "", "admin methods cannot be disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSwitches()
+			sw, _, err := s.Disable(tt.method, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Disable(%q) error = %v, want %q", tt.method, err, tt.wantErr)
+				}
+				if len(s.List()) != 0 {
+					t.Errorf("failed Disable left %v switched off", s.List())
+				}
+				return
+			}
+			if err != nil || sw.Method != tt.want {
+				t.Fatalf("Disable(%q) = %s, %v; want %s", tt.method, sw.Method, err, tt.want)
+			}
+		})
+	}
+
+	s := newTestSwitches()
+	if _, _, err := s.Enable("Frobnicate"); err == nil {
+		t.Error("Enable of an unknown method succeeded")
+	}
+}
+
+func TestListIsInMethodOrder(t *testing.T) {
+	s := newTestSwitches()
+	for _, method := range []string{orgExport, scanMethod, healthExport, chatMethod} {
+		if _, _, err := s.Disable(method, "r"); err != nil {
+			t.Fatalf("Disable(%s): %v", method, err)
+		}
+	}
+	if _, _, err := s.Enable(healthExport); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	var got []string
+	for _, sw := range s.List() {
+		got = append(got, sw.Method)
+	}
+	want := []string{chatMethod, scanMethod, orgExport}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
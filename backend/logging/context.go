@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// requestIDHeader is the metadata key clients may use to supply a request ID
+const requestIDHeader = "x-request-id"
+
+// RequestInfo identifies the request a log record belongs to
+type RequestInfo struct {
+	RequestID string
+	UserID    string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo attaches request identity to ctx for log correlation
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the request identity attached to ctx
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// userIDGetter matches generated request messages that carry a user_id field
+type userIDGetter interface {
+	GetUserId() string
+}
+
+func requestInfo(ctx context.Context, req interface{}) RequestInfo {
+	info := RequestInfo{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 {
+			info.RequestID = ids[0]
+		}
+	}
+	if info.RequestID == "" {
+		info.RequestID = uuid.New().String()
+	}
+	if r, ok := req.(userIDGetter); ok {
+		info.UserID = r.GetUserId()
+	}
+	return info
+}
+
+// UnaryServerInterceptor attaches RequestInfo to each unary call's context
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(WithRequestInfo(ctx, requestInfo(ctx, req)), req)
+	}
+}
+
+// StreamServerInterceptor attaches RequestInfo (request ID only, since the
+// user is not known until the first message) to each stream's context
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := WithRequestInfo(ss.Context(), requestInfo(ss.Context(), nil))
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (cs *contextStream) Context() context.Context {
+	return cs.ctx
+}
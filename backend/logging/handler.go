@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// redactedKeys are attribute keys whose values are never logged, even at
+// debug level: message bodies, images, audio, and credentials.
+var redactedKeys = map[string]bool{
+	"message":     true,
+	"response":    true,
+	"transcript":  true,
+	"body":        true,
+	"content":     true,
+	"description": true,
+	"image":       true,
+	"image_data":  true,
+	"audio":       true,
+	"audio_data":  true,
+	"otp":         true,
+	"token":       true,
+	"password":    true,
+}
+
+const redactedValue = "[REDACTED]"
+
+// Handler wraps another slog.Handler with a runtime-adjustable level and
+// targeted debug logging: records below the global level are still emitted
+// when the request in ctx matches an active debug target.
+type Handler struct {
+	next    slog.Handler
+	level   *slog.LevelVar
+	targets *DebugTargets
+}
+
+// NewHandler wraps next, which should itself accept every level
+func NewHandler(next slog.Handler, level *slog.LevelVar, targets *DebugTargets) *Handler {
+	return &Handler{
+		next:    next,
+		level:   level,
+		targets: targets,
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= h.level.Level() {
+		return true
+	}
+	return level >= slog.LevelDebug && h.targets.Matches(ctx)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	if info, ok := RequestInfoFromContext(ctx); ok {
+		if info.RequestID != "" {
+			out.AddAttrs(slog.String("request_id", info.RequestID))
+		}
+		if info.UserID != "" {
+			out.AddAttrs(slog.String("user_id", info.UserID))
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redact(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redact(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted), level: h.level, targets: h.targets}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), level: h.level, targets: h.targets}
+}
+
+// redact replaces sensitive attribute values, recursing into groups.
+// Raw byte slices are always dropped since they are typically images or audio.
+func redact(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if redactedKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redactedValue)
+	}
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = redact(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		if _, ok := a.Value.Any().([]byte); ok {
+			return slog.String(a.Key, redactedValue)
+		}
+	}
+	return a
+}
@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLogger returns a logger at level whose JSON output lands in the
+// returned buffer
+func newTestLogger(level slog.Level, targets *DebugTargets) (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	levelVar := new(slog.LevelVar)
+	levelVar.Set(level)
+	base := slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return slog.New(NewHandler(base, levelVar, targets)), &buf
+}
+
+// logLines decodes each JSON record written to buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		lines = append(lines, record)
+	}
+	return lines
+}
+
+func TestHandlerTargetedDebug(t *testing.T) {
+	targets := NewDebugTargets()
+	if _, err := targets.Add("user-1", "", time.Hour); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := targets.Add("", "req-debug-", time.Hour); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"targeted user", WithRequestInfo(context.Background(), RequestInfo{RequestID: "r1", UserID: "user-1"}), true},
+		{"request ID prefix", WithRequestInfo(context.Background(), RequestInfo{RequestID: "req-debug-42", UserID: "user-2"}), true},
+		{"other user", WithRequestInfo(context.Background(), RequestInfo{RequestID: "r2", UserID: "user-2"}), false},
+		{"prefix in the middle", WithRequestInfo(context.Background(), RequestInfo{RequestID: "x-req-debug-1"}), false},
+		{"no request", context.Background(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, buf := newTestLogger(slog.LevelInfo, targets)
+			logger.DebugContext(tt.ctx, "cache miss", "key", "k1")
+			logger.InfoContext(tt.ctx, "request done")
+
+			lines := logLines(t, buf)
+			if got := len(lines) == 2; got != tt.want {
+				t.Fatalf("debug record emitted = %v, want %v (%d lines)", got, tt.want, len(lines))
+			}
+			if len(lines) == 0 || lines[len(lines)-1]["msg"] != "request done" {
+				t.Fatal("info record not emitted")
+			}
+		})
+	}
+}
+
+func TestHandlerRuntimeLevel(t *testing.T) {
+	var buf bytes.Buffer
+	c := &Controller{level: new(slog.LevelVar), Targets: NewDebugTargets()}
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), c.level, c.Targets))
+
+	if err := c.SetLevel("warn"); err != nil {
+		t.Fatalf("SetLevel: %v", err)
+	}
+	logger.Info("hidden")
+	if err := c.SetLevel("DEBUG"); err != nil {
+		t.Fatalf("SetLevel: %v", err)
+	}
+	logger.Debug("shown")
+	if c.Level() != "debug" {
+		t.Errorf("Level() = %q, want debug", c.Level())
+	}
+
+	lines := logLines(t, &buf)
+	if len(lines) != 1 || lines[0]["msg"] != "shown" {
+		t.Errorf("logged %v, want only the record after lowering the level", lines)
+	}
+	if err := c.SetLevel("verbose"); err == nil {
+		t.Error("SetLevel accepted an unknown level")
+	}
+}
+
+func TestHandlerRedactsSensitiveAttributes(t *testing.T) {
+	targets := NewDebugTargets()
+	targets.Add("user-1", "", time.Hour)
+	logger, buf := newTestLogger(slog.LevelInfo, targets)
+	ctx := WithRequestInfo(context.Background(), RequestInfo{RequestID: "r1", UserID: "user-1"})
+
+	logger.With("token", "jwt-secret").DebugContext(ctx, "chat turn",
+		"message", "I have chest pain",
+		"Transcript", "my head hurts",
+		"image_data", "base64...",
+		"frame", []byte{0xFF, 0xD8},
+		slog.Group("request", "body", "raw body", "size", 42),
+		"conversation_id", "conv-1",
+	)
+
+	lines := logLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("got %d records, want 1", len(lines))
+	}
+	record := lines[0]
+	for _, key := range []string{"token", "message", "Transcript", "image_data", "frame"} {
+		if record[key] != redactedValue {
+			t.Errorf("%s = %v, want it redacted", key, record[key])
+		}
+	}
+	group, _ := record["request"].(map[string]any)
+	if group["body"] != redactedValue || group["size"] != float64(42) {
+		t.Errorf("request group = %v, want body redacted and size kept", group)
+	}
+	if record["conversation_id"] != "conv-1" || record["request_id"] != "r1" || record["user_id"] != "user-1" {
+		t.Errorf("record = %v, want identifying attributes kept", record)
+	}
+	for _, secret := range []string{"chest pain", "head hurts", "jwt-secret", "raw body"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("output contains %q", secret)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package logging configures structured logging with a runtime-adjustable
+// level and time-bounded debug logging for individual users or requests.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Controller adjusts logging at runtime
+type Controller struct {
+	level   *slog.LevelVar
+	Targets *DebugTargets
+}
+
+// Setup installs the logging handler as the slog default, which also routes
+// the standard log package through it, and returns its controller
+func Setup(level string) (*Controller, error) {
+	c := &Controller{
+		level:   new(slog.LevelVar),
+		Targets: NewDebugTargets(),
+	}
+	if err := c.SetLevel(level); err != nil {
+		return nil, err
+	}
+
+	base := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	slog.SetDefault(slog.New(NewHandler(base, c.level, c.Targets)))
+	return c, nil
+}
+
+// Level returns the current global level name
+func (c *Controller) Level() string {
+	return strings.ToLower(c.level.Level().String())
+}
+
+// SetLevel changes the global level to debug, info, warn, or error
+func (c *Controller) SetLevel(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	c.level.Set(l)
+	return nil
+}
@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DebugTarget enables debug logging for requests from one user or whose
+// request ID starts with a prefix, until ExpiresAt.
+type DebugTarget struct {
+	ID              string
+	UserID          string
+	RequestIDPrefix string
+	ExpiresAt       time.Time
+}
+
+func (t DebugTarget) matches(info RequestInfo) bool {
+	if t.UserID != "" && t.UserID == info.UserID {
+		return true
+	}
+	return t.RequestIDPrefix != "" && strings.HasPrefix(info.RequestID, t.RequestIDPrefix)
+}
+
+// DebugTargets is the set of active debug targets. Expired targets stop
+// matching immediately and are pruned on the next change.
+type DebugTargets struct {
+	mu      sync.RWMutex
+	targets []DebugTarget
+	now     func() time.Time
+}
+
+func NewDebugTargets() *DebugTargets {
+	return &DebugTargets{now: time.Now}
+}
+
+// Add enables a target for duration and returns it
+func (dt *DebugTargets) Add(userID, requestIDPrefix string, duration time.Duration) (DebugTarget, error) {
+	if userID == "" && requestIDPrefix == "" {
+		return DebugTarget{}, fmt.Errorf("debug target needs a user ID or request ID prefix")
+	}
+	if duration <= 0 {
+		return DebugTarget{}, fmt.Errorf("debug target duration must be positive")
+	}
+
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	target := DebugTarget{
+		ID:              uuid.New().String(),
+		UserID:          userID,
+		RequestIDPrefix: requestIDPrefix,
+		ExpiresAt:       dt.now().Add(duration),
+	}
+	dt.targets = append(dt.active(), target)
+	return target, nil
+}
+
+// Remove disables a target early; it reports whether the target was active
+func (dt *DebugTargets) Remove(id string) bool {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	active := dt.active()
+	for i, t := range active {
+		if t.ID == id {
+			dt.targets = append(active[:i], active[i+1:]...)
+			return true
+		}
+	}
+	dt.targets = active
+	return false
+}
+
+// List returns the targets that have not yet expired
+func (dt *DebugTargets) List() []DebugTarget {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
+	var active []DebugTarget
+	now := dt.now()
+	for _, t := range dt.targets {
+		if now.Before(t.ExpiresAt) {
+			active = append(active, t)
+		}
+	}
+	return active
+}
+
+// Matches reports whether the request in ctx matches an unexpired target
+func (dt *DebugTargets) Matches(ctx context.Context) bool {
+	info, ok := RequestInfoFromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+
+	now := dt.now()
+	for _, t := range dt.targets {
+		if now.Before(t.ExpiresAt) && t.matches(info) {
+			return true
+		}
+	}
+	return false
+}
+
+// active returns unexpired targets; callers must hold mu
+func (dt *DebugTargets) active() []DebugTarget {
+	now := dt.now()
+	active := dt.targets[:0:0]
+	for _, t := range dt.targets {
+		if now.Before(t.ExpiresAt) {
+			active = append(active, t)
+		}
+	}
+	return active
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDebugTargetsExpire(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	targets := NewDebugTargets()
+	targets.now = func() time.Time { return now }
+	ctx := WithRequestInfo(context.Background(), RequestInfo{RequestID: "r1", UserID: "user-1"})
+
+	short, err := targets.Add("user-1", "", time.Minute)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := targets.Add("", "batch-", time.Hour); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if !short.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("ExpiresAt = %v, want a minute from now", short.ExpiresAt)
+	}
+	if !targets.Matches(ctx) {
+		t.Fatal("active target does not match")
+	}
+
+	now = now.Add(time.Minute)
+	if targets.Matches(ctx) {
+		t.Error("target still matches at its expiry")
+	}
+	if list := targets.List(); len(list) != 1 || list[0].RequestIDPrefix != "batch-" {
+		t.Errorf("List() = %+v, want only the unexpired target", list)
+	}
+	if targets.Remove(short.ID) {
+		t.Error("Remove reported an expired target as active")
+	}
+}
+
+func TestDebugTargetsRemove(t *testing.T) {
+	targets := NewDebugTargets()
+	target, err := targets.Add("user-1", "", time.Hour)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if !targets.Remove(target.ID) {
+		t.Fatal("Remove did not find the active target")
+	}
+	ctx := WithRequestInfo(context.Background(), RequestInfo{UserID: "user-1"})
+	if targets.Matches(ctx) {
+		t.Error("removed target still matches")
+	}
+	if targets.Remove(target.ID) {
+		t.Error("removed the same target twice")
+	}
+}
+
+func TestDebugTargetsAddValidates(t *testing.T) {
+	targets := NewDebugTargets()
+	tests := []struct {
+		name     string
+		userID   string
+		prefix   string
+		duration time.Duration
+	}{
+		{"no user or prefix", "", "", time.Hour},
+		{"zero duration", "user-1", "", 0},
+		{"negative duration", "", "req-", -time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := targets.Add(tt.userID, tt.prefix, tt.duration); err == nil {
+				t.Error("Add() accepted an invalid target")
+			}
+		})
+	}
+	if list := targets.List(); len(list) != 0 {
+		t.Errorf("invalid targets were kept: %+v", list)
+	}
+}
@@ -1,23 +1,51 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/clarity/backend/config"
 	"github.com/clarity/backend/database"
-	authpb "github.com/clarity/backend/gen/go/auth"
-	healthpb "github.com/clarity/backend/gen/go/health"
-	aipb "github.com/clarity/backend/gen/go/ai"
-	"github.com/clarity/backend/handlers"
-	"github.com/clarity/backend/services"
-	"google.golang.org/grpc"
+	"github.com/clarity/backend/gateway"
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/logging"
+	"github.com/clarity/backend/server"
+	"github.com/clarity/backend/tenancy"
 )
 
+// databasePingTimeout bounds the startup check that the database answers
+const databasePingTimeout = 10 * time.Second
+
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
+
+	logControl, err := logging.Setup(cfg.Logging.Level)
+	if err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
+	}
+
+	// Reload the log level from .env on SIGHUP
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			level := config.Reload().Logging.Level
+			if err := logControl.SetLevel(level); err != nil {
+				log.Printf("Ignoring SIGHUP reload: %v", err)
+				continue
+			}
+			log.Printf("Log level set to %s", level)
+		}
+	}()
+
 	log.Printf("Starting server on %s:%s", cfg.Server.Host, cfg.Server.Port)
 
 	// Initialize database
@@ -25,26 +53,107 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), databasePingTimeout)
+	err = db.Ping(pingCtx)
+	cancelPing()
+	if err != nil {
+		log.Fatalf("Database is unreachable: %v", err)
+	}
 
 	if err := db.Migrate(); err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	schemas, err := db.MigrationStatus()
+	if err != nil {
+		log.Fatalf("Failed to read database schema version: %v", err)
+	}
+	for _, schema := range schemas {
+		if schema.Tenant != "" {
+			log.Printf("Database schema of tenant %s at version %d", schema.Tenant, schema.Version)
+		} else {
+			log.Printf("Database schema at version %d", schema.Version)
+		}
+	}
 
-	dbConn := db.GetConnection()
+	tenants := db.Tenants()
 	defer db.Close()
 
-	// Initialize services
-	authService := services.NewAuthService(dbConn, &cfg.Auth)
-	healthService := services.NewHealthRecordsService(dbConn)
-	aiService := services.NewAIService(dbConn)
+	srv, err := server.New(cfg, db, logControl, server.Options{})
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
 
-	// Create gRPC server
-	grpcServer := grpc.NewServer()
+	// Start background jobs
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Register services
-	authpb.RegisterAuthServiceServer(grpcServer, handlers.NewAuthServer(authService))
-	healthpb.RegisterHealthRecordsServiceServer(grpcServer, handlers.NewHealthRecordsServer(healthService))
-	aipb.RegisterAIServiceServer(grpcServer, handlers.NewAIServer(aiService))
+	// Jobs that work on stored data run once for each tenant's database
+	perTenant := func(job func(ctx context.Context) error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			return tenancy.Each(ctx, tenants, job)
+		}
+	}
+
+	scheduler := jobs.NewScheduler()
+	scheduler.Register("reminder-dispatch", time.Duration(cfg.Jobs.ReminderInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.Reminders.DispatchDue(ctx)
+		return err
+	}))
+	scheduler.Register("search-reindex", time.Duration(cfg.Jobs.SearchReindexInterval)*time.Second, func(ctx context.Context) error {
+		return srv.BatchLimiter.Run(ctx, perTenant(func(ctx context.Context) error {
+			_, err := srv.Search.Reindex(ctx, false)
+			return err
+		}))
+	})
+	scheduler.Register("delivery-dispatch", time.Duration(cfg.Jobs.DeliveryInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.Deliveries.ProcessDue(ctx)
+		return err
+	}))
+	scheduler.Register("export-link-purge", time.Duration(cfg.Jobs.ExportPurgeInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.Exports.PurgeExpired(ctx)
+		return err
+	}))
+	scheduler.Register("tombstone-purge", time.Duration(cfg.Jobs.TombstonePurgeInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.HealthRecords.PurgeTombstones(ctx)
+		return err
+	}))
+	scheduler.Register("deleted-record-purge", time.Duration(cfg.Jobs.DeletedRecordPurgeInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.HealthRecords.PurgeDeletedRecords(ctx)
+		return err
+	}))
+	scheduler.Register("otp-purge", time.Duration(cfg.Jobs.OTPPurgeInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.Auth.PurgeExpiredOTPs(ctx)
+		return err
+	}))
+	scheduler.Register("conversation-purge", time.Duration(cfg.Jobs.ConversationPurgeInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.AI.PurgeStaleConversations(ctx)
+		return err
+	}))
+	scheduler.Register("delivery-purge", time.Duration(cfg.Jobs.DeliveryPurgeInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.Deliveries.PurgeFinished(ctx)
+		return err
+	}))
+	scheduler.Register("revoked-token-purge", time.Duration(cfg.Jobs.RevokedTokenPurgeInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.Auth.PurgeRevokedTokens(ctx)
+		return err
+	}))
+	scheduler.Register("medication-reminders", time.Duration(cfg.Jobs.MedicationInterval)*time.Second, perTenant(func(ctx context.Context) error {
+		_, err := srv.Medications.ExtendDoseReminders(ctx)
+		return err
+	}))
+	if srv.MedicationDataset != nil {
+		scheduler.Register("reference-refresh", time.Duration(cfg.Reference.WatchInterval)*time.Second, func(ctx context.Context) error {
+			_, err := srv.MedicationDataset.Refresh()
+			return err
+		})
+	}
+	scheduler.Register("reprocess", time.Duration(cfg.Jobs.ReprocessInterval)*time.Second, func(ctx context.Context) error {
+		return srv.BatchLimiter.Run(ctx, perTenant(func(ctx context.Context) error {
+			_, err := srv.Reprocess.RunPending(ctx)
+			return err
+		}))
+	})
+	scheduler.Start(ctx)
 
 	// Listen on port
 	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port))
@@ -54,7 +163,33 @@ func main() {
 
 	log.Printf("gRPC server listening on %s:%s", cfg.Server.Host, cfg.Server.Port)
 
-	if err := grpcServer.Serve(listener); err != nil {
+	// HTTP gateway for share links
+	var httpServer *http.Server
+	if cfg.Server.HTTPPort != "" {
+		httpServer = &http.Server{
+			Addr:              fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.HTTPPort),
+			Handler:           gateway.NewHandler(srv.Exports, gateway.NewGuard(&cfg.Gateway)),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("HTTP gateway listening on %s", httpServer.Addr)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("HTTP gateway error: %v", err)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Printf("Shutting down")
+		if httpServer != nil {
+			httpServer.Shutdown(context.Background())
+		}
+		srv.GRPC.GracefulStop()
+	}()
+
+	if err := srv.GRPC.Serve(listener); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
+	scheduler.Wait()
 }
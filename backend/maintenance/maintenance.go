@@ -0,0 +1,242 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/permissions"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// WritesHealthService is the gRPC health service name that reports
+// NOT_SERVING while maintenance mode refuses writes. The server's overall
+// status stays SERVING, since reads keep working.
+const WritesHealthService = "clarity.Writes"
+
+// allowedWrites are mutating methods served during maintenance. Token
+// refresh keeps signed-in clients signed in until it is over, and signing
+// out is never refused.
+var allowedWrites = []string{
+	"/clarity.auth.AuthService/RefreshToken",
+	"/clarity.auth.AuthService/Logout",
+}
+
+// State describes maintenance mode
+type State struct {
+	Enabled    bool
+	Reason     string // shown to callers
+	RetryAfter time.Duration
+	Since      time.Time
+}
+
+// Mode is the server's read-only maintenance flag. While it is on, methods
+// the permissions table marks as mutating are refused with Unavailable and
+// a retry delay; reads, token refresh, health checks and the admin service
+// carry on, the admin service so an operator can always end maintenance.
+// A method missing from the table is refused.
+type Mode struct {
+	mu       sync.Mutex
+	state    State
+	inFlight int           // mutating calls running
+	drained  chan struct{} // closed when inFlight reaches zero
+	allowed  map[string]bool
+	health   *health.Server
+	now      func() time.Time
+
+	defaultRetryAfter time.Duration
+	drainTimeout      time.Duration
+}
+
+func NewMode(cfg *config.MaintenanceConfig, healthServer *health.Server) *Mode {
+	m := &Mode{
+		allowed: make(map[string]bool, len(allowedWrites)),
+		health:  healthServer,
+		now:     time.Now,
+
+		defaultRetryAfter: time.Duration(cfg.RetryAfter) * time.Second,
+		drainTimeout:      time.Duration(cfg.DrainTimeout) * time.Second,
+	}
+	for _, method := range allowedWrites {
+		m.allowed[method] = true
+	}
+	healthServer.SetServingStatus(WritesHealthService, healthgrpc.HealthCheckResponse_SERVING)
+	return m
+}
+
+// Register checks the maintenance exemptions against the methods of the
+// services on a gRPC server, so a renamed method fails at startup instead
+// of being refused during maintenance. It returns how many registered
+// methods maintenance mode refuses. Call it after every service is
+// registered.
+func (m *Mode) Register(services map[string]grpc.ServiceInfo) (int, error) {
+	registered := make(map[string]bool)
+	writes := 0
+	for service, info := range services {
+		for _, method := range info.Methods {
+			full := "/" + service + "/" + method.Name
+			registered[full] = true
+			if m.refuses(full) {
+				writes++
+			}
+		}
+	}
+	for _, method := range allowedWrites {
+		if !registered[method] {
+			return 0, fmt.Errorf("maintenance exemption %s is not a registered method", method)
+		}
+	}
+	return writes, nil
+}
+
+// refuses reports whether fullMethod is refused while maintenance is on
+func (m *Mode) refuses(fullMethod string) bool {
+	method, ok := permissions.Lookup(fullMethod)
+	if !ok {
+		return true
+	}
+	return method.Mutates && !m.allowed[fullMethod] && method.Access != permissions.AccessAdminKey
+}
+
+// State returns the current maintenance state and the number of mutating
+// calls still running
+func (m *Mode) State() (State, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.inFlight
+}
+
+// Enter turns maintenance on, then waits up to the drain timeout for
+// mutating calls already running to finish. New writes are refused from
+// the moment it is called. A zero retryAfter uses the configured default.
+// It returns the number of calls still running when it stopped waiting;
+// entering when already on updates the reason and retry delay.
+func (m *Mode) Enter(ctx context.Context, reason string, retryAfter time.Duration) (State, int) {
+	if retryAfter <= 0 {
+		retryAfter = m.defaultRetryAfter
+	}
+
+	m.mu.Lock()
+	if !m.state.Enabled {
+		m.state = State{Enabled: true, Since: m.now()}
+		m.health.SetServingStatus(WritesHealthService, healthgrpc.HealthCheckResponse_NOT_SERVING)
+	}
+	m.state.Reason = reason
+	m.state.RetryAfter = retryAfter
+	state := m.state
+	if m.inFlight == 0 {
+		m.mu.Unlock()
+		return state, 0
+	}
+	if m.drained == nil {
+		m.drained = make(chan struct{})
+	}
+	drained := m.drained
+	m.mu.Unlock()
+
+	timer := time.NewTimer(m.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	_, remaining := m.State()
+	return state, remaining
+}
+
+// Exit turns maintenance off. It reports whether maintenance was on.
+func (m *Mode) Exit() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.state.Enabled {
+		return false
+	}
+	m.state = State{}
+	m.health.SetServingStatus(WritesHealthService, healthgrpc.HealthCheckResponse_SERVING)
+	return true
+}
+
+// begin admits a mutating call, or returns the Unavailable error refusing
+// it. Admitted calls must call end.
+func (m *Mode) begin() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state.Enabled {
+		return m.refusal()
+	}
+	m.inFlight++
+	return nil
+}
+
+func (m *Mode) end() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+	if m.inFlight == 0 && m.drained != nil {
+		close(m.drained)
+		m.drained = nil
+	}
+}
+
+// refusal builds the error for a refused call. Callers must hold mu.
+func (m *Mode) refusal() error {
+	message := "the service is in maintenance and not accepting changes"
+	if m.state.Reason != "" {
+		message += ": " + m.state.Reason
+	}
+	if m.state.RetryAfter > 0 {
+		message += fmt.Sprintf(" (retry in %s)", m.state.RetryAfter.Round(time.Second))
+	}
+
+	st := status.New(codes.Unavailable, message)
+	if m.state.RetryAfter > 0 {
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(m.state.RetryAfter)}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor refuses mutating calls during maintenance and
+// counts the ones running so Enter can wait for them
+func (m *Mode) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !m.refuses(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if err := m.begin(); err != nil {
+			return nil, err
+		}
+		defer m.end()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor refuses new mutating streams during maintenance.
+// An open stream counts as running until it ends, so Enter waits for open
+// chats up to its drain timeout.
+func (m *Mode) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !m.refuses(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		if err := m.begin(); err != nil {
+			return err
+		}
+		defer m.end()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,259 @@
+package maintenance
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	adminpb "github.com/clarity/backend/gen/go/admin"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	authpb "github.com/clarity/backend/gen/go/auth"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	orgpb "github.com/clarity/backend/gen/go/organization"
+	"github.com/clarity/backend/permissions"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func newTestMode(drainTimeout int) (*Mode, *health.Server) {
+	healthServer := health.NewServer()
+	return NewMode(&config.MaintenanceConfig{RetryAfter: 60, DrainTimeout: drainTimeout}, healthServer), healthServer
+}
+
+// callUnary runs method through the unary interceptor
+func callUnary(m *Mode, method string, handler grpc.UnaryHandler) error {
+	_, err := m.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	return err
+}
+
+func ok(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+
+// writesStatus returns the health status of WritesHealthService
+func writesStatus(t *testing.T, healthServer *health.Server) healthgrpc.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := healthServer.Check(context.Background(), &healthgrpc.HealthCheckRequest{Service: WritesHealthService})
+	if err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	return resp.Status
+}
+
+func TestMaintenanceRefusesWrites(t *testing.T) {
+	m, healthServer := newTestMode(1)
+	tests := []struct {
+		method  string
+		refused bool
+	}{
+		{"/clarity.health.HealthRecordsService/CreateRecord", true},
+		{"/clarity.health.HealthRecordsService/DeleteRecord", true},
+		{"/clarity.ai.AIService/ScanPrescription", true},
+		{"/clarity.auth.AuthService/VerifyOTP", true},
+		{"/clarity.health.HealthRecordsService/GetRecord", false},
+		{"/clarity.health.HealthRecordsService/ListRecords", false},
+		{"/clarity.ai.AIService/SummarizeHealth", false},
+		{"/clarity.auth.AuthService/RefreshToken", false},
+		{"/clarity.auth.AuthService/Logout", false},
+		{"/grpc.health.v1.Health/Check", false},
+		{"/clarity.admin.AdminService/SetMaintenanceMode", false},
+		{"/clarity.admin.AdminService/PurgeDeletedRecords", false},
+		{"/clarity.health.HealthRecordsService/ShareEverything", true},
+	}
+
+	for _, tt := range tests {
+		if err := callUnary(m, tt.method, ok); err != nil {
+			t.Errorf("%s before maintenance: %v", tt.method, err)
+		}
+	}
+	if got := writesStatus(t, healthServer); got != healthgrpc.HealthCheckResponse_SERVING {
+		t.Errorf("writes health before maintenance = %v, want SERVING", got)
+	}
+
+	state, running := m.Enter(context.Background(), "database upgrade", 0)
+	if !state.Enabled || running != 0 || state.RetryAfter != time.Minute {
+		t.Fatalf("Enter = %+v, %d running", state, running)
+	}
+	if got := writesStatus(t, healthServer); got != healthgrpc.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("writes health in maintenance = %v, want NOT_SERVING", got)
+	}
+	for _, tt := range tests {
+		err := callUnary(m, tt.method, ok)
+		if !tt.refused {
+			if err != nil {
+				t.Errorf("%s refused in maintenance: %v", tt.method, err)
+			}
+			continue
+		}
+		st := status.Convert(err)
+		if st.Code() != codes.Unavailable || !strings.Contains(st.Message(), "database upgrade") {
+			t.Errorf("%s in maintenance: %v, want Unavailable with the reason", tt.method, err)
+			continue
+		}
+		var retry *errdetails.RetryInfo
+		for _, detail := range st.Details() {
+			if d, ok := detail.(*errdetails.RetryInfo); ok {
+				retry = d
+			}
+		}
+		if retry == nil || retry.RetryDelay.AsDuration() != time.Minute {
+			t.Errorf("%s retry info = %v, want one minute", tt.method, retry)
+		}
+	}
+
+	if !m.Exit() {
+		t.Fatal("Exit reported maintenance was off")
+	}
+	if m.Exit() {
+		t.Error("second Exit reported maintenance was on")
+	}
+	if got := writesStatus(t, healthServer); got != healthgrpc.HealthCheckResponse_SERVING {
+		t.Errorf("writes health after maintenance = %v, want SERVING", got)
+	}
+	if err := callUnary(m, "/clarity.health.HealthRecordsService/CreateRecord", ok); err != nil {
+		t.Errorf("write after maintenance: %v", err)
+	}
+}
+
+// fakeServerStream is a server stream with a background context
+type fakeServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *fakeServerStream) Context() context.Context { return context.Background() }
+
+func TestMaintenanceRefusesStreams(t *testing.T) {
+	m, _ := newTestMode(1)
+	m.Enter(context.Background(), "", time.Second)
+
+	info := &grpc.StreamServerInfo{FullMethod: "/clarity.ai.AIService/DoctorChat", IsClientStream: true, IsServerStream: true}
+	err := m.StreamServerInterceptor()(nil, &fakeServerStream{}, info, func(srv interface{}, ss grpc.ServerStream) error { return nil })
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("chat in maintenance: %v, want Unavailable", err)
+	}
+
+	info.FullMethod = "/grpc.health.v1.Health/Watch"
+	if err := m.StreamServerInterceptor()(nil, &fakeServerStream{}, info, func(srv interface{}, ss grpc.ServerStream) error { return nil }); err != nil {
+		t.Errorf("health watch in maintenance: %v", err)
+	}
+}
+
+func TestEnterWaitsForRunningWrites(t *testing.T) {
+	m, _ := newTestMode(5)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+	go func() {
+		finished <- callUnary(m, "/clarity.health.HealthRecordsService/CreateRecord", func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+	}()
+	<-started
+
+	entered := make(chan int, 1)
+	go func() {
+		_, running := m.Enter(context.Background(), "", 0)
+		entered <- running
+	}()
+
+	// New writes are refused while the running one drains
+	deadline := time.Now().Add(time.Second)
+	for {
+		if state, _ := m.State(); state.Enabled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("maintenance not on while draining")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := callUnary(m, "/clarity.health.HealthRecordsService/UpdateRecord", ok); status.Code(err) != codes.Unavailable {
+		t.Errorf("write while draining: %v, want Unavailable", err)
+	}
+	select {
+	case <-entered:
+		t.Fatal("Enter returned with a write still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-finished; err != nil {
+		t.Errorf("running write failed: %v", err)
+	}
+	select {
+	case running := <-entered:
+		if running != 0 {
+			t.Errorf("Enter left %d running, want 0", running)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Enter did not return once the write finished")
+	}
+}
+
+func TestEnterStopsWaitingAfterDrainTimeout(t *testing.T) {
+	m, _ := newTestMode(0)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	go callUnary(m, "/clarity.health.HealthRecordsService/CreateRecord", func(ctx context.Context, req interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	if _, running := m.Enter(context.Background(), "", 0); running != 1 {
+		t.Errorf("Enter after its drain timeout left %d running, want 1", running)
+	}
+}
+
+// registeredServices returns the services main registers
+func registeredServices() map[string]grpc.ServiceInfo {
+	server := grpc.NewServer()
+	healthgrpc.RegisterHealthServer(server, health.NewServer())
+	authpb.RegisterAuthServiceServer(server, authpb.UnimplementedAuthServiceServer{})
+	healthpb.RegisterHealthRecordsServiceServer(server, healthpb.UnimplementedHealthRecordsServiceServer{})
+	aipb.RegisterAIServiceServer(server, aipb.UnimplementedAIServiceServer{})
+	orgpb.RegisterOrganizationServiceServer(server, orgpb.UnimplementedOrganizationServiceServer{})
+	adminpb.RegisterAdminServiceServer(server, adminpb.UnimplementedAdminServiceServer{})
+	return server.GetServiceInfo()
+}
+
+func TestRegisterClassifiesEveryMethodFromPermissions(t *testing.T) {
+	m, _ := newTestMode(1)
+	services := registeredServices()
+
+	want := 0
+	for service, info := range services {
+		for _, method := range info.Methods {
+			full := "/" + service + "/" + method.Name
+			entry, listed := permissions.Lookup(full)
+			if !listed {
+				t.Fatalf("%s has no permissions entry", full)
+			}
+			refused := entry.Mutates && entry.Access != permissions.AccessAdminKey && !m.allowed[full]
+			if refused {
+				want++
+			}
+			if got := m.refuses(full); got != refused {
+				t.Errorf("refuses(%s) = %v, want %v", full, got, refused)
+			}
+		}
+	}
+
+	writes, err := m.Register(services)
+	if err != nil || writes != want {
+		t.Errorf("Register = %d, %v; want %d writes", writes, err, want)
+	}
+
+	delete(services, "clarity.auth.AuthService")
+	if _, err := m.Register(services); err == nil || !strings.Contains(err.Error(), "RefreshToken") {
+		t.Errorf("Register without the auth service = %v, want a missing exemption", err)
+	}
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clarity/backend/logging"
+	"github.com/clarity/backend/permissions"
+	"github.com/clarity/backend/services"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationHeader carries the caller's access token as "Bearer <token>"
+const authorizationHeader = "authorization"
+
+// InsufficientScopeReason is the ErrorInfo reason on calls refused because
+// the caller's token lacks a scope the method needs. The missing scopes
+// are in the "required_scopes" metadata, space separated.
+const InsufficientScopeReason = "INSUFFICIENT_SCOPE"
+
+const errorDomain = "clarity"
+
+// activityInterval is how often a session's use is recorded. The session
+// idle timeout is only as precise as this.
+const activityInterval = time.Minute
+
+// maxTrackedSessions bounds how many sessions Auth remembers recording;
+// past it, sessions not recorded within activityInterval are forgotten
+const maxTrackedSessions = 10000
+
+// TokenValidator checks an access token and returns its claims.
+// *services.AuthService implements it.
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (*services.Claims, error)
+}
+
+// ActivityRecorder records that a session was used. *services.AuthService
+// implements it; when the TokenValidator does too, each authenticated
+// call counts as activity on its token's session.
+type ActivityRecorder interface {
+	RecordActivity(ctx context.Context, userID, sessionID string) error
+}
+
+// Auth authenticates calls to the protected services. It validates the
+// bearer token in each call's metadata, checks it carries the scopes the
+// permissions table requires of the method, and puts the user it was
+// issued to in the context, where handlers read it with UserID instead of
+// trusting the user_id in the request. Calls also keep the token's
+// session from going idle, recorded at most once every activityInterval.
+type Auth struct {
+	validator TokenValidator
+	activity  ActivityRecorder // nil when the validator does not record activity
+	now       func() time.Time
+
+	mu       sync.Mutex
+	recorded map[string]time.Time // session ID -> when its use was last recorded
+}
+
+func NewAuth(validator TokenValidator) *Auth {
+	activity, _ := validator.(ActivityRecorder)
+	return &Auth{
+		validator: validator,
+		activity:  activity,
+		now:       time.Now,
+		recorded:  make(map[string]time.Time),
+	}
+}
+
+type userIDKey struct{}
+
+// WithUserID returns ctx authenticated as userID
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserID returns the authenticated user of ctx, if any
+func UserID(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDKey{}).(string)
+	return userID, ok && userID != ""
+}
+
+// authenticate returns ctx carrying the caller's user ID. Calls to methods
+// needing a token fail with Unauthenticated without a valid access token,
+// and with PermissionDenied when the token lacks a scope the method needs.
+// A method missing from the permissions table is refused outright.
+func (a *Auth) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	method, ok := permissions.Lookup(fullMethod)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "no permissions defined for %s", fullMethod)
+	}
+	if method.Access != permissions.AccessToken {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "access token required")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || strings.TrimSpace(token) == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+	}
+	claims, err := a.validator.ValidateToken(ctx, strings.TrimSpace(token))
+	if errors.Is(err, services.ErrUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if claims.Type != services.TokenTypeAccess {
+		return nil, status.Error(codes.Unauthenticated, "not an access token")
+	}
+	if missing := permissions.Missing(method.Scopes, claims.GrantedScopes()); len(missing) > 0 {
+		return nil, insufficientScope(fullMethod, missing)
+	}
+	a.recordActivity(ctx, claims)
+
+	// Logs name the authenticated user, not whoever the request claims
+	if info, ok := logging.RequestInfoFromContext(ctx); ok {
+		info.UserID = claims.Subject
+		ctx = logging.WithRequestInfo(ctx, info)
+	}
+	return WithUserID(ctx, claims.Subject), nil
+}
+
+// recordActivity records a call on the session claims were issued in,
+// unless it was recorded within activityInterval. Tokens from before
+// sessions were named in them are not tracked. A failure is only logged,
+// since the call itself was authenticated.
+func (a *Auth) recordActivity(ctx context.Context, claims *services.Claims) {
+	if a.activity == nil || claims.Session == "" {
+		return
+	}
+	now := a.now()
+	a.mu.Lock()
+	if last, ok := a.recorded[claims.Session]; ok && now.Sub(last) < activityInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.recorded[claims.Session] = now
+	if len(a.recorded) > maxTrackedSessions {
+		for id, last := range a.recorded {
+			if now.Sub(last) >= activityInterval {
+				delete(a.recorded, id)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	if err := a.activity.RecordActivity(ctx, claims.Subject, claims.Session); err != nil {
+		log.Printf("Warning: %v", err)
+		// Try again on the session's next call
+		a.mu.Lock()
+		delete(a.recorded, claims.Session)
+		a.mu.Unlock()
+	}
+}
+
+// insufficientScope builds the error for a token missing scopes
+func insufficientScope(fullMethod string, missing []string) error {
+	st := status.New(codes.PermissionDenied, "access token lacks scope "+strings.Join(missing, ", "))
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: InsufficientScopeReason,
+		Domain: errorDomain,
+		Metadata: map[string]string{
+			"method":          fullMethod,
+			"required_scopes": strings.Join(missing, " "),
+		},
+	}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor authenticates unary calls
+func (a *Auth) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates streams once, when they open
+func (a *Auth) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,268 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/permissions"
+	"github.com/clarity/backend/services"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeValidator accepts the tokens it holds claims for
+type fakeValidator map[string]*services.Claims
+
+func (v fakeValidator) ValidateToken(ctx context.Context, token string) (*services.Claims, error) {
+	claims, ok := v[token]
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid token signature", services.ErrUnauthenticated)
+	}
+	return claims, nil
+}
+
+// withBearer returns a context carrying token the way a client sends it
+func withBearer(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer "+token))
+}
+
+// call runs method through the unary interceptor, returning the user the
+// handler saw
+func call(a *Auth, ctx context.Context, method string) (string, error) {
+	var userID string
+	_, err := a.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			userID, _ = UserID(ctx)
+			return nil, nil
+		})
+	return userID, err
+}
+
+const (
+	getRecord    = "/clarity.health.HealthRecordsService/GetRecord"
+	createRecord = "/clarity.health.HealthRecordsService/CreateRecord"
+	doctorChat   = "/clarity.ai.AIService/DoctorChat"
+)
+
+func newTestAuth() *Auth {
+	return NewAuth(fakeValidator{
+		"full":      {Subject: "user-1", Type: services.TokenTypeAccess, Scopes: permissions.LoginScopes},
+		"read-only": {Subject: "user-1", Type: services.TokenTypeAccess, Scopes: []string{permissions.ScopeRecordsRead}},
+		"no-scopes": {Subject: "user-1", Type: services.TokenTypeAccess, Scopes: []string{}},
+		"legacy":    {Subject: "user-1", Type: services.TokenTypeAccess},
+		"refresh":   {Subject: "user-1", Type: services.TokenTypeRefresh, Scopes: permissions.LoginScopes},
+	})
+}
+
+func TestAuthEnforcesScopes(t *testing.T) {
+	a := newTestAuth()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		method  string
+		want    codes.Code
+		missing string
+	}{
+		{"full token reads", withBearer("full"), getRecord, codes.OK, ""},
+		{"full token writes", withBearer("full"), createRecord, codes.OK, ""},
+		{"read-only token reads", withBearer("read-only"), getRecord, codes.OK, ""},
+		{"read-only token writes", withBearer("read-only"), createRecord, codes.PermissionDenied, "records:write"},
+		{"read-only token chats", withBearer("read-only"), doctorChat, codes.PermissionDenied, "ai:chat"},
+		{"token without scopes", withBearer("no-scopes"), doctorChat, codes.PermissionDenied, "ai:chat records:read"},
+		{"token without scopes, no scope needed", withBearer("no-scopes"), "/clarity.ai.AIService/GetServiceCapabilities", codes.OK, ""},
+		{"token from before scopes", withBearer("legacy"), createRecord, codes.OK, ""},
+		{"refresh token", withBearer("refresh"), getRecord, codes.Unauthenticated, ""},
+		{"forged token", withBearer("forged"), getRecord, codes.Unauthenticated, ""},
+		{"no token", context.Background(), getRecord, codes.Unauthenticated, ""},
+		{"sign in needs no token", context.Background(), "/clarity.auth.AuthService/VerifyOTP", codes.OK, ""},
+		{"health checks need no token", context.Background(), "/grpc.health.v1.Health/Check", codes.OK, ""},
+		{"admin methods are left to the admin key", context.Background(), "/clarity.admin.AdminService/ListAuditLogs", codes.OK, ""},
+		{"unlisted method", withBearer("full"), "/clarity.health.HealthRecordsService/ShareEverything", codes.PermissionDenied, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := call(a, tt.ctx, tt.method)
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("code = %v, want %v (%v)", got, tt.want, err)
+			}
+			if tt.want == codes.OK && tt.ctx != context.Background() && userID != "user-1" {
+				t.Errorf("handler saw user %q, want user-1", userID)
+			}
+			if tt.missing == "" {
+				return
+			}
+			var info *errdetails.ErrorInfo
+			for _, detail := range status.Convert(err).Details() {
+				if d, ok := detail.(*errdetails.ErrorInfo); ok {
+					info = d
+				}
+			}
+			if info == nil || info.Reason != InsufficientScopeReason || info.Metadata["required_scopes"] != tt.missing || info.Metadata["method"] != tt.method {
+				t.Errorf("error details = %+v, want %s needing %q", info, InsufficientScopeReason, tt.missing)
+			}
+		})
+	}
+}
+
+// fakeServerStream is a server stream with a fixed context
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func TestAuthEnforcesScopesOnStreams(t *testing.T) {
+	a := newTestAuth()
+	info := &grpc.StreamServerInfo{FullMethod: doctorChat, IsClientStream: true, IsServerStream: true}
+	open := func(token string) (string, error) {
+		var userID string
+		err := a.StreamServerInterceptor()(nil, &fakeServerStream{ctx: withBearer(token)}, info, func(srv interface{}, ss grpc.ServerStream) error {
+			userID, _ = UserID(ss.Context())
+			return nil
+		})
+		return userID, err
+	}
+
+	if _, err := open("read-only"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("chat with a read-only token: code = %v, want PermissionDenied", status.Code(err))
+	}
+	if userID, err := open("full"); err != nil || userID != "user-1" {
+		t.Errorf("chat with a full token = %q, %v", userID, err)
+	}
+	if _, err := open("refresh"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("chat with a refresh token: code = %v, want Unauthenticated", status.Code(err))
+	}
+	if _, err := open("forged"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("chat with a forged token: code = %v, want Unauthenticated", status.Code(err))
+	}
+}
+
+// brokenValidator fails the way ValidateToken does when the revocation
+// check cannot reach the database
+type brokenValidator struct{}
+
+func (brokenValidator) ValidateToken(ctx context.Context, token string) (*services.Claims, error) {
+	return nil, errors.New("failed to check token revocation: database is locked")
+}
+
+func TestAuthReadsBearerTokens(t *testing.T) {
+	a := newTestAuth()
+	header := func(value string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, value))
+	}
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		want   codes.Code
+	}{
+		{"bearer token", header("Bearer full"), getRecord, codes.OK},
+		{"surrounding space", header("Bearer  full "), getRecord, codes.OK},
+		{"basic credentials", header("Basic dXNlcjpwYXNz"), getRecord, codes.Unauthenticated},
+		{"bare token", header("full"), getRecord, codes.Unauthenticated},
+		{"empty bearer", header("Bearer "), getRecord, codes.Unauthenticated},
+		{"send a code without a token", context.Background(), "/clarity.auth.AuthService/SendOTP", codes.OK},
+		{"refresh without a token", context.Background(), "/clarity.auth.AuthService/RefreshToken", codes.OK},
+		{"forged token on an open method", withBearer("forged"), "/clarity.auth.AuthService/SendOTP", codes.OK},
+		{"profile needs a token", context.Background(), "/clarity.auth.AuthService/GetProfile", codes.Unauthenticated},
+		{"profile with a token", withBearer("full"), "/clarity.auth.AuthService/GetProfile", codes.OK},
+		{"profile update needs write scope", withBearer("read-only"), "/clarity.auth.AuthService/UpdateProfile", codes.PermissionDenied},
+		{"profile update with a refresh token", withBearer("refresh"), "/clarity.auth.AuthService/UpdateProfile", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := call(a, tt.ctx, tt.method); status.Code(err) != tt.want {
+				t.Errorf("code = %v, want %v (%v)", status.Code(err), tt.want, err)
+			}
+		})
+	}
+
+	// A validator that cannot tell is an outage, not a bad token
+	if _, err := call(NewAuth(brokenValidator{}), withBearer("full"), getRecord); status.Code(err) != codes.Internal {
+		t.Errorf("validator failure: code = %v, want Internal", status.Code(err))
+	}
+}
+
+// recordingValidator is a fakeValidator that also records session activity
+type recordingValidator struct {
+	fakeValidator
+	recorded []string // "user/session" of each recording
+	err      error
+}
+
+func (v *recordingValidator) RecordActivity(ctx context.Context, userID, sessionID string) error {
+	v.recorded = append(v.recorded, userID+"/"+sessionID)
+	return v.err
+}
+
+func TestAuthRecordsSessionActivity(t *testing.T) {
+	validator := &recordingValidator{fakeValidator: fakeValidator{
+		"session-1": {Subject: "user-1", Type: services.TokenTypeAccess, Session: "s1"},
+		"session-2": {Subject: "user-2", Type: services.TokenTypeAccess, Session: "s2"},
+		"read-only": {Subject: "user-1", Type: services.TokenTypeAccess, Session: "s1", Scopes: []string{permissions.ScopeRecordsRead}},
+		"legacy":    {Subject: "user-1", Type: services.TokenTypeAccess},
+	}}
+	a := NewAuth(validator)
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	expect := func(want ...string) {
+		t.Helper()
+		if fmt.Sprint(validator.recorded) != fmt.Sprint(want) {
+			t.Errorf("recorded = %v, want %v", validator.recorded, want)
+		}
+		validator.recorded = nil
+	}
+
+	// The first call of a session is recorded, later ones within a minute
+	// are not
+	for i := 0; i < 3; i++ {
+		if _, err := call(a, withBearer("session-1"), getRecord); err != nil {
+			t.Fatalf("call: %v", err)
+		}
+		now = now.Add(20 * time.Second)
+	}
+	expect("user-1/s1")
+
+	// Sessions are throttled apart
+	if _, err := call(a, withBearer("session-2"), getRecord); err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	expect("user-2/s2")
+
+	// A minute after the last recording, the next call is recorded again
+	call(a, withBearer("session-1"), getRecord)
+	expect("user-1/s1")
+
+	// Refused calls and tokens naming no session are not activity
+	now = now.Add(time.Hour)
+	if _, err := call(a, withBearer("read-only"), createRecord); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("call without the scope: %v", err)
+	}
+	call(a, withBearer("legacy"), getRecord)
+	call(a, context.Background(), getRecord)
+	expect()
+
+	// A failed recording does not fail the call, and is retried on the next
+	validator.err = errors.New("database is down")
+	if _, err := call(a, withBearer("session-1"), getRecord); err != nil {
+		t.Errorf("call whose activity could not be recorded: %v", err)
+	}
+	validator.err = nil
+	call(a, withBearer("session-1"), getRecord)
+	call(a, withBearer("session-1"), getRecord)
+	expect("user-1/s1", "user-1/s1")
+}
+
+func TestAuthWithoutActivityRecorder(t *testing.T) {
+	a := NewAuth(fakeValidator{"session-1": {Subject: "user-1", Type: services.TokenTypeAccess, Session: "s1"}})
+	if userID, err := call(a, withBearer("session-1"), getRecord); err != nil || userID != "user-1" {
+		t.Errorf("call = %q, %v", userID, err)
+	}
+}
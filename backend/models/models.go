@@ -1,55 +1,526 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // User represents a user in the system
 type User struct {
-	ID            string    `gorm:"primaryKey"`
-	Email         string    `gorm:"uniqueIndex"`
-	Name          string
-	DateOfBirth   string
-	Gender        string
-	BloodType     string
-	PasswordHash  string
+	ID           string `gorm:"primaryKey"`
+	Email        string `gorm:"uniqueIndex;size:254"`
+	Name         string
+	DateOfBirth  string
+	Gender       string
+	BloodType    string
+	PasswordHash string
+	OrgID        string `gorm:"index"` // set when the user joined through an org invite
+	Phone        string // E.164, used for WhatsApp delivery
+	OTPChannel   string // preferred sign-in code channel; empty means email
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// OTPStore stores OTP data temporarily. Only a keyed hash of the code is
+// kept, so reading the table does not reveal codes that would sign in.
+type OTPStore struct {
+	ID        string `gorm:"primaryKey"`
+	Email     string `gorm:"index"`
+	OTPHash   string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// OTPIssuance counts OTPs issued to an email on one UTC day. Unlike
+// OTPStore rows it survives verification, so it can enforce a daily cap.
+type OTPIssuance struct {
+	Email string `gorm:"primaryKey"`
+	Day   string `gorm:"primaryKey"` // YYYY-MM-DD, UTC
+	Count int
+}
+
+// OTPAttempts counts wrong sign-in codes entered for an email. When they
+// reach the limit the email's outstanding codes are discarded and it can
+// neither sign in with a code nor request one until LockedUntil.
+type OTPAttempts struct {
+	Email        string `gorm:"primaryKey"`
+	Failed       int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// TOTPCredential is a user's authenticator app secret. A new secret stays
+// pending until the user proves their app has it by confirming a code, and
+// only then replaces Secret and turns TOTP sign-in on.
+type TOTPCredential struct {
+	UserID        string `gorm:"primaryKey"`
+	Secret        string // base32; empty until the first enrollment is confirmed
+	PendingSecret string
+	LastStep      int64 // time step of the last accepted code, to refuse replays
+	EnabledAt     *time.Time
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
 
-// OTPStore stores OTP data temporarily
-type OTPStore struct {
-	ID        string    `gorm:"primaryKey"`
-	Email     string    `gorm:"index"`
-	OTP       string
+// Session is one login on one device. Every refresh token minted from that
+// login belongs to the session, so the session is the token family that is
+// revoked as a whole when theft is suspected.
+type Session struct {
+	ID           string `gorm:"primaryKey"`
+	UserID       string `gorm:"index"`
+	DeviceID     string // client-supplied installation ID, bound at login
+	CreatedAt    time.Time
+	LastUsedAt   time.Time
+	RevokedAt    *time.Time
+	RevokeReason string
+}
+
+// RefreshToken is one link in a session's rotation chain. Only the token's
+// SHA-256 hash is stored. RotatedAt is set once the token has been
+// exchanged; presenting it again means it was copied.
+type RefreshToken struct {
+	ID        string `gorm:"primaryKey"`
+	SessionID string `gorm:"index"`
+	UserID    string `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex;size:191"`
 	ExpiresAt time.Time
+	RotatedAt *time.Time
 	CreatedAt time.Time
 }
 
+// RevokedToken is a token signed out before it expired, by its jti claim.
+// Rows are kept until the token would have expired anyway.
+type RevokedToken struct {
+	TokenID   string    `gorm:"primaryKey"`
+	UserID    string    `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+	RevokedAt time.Time
+}
+
 // HealthRecord stores health information
 type HealthRecord struct {
-	ID          string    `gorm:"primaryKey"`
-	UserID      string    `gorm:"index"`
-	RecordType  string    // prescription, appointment, lab_result, symptom
+	ID          string `gorm:"primaryKey"`
+	UserID      string `gorm:"index;index:idx_record_user_sync,priority:1;index:idx_record_user_content,priority:1"`
+	RecordType  string // prescription, appointment, lab_result, symptom, or a custom type
 	Title       string
 	Description string
 	Metadata    string `gorm:"type:json"` // JSON string for flexibility
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	Sensitivity string // standard (or empty), sensitive, or blocked
+
+	// ScanID links a record created from a prescription scan to the stored
+	// scan, and ExtractionVersion and ExtractionProvider are the pipeline
+	// version and AI provider that produced its metadata. UserEditedAt is
+	// set once the owner edits the record, after which it is never
+	// re-extracted.
+	ScanID             string `gorm:"index"`
+	ExtractionVersion  int
+	ExtractionProvider string
+	UserEditedAt       *time.Time
+
+	// ContentHash identifies the type, title and description the record
+	// was created with, so a create sent twice can be recognized
+	ContentHash string `gorm:"size:64;index:idx_record_user_content,priority:2"`
+
+	// SyncSeq is the owner's change sequence at the record's last change,
+	// so clients can pull only what changed since they last synced
+	SyncSeq int64 `gorm:"index:idx_record_user_sync,priority:2"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// DeletedAt is set while the record is in the trash, where queries do
+	// not see it unless Unscoped
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// SyncState holds a user's change sequence. Seq increases by one on every
+// change to one of their records. PurgedSeq is the highest sequence whose
+// tombstone has been purged; sync tokens older than it cannot be served.
+type SyncState struct {
+	UserID    string `gorm:"primaryKey"`
+	Seq       int64
+	PurgedSeq int64
+}
+
+// RecordTombstone marks a deleted record for clients to remove on their
+// next sync. Tombstones are kept for a bounded window.
+type RecordTombstone struct {
+	RecordID  string    `gorm:"primaryKey"`
+	UserID    string    `gorm:"index:idx_tombstone_user_sync,priority:1"`
+	SyncSeq   int64     `gorm:"index:idx_tombstone_user_sync,priority:2"`
+	DeletedAt time.Time `gorm:"index"`
+}
+
+// ScanInput keeps the image a prescription scan read, so the scan can be
+// re-extracted when the pipeline improves
+type ScanInput struct {
+	ID                string `gorm:"primaryKey"`
+	UserID            string `gorm:"index"`
+	Image             []byte
+	ExtractionVersion int
+	Provider          string // AI provider that read the image
+	CreatedAt         time.Time
+}
+
+// Correction is an owner's edit to a field of a record extracted from a
+// scan: the value the extraction produced and the one the owner replaced
+// it with, kept to measure extraction accuracy
+type Correction struct {
+	ID                string `gorm:"primaryKey"`
+	UserID            string `gorm:"index"`
+	RecordID          string `gorm:"index"`
+	ScanID            string
+	Field             string `gorm:"index:idx_correction_field_provider,priority:1"`
+	OriginalValue     string
+	CorrectedValue    string
+	Provider          string `gorm:"index:idx_correction_field_provider,priority:2"`
+	ExtractionVersion int
+	CreatedAt         time.Time `gorm:"index"`
+}
+
+// RecordRevision is an earlier version of a record, kept when a
+// re-extraction replaces its metadata
+type RecordRevision struct {
+	ID                string `gorm:"primaryKey"`
+	RecordID          string `gorm:"index"`
+	Title             string
+	Description       string
+	Metadata          string `gorm:"type:json"`
+	ExtractionVersion int
+	ReplacedBy        string // reprocess job ID
+	CreatedAt         time.Time
+}
+
+// Record sensitivity. Sensitive records can be read by non-owners only
+// with a stated reason; blocked records only by their owner.
+const (
+	SensitivityStandard  = "standard"
+	SensitivitySensitive = "sensitive"
+	SensitivityBlocked   = "blocked"
+)
+
+// RecordAccessLog records a non-owner read of a sensitive record and the
+// reason given for it, so the owner can see who looked and why
+type RecordAccessLog struct {
+	ID        string `gorm:"primaryKey"`
+	OwnerID   string `gorm:"index"`
+	RecordID  string `gorm:"index"`
+	ActorID   string
+	OrgID     string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// Change event entity types and kinds
+const (
+	ChangeEntityRecord     = "record"
+	ChangeEntityMedication = "medication"
+
+	ChangeKindCreated  = "created"
+	ChangeKindUpdated  = "updated"
+	ChangeKindDeleted  = "deleted"
+	ChangeKindStarted  = "started"
+	ChangeKindStopped  = "stopped"
+	ChangeKindRestored = "restored"
+)
+
+// ChangeEvent is one entry in a user's "what's new in my data" feed. It is
+// written in the same transaction as the change it describes, so the feed
+// never misses or invents a change. RecordID and Sensitivity copy the
+// health record the change concerns, so caregiver filtering still works
+// after the record is deleted.
+type ChangeEvent struct {
+	ID          string `gorm:"primaryKey;index:idx_change_user_created,priority:3"`
+	UserID      string `gorm:"index:idx_change_user_created,priority:1"` // whose data changed
+	ActorID     string
+	EntityType  string // record, medication
+	EntityID    string
+	RecordID    string `gorm:"index"`
+	Kind        string // created, updated, deleted, started, stopped
+	Summary     string // e.g. "title changed, dosage updated"
+	Sensitivity string
+	CreatedAt   time.Time `gorm:"index:idx_change_user_created,priority:2"`
+}
+
+// RecordSearchIndex is the derived keyword index used by record search.
+// Terms holds the record's normalized tokens, space-delimited with leading
+// and trailing spaces so token matches can use LIKE '% term%'.
+type RecordSearchIndex struct {
+	RecordID        string `gorm:"primaryKey"`
+	UserID          string `gorm:"index"`
+	Terms           string
+	RecordUpdatedAt time.Time
+	IndexedAt       time.Time
+}
+
+// RecordMetadataNumber is the derived index of a record's numeric metadata
+// values, used for range filters. Values that do not parse as numbers are
+// not indexed.
+type RecordMetadataNumber struct {
+	RecordID string  `gorm:"primaryKey"`
+	Key      string  `gorm:"primaryKey;column:metadata_key;index:idx_metadata_number,priority:2"`
+	UserID   string  `gorm:"index:idx_metadata_number,priority:1"`
+	Value    float64 `gorm:"index:idx_metadata_number,priority:3"`
+}
+
+// RecordLink is a typed relationship between two of a user's records, e.g.
+// a lab result that came out of an appointment
+type RecordLink struct {
+	ID           string `gorm:"primaryKey"`
+	UserID       string `gorm:"index"`
+	SourceID     string `gorm:"uniqueIndex:idx_record_link;index"`
+	TargetID     string `gorm:"uniqueIndex:idx_record_link;index"`
+	RelationType string `gorm:"uniqueIndex:idx_record_link;size:191"` // related, result_of, prescribed_for, follow_up_of
+	CreatedAt    time.Time
+	// DeletedAt is set while either record is in the trash
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
 // DoctorConversation stores chat history
 type DoctorConversation struct {
-	ID             string    `gorm:"primaryKey"`
-	UserID         string    `gorm:"index"`
-	ConversationID string    `gorm:"index"`
+	ID             string `gorm:"primaryKey"`
+	UserID         string `gorm:"index"`
+	ConversationID string `gorm:"index"`
 	Message        string
 	Response       string
 	IsAI           bool
 	CreatedAt      time.Time
 }
 
+// ConversationSummary condenses a doctor chat conversation, up to and
+// including its turn at SummarizedThrough, so later prompts can carry the
+// summary instead of the turns
+type ConversationSummary struct {
+	ConversationID    string `gorm:"primaryKey"`
+	UserID            string `gorm:"primaryKey"`
+	Summary           string
+	Turns             int // turns the summary covers
+	SummarizedThrough time.Time
+	Provider          string
+	UpdatedAt         time.Time
+}
+
+// Reminder kinds
+const (
+	ReminderKindCourseEnd = "course_end"
+	ReminderKindManual    = "manual"
+	ReminderKindDose      = "medication_dose"
+)
+
+// Reminder schedules a follow-up notification about a health record
+type Reminder struct {
+	ID          string `gorm:"primaryKey"`
+	UserID      string `gorm:"index"`
+	RecordID    string `gorm:"index"`
+	Kind        string // course_end, manual, medication_dose
+	Message     string
+	DueAt       time.Time `gorm:"index"`
+	SentAt      *time.Time
+	CancelledAt *time.Time
+	CreatedAt   time.Time
+}
+
+// Medication is a medicine the user takes on a schedule, set up from a
+// scanned prescription. TimesOfDay holds comma-separated "HH:MM" wall-clock
+// times in Timezone. Dose reminders are created ahead of time up to
+// ScheduledUntil and topped up by a background job.
+type Medication struct {
+	ID             string `gorm:"primaryKey"`
+	UserID         string `gorm:"index"`
+	RecordID       string `gorm:"index"` // prescription record created with it
+	Name           string
+	Dosage         string
+	Frequency      string // as written on the label
+	TimesOfDay     string
+	EveryDays      int
+	AsNeeded       bool // never scheduled
+	Timezone       string
+	StartsAt       time.Time
+	EndsAt         *time.Time // nil while ongoing
+	ScheduledUntil time.Time  `gorm:"index"`
+	CreatedAt      time.Time
+	// DeletedAt is set while the prescription record is in the trash
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// Delivery channels
+const (
+	DeliveryChannelEmail    = "email"
+	DeliveryChannelPush     = "push"
+	DeliveryChannelWhatsApp = "whatsapp"
+)
+
+// Delivery states
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSent    = "sent"
+	DeliveryStatusFailed  = "failed" // gave up after MaxAttempts
+)
+
+// Delivery is an outbound email or push message waiting in the delivery
+// queue. Its ID doubles as the idempotency reference given to recipients
+// and support staff. A Sensitive message, such as one carrying a sign-in
+// code, has its Body cleared once it is sent or given up on.
+type Delivery struct {
+	ID                string `gorm:"primaryKey"`
+	Channel           string // email, push, whatsapp
+	Recipient         string `gorm:"index"` // email address, user ID, or phone number
+	Subject           string
+	Body              string
+	Link              string
+	Sensitive         bool
+	FallbackChannel   string // takes over after the first failed attempt on Channel
+	FallbackRecipient string
+	Status            string `gorm:"index"`
+	Attempts          int
+	MaxAttempts       int
+	NextAttemptAt     time.Time `gorm:"index"`
+	LastError         string
+	SentAt            *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// ExportLink is a read-only snapshot of selected records shared through a
+// link. Only hashes of the link token and PIN are stored, and the snapshot
+// is encrypted with a key derived from the token, so the database alone
+// cannot open it.
+type ExportLink struct {
+	ID                string `gorm:"primaryKey"`
+	UserID            string `gorm:"index"`
+	TokenHash         string `gorm:"uniqueIndex;size:191"`
+	PINHash           string // empty when no PIN is required
+	Snapshot          []byte // AES-GCM nonce followed by ciphertext; cleared on revoke
+	RecordCount       int
+	Redacted          bool      // snapshot had personal details stripped
+	ExpiresAt         time.Time `gorm:"index"`
+	RevokedAt         *time.Time
+	AccessCount       int
+	LastAccessedAt    *time.Time
+	FailedPINAttempts int
+	LockedUntil       *time.Time
+	CreatedAt         time.Time
+}
+
+// ExportLinkRecord is one record shared through an export link. The
+// snapshot itself is encrypted, so these rows are what count a record's
+// active shares.
+type ExportLinkRecord struct {
+	LinkID   string `gorm:"primaryKey"`
+	RecordID string `gorm:"primaryKey;index"`
+}
+
+// Organization is a clinic or practice whose staff can view consenting patients' data
+type Organization struct {
+	ID        string `gorm:"primaryKey"`
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Organization membership roles
+const (
+	OrgRoleStaff = "staff"
+	OrgRoleAdmin = "admin"
+)
+
+// OrgMembership links a staff account to an organization
+type OrgMembership struct {
+	ID        string `gorm:"primaryKey"`
+	OrgID     string `gorm:"uniqueIndex:idx_org_member;size:191"`
+	UserID    string `gorm:"uniqueIndex:idx_org_member;index"`
+	Role      string // staff, admin
+	CreatedAt time.Time
+}
+
+// OrgInvite is a pending invitation for an email address to join an
+// organization. Only a hash of the token in the invite link is stored.
+type OrgInvite struct {
+	ID         string `gorm:"primaryKey"`
+	OrgID      string `gorm:"index"`
+	Email      string `gorm:"index"`
+	Role       string // patient, staff, admin
+	TokenHash  string `gorm:"uniqueIndex;size:191"`
+	InvitedBy  string
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// OrgConsent records a patient's grant allowing an organization's staff to view their data
+type OrgConsent struct {
+	ID        string `gorm:"primaryKey"`
+	OrgID     string `gorm:"uniqueIndex:idx_org_patient;size:191"`
+	PatientID string `gorm:"uniqueIndex:idx_org_patient;index"`
+	GrantedAt time.Time
+	RevokedAt *time.Time
+}
+
+// AuditLog records security-relevant actions for later review. The composite indexes serve ListAuditLogs: newest-first pages, optionally
+// narrowed to one actor or one action.
+type AuditLog struct {
+	ID         string `gorm:"primaryKey;index:idx_audit_created,priority:2"`
+	ActorID    string `gorm:"index:idx_audit_actor_created,priority:1"` // user ID, or "admin" for admin key holders
+	Action     string `gorm:"index:idx_audit_action_created,priority:1"`
+	TargetType string
+	TargetID   string
+	Details    string
+	CreatedAt  time.Time `gorm:"index:idx_audit_created,priority:1;index:idx_audit_actor_created,priority:2;index:idx_audit_action_created,priority:2"`
+}
+
 // Token for JWT tokens
 type Token struct {
 	AccessToken  string
 	RefreshToken string
 	ExpiresAt    time.Time
 }
+
+// Data quality report states
+const (
+	ReportStatusRunning   = "running"
+	ReportStatusCompleted = "completed"
+	ReportStatusFailed    = "failed"
+)
+
+// ReprocessJob re-runs scan extraction over a cohort of records. The
+// cursor is the (created_at, id) of the last record handled, so a job
+// resumes where it stopped after a restart.
+type ReprocessJob struct {
+	ID           string `gorm:"primaryKey"`
+	Status       string `gorm:"index"` // running, completed
+	RecordType   string // empty matches every type
+	CreatedFrom  *time.Time
+	CreatedTo    *time.Time
+	BelowVersion int
+
+	CursorCreatedAt time.Time
+	CursorID        string
+
+	Improved  int
+	Unchanged int
+	Skipped   int // edited by the user, or the scan is no longer stored
+	Failed    int
+
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+// DataQualityReport stores one run of the data-quality checks. Results is
+// the JSON-encoded list of per-check results.
+type DataQualityReport struct {
+	ID          string `gorm:"primaryKey"`
+	Status      string // running, completed, failed
+	Fix         bool   // safe remediations were applied
+	Results     string `gorm:"type:json"`
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+// SchemaMigration records a schema migration applied to the database
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
@@ -0,0 +1,183 @@
+package permissions
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// Scopes an access token can carry. A normal sign-in gets every one of
+// them; tokens for narrower contexts carry only the scopes they need.
+const (
+	ScopeRecordsRead  = "records:read"
+	ScopeRecordsWrite = "records:write"
+	ScopeAIChat       = "ai:chat"
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+// LoginScopes are the scopes of a token issued by signing in
+var LoginScopes = []string{ScopeRecordsRead, ScopeRecordsWrite, ScopeAIChat, ScopeProfileRead, ScopeProfileWrite}
+
+// Access says how a method's callers are authenticated
+type Access int
+
+const (
+	// AccessToken methods need an access token carrying the method's scopes
+	AccessToken Access = iota
+	// AccessOpen methods need no token: signing in happens before the
+	// client has one, and health checks come from the load balancer
+	AccessOpen
+	// AccessAdminKey methods are authorized by the admin API key, which
+	// the admin service checks itself
+	AccessAdminKey
+)
+
+// Method is what a method needs from its caller and what it does
+type Method struct {
+	Access  Access
+	Scopes  []string // all needed; none means any access token will do
+	Mutates bool     // may change stored data; refused in maintenance mode
+}
+
+func token(mutates bool, scopes ...string) Method {
+	return Method{Access: AccessToken, Scopes: scopes, Mutates: mutates}
+}
+
+func open(mutates bool) Method { return Method{Access: AccessOpen, Mutates: mutates} }
+
+func admin(mutates bool) Method { return Method{Access: AccessAdminKey, Mutates: mutates} }
+
+// methods lists every RPC the server registers. Check fails startup when
+// one is missing, so a new RPC cannot ship without deciding who may call
+// it and whether it writes.
+var methods = map[string]Method{
+	"/clarity.auth.AuthService/SendOTP":       open(true),
+	"/clarity.auth.AuthService/VerifyOTP":     open(true),
+	"/clarity.auth.AuthService/RefreshToken":  open(true),
+	"/clarity.auth.AuthService/Logout":        open(true),
+	"/clarity.auth.AuthService/SetOTPChannel": token(true, ScopeProfileWrite),
+	"/clarity.auth.AuthService/EnrollTOTP":    token(true, ScopeProfileWrite),
+	"/clarity.auth.AuthService/ConfirmTOTP":   token(true, ScopeProfileWrite),
+	"/clarity.auth.AuthService/GetProfile":    token(false, ScopeProfileRead),
+	"/clarity.auth.AuthService/UpdateProfile": token(true, ScopeProfileWrite),
+
+	"/clarity.health.HealthRecordsService/CreateRecord":           token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/GetRecord":              token(false, ScopeRecordsRead),
+	"/clarity.health.HealthRecordsService/ListRecords":            token(false, ScopeRecordsRead),
+	"/clarity.health.HealthRecordsService/UpdateRecord":           token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/DeleteRecord":           token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/RestoreRecord":          token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/SetRecordReminder":      token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/SearchRecords":          token(false, ScopeRecordsRead),
+	"/clarity.health.HealthRecordsService/LinkRecords":            token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/ParseAppointment":       token(false, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/CreateExportLink":       token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/RevokeExportLink":       token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/ConfirmMedicationSetup": token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/SetRecordSensitivity":   token(true, ScopeRecordsWrite),
+	"/clarity.health.HealthRecordsService/ListRecordAccessLog":    token(false, ScopeRecordsRead),
+	"/clarity.health.HealthRecordsService/ListChanges":            token(false, ScopeRecordsRead),
+	// Writes sequence numbers for records that predate sync
+	"/clarity.health.HealthRecordsService/SyncRecords": token(true, ScopeRecordsRead),
+
+	"/clarity.ai.AIService/ScanPrescription": token(true, ScopeRecordsWrite),
+	"/clarity.ai.AIService/SummarizeHealth":  token(false, ScopeRecordsRead),
+	// Chat tools read the user's records
+	"/clarity.ai.AIService/DoctorChat":             token(true, ScopeAIChat, ScopeRecordsRead),
+	"/clarity.ai.AIService/VoiceChat":              token(true, ScopeAIChat, ScopeRecordsRead),
+	"/clarity.ai.AIService/SummarizeConversation":  token(true, ScopeAIChat),
+	"/clarity.ai.AIService/SearchConversations":    token(false, ScopeAIChat),
+	"/clarity.ai.AIService/GetServiceCapabilities": token(false),
+
+	"/clarity.organization.OrganizationService/CreateOrganization":        token(true, ScopeProfileWrite),
+	"/clarity.organization.OrganizationService/InviteMember":              token(true, ScopeProfileWrite),
+	"/clarity.organization.OrganizationService/AcceptInvite":              token(true, ScopeProfileWrite),
+	"/clarity.organization.OrganizationService/GrantConsent":              token(true, ScopeRecordsWrite),
+	"/clarity.organization.OrganizationService/RevokeConsent":             token(true, ScopeRecordsWrite),
+	"/clarity.organization.OrganizationService/ListConsentingPatients":    token(false, ScopeRecordsRead),
+	"/clarity.organization.OrganizationService/ListPatientRecords":        token(false, ScopeRecordsRead),
+	"/clarity.organization.OrganizationService/GetPatientRecord":          token(false, ScopeRecordsRead),
+	"/clarity.organization.OrganizationService/ListPatientChanges":        token(false, ScopeRecordsRead),
+	"/clarity.organization.OrganizationService/GetOrganizationStats":      token(false, ScopeRecordsRead),
+	"/clarity.organization.OrganizationService/ExportOrganizationRecords": token(false, ScopeRecordsRead),
+
+	"/clarity.admin.AdminService/ReindexSearch":             admin(true),
+	"/clarity.admin.AdminService/SetLogLevel":               admin(true),
+	"/clarity.admin.AdminService/EnableDebugLogging":        admin(true),
+	"/clarity.admin.AdminService/DisableDebugLogging":       admin(true),
+	"/clarity.admin.AdminService/ListDebugTargets":          admin(false),
+	"/clarity.admin.AdminService/GetDeliveryStatus":         admin(false),
+	"/clarity.admin.AdminService/GenerateDataQualityReport": admin(true),
+	"/clarity.admin.AdminService/GetDataQualityReport":      admin(false),
+	"/clarity.admin.AdminService/ListAuditLogs":             admin(false),
+	"/clarity.admin.AdminService/ListCorrections":           admin(false),
+	"/clarity.admin.AdminService/StartReprocessJob":         admin(true),
+	"/clarity.admin.AdminService/GetReprocessJob":           admin(false),
+	"/clarity.admin.AdminService/GetConcurrencyStats":       admin(false),
+	"/clarity.admin.AdminService/SetEndpointEnabled":        admin(true),
+	"/clarity.admin.AdminService/ListDisabledEndpoints":     admin(false),
+	"/clarity.admin.AdminService/SetMaintenanceMode":        admin(true),
+	"/clarity.admin.AdminService/GetMaintenanceMode":        admin(false),
+	"/clarity.admin.AdminService/GetDeprecationUsage":       admin(false),
+	"/clarity.admin.AdminService/GetEventStats":             admin(false),
+	"/clarity.admin.AdminService/ListDeletedRecords":        admin(false),
+	"/clarity.admin.AdminService/PurgeDeletedRecords":       admin(true),
+
+	"/grpc.health.v1.Health/Check": open(false),
+	"/grpc.health.v1.Health/Watch": open(false),
+}
+
+// Lookup returns the entry for a full gRPC method name. Callers treat a
+// method that is not listed as needing more than any caller has.
+func Lookup(fullMethod string) (Method, bool) {
+	method, ok := methods[fullMethod]
+	return method, ok
+}
+
+// Missing returns the scopes in need that granted lacks, in need's order
+func Missing(need, granted []string) []string {
+	var missing []string
+	for _, scope := range need {
+		if !slices.Contains(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// Check fails when a method of the services on a gRPC server has no entry,
+// or an entry names a method that is not registered. Call it after every
+// service is registered.
+func Check(services map[string]grpc.ServiceInfo) error {
+	registered := make(map[string]bool)
+	var unlisted []string
+	for service, info := range services {
+		for _, method := range info.Methods {
+			full := "/" + service + "/" + method.Name
+			registered[full] = true
+			if _, ok := methods[full]; !ok {
+				unlisted = append(unlisted, full)
+			}
+		}
+	}
+	if len(unlisted) > 0 {
+		sort.Strings(unlisted)
+		return fmt.Errorf("no permissions defined for %s", strings.Join(unlisted, ", "))
+	}
+
+	var stale []string
+	for full := range methods {
+		if !registered[full] {
+			stale = append(stale, full)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return fmt.Errorf("permissions defined for unregistered %s", strings.Join(stale, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package permissions
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	adminpb "github.com/clarity/backend/gen/go/admin"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	authpb "github.com/clarity/backend/gen/go/auth"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	orgpb "github.com/clarity/backend/gen/go/organization"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// registeredServices returns the services main registers
+func registeredServices() map[string]grpc.ServiceInfo {
+	server := grpc.NewServer()
+	healthgrpc.RegisterHealthServer(server, health.NewServer())
+	authpb.RegisterAuthServiceServer(server, authpb.UnimplementedAuthServiceServer{})
+	healthpb.RegisterHealthRecordsServiceServer(server, healthpb.UnimplementedHealthRecordsServiceServer{})
+	aipb.RegisterAIServiceServer(server, aipb.UnimplementedAIServiceServer{})
+	orgpb.RegisterOrganizationServiceServer(server, orgpb.UnimplementedOrganizationServiceServer{})
+	adminpb.RegisterAdminServiceServer(server, adminpb.UnimplementedAdminServiceServer{})
+	return server.GetServiceInfo()
+}
+
+func TestEveryRegisteredMethodHasPermissions(t *testing.T) {
+	services := registeredServices()
+	if err := Check(services); err != nil {
+		t.Fatal(err)
+	}
+
+	for service, info := range services {
+		for _, m := range info.Methods {
+			full := "/" + service + "/" + m.Name
+			method, _ := Lookup(full)
+			switch {
+			case strings.HasPrefix(full, "/clarity.admin.AdminService/"):
+				if method.Access != AccessAdminKey {
+					t.Errorf("%s is not behind the admin key", full)
+				}
+			case method.Access == AccessAdminKey:
+				t.Errorf("%s is behind the admin key outside the admin service", full)
+			}
+			for _, scope := range method.Scopes {
+				if !slices.Contains(LoginScopes, scope) {
+					t.Errorf("%s needs %s, which no sign-in grants", full, scope)
+				}
+			}
+		}
+	}
+}
+
+func TestCheckCatchesMissingAndStaleEntries(t *testing.T) {
+	services := registeredServices()
+
+	withNew := registeredServices()
+	info := withNew["clarity.health.HealthRecordsService"]
+	info.Methods = append(info.Methods, grpc.MethodInfo{Name: "ShareEverything"})
+	withNew["clarity.health.HealthRecordsService"] = info
+	if err := Check(withNew); err == nil || !strings.Contains(err.Error(), "no permissions defined for /clarity.health.HealthRecordsService/ShareEverything") {
+		t.Errorf("Check with an unlisted method = %v", err)
+	}
+
+	delete(services, "clarity.admin.AdminService")
+	if err := Check(services); err == nil || !strings.Contains(err.Error(), "unregistered /clarity.admin.AdminService/") {
+		t.Errorf("Check with a removed service = %v", err)
+	}
+
+	if _, ok := Lookup("/clarity.health.HealthRecordsService/ShareEverything"); ok {
+		t.Error("Lookup found an unlisted method")
+	}
+}
+
+func TestMissing(t *testing.T) {
+	tests := []struct {
+		need, granted, want []string
+	}{
+		{nil, nil, nil},
+		{nil, []string{ScopeRecordsRead}, nil},
+		{[]string{ScopeRecordsRead}, LoginScopes, nil},
+		{[]string{ScopeRecordsWrite}, []string{ScopeRecordsRead}, []string{ScopeRecordsWrite}},
+		{[]string{ScopeAIChat, ScopeRecordsRead}, []string{ScopeRecordsRead}, []string{ScopeAIChat}},
+		{[]string{ScopeAIChat, ScopeRecordsRead}, []string{}, []string{ScopeAIChat, ScopeRecordsRead}},
+	}
+	for _, tt := range tests {
+		if got := Missing(tt.need, tt.granted); !slices.Equal(got, tt.want) {
+			t.Errorf("Missing(%v, %v) = %v, want %v", tt.need, tt.granted, got, tt.want)
+		}
+	}
+}
+
+func TestMutates(t *testing.T) {
+	tests := []struct {
+		method  string
+		mutates bool
+	}{
+		{"/clarity.health.HealthRecordsService/GetRecord", false},
+		{"/clarity.health.HealthRecordsService/ListRecords", false},
+		{"/clarity.health.HealthRecordsService/SearchRecords", false},
+		{"/clarity.health.HealthRecordsService/CreateRecord", true},
+		{"/clarity.health.HealthRecordsService/DeleteRecord", true},
+		{"/clarity.health.HealthRecordsService/ParseAppointment", false},
+		{"/clarity.health.HealthRecordsService/SyncRecords", true},
+		{"/clarity.ai.AIService/ScanPrescription", true},
+		{"/clarity.ai.AIService/SummarizeHealth", false},
+		{"/clarity.ai.AIService/DoctorChat", true},
+		{"/clarity.health.HealthRecordsService/CreateExportLink", true},
+		{"/clarity.organization.OrganizationService/ExportOrganizationRecords", false},
+		{"/clarity.auth.AuthService/RefreshToken", true},
+		{"/clarity.admin.AdminService/GetMaintenanceMode", false},
+		{"/clarity.admin.AdminService/SetMaintenanceMode", true},
+		{"/grpc.health.v1.Health/Check", false},
+	}
+	for _, tt := range tests {
+		method, ok := Lookup(tt.method)
+		if !ok {
+			t.Errorf("%s is not listed", tt.method)
+			continue
+		}
+		if method.Mutates != tt.mutates {
+			t.Errorf("%s: Mutates = %v, want %v", tt.method, method.Mutates, tt.mutates)
+		}
+	}
+}
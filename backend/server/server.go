@@ -0,0 +1,252 @@
+// Package server builds the gRPC server with every service registered
+// behind the interceptor chain. main serves it on a TCP listener; the
+// integration tests serve the same server in memory.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/concurrency"
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/database"
+	"github.com/clarity/backend/events"
+	adminpb "github.com/clarity/backend/gen/go/admin"
+	aipb "github.com/clarity/backend/gen/go/ai"
+	authpb "github.com/clarity/backend/gen/go/auth"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	orgpb "github.com/clarity/backend/gen/go/organization"
+	"github.com/clarity/backend/handlers"
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/killswitch"
+	"github.com/clarity/backend/logging"
+	"github.com/clarity/backend/maintenance"
+	"github.com/clarity/backend/middleware"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/permissions"
+	"github.com/clarity/backend/services"
+	"github.com/clarity/backend/versioning"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Options replace parts of the server that tests need to control
+type Options struct {
+	// EmailSender delivers email instead of the sender cfg.Delivery
+	// selects
+	EmailSender services.Sender
+	// Now is the clock sign-in codes, tokens and sessions are checked
+	// against; nil uses time.Now
+	Now func() time.Time
+}
+
+// Server is the gRPC server and the services behind it. Background jobs
+// and the HTTP gateway share the services with the RPC handlers.
+type Server struct {
+	GRPC *grpc.Server
+
+	Auth          *services.AuthService
+	HealthRecords *services.HealthRecordsService
+	Medications   *services.MedicationService
+	AI            *services.AIService
+	Reminders     *services.ReminderService
+	Search        *services.SearchService
+	Exports       *services.ExportService
+	Deliveries    *services.DeliveryQueue
+	Reprocess     *services.ReprocessService
+
+	// MedicationDataset is nil when no dataset is configured
+	MedicationDataset *services.ReferenceDataset[*services.MedicationNormalizer]
+	// BatchLimiter bounds batch work, whether an admin or a job starts it
+	BatchLimiter *jobs.Limiter
+}
+
+// New builds the services on db and registers them on a gRPC server. It
+// fails when a service cannot be configured or a registered method is
+// missing from the permission, version or maintenance tables.
+func New(cfg *config.Config, db database.Database, logControl *logging.Controller, opts Options) (*Server, error) {
+	dbConn := db.GetConnection()
+	tenants := db.Tenants()
+
+	// Services publish domain events on the bus; subscribers are
+	// registered below, once everything is built.
+	eventBus := events.NewBus()
+	retryClassifier, err := services.NewRetryClassifier(cfg.Delivery.RetryOverrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure delivery retries: %w", err)
+	}
+	emailSender := opts.EmailSender
+	if emailSender == nil {
+		emailSender = services.NewLogEmailSender()
+		if cfg.Delivery.SMTPHost != "" {
+			emailSender, err = services.NewSMTPEmailSender(&cfg.Delivery)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure email delivery: %w", err)
+			}
+		}
+	}
+	deliveryQueue := services.NewDeliveryQueue(dbConn, &cfg.Delivery, map[string]services.Sender{
+		models.DeliveryChannelEmail:    emailSender,
+		models.DeliveryChannelPush:     services.NewNotifierSender(services.NewLogNotifier()),
+		models.DeliveryChannelWhatsApp: services.NewLogWhatsAppSender(),
+	}, retryClassifier)
+	authService := services.NewAuthService(dbConn, &cfg.Auth, deliveryQueue, eventBus)
+	if opts.Now != nil {
+		authService.SetClock(opts.Now)
+	}
+	recordTypes, err := services.LoadRecordTypes(cfg.Records.TypesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load record types: %w", err)
+	}
+	healthService := services.NewHealthRecordsService(dbConn, &cfg.Records, recordTypes, eventBus)
+	medicationService := services.NewMedicationService(dbConn, healthService)
+	var medications *services.ReferenceDataset[*services.MedicationNormalizer]
+	if cfg.Reference.MedicationDatasetPath != "" {
+		medications, err = services.LoadMedicationDataset(cfg.Reference.MedicationDatasetPath,
+			time.Duration(cfg.Reference.RefreshInterval)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load medication dataset: %w", err)
+		}
+	}
+
+	cache, err := services.NewCache(&cfg.Cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cache: %w", err)
+	}
+
+	featureFlags := services.NewFeatureFlags(cfg.Features.Disabled)
+	aiService := services.NewAIService(dbConn, &cfg.AI, medications, recordTypes, healthService, cache, time.Duration(cfg.Cache.TTL)*time.Second, featureFlags, eventBus)
+	if err := aiService.StartupSelfTest(context.Background()); err != nil {
+		return nil, fmt.Errorf("AI provider self-test failed: %w", err)
+	}
+	capabilityService := services.NewCapabilityService(aiService, featureFlags)
+	orgService := services.NewOrganizationService(dbConn, deliveryQueue)
+	reminderService := services.NewReminderService(dbConn, deliveryQueue)
+	searchService := services.NewSearchService(dbConn, featureFlags)
+	auditService := services.NewAuditService(dbConn)
+	correctionService := services.NewCorrectionService(dbConn)
+	exportService := services.NewExportService(dbConn, &cfg.Export, cfg.Server.PublicBaseURL, eventBus, deliveryQueue)
+
+	// Event subscribers. ExportService reads the access audit back to
+	// revoke links opened from too many addresses, so keep that one.
+	events.Subscribe(eventBus, "audit", auditService.OnExportLinkCreated)
+	events.Subscribe(eventBus, "audit", auditService.OnExportLinkAccessed)
+	events.Subscribe(eventBus, "audit", auditService.OnExportLinkRevoked)
+	events.Subscribe(eventBus, "audit", auditService.OnUserLoggedIn)
+	events.Subscribe(eventBus, "audit", auditService.OnRecordCreated)
+	events.Subscribe(eventBus, "audit", auditService.OnScanCompleted)
+	events.Subscribe(eventBus, "audit", auditService.OnConversationMessageAdded)
+
+	batchLimiter := jobs.NewLimiter(cfg.Jobs.MaxConcurrentBatch, cfg.Jobs.MaxQueuedBatch, time.Duration(cfg.Jobs.BatchQueueTimeout)*time.Second)
+	dataQualityService := services.NewDataQualityService(dbConn, batchLimiter)
+	reprocessService := services.NewReprocessService(dbConn, aiService, &cfg.Jobs)
+
+	// Recovery sits outside the concurrency limiter so a panicking handler
+	// still releases its permit before it is recovered. Version checks,
+	// kill switches, maintenance mode, provider override checks, tenant
+	// resolution and authentication run before the limiter so refused
+	// calls take no permit.
+	versions, err := versioning.NewRegistry(&cfg.Versioning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure client versions: %w", err)
+	}
+	concurrencyLimiter := concurrency.NewLimiter(&cfg.Concurrency)
+	auth := middleware.NewAuth(authService)
+	killSwitches := killswitch.NewSwitches()
+	healthServer := health.NewServer()
+	maintenanceMode := maintenance.NewMode(&cfg.Maintenance, healthServer)
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			logging.UnaryServerInterceptor(),
+			logging.RecoveryUnaryInterceptor(),
+			versions.UnaryServerInterceptor(),
+			killSwitches.UnaryServerInterceptor(),
+			maintenanceMode.UnaryServerInterceptor(),
+			handlers.ProviderOverrideUnaryInterceptor(cfg.Admin.APIKey, aiService),
+			handlers.TenantUnaryInterceptor(tenants, authService),
+			auth.UnaryServerInterceptor(),
+			concurrencyLimiter.UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			logging.StreamServerInterceptor(),
+			logging.RecoveryStreamInterceptor(),
+			versions.StreamServerInterceptor(),
+			killSwitches.StreamServerInterceptor(),
+			maintenanceMode.StreamServerInterceptor(),
+			handlers.ProviderOverrideStreamInterceptor(cfg.Admin.APIKey, aiService),
+			handlers.TenantStreamInterceptor(tenants, authService),
+			auth.StreamServerInterceptor(),
+			concurrencyLimiter.StreamServerInterceptor(),
+		),
+	)
+
+	healthgrpc.RegisterHealthServer(grpcServer, healthServer)
+	authpb.RegisterAuthServiceServer(grpcServer, handlers.NewAuthServer(authService))
+	healthpb.RegisterHealthRecordsServiceServer(grpcServer, handlers.NewHealthRecordsServer(healthService, reminderService, searchService, exportService, medicationService))
+	aipb.RegisterAIServiceServer(grpcServer, handlers.NewAIServer(aiService, capabilityService, cfg.AI.ChatStreamConcurrency))
+	orgpb.RegisterOrganizationServiceServer(grpcServer, handlers.NewOrganizationServer(orgService))
+	adminpb.RegisterAdminServiceServer(grpcServer, handlers.NewAdminServer(
+		cfg.Admin.APIKey,
+		searchService,
+		healthService,
+		auditService,
+		correctionService,
+		deliveryQueue,
+		batchLimiter,
+		dataQualityService,
+		reprocessService,
+		concurrencyLimiter,
+		killSwitches,
+		maintenanceMode,
+		versions,
+		eventBus,
+		logControl,
+		time.Duration(cfg.Logging.MaxDebugDuration)*time.Second,
+	))
+
+	registered := grpcServer.GetServiceInfo()
+	if err := permissions.Check(registered); err != nil {
+		return nil, fmt.Errorf("failed to configure method permissions: %w", err)
+	}
+	handlers.RegisterDeprecations(versions, registered)
+	if err := versions.Register(registered); err != nil {
+		return nil, fmt.Errorf("failed to configure client versions: %w", err)
+	}
+	killSwitches.Register(registered)
+	for _, method := range cfg.Features.DisabledEndpoints {
+		sw, _, err := killSwitches.Disable(method, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to disable endpoint: %w", err)
+		}
+		log.Printf("Endpoint %s is disabled", sw.Method)
+	}
+	writes, err := maintenanceMode.Register(registered)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up maintenance mode: %w", err)
+	}
+	if cfg.Maintenance.Enabled {
+		// Nothing is running yet, so there is nothing to drain
+		maintenanceMode.Enter(context.Background(), cfg.Maintenance.Reason, 0)
+		log.Printf("Starting in maintenance mode, %d write methods refused", writes)
+	}
+
+	return &Server{
+		GRPC: grpcServer,
+
+		Auth:          authService,
+		HealthRecords: healthService,
+		Medications:   medicationService,
+		AI:            aiService,
+		Reminders:     reminderService,
+		Search:        searchService,
+		Exports:       exportService,
+		Deliveries:    deliveryQueue,
+		Reprocess:     reprocessService,
+
+		MedicationDataset: medications,
+		BatchLimiter:      batchLimiter,
+	}, nil
+}
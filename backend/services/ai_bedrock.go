@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const bedrockDefaultModel = "anthropic.claude-3-haiku-20240307-v1:0"
+
+// bedrockMaxTokens bounds a reply; Converse requires a limit for some models
+const bedrockMaxTokens = 2048
+
+// bedrockBaseURL is the Bedrock runtime endpoint of an AWS region
+func bedrockBaseURL(region string) string {
+	return "https://bedrock-runtime." + firstNonEmpty(region, "us-east-1") + ".amazonaws.com"
+}
+
+// bedrockClient calls the Bedrock Converse API, authenticating with a
+// Bedrock API key rather than SigV4-signed IAM credentials
+type bedrockClient struct {
+	apiKey  string
+	keyEnv  string
+	model   string
+	baseURL string
+	http    *http.Client
+}
+
+type bedrockBlock struct {
+	Text  string        `json:"text,omitempty"`
+	Image *bedrockImage `json:"image,omitempty"`
+}
+
+type bedrockImage struct {
+	Format string `json:"format"` // jpeg, png, gif, webp
+	Source struct {
+		Bytes string `json:"bytes"` // base64
+	} `json:"source"`
+}
+
+type bedrockMessage struct {
+	Role    string         `json:"role"`
+	Content []bedrockBlock `json:"content"`
+}
+
+func (bc *bedrockClient) complete(ctx context.Context, req completion) (string, error) {
+	var content []bedrockBlock
+	if req.image != nil {
+		image := &bedrockImage{Format: strings.TrimPrefix(imageMediaType(req.image), "image/")}
+		image.Source.Bytes = base64.StdEncoding.EncodeToString(req.image)
+		content = append(content, bedrockBlock{Image: image})
+	}
+	content = append(content, bedrockBlock{Text: req.prompt})
+
+	var messages []bedrockMessage
+	for _, turn := range req.history {
+		messages = append(messages, bedrockMessage{Role: turn.Role, Content: []bedrockBlock{{Text: turn.Content}}})
+	}
+
+	// Converse has no JSON mode or reply schemas; the prompts already ask
+	// for JSON only
+	body := map[string]interface{}{
+		"messages":        append(messages, bedrockMessage{Role: "user", Content: content}),
+		"inferenceConfig": map[string]int{"maxTokens": bedrockMaxTokens},
+	}
+	if req.system != "" {
+		body["system"] = []bedrockBlock{{Text: req.system}}
+	}
+
+	var resp struct {
+		Output struct {
+			Message bedrockMessage `json:"message"`
+		} `json:"output"`
+		StopReason string `json:"stopReason"`
+	}
+	endpoint := strings.TrimSuffix(bc.baseURL, "/") + "/model/" + url.PathEscape(bc.model) + "/converse"
+	header := http.Header{"Authorization": {"Bearer " + bc.apiKey}}
+	if err := postProviderJSON(ctx, bc.http, "bedrock", bc.keyEnv, endpoint, header, body, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.StopReason == "content_filtered" || resp.StopReason == "guardrail_intervened" {
+		return "", refusalError("bedrock", "stopped for "+resp.StopReason)
+	}
+	var reply strings.Builder
+	for _, block := range resp.Output.Message.Content {
+		reply.WriteString(block.Text)
+	}
+	return reply.String(), nil
+}
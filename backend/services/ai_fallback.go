@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/clarity/backend/models"
+)
+
+// Rule-based stand-ins used while the AI provider is unavailable. They only
+// restate what the app already knows and never give medical advice.
+
+// fallbackRecentTitles bounds how many record titles the fallback summary
+// lists
+const fallbackRecentTitles = 5
+
+// ruleBasedChatReply answers doctor chat messages while the provider is down
+const ruleBasedChatReply = "Our AI doctor is temporarily unavailable, so this is an automated reply. " +
+	"Your message has been saved. If you have severe symptoms such as chest pain or trouble breathing, " +
+	"call your local emergency number now."
+
+// ruleBasedSummary counts the user's records by type and lists the most
+// recent titles. Of the other sections it can only list prescriptions as
+// medications; trends and risks need the model and are left empty.
+func ruleBasedSummary(records []models.HealthRecord, days int, sections []string) *HealthSummary {
+	counts := make(map[string]int)
+	for _, record := range records {
+		counts[record.RecordType]++
+	}
+	types := make([]string, 0, len(counts))
+	for recordType := range counts {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+
+	findings := make([]string, 0, len(types)+1)
+	for _, recordType := range types {
+		findings = append(findings, fmt.Sprintf("%d %s record(s)", counts[recordType], recordType))
+	}
+
+	recent := make([]models.HealthRecord, len(records))
+	copy(recent, records)
+	sort.Slice(recent, func(i, j int) bool { return recent[i].CreatedAt.After(recent[j].CreatedAt) })
+	if len(recent) > fallbackRecentTitles {
+		recent = recent[:fallbackRecentTitles]
+	}
+	for _, record := range recent {
+		findings = append(findings, "Recent: "+record.Title)
+	}
+
+	var medications []string
+	for _, record := range records {
+		if record.RecordType == "prescription" {
+			medications = append(medications, record.Title)
+		}
+	}
+
+	parts := make([]SummarySection, len(sections))
+	for i, name := range sections {
+		parts[i] = SummarySection{Name: name}
+		switch name {
+		case SummarySectionSummary:
+			parts[i].Text = fmt.Sprintf("You added %d records in the last %d days.", len(records), days)
+		case SummarySectionFindings:
+			parts[i].Items = findings
+		case SummarySectionRecommendations:
+			parts[i].Text = "A detailed AI summary is temporarily unavailable. Please try again later."
+		case SummarySectionMedications:
+			parts[i].Items = medications
+		}
+	}
+
+	summary := newHealthSummary(parts)
+	summary.Degraded = true
+	return summary
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	geminiBaseURL      = "https://generativelanguage.googleapis.com/v1beta"
+	geminiDefaultModel = "gemini-1.5-flash"
+)
+
+// geminiBlockedFinishReasons are the finish reasons Gemini gives when its
+// filters stopped a reply
+var geminiBlockedFinishReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+}
+
+// geminiClient calls the Gemini generateContent endpoint
+type geminiClient struct {
+	apiKey  string
+	keyEnv  string
+	model   string
+	baseURL string
+	http    *http.Client
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"` // base64
+}
+
+func (gc *geminiClient) complete(ctx context.Context, req completion) (string, error) {
+	var parts []geminiPart
+	if req.image != nil {
+		parts = append(parts, geminiPart{InlineData: &geminiInlineData{
+			MimeType: imageMediaType(req.image),
+			Data:     base64.StdEncoding.EncodeToString(req.image),
+		}})
+	}
+	parts = append(parts, geminiPart{Text: req.prompt})
+
+	// Gemini calls the assistant "model"
+	var contents []geminiContent
+	for _, turn := range req.history {
+		role := "user"
+		if turn.Role == ChatRoleAssistant {
+			role = "model"
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: []geminiPart{{Text: turn.Content}}})
+	}
+	body := map[string]interface{}{
+		"contents": append(contents, geminiContent{Role: "user", Parts: parts}),
+	}
+	if req.system != "" {
+		body["systemInstruction"] = geminiContent{Parts: []geminiPart{{Text: req.system}}}
+	}
+	if req.json {
+		generation := map[string]interface{}{"responseMimeType": "application/json"}
+		if req.schema != nil {
+			generation["responseSchema"] = geminiSchema(req.schema.schema)
+		}
+		body["generationConfig"] = generation
+	}
+
+	var resp struct {
+		Candidates []struct {
+			Content      geminiContent `json:"content"`
+			FinishReason string        `json:"finishReason"`
+		} `json:"candidates"`
+		PromptFeedback struct {
+			BlockReason string `json:"blockReason"`
+		} `json:"promptFeedback"`
+	}
+	endpoint := strings.TrimSuffix(gc.baseURL, "/") + "/models/" + url.PathEscape(gc.model) + ":generateContent"
+	header := http.Header{"X-Goog-Api-Key": {gc.apiKey}}
+	if err := postProviderJSON(ctx, gc.http, "gemini", gc.keyEnv, endpoint, header, body, &resp); err != nil {
+		return "", err
+	}
+
+	if reason := resp.PromptFeedback.BlockReason; reason != "" {
+		return "", refusalError("gemini", "prompt blocked: "+reason)
+	}
+	if len(resp.Candidates) == 0 {
+		return "", refusalError("gemini", "no candidates")
+	}
+	candidate := resp.Candidates[0]
+	if geminiBlockedFinishReasons[candidate.FinishReason] {
+		return "", refusalError("gemini", "stopped for "+candidate.FinishReason)
+	}
+	var reply strings.Builder
+	for _, part := range candidate.Content.Parts {
+		reply.WriteString(part.Text)
+	}
+	return reply.String(), nil
+}
+
+// geminiSchema rewrites a JSON Schema into the OpenAPI subset Gemini
+// takes: type names are upper case and additionalProperties is not
+// allowed
+func geminiSchema(schema interface{}) interface{} {
+	switch v := schema.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			switch key {
+			case "additionalProperties":
+			case "type":
+				if name, ok := value.(string); ok {
+					converted[key] = strings.ToUpper(name)
+				}
+			default:
+				converted[key] = geminiSchema(value)
+			}
+		}
+		return converted
+	default:
+		return v
+	}
+}
@@ -0,0 +1,302 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+// hostedProviderTimeout bounds one call to a hosted model API
+const hostedProviderTimeout = 60 * time.Second
+
+// scanPrompt asks a vision model for the fields AIService extracts from a
+// prescription label
+const scanPrompt = `Please analyze this prescription image and extract the following information in JSON format:
+{
+  "medication": "the medication name",
+  "dosage": "dose amount and unit",
+  "frequency": "how often to take (e.g., twice daily)",
+  "duration": "how long to take the medication",
+  "indication": "reason for the prescription",
+  "warnings": "any warnings or contraindications",
+  "refills": "number of refills allowed"
+}
+
+Leave fields the label does not show empty. Return ONLY the JSON object, no other text.`
+
+// chatSystemPrompt frames doctor chat for hosted models
+const chatSystemPrompt = "You are a medical assistant in a personal health records app. " +
+	"Give general health information in plain language, say when symptoms need a doctor, " +
+	"and never claim to diagnose or prescribe."
+
+// completion is one request to a hosted model
+type completion struct {
+	system  string
+	history []ChatMessage // earlier turns, sent ahead of the prompt
+	prompt  string
+	image   []byte // sent ahead of the prompt when set
+	json    bool   // ask for a JSON object reply where the API supports it
+
+	// schema constrains a JSON reply on APIs with structured output;
+	// others rely on the prompt describing the shape
+	schema *jsonSchema
+}
+
+// modelClient sends a completion to one hosted model API and returns the
+// reply text. Clients return ErrContentRefused when the API reports that
+// its filters stopped the reply.
+type modelClient interface {
+	complete(ctx context.Context, req completion) (string, error)
+}
+
+// streamingClient is a modelClient whose API can send the reply as it is
+// generated. stream passes each piece to onChunk and returns the whole
+// reply.
+type streamingClient interface {
+	stream(ctx context.Context, req completion, onChunk func(chunk string)) (string, error)
+}
+
+// hostedProvider adapts a hosted model API to AIProvider. The prompts and
+// reply parsing are shared, so providers differ only in how a prompt
+// reaches the model.
+type hostedProvider struct {
+	name       string
+	language   string // summaries are written in it
+	structured bool   // send reply schemas to APIs that enforce them
+	client     modelClient
+}
+
+func (hp *hostedProvider) Name() string {
+	return hp.name
+}
+
+func (hp *hostedProvider) ScanPrescription(ctx context.Context, imageData []byte) (map[string]string, error) {
+	req := completion{prompt: scanPrompt, image: imageData, json: true}
+	if hp.structured {
+		req.schema = scanSchema
+	}
+	reply, err := hp.client.complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseScanResponse(reply)
+}
+
+func (hp *hostedProvider) SummarizeHealth(ctx context.Context, records []models.HealthRecord, days int, sections []string) (*HealthSummary, error) {
+	req := completion{
+		system: SummaryPrompt(days, sections),
+		prompt: SummaryRecordsText(records, hp.language),
+		json:   true,
+	}
+	if hp.structured {
+		req.schema = summarySchema(sections)
+	}
+	reply, err := hp.client.complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSummaryResponse(reply, sections)
+}
+
+func (hp *hostedProvider) DoctorChat(ctx context.Context, message string) (string, error) {
+	return hp.DoctorChatWithHistory(ctx, nil, message)
+}
+
+func (hp *hostedProvider) DoctorChatWithHistory(ctx context.Context, history []ChatMessage, message string) (string, error) {
+	return hp.client.complete(ctx, completion{system: chatSystemPrompt, history: history, prompt: message})
+}
+
+// StreamDoctorChat streams the reply from APIs that can, and returns it
+// whole, without chunks, from the others
+func (hp *hostedProvider) StreamDoctorChat(ctx context.Context, history []ChatMessage, message string, onChunk func(chunk string)) (string, error) {
+	req := completion{system: chatSystemPrompt, history: history, prompt: message}
+	if sc, ok := hp.client.(streamingClient); ok {
+		return sc.stream(ctx, req, onChunk)
+	}
+	return hp.client.complete(ctx, req)
+}
+
+// parseScanResponse reads a model's JSON reply to scanPrompt. Fields the
+// model left empty are dropped, and numbers such as refills are kept as
+// text.
+func parseScanResponse(reply string) (map[string]string, error) {
+	// Models often wrap JSON in a code fence or a sentence
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("scan response is not JSON")
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse scan response: %w", err)
+	}
+
+	extractedData := make(map[string]string, len(raw))
+	for key, value := range raw {
+		var text string
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			text = v
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			text = string(encoded)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			extractedData[key] = text
+		}
+	}
+	return extractedData, nil
+}
+
+// newHostedProvider returns the hosted provider with the given name. The
+// model and API key configured for AI_PROVIDER apply when name is that
+// provider; others use their own <NAME>_API_KEY and default model. A
+// provider without an API key is a *ProviderConfigError.
+func newHostedProvider(name string, cfg *config.AIConfig) (AIProvider, error) {
+	key, keyEnv := providerKey(cfg, name)
+	model := ""
+	if name == cfg.Provider {
+		model = cfg.Model
+	}
+	httpClient := &http.Client{Timeout: hostedProviderTimeout}
+
+	var client modelClient
+	switch name {
+	case "openai":
+		client = &openAIClient{apiKey: key, keyEnv: keyEnv, model: firstNonEmpty(model, openAIDefaultModel), baseURL: openAIBaseURL, http: httpClient}
+	case "gemini":
+		client = &geminiClient{apiKey: key, keyEnv: keyEnv, model: firstNonEmpty(model, geminiDefaultModel), baseURL: geminiBaseURL, http: httpClient}
+	case "bedrock":
+		client = &bedrockClient{apiKey: key, keyEnv: keyEnv, model: firstNonEmpty(model, bedrockDefaultModel), baseURL: bedrockBaseURL(cfg.Region), http: httpClient}
+	default:
+		return nil, fmt.Errorf("unknown AI provider %q", name)
+	}
+	if key == "" {
+		return nil, &ProviderConfigError{Provider: name, EnvVar: keyEnv, Err: ErrProviderNotConfigured}
+	}
+	return &hostedProvider{name: name, language: cfg.Language, structured: cfg.StructuredOutput, client: client}, nil
+}
+
+// providerKey returns the API key for a hosted provider and the setting
+// it comes from: the provider's own <NAME>_API_KEY, or AI_API_KEY for the
+// configured provider
+func providerKey(cfg *config.AIConfig, name string) (string, string) {
+	keyEnv := strings.ToUpper(name) + "_API_KEY"
+	if key := strings.TrimSpace(cfg.ProviderAPIKeys[name]); key != "" {
+		return key, keyEnv
+	}
+	if name == cfg.Provider {
+		return strings.TrimSpace(cfg.APIKey), "AI_API_KEY"
+	}
+	return "", keyEnv
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// postProviderJSON posts body as JSON and decodes the JSON reply into out.
+// Rejected credentials are reported as ErrProviderAuth naming keyEnv.
+func postProviderJSON(ctx context.Context, client *http.Client, provider, keyEnv, url string, header http.Header, body, out interface{}) error {
+	resp, err := postProvider(ctx, client, provider, keyEnv, url, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", provider, err)
+	}
+	return nil
+}
+
+// postProviderEvents posts body as JSON and passes the data of each
+// server-sent event in the reply to onEvent, stopping at the "[DONE]"
+// event some APIs end with
+func postProviderEvents(ctx context.Context, client *http.Client, provider, keyEnv, url string, header http.Header, body interface{}, onEvent func(data []byte) error) error {
+	resp, err := postProvider(ctx, client, provider, keyEnv, url, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			return nil
+		}
+		if err := onEvent(data); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s stream failed: %w", provider, err)
+	}
+	return nil
+}
+
+// postProvider posts body as JSON and returns the response if the API
+// accepted the request. Rejected credentials are reported as
+// ErrProviderAuth naming keyEnv.
+func postProvider(ctx context.Context, client *http.Client, provider, keyEnv, url string, header http.Header, body interface{}) (*http.Response, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request: %w", provider, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", provider, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		// Gemini answers a bad key with 400 API_KEY_INVALID
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden || bytes.Contains(msg, []byte("API_KEY_INVALID")) {
+			return nil, &ProviderConfigError{Provider: provider, EnvVar: keyEnv, Err: ErrProviderAuth}
+		}
+		return nil, fmt.Errorf("%s request failed with status %d: %s", provider, resp.StatusCode, msg)
+	}
+	return resp, nil
+}
+
+// imageMediaType sniffs the MIME type of an image, defaulting to JPEG,
+// the format phone cameras produce
+func imageMediaType(imageData []byte) string {
+	if mediaType := http.DetectContentType(imageData); strings.HasPrefix(mediaType, "image/") {
+		return mediaType
+	}
+	return "image/jpeg"
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+// testPNG starts with the PNG signature, which is all imageMediaType reads
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// providerRequest is what a hosted provider sent to its API
+type providerRequest struct {
+	path   string
+	header http.Header
+	body   map[string]interface{}
+	raw    string
+}
+
+// hostedTestProvider returns the named provider with the API key "key",
+// sending its requests to a server that records them and answers with
+// reply
+func hostedTestProvider(t *testing.T, cfg config.AIConfig, reply string) (AIProvider, *providerRequest) {
+	t.Helper()
+	sent := &providerRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		sent.path, sent.header, sent.raw = r.URL.Path, r.Header, string(raw)
+		sent.body = nil
+		json.Unmarshal(raw, &sent.body)
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	httpClient := &http.Client{Transport: redirectTransport{target: target}}
+
+	cfg.APIKey = "key"
+	p, err := newNamedAIProvider(cfg.Provider, &cfg)
+	if err != nil {
+		t.Fatalf("newNamedAIProvider(%s): %v", cfg.Provider, err)
+	}
+	switch client := p.(*hostedProvider).client.(type) {
+	case *openAIClient:
+		client.http = httpClient
+	case *geminiClient:
+		client.http = httpClient
+	case *bedrockClient:
+		client.http = httpClient
+	}
+	return p, sent
+}
+
+// scanReply is a model's scan reply, fenced and with a number and an empty
+// field as models send them
+const scanReply = "```json\\n{\\\"medication\\\": \\\"Amoxicillin\\\", \\\"dosage\\\": \\\"250mg\\\", \\\"refills\\\": 2, \\\"warnings\\\": \\\"\\\"}\\n```"
+
+// hostedReplies are each API's response carrying a reply text
+var hostedReplies = map[string]func(text string) string{
+	"openai": func(text string) string {
+		return `{"choices":[{"message":{"content":"` + text + `"},"finish_reason":"stop"}]}`
+	},
+	"gemini": func(text string) string {
+		return `{"candidates":[{"content":{"role":"model","parts":[{"text":"` + text + `"}]},"finishReason":"STOP"}]}`
+	},
+	"bedrock": func(text string) string {
+		return `{"output":{"message":{"role":"assistant","content":[{"text":"` + text + `"}]}},"stopReason":"end_turn"}`
+	},
+}
+
+func TestHostedProviderScan(t *testing.T) {
+	tests := []struct {
+		provider string
+		model    string
+		path     string
+		auth     [2]string
+		image    string // how the image's type is sent
+	}{
+		{"openai", "gpt-4o", "/v1/chat/completions", [2]string{"Authorization", "Bearer key"}, "data:image/png;base64,"},
+		{"gemini", "", "/v1beta/models/gemini-1.5-flash:generateContent", [2]string{"X-Goog-Api-Key", "key"}, `"mimeType":"image/png"`},
+		{"bedrock", "", "/model/anthropic.claude-3-haiku-20240307-v1:0/converse", [2]string{"Authorization", "Bearer key"}, `"format":"png"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			p, sent := hostedTestProvider(t, config.AIConfig{Provider: tt.provider, Model: tt.model}, hostedReplies[tt.provider](scanReply))
+
+			extractedData, err := p.ScanPrescription(context.Background(), testPNG)
+			if err != nil {
+				t.Fatalf("ScanPrescription: %v", err)
+			}
+			want := map[string]string{"medication": "Amoxicillin", "dosage": "250mg", "refills": "2"}
+			if !reflect.DeepEqual(extractedData, want) {
+				t.Errorf("extracted %v, want %v", extractedData, want)
+			}
+
+			if sent.path != tt.path {
+				t.Errorf("request to %s, want %s", sent.path, tt.path)
+			}
+			if got := sent.header.Get(tt.auth[0]); got != tt.auth[1] {
+				t.Errorf("%s header = %q, want %q", tt.auth[0], got, tt.auth[1])
+			}
+			if tt.model != "" && sent.body["model"] != tt.model {
+				t.Errorf("model = %v, want the configured %s", sent.body["model"], tt.model)
+			}
+			if !strings.Contains(sent.raw, base64.StdEncoding.EncodeToString(testPNG)) || !strings.Contains(sent.raw, tt.image) {
+				t.Errorf("request does not carry the image as %s: %s", tt.image, sent.raw)
+			}
+			if !strings.Contains(sent.raw, "extract the following information") {
+				t.Errorf("request does not carry the scan prompt: %s", sent.raw)
+			}
+		})
+	}
+}
+
+// messageRoles lists the roles of the messages in an API request body
+func messageRoles(body map[string]interface{}, key string) []string {
+	messages, _ := body[key].([]interface{})
+	var roles []string
+	for _, message := range messages {
+		role, _ := message.(map[string]interface{})["role"].(string)
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func TestHostedProviderChatHistory(t *testing.T) {
+	history := []ChatMessage{
+		{Role: ChatRoleUser, Content: "I have a headache"},
+		{Role: ChatRoleAssistant, Content: "How long has it lasted?"},
+	}
+	tests := []struct {
+		provider    string
+		messagesKey string
+		roles       []string
+		systemKey   string
+	}{
+		{"openai", "messages", []string{"system", "user", "assistant", "user"}, ""},
+		{"gemini", "contents", []string{"user", "model", "user"}, "systemInstruction"},
+		{"bedrock", "messages", []string{"user", "assistant", "user"}, "system"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			p, sent := hostedTestProvider(t, config.AIConfig{Provider: tt.provider}, hostedReplies[tt.provider]("Drink water and rest."))
+
+			reply, err := p.(ConversationalProvider).DoctorChatWithHistory(context.Background(), history, "Two days")
+			if err != nil || reply != "Drink water and rest." {
+				t.Fatalf("DoctorChatWithHistory = %q, %v", reply, err)
+			}
+			if roles := messageRoles(sent.body, tt.messagesKey); !reflect.DeepEqual(roles, tt.roles) {
+				t.Errorf("message roles = %v, want %v", roles, tt.roles)
+			}
+			if tt.systemKey != "" && sent.body[tt.systemKey] == nil {
+				t.Errorf("no %s in the request: %s", tt.systemKey, sent.raw)
+			}
+			for _, text := range []string{chatSystemPrompt, "I have a headache", "How long has it lasted?", "Two days"} {
+				if !strings.Contains(sent.raw, text) {
+					t.Errorf("request does not carry %q", text)
+				}
+			}
+		})
+	}
+}
+
+func TestHostedProviderFilterIsARefusal(t *testing.T) {
+	tests := []struct {
+		provider string
+		reply    string
+	}{
+		{"openai", `{"choices":[{"message":{"content":""},"finish_reason":"content_filter"}]}`},
+		{"openai", `{"choices":[{"message":{"content":null,"refusal":"I can't help with that."},"finish_reason":"stop"}]}`},
+		{"openai", `{"choices":[]}`},
+		{"gemini", `{"promptFeedback":{"blockReason":"SAFETY"}}`},
+		{"gemini", `{"candidates":[{"content":{"parts":[]},"finishReason":"SAFETY"}]}`},
+		{"bedrock", `{"output":{"message":{"role":"assistant","content":[{"text":"Sorry."}]}},"stopReason":"guardrail_intervened"}`},
+		{"bedrock", `{"output":{"message":{"role":"assistant","content":[]}},"stopReason":"content_filtered"}`},
+	}
+	for _, tt := range tests {
+		p, _ := hostedTestProvider(t, config.AIConfig{Provider: tt.provider}, tt.reply)
+		if _, err := p.DoctorChat(context.Background(), "hello"); !errors.Is(err, ErrContentRefused) || !strings.Contains(err.Error(), tt.provider) {
+			t.Errorf("%s answering %s: %v, want a refusal naming the provider", tt.provider, tt.reply, err)
+		}
+	}
+
+	// A reply the API sent in full is not a refusal
+	p, _ := hostedTestProvider(t, config.AIConfig{Provider: "gemini"}, hostedReplies["gemini"]("Rest."))
+	if _, err := p.DoctorChat(context.Background(), "hello"); err != nil {
+		t.Errorf("gemini reply: %v", err)
+	}
+}
+
+func TestOpenAIStreamsChat(t *testing.T) {
+	events := strings.Join([]string{
+		`data: {"choices":[{"delta":{"role":"assistant","content":""}}]}`,
+		`data: {"choices":[{"delta":{"content":"Drink "}}]}`,
+		`data: {"choices":[{"delta":{"content":"water."}}]}`,
+		`data: {"choices":[{"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	p, sent := hostedTestProvider(t, config.AIConfig{Provider: "openai"}, events)
+
+	var chunks []string
+	reply, err := p.(StreamingChatProvider).StreamDoctorChat(context.Background(), nil, "hello", func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil || reply != "Drink water." {
+		t.Fatalf("StreamDoctorChat = %q, %v", reply, err)
+	}
+	if want := []string{"Drink ", "water."}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("chunks = %q, want %q", chunks, want)
+	}
+	if sent.body["stream"] != true {
+		t.Errorf("request did not ask for a stream: %s", sent.raw)
+	}
+
+	// A stream the content filter stopped is a refusal, whatever came first
+	filtered := `data: {"choices":[{"delta":{"content":"Here"}}]}` + "\n\n" + `data: {"choices":[{"delta":{},"finish_reason":"content_filter"}]}`
+	p, _ = hostedTestProvider(t, config.AIConfig{Provider: "openai"}, filtered)
+	if _, err := p.(StreamingChatProvider).StreamDoctorChat(context.Background(), nil, "hello", func(string) {}); !errors.Is(err, ErrContentRefused) {
+		t.Errorf("filtered stream: %v, want a refusal", err)
+	}
+}
+
+func TestStreamedChatIsStoredWhole(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	createUser(t, db, "user-1")
+	stream := strings.Join([]string{
+		`data: {"choices":[{"delta":{"content":"Drink "}}]}`,
+		`data: {"choices":[{"delta":{"content":"water."}}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	as.provider, _ = hostedTestProvider(t, config.AIConfig{Provider: "openai"}, stream)
+
+	var chunks []string
+	reply, degraded, err := as.StreamDoctorChat(context.Background(), "user-1", "conv-1", "hello", func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil || degraded || reply != "Drink water." {
+		t.Fatalf("StreamDoctorChat = %q, %t, %v", reply, degraded, err)
+	}
+	if len(chunks) != 2 {
+		t.Errorf("chunks = %q, want the reply in two parts", chunks)
+	}
+
+	// The conversation keeps the whole reply, not the last chunk
+	var stored []models.DoctorConversation
+	if err := db.Where("user_id = ? AND conversation_id = ?", "user-1", "conv-1").Find(&stored).Error; err != nil {
+		t.Fatalf("load conversation: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Message != "hello" || stored[0].Response != "Drink water." {
+		t.Errorf("stored turns = %+v, want hello answered with the whole reply", stored)
+	}
+
+	// A stream the content filter stops is not stored
+	filtered := `data: {"choices":[{"delta":{"content":"Here"}}]}` + "\n\n" + `data: {"choices":[{"delta":{},"finish_reason":"content_filter"}]}`
+	as.provider, _ = hostedTestProvider(t, config.AIConfig{Provider: "openai"}, filtered)
+	if _, _, err := as.StreamDoctorChat(context.Background(), "user-1", "conv-2", "hello", func(string) {}); !errors.Is(err, ErrContentRefused) {
+		t.Fatalf("filtered stream: %v, want a refusal", err)
+	}
+	var count int64
+	db.Model(&models.DoctorConversation{}).Where("conversation_id = ?", "conv-2").Count(&count)
+	if count != 0 {
+		t.Errorf("refused stream stored %d turns", count)
+	}
+}
+
+func TestStreamWithoutStreamingAPI(t *testing.T) {
+	p, sent := hostedTestProvider(t, config.AIConfig{Provider: "gemini"}, hostedReplies["gemini"]("Rest."))
+
+	chunks := 0
+	reply, err := p.(StreamingChatProvider).StreamDoctorChat(context.Background(), nil, "hello", func(string) { chunks++ })
+	if err != nil || reply != "Rest." || chunks != 0 {
+		t.Errorf("StreamDoctorChat = %q, %d chunks, %v; want the whole reply and no chunks", reply, chunks, err)
+	}
+	if !strings.HasSuffix(sent.path, ":generateContent") {
+		t.Errorf("request to %s, want generateContent", sent.path)
+	}
+}
+
+func TestNewAIServiceSelectsProvider(t *testing.T) {
+	tests := []struct {
+		cfg  config.AIConfig
+		want string
+	}{
+		{config.AIConfig{}, "mock"},
+		{config.AIConfig{Provider: "openai", APIKey: "sk-key"}, "openai"},
+		{config.AIConfig{Provider: "gemini", ProviderAPIKeys: map[string]string{"gemini": "g-key"}}, "gemini"},
+		{config.AIConfig{Provider: "bedrock", APIKey: "b-key", Region: "eu-west-1"}, "bedrock"},
+		{config.AIConfig{Provider: "sandbox"}, "sandbox"},
+		// No key, or a provider that does not exist, falls back to the mock
+		{config.AIConfig{Provider: "bedrock"}, "mock"},
+		{config.AIConfig{Provider: "watson", APIKey: "key"}, "mock"},
+	}
+	for _, tt := range tests {
+		cfg := tt.cfg
+		as := newTestAIService(t, newTestDB(t), &cfg)
+		if got := as.provider.Name(); got != tt.want {
+			t.Errorf("AI_PROVIDER=%q: provider %s, want %s", tt.cfg.Provider, got, tt.want)
+		}
+	}
+
+	// Bedrock is called in the configured region
+	p, err := newNamedAIProvider("bedrock", &config.AIConfig{Provider: "bedrock", APIKey: "b-key", Region: "eu-west-1"})
+	if err != nil {
+		t.Fatalf("newNamedAIProvider: %v", err)
+	}
+	if client := p.(*hostedProvider).client.(*bedrockClient); client.baseURL != "https://bedrock-runtime.eu-west-1.amazonaws.com" {
+		t.Errorf("bedrock endpoint = %s", client.baseURL)
+	}
+}
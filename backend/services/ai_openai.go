@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	openAIBaseURL      = "https://api.openai.com/v1"
+	openAIDefaultModel = "gpt-4o-mini"
+)
+
+// openAIClient calls the OpenAI chat completions endpoint
+type openAIClient struct {
+	apiKey  string
+	keyEnv  string
+	model   string
+	baseURL string
+	http    *http.Client
+}
+
+type openAIMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // text, or parts when sending an image
+}
+
+type openAIPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+func (oc *openAIClient) complete(ctx context.Context, req completion) (string, error) {
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+				Refusal string `json:"refusal"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	header := http.Header{"Authorization": {"Bearer " + oc.apiKey}}
+	if err := postProviderJSON(ctx, oc.http, "openai", oc.keyEnv, strings.TrimSuffix(oc.baseURL, "/")+"/chat/completions", header, oc.body(req), &resp); err != nil {
+		return "", err
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", refusalError("openai", "no choices")
+	}
+	choice := resp.Choices[0]
+	return openAIReply(choice.Message.Content, choice.Message.Refusal, choice.FinishReason)
+}
+
+// stream asks for the reply as server-sent events, each carrying the next
+// piece of it
+func (oc *openAIClient) stream(ctx context.Context, req completion, onChunk func(chunk string)) (string, error) {
+	body := oc.body(req)
+	body["stream"] = true
+
+	var reply, refusal strings.Builder
+	var finishReason string
+	header := http.Header{"Authorization": {"Bearer " + oc.apiKey}}
+	err := postProviderEvents(ctx, oc.http, "openai", oc.keyEnv, strings.TrimSuffix(oc.baseURL, "/")+"/chat/completions", header, body, func(data []byte) error {
+		var event struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+					Refusal string `json:"refusal"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to decode openai stream: %w", err)
+		}
+		for _, choice := range event.Choices {
+			if choice.Delta.Content != "" {
+				reply.WriteString(choice.Delta.Content)
+				onChunk(choice.Delta.Content)
+			}
+			refusal.WriteString(choice.Delta.Refusal)
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return openAIReply(reply.String(), refusal.String(), finishReason)
+}
+
+// openAIReply is the reply text, or a refusal when the model declined or
+// the content filter stopped it
+func openAIReply(content, refusal, finishReason string) (string, error) {
+	if finishReason == "content_filter" {
+		return "", refusalError("openai", "stopped by content filter")
+	}
+	if refusal != "" {
+		return "", refusalError("openai", refusal)
+	}
+	return content, nil
+}
+
+// body is the chat completions request for req
+func (oc *openAIClient) body(req completion) map[string]interface{} {
+	var messages []openAIMessage
+	if req.system != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: req.system})
+	}
+	for _, turn := range req.history {
+		messages = append(messages, openAIMessage{Role: turn.Role, Content: turn.Content})
+	}
+	if req.image != nil {
+		dataURL := "data:" + imageMediaType(req.image) + ";base64," + base64.StdEncoding.EncodeToString(req.image)
+		messages = append(messages, openAIMessage{Role: "user", Content: []openAIPart{
+			{Type: "image_url", ImageURL: &openAIImageURL{URL: dataURL}},
+			{Type: "text", Text: req.prompt},
+		}})
+	} else {
+		messages = append(messages, openAIMessage{Role: "user", Content: req.prompt})
+	}
+
+	body := map[string]interface{}{"model": oc.model, "messages": messages}
+	switch {
+	case req.schema != nil:
+		body["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   req.schema.name,
+				"schema": req.schema.schema,
+				"strict": true,
+			},
+		}
+	case req.json:
+		body["response_format"] = map[string]string{"type": "json_object"}
+	}
+
+	return body
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+// AIProvider is the model backend behind AIService. AIService owns
+// validation, persistence, and post-processing; providers only turn inputs
+// into model output.
+// Providers return ErrContentRefused, wrapped with the provider's reason,
+// when the upstream model declines a request, for example when it stops
+// for a content filter.
+type AIProvider interface {
+	Name() string
+	ScanPrescription(ctx context.Context, imageData []byte) (map[string]string, error)
+	// SummarizeHealth returns the requested sections, which AIService has
+	// already validated against the supported set
+	SummarizeHealth(ctx context.Context, records []models.HealthRecord, days int, sections []string) (*HealthSummary, error)
+	DoctorChat(ctx context.Context, message string) (string, error)
+}
+
+// HealthSummary is a provider's summary of a user's recent records.
+// Sections holds every requested section in request order; Summary,
+// KeyFindings and Recommendations repeat the default sections when they
+// were requested.
+type HealthSummary struct {
+	Summary         string
+	KeyFindings     []string
+	Recommendations string
+	Sections        []SummarySection
+	Degraded        bool // produced by the rule-based fallback
+
+	// IncompleteSections are requested sections the provider left out or
+	// sent in a form that could not be read. They are empty in Sections;
+	// the others are usable.
+	IncompleteSections []string
+
+	// RecordsTruncated is set when the window held more records than the
+	// configured cap and only the most recent RecordCount were summarized
+	RecordsTruncated bool
+	RecordCount      int
+}
+
+// OCRText is the text a provider read from an image before extracting
+// fields from it
+type OCRText struct {
+	Text   string
+	Tokens []OCRToken
+}
+
+// OCRToken is one word of OCRText with the provider's confidence in it,
+// from 0 to 1
+type OCRToken struct {
+	Text       string
+	Confidence float64
+}
+
+// TextReadingProvider is implemented by providers that can return the raw
+// text behind a scan along with the extraction, for clients that run their
+// own parsing
+type TextReadingProvider interface {
+	ScanPrescriptionText(ctx context.Context, imageData []byte) (map[string]string, *OCRText, error)
+}
+
+// Roles of a ChatMessage
+const (
+	ChatRoleUser      = "user"
+	ChatRoleAssistant = "assistant"
+)
+
+// ChatMessage is one message of an earlier doctor chat turn
+type ChatMessage struct {
+	Role    string
+	Content string
+}
+
+// ConversationalProvider is implemented by providers that take the earlier
+// turns of a chat as separate messages. History alternates user and
+// assistant messages, oldest first. AIService writes the history into the
+// prompt for other providers.
+type ConversationalProvider interface {
+	DoctorChatWithHistory(ctx context.Context, history []ChatMessage, message string) (string, error)
+}
+
+// StreamingChatProvider is implemented by providers that can pass a chat
+// reply on as it is generated. onChunk receives each piece of the reply in
+// order, and the whole reply is returned at the end. History is as for
+// ConversationalProvider.
+type StreamingChatProvider interface {
+	StreamDoctorChat(ctx context.Context, history []ChatMessage, message string, onChunk func(chunk string)) (string, error)
+}
+
+// ocrText builds OCRText from lines of text, scoring each word with
+// confidence
+func ocrText(lines []string, confidence func(i int) float64) *OCRText {
+	text := &OCRText{Text: strings.Join(lines, "\n")}
+	for _, word := range strings.Fields(text.Text) {
+		text.Tokens = append(text.Tokens, OCRToken{Text: word, Confidence: confidence(len(text.Tokens))})
+	}
+	return text
+}
+
+// ProviderConfigError reports a provider that cannot be called because its
+// credentials are missing or were rejected, and which setting the operator
+// has to fix
+type ProviderConfigError struct {
+	Provider string
+	EnvVar   string
+	Err      error // ErrProviderNotConfigured or ErrProviderAuth
+}
+
+func (e *ProviderConfigError) Error() string {
+	if e.Err == ErrProviderAuth {
+		return fmt.Sprintf("%s rejected its credentials, check %s", e.Provider, e.EnvVar)
+	}
+	return fmt.Sprintf("%s is not configured, set %s", e.Provider, e.EnvVar)
+}
+
+func (e *ProviderConfigError) Unwrap() error {
+	return e.Err
+}
+
+// NewAIProvider returns the AI provider selected in config: openai,
+// gemini, bedrock, sandbox or mock. An unknown provider, or a hosted one
+// without an API key, is reported at startup and the mock is used instead,
+// so development setups run without credentials.
+func NewAIProvider(cfg *config.AIConfig) AIProvider {
+	provider, err := newNamedAIProvider(cfg.Provider, cfg)
+	if err != nil {
+		log.Printf("Warning: %v; using the mock AI provider", err)
+		return &mockAIProvider{}
+	}
+	return provider
+}
+
+// newNamedAIProvider returns the provider with the given name
+func newNamedAIProvider(name string, cfg *config.AIConfig) (AIProvider, error) {
+	switch name {
+	case "sandbox":
+		return &sandboxAIProvider{}, nil
+	case "mock":
+		return &mockAIProvider{}, nil
+	}
+	return newHostedProvider(name, cfg)
+}
+
+// selfTestPrompt keeps the self-test call as small as the provider allows
+const selfTestPrompt = "Reply with OK."
+
+// ProviderSelfTest makes one tiny chat call to check the provider answers
+func ProviderSelfTest(ctx context.Context, provider AIProvider, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	reply, err := provider.DoctorChat(ctx, selfTestPrompt)
+	if err != nil {
+		return fmt.Errorf("%s self-test failed: %w", provider.Name(), err)
+	}
+	if strings.TrimSpace(reply) == "" {
+		return fmt.Errorf("%s self-test failed: empty reply", provider.Name())
+	}
+	return nil
+}
+
+type mockAIProvider struct{}
+
+func (mp *mockAIProvider) Name() string {
+	return "mock"
+}
+
+func (mp *mockAIProvider) ScanPrescription(ctx context.Context, imageData []byte) (map[string]string, error) {
+	return map[string]string{
+		"medication": "Aspirin",
+		"dosage":     "500mg",
+		"frequency":  "Twice daily",
+		"duration":   "7 days",
+		"indication": "Headache/Pain relief",
+	}, nil
+}
+
+func (mp *mockAIProvider) ScanPrescriptionText(ctx context.Context, imageData []byte) (map[string]string, *OCRText, error) {
+	extractedData, err := mp.ScanPrescription(ctx, imageData)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := []string{
+		"Rx " + extractedData["medication"] + " " + extractedData["dosage"],
+		extractedData["frequency"] + " for " + extractedData["duration"],
+		"For: " + extractedData["indication"],
+	}
+	return extractedData, ocrText(lines, func(int) float64 { return 0.99 }), nil
+}
+
+var mockSummarySections = map[string]SummarySection{
+	SummarySectionFindings: {Items: []string{
+		"Overall health status: Good",
+		"Recent medications: None critical",
+		"Recommended actions: Regular check-up",
+	}},
+	SummarySectionRecommendations: {Text: "Stay hydrated, maintain regular exercise, and schedule a check-up next month."},
+	SummarySectionMedications:     {Items: []string{"No medications need attention"}},
+	SummarySectionTrends:          {Items: []string{"No notable changes"}},
+	SummarySectionRisks:           {Items: []string{"No risks identified"}},
+}
+
+func (mp *mockAIProvider) SummarizeHealth(ctx context.Context, records []models.HealthRecord, days int, sections []string) (*HealthSummary, error) {
+	parts := make([]SummarySection, len(sections))
+	for i, name := range sections {
+		parts[i] = mockSummarySections[name]
+		parts[i].Name = name
+		if name == SummarySectionSummary {
+			parts[i].Text = fmt.Sprintf("Health Summary for last %d days: %d records found.", days, len(records))
+		}
+	}
+	return newHealthSummary(parts), nil
+}
+
+func (mp *mockAIProvider) DoctorChat(ctx context.Context, message string) (string, error) {
+	return fmt.Sprintf("AI Doctor: I've noted your concern about '%s'. Please provide more details about your symptoms.", message), nil
+}
+
+// mockRecalledMessages is how many of the user's earlier messages the mock
+// repeats back, showing the history reached it
+const mockRecalledMessages = 3
+
+func (mp *mockAIProvider) DoctorChatWithHistory(ctx context.Context, history []ChatMessage, message string) (string, error) {
+	reply, err := mp.DoctorChat(ctx, message)
+	if err != nil {
+		return "", err
+	}
+	var recalled []string
+	for i := len(history) - 1; i >= 0 && len(recalled) < mockRecalledMessages; i-- {
+		if history[i].Role == ChatRoleUser {
+			recalled = append(recalled, fmt.Sprintf("'%s'", history[i].Content))
+		}
+	}
+	if len(recalled) == 0 {
+		return reply, nil
+	}
+	slices.Reverse(recalled)
+	return reply + " Earlier you said: " + strings.Join(recalled, ", ") + ".", nil
+}
+
+// StreamDoctorChat passes the mock's reply on a word at a time, so clients
+// can try incremental rendering without a hosted provider
+func (mp *mockAIProvider) StreamDoctorChat(ctx context.Context, history []ChatMessage, message string, onChunk func(chunk string)) (string, error) {
+	reply, err := mp.DoctorChatWithHistory(ctx, history, message)
+	if err != nil {
+		return "", err
+	}
+	for rest := reply; rest != ""; {
+		end := strings.IndexByte(rest, ' ') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		onChunk(rest[:end])
+		rest = rest[end:]
+	}
+	return reply, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// hangingProvider never answers a chat until its context ends
+type hangingProvider struct {
+	fakeProvider
+}
+
+func (hp *hangingProvider) DoctorChat(ctx context.Context, message string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestStartupSelfTest(t *testing.T) {
+	providerDown := errors.New("401 invalid api key")
+	tests := []struct {
+		name      string
+		cfg       config.AIConfig
+		provider  AIProvider
+		wantErr   bool
+		wantChats int
+	}{
+		{"off by default", config.AIConfig{}, &fakeProvider{chatErr: providerDown}, false, 0},
+		{"passes", config.AIConfig{SelfTest: true}, &fakeProvider{reply: "OK"}, false, 1},
+		{"failure is only logged", config.AIConfig{SelfTest: true}, &fakeProvider{chatErr: providerDown}, false, 1},
+		{"required and failing", config.AIConfig{SelfTest: true, SelfTestRequired: true}, &fakeProvider{chatErr: providerDown}, true, 1},
+		{"required and empty reply", config.AIConfig{SelfTest: true, SelfTestRequired: true}, &fakeProvider{reply: "  "}, true, 1},
+		{"required and passing", config.AIConfig{SelfTest: true, SelfTestRequired: true}, &fakeProvider{reply: "OK"}, false, 1},
+		{"required and timing out", config.AIConfig{SelfTest: true, SelfTestRequired: true, SelfTestTimeout: 1}, &hangingProvider{}, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			as := newTestAIService(t, newTestDB(t), &cfg)
+			as.provider = tt.provider
+
+			start := time.Now()
+			err := as.StartupSelfTest(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StartupSelfTest() error = %v, want error %v", err, tt.wantErr)
+			}
+			if fake, ok := tt.provider.(*fakeProvider); ok && fake.chats != tt.wantChats {
+				t.Errorf("provider called %d times, want %d", fake.chats, tt.wantChats)
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("self-test took %v, want it bounded by the timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestProviderSelfTestNamesProvider(t *testing.T) {
+	err := ProviderSelfTest(context.Background(), &fakeProvider{chatErr: ErrProviderAuth}, 0)
+	if !errors.Is(err, ErrProviderAuth) {
+		t.Fatalf("ProviderSelfTest() error = %v, want it to wrap the provider error", err)
+	}
+	if !strings.HasPrefix(err.Error(), "fake ") {
+		t.Errorf("error %q does not name the provider", err)
+	}
+}
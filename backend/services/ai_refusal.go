@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RefusalMessage is shown to users when the provider declines a request,
+// in place of the provider's own wording
+const RefusalMessage = "The AI assistant couldn't respond to this request. " +
+	"Try rephrasing it, or contact a doctor directly if it is urgent."
+
+// maxRefusalLength bounds the replies checked for refusal wording. A long
+// answer that opens with "I can't diagnose..." is still an answer.
+const maxRefusalLength = 300
+
+// refusalPattern matches the usual openings of a safety-filter refusal
+var refusalPattern = regexp.MustCompile(`(?i)^\s*(?:i'?m sorry,?\s*(?:but\s*)?)?(?:i(?:\s+(?:can(?:no|')t|am\s+(?:not\s+able\s+to|unable\s+to)|won'?t)|'m\s+(?:not\s+able\s+to|unable\s+to))\s+(?:help|assist|provide|respond|answer|comply|do\s+that)|as\s+an\s+ai\b[^.]*\b(?:can(?:no|')t|unable))`)
+
+// refusalError marks a provider refusal. The provider's reason is kept for
+// logs; callers show RefusalMessage.
+func refusalError(provider, reason string) error {
+	return fmt.Errorf("%w: %s: %s", ErrContentRefused, provider, reason)
+}
+
+// checkReply turns an empty or refusing text reply into ErrContentRefused
+func checkReply(provider, reply string) error {
+	trimmed := strings.TrimSpace(reply)
+	if trimmed == "" {
+		return refusalError(provider, "empty reply")
+	}
+	if len(trimmed) <= maxRefusalLength && refusalPattern.MatchString(trimmed) {
+		return refusalError(provider, "reply was a refusal")
+	}
+	return nil
+}
+
+// checkSummary treats a missing summary, one with no text at all, or one
+// whose overview is a refusal as ErrContentRefused
+func checkSummary(provider string, summary *HealthSummary) error {
+	if summary == nil {
+		return refusalError(provider, "no summary")
+	}
+	if strings.TrimSpace(summary.Summary) != "" {
+		return checkReply(provider, summary.Summary)
+	}
+	for _, section := range summary.Sections {
+		if strings.TrimSpace(section.Text) != "" || len(section.Items) > 0 {
+			return nil
+		}
+	}
+	return refusalError(provider, "empty summary")
+}
+
+// isRefusal reports whether err is a provider refusal. Refusals show the
+// provider is up, so they do not count against the circuit breaker.
+func isRefusal(err error) bool {
+	return errors.Is(err, ErrContentRefused)
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestCheckReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		refused bool
+	}{
+		{"answer", "Rest, drink water and see a doctor if the fever lasts.", false},
+		{"empty", "", true},
+		{"whitespace", " \n\t", true},
+		{"cannot help", "I cannot help with that request.", true},
+		{"sorry, can't assist", "I'm sorry, but I can't assist with that.", true},
+		{"unable to provide", "I am unable to provide medical advice on this.", true},
+		{"won't answer", "I won't answer that.", true},
+		{"as an AI", "As an AI language model, I cannot give a diagnosis.", true},
+		{"lower case", "i can't help with that", true},
+		{"refusal opening a long answer", "I can't diagnose you, but " + strings.Repeat("here is what the symptoms usually mean. ", 10), false},
+		{"can help", "I can help with that: take it with food.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReply("fake", tt.reply)
+			if got := errors.Is(err, ErrContentRefused); got != tt.refused {
+				t.Errorf("checkReply(%q) = %v, want refused %v", tt.reply, err, tt.refused)
+			}
+		})
+	}
+}
+
+func TestCheckSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary *HealthSummary
+		refused bool
+	}{
+		{"overview", &HealthSummary{Summary: "Two lab results this month."}, false},
+		{"sections only", &HealthSummary{Sections: []SummarySection{{Text: "Blood pressure is stable."}}}, false},
+		{"missing", nil, true},
+		{"no text", &HealthSummary{Sections: []SummarySection{{Text: "  "}}}, true},
+		{"refusing overview", &HealthSummary{Summary: "I'm unable to provide a summary of these records."}, true},
+	}
+	for _, tt := range tests {
+		err := checkSummary("fake", tt.summary)
+		if got := errors.Is(err, ErrContentRefused); got != tt.refused {
+			t.Errorf("%s: checkSummary = %v, want refused %v", tt.name, err, tt.refused)
+		}
+	}
+}
+
+func TestProviderRefusals(t *testing.T) {
+	refusing := []struct {
+		name     string
+		provider *fakeProvider
+	}{
+		{"refusing reply", &fakeProvider{reply: "I'm sorry, but I can't help with that.", summary: &HealthSummary{Summary: "I cannot provide that."}}},
+		{"empty reply", &fakeProvider{reply: "", summary: &HealthSummary{}}},
+		{"refusal reported by the provider", &fakeProvider{
+			chatErr:    refusalError("fake", "stopped by content filter"),
+			scanErr:    refusalError("fake", "stopped by content filter"),
+			summaryErr: refusalError("fake", "stopped by content filter"),
+		}},
+	}
+	for _, tt := range refusing {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			as := newTestAIService(t, db, &config.AIConfig{BreakerThreshold: 1, BreakerCooldown: 60})
+			as.provider = tt.provider
+			createUser(t, db, "user-1")
+			createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+			ctx := context.Background()
+
+			response, degraded, err := as.DoctorChat(ctx, "user-1", "conv-1", "hello")
+			if !errors.Is(err, ErrContentRefused) || response != "" || degraded {
+				t.Errorf("DoctorChat = %q, degraded %v, %v; want a refusal", response, degraded, err)
+			}
+			if turns := conversationTurns(t, db, "conv-1"); len(turns) != 0 {
+				t.Errorf("stored %d turns for a refused chat, want none", len(turns))
+			}
+
+			// Refusals are not answered by the rule-based summary
+			if summary, err := as.SummarizeHealth(ctx, "user-1", 30, nil, nil); !errors.Is(err, ErrContentRefused) {
+				t.Errorf("SummarizeHealth = %v, %v; want a refusal", summary, err)
+			}
+
+			if _, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true}); !errors.Is(err, ErrContentRefused) {
+				t.Errorf("ScanPrescription error = %v, want a refusal", err)
+			}
+
+			// The provider answered, so the breaker stays closed
+			if state := as.breaker.State(); state != BreakerClosed {
+				t.Errorf("breaker %s after refusals, want closed", state)
+			}
+		})
+	}
+}
+
+func TestProviderFailureIsNotARefusal(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{BreakerThreshold: 1, BreakerCooldown: 60})
+	as.provider = &fakeProvider{chatErr: errors.New("connection reset")}
+
+	response, degraded, err := as.DoctorChat(context.Background(), "user-1", "conv-1", "hello")
+	if err != nil || !degraded || response != ruleBasedChatReply {
+		t.Errorf("DoctorChat = %q, degraded %v, %v; want the degraded fallback", response, degraded, err)
+	}
+	if state := as.breaker.State(); state != BreakerOpen {
+		t.Errorf("breaker %s after a failure, want open", state)
+	}
+}
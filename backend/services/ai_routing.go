@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// providerRoute is a provider with the circuit breaker guarding it
+type providerRoute struct {
+	provider AIProvider
+	breaker  *CircuitBreaker
+}
+
+// call runs fn through the route's circuit breaker, failing fast with
+// ErrUnavailable while the breaker is open. Refusals count as successes.
+func (r *providerRoute) call(fn func() error) error {
+	if !r.breaker.Allow() {
+		return fmt.Errorf("%w: AI provider %s is failing", ErrUnavailable, r.provider.Name())
+	}
+	err := fn()
+	if isRefusal(err) {
+		r.breaker.Record(nil)
+	} else {
+		r.breaker.Record(err)
+	}
+	return err
+}
+
+type providerOverrideKey struct{}
+
+// WithProviderOverride routes the AI calls made with ctx to the named
+// provider instead of the configured one, for comparing models. The
+// handler layer sets it only for admin callers; AIService still checks the
+// name against AI_PROVIDER_OVERRIDES.
+func WithProviderOverride(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, providerOverrideKey{}, name)
+}
+
+// newProviderOverrides builds the providers admins may route requests to.
+// Each gets its own circuit breaker so an experiment failing cannot trip
+// the configured provider's.
+func newProviderOverrides(cfg *config.AIConfig) map[string]*providerRoute {
+	overrides := make(map[string]*providerRoute, len(cfg.ProviderOverrides))
+	for _, name := range cfg.ProviderOverrides {
+		provider, err := newNamedAIProvider(name, cfg)
+		if err != nil {
+			log.Printf("Ignoring AI provider override %q: %v", name, err)
+			continue
+		}
+		overrides[name] = &providerRoute{
+			provider: provider,
+			breaker:  NewCircuitBreaker(cfg.BreakerThreshold, time.Duration(cfg.BreakerCooldown)*time.Second),
+		}
+	}
+	return overrides
+}
+
+// HasProviderOverride reports whether requests may be routed to the named
+// provider
+func (as *AIService) HasProviderOverride(name string) bool {
+	_, ok := as.overrides[name]
+	return ok
+}
+
+// route returns the provider serving a request: the admin override carried
+// by ctx, or the configured provider
+func (as *AIService) route(ctx context.Context) (*providerRoute, error) {
+	name, ok := ctx.Value(providerOverrideKey{}).(string)
+	if !ok {
+		return &providerRoute{provider: as.provider, breaker: as.breaker}, nil
+	}
+	route, ok := as.overrides[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: AI provider %q is not available for overrides", ErrInvalidArgument, name)
+	}
+	log.Printf("Routing AI call to provider override %s", name)
+	return route, nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+func TestProviderOverrideRouting(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{BreakerThreshold: 1, BreakerCooldown: 60, ProviderOverrides: []string{"mock", "no-such-provider"}})
+	configured := &fakeProvider{reply: "configured reply"}
+	as.provider = configured
+	ctx := context.Background()
+
+	if !as.HasProviderOverride("mock") || as.HasProviderOverride("no-such-provider") || as.HasProviderOverride("fake") {
+		t.Fatal("overrides should be exactly the providers that could be built")
+	}
+
+	failing := &fakeProvider{chatErr: errors.New("experiment down")}
+	as.overrides["experiment"] = &providerRoute{provider: failing, breaker: NewCircuitBreaker(1, time.Minute)}
+
+	response, degraded, err := as.DoctorChat(WithProviderOverride(ctx, "mock"), "user-1", "conv-1", "hello")
+	if err != nil || degraded || configured.chats != 0 || response == "configured reply" {
+		t.Errorf("chat routed to mock = %q, degraded %v, %v; configured provider called %d times", response, degraded, err, configured.chats)
+	}
+
+	// A failing experiment opens only its own breaker
+	if _, degraded, _ := as.DoctorChat(WithProviderOverride(ctx, "experiment"), "user-1", "conv-1", "hello"); !degraded || failing.chats != 1 {
+		t.Errorf("failing experiment: degraded %v, %d calls", degraded, failing.chats)
+	}
+	if state := as.overrides["experiment"].breaker.State(); state != BreakerOpen {
+		t.Errorf("experiment breaker %s, want open", state)
+	}
+	if state := as.breaker.State(); state != BreakerClosed {
+		t.Errorf("configured provider's breaker %s after an experiment failed, want closed", state)
+	}
+	if response, _, err := as.DoctorChat(ctx, "user-1", "conv-1", "hello"); err != nil || response != "configured reply" {
+		t.Errorf("chat without an override = %q, %v", response, err)
+	}
+
+	// The service checks the name itself too
+	if _, err := as.route(WithProviderOverride(ctx, "openai")); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("route to an unlisted provider: error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/clarity/backend/models"
+)
+
+// Sandbox provider for development and demos. Outputs are deterministic
+// for a given input but vary between inputs, so different images scan as
+// different medications and summaries mention the user's own records.
+// Every output is marked with sandboxWatermark so it is never mistaken for
+// a real model response.
+
+const sandboxWatermark = "sandbox response"
+
+// sandboxMedication is one prescription the sandbox can "read"
+type sandboxMedication struct {
+	name        string
+	dosages     []string
+	frequencies []string
+	indication  string
+}
+
+var sandboxMedications = []sandboxMedication{
+	{"Amoxicillin", []string{"250mg", "500mg"}, []string{"Three times daily", "Every 8 hours"}, "Bacterial infection"},
+	{"Ibuprofen", []string{"200mg", "400mg"}, []string{"Every 6 hours as needed", "Twice daily with food"}, "Pain and inflammation"},
+	{"Metformin", []string{"500mg", "850mg"}, []string{"Twice daily with meals", "Once daily"}, "Type 2 diabetes"},
+	{"Lisinopril", []string{"5mg", "10mg"}, []string{"Once daily", "Every morning"}, "High blood pressure"},
+	{"Atorvastatin", []string{"10mg", "20mg"}, []string{"Once daily at bedtime", "Every evening"}, "High cholesterol"},
+	{"Cetirizine", []string{"10mg"}, []string{"Once daily", "At bedtime"}, "Allergies"},
+	{"Omeprazole", []string{"20mg", "40mg"}, []string{"Once daily before breakfast", "Twice daily"}, "Acid reflux"},
+	{"Paracetamol", []string{"500mg", "1g"}, []string{"Every 6 hours as needed", "Four times daily"}, "Fever and pain"},
+}
+
+var sandboxDurations = []string{"5 days", "7 days", "10 days", "14 days", "30 days", "Ongoing"}
+
+var sandboxRecommendations = []string{
+	"Keep taking medications as prescribed and note any side effects.",
+	"Stay hydrated and keep a regular sleep schedule.",
+	"Book a routine check-up to review your recent results.",
+	"Log symptoms as they happen so trends are easier to spot.",
+}
+
+// sandboxKeywords maps words the chat recognizes to a line of advice
+var sandboxKeywords = map[string]string{
+	"headache":   "For headaches, rest, fluids and a quiet, dark room often help.",
+	"fever":      "Keep an eye on your temperature and seek care if it stays above 39°C.",
+	"cough":      "A cough lasting more than three weeks is worth getting checked.",
+	"pain":       "Note where the pain is, how strong it is and what makes it better or worse.",
+	"sleep":      "A consistent bedtime and less screen time in the evening can improve sleep.",
+	"dizzy":      "Sit or lie down when dizzy, and stand up slowly.",
+	"nausea":     "Small sips of water and bland food can ease nausea.",
+	"rash":       "Avoid scratching, and note whether the rash spreads or changes.",
+	"medication": "Take medications exactly as prescribed and check with a pharmacist before combining them.",
+	"dose":       "If you miss a dose, check the leaflet; never take a double dose to catch up.",
+	"allergy":    "Keep a list of your allergies handy for every appointment.",
+	"tired":      "Persistent tiredness can have many causes; a blood test may help.",
+}
+
+type sandboxAIProvider struct{}
+
+func (sp *sandboxAIProvider) Name() string {
+	return "sandbox"
+}
+
+func (sp *sandboxAIProvider) ScanPrescription(ctx context.Context, imageData []byte) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	seed := sandboxSeed(imageData)
+	medication := sandboxMedications[pick(seed, 0, len(sandboxMedications))]
+	return map[string]string{
+		"medication": medication.name,
+		"dosage":     medication.dosages[pick(seed, 1, len(medication.dosages))],
+		"frequency":  medication.frequencies[pick(seed, 2, len(medication.frequencies))],
+		"duration":   sandboxDurations[pick(seed, 3, len(sandboxDurations))],
+		"indication": medication.indication,
+		"notes":      sandboxWatermark,
+	}, nil
+}
+
+// ScanPrescriptionText returns the sandbox extraction with label text that
+// would read as it, scored with confidences that vary by image
+func (sp *sandboxAIProvider) ScanPrescriptionText(ctx context.Context, imageData []byte) (map[string]string, *OCRText, error) {
+	extractedData, err := sp.ScanPrescription(ctx, imageData)
+	if err != nil {
+		return nil, nil, err
+	}
+	seed := sandboxSeed(imageData)
+	lines := []string{
+		"Rx " + extractedData["medication"] + " " + extractedData["dosage"],
+		extractedData["frequency"] + " for " + extractedData["duration"],
+		"For: " + extractedData["indication"],
+		sandboxWatermark,
+	}
+	text := ocrText(lines, func(i int) float64 {
+		return float64(75+seed[i%len(seed)]%25) / 100
+	})
+	return extractedData, text, nil
+}
+
+func (sp *sandboxAIProvider) SummarizeHealth(ctx context.Context, records []models.HealthRecord, days int, sections []string) (*HealthSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	titles := make([]string, 0, 3)
+	counts := make(map[string]int)
+	var medications []string
+	var seedInput strings.Builder
+	for _, record := range records {
+		counts[record.RecordType]++
+		seedInput.WriteString(record.Title)
+		if len(titles) < 3 && record.Title != "" {
+			titles = append(titles, fmt.Sprintf("%q", record.Title))
+		}
+		if record.RecordType == "prescription" {
+			medications = append(medications, record.Title)
+		}
+	}
+
+	summary := fmt.Sprintf("[%s] In the last %d days you logged %d records", sandboxWatermark, days, len(records))
+	if len(records) == 0 {
+		summary = fmt.Sprintf("[%s] No health records in the last %d days", sandboxWatermark, days)
+	} else if len(titles) > 0 {
+		summary += ", including " + joinList(titles)
+	}
+
+	types := make([]string, 0, len(counts))
+	for recordType := range counts {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+	findings := make([]string, 0, len(types))
+	for _, recordType := range types {
+		finding := fmt.Sprintf("%d %s record", counts[recordType], strings.ReplaceAll(recordType, "_", " "))
+		if counts[recordType] > 1 {
+			finding += "s"
+		}
+		findings = append(findings, finding)
+	}
+
+	seed := sandboxSeed([]byte(seedInput.String()))
+	parts := make([]SummarySection, len(sections))
+	for i, name := range sections {
+		parts[i] = SummarySection{Name: name}
+		switch name {
+		case SummarySectionSummary:
+			parts[i].Text = summary + "."
+		case SummarySectionFindings:
+			parts[i].Items = findings
+		case SummarySectionRecommendations:
+			parts[i].Text = sandboxRecommendations[pick(seed, 0, len(sandboxRecommendations))]
+		case SummarySectionMedications:
+			parts[i].Items = medications
+		case SummarySectionTrends:
+			if len(types) > 0 {
+				parts[i].Items = []string{fmt.Sprintf("[%s] Most of your records are %s", sandboxWatermark, strings.ReplaceAll(busiestType(counts, types), "_", " "))}
+			}
+		case SummarySectionRisks:
+			parts[i].Items = []string{fmt.Sprintf("[%s] No risks flagged", sandboxWatermark)}
+		}
+	}
+	return newHealthSummary(parts), nil
+}
+
+// busiestType returns the record type with the most records, breaking ties
+// by name
+func busiestType(counts map[string]int, types []string) string {
+	busiest := types[0]
+	for _, recordType := range types[1:] {
+		if counts[recordType] > counts[busiest] {
+			busiest = recordType
+		}
+	}
+	return busiest
+}
+
+func (sp *sandboxAIProvider) DoctorChat(ctx context.Context, message string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	lower := strings.ToLower(message)
+	var recognized []string
+	for keyword := range sandboxKeywords {
+		if strings.Contains(lower, keyword) {
+			recognized = append(recognized, keyword)
+		}
+	}
+	// Echo keywords in the order the user wrote them
+	sort.Slice(recognized, func(i, j int) bool {
+		return strings.Index(lower, recognized[i]) < strings.Index(lower, recognized[j])
+	})
+
+	if len(recognized) == 0 {
+		return fmt.Sprintf("[%s] Thanks for your message. Could you tell me more about your symptoms and how long you have had them?", sandboxWatermark), nil
+	}
+	advice := make([]string, len(recognized))
+	for i, keyword := range recognized {
+		advice[i] = sandboxKeywords[keyword]
+	}
+	return fmt.Sprintf("[%s] You mentioned %s. %s", sandboxWatermark, joinList(recognized), strings.Join(advice, " ")), nil
+}
+
+// ChatWithTools looks up the user's records when the message asks about
+// them, then replies as DoctorChat does with what the tool returned
+func (sp *sandboxAIProvider) ChatWithTools(ctx context.Context, message string, tools []ToolSpec, results []ToolResult) (*ChatTurn, error) {
+	lower := strings.ToLower(message)
+	asksAboutRecords := strings.Contains(lower, "record") || strings.Contains(lower, "history")
+	if asksAboutRecords && len(results) == 0 {
+		for _, tool := range tools {
+			if tool.Name == ToolListRecentRecords {
+				return &ChatTurn{ToolCalls: []ToolCall{{ID: "sandbox-1", Name: ToolListRecentRecords}}}, nil
+			}
+		}
+	}
+
+	reply, err := sp.DoctorChat(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if !result.IsError {
+			reply += "\nHere is what I found in your records:\n" + result.Content
+		}
+	}
+	return &ChatTurn{Reply: reply}, nil
+}
+
+// sandboxTranscriber "transcribes" audio into a phrase chosen from its
+// hash, using words the sandbox chat recognizes
+type sandboxTranscriber struct{}
+
+var sandboxTranscripts = []string{
+	"I have had a headache since yesterday",
+	"I think I have a fever and a cough",
+	"I can't sleep and feel tired all day",
+	"I missed a dose of my medication this morning",
+	"I feel dizzy when I stand up",
+}
+
+func (st *sandboxTranscriber) Transcribe(ctx context.Context, audio []byte, format string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	seed := sandboxSeed(audio)
+	return sandboxTranscripts[pick(seed, 0, len(sandboxTranscripts))], nil
+}
+
+// sandboxSeed hashes an input so choices are stable for the same input
+func sandboxSeed(input []byte) [sha256.Size]byte {
+	return sha256.Sum256(input)
+}
+
+// pick returns a choice in [0, n) from the slot'th part of seed
+func pick(seed [sha256.Size]byte, slot, n int) int {
+	return int(binary.BigEndian.Uint32(seed[slot*4:]) % uint32(n))
+}
+
+// joinList joins items as "a, b and c"
+func joinList(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
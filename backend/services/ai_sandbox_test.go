@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestSandboxIsSelectable(t *testing.T) {
+	if provider := NewAIProvider(&config.AIConfig{Provider: "sandbox"}); provider.Name() != "sandbox" {
+		t.Errorf("AI_PROVIDER=sandbox selected %s", provider.Name())
+	}
+	if _, ok := NewTranscriber(&config.AIConfig{STTProvider: "sandbox"}).(*sandboxTranscriber); !ok {
+		t.Error("STT_PROVIDER=sandbox did not select the sandbox transcriber")
+	}
+}
+
+func TestSandboxScanIsStablePerImageAndVariedAcrossImages(t *testing.T) {
+	sp := &sandboxAIProvider{}
+	ctx := context.Background()
+
+	medications := make(map[string]bool)
+	for i := 0; i < 64; i++ {
+		image := []byte(fmt.Sprintf("image-%d", i))
+		first, err := sp.ScanPrescription(ctx, image)
+		if err != nil {
+			t.Fatalf("ScanPrescription: %v", err)
+		}
+		again, _ := sp.ScanPrescription(ctx, image)
+		for key, value := range first {
+			if again[key] != value {
+				t.Fatalf("image %d: %s = %q then %q", i, key, value, again[key])
+			}
+		}
+		for _, key := range []string{"medication", "dosage", "frequency", "duration", "indication"} {
+			if first[key] == "" {
+				t.Errorf("image %d: no %s in %v", i, key, first)
+			}
+		}
+		if first["notes"] != sandboxWatermark {
+			t.Errorf("image %d: scan not watermarked: %v", i, first)
+		}
+		medications[first["medication"]] = true
+	}
+	if len(medications) < len(sandboxMedications)/2 {
+		t.Errorf("64 images scanned as only %d medications", len(medications))
+	}
+
+	data, text, err := sp.ScanPrescriptionText(ctx, []byte("image-1"))
+	if err != nil {
+		t.Fatalf("ScanPrescriptionText: %v", err)
+	}
+	if text == nil || !strings.Contains(text.Text, data["medication"]) || !strings.Contains(text.Text, sandboxWatermark) {
+		t.Errorf("label text %+v does not read as %v", text, data)
+	}
+}
+
+func TestSandboxSummaryReferencesRecords(t *testing.T) {
+	sp := &sandboxAIProvider{}
+	records := []models.HealthRecord{
+		{RecordType: "lab_result", Title: "Cholesterol panel"},
+		{RecordType: "prescription", Title: "Atorvastatin"},
+		{RecordType: "lab_result", Title: "HbA1c"},
+	}
+	sections := []string{SummarySectionSummary, SummarySectionFindings, SummarySectionMedications, SummarySectionRecommendations}
+	summary, err := sp.SummarizeHealth(context.Background(), records, 30, sections)
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	for _, want := range []string{sandboxWatermark, "30 days", "3 records", `"Cholesterol panel"`, `"Atorvastatin"`} {
+		if !strings.Contains(summary.Summary, want) {
+			t.Errorf("summary %q does not mention %s", summary.Summary, want)
+		}
+	}
+	if got := strings.Join(summary.KeyFindings, "; "); got != "2 lab result records; 1 prescription record" {
+		t.Errorf("findings = %q", got)
+	}
+	if got := summary.Section(SummarySectionMedications).Items; len(got) != 1 || got[0] != "Atorvastatin" {
+		t.Errorf("medications = %v", got)
+	}
+	if summary.Recommendations == "" {
+		t.Error("no recommendations")
+	}
+
+	empty, err := sp.SummarizeHealth(context.Background(), nil, 7, []string{SummarySectionSummary})
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if !strings.Contains(empty.Summary, "No health records in the last 7 days") {
+		t.Errorf("summary of no records = %q", empty.Summary)
+	}
+}
+
+func TestSandboxChatEchoesKeywords(t *testing.T) {
+	sp := &sandboxAIProvider{}
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"I have a Fever and a headache", []string{"You mentioned fever and headache.", sandboxKeywords["fever"], sandboxKeywords["headache"]}},
+		{"can't sleep", []string{"You mentioned sleep.", sandboxKeywords["sleep"]}},
+		{"hello there", []string{"Could you tell me more about your symptoms"}},
+	}
+	for _, tt := range tests {
+		reply, err := sp.DoctorChat(context.Background(), tt.message)
+		if err != nil {
+			t.Fatalf("DoctorChat: %v", err)
+		}
+		if !strings.HasPrefix(reply, "["+sandboxWatermark+"]") {
+			t.Errorf("DoctorChat(%q) reply not watermarked: %q", tt.message, reply)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(reply, want) {
+				t.Errorf("DoctorChat(%q) = %q, missing %q", tt.message, reply, want)
+			}
+		}
+	}
+
+	// Every sandbox transcript is something the sandbox chat recognizes
+	st := &sandboxTranscriber{}
+	for i := 0; i < 20; i++ {
+		transcript, err := st.Transcribe(context.Background(), []byte{byte(i)}, "wav")
+		if err != nil {
+			t.Fatalf("Transcribe: %v", err)
+		}
+		if reply, _ := sp.DoctorChat(context.Background(), transcript); !strings.Contains(reply, "You mentioned") {
+			t.Errorf("transcript %q not recognized by the chat: %q", transcript, reply)
+		}
+	}
+}
+
+func TestSandboxCallsRecordTool(t *testing.T) {
+	sp := &sandboxAIProvider{}
+	tools := []ToolSpec{{Name: ToolListRecentRecords}}
+	ctx := context.Background()
+
+	turn, err := sp.ChatWithTools(ctx, "What is in my records?", tools, nil)
+	if err != nil {
+		t.Fatalf("ChatWithTools: %v", err)
+	}
+	if len(turn.ToolCalls) != 1 || turn.ToolCalls[0].Name != ToolListRecentRecords {
+		t.Fatalf("first turn = %+v, want a call to %s", turn, ToolListRecentRecords)
+	}
+
+	results := []ToolResult{{CallID: turn.ToolCalls[0].ID, Content: "Blood panel (lab_result)"}}
+	turn, err = sp.ChatWithTools(ctx, "What is in my records?", tools, results)
+	if err != nil {
+		t.Fatalf("ChatWithTools: %v", err)
+	}
+	if len(turn.ToolCalls) != 0 || !strings.Contains(turn.Reply, "Blood panel (lab_result)") {
+		t.Errorf("second turn = %+v, want a reply with the tool result", turn)
+	}
+
+	if turn, _ := sp.ChatWithTools(ctx, "What is in my records?", nil, nil); len(turn.ToolCalls) != 0 {
+		t.Error("called a tool it was not offered")
+	}
+}
+
+func TestSandboxRunsTheFullPipeline(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	as := newTestAIService(t, db, &config.AIConfig{Provider: "sandbox"})
+	if as.provider.Name() != "sandbox" {
+		t.Fatalf("AIService uses %s", as.provider.Name())
+	}
+	ctx := context.Background()
+
+	result, err := as.ScanPrescription(ctx, "user-1", encodePNG(t, labelImage(640, 480)), ScanOptions{})
+	if err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if result.ExtractedData["notes"] != sandboxWatermark {
+		t.Errorf("scan result %v not from the sandbox", result.ExtractedData)
+	}
+
+	summary, err := as.SummarizeHealth(ctx, "user-1", 30, nil, nil)
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if !strings.Contains(summary.Summary, "Record rec-1") {
+		t.Errorf("summary %q does not mention the user's record", summary.Summary)
+	}
+
+	reply, degraded, err := as.DoctorChat(ctx, "user-1", "conv-1", "I feel dizzy")
+	if err != nil || degraded {
+		t.Fatalf("DoctorChat = degraded %v, err %v", degraded, err)
+	}
+	if !strings.Contains(reply, sandboxKeywords["dizzy"]) {
+		t.Errorf("chat reply = %q", reply)
+	}
+}
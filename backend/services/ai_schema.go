@@ -0,0 +1,42 @@
+package services
+
+// jsonSchema is the JSON Schema a model reply must follow, for APIs with
+// structured output. Name identifies it where the API asks for one.
+type jsonSchema struct {
+	name   string
+	schema map[string]interface{}
+}
+
+// scanFields are the fields scanPrompt asks for, in its order
+var scanFields = []string{"medication", "dosage", "frequency", "duration", "indication", "warnings", "refills"}
+
+// scanSchema is the reply scanPrompt asks for. Every field is required,
+// as strict structured output demands; fields left empty are dropped by
+// parseScanResponse.
+var scanSchema = &jsonSchema{name: "prescription", schema: objectSchema(scanFields, func(string) map[string]interface{} {
+	return map[string]interface{}{"type": "string"}
+})}
+
+// summarySchema is the reply SummaryPrompt asks for with sections
+func summarySchema(sections []string) *jsonSchema {
+	return &jsonSchema{name: "health_summary", schema: objectSchema(sections, func(name string) map[string]interface{} {
+		if summarySectionFormats[name].list {
+			return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+		}
+		return map[string]interface{}{"type": "string"}
+	})}
+}
+
+// objectSchema is an object with exactly the given keys, each required
+func objectSchema(keys []string, property func(key string) map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		properties[key] = property(key)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             keys,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+// object reads key of a decoded JSON object as an object
+func object(value interface{}, key string) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	child, _ := m[key].(map[string]interface{})
+	return child
+}
+
+// stringList lists a decoded JSON array of strings
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+	list := make([]string, len(items))
+	for i, item := range items {
+		list[i], _ = item.(string)
+	}
+	return list
+}
+
+// hasKey reports whether key appears anywhere in a decoded JSON value
+func hasKey(value interface{}, key string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if k == key || hasKey(child, key) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if hasKey(child, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var schemaTestSections = []string{SummarySectionSummary, SummarySectionFindings}
+
+// schemaTestSummary is a summary reply with schemaTestSections
+const schemaTestSummary = `{\"summary\":\"Stable.\",\"findings\":[\"BP 120/80\"]}`
+
+func TestOpenAISendsReplySchemas(t *testing.T) {
+	cfg := config.AIConfig{Provider: "openai", StructuredOutput: true}
+
+	p, sent := hostedTestProvider(t, cfg, hostedReplies["openai"](scanReply))
+	if _, err := p.ScanPrescription(context.Background(), testPNG); err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	format := object(sent.body, "response_format")
+	schema := object(format, "json_schema")
+	if format["type"] != "json_schema" || schema["name"] != "prescription" || schema["strict"] != true {
+		t.Fatalf("scan response_format = %v, want the strict prescription schema", format)
+	}
+	body := object(schema, "schema")
+	if got := stringList(body["required"]); !reflect.DeepEqual(got, scanFields) {
+		t.Errorf("scan schema requires %v, want %v", got, scanFields)
+	}
+	if body["additionalProperties"] != false {
+		t.Errorf("scan schema allows other keys: %v", body)
+	}
+
+	p, sent = hostedTestProvider(t, cfg, hostedReplies["openai"](schemaTestSummary))
+	summary, err := p.SummarizeHealth(context.Background(), []models.HealthRecord{{Title: "BP"}}, 30, schemaTestSections)
+	if err != nil || summary.Summary != "Stable." {
+		t.Fatalf("SummarizeHealth = %+v, %v", summary, err)
+	}
+	schema = object(object(sent.body, "response_format"), "json_schema")
+	properties := object(object(schema, "schema"), "properties")
+	if schema["name"] != "health_summary" || object(properties, "summary")["type"] != "string" || object(properties, "findings")["type"] != "array" {
+		t.Errorf("summary schema = %v, want a string summary and an array of findings", schema)
+	}
+	if got := stringList(object(schema, "schema")["required"]); !reflect.DeepEqual(got, schemaTestSections) {
+		t.Errorf("summary schema requires %v, want the requested %v", got, schemaTestSections)
+	}
+
+	// Chat replies are free text
+	p, sent = hostedTestProvider(t, cfg, hostedReplies["openai"]("Rest."))
+	if _, err := p.DoctorChat(context.Background(), "hello"); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if _, ok := sent.body["response_format"]; ok {
+		t.Errorf("chat request has a response_format: %s", sent.raw)
+	}
+}
+
+func TestGeminiSendsReplySchemas(t *testing.T) {
+	p, sent := hostedTestProvider(t, config.AIConfig{Provider: "gemini", StructuredOutput: true}, hostedReplies["gemini"](schemaTestSummary))
+	if _, err := p.SummarizeHealth(context.Background(), nil, 30, schemaTestSections); err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+
+	generation := object(sent.body, "generationConfig")
+	if generation["responseMimeType"] != "application/json" {
+		t.Errorf("responseMimeType = %v, want JSON", generation["responseMimeType"])
+	}
+	// Gemini takes the OpenAPI subset: upper-case types and no
+	// additionalProperties
+	schema := object(generation, "responseSchema")
+	findings := object(object(schema, "properties"), "findings")
+	if schema["type"] != "OBJECT" || findings["type"] != "ARRAY" || object(findings, "items")["type"] != "STRING" {
+		t.Errorf("responseSchema = %v, want upper-case types", schema)
+	}
+	if hasKey(schema, "additionalProperties") {
+		t.Errorf("responseSchema has additionalProperties: %v", schema)
+	}
+	if got := stringList(schema["required"]); !reflect.DeepEqual(got, schemaTestSections) {
+		t.Errorf("responseSchema requires %v, want %v", got, schemaTestSections)
+	}
+}
+
+func TestReplySchemaFallback(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.AIConfig
+		check func(t *testing.T, sent *providerRequest)
+	}{
+		{"openai with structured output off", config.AIConfig{Provider: "openai"}, func(t *testing.T, sent *providerRequest) {
+			if format := object(sent.body, "response_format"); format["type"] != "json_object" || format["json_schema"] != nil {
+				t.Errorf("response_format = %v, want plain JSON mode", format)
+			}
+		}},
+		{"gemini with structured output off", config.AIConfig{Provider: "gemini"}, func(t *testing.T, sent *providerRequest) {
+			generation := object(sent.body, "generationConfig")
+			if generation["responseMimeType"] != "application/json" || generation["responseSchema"] != nil {
+				t.Errorf("generationConfig = %v, want JSON without a schema", generation)
+			}
+		}},
+		// Converse has no schema support, whatever the setting
+		{"bedrock", config.AIConfig{Provider: "bedrock", StructuredOutput: true}, func(t *testing.T, sent *providerRequest) {
+			if hasKey(sent.body, "required") || hasKey(sent.body, "properties") {
+				t.Errorf("bedrock request carries a schema: %s", sent.raw)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, sent := hostedTestProvider(t, tt.cfg, hostedReplies[tt.cfg.Provider](scanReply))
+			extractedData, err := p.ScanPrescription(context.Background(), testPNG)
+			if err != nil || extractedData["medication"] != "Amoxicillin" {
+				t.Fatalf("ScanPrescription = %v, %v", extractedData, err)
+			}
+			tt.check(t, sent)
+			// The prompt still describes the JSON it wants
+			if !strings.Contains(sent.raw, "Return ONLY the JSON object") {
+				t.Errorf("scan prompt does not ask for JSON: %s", sent.raw)
+			}
+		})
+	}
+}
@@ -2,17 +2,30 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	vision "cloud.google.com/go/vision/v2"
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/events"
 	"github.com/clarity/backend/models"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ExtractionVersion identifies the scan extraction pipeline: the provider
+// prompt, the medication normalizer, and the parsers applied to its output.
+// Bump it whenever one of them improves so a reprocess job can find records
+// extracted by an older version.
+const ExtractionVersion = 1
+
 type PrescriptionData struct {
 	Medication string `json:"medication"`
 	Dosage     string `json:"dosage"`
@@ -24,91 +37,512 @@ type PrescriptionData struct {
 }
 
 type AIService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	config      *config.AIConfig
+	provider    AIProvider
+	transcriber Transcriber
+	enhancer    ImageEnhancer
+	medications *ReferenceDataset[*MedicationNormalizer] // optional
+	recordTypes *RecordTypes                             // optional; nil accepts only built-in types
+	records     *HealthRecordsService                    // saves the record of each scan
+	cache       Cache                                    // optional
+	cacheTTL    time.Duration
+	flags       *FeatureFlags // optional
+	breaker     *CircuitBreaker
+	overrides   map[string]*providerRoute // providers admins may route requests to
+	events      *events.Bus
+}
+
+// NewAIService returns the AI service. Scans are saved as records through
+// records; nil saves them with the default records settings, without a
+// duplicate window.
+func NewAIService(db *gorm.DB, cfg *config.AIConfig, medications *ReferenceDataset[*MedicationNormalizer], recordTypes *RecordTypes, records *HealthRecordsService, cache Cache, cacheTTL time.Duration, flags *FeatureFlags, bus *events.Bus) *AIService {
+	if records == nil {
+		records = NewHealthRecordsService(db, &config.RecordsConfig{}, recordTypes, bus)
+	}
+	return &AIService{
+		db:          db,
+		config:      cfg,
+		provider:    NewAIProvider(cfg),
+		transcriber: NewTranscriber(cfg),
+		enhancer:    NewImageEnhancer(cfg),
+		medications: medications,
+		recordTypes: recordTypes,
+		records:     records,
+		cache:       cache,
+		cacheTTL:    cacheTTL,
+		flags:       flags,
+		breaker:     NewCircuitBreaker(cfg.BreakerThreshold, time.Duration(cfg.BreakerCooldown)*time.Second),
+		overrides:   newProviderOverrides(cfg),
+		events:      bus,
+	}
+}
+
+// ProviderState returns the state of the circuit breaker guarding the
+// configured provider
+func (as *AIService) ProviderState() string {
+	return as.breaker.State()
 }
 
-func NewAIService(db *gorm.DB) *AIService {
-	return &AIService{db: db}
+// StartupSelfTest runs the provider self-test when enabled in config. A
+// failure is only logged unless AI_SELF_TEST_REQUIRED is set, in which case
+// it is returned so startup can abort.
+func (as *AIService) StartupSelfTest(ctx context.Context) error {
+	if !as.config.SelfTest {
+		return nil
+	}
+
+	err := ProviderSelfTest(ctx, as.provider, time.Duration(as.config.SelfTestTimeout)*time.Second)
+	if err == nil {
+		log.Printf("AI provider %s self-test passed", as.provider.Name())
+		return nil
+	}
+	if as.config.SelfTestRequired {
+		return err
+	}
+	log.Printf("Warning: %v", err)
+	return nil
 }
 
-// ScanPrescription extracts data from prescription image
-func (as *AIService) ScanPrescription(userID string, imageData []byte) (map[string]string, error) {
-	// Placeholder for AI prescription scanning
-	// In production, integrate with OpenAI Vision API or similar
+// ScanOptions adjusts a prescription scan
+type ScanOptions struct {
+	Force bool // skip the image quality pre-check
+	// IncludeRawText also returns the text the provider read, which is
+	// left out by default to keep responses small
+	IncludeRawText bool
+}
+
+// ScanResult is a completed prescription scan
+type ScanResult struct {
+	ExtractedData map[string]string
+	ScanID        string
+	// RecordID is the prescription record saved from the scan, or the
+	// matching record the user saved within the duplicate window
+	RecordID string
+	// RawText is only set with IncludeRawText, and stays nil when the
+	// provider cannot report the text it read
+	RawText *OCRText
+}
+
+// ScanPrescription extracts data from prescription image. Unless
+// opts.Force is set, images that fail the local quality pre-check are
+// rejected with an *ImageQualityError before any provider call is made.
+// The image is kept under the returned scan ID so the scan can be
+// re-extracted later, and the extracted fields are saved as a prescription
+// record linked to the scan.
+func (as *AIService) ScanPrescription(ctx context.Context, userID string, imageData []byte, opts ScanOptions) (*ScanResult, error) {
+	if err := as.flags.require(FeatureScan); err != nil {
+		return nil, err
+	}
+	if !opts.Force {
+		if _, err := checkImageQuality(imageData, as.config); err != nil {
+			return nil, err
+		}
+	}
 
 	log.Printf("Scanning prescription for user %s", userID)
 
-	// Mock extracted data
-	extractedData := map[string]string{
-		"medication": "Aspirin",
-		"dosage":     "500mg",
-		"frequency":  "Twice daily",
-		"duration":   "7 days",
-		"indication": "Headache/Pain relief",
+	extracted, err := as.extract(ctx, imageData, opts.IncludeRawText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan prescription: %w", err)
+	}
+
+	scan := models.ScanInput{
+		ID:                uuid.New().String(),
+		UserID:            userID,
+		Image:             imageData,
+		ExtractionVersion: ExtractionVersion,
+		Provider:          extracted.provider,
+		CreatedAt:         time.Now(),
+	}
+	metadata := make(map[string]string, len(extracted.data)+1)
+	for key, value := range extracted.data {
+		metadata[key] = value
+	}
+	metadata["scan_id"] = scan.ID
+	title := firstNonEmpty(strings.TrimSpace(extracted.data["medication"]), "Prescription")
+	description := strings.TrimSpace(extracted.data["dosage"] + " " + extracted.data["frequency"])
+	record, err := as.records.newRecord(userID, "prescription", title, description, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save scanned prescription: %w", err)
+	}
+
+	var existing *models.HealthRecord
+	err = as.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&scan).Error; err != nil {
+			return fmt.Errorf("failed to store scan: %w", err)
+		}
+		duplicate, err := as.records.findDuplicate(tx, record)
+		if err != nil || duplicate != nil {
+			existing = duplicate
+			return err
+		}
+		return insertRecord(tx, record, metadata)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	as.events.Publish(ctx, events.ScanCompleted{UserID: userID, ScanID: scan.ID})
+	if existing != nil {
+		record = existing
+	} else {
+		as.events.Publish(ctx, events.RecordCreated{UserID: userID, RecordID: record.ID, RecordType: record.RecordType})
+	}
+	return &ScanResult{ExtractedData: extracted.data, ScanID: scan.ID, RecordID: record.ID, RawText: extracted.text}, nil
+}
+
+// extraction is the outcome of the extraction pipeline
+type extraction struct {
+	data     map[string]string
+	text     *OCRText // only when asked for and the provider reads text
+	provider string   // name of the provider that read the image
+}
+
+// extract runs the extraction pipeline on a prescription image, enhanced
+// for recognition if configured. With withText set, the raw text is
+// returned too if the provider can report it.
+func (as *AIService) extract(ctx context.Context, imageData []byte, withText bool) (*extraction, error) {
+	route, err := as.route(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := route.provider.(TextReadingProvider); !ok {
+		withText = false
+	}
+	extractedData, text, err := as.scanWithCache(ctx, route, as.enhance(imageData), withText)
+	if err != nil {
+		return nil, err
+	}
+
+	if medications := as.medications.Get(); medications != nil {
+		applyMedicationMatch(extractedData, medications.Normalize(extractedData["medication"]))
 	}
+	return &extraction{data: extractedData, text: text, provider: route.provider.Name()}, nil
+}
+
+// enhance returns the image to send for recognition. An image the
+// enhancer fails on is scanned as taken.
+func (as *AIService) enhance(imageData []byte) []byte {
+	enhanced, err := as.enhancer.Enhance(imageData)
+	if err != nil {
+		log.Printf("Warning: %s image enhancement failed, scanning the image as taken: %v", as.enhancer.Name(), err)
+		return imageData
+	}
+	return enhanced
+}
+
+// scanWithCache returns the provider's extraction for an image, reusing a
+// cached result for byte-identical images. The cache holds only
+// extractions, so a scan that wants the raw text always reaches the
+// provider. Cache failures fall through to the provider.
+func (as *AIService) scanWithCache(ctx context.Context, route *providerRoute, imageData []byte, withText bool) (map[string]string, *OCRText, error) {
+	if as.cache == nil || as.cacheTTL <= 0 {
+		return as.scan(ctx, route, imageData, withText)
+	}
+
+	sum := sha256.Sum256(imageData)
+	key := fmt.Sprintf("scan:%s:v%d:%s", route.provider.Name(), ExtractionVersion, hex.EncodeToString(sum[:]))
+
+	if !withText {
+		cached, ok, err := as.cache.Get(ctx, key)
+		if err != nil {
+			log.Printf("Cache read failed: %v", err)
+		}
+		if ok {
+			var extractedData map[string]string
+			if err := json.Unmarshal([]byte(cached), &extractedData); err == nil {
+				return extractedData, nil, nil
+			}
+		}
+	}
+
+	extractedData, text, err := as.scan(ctx, route, imageData, withText)
+	if err != nil {
+		return nil, nil, err
+	}
+	if encoded, err := json.Marshal(extractedData); err == nil {
+		if err := as.cache.Set(ctx, key, string(encoded), as.cacheTTL); err != nil {
+			log.Printf("Cache write failed: %v", err)
+		}
+	}
+	return extractedData, text, nil
+}
+
+// scan calls the provider through the circuit breaker. Scans have no
+// fallback: a guessed prescription is worse than none. An empty extraction
+// is reported as a refusal. withText asks a TextReadingProvider for the
+// raw text as well.
+func (as *AIService) scan(ctx context.Context, route *providerRoute, imageData []byte, withText bool) (map[string]string, *OCRText, error) {
+	var extractedData map[string]string
+	var text *OCRText
+	err := route.call(func() error {
+		var err error
+		if withText {
+			extractedData, text, err = route.provider.(TextReadingProvider).ScanPrescriptionText(ctx, imageData)
+		} else {
+			extractedData, err = route.provider.ScanPrescription(ctx, imageData)
+		}
+		if err == nil && len(extractedData) == 0 {
+			err = refusalError(route.provider.Name(), "empty extraction")
+		}
+		return err
+	})
+	return extractedData, text, err
+}
 
-	return extractedData, nil
+// applyMedicationMatch records the canonical medication name alongside the
+// scanned one and flags names that need a person to confirm them
+func applyMedicationMatch(extractedData map[string]string, match MedicationMatch) {
+	extractedData["medication_canonical"] = match.Canonical
+	extractedData["medication_match"] = match.Kind
+	if match.NeedsReview() {
+		extractedData["needs_review"] = "true"
+	}
 }
 
-// SummarizeHealth generates a health summary
-func (as *AIService) SummarizeHealth(userID string, days int) (string, []string, string, error) {
+// SummarizeHealth generates a health summary with the requested sections,
+// or DefaultSummarySections if none are given. Unknown sections are
+// rejected. Records in excludeIDs, such as an erroneous entry, are left
+// out before anything reaches the provider; they must belong to the user.
+// At most MaxSummaryRecords of the most recent records in the window are
+// summarized, and the summary says when older ones were left out.
+// When the provider fails or its breaker is open, a rule-based summary
+// marked Degraded is returned.
+func (as *AIService) SummarizeHealth(ctx context.Context, userID string, days int, sections, excludeIDs []string) (*HealthSummary, error) {
+	if err := as.flags.require(FeatureSummaries); err != nil {
+		return nil, err
+	}
+	sections, err := normalizeSummarySections(sections)
+	if err != nil {
+		return nil, err
+	}
+	excludeIDs, err = as.checkSummaryExclusions(ctx, userID, excludeIDs)
+	if err != nil {
+		return nil, err
+	}
+	route, err := as.route(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Fetch user's recent health records
 	var records []models.HealthRecord
 	startDate := time.Now().AddDate(0, 0, -days)
 
-	if err := as.db.Where("user_id = ? AND created_at > ?", userID, startDate).
-		Find(&records).Error; err != nil {
-		return "", nil, "", fmt.Errorf("failed to fetch records: %w", err)
+	query := as.db.WithContext(ctx).Scopes(scopeOwner(userID)).Where("created_at > ?", startDate)
+	if len(excludeIDs) > 0 {
+		query = query.Where("id NOT IN ?", excludeIDs)
+	}
+	// One record past the cap tells whether any were left out
+	limit := as.config.MaxSummaryRecords
+	if limit > 0 {
+		query = query.Order("created_at DESC").Limit(limit + 1)
+	}
+	if err := query.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch records: %w", err)
+	}
+	truncated := limit > 0 && len(records) > limit
+	if truncated {
+		records = records[:limit]
 	}
 
 	log.Printf("Summarizing %d health records for user %s", len(records), userID)
 
-	// Mock summarization (in production, use AI model)
-	summary := fmt.Sprintf("Health Summary for last %d days: %d records found.", days, len(records))
+	var summary *HealthSummary
+	err = route.call(func() error {
+		var err error
+		summary, err = route.provider.SummarizeHealth(ctx, records, days, sections)
+		if err != nil {
+			return err
+		}
+		return checkSummary(route.provider.Name(), summary)
+	})
+	if isRefusal(err) {
+		log.Printf("Summary refused: %v", err)
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Falling back to rule-based summary: %v", err)
+		summary = ruleBasedSummary(records, days, sections)
+	}
+
+	capSummary(summary, as.config.MaxSummaryFindings, as.config.MaxSummaryRecommendations)
+	summary.RecordsTruncated = truncated
+	summary.RecordCount = len(records)
+	return summary, nil
+}
 
-	keyFindings := []string{
-		"Overall health status: Good",
-		"Recent medications: None critical",
-		"Recommended actions: Regular check-up",
+// maxSummaryExclusions bounds how many records one summary request can
+// leave out
+const maxSummaryExclusions = 100
+
+// checkSummaryExclusions dedupes the record IDs to leave out of a summary
+// and checks the user owns every one. Records of other users fail with
+// ErrNotFound, like records that do not exist.
+func (as *AIService) checkSummaryExclusions(ctx context.Context, userID string, excludeIDs []string) ([]string, error) {
+	if len(excludeIDs) == 0 {
+		return nil, nil
+	}
+	excludeIDs = uniqueStrings(excludeIDs)
+	if len(excludeIDs) > maxSummaryExclusions {
+		return nil, fmt.Errorf("%w: at most %d records can be excluded", ErrInvalidArgument, maxSummaryExclusions)
 	}
 
-	recommendations := "Stay hydrated, maintain regular exercise, and schedule a check-up next month."
+	var owned int64
+	if err := as.db.WithContext(ctx).Model(&models.HealthRecord{}).Scopes(scopeOwner(userID)).
+		Where("id IN ?", excludeIDs).Count(&owned).Error; err != nil {
+		return nil, fmt.Errorf("failed to check excluded records: %w", err)
+	}
+	if int(owned) != len(excludeIDs) {
+		return nil, fmt.Errorf("%w: excluded record", ErrNotFound)
+	}
+	return excludeIDs, nil
+}
 
-	return summary, keyFindings, recommendations, nil
+// DoctorChat handles conversation with AI doctor. When the provider fails
+// or its breaker is open, the user gets a rule-based holding reply and
+// degraded is set. A refusal is returned as ErrContentRefused and the turn
+// is not stored. With ChatSummaryContext set, the conversation's stored
+// summary is sent along with the message.
+func (as *AIService) DoctorChat(ctx context.Context, userID, conversationID, message string) (response string, degraded bool, err error) {
+	return as.StreamDoctorChat(ctx, userID, conversationID, message, nil)
 }
 
-// DoctorChat handles conversation with AI doctor
-func (as *AIService) DoctorChat(userID, conversationID, message string) (string, error) {
-	// Placeholder for AI-powered doctor chat
-	// In production, integrate with LLM API
+// StreamDoctorChat is DoctorChat passing the reply to onChunk in pieces as
+// the provider generates it, when the provider can stream and the chat
+// does not use tools. The returned response is the whole reply and can
+// differ from the pieces: a reply refused or failed partway is replaced by
+// the refusal or the fallback reply. A nil onChunk streams nothing.
+func (as *AIService) StreamDoctorChat(ctx context.Context, userID, conversationID, message string, onChunk func(chunk string)) (response string, degraded bool, err error) {
+	if err := as.flags.require(FeatureChat); err != nil {
+		return "", false, err
+	}
+
+	route, err := as.route(ctx)
+	if err != nil {
+		return "", false, err
+	}
 
-	log.Printf("Doctor chat for user %s: %s", userID, message)
+	// The message is health information, so only its size is logged
+	slog.DebugContext(ctx, "Doctor chat", "conversation_id", conversationID, "message_length", len(message))
+
+	var summary string
+	var since time.Time
+	if as.config.ChatSummaryContext {
+		stored, err := as.conversationSummary(ctx, userID, conversationID)
+		if err != nil {
+			return "", false, err
+		}
+		// The turns the summary covers are not sent again
+		summary, since = stored.Summary, stored.SummarizedThrough
+	}
+	history, err := as.chatHistory(ctx, userID, conversationID, since)
+	if err != nil {
+		return "", false, err
+	}
 
-	// Mock AI response
-	response := fmt.Sprintf("AI Doctor: I've noted your concern about '%s'. Please provide more details about your symptoms.", message)
+	if tp, ok := route.provider.(ToolCallingProvider); ok && len(as.config.ChatTools) > 0 {
+		response, err = as.chatWithTools(ctx, route, tp, userID, withConversationContext(summary, history, message))
+	} else {
+		err = route.call(func() error {
+			var err error
+			if sp, ok := route.provider.(StreamingChatProvider); ok && onChunk != nil {
+				response, err = sp.StreamDoctorChat(ctx, history, withConversationContext(summary, nil, message), onChunk)
+			} else if cp, ok := route.provider.(ConversationalProvider); ok {
+				response, err = cp.DoctorChatWithHistory(ctx, history, withConversationContext(summary, nil, message))
+			} else {
+				response, err = route.provider.DoctorChat(ctx, withConversationContext(summary, history, message))
+			}
+			if err != nil {
+				return err
+			}
+			return checkReply(route.provider.Name(), response)
+		})
+	}
+	if isRefusal(err) {
+		log.Printf("Doctor chat refused: %v", err)
+		return "", false, err
+	}
+	if err != nil {
+		log.Printf("Falling back to rule-based chat reply: %v", err)
+		response, degraded = ruleBasedChatReply, true
+	}
 
 	// Store conversation
 	conversation := models.DoctorConversation{
 		ID:             uuid.New().String(),
 		UserID:         userID,
 		ConversationID: conversationID,
-		Message:        message,
-		Response:       response,
+		Message:        truncateStored(message, as.config.MaxStoredChatLength),
+		Response:       truncateStored(response, as.config.MaxStoredChatLength),
 		IsAI:           true,
 		CreatedAt:      time.Now(),
 	}
 
-	if err := as.db.Create(&conversation).Error; err != nil {
-		return "", fmt.Errorf("failed to store conversation: %w", err)
+	if err := as.db.WithContext(ctx).Create(&conversation).Error; err != nil {
+		return "", false, fmt.Errorf("failed to store conversation: %w", err)
+	}
+
+	as.events.Publish(ctx, events.ConversationMessageAdded{
+		UserID:         userID,
+		ConversationID: conversationID,
+		MessageID:      conversation.ID,
+		Degraded:       degraded,
+	})
+	return response, degraded, nil
+}
+
+// truncatedMarker ends chat text cut by truncateStored
+const truncatedMarker = "… [truncated]"
+
+// truncateStored cuts s to at most max bytes, including the trailing
+// truncatedMarker, without splitting a rune. A max of 0 keeps s whole.
+func truncateStored(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	marker := truncatedMarker
+	if max < len(marker) {
+		// No room for the marker
+		marker = ""
+	}
+	cut := max - len(marker)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + marker
+}
+
+// VoiceChat transcribes spoken audio and feeds the transcript into DoctorChat
+func (as *AIService) VoiceChat(ctx context.Context, userID, conversationID string, audio []byte, format string) (transcript, response string, degraded bool, err error) {
+	if err := as.flags.require(FeatureChat); err != nil {
+		return "", "", false, err
+	}
+	if err := validateAudio(audio, format, as.config.MaxAudioSize); err != nil {
+		return "", "", false, err
+	}
+
+	transcript, err = as.transcriber.Transcribe(ctx, audio, format)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	if transcript == "" {
+		return "", "", false, fmt.Errorf("%w: no speech detected", ErrInvalidAudio)
+	}
+
+	response, degraded, err = as.DoctorChat(ctx, userID, conversationID, transcript)
+	if err != nil {
+		return transcript, "", false, err
 	}
 
-	return response, nil
+	return transcript, response, degraded, nil
 }
 
 // GetConversationHistory retrieves chat history
-func (as *AIService) GetConversationHistory(conversationID string) ([]models.DoctorConversation, error) {
+func (as *AIService) GetConversationHistory(ctx context.Context, conversationID string) ([]models.DoctorConversation, error) {
 	var conversations []models.DoctorConversation
-	if err := as.db.Where("conversation_id = ?", conversationID).
+	if err := as.db.WithContext(ctx).Where("conversation_id = ?", conversationID).
 		Order("created_at ASC").
 		Find(&conversations).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch conversations: %w", err)
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/clarity/backend/config"
+)
+
+func TestTruncateStored(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	tests := []struct {
+		name string
+		s    string
+		max  int
+		want string
+	}{
+		{"shorter than the limit", "hello", 10, "hello"},
+		{"exactly the limit", "0123456789", 10, "0123456789"},
+		{"limit disabled", long, 0, long},
+		{"one byte over", long[:41], 40, long[:40-len(truncatedMarker)] + truncatedMarker},
+		{"far over", long, 40, long[:40-len(truncatedMarker)] + truncatedMarker},
+		{"limit below the marker", long, 5, "aaaaa"},
+		{"empty", "", 10, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateStored(tt.s, tt.max)
+			if got != tt.want {
+				t.Errorf("truncateStored(%d bytes, %d) = %q, want %q", len(tt.s), tt.max, got, tt.want)
+			}
+			if tt.max > 0 && len(got) > tt.max {
+				t.Errorf("result is %d bytes, over the limit of %d", len(got), tt.max)
+			}
+		})
+	}
+}
+
+func TestTruncateStoredNeverSplitsARune(t *testing.T) {
+	s := strings.Repeat("€", 50) // three bytes each
+	for max := 1; max <= len(s); max++ {
+		got := truncateStored(s, max)
+		if len(got) > max {
+			t.Fatalf("max %d: result is %d bytes", max, len(got))
+		}
+		if !utf8.ValidString(got) {
+			t.Fatalf("max %d: result %q is not valid UTF-8", max, got)
+		}
+	}
+}
+
+func TestDoctorChatStoresBoundedTurns(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{MaxStoredChatLength: 64})
+	long := strings.Repeat("x", 1000)
+	as.provider = &fakeProvider{reply: long}
+	ctx := context.Background()
+
+	response, _, err := as.DoctorChat(ctx, "user-1", "conv-1", "short question")
+	if err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if response != long {
+		t.Errorf("caller got a %d byte response, want the full %d bytes", len(response), len(long))
+	}
+	as.provider = &fakeProvider{reply: "short answer"}
+	if _, _, err := as.DoctorChat(ctx, "user-1", "conv-1", long); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+
+	turns := conversationTurns(t, db, "conv-1")
+	if len(turns) != 2 {
+		t.Fatalf("stored %d turns, want 2", len(turns))
+	}
+	if turns[0].Message != "short question" {
+		t.Errorf("normal message stored as %q", turns[0].Message)
+	}
+	if len(turns[0].Response) != 64 || !strings.HasSuffix(turns[0].Response, truncatedMarker) {
+		t.Errorf("long response stored as %d bytes: %q", len(turns[0].Response), turns[0].Response)
+	}
+	if len(turns[1].Message) != 64 || !strings.HasSuffix(turns[1].Message, truncatedMarker) {
+		t.Errorf("long message stored as %d bytes: %q", len(turns[1].Message), turns[1].Message)
+	}
+	if turns[1].Response != "short answer" {
+		t.Errorf("normal response stored as %q", turns[1].Response)
+	}
+}
+
+func TestDoctorChatDoesNotLogTheMessage(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	as := newTestAIService(t, newTestDB(t), nil)
+	as.provider = &fakeProvider{reply: "Rest and fluids."}
+	const message = "I found a lump in my breast"
+	if _, _, err := as.DoctorChat(context.Background(), "user-1", "conv-1", message); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+
+	if strings.Contains(logs.String(), "lump") {
+		t.Errorf("logs contain the chat message:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), fmt.Sprintf("message_length=%d", len(message))) {
+		t.Errorf("logs do not record the message length:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Appointment parsing for free text such as "Dentist next Tuesday at 3pm"
+// or "Dr Patel at City Clinic on March 5th, 10:30am". Each recognized date,
+// time, and location phrase is blanked out of the text as it is consumed;
+// whatever remains becomes the title.
+
+var (
+	isoDatePattern      = regexp.MustCompile(`(?i)\b(?:on\s+)?(\d{4})-(\d{1,2})-(\d{1,2})\b`)
+	monthDayPattern     = regexp.MustCompile(`(?i)\b(?:on\s+)?(?:the\s+)?(?:(jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?\s+(\d{1,2})(?:st|nd|rd|th)?|(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?(jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?)(?:,?\s+(\d{4}))?\b`)
+	slashDatePattern    = regexp.MustCompile(`\b(?:on\s+)?(\d{1,2})/(\d{1,2})(?:/(\d{2}|\d{4}))?\b`)
+	relativeDayPattern  = regexp.MustCompile(`(?i)\b(?:on\s+)?(the\s+day\s+after\s+tomorrow|day\s+after\s+tomorrow|today|tonight|tomorrow)\b`)
+	inDaysPattern       = regexp.MustCompile(`(?i)\bin\s+(\d{1,2}|a|an|one|two|three|four|five|six|seven|eight|nine|ten)\s+(days?|weeks?)\b`)
+	weekdayPattern      = regexp.MustCompile(`(?i)\b(?:on\s+)?(?:(next|this|coming)\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday|mon|tues|tue|wed|thurs|thur|thu|fri|sat|sun)\b\.?`)
+	meridiemTimePattern = regexp.MustCompile(`(?i)\b(?:at\s+|@\s*)?(\d{1,2})(?:[:.](\d{2}))?\s*(a\.?m\.?|p\.?m\.?)`)
+	clockTimePattern    = regexp.MustCompile(`(?i)\b(?:at\s+|@\s*)?([01]?\d|2[0-3]):([0-5]\d)\b`)
+	namedTimePattern    = regexp.MustCompile(`(?i)\b(?:at\s+)?(noon|midday|midnight)\b`)
+	partOfDayPattern    = regexp.MustCompile(`(?i)\b(?:in\s+the\s+|this\s+)?(morning|afternoon|evening)\b`)
+	bareHourPattern     = regexp.MustCompile(`(?i)\bat\s+(\d{1,2})\b`)
+	locationPattern     = regexp.MustCompile(`\b(?:at|@)\s+(?:the\s+)?([A-Z][\w'&.-]*(?:\s+(?:[A-Z0-9][\w'&.-]*|of|on|and|&))*)`)
+	titleTrimPattern    = regexp.MustCompile(`(?i)^(?:(?:on|at|in|for|with|and|the)\b|[\s,;:.@-])+|(?:\b(?:on|at|in|for|with|and|the)|[\s,;:.@-])+$`)
+	whitespacePattern   = regexp.MustCompile(`\s+`)
+)
+
+var appointmentMonths = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"may": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "sept": time.September, "oct": time.October,
+	"nov": time.November, "dec": time.December,
+}
+
+var appointmentWeekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// ParsedAppointment holds the fields extracted from free text. StartsAt is
+// zero when no date or time was recognized. NeedsConfirmation is set, with
+// the reasons listed, whenever a field is missing or had to be guessed.
+type ParsedAppointment struct {
+	Title             string
+	StartsAt          time.Time
+	Location          string
+	NeedsConfirmation bool
+	Reasons           []string
+}
+
+func (pa *ParsedAppointment) flag(reason string) {
+	pa.NeedsConfirmation = true
+	pa.Reasons = append(pa.Reasons, reason)
+}
+
+// ParseAppointment extracts an appointment from free text. Relative dates
+// are resolved against now, in now's location.
+func ParseAppointment(text string, now time.Time) ParsedAppointment {
+	var result ParsedAppointment
+	rest := text
+
+	date, hasDate := parseAppointmentDate(&rest, now, &result)
+	hour, minute, hasTime := parseAppointmentTime(&rest, &result)
+
+	switch {
+	case hasDate && hasTime:
+		result.StartsAt = time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, now.Location())
+	case hasDate:
+		result.StartsAt = time.Date(date.Year(), date.Month(), date.Day(), 9, 0, 0, 0, now.Location())
+		result.flag("no time given, assumed 9:00")
+	case hasTime:
+		start := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if !start.After(now) {
+			start = start.AddDate(0, 0, 1)
+		}
+		result.StartsAt = start
+		result.flag("no date given, assumed the next occurrence of that time")
+	default:
+		result.flag("no date or time recognized")
+	}
+
+	if m := locationPattern.FindStringSubmatchIndex(rest); m != nil {
+		result.Location = strings.TrimRight(rest[m[2]:m[3]], " .,&")
+		result.Location = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(result.Location, " of"), " on"), " and")
+		rest = blank(rest, m[0], m[1])
+	}
+
+	title := whitespacePattern.ReplaceAllString(rest, " ")
+	title = strings.TrimSpace(titleTrimPattern.ReplaceAllString(title, ""))
+	if title == "" {
+		title = "Appointment"
+		result.flag("no title recognized")
+	}
+	result.Title = capitalizeFirst(title)
+
+	return result
+}
+
+// parseAppointmentDate consumes the first date phrase in rest
+func parseAppointmentDate(rest *string, now time.Time, result *ParsedAppointment) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if m := isoDatePattern.FindStringSubmatchIndex(*rest); m != nil {
+		year, _ := strconv.Atoi((*rest)[m[2]:m[3]])
+		month, _ := strconv.Atoi((*rest)[m[4]:m[5]])
+		day, _ := strconv.Atoi((*rest)[m[6]:m[7]])
+		*rest = blank(*rest, m[0], m[1])
+		return checkedDate(year, time.Month(month), day, today, true, result)
+	}
+
+	if m := monthDayPattern.FindStringSubmatch(*rest); m != nil {
+		loc := monthDayPattern.FindStringIndex(*rest)
+		monthName, dayText := m[1], m[2]
+		if monthName == "" {
+			monthName, dayText = m[4], m[3]
+		}
+		day, _ := strconv.Atoi(dayText)
+		month := appointmentMonths[strings.ToLower(monthName)]
+		*rest = blank(*rest, loc[0], loc[1])
+		if m[5] != "" {
+			year, _ := strconv.Atoi(m[5])
+			return checkedDate(year, month, day, today, true, result)
+		}
+		return checkedDate(today.Year(), month, day, today, false, result)
+	}
+
+	if m := slashDatePattern.FindStringSubmatchIndex(*rest); m != nil {
+		first, _ := strconv.Atoi((*rest)[m[2]:m[3]])
+		second, _ := strconv.Atoi((*rest)[m[4]:m[5]])
+		year, explicitYear := today.Year(), m[6] >= 0
+		if explicitYear {
+			year, _ = strconv.Atoi((*rest)[m[6]:m[7]])
+			if year < 100 {
+				year += 2000
+			}
+		}
+		*rest = blank(*rest, m[0], m[1])
+
+		// Month first unless that is impossible; either way ask when both readings work
+		month, day := first, second
+		if first > 12 {
+			month, day = second, first
+		} else if second <= 12 && first != second {
+			result.flag("numeric date could be month/day or day/month, assumed month/day")
+		}
+		return checkedDate(year, time.Month(month), day, today, explicitYear, result)
+	}
+
+	if m := relativeDayPattern.FindStringSubmatchIndex(*rest); m != nil {
+		word := strings.ToLower(whitespacePattern.ReplaceAllString((*rest)[m[2]:m[3]], " "))
+		*rest = blank(*rest, m[0], m[1])
+		switch {
+		case strings.HasSuffix(word, "day after tomorrow"):
+			return today.AddDate(0, 0, 2), true
+		case word == "tomorrow":
+			return today.AddDate(0, 0, 1), true
+		default:
+			return today, true
+		}
+	}
+
+	if m := inDaysPattern.FindStringSubmatchIndex(*rest); m != nil {
+		countText := strings.ToLower((*rest)[m[2]:m[3]])
+		unit := strings.ToLower((*rest)[m[4]:m[5]])
+		*rest = blank(*rest, m[0], m[1])
+		count, err := strconv.Atoi(countText)
+		if err != nil {
+			count = durationNumberWords[countText]
+		}
+		if strings.HasPrefix(unit, "week") {
+			count *= 7
+		}
+		return today.AddDate(0, 0, count), true
+	}
+
+	if m := weekdayPattern.FindStringSubmatchIndex(*rest); m != nil {
+		var modifier string
+		if m[2] >= 0 {
+			modifier = strings.ToLower((*rest)[m[2]:m[3]])
+		}
+		weekday := appointmentWeekdays[strings.ToLower((*rest)[m[4]:m[5]])]
+		*rest = blank(*rest, m[0], m[1])
+
+		ahead := (int(weekday) - int(today.Weekday()) + 7) % 7
+		if ahead == 0 {
+			ahead = 7
+			if modifier != "next" {
+				result.flag("weekday is today, assumed next week")
+			}
+		}
+		date := today.AddDate(0, 0, ahead)
+		// "next Tuesday" said on a Monday may mean tomorrow or a week later
+		if modifier == "next" && ahead < 7 && daysLeftInWeek(today) >= ahead {
+			result.flag(`"next" weekday could mean this week or the following one, assumed the nearest`)
+		}
+		return date, true
+	}
+
+	return time.Time{}, false
+}
+
+// parseAppointmentTime consumes the first time-of-day phrase in rest
+func parseAppointmentTime(rest *string, result *ParsedAppointment) (int, int, bool) {
+	if m := meridiemTimePattern.FindStringSubmatchIndex(*rest); m != nil {
+		hour, _ := strconv.Atoi((*rest)[m[2]:m[3]])
+		minute := 0
+		if m[4] >= 0 {
+			minute, _ = strconv.Atoi((*rest)[m[4]:m[5]])
+		}
+		pm := strings.HasPrefix(strings.ToLower((*rest)[m[6]:m[7]]), "p")
+		*rest = blank(*rest, m[0], m[1])
+		if hour < 1 || hour > 12 || minute > 59 {
+			result.flag("time not understood")
+			return 0, 0, false
+		}
+		hour %= 12
+		if pm {
+			hour += 12
+		}
+		return hour, minute, true
+	}
+
+	if m := clockTimePattern.FindStringSubmatchIndex(*rest); m != nil {
+		hour, _ := strconv.Atoi((*rest)[m[2]:m[3]])
+		minute, _ := strconv.Atoi((*rest)[m[4]:m[5]])
+		*rest = blank(*rest, m[0], m[1])
+		return hour, minute, true
+	}
+
+	if m := namedTimePattern.FindStringSubmatchIndex(*rest); m != nil {
+		word := strings.ToLower((*rest)[m[2]:m[3]])
+		*rest = blank(*rest, m[0], m[1])
+		if word == "midnight" {
+			return 0, 0, true
+		}
+		return 12, 0, true
+	}
+
+	if m := partOfDayPattern.FindStringSubmatchIndex(*rest); m != nil {
+		word := strings.ToLower((*rest)[m[2]:m[3]])
+		*rest = blank(*rest, m[0], m[1])
+		result.flag("only a part of the day was given, assumed a typical time")
+		switch word {
+		case "morning":
+			return 9, 0, true
+		case "afternoon":
+			return 14, 0, true
+		default:
+			return 18, 0, true
+		}
+	}
+
+	if m := bareHourPattern.FindStringSubmatchIndex(*rest); m != nil {
+		hour, _ := strconv.Atoi((*rest)[m[2]:m[3]])
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		*rest = blank(*rest, m[0], m[1])
+		// Clinics rarely open before 8, so "at 3" almost always means 3pm
+		if hour < 8 {
+			hour += 12
+		}
+		result.flag("time given without am/pm, assumed daytime hours")
+		return hour, 0, true
+	}
+
+	return 0, 0, false
+}
+
+// checkedDate validates a calendar date. Without an explicit year, a date
+// that has already passed this year is moved to next year.
+func checkedDate(year int, month time.Month, day int, today time.Time, explicitYear bool, result *ParsedAppointment) (time.Time, bool) {
+	date := time.Date(year, month, day, 0, 0, 0, 0, today.Location())
+	if month < time.January || month > time.December || date.Day() != day {
+		result.flag("date not understood")
+		return time.Time{}, false
+	}
+	if date.Before(today) {
+		if !explicitYear {
+			return date.AddDate(1, 0, 0), true
+		}
+		result.flag("date is in the past")
+	}
+	return date, true
+}
+
+// daysLeftInWeek counts the days after today up to and including Sunday
+func daysLeftInWeek(today time.Time) int {
+	return (7 - int(today.Weekday())) % 7
+}
+
+// blank replaces text[start:end] with spaces so later patterns and the
+// title see the consumed phrase as a gap
+func blank(text string, start, end int) string {
+	return text[:start] + strings.Repeat(" ", end-start) + text[end:]
+}
+
+func capitalizeFirst(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAppointment(t *testing.T) {
+	// A Monday morning
+	now := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int, year ...int) time.Time {
+		y := 2026
+		if len(year) > 0 {
+			y = year[0]
+		}
+		return time.Date(y, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		text        string
+		title       string
+		startsAt    time.Time
+		location    string
+		confirm     bool
+		wantReasons int
+	}{
+		{"Dentist next Tuesday at 3pm", "Dentist", at(10, 13, 15, 0), "", true, 1},
+		{"Dr Patel at City Clinic on March 5th, 10:30am", "Dr Patel", at(3, 5, 10, 30, 2027), "City Clinic", false, 0},
+		{"Physio tomorrow 14:00", "Physio", at(10, 13, 14, 0), "", false, 0},
+		{"Eye test on 5 Nov at Vision Express", "Eye test", at(11, 5, 9, 0), "Vision Express", true, 1},
+		{"blood test in 2 weeks in the morning", "Blood test", at(10, 26, 9, 0), "", true, 1},
+		{"cardiology 11/3 at 9", "Cardiology", at(11, 3, 9, 0), "", true, 2},
+		{"Checkup at 4pm", "Checkup", at(10, 12, 16, 0), "", true, 1},
+		{"GP appointment 2026-12-01 at 08:15 at Riverside Surgery", "GP appointment", at(12, 1, 8, 15), "Riverside Surgery", false, 0},
+		{"Dentist on friday", "Dentist", at(10, 16, 9, 0), "", true, 1},
+		{"something", "Something", time.Time{}, "", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := ParseAppointment(tt.text, now)
+			if got.Title != tt.title {
+				t.Errorf("Title = %q, want %q", got.Title, tt.title)
+			}
+			if !got.StartsAt.Equal(tt.startsAt) {
+				t.Errorf("StartsAt = %v, want %v", got.StartsAt, tt.startsAt)
+			}
+			if got.Location != tt.location {
+				t.Errorf("Location = %q, want %q", got.Location, tt.location)
+			}
+			if got.NeedsConfirmation != tt.confirm {
+				t.Errorf("NeedsConfirmation = %v, want %v", got.NeedsConfirmation, tt.confirm)
+			}
+			if len(got.Reasons) != tt.wantReasons {
+				t.Errorf("Reasons = %q, want %d", got.Reasons, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestParseAppointmentFlagsEveryAssumption(t *testing.T) {
+	now := time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)
+	for _, text := range []string{"Physio tomorrow 14:00", "GP appointment 2026-12-01 at 08:15"} {
+		if got := ParseAppointment(text, now); got.NeedsConfirmation || len(got.Reasons) != 0 {
+			t.Errorf("ParseAppointment(%q) asks for confirmation: %q", text, got.Reasons)
+		}
+	}
+	for _, text := range []string{"at 3pm", "on friday", ""} {
+		got := ParseAppointment(text, now)
+		if !got.NeedsConfirmation || len(got.Reasons) == 0 {
+			t.Errorf("ParseAppointment(%q) does not ask for confirmation", text)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/clarity/backend/events"
+)
+
+// Audit subscribers, registered on the event bus in server.New
+
+// OnExportLinkCreated audits a new export link
+func (as *AuditService) OnExportLinkCreated(ctx context.Context, e events.ExportLinkCreated) error {
+	return as.Record(ctx, e.UserID, AuditActionExportLinkCreate, "export_link", e.LinkID,
+		fmt.Sprintf("records=%d expires_at=%s pin=%t redacted=%t", e.Records, e.ExpiresAt.Format(time.RFC3339), e.PIN, e.Redacted))
+}
+
+// OnExportLinkAccessed audits an export link being opened. ExportService
+// reads these entries back to spot links opened from too many addresses.
+func (as *AuditService) OnExportLinkAccessed(ctx context.Context, e events.ExportLinkAccessed) error {
+	return as.Record(ctx, "export_link:"+e.LinkID, AuditActionExportLinkAccess, "export_link", e.LinkID, "accessor="+e.Accessor)
+}
+
+// OnExportLinkRevoked audits an export link being revoked
+func (as *AuditService) OnExportLinkRevoked(ctx context.Context, e events.ExportLinkRevoked) error {
+	var details string
+	if e.Reason != "" {
+		details = "reason=" + e.Reason
+	}
+	return as.Record(ctx, e.ActorID, AuditActionExportLinkRevoke, "export_link", e.LinkID, details)
+}
+
+// OnUserLoggedIn audits a sign-in
+func (as *AuditService) OnUserLoggedIn(ctx context.Context, e events.UserLoggedIn) error {
+	return as.Record(ctx, e.UserID, AuditActionLogin, "user", e.UserID,
+		fmt.Sprintf("method=%s device=%s new_user=%t", e.Method, e.DeviceID, e.NewUser))
+}
+
+// OnRecordCreated audits a health record being added
+func (as *AuditService) OnRecordCreated(ctx context.Context, e events.RecordCreated) error {
+	return as.Record(ctx, e.UserID, AuditActionRecordCreate, "health_record", e.RecordID, "type="+e.RecordType)
+}
+
+// OnScanCompleted audits a prescription scan being stored
+func (as *AuditService) OnScanCompleted(ctx context.Context, e events.ScanCompleted) error {
+	return as.Record(ctx, e.UserID, AuditActionScanComplete, "scan", e.ScanID, "")
+}
+
+// OnConversationMessageAdded audits a doctor chat turn being stored. The
+// message itself stays in the conversation.
+func (as *AuditService) OnConversationMessageAdded(ctx context.Context, e events.ConversationMessageAdded) error {
+	return as.Record(ctx, e.UserID, AuditActionChatMessage, "conversation", e.ConversationID,
+		fmt.Sprintf("message=%s degraded=%t", e.MessageID, e.Degraded))
+}
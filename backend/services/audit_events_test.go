@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/events"
+	"github.com/clarity/backend/models"
+)
+
+func TestRecordAndChatEventsAreAudited(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	bus := events.NewBus()
+	audit := NewAuditService(db)
+	events.Subscribe(bus, "audit", audit.OnRecordCreated)
+	events.Subscribe(bus, "audit", audit.OnScanCompleted)
+	events.Subscribe(bus, "audit", audit.OnConversationMessageAdded)
+
+	hrs := NewHealthRecordsService(db, &config.RecordsConfig{}, nil, bus)
+	as := NewAIService(db, &config.AIConfig{}, nil, nil, nil, nil, 0, nil, bus)
+	as.provider = &fakeProvider{reply: "Rest and drink water.", scan: map[string]string{"medication": "Amoxicillin"}}
+	ctx := context.Background()
+
+	record, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Fasting glucose", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	scan, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true})
+	if err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if _, _, err := as.DoctorChat(ctx, "user-1", "conv-1", "I have a headache"); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+
+	var entries []models.AuditLog
+	if err := db.Order("created_at ASC").Find(&entries).Error; err != nil {
+		t.Fatalf("load audit log: %v", err)
+	}
+	want := []struct{ action, targetType, targetID string }{
+		{AuditActionRecordCreate, "health_record", record.ID},
+		{AuditActionScanComplete, "scan", scan.ScanID},
+		{AuditActionRecordCreate, "health_record", scan.RecordID},
+		{AuditActionChatMessage, "conversation", "conv-1"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("audit log = %+v, want %d entries", entries, len(want))
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.ActorID != "user-1" || e.Action != w.action || e.TargetType != w.targetType || e.TargetID != w.targetID {
+			t.Errorf("entry %d = %s %s %s/%s, want user-1 %s %s/%s", i, e.ActorID, e.Action, e.TargetType, e.TargetID, w.action, w.targetType, w.targetID)
+		}
+	}
+	if entries[0].Details != "type=lab_result" {
+		t.Errorf("record entry details = %q", entries[0].Details)
+	}
+}
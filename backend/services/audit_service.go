@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditActorAdmin is the actor ID recorded for calls made with the admin key
+const AuditActorAdmin = "admin"
+
+// Audit actions
+const (
+	AuditActionSetLogLevel         = "logging.set_level"
+	AuditActionEnableDebugLogging  = "logging.enable_debug"
+	AuditActionDisableDebugLogging = "logging.disable_debug"
+	AuditActionDataQualityReport   = "data_quality.generate"
+	AuditActionExportLinkCreate    = "export_link.create"
+	AuditActionExportLinkAccess    = "export_link.access"
+	AuditActionExportLinkRevoke    = "export_link.revoke"
+	AuditActionReprocessStart      = "reprocess.start"
+	AuditActionEndpointDisable     = "endpoint.disable"
+	AuditActionEndpointEnable      = "endpoint.enable"
+	AuditActionMaintenanceEnter    = "maintenance.enter"
+	AuditActionMaintenanceExit     = "maintenance.exit"
+	AuditActionPurgeDeleted        = "records.purge_deleted"
+	AuditActionLogin               = "auth.login"
+	AuditActionRecordCreate        = "records.create"
+	AuditActionScanComplete        = "scan.complete"
+	AuditActionChatMessage         = "conversation.message"
+)
+
+type AuditService struct {
+	db *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record appends an entry to the audit log. The entry is written even if
+// ctx has been cancelled, since the action it records has already happened.
+func (as *AuditService) Record(ctx context.Context, actorID, action, targetType, targetID, details string) error {
+	entry := models.AuditLog{
+		ID:         uuid.New().String(),
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		CreatedAt:  time.Now(),
+	}
+	if err := as.db.WithContext(context.WithoutCancel(ctx)).Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// AuditLogFilter narrows ListAuditLogs. Zero fields match everything; the
+// time range is inclusive of Since and exclusive of Until.
+type AuditLogFilter struct {
+	ActorID string
+	Action  string
+	Since   time.Time
+	Until   time.Time
+}
+
+// List returns one page of audit entries, newest first, and the cursor for
+// the next page, which is empty on the last page. Paging is keyset-based on
+// (created_at, id) so deep pages cost the same as the first.
+func (as *AuditService) List(ctx context.Context, filter AuditLogFilter, cursor string, limit int) ([]models.AuditLog, string, error) {
+	limit, _ = pageBounds(limit, 0)
+
+	query := as.db.WithContext(ctx).Model(&models.AuditLog{})
+	if filter.ActorID != "" {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", createdAt, createdAt, id)
+	}
+
+	// One extra row tells us whether another page follows
+	var entries []models.AuditLog
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&entries).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to list audit logs: %w", err)
+	}
+
+	next := ""
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		next = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return entries, next, nil
+}
+
+// encodeCursor packs a keyset position (created_at, id) into an opaque token
+func encodeCursor(createdAt time.Time, id string) string {
+	raw := strconv.FormatInt(createdAt.UnixNano(), 10) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: malformed cursor", ErrInvalidArgument)
+	}
+	nanos, id, ok := strings.Cut(string(raw), "|")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil || id == "" {
+		return time.Time{}, "", fmt.Errorf("%w: malformed cursor", ErrInvalidArgument)
+	}
+	return time.Unix(0, n), id, nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// seedAuditLogs stores 120 entries from three actors and three actions,
+// two to a minute so pages have to break ties on ID
+func seedAuditLogs(t *testing.T, db *gorm.DB) []models.AuditLog {
+	t.Helper()
+	actors := []string{"user-1", "user-2", AuditActorAdmin}
+	actions := []string{AuditActionLogin, AuditActionExportLinkCreate, AuditActionMaintenanceEnter, AuditActionLogin}
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	entries := make([]models.AuditLog, 120)
+	for i := range entries {
+		entries[i] = models.AuditLog{
+			ID:        fmt.Sprintf("log-%03d", i),
+			ActorID:   actors[i%len(actors)],
+			Action:    actions[i%len(actions)],
+			CreatedAt: start.Add(time.Duration(i/2) * time.Minute),
+		}
+	}
+	if err := db.Create(&entries).Error; err != nil {
+		t.Fatalf("seed audit logs: %v", err)
+	}
+	return entries
+}
+
+// listAllAuditLogs follows cursors through every page of filter
+func listAllAuditLogs(t *testing.T, as *AuditService, filter AuditLogFilter, limit int) (ids []string, pages int) {
+	t.Helper()
+	cursor := ""
+	for {
+		entries, next, err := as.List(context.Background(), filter, cursor, limit)
+		if err != nil {
+			t.Fatalf("List(page %d): %v", pages+1, err)
+		}
+		if len(entries) > limit {
+			t.Fatalf("page %d has %d entries, limit %d", pages+1, len(entries), limit)
+		}
+		pages++
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if next == "" {
+			return ids, pages
+		}
+		if pages > 1000 {
+			t.Fatal("cursor never ran out")
+		}
+		cursor = next
+	}
+}
+
+func TestAuditLogListFiltersAndPages(t *testing.T) {
+	db := newTestDB(t)
+	as := NewAuditService(db)
+	seeded := seedAuditLogs(t, db)
+	start := seeded[0].CreatedAt
+
+	tests := []struct {
+		name   string
+		filter AuditLogFilter
+		limit  int
+	}{
+		{"everything", AuditLogFilter{}, 7},
+		{"one page", AuditLogFilter{}, 100},
+		{"actor", AuditLogFilter{ActorID: "user-2"}, 5},
+		{"action", AuditLogFilter{Action: AuditActionLogin}, 9},
+		{"actor and action", AuditLogFilter{ActorID: AuditActorAdmin, Action: AuditActionExportLinkCreate}, 3},
+		{"since", AuditLogFilter{Since: start.Add(40 * time.Minute)}, 6},
+		{"until", AuditLogFilter{Until: start.Add(10 * time.Minute)}, 4},
+		{"time range", AuditLogFilter{Since: start.Add(15 * time.Minute), Until: start.Add(25 * time.Minute)}, 2},
+		{"everything filtered", AuditLogFilter{ActorID: "user-1", Action: AuditActionLogin, Since: start.Add(5 * time.Minute), Until: start.Add(50 * time.Minute)}, 2},
+		{"no matches", AuditLogFilter{ActorID: "nobody"}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []models.AuditLog
+			for _, entry := range seeded {
+				f := tt.filter
+				if (f.ActorID == "" || entry.ActorID == f.ActorID) &&
+					(f.Action == "" || entry.Action == f.Action) &&
+					(f.Since.IsZero() || !entry.CreatedAt.Before(f.Since)) &&
+					(f.Until.IsZero() || entry.CreatedAt.Before(f.Until)) {
+					want = append(want, entry)
+				}
+			}
+			sort.Slice(want, func(i, j int) bool {
+				if !want[i].CreatedAt.Equal(want[j].CreatedAt) {
+					return want[i].CreatedAt.After(want[j].CreatedAt)
+				}
+				return want[i].ID > want[j].ID
+			})
+
+			got, pages := listAllAuditLogs(t, as, tt.filter, tt.limit)
+			if len(got) != len(want) {
+				t.Fatalf("listed %d entries, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i].ID {
+					t.Fatalf("entry %d = %s, want %s", i, got[i], want[i].ID)
+				}
+			}
+			if wantPages := max(1, (len(want)+tt.limit-1)/tt.limit); pages != wantPages {
+				t.Errorf("took %d pages, want %d", pages, wantPages)
+			}
+		})
+	}
+}
+
+func TestAuditLogListSeesEntriesAddedWhilePaging(t *testing.T) {
+	db := newTestDB(t)
+	as := NewAuditService(db)
+	seedAuditLogs(t, db)
+
+	first, cursor, err := as.List(context.Background(), AuditLogFilter{}, "", 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	// A newer entry must not shift the pages that follow
+	if err := as.Record(context.Background(), "user-1", AuditActionLogin, "user", "user-1", ""); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	second, _, err := as.List(context.Background(), AuditLogFilter{}, cursor, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(second) != 10 {
+		t.Fatalf("second page has %d entries, want 10", len(second))
+	}
+	for _, entry := range second {
+		for _, seen := range first {
+			if entry.ID == seen.ID {
+				t.Errorf("entry %s listed on both pages", entry.ID)
+			}
+		}
+	}
+}
+
+func TestAuditLogListRejectsMalformedCursors(t *testing.T) {
+	as := NewAuditService(newTestDB(t))
+	encode := base64.RawURLEncoding.EncodeToString
+	for _, cursor := range []string{"not base64!", encode([]byte("no-separator")), encode([]byte("abc|log-1")), encode([]byte("123|"))} {
+		if _, _, err := as.List(context.Background(), AuditLogFilter{}, cursor, 10); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("List(cursor %q) error = %v, want %v", cursor, err, ErrInvalidArgument)
+		}
+	}
+}
+
+func TestAuditCursorRoundTrip(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 30, 0, 123456789, time.UTC)
+	createdAt, id, err := decodeCursor(encodeCursor(at, "log|with|bars"))
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	if !createdAt.Equal(at) || id != "log|with|bars" {
+		t.Errorf("decodeCursor() = %v, %q", createdAt, id)
+	}
+}
@@ -1,77 +1,330 @@
 package services
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"log"
-	"strconv"
+	"math/big"
 	"time"
 
 	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/events"
 	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/permissions"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AuthService struct {
-	db     *gorm.DB
-	config *config.AuthConfig
+	db         *gorm.DB
+	config     *config.AuthConfig
+	deliveries *DeliveryQueue
+	events     *events.Bus
+	now        func() time.Time
 }
 
-func NewAuthService(db *gorm.DB, cfg *config.AuthConfig) *AuthService {
+func NewAuthService(db *gorm.DB, cfg *config.AuthConfig, deliveries *DeliveryQueue, bus *events.Bus) *AuthService {
 	return &AuthService{
-		db:     db,
-		config: cfg,
+		db:         db,
+		config:     cfg,
+		deliveries: deliveries,
+		events:     bus,
+		now:        time.Now,
 	}
 }
 
-// SendOTP generates and stores an OTP
-func (as *AuthService) SendOTP(email string) (string, error) {
-	otp := generateOTP(as.config.OTPLength)
+// SetClock replaces the clock codes, tokens and sessions are checked
+// against, so tests can let them expire without waiting
+func (as *AuthService) SetClock(now func() time.Time) {
+	as.now = now
+}
+
+// SendOTP generates and stores an OTP and queues the message carrying it
+// on the user's preferred channel, falling back to email if that attempt
+// fails. The code only ever leaves in that message. It returns the
+// delivery reference, which support can use to check whether the message
+// went out, and the channel tried first.
+func (as *AuthService) SendOTP(ctx context.Context, email string) (string, string, error) {
+	if err := as.checkOTPLockout(ctx, email); err != nil {
+		return "", "", err
+	}
+	if err := as.checkOTPRate(ctx, email); err != nil {
+		return "", "", err
+	}
+	if err := as.countOTPIssuance(ctx, email); err != nil {
+		return "", "", err
+	}
 
+	otp, err := generateOTP(as.config.OTPLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := as.now()
 	otpStore := models.OTPStore{
 		ID:        uuid.New().String(),
 		Email:     email,
-		OTP:       otp,
-		ExpiresAt: time.Now().Add(time.Duration(as.config.OTPExpiry) * time.Second),
-		CreatedAt: time.Now(),
+		ExpiresAt: now.Add(time.Duration(as.config.OTPExpiry) * time.Second),
+		CreatedAt: now,
+	}
+	otpStore.OTPHash = as.hashOTP(otpStore.ID, otp)
+
+	reference := uuid.New().String()
+	delivery := &models.Delivery{
+		ID:        reference,
+		Channel:   models.DeliveryChannelEmail,
+		Recipient: email,
+		Subject:   "Your Clarity sign-in code",
+		Body:      otpEmailBody(otp, as.config.OTPExpiry, reference),
+		Sensitive: true,
+	}
+	if channel, recipient := as.preferredOTPChannel(ctx, email); channel != models.DeliveryChannelEmail {
+		delivery.Channel, delivery.Recipient = channel, recipient
+		delivery.FallbackChannel, delivery.FallbackRecipient = models.DeliveryChannelEmail, email
+		delivery.Body = otpMessageBody(otp, as.config.OTPExpiry, reference)
+	}
+
+	err = as.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&otpStore).Error; err != nil {
+			return fmt.Errorf("failed to store OTP: %w", err)
+		}
+		return as.deliveries.Enqueue(tx, delivery)
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return reference, delivery.Channel, nil
+}
+
+// otpEmailBody renders the OTP email. The reference lets recipients and
+// support tell retried duplicates of the same email apart from new codes.
+func otpEmailBody(otp string, expirySeconds int, reference string) string {
+	return fmt.Sprintf("Your Clarity sign-in code is %s. It expires in %d minutes.\n\n"+
+		"If you receive this email more than once, use the code from any copy.\n"+
+		"Reference: %s\n", otp, expirySeconds/60, reference)
+}
+
+// otpMessageBody renders the OTP for push and WhatsApp. It is also what
+// the fallback email carries, so it avoids naming a channel.
+func otpMessageBody(otp string, expirySeconds int, reference string) string {
+	return fmt.Sprintf("Your Clarity sign-in code is %s. It expires in %d minutes. Reference: %s",
+		otp, expirySeconds/60, reference)
+}
+
+// preferredOTPChannel returns the channel and recipient for email's sign-in
+// code. New users, users without a preference, and preferences the server
+// can no longer honour all get email.
+func (as *AuthService) preferredOTPChannel(ctx context.Context, email string) (string, string) {
+	var user models.User
+	if err := as.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return models.DeliveryChannelEmail, email
+	}
+	if !as.deliveries.HasChannel(user.OTPChannel) {
+		return models.DeliveryChannelEmail, email
+	}
+
+	switch user.OTPChannel {
+	case models.DeliveryChannelPush:
+		return user.OTPChannel, user.ID
+	case models.DeliveryChannelWhatsApp:
+		if user.Phone != "" {
+			return user.OTPChannel, user.Phone
+		}
+	}
+	return models.DeliveryChannelEmail, email
+}
+
+// SetOTPChannel stores where the user wants sign-in codes sent. WhatsApp
+// needs a phone number in E.164 form, which is saved on the user.
+func (as *AuthService) SetOTPChannel(ctx context.Context, userID, channel, phone string) (*models.User, error) {
+	if !otpChannels[channel] || !as.deliveries.HasChannel(channel) {
+		return nil, fmt.Errorf("%w: unsupported sign-in code channel %q", ErrInvalidArgument, channel)
+	}
+
+	updates := map[string]interface{}{
+		"otp_channel": channel,
+		"updated_at":  time.Now(),
+	}
+	if channel == models.DeliveryChannelWhatsApp {
+		if !phonePattern.MatchString(phone) {
+			return nil, fmt.Errorf("%w: WhatsApp needs a phone number like +15551234567", ErrInvalidArgument)
+		}
+		updates["phone"] = phone
+	}
+
+	var user models.User
+	if err := as.db.WithContext(ctx).Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if err := as.db.WithContext(ctx).Model(&user).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update sign-in code channel: %w", err)
+	}
+
+	return &user, nil
+}
+
+// checkOTPRate rejects a request once email has been sent the configured
+// number of OTPs within the rolling window. It counts unverified OTPStore
+// rows, so the window empties as they age out of it, and a sign-in, which
+// deletes the code used, frees a slot.
+func (as *AuthService) checkOTPRate(ctx context.Context, email string) error {
+	if as.config.MaxOTPPerWindow <= 0 || as.config.RateLimitWindow <= 0 {
+		return nil
 	}
 
-	// In production, send via email service
-	log.Printf("OTP for %s: %s (expires in %d seconds)", email, otp, as.config.OTPExpiry)
+	since := as.now().Add(-time.Duration(as.config.RateLimitWindow) * time.Second)
+	var recent int64
+	if err := as.db.WithContext(ctx).Model(&models.OTPStore{}).
+		Where("email = ? AND created_at > ?", email, since).
+		Count(&recent).Error; err != nil {
+		return fmt.Errorf("failed to count recent OTPs: %w", err)
+	}
+	if recent >= int64(as.config.MaxOTPPerWindow) {
+		return ErrOTPRateLimited
+	}
+	return nil
+}
+
+// countOTPIssuance increments today's OTP count for email, rejecting the
+// request once the configured daily cap has been reached. Days are UTC.
+// The increment is conditional on the count being under the cap, so
+// concurrent requests cannot all pass on the same count.
+func (as *AuthService) countOTPIssuance(ctx context.Context, email string) error {
+	if as.config.OTPDailyCap <= 0 {
+		return nil
+	}
+
+	today := as.now().UTC().Format("2006-01-02")
+	return as.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Earlier days no longer matter once the day has rolled over
+		if err := tx.Where("email = ? AND day < ?", email, today).Delete(&models.OTPIssuance{}).Error; err != nil {
+			return fmt.Errorf("failed to prune OTP issuance: %w", err)
+		}
+
+		issuance := models.OTPIssuance{Email: email, Day: today}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&issuance).Error; err != nil {
+			return fmt.Errorf("failed to create OTP issuance: %w", err)
+		}
+		result := tx.Model(&models.OTPIssuance{}).
+			Where("email = ? AND day = ? AND count < ?", email, today, as.config.OTPDailyCap).
+			Update("count", gorm.Expr("count + 1"))
+		if result.Error != nil {
+			return fmt.Errorf("failed to count OTP issuance: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrOTPDailyCapReached
+		}
+		return nil
+	})
+}
 
-	if err := as.db.Create(&otpStore).Error; err != nil {
-		return "", fmt.Errorf("failed to store OTP: %w", err)
+// checkOTPLockout rejects signing in and requesting codes for an email
+// locked out after too many wrong codes
+func (as *AuthService) checkOTPLockout(ctx context.Context, email string) error {
+	if as.config.OTPMaxAttempts <= 0 {
+		return nil
 	}
 
-	return otp, nil // In production, don't return OTP
+	var attempts models.OTPAttempts
+	if err := as.db.WithContext(ctx).Where("email = ?", email).Limit(1).Find(&attempts).Error; err != nil {
+		return fmt.Errorf("failed to fetch OTP attempts: %w", err)
+	}
+	if attempts.LockedUntil != nil && as.now().Before(*attempts.LockedUntil) {
+		return ErrOTPAttempts
+	}
+	return nil
 }
 
-// VerifyOTP validates the OTP and returns tokens
-func (as *AuthService) VerifyOTP(email, otp string) (*models.User, string, string, error) {
-	var otpStore models.OTPStore
+// recordFailedOTP counts a wrong code for email. The limit-reaching wrong
+// code discards every outstanding code for the email and locks it out. The
+// returned error is what the caller should report. The attempt is counted
+// even if the caller hangs up, so cancelling requests cannot dodge the
+// lockout.
+func (as *AuthService) recordFailedOTP(ctx context.Context, email string) error {
+	if as.config.OTPMaxAttempts <= 0 {
+		return fmt.Errorf("invalid OTP")
+	}
+
+	now := as.now()
+	locked := false
+	err := as.db.WithContext(context.WithoutCancel(ctx)).Transaction(func(tx *gorm.DB) error {
+		attempts := models.OTPAttempts{Email: email}
+		if err := tx.FirstOrCreate(&attempts, attempts).Error; err != nil {
+			return fmt.Errorf("failed to load OTP attempts: %w", err)
+		}
 
-	if err := as.db.Where("email = ? AND otp = ?", email, otp).First(&otpStore).Error; err != nil {
-		return nil, "", "", fmt.Errorf("invalid OTP")
+		// Earlier wrong codes stop counting once every code they could have
+		// been guesses at has expired
+		failed := attempts.Failed + 1
+		if now.Sub(attempts.LastFailedAt) > time.Duration(as.config.OTPExpiry)*time.Second {
+			failed = 1
+		}
+		updates := map[string]interface{}{"failed": failed, "last_failed_at": now}
+		if failed >= as.config.OTPMaxAttempts {
+			locked = true
+			updates["failed"] = 0
+			updates["locked_until"] = now.Add(time.Duration(as.config.OTPLockout) * time.Second)
+			if err := tx.Where("email = ?", email).Delete(&models.OTPStore{}).Error; err != nil {
+				return fmt.Errorf("failed to discard OTPs: %w", err)
+			}
+		}
+		return tx.Model(&attempts).Updates(updates).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record OTP attempt: %w", err)
 	}
+	if locked {
+		return ErrOTPAttempts
+	}
+	return fmt.Errorf("invalid OTP")
+}
 
-	if time.Now().After(otpStore.ExpiresAt) {
-		as.db.Delete(&otpStore)
-		return nil, "", "", fmt.Errorf("OTP expired")
+// VerifyOTP validates the OTP and returns tokens. Users with TOTP enabled
+// can give a code from their authenticator app instead; an emailed code
+// still works for them, so losing the phone does not lock them out. The
+// refresh token is bound to deviceID, the client's installation ID.
+//
+// Wrong codes, emailed or TOTP, count towards a lockout: the one that
+// reaches OTP_MAX_ATTEMPTS invalidates the email's codes and further
+// attempts return ErrOTPAttempts until the lockout ends. A right code
+// clears the count, even on the last allowed attempt.
+func (as *AuthService) VerifyOTP(ctx context.Context, email, otp, deviceID string) (*models.User, string, string, error) {
+	if err := as.checkOTPLockout(ctx, email); err != nil {
+		return nil, "", "", err
+	}
+
+	usedTOTP, err := as.checkTOTP(ctx, email, otp)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if !usedTOTP {
+		if err := as.claimOTP(ctx, email, otp); err != nil {
+			return nil, "", "", err
+		}
 	}
 
 	// Get or create user
 	var user models.User
-	if err := as.db.Where("email = ?", email).First(&user).Error; err != nil {
+	newUser := false
+	if err := as.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
+			newUser = true
 			user = models.User{
 				ID:        uuid.New().String(),
 				Email:     email,
 				CreatedAt: time.Now(),
 				UpdatedAt: time.Now(),
 			}
-			if err := as.db.Create(&user).Error; err != nil {
+			if err := as.db.WithContext(ctx).Create(&user).Error; err != nil {
 				return nil, "", "", fmt.Errorf("failed to create user: %w", err)
 			}
 		} else {
@@ -80,35 +333,83 @@ func (as *AuthService) VerifyOTP(email, otp string) (*models.User, string, strin
 	}
 
 	// Generate tokens
-	accessToken := generateToken(user.ID, 24*time.Hour)
-	refreshToken := generateToken(user.ID, 7*24*time.Hour)
+	session, refreshToken, err := as.startSession(as.db.WithContext(ctx), user.ID, deviceID, permissions.LoginScopes)
+	if err != nil {
+		return nil, "", "", err
+	}
+	accessToken, err := as.generateToken(ctx, user.ID, session.ID, TokenTypeAccess, permissions.LoginScopes, accessTokenTTL)
+	if err != nil {
+		return nil, "", "", err
+	}
 
-	// Delete used OTP
-	as.db.Delete(&otpStore)
+	method := "totp"
+	if !usedTOTP {
+		method = "otp"
+	}
+	as.db.WithContext(ctx).Where("email = ?", email).Delete(&models.OTPAttempts{})
 
+	as.events.Publish(ctx, events.UserLoggedIn{UserID: user.ID, DeviceID: deviceID, Method: method, NewUser: newUser})
 	return &user, accessToken, refreshToken, nil
 }
 
-// Helper functions
-func generateOTP(length int) string {
-	bytes := make([]byte, length)
-	rand.Read(bytes)
-	return fmt.Sprintf("%0*d", length, int64(bytes[0])%1000000)
+// claimOTP checks otp against the codes pending for email, comparing
+// hashes in constant time, and deletes the matching code so it signs in
+// only once. Expired codes are left for PurgeExpiredOTPs, since
+// checkOTPRate still counts them while they are inside the rate window. A
+// wrong code counts towards the lockout; an expired one does not, since it
+// was once right.
+func (as *AuthService) claimOTP(ctx context.Context, email, otp string) error {
+	var pending []models.OTPStore
+	if err := as.db.WithContext(ctx).Where("email = ?", email).Find(&pending).Error; err != nil {
+		return fmt.Errorf("failed to fetch OTPs: %w", err)
+	}
+
+	now := as.now()
+	var match *models.OTPStore
+	for i := range pending {
+		if hmac.Equal([]byte(as.hashOTP(pending[i].ID, otp)), []byte(pending[i].OTPHash)) {
+			match = &pending[i]
+		}
+	}
+
+	switch {
+	case match == nil:
+		return as.recordFailedOTP(ctx, email)
+	case now.After(match.ExpiresAt):
+		return fmt.Errorf("OTP expired")
+	}
+	// Of concurrent requests with the same code, only one deletes it
+	result := as.db.WithContext(ctx).Delete(match)
+	if result.Error != nil {
+		return fmt.Errorf("failed to consume OTP: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("invalid OTP")
+	}
+	return nil
 }
 
-func generateToken(userID string, duration time.Duration) string {
-	token := hex.EncodeToString([]byte(userID + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)))
-	log.Printf("Generated token for user %s", userID)
-	return token
+// hashOTP keys the hash with the server secret and salts it with the row
+// ID, so codes cannot be recovered from the table alone by trying all of
+// them
+func (as *AuthService) hashOTP(id, otp string) string {
+	mac := hmac.New(sha256.New, []byte(as.config.JWTSecret))
+	mac.Write([]byte(id + ":" + otp))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-// RefreshToken validates refresh token and returns new access token
-func (as *AuthService) RefreshToken(refreshToken string) (string, error) {
-	// In production, implement proper JWT validation
-	if refreshToken == "" {
-		return "", fmt.Errorf("invalid refresh token")
-	}
+// Helper functions
 
-	accessToken := generateToken("user-id", 24*time.Hour)
-	return accessToken, nil
+// generateOTP returns a code of exactly length digits, every one of the
+// 10^length codes equally likely
+func generateOTP(length int) (string, error) {
+	if length <= 0 {
+		return "", fmt.Errorf("OTP length must be positive, got %d", length)
+	}
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate OTP: %w", err)
+	}
+	return fmt.Sprintf("%0*s", length, n.String()), nil
 }
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+func TestSendOTPDailyCap(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)}
+	as := newTestAuthService(db, &config.AuthConfig{OTPDailyCap: 3}, clock)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := as.SendOTP(ctx, "a@example.com"); err != nil {
+			t.Fatalf("OTP %d within the cap: %v", i+1, err)
+		}
+		clock.Advance(10 * time.Minute)
+	}
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPDailyCapReached) {
+		t.Fatalf("OTP over the cap: error = %v, want ErrOTPDailyCapReached", err)
+	}
+	if _, _, err := as.SendOTP(ctx, "b@example.com"); err != nil {
+		t.Fatalf("another email shares the cap: %v", err)
+	}
+
+	// Still the same UTC day, whatever the local time zone says
+	clock.now = time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPDailyCapReached) {
+		t.Fatalf("OTP before midnight: error = %v, want ErrOTPDailyCapReached", err)
+	}
+
+	clock.now = time.Date(2026, 3, 2, 0, 1, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if _, _, err := as.SendOTP(ctx, "a@example.com"); err != nil {
+			t.Fatalf("OTP %d after the day rolled over: %v", i+1, err)
+		}
+	}
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPDailyCapReached) {
+		t.Fatalf("cap did not apply to the new day: error = %v", err)
+	}
+}
+
+func TestConcurrentSendOTPAtTheDailyCap(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	as := newTestAuthService(db, &config.AuthConfig{OTPDailyCap: 3}, clock)
+	ctx := context.Background()
+
+	// One code short of the cap
+	for i := 0; i < 2; i++ {
+		if _, _, err := as.SendOTP(ctx, "a@example.com"); err != nil {
+			t.Fatalf("OTP %d within the cap: %v", i+1, err)
+		}
+	}
+
+	const sends = 8
+	var wg sync.WaitGroup
+	results := make(chan error, sends)
+	for i := 0; i < sends; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := as.SendOTP(ctx, "a@example.com")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	sent := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			sent++
+		case !errors.Is(err, ErrOTPDailyCapReached):
+			t.Errorf("OTP over the cap: error = %v, want ErrOTPDailyCapReached", err)
+		}
+	}
+	if sent != 1 {
+		t.Errorf("%d of %d concurrent OTPs sent at the cap, want 1", sent, sends)
+	}
+	var issuance models.OTPIssuance
+	if err := db.First(&issuance, "email = ? AND day = ?", "a@example.com", "2026-03-01").Error; err != nil {
+		t.Fatalf("load OTP issuance: %v", err)
+	}
+	if issuance.Count != 3 {
+		t.Errorf("issuance count = %d, want the cap of 3", issuance.Count)
+	}
+}
+
+func TestSendOTPDailyCapDisabled(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(db, &config.AuthConfig{}, &testClock{now: time.Now()})
+	for i := 0; i < 20; i++ {
+		if _, _, err := as.SendOTP(context.Background(), "a@example.com"); err != nil {
+			t.Fatalf("OTP %d with no cap: %v", i+1, err)
+		}
+	}
+}
+
+// newChannelTestAuth returns an AuthService whose email, push and WhatsApp
+// senders are the given fakes
+func newChannelTestAuth(db *gorm.DB, email, push, whatsapp *fakeSender) *AuthService {
+	dq := NewDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 60, MaxBackoff: 600}, map[string]Sender{
+		models.DeliveryChannelEmail:    email,
+		models.DeliveryChannelPush:     push,
+		models.DeliveryChannelWhatsApp: whatsapp,
+	}, nil)
+	return NewAuthService(db, &config.AuthConfig{JWTSecret: "test-secret", OTPLength: 6, OTPExpiry: 600}, dq, nil)
+}
+
+func TestSendOTPPreferredChannel(t *testing.T) {
+	tests := []struct {
+		name          string
+		otpChannel    string
+		phone         string
+		wantChannel   string
+		wantRecipient string
+		wantFallback  bool
+	}{
+		{"no preference", "", "", models.DeliveryChannelEmail, "user-1@example.com", false},
+		{"email", models.DeliveryChannelEmail, "", models.DeliveryChannelEmail, "user-1@example.com", false},
+		{"push", models.DeliveryChannelPush, "", models.DeliveryChannelPush, "user-1", true},
+		{"whatsapp", models.DeliveryChannelWhatsApp, "+4915112345678", models.DeliveryChannelWhatsApp, "+4915112345678", true},
+		{"whatsapp without a phone", models.DeliveryChannelWhatsApp, "", models.DeliveryChannelEmail, "user-1@example.com", false},
+		{"channel the server cannot send on", "sms", "+4915112345678", models.DeliveryChannelEmail, "user-1@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			as := newChannelTestAuth(db, &fakeSender{}, &fakeSender{}, &fakeSender{})
+			user := createUser(t, db, "user-1")
+			if err := db.Model(user).Updates(map[string]interface{}{"otp_channel": tt.otpChannel, "phone": tt.phone}).Error; err != nil {
+				t.Fatalf("set preference: %v", err)
+			}
+
+			reference, channel, err := as.SendOTP(context.Background(), user.Email)
+			if err != nil {
+				t.Fatalf("SendOTP: %v", err)
+			}
+			delivery, err := as.deliveries.GetDelivery(context.Background(), reference)
+			if err != nil {
+				t.Fatalf("GetDelivery: %v", err)
+			}
+			if channel != tt.wantChannel || delivery.Channel != tt.wantChannel || delivery.Recipient != tt.wantRecipient {
+				t.Errorf("queued on %s to %q (reported %s), want %s to %q",
+					delivery.Channel, delivery.Recipient, channel, tt.wantChannel, tt.wantRecipient)
+			}
+			if hasFallback := delivery.FallbackChannel != ""; hasFallback != tt.wantFallback {
+				t.Errorf("fallback = %s to %q, want one: %v", delivery.FallbackChannel, delivery.FallbackRecipient, tt.wantFallback)
+			}
+			if tt.wantFallback && (delivery.FallbackChannel != models.DeliveryChannelEmail || delivery.FallbackRecipient != user.Email) {
+				t.Errorf("fallback = %s to %q, want email to %q", delivery.FallbackChannel, delivery.FallbackRecipient, user.Email)
+			}
+		})
+	}
+}
+
+func TestSendOTPFallsBackToEmailWhenPreferredChannelFails(t *testing.T) {
+	db := newTestDB(t)
+	email, push := &fakeSender{}, &fakeSender{errs: []error{errors.New("device token expired")}}
+	as := newChannelTestAuth(db, email, push, &fakeSender{})
+	user := createUser(t, db, "user-1")
+	if _, err := as.SetOTPChannel(context.Background(), user.ID, models.DeliveryChannelPush, ""); err != nil {
+		t.Fatalf("SetOTPChannel: %v", err)
+	}
+
+	reference, channel, err := as.SendOTP(context.Background(), user.Email)
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	if channel != models.DeliveryChannelPush {
+		t.Fatalf("channel = %q, want push first", channel)
+	}
+	code := sentOTP(t, db, reference)
+
+	_, delivery := processDue(t, as.deliveries, reference)
+	if len(push.recipients) != 1 || push.recipients[0] != user.ID {
+		t.Fatalf("push sender got %v, want one attempt to %s", push.recipients, user.ID)
+	}
+	if delivery.Channel != models.DeliveryChannelEmail || delivery.Recipient != user.Email {
+		t.Fatalf("after the push failure: %+v, want it moved to email", delivery)
+	}
+
+	if sent, delivery := processDue(t, as.deliveries, reference); sent != 1 || delivery.Status != models.DeliveryStatusSent {
+		t.Fatalf("fallback email not sent: %+v", delivery)
+	}
+	if len(email.recipients) != 1 || email.recipients[0] != user.Email {
+		t.Errorf("email sender got %v", email.recipients)
+	}
+
+	// The fallback carries the same code, which still signs the user in
+	if _, _, _, err := as.VerifyOTP(context.Background(), user.Email, code, "device-1"); err != nil {
+		t.Errorf("VerifyOTP with the code from the fallback: %v", err)
+	}
+}
+
+func TestSetOTPChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		phone   string
+		want    error
+	}{
+		{"email", models.DeliveryChannelEmail, "", nil},
+		{"push", models.DeliveryChannelPush, "", nil},
+		{"whatsapp", models.DeliveryChannelWhatsApp, "+4915112345678", nil},
+		{"whatsapp without a phone", models.DeliveryChannelWhatsApp, "", ErrInvalidArgument},
+		{"whatsapp with a local number", models.DeliveryChannelWhatsApp, "015112345678", ErrInvalidArgument},
+		{"unknown channel", "carrier-pigeon", "", ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			as := newChannelTestAuth(db, &fakeSender{}, &fakeSender{}, &fakeSender{})
+			createUser(t, db, "user-1")
+
+			_, err := as.SetOTPChannel(context.Background(), "user-1", tt.channel, tt.phone)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("SetOTPChannel() error = %v, want %v", err, tt.want)
+			}
+			var user models.User
+			db.First(&user, "id = ?", "user-1")
+			if wantStored := tt.want == nil; (user.OTPChannel == tt.channel) != wantStored {
+				t.Errorf("stored channel = %q after error %v", user.OTPChannel, err)
+			}
+		})
+	}
+
+	as := newChannelTestAuth(newTestDB(t), &fakeSender{}, &fakeSender{}, &fakeSender{})
+	if _, err := as.SetOTPChannel(context.Background(), "nobody", models.DeliveryChannelEmail, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown user: error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces Clarity's keys in a shared Redis
+const redisKeyPrefix = "clarity:"
+
+// Cache stores short-lived values such as AI responses. Get reports a miss
+// with ok=false; errors are reserved for backend failures, which callers
+// should treat as a miss.
+type Cache interface {
+	Get(ctx context.Context, key string) (value string, ok bool, err error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+}
+
+// NewCache returns the cache backend selected in config
+func NewCache(cfg *config.CacheConfig) (Cache, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryCache(cfg.MaxEntries), nil
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return NewRedisCache(redis.NewClient(opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+}
+
+// MemoryCache is a per-process cache. Once full, the entry closest to
+// expiry is evicted to make room.
+type MemoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]memoryEntry
+	maxEntries int
+	now        func() time.Time
+}
+
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewMemoryCache holds up to maxEntries values; 0 means unbounded
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		entries:    make(map[string]memoryEntry),
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+func (mc *MemoryCache) Get(ctx context.Context, key string) (string, bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	entry, ok := mc.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+	if !mc.now().Before(entry.expiresAt) {
+		delete(mc.entries, key)
+		return "", false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (mc *MemoryCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := mc.now()
+	if _, exists := mc.entries[key]; !exists && mc.maxEntries > 0 && len(mc.entries) >= mc.maxEntries {
+		mc.evict(now)
+	}
+	mc.entries[key] = memoryEntry{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// evict drops expired entries, or failing that the one expiring soonest
+func (mc *MemoryCache) evict(now time.Time) {
+	var soonestKey string
+	var soonest time.Time
+	for key, entry := range mc.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(mc.entries, key)
+			continue
+		}
+		if soonestKey == "" || entry.expiresAt.Before(soonest) {
+			soonestKey, soonest = key, entry.expiresAt
+		}
+	}
+	if len(mc.entries) >= mc.maxEntries && soonestKey != "" {
+		delete(mc.entries, soonestKey)
+	}
+}
+
+// RedisCache shares cached values across replicas and restarts
+type RedisCache struct {
+	client *redis.Client
+}
+
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+func (rc *RedisCache) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := rc.client.Get(ctx, redisKeyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("redis get: %w", err)
+	}
+	return value, true, nil
+}
+
+func (rc *RedisCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	if err := rc.client.Set(ctx, redisKeyPrefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("redis set: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/clarity/backend/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// cacheBackend is a Cache under test with a way to move its clock forward
+type cacheBackend struct {
+	cache   Cache
+	advance func(time.Duration)
+}
+
+func newMemoryBackend(t *testing.T) cacheBackend {
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	mc := NewMemoryCache(0)
+	mc.now = clock.Now
+	return cacheBackend{cache: mc, advance: clock.Advance}
+}
+
+func newRedisBackend(t *testing.T) cacheBackend {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return cacheBackend{cache: NewRedisCache(client), advance: server.FastForward}
+}
+
+var cacheBackends = []struct {
+	name string
+	new  func(t *testing.T) cacheBackend
+}{
+	{"memory", newMemoryBackend},
+	{"redis", newRedisBackend},
+}
+
+func TestCacheGetSet(t *testing.T) {
+	for _, backend := range cacheBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			cache := backend.new(t).cache
+			ctx := context.Background()
+
+			if _, ok, err := cache.Get(ctx, "missing"); ok || err != nil {
+				t.Fatalf("Get(missing) = ok %v, err %v; want a miss", ok, err)
+			}
+			if err := cache.Set(ctx, "summary:user-1", "all good", time.Minute); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if value, ok, err := cache.Get(ctx, "summary:user-1"); !ok || err != nil || value != "all good" {
+				t.Fatalf("Get = %q, ok %v, err %v; want %q", value, ok, err, "all good")
+			}
+			if err := cache.Set(ctx, "summary:user-1", "updated", time.Minute); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if value, _, _ := cache.Get(ctx, "summary:user-1"); value != "updated" {
+				t.Errorf("Get after overwrite = %q, want %q", value, "updated")
+			}
+			if err := cache.Set(ctx, "empty", "", time.Minute); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if value, ok, _ := cache.Get(ctx, "empty"); !ok || value != "" {
+				t.Errorf("Get(empty) = %q, ok %v; want a hit on an empty value", value, ok)
+			}
+		})
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	for _, backend := range cacheBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			b := backend.new(t)
+			ctx := context.Background()
+
+			if err := b.cache.Set(ctx, "short", "a", time.Minute); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if err := b.cache.Set(ctx, "long", "b", time.Hour); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if err := b.cache.Set(ctx, "no-ttl", "c", 0); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if _, ok, _ := b.cache.Get(ctx, "no-ttl"); ok {
+				t.Error("a value without a TTL was cached")
+			}
+
+			b.advance(59 * time.Second)
+			if _, ok, _ := b.cache.Get(ctx, "short"); !ok {
+				t.Error("value expired before its TTL")
+			}
+
+			b.advance(time.Second)
+			if _, ok, _ := b.cache.Get(ctx, "short"); ok {
+				t.Error("value outlived its TTL")
+			}
+			if value, ok, _ := b.cache.Get(ctx, "long"); !ok || value != "b" {
+				t.Errorf("longer-lived value = %q, ok %v; want %q", value, ok, "b")
+			}
+		})
+	}
+}
+
+func TestRedisCacheIsSharedAndNamespaced(t *testing.T) {
+	server := miniredis.RunT(t)
+	cache, err := NewCache(&config.CacheConfig{Backend: "redis", RedisURL: "redis://" + server.Addr()})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	if _, ok := cache.(*RedisCache); !ok {
+		t.Fatalf("NewCache returned %T, want *RedisCache", cache)
+	}
+	other, err := NewCache(&config.CacheConfig{Backend: "redis", RedisURL: "redis://" + server.Addr()})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := cache.Set(ctx, "chat:hello", "hi there", time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if value, ok, _ := other.Get(ctx, "chat:hello"); !ok || value != "hi there" {
+		t.Errorf("second replica Get = %q, ok %v; want the shared value", value, ok)
+	}
+	if got, err := server.Get(redisKeyPrefix + "chat:hello"); err != nil || got != "hi there" {
+		t.Errorf("stored under %q = %q, %v", redisKeyPrefix+"chat:hello", got, err)
+	}
+	if ttl := server.TTL(redisKeyPrefix + "chat:hello"); ttl != time.Minute {
+		t.Errorf("stored TTL = %v, want %v", ttl, time.Minute)
+	}
+}
+
+func TestRedisCacheReportsBackendFailures(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	cache := NewRedisCache(client)
+	server.Close()
+
+	ctx := context.Background()
+	if _, ok, err := cache.Get(ctx, "key"); ok || err == nil {
+		t.Errorf("Get with Redis down = ok %v, err %v; want an error", ok, err)
+	}
+	if err := cache.Set(ctx, "key", "value", time.Minute); err == nil {
+		t.Error("Set with Redis down succeeded")
+	}
+}
+
+func TestNewCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.CacheConfig
+		wantErr bool
+	}{
+		{"default is memory", config.CacheConfig{}, false},
+		{"memory", config.CacheConfig{Backend: "memory", MaxEntries: 10}, false},
+		{"bad redis URL", config.CacheConfig{Backend: "redis", RedisURL: "http://nope"}, true},
+		{"unknown backend", config.CacheConfig{Backend: "memcached"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := NewCache(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCache() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if _, ok := cache.(*MemoryCache); !ok {
+					t.Errorf("NewCache() = %T, want *MemoryCache", cache)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryCacheEvictsSoonestExpiry(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	mc := NewMemoryCache(2)
+	mc.now = clock.Now
+	ctx := context.Background()
+
+	mc.Set(ctx, "a", "1", time.Hour)
+	mc.Set(ctx, "b", "2", time.Minute)
+	mc.Set(ctx, "c", "3", time.Hour)
+
+	if _, ok, _ := mc.Get(ctx, "b"); ok {
+		t.Error("entry closest to expiry was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := mc.Get(ctx, key); !ok {
+			t.Errorf("entry %q was evicted", key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowQuery counts to a billion, which takes SQLite far longer than any
+// deadline in these tests
+const slowQuery = `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000000000) SELECT count(*) FROM n`
+
+func TestDeadlineAbortsRunningQuery(t *testing.T) {
+	db := newTestDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	var count int64
+	err := db.WithContext(ctx).Raw(slowQuery).Scan(&count).Error
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow query error = %v (count %d), want %v", err, count, context.DeadlineExceeded)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("query ran for %v after its deadline of 50ms", elapsed)
+	}
+
+	// The interrupted connection is usable again
+	if err := db.Raw("SELECT 1").Scan(&count).Error; err != nil || count != 1 {
+		t.Errorf("query after the interrupt = %d, %v", count, err)
+	}
+}
+
+func TestCancelAbortsRunningQuery(t *testing.T) {
+	db := newTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	var count int64
+	err := db.WithContext(ctx).Raw(slowQuery).Scan(&count).Error
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("slow query error = %v (count %d), want %v", err, count, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("query ran for %v after it was cancelled", elapsed)
+	}
+}
+
+func TestServiceCallsStopOnCancelledContext(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", "")
+	hrs := newTestRecordsService(db, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := hrs.ListRecords(ctx, "user-1", ListRecordsOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListRecords: error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := hrs.GetRecord(ctx, "user-1", "rec-1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetRecord: error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Panel", "", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateRecord: error = %v, want %v", err, context.Canceled)
+	}
+	if _, _, err := NewAuditService(db).List(ctx, AuditLogFilter{}, "", 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("audit List: error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestAuditEntriesOutliveTheCaller(t *testing.T) {
+	db := newTestDB(t)
+	as := NewAuditService(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := as.Record(ctx, "user-1", AuditActionLogin, "user", "user-1", ""); err != nil {
+		t.Fatalf("Record on a cancelled context: %v", err)
+	}
+	entries, _, err := as.List(context.Background(), AuditLogFilter{}, "", 10)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("audit log = %d entries, %v; want the entry written after the caller hung up", len(entries), err)
+	}
+}
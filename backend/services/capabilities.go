@@ -0,0 +1,130 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Features clients can ask about with GetServiceCapabilities
+const (
+	FeatureScan      = "scan"
+	FeatureChat      = "chat"
+	FeatureSummaries = "summaries"
+	FeatureSearch    = "search"
+)
+
+// Features lists every feature in the order capabilities are reported
+var Features = []string{FeatureScan, FeatureChat, FeatureSummaries, FeatureSearch}
+
+// Fidelity of a feature
+const (
+	FidelityFull        = "full"
+	FidelityDegraded    = "degraded" // served by a rule-based fallback
+	FidelityUnavailable = "unavailable"
+)
+
+// capabilityCacheTTL is how long computed capabilities are reused, so a
+// busy app polling the RPC does not recompute them on every call
+const capabilityCacheTTL = 5 * time.Second
+
+// FeatureFlags records which features an operator has switched off
+type FeatureFlags struct {
+	disabled map[string]bool
+}
+
+func NewFeatureFlags(disabled []string) *FeatureFlags {
+	flags := &FeatureFlags{disabled: make(map[string]bool, len(disabled))}
+	for _, feature := range disabled {
+		flags.disabled[feature] = true
+	}
+	return flags
+}
+
+// Enabled reports whether feature is switched on. A nil FeatureFlags
+// enables everything.
+func (ff *FeatureFlags) Enabled(feature string) bool {
+	return ff == nil || !ff.disabled[feature]
+}
+
+// require returns ErrUnavailable when feature is switched off
+func (ff *FeatureFlags) require(feature string) error {
+	if !ff.Enabled(feature) {
+		return fmt.Errorf("%w: %s is switched off", ErrUnavailable, feature)
+	}
+	return nil
+}
+
+// CapabilityInputs are the signals capabilities are derived from
+type CapabilityInputs struct {
+	ProviderState string          // AI provider circuit breaker state
+	Disabled      map[string]bool // features switched off by flag
+}
+
+// computeCapabilities maps the inputs to a fidelity per feature. A flag
+// wins over everything else. While the provider breaker is open, chat and
+// summaries fall back to rule-based answers and scans are refused, since
+// there is no safe way to guess a prescription. Search does not use the
+// provider.
+func computeCapabilities(in CapabilityInputs) map[string]string {
+	providerDown := in.ProviderState == BreakerOpen
+
+	capabilities := make(map[string]string, len(Features))
+	for _, feature := range Features {
+		fidelity := FidelityFull
+		switch {
+		case in.Disabled[feature]:
+			fidelity = FidelityUnavailable
+		case providerDown && feature == FeatureScan:
+			fidelity = FidelityUnavailable
+		case providerDown && (feature == FeatureChat || feature == FeatureSummaries):
+			fidelity = FidelityDegraded
+		}
+		capabilities[feature] = fidelity
+	}
+	return capabilities
+}
+
+// CapabilityService reports which features are currently available and at
+// what fidelity, so the app can show a single "limited" notice instead of
+// surfacing errors feature by feature.
+type CapabilityService struct {
+	ai    *AIService
+	flags *FeatureFlags
+	now   func() time.Time
+
+	mu        sync.Mutex
+	cached    map[string]string
+	expiresAt time.Time
+}
+
+func NewCapabilityService(ai *AIService, flags *FeatureFlags) *CapabilityService {
+	return &CapabilityService{
+		ai:    ai,
+		flags: flags,
+		now:   time.Now,
+	}
+}
+
+// Capabilities returns the fidelity of every feature, recomputing at most
+// once per capabilityCacheTTL
+func (cs *CapabilityService) Capabilities() map[string]string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	now := cs.now()
+	if cs.cached != nil && now.Before(cs.expiresAt) {
+		return cs.cached
+	}
+
+	disabled := make(map[string]bool)
+	for _, feature := range Features {
+		disabled[feature] = !cs.flags.Enabled(feature)
+	}
+	cs.cached = computeCapabilities(CapabilityInputs{
+		ProviderState: cs.ai.ProviderState(),
+		Disabled:      disabled,
+	})
+	cs.expiresAt = now.Add(capabilityCacheTTL)
+	return cs.cached
+}
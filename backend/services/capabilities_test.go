@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestComputeCapabilities(t *testing.T) {
+	const (
+		full        = FidelityFull
+		degraded    = FidelityDegraded
+		unavailable = FidelityUnavailable
+	)
+	tests := []struct {
+		provider string
+		disabled []string
+		// scan, chat, summaries, search
+		want [4]string
+	}{
+		{BreakerClosed, nil, [4]string{full, full, full, full}},
+		{BreakerHalfOpen, nil, [4]string{full, full, full, full}},
+		{BreakerOpen, nil, [4]string{unavailable, degraded, degraded, full}},
+
+		{BreakerClosed, []string{FeatureScan}, [4]string{unavailable, full, full, full}},
+		{BreakerClosed, []string{FeatureChat}, [4]string{full, unavailable, full, full}},
+		{BreakerClosed, []string{FeatureSummaries}, [4]string{full, full, unavailable, full}},
+		{BreakerClosed, []string{FeatureSearch}, [4]string{full, full, full, unavailable}},
+
+		{BreakerOpen, []string{FeatureChat}, [4]string{unavailable, unavailable, degraded, full}},
+		{BreakerOpen, []string{FeatureSummaries}, [4]string{unavailable, degraded, unavailable, full}},
+		{BreakerOpen, []string{FeatureSearch}, [4]string{unavailable, degraded, degraded, unavailable}},
+		{BreakerHalfOpen, []string{FeatureScan, FeatureSearch}, [4]string{unavailable, full, full, unavailable}},
+		{BreakerOpen, Features, [4]string{unavailable, unavailable, unavailable, unavailable}},
+	}
+	for _, tt := range tests {
+		disabled := make(map[string]bool)
+		for _, feature := range tt.disabled {
+			disabled[feature] = true
+		}
+		got := computeCapabilities(CapabilityInputs{ProviderState: tt.provider, Disabled: disabled})
+		if len(got) != len(Features) {
+			t.Errorf("provider %s, disabled %v: %d features reported, want %d", tt.provider, tt.disabled, len(got), len(Features))
+		}
+		for i, feature := range Features {
+			if got[feature] != tt.want[i] {
+				t.Errorf("provider %s, disabled %v: %s = %s, want %s", tt.provider, tt.disabled, feature, got[feature], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	cb := NewCircuitBreaker(2, time.Minute)
+	cb.now = clock.Now
+	failure := errors.New("provider down")
+
+	cb.Record(failure)
+	if cb.State() != BreakerClosed {
+		t.Fatalf("state after one failure = %s, want closed", cb.State())
+	}
+	cb.Record(nil)
+	cb.Record(failure)
+	if cb.State() != BreakerClosed {
+		t.Fatalf("a success did not reset the failure count: %s", cb.State())
+	}
+	cb.Record(failure)
+	if cb.State() != BreakerOpen || cb.Allow() {
+		t.Fatalf("state after two failures in a row = %s, want open", cb.State())
+	}
+
+	clock.Advance(time.Minute)
+	if cb.State() != BreakerHalfOpen || !cb.Allow() {
+		t.Fatalf("state after the cooldown = %s, want half_open", cb.State())
+	}
+	cb.Record(failure)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("failed trial call left the breaker %s, want open", cb.State())
+	}
+
+	clock.Advance(time.Minute)
+	cb.Record(nil)
+	if cb.State() != BreakerClosed {
+		t.Fatalf("successful trial call left the breaker %s, want closed", cb.State())
+	}
+
+	disabled := NewCircuitBreaker(0, time.Minute)
+	for i := 0; i < 10; i++ {
+		disabled.Record(failure)
+	}
+	if disabled.State() != BreakerClosed {
+		t.Errorf("disabled breaker opened")
+	}
+}
+
+func TestCapabilityServiceFollowsTheBreaker(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{BreakerThreshold: 1, BreakerCooldown: 60})
+	as.provider = &fakeProvider{chatErr: errors.New("provider down")}
+	clock := &testClock{now: time.Now()}
+	cs := NewCapabilityService(as, NewFeatureFlags([]string{FeatureSearch}))
+	cs.now = clock.Now
+
+	if got := cs.Capabilities(); got[FeatureChat] != FidelityFull || got[FeatureSearch] != FidelityUnavailable {
+		t.Fatalf("initial capabilities = %v", got)
+	}
+
+	// One failed chat opens the breaker and is answered by the fallback
+	response, degraded, err := as.DoctorChat(context.Background(), "user-1", "conv-1", "hello")
+	if err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if !degraded || response != ruleBasedChatReply {
+		t.Errorf("DoctorChat() = %q, degraded %v; want the rule-based reply marked degraded", response, degraded)
+	}
+	if turns := conversationTurns(t, db, "conv-1"); len(turns) != 1 {
+		t.Errorf("stored %d turns for a degraded reply, want 1", len(turns))
+	}
+
+	if got := cs.Capabilities(); got[FeatureChat] != FidelityFull {
+		t.Errorf("capabilities recomputed within the cache TTL: %v", got)
+	}
+	clock.Advance(capabilityCacheTTL)
+	got := cs.Capabilities()
+	if got[FeatureScan] != FidelityUnavailable || got[FeatureChat] != FidelityDegraded || got[FeatureSummaries] != FidelityDegraded {
+		t.Errorf("capabilities with the breaker open = %v", got)
+	}
+}
+
+func TestDegradedResponsesWhileBreakerOpen(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{BreakerThreshold: 1, BreakerCooldown: 60})
+	provider := &fakeProvider{reply: "Rest and drink water.", scan: map[string]string{"medication": "Amoxicillin"}}
+	as.provider = provider
+	ctx := context.Background()
+
+	if response, degraded, err := as.DoctorChat(ctx, "user-1", "conv-1", "hello"); err != nil || degraded || response != provider.reply {
+		t.Fatalf("healthy DoctorChat() = %q, degraded %v, err %v", response, degraded, err)
+	}
+
+	as.breaker.Record(errors.New("provider down"))
+	chats := provider.chats
+	if _, degraded, err := as.DoctorChat(ctx, "user-1", "conv-1", "hello again"); err != nil || !degraded {
+		t.Errorf("DoctorChat with the breaker open: degraded %v, err %v", degraded, err)
+	}
+	if provider.chats != chats {
+		t.Error("open breaker let a chat through to the provider")
+	}
+
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	summary, err := as.SummarizeHealth(ctx, "user-1", 30, nil, nil)
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if !summary.Degraded {
+		t.Errorf("summary with the breaker open is not marked degraded: %+v", summary)
+	}
+
+	if _, err := as.ScanPrescription(ctx, "user-1", encodePNG(t, labelImage(640, 480)), ScanOptions{}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("ScanPrescription with the breaker open: error = %v, want %v", err, ErrUnavailable)
+	}
+	if provider.scans != 0 {
+		t.Error("open breaker let a scan through to the provider")
+	}
+}
+
+func TestFeatureFlagsSwitchFeaturesOff(t *testing.T) {
+	db := newTestDB(t)
+	as := NewAIService(db, &config.AIConfig{}, nil, nil, nil, nil, 0, NewFeatureFlags([]string{FeatureChat, FeatureSummaries}), nil)
+	as.provider = &fakeProvider{reply: "hi"}
+
+	if _, _, err := as.DoctorChat(context.Background(), "user-1", "conv-1", "hello"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("DoctorChat switched off: error = %v, want %v", err, ErrUnavailable)
+	}
+	if _, err := as.SummarizeHealth(context.Background(), "user-1", 30, nil, nil); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("SummarizeHealth switched off: error = %v, want %v", err, ErrUnavailable)
+	}
+
+	var flags *FeatureFlags
+	if !flags.Enabled(FeatureChat) {
+		t.Error("nil flags switched a feature off")
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ChangeActorSystem is the actor recorded for changes made by background
+// jobs rather than a person
+const ChangeActorSystem = "system"
+
+// maxSummaryFields bounds how many changed fields a summary names before
+// it falls back to "and N more"
+const maxSummaryFields = 5
+
+// recordChange appends a change event about record (or a medication on
+// it) inside tx, so the event commits or rolls back with the change
+func recordChange(tx *gorm.DB, actorID string, record *models.HealthRecord, entityType, entityID, kind, summary string) error {
+	event := models.ChangeEvent{
+		ID:          uuid.New().String(),
+		UserID:      record.UserID,
+		ActorID:     actorID,
+		EntityType:  entityType,
+		EntityID:    entityID,
+		RecordID:    record.ID,
+		Kind:        kind,
+		Summary:     summary,
+		Sensitivity: record.Sensitivity,
+		CreatedAt:   time.Now(),
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	return nil
+}
+
+// describeRecordChange names the fields that differ between two versions
+// of a record, e.g. "title changed, dosage updated". Only the field names
+// are given, never the old or new values.
+func describeRecordChange(before, after *models.HealthRecord) string {
+	var fields []string
+	if before.Title != after.Title {
+		fields = append(fields, "title changed")
+	}
+	if before.Description != after.Description {
+		fields = append(fields, "description changed")
+	}
+	if before.Sensitivity != after.Sensitivity {
+		fields = append(fields, "sharing changed")
+	}
+	fields = append(fields, describeMetadataChange(before.Metadata, after.Metadata)...)
+
+	if len(fields) == 0 {
+		return "no changes"
+	}
+	if len(fields) > maxSummaryFields {
+		more := len(fields) - maxSummaryFields
+		fields = append(fields[:maxSummaryFields], fmt.Sprintf("and %d more", more))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// describeMetadataChange lists added, updated and removed metadata keys in
+// key order. Undecodable metadata counts as empty.
+func describeMetadataChange(before, after string) []string {
+	var old, cur map[string]string
+	_ = json.Unmarshal([]byte(before), &old)
+	_ = json.Unmarshal([]byte(after), &cur)
+
+	keys := make(map[string]bool, len(old)+len(cur))
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range cur {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var fields []string
+	for _, k := range sorted {
+		oldValue, hadOld := old[k]
+		newValue, hasNew := cur[k]
+		name := strings.ReplaceAll(k, "_", " ")
+		switch {
+		case !hadOld:
+			fields = append(fields, name+" added")
+		case !hasNew:
+			fields = append(fields, name+" removed")
+		case oldValue != newValue:
+			fields = append(fields, name+" updated")
+		}
+	}
+	return fields
+}
+
+// ChangePage is one page of a change feed, oldest first. Cursor is the
+// position after the last event returned; clients keep it and pass it on
+// their next sync. It is empty only when the page is empty, in which case
+// the client keeps the cursor it had.
+type ChangePage struct {
+	Events  []models.ChangeEvent
+	Cursor  string
+	HasMore bool
+}
+
+// listChanges pages through the change events query selects, oldest
+// first, starting after cursor or, without one, at since. Paging is
+// keyset-based on (created_at, id).
+func listChanges(query *gorm.DB, since time.Time, cursor string, limit int) (*ChangePage, error) {
+	limit, _ = pageBounds(limit, 0)
+
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at > ? OR (created_at = ? AND id > ?)", createdAt, createdAt, id)
+	} else if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+
+	var events []models.ChangeEvent
+	if err := query.Order("created_at ASC, id ASC").Limit(limit + 1).Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to list changes: %w", err)
+	}
+
+	page := &ChangePage{Events: events}
+	if len(events) > limit {
+		page.Events = events[:limit]
+		page.HasMore = true
+	}
+	if n := len(page.Events); n > 0 {
+		last := page.Events[n-1]
+		page.Cursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return page, nil
+}
+
+// ListChanges returns the changes to the user's own data, oldest first
+func (hrs *HealthRecordsService) ListChanges(ctx context.Context, userID string, since time.Time, cursor string, limit int) (*ChangePage, error) {
+	return listChanges(hrs.db.WithContext(ctx).Model(&models.ChangeEvent{}).Scopes(scopeOwner(userID)), since, cursor, limit)
+}
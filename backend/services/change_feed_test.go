@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+)
+
+func TestDescribeRecordChange(t *testing.T) {
+	base := models.HealthRecord{
+		Title:       "Amoxicillin",
+		Description: "Antibiotic",
+		Sensitivity: models.SensitivityStandard,
+		Metadata:    `{"dosage":"500mg","frequency":"twice daily","notes":"with food"}`,
+	}
+	tests := []struct {
+		name   string
+		change func(r *models.HealthRecord)
+		want   string
+	}{
+		{"nothing", func(r *models.HealthRecord) {}, "no changes"},
+		{"title", func(r *models.HealthRecord) { r.Title = "Amoxil" }, "title changed"},
+		{"description", func(r *models.HealthRecord) { r.Description = "" }, "description changed"},
+		{"sharing", func(r *models.HealthRecord) { r.Sensitivity = models.SensitivityBlocked }, "sharing changed"},
+		{"metadata value", func(r *models.HealthRecord) {
+			r.Metadata = `{"dosage":"250mg","frequency":"twice daily","notes":"with food"}`
+		}, "dosage updated"},
+		{"metadata keys", func(r *models.HealthRecord) {
+			r.Metadata = `{"dosage":"500mg","frequency":"twice daily","refill_date":"2026-11-01"}`
+		}, "notes removed, refill date added"},
+		{"title and metadata", func(r *models.HealthRecord) {
+			r.Title = "Amoxil"
+			r.Metadata = `{"dosage":"250mg","frequency":"twice daily","notes":"with food"}`
+		}, "title changed, dosage updated"},
+		{"many fields", func(r *models.HealthRecord) {
+			r.Title, r.Description, r.Sensitivity = "a", "b", models.SensitivitySensitive
+			r.Metadata = `{"dosage":"1","frequency":"2","notes":"3"}`
+		}, "title changed, description changed, sharing changed, dosage updated, frequency updated, and 1 more"},
+		{"undecodable metadata", func(r *models.HealthRecord) { r.Metadata = "not json" },
+			"dosage removed, frequency removed, notes removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after := base
+			tt.change(&after)
+			if got := describeRecordChange(&base, &after); got != tt.want {
+				t.Errorf("describeRecordChange() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// changeKinds lists the entity and kind of each event, oldest first
+func changeKinds(events []models.ChangeEvent) []string {
+	kinds := make([]string, len(events))
+	for i, event := range events {
+		kinds[i] = event.EntityType + " " + event.Kind
+	}
+	return kinds
+}
+
+func TestMutationsAppearInTheChangeFeed(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Now()}
+	hrs := newTestRecordsService(db, nil)
+	ms := newTestMedicationService(db, clock)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	ctx := context.Background()
+
+	record, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Blood panel", "", map[string]string{"glucose": "90"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if _, err := hrs.UpdateRecord(ctx, "user-1", record.ID, "Blood panel", "Fasting", map[string]string{"glucose": "95"}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if _, err := hrs.SetRecordSensitivity(ctx, "user-1", record.ID, models.SensitivitySensitive); err != nil {
+		t.Fatalf("SetRecordSensitivity: %v", err)
+	}
+	medication, _, err := ms.ConfirmMedicationSetup(ctx, "user-1", MedicationSetup{
+		Name:       "Amoxicillin",
+		Dosage:     "500mg",
+		TimesOfDay: []string{"08:00", "20:00"},
+		CourseDays: 5,
+	})
+	if err != nil {
+		t.Fatalf("ConfirmMedicationSetup: %v", err)
+	}
+	if err := hrs.DeleteRecord(ctx, "user-1", medication.RecordID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+
+	// Another user's failed edit leaves no trace
+	if _, err := hrs.UpdateRecord(ctx, "user-2", record.ID, "Mine now", "", nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("cross-user UpdateRecord: error = %v, want %v", err, ErrNotFound)
+	}
+
+	page, err := hrs.ListChanges(ctx, "user-1", time.Time{}, "", 50)
+	if err != nil {
+		t.Fatalf("ListChanges: %v", err)
+	}
+	want := []struct{ kind, summary string }{
+		{"record created", "lab result added"},
+		{"record updated", "description changed, glucose updated"},
+		{"record updated", "sharing changed"},
+		{"record created", "prescription added"},
+		{"medication started", "2 doses a day"},
+		{"medication stopped", "prescription deleted"},
+		{"record deleted", "prescription deleted"},
+	}
+	kinds := changeKinds(page.Events)
+	if len(page.Events) != len(want) {
+		t.Fatalf("change feed = %v, want %d events", kinds, len(want))
+	}
+	for i, w := range want {
+		event := page.Events[i]
+		if kinds[i] != w.kind || event.Summary != w.summary {
+			t.Errorf("event %d = %s %q, want %s %q", i, kinds[i], event.Summary, w.kind, w.summary)
+		}
+		if event.UserID != "user-1" || event.ActorID != "user-1" {
+			t.Errorf("event %d for %s by %s, want user-1's own change", i, event.UserID, event.ActorID)
+		}
+	}
+	if page.Events[1].Sensitivity != models.SensitivitySensitive {
+		t.Error("earlier events did not follow the record's new sensitivity")
+	}
+
+	if other, err := hrs.ListChanges(ctx, "user-2", time.Time{}, "", 50); err != nil || len(other.Events) != 0 {
+		t.Errorf("user-2's feed = %v, %v; want nothing", changeKinds(other.Events), err)
+	}
+}
+
+func TestListChangesPagesForSync(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		event := models.ChangeEvent{
+			ID:         fmt.Sprintf("change-%02d", i),
+			UserID:     "user-1",
+			EntityType: models.ChangeEntityRecord,
+			Kind:       models.ChangeKindUpdated,
+			// Pairs share a timestamp, so paging breaks ties on ID
+			CreatedAt: start.Add(time.Duration(i/2) * time.Minute),
+		}
+		if err := db.Create(&event).Error; err != nil {
+			t.Fatalf("create event: %v", err)
+		}
+	}
+	ctx := context.Background()
+
+	var seen []string
+	cursor := ""
+	for page := 0; ; page++ {
+		got, err := hrs.ListChanges(ctx, "user-1", time.Time{}, cursor, 10)
+		if err != nil {
+			t.Fatalf("ListChanges: %v", err)
+		}
+		for _, event := range got.Events {
+			seen = append(seen, event.ID)
+		}
+		if !got.HasMore {
+			break
+		}
+		if page > 5 {
+			t.Fatal("paging never ended")
+		}
+		cursor = got.Cursor
+	}
+	if len(seen) != 25 {
+		t.Fatalf("synced %d events, want 25", len(seen))
+	}
+	for i, id := range seen {
+		if want := fmt.Sprintf("change-%02d", i); id != want {
+			t.Fatalf("event %d = %s, want %s", i, id, want)
+		}
+	}
+
+	since, err := hrs.ListChanges(ctx, "user-1", start.Add(10*time.Minute), "", 10)
+	if err != nil {
+		t.Fatalf("ListChanges since: %v", err)
+	}
+	if ids := len(since.Events); ids != 5 || since.Events[0].ID != "change-20" {
+		t.Errorf("changes since 00:10 = %d starting %v, want 5 from change-20", ids, since.Events)
+	}
+
+	// The last cursor picks up only what happened after it
+	last, err := hrs.ListChanges(ctx, "user-1", time.Time{}, cursor, 10)
+	if err != nil {
+		t.Fatalf("ListChanges: %v", err)
+	}
+	db.Create(&models.ChangeEvent{ID: "change-new", UserID: "user-1", CreatedAt: start.Add(time.Hour)})
+	next, err := hrs.ListChanges(ctx, "user-1", time.Time{}, last.Cursor, 10)
+	if err != nil {
+		t.Fatalf("ListChanges: %v", err)
+	}
+	if len(next.Events) != 1 || next.Events[0].ID != "change-new" || next.HasMore {
+		t.Errorf("sync after the last cursor = %v", next.Events)
+	}
+	empty, err := hrs.ListChanges(ctx, "user-1", time.Time{}, next.Cursor, 10)
+	if err != nil || len(empty.Events) != 0 || empty.Cursor != "" {
+		t.Errorf("sync with nothing new = %v, cursor %q, %v", empty.Events, empty.Cursor, err)
+	}
+
+	if _, err := hrs.ListChanges(ctx, "user-1", time.Time{}, "garbage!", 10); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("malformed cursor: error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestListPatientChangesFollowsTheGrant(t *testing.T) {
+	f := newOrgFixture(t)
+	hrs := newTestRecordsService(f.db, nil)
+	ctx := context.Background()
+	for _, patient := range []string{f.patientA, f.patientNoConsent} {
+		for _, suffix := range []string{"-standard", "-sensitive", "-blocked"} {
+			if _, err := hrs.UpdateRecord(ctx, patient, patient+suffix, "Edited", "", nil); err != nil {
+				t.Fatalf("UpdateRecord: %v", err)
+			}
+		}
+	}
+
+	page, err := f.ors.ListPatientChanges(ctx, f.staffA, f.orgA, f.patientA, time.Time{}, "", 50)
+	if err != nil {
+		t.Fatalf("ListPatientChanges: %v", err)
+	}
+	if len(page.Events) != 2 {
+		t.Fatalf("staff see %d events, want the standard and sensitive records' edits", len(page.Events))
+	}
+	for _, event := range page.Events {
+		switch event.RecordID {
+		case f.patientA + "-standard":
+			if event.Summary == "" {
+				t.Error("summary of a standard record's change was hidden")
+			}
+		case f.patientA + "-sensitive":
+			if event.Summary != "" {
+				t.Errorf("summary of a sensitive record's change shown: %q", event.Summary)
+			}
+		default:
+			t.Errorf("staff see a change to %s", event.RecordID)
+		}
+	}
+
+	denied := []struct {
+		name                string
+		staff, org, patient string
+	}{
+		{"staff of another org", f.staffB, f.orgA, f.patientA},
+		{"patient of another org", f.staffA, f.orgA, f.patientB},
+		{"no consent", f.staffA, f.orgA, f.patientNoConsent},
+		{"not staff", f.patientB, f.orgA, f.patientA},
+	}
+	for _, tt := range denied {
+		if _, err := f.ors.ListPatientChanges(ctx, tt.staff, tt.org, tt.patient, time.Time{}, "", 50); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, ErrPermissionDenied)
+		}
+	}
+
+	// Blocking a record hides its history too
+	if _, err := hrs.SetRecordSensitivity(ctx, f.patientA, f.patientA+"-standard", models.SensitivityBlocked); err != nil {
+		t.Fatalf("SetRecordSensitivity: %v", err)
+	}
+	page, err = f.ors.ListPatientChanges(ctx, f.staffA, f.orgA, f.patientA, time.Time{}, "", 50)
+	if err != nil {
+		t.Fatalf("ListPatientChanges: %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].RecordID != f.patientA+"-sensitive" {
+		t.Errorf("after blocking, staff see %v", changeKinds(page.Events))
+	}
+
+	if err := f.ors.RevokeConsent(ctx, f.patientA, f.orgA); err != nil {
+		t.Fatalf("RevokeConsent: %v", err)
+	}
+	if _, err := f.ors.ListPatientChanges(ctx, f.staffA, f.orgA, f.patientA, time.Time{}, "", 50); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("after revoking consent: error = %v, want %v", err, ErrPermissionDenied)
+	}
+}
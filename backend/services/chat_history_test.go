@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// historyProvider takes the earlier turns of a chat as separate messages
+type historyProvider struct {
+	fakeProvider
+	history []ChatMessage
+	message string
+}
+
+func (hp *historyProvider) DoctorChatWithHistory(ctx context.Context, history []ChatMessage, message string) (string, error) {
+	hp.history, hp.message = history, message
+	return hp.fakeProvider.DoctorChat(ctx, message)
+}
+
+// streamingHistoryProvider streams its replies, taking the earlier turns
+// as separate messages
+type streamingHistoryProvider struct {
+	fakeProvider
+	history []ChatMessage
+	message string
+}
+
+func (sp *streamingHistoryProvider) StreamDoctorChat(ctx context.Context, history []ChatMessage, message string, onChunk func(chunk string)) (string, error) {
+	sp.history, sp.message = history, message
+	reply, err := sp.fakeProvider.DoctorChat(ctx, message)
+	onChunk(reply)
+	return reply, err
+}
+
+func TestDoctorChatSendsTheEarlierTurns(t *testing.T) {
+	const message = "What should I do?"
+	wantHistory := []ChatMessage{
+		{Role: ChatRoleUser, Content: "I have a headache"},
+		{Role: ChatRoleAssistant, Content: "reply to I have a headache"},
+		{Role: ChatRoleUser, Content: "It started Monday"},
+		{Role: ChatRoleAssistant, Content: "reply to It started Monday"},
+	}
+	wantPrompt := "Patient: I have a headache\n\nAssistant: reply to I have a headache\n\n" +
+		"Patient: It started Monday\n\nAssistant: reply to It started Monday\n\n" +
+		"Patient: " + message
+
+	tests := []struct {
+		name     string
+		provider AIProvider
+		onChunk  func(string)
+		// sent returns the history and message the provider was given
+		sent        func(p AIProvider) ([]ChatMessage, string)
+		wantHistory []ChatMessage
+		wantMessage string
+	}{
+		{
+			name:     "streaming",
+			provider: &streamingHistoryProvider{fakeProvider: fakeProvider{reply: "Rest and drink water."}},
+			onChunk:  func(string) {},
+			sent: func(p AIProvider) ([]ChatMessage, string) {
+				sp := p.(*streamingHistoryProvider)
+				return sp.history, sp.message
+			},
+			wantHistory: wantHistory,
+			wantMessage: message,
+		},
+		{
+			name:     "conversational",
+			provider: &historyProvider{fakeProvider: fakeProvider{reply: "Rest and drink water."}},
+			sent: func(p AIProvider) ([]ChatMessage, string) {
+				hp := p.(*historyProvider)
+				return hp.history, hp.message
+			},
+			wantHistory: wantHistory,
+			wantMessage: message,
+		},
+		{
+			name:     "single prompt",
+			provider: &promptProvider{fakeProvider: fakeProvider{reply: "Rest and drink water."}},
+			sent: func(p AIProvider) ([]ChatMessage, string) {
+				pp := p.(*promptProvider)
+				if len(pp.prompts) == 0 {
+					return nil, ""
+				}
+				return nil, pp.prompts[len(pp.prompts)-1]
+			},
+			// The history is written into the prompt instead
+			wantMessage: wantPrompt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			as := newTestAIService(t, db, &config.AIConfig{ChatHistoryTurns: 10})
+			as.provider = tt.provider
+			start := time.Now().Add(-time.Hour)
+			storeTurns(t, db, "user-1", "conv-1", start, "I have a headache", "It started Monday")
+			// Turns of another conversation and of another user's
+			// conversation with the same ID stay out
+			storeTurns(t, db, "user-1", "conv-2", start, "Another conversation")
+			storeTurns(t, db, "user-2", "conv-1", start.Add(time.Second), "Another user")
+
+			if _, degraded, err := as.StreamDoctorChat(context.Background(), "user-1", "conv-1", message, tt.onChunk); err != nil || degraded {
+				t.Fatalf("StreamDoctorChat: degraded %t, %v", degraded, err)
+			}
+
+			history, sent := tt.sent(tt.provider)
+			if !reflect.DeepEqual(history, tt.wantHistory) {
+				t.Errorf("history = %+v, want %+v", history, tt.wantHistory)
+			}
+			if sent != tt.wantMessage {
+				t.Errorf("message =\n%s\nwant\n%s", sent, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// Tool calling for doctor chat. Providers that support it implement
+// ToolCallingProvider and translate between their native tool format and
+// the ToolSpec, ToolCall and ToolResult types here, so AIService runs the
+// same tools whatever the provider. Tools always act as the chatting user:
+// they take no user argument and read through scopeOwner, so a model
+// cannot reach anyone else's data. Blocked records are left out too, since
+// the provider is not the owner.
+
+// Chat tool names
+const (
+	ToolListRecentRecords = "list_recent_records"
+	ToolGetHealthSummary  = "get_health_summary"
+)
+
+const (
+	// maxToolRecords bounds how many records list_recent_records returns
+	maxToolRecords = 20
+	// maxToolSummaryDays bounds the period get_health_summary covers
+	maxToolSummaryDays = 365
+)
+
+// ToolSpec describes a tool to the provider
+type ToolSpec struct {
+	Name        string
+	Description string
+	Parameters  map[string]string // argument name -> description; all optional
+}
+
+// ToolCall is the provider asking for a tool to run
+type ToolCall struct {
+	ID        string // provider's call ID, echoed in the result
+	Name      string
+	Arguments map[string]string
+}
+
+// ToolResult is the output of a tool call fed back to the provider
+type ToolResult struct {
+	CallID  string
+	Name    string
+	Content string
+	IsError bool
+}
+
+// ChatTurn is one provider response in a tool-calling chat: either a reply
+// or tool calls to run before the provider continues
+type ChatTurn struct {
+	Reply     string
+	ToolCalls []ToolCall
+}
+
+// ToolCallingProvider is implemented by providers that can call tools.
+// ChatWithTools is given the user's message, the tools on offer, and the
+// results of every tool call made so far in this turn. With no tools on
+// offer the provider must reply.
+type ToolCallingProvider interface {
+	ChatWithTools(ctx context.Context, message string, tools []ToolSpec, results []ToolResult) (*ChatTurn, error)
+}
+
+// chatTool is a tool AIService can run for a user
+type chatTool struct {
+	spec ToolSpec
+	run  func(ctx context.Context, db *gorm.DB, types *RecordTypes, userID string, args map[string]string) (string, error)
+}
+
+var chatTools = map[string]chatTool{
+	ToolListRecentRecords: {
+		spec: ToolSpec{
+			Name:        ToolListRecentRecords,
+			Description: "List the user's most recent health records, newest first.",
+			Parameters: map[string]string{
+				"limit":       fmt.Sprintf("number of records, 1 to %d (default 10)", maxToolRecords),
+				"record_type": "only records of this type",
+			},
+		},
+		run: runListRecentRecords,
+	},
+	ToolGetHealthSummary: {
+		spec: ToolSpec{
+			Name:        ToolGetHealthSummary,
+			Description: "Count the user's health records by type over a recent period.",
+			Parameters: map[string]string{
+				"days": fmt.Sprintf("period in days, 1 to %d (default 30)", maxToolSummaryDays),
+			},
+		},
+		run: runGetHealthSummary,
+	},
+}
+
+// enabledChatTools returns the specs of the configured tools that exist,
+// in name order. A record_type parameter lists the types the deployment
+// accepts, custom ones included.
+func enabledChatTools(names []string, types *RecordTypes) []ToolSpec {
+	var specs []ToolSpec
+	for _, name := range names {
+		tool, ok := chatTools[name]
+		if !ok {
+			continue
+		}
+		spec := tool.spec
+		if description, ok := spec.Parameters["record_type"]; ok {
+			spec.Parameters = make(map[string]string, len(tool.spec.Parameters))
+			for param, text := range tool.spec.Parameters {
+				spec.Parameters[param] = text
+			}
+			spec.Parameters["record_type"] = description + ": " + strings.Join(types.Names(), ", ")
+		}
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// runChatTool runs one call for userID. Unknown or disabled tools and bad
+// arguments come back as error results for the model to read, not as Go
+// errors, so a confused model cannot end the chat.
+func runChatTool(ctx context.Context, db *gorm.DB, types *RecordTypes, userID string, enabled []ToolSpec, call ToolCall) ToolResult {
+	result := ToolResult{CallID: call.ID, Name: call.Name}
+
+	allowed := false
+	for _, spec := range enabled {
+		allowed = allowed || spec.Name == call.Name
+	}
+	tool, ok := chatTools[call.Name]
+	if !ok || !allowed {
+		result.Content, result.IsError = fmt.Sprintf("unknown tool %q", call.Name), true
+		return result
+	}
+
+	content, err := tool.run(ctx, db, types, userID, call.Arguments)
+	if err != nil {
+		result.Content, result.IsError = err.Error(), true
+		return result
+	}
+	result.Content = content
+	return result
+}
+
+func runListRecentRecords(ctx context.Context, db *gorm.DB, types *RecordTypes, userID string, args map[string]string) (string, error) {
+	limit, err := toolIntArg(args, "limit", 10, 1, maxToolRecords)
+	if err != nil {
+		return "", err
+	}
+
+	query := db.WithContext(ctx).Scopes(scopeOwner(userID), scopeNotBlocked())
+	if recordType := args["record_type"]; recordType != "" {
+		if err := types.validate(recordType); err != nil {
+			return "", err
+		}
+		query = query.Where("record_type = ?", recordType)
+	}
+
+	var records []models.HealthRecord
+	if err := query.Select("id, record_type, title, description, created_at").
+		Order("created_at DESC").Limit(limit).Find(&records).Error; err != nil {
+		return "", fmt.Errorf("failed to list records: %w", err)
+	}
+	if len(records) == 0 {
+		return "No records found.", nil
+	}
+
+	lines := make([]string, len(records))
+	for i, record := range records {
+		line := fmt.Sprintf("- %s %s: %s", record.CreatedAt.Format("2006-01-02"), record.RecordType, record.Title)
+		if record.Description != "" {
+			line += " (" + truncateRunes(record.Description, recordPreviewLength) + ")"
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func runGetHealthSummary(ctx context.Context, db *gorm.DB, _ *RecordTypes, userID string, args map[string]string) (string, error) {
+	days, err := toolIntArg(args, "days", 30, 1, maxToolSummaryDays)
+	if err != nil {
+		return "", err
+	}
+
+	type typeCount struct {
+		RecordType string
+		Count      int
+	}
+	var counts []typeCount
+	if err := db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Scopes(scopeOwner(userID), scopeNotBlocked()).
+		Where("created_at > ?", time.Now().AddDate(0, 0, -days)).
+		Select("record_type, COUNT(*) AS count").Group("record_type").Order("record_type").
+		Scan(&counts).Error; err != nil {
+		return "", fmt.Errorf("failed to count records: %w", err)
+	}
+
+	total := 0
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		total += c.Count
+		parts[i] = fmt.Sprintf("%d %s", c.Count, c.RecordType)
+	}
+	if total == 0 {
+		return fmt.Sprintf("No records in the last %d days.", days), nil
+	}
+	return fmt.Sprintf("%d records in the last %d days: %s.", total, days, strings.Join(parts, ", ")), nil
+}
+
+// toolIntArg reads an optional integer argument within [lo, hi]
+func toolIntArg(args map[string]string, name string, def, lo, hi int) (int, error) {
+	raw := strings.TrimSpace(args[name])
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%s must be a whole number from %d to %d", name, lo, hi)
+	}
+	return n, nil
+}
+
+// chatWithTools runs a tool-calling chat turn: it offers the enabled
+// tools, runs the calls the provider makes, and feeds their results back
+// until the provider replies. After MaxToolRounds rounds of calls the tools
+// are withdrawn so the provider has to answer.
+func (as *AIService) chatWithTools(ctx context.Context, route *providerRoute, provider ToolCallingProvider, userID, message string) (string, error) {
+	tools := enabledChatTools(as.config.ChatTools, as.recordTypes)
+	var results []ToolResult
+	for round := 0; ; round++ {
+		offered := tools
+		if round >= as.config.MaxToolRounds {
+			offered = nil
+		}
+
+		var turn *ChatTurn
+		err := route.call(func() error {
+			var err error
+			turn, err = provider.ChatWithTools(ctx, message, offered, results)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(turn.ToolCalls) == 0 || offered == nil {
+			if err := checkReply(route.provider.Name(), turn.Reply); err != nil {
+				return "", err
+			}
+			return turn.Reply, nil
+		}
+
+		for _, call := range turn.ToolCalls {
+			results = append(results, runChatTool(ctx, as.db, as.recordTypes, userID, offered, call))
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// toolProvider is a fakeProvider that can call tools. Each ChatWithTools
+// call takes the next turn from script; with no tools offered, or once the
+// script runs out, it replies with reply. Every call's tools and results
+// are kept for inspection.
+type toolProvider struct {
+	fakeProvider
+	script []ChatTurn
+
+	offered [][]ToolSpec
+	results [][]ToolResult
+}
+
+func (tp *toolProvider) ChatWithTools(ctx context.Context, message string, tools []ToolSpec, results []ToolResult) (*ChatTurn, error) {
+	tp.offered = append(tp.offered, tools)
+	tp.results = append(tp.results, append([]ToolResult(nil), results...))
+	if len(tools) == 0 || len(tp.script) == 0 {
+		return &ChatTurn{Reply: tp.reply}, nil
+	}
+	turn := tp.script[0]
+	tp.script = tp.script[1:]
+	return &turn, nil
+}
+
+// newToolChatService returns an AIService offering both chat tools to
+// provider, with records for two users
+func newToolChatService(t *testing.T, provider *toolProvider, maxRounds int) (*AIService, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	now := time.Now()
+	for i, record := range []struct{ id, user, sensitivity string }{
+		{"rec-oldest", "user-1", models.SensitivityStandard},
+		{"rec-blocked", "user-1", models.SensitivityBlocked},
+		{"rec-sensitive", "user-1", models.SensitivitySensitive},
+		{"rec-newest", "user-1", models.SensitivityStandard},
+		{"rec-other-user", "user-2", models.SensitivityStandard},
+	} {
+		createRecord(t, db, record.id, record.user, record.sensitivity)
+		db.Model(&models.HealthRecord{}).Where("id = ?", record.id).Update("created_at", now.Add(time.Duration(i-10)*time.Hour))
+	}
+
+	as := newTestAIService(t, db, &config.AIConfig{
+		ChatTools:     []string{ToolListRecentRecords, ToolGetHealthSummary},
+		MaxToolRounds: maxRounds,
+	})
+	as.provider = provider
+	return as, db
+}
+
+func TestDoctorChatToolRoundTrip(t *testing.T) {
+	provider := &toolProvider{
+		fakeProvider: fakeProvider{reply: "You have three recent records."},
+		script: []ChatTurn{{ToolCalls: []ToolCall{
+			{ID: "call-1", Name: ToolListRecentRecords, Arguments: map[string]string{"limit": "5", "user_id": "user-2"}},
+			{ID: "call-2", Name: ToolGetHealthSummary, Arguments: map[string]string{"days": "7"}},
+		}}},
+	}
+	as, db := newToolChatService(t, provider, 3)
+
+	reply, degraded, err := as.DoctorChat(context.Background(), "user-1", "conv-1", "What's in my records?")
+	if err != nil || degraded {
+		t.Fatalf("DoctorChat = degraded %v, err %v", degraded, err)
+	}
+	if reply != provider.reply {
+		t.Errorf("reply = %q, want the provider's final reply", reply)
+	}
+	if len(provider.offered) != 2 {
+		t.Fatalf("provider called %d times, want 2", len(provider.offered))
+	}
+	if names := []string{provider.offered[0][0].Name, provider.offered[0][1].Name}; names[0] != ToolGetHealthSummary || names[1] != ToolListRecentRecords {
+		t.Errorf("offered tools %v", names)
+	}
+
+	results := provider.results[1]
+	if len(results) != 2 || results[0].CallID != "call-1" || results[1].CallID != "call-2" {
+		t.Fatalf("results fed back = %+v", results)
+	}
+	listed := results[0].Content
+	if results[0].IsError {
+		t.Fatalf("list_recent_records failed: %s", listed)
+	}
+	// Newest first, the owner's own records only, and never blocked ones,
+	// whatever user the model asked for
+	if strings.Index(listed, "Record rec-newest") > strings.Index(listed, "Record rec-oldest") ||
+		!strings.Contains(listed, "Record rec-sensitive") {
+		t.Errorf("listed records:\n%s", listed)
+	}
+	for _, hidden := range []string{"rec-blocked", "rec-other-user"} {
+		if strings.Contains(listed, hidden) {
+			t.Errorf("tool returned %s:\n%s", hidden, listed)
+		}
+	}
+	if got := results[1].Content; got != "3 records in the last 7 days: 3 lab_result." {
+		t.Errorf("get_health_summary = %q", got)
+	}
+
+	if turns := conversationTurns(t, db, "conv-1"); len(turns) != 1 || turns[0].Response != provider.reply {
+		t.Errorf("stored turns = %+v", turns)
+	}
+}
+
+func TestDoctorChatToolErrorsGoBackToTheModel(t *testing.T) {
+	provider := &toolProvider{
+		fakeProvider: fakeProvider{reply: "Sorry, I could not look that up."},
+		script: []ChatTurn{{ToolCalls: []ToolCall{
+			{ID: "call-1", Name: "delete_all_records"},
+			{ID: "call-2", Name: ToolListRecentRecords, Arguments: map[string]string{"limit": "500"}},
+			{ID: "call-3", Name: ToolListRecentRecords, Arguments: map[string]string{"record_type": "no_such_type"}},
+			{ID: "call-4", Name: ToolGetHealthSummary, Arguments: map[string]string{"days": "soon"}},
+		}}},
+	}
+	as, _ := newToolChatService(t, provider, 3)
+
+	reply, _, err := as.DoctorChat(context.Background(), "user-1", "conv-1", "Tidy up my records")
+	if err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if reply != provider.reply {
+		t.Errorf("reply = %q", reply)
+	}
+	results := provider.results[len(provider.results)-1]
+	if len(results) != 4 {
+		t.Fatalf("results fed back = %+v", results)
+	}
+	for _, result := range results {
+		if !result.IsError || result.Content == "" {
+			t.Errorf("call %s (%s) = %+v, want an error result", result.CallID, result.Name, result)
+		}
+	}
+	if !strings.Contains(results[0].Content, `unknown tool "delete_all_records"`) {
+		t.Errorf("unknown tool result = %q", results[0].Content)
+	}
+}
+
+func TestDoctorChatWithdrawsToolsAfterMaxRounds(t *testing.T) {
+	call := ChatTurn{ToolCalls: []ToolCall{{ID: "again", Name: ToolGetHealthSummary}}}
+	provider := &toolProvider{
+		fakeProvider: fakeProvider{reply: "Here is my answer."},
+		script:       []ChatTurn{call, call, call, call, call},
+	}
+	as, _ := newToolChatService(t, provider, 2)
+
+	reply, _, err := as.DoctorChat(context.Background(), "user-1", "conv-1", "Keep looking")
+	if err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	if reply != provider.reply {
+		t.Errorf("reply = %q", reply)
+	}
+	if len(provider.offered) != 3 || provider.offered[2] != nil {
+		t.Errorf("provider called %d times; tools on the last call %v; want tools withdrawn on the third", len(provider.offered), provider.offered[len(provider.offered)-1])
+	}
+	if got := len(provider.results[2]); got != 2 {
+		t.Errorf("final call saw %d results, want both rounds", got)
+	}
+}
+
+func TestDoctorChatWithoutToolsConfigured(t *testing.T) {
+	provider := &toolProvider{fakeProvider: fakeProvider{reply: "Plain reply."}}
+	as, _ := newToolChatService(t, provider, 3)
+	as.config.ChatTools = nil
+
+	if reply, _, err := as.DoctorChat(context.Background(), "user-1", "conv-1", "hello"); err != nil || reply != "Plain reply." {
+		t.Fatalf("DoctorChat = %q, %v", reply, err)
+	}
+	if len(provider.offered) != 0 || provider.chats != 1 {
+		t.Errorf("tool chat used %d times, plain chat %d; want plain chat only", len(provider.offered), provider.chats)
+	}
+}
+
+func TestEnabledChatTools(t *testing.T) {
+	types, err := NewRecordTypes(nil)
+	if err != nil {
+		t.Fatalf("NewRecordTypes: %v", err)
+	}
+	specs := enabledChatTools([]string{ToolListRecentRecords, "nonexistent", ToolGetHealthSummary}, types)
+	if len(specs) != 2 || specs[0].Name != ToolGetHealthSummary || specs[1].Name != ToolListRecentRecords {
+		t.Fatalf("enabledChatTools() = %+v", specs)
+	}
+	if desc := specs[1].Parameters["record_type"]; !strings.Contains(desc, "lab_result") || !strings.Contains(desc, "prescription") {
+		t.Errorf("record_type parameter = %q, want the accepted types listed", desc)
+	}
+	if strings.Contains(chatTools[ToolListRecentRecords].spec.Parameters["record_type"], "lab_result") {
+		t.Error("enabledChatTools changed the shared tool spec")
+	}
+}
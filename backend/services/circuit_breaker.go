@@ -0,0 +1,71 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // calls go through
+	BreakerOpen     = "open"      // calls are refused until the cooldown ends
+	BreakerHalfOpen = "half_open" // cooldown over; the next call decides
+)
+
+// CircuitBreaker stops calls to a failing dependency. It opens after
+// threshold consecutive failures and, once cooldown has passed, lets calls
+// through again: a success closes it and a failure reopens it.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+// NewCircuitBreaker returns a closed breaker. A threshold of 0 disables it.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// State returns the breaker's current state
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state()
+}
+
+func (cb *CircuitBreaker) state() string {
+	if cb.threshold <= 0 || cb.failures < cb.threshold {
+		return BreakerClosed
+	}
+	if cb.now().Sub(cb.openedAt) < cb.cooldown {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// Allow reports whether a call may be made now
+func (cb *CircuitBreaker) Allow() bool {
+	return cb.State() != BreakerOpen
+}
+
+// Record reports the outcome of a call that Allow let through
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.threshold > 0 && cb.failures >= cb.threshold {
+		cb.openedAt = cb.now()
+	}
+}
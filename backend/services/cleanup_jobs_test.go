@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// seedOTPs stores sign-in codes created at the given ages before now, each
+// valid for ten minutes
+func seedOTPs(t *testing.T, db *gorm.DB, now time.Time, ages map[string]time.Duration) {
+	t.Helper()
+	for id, age := range ages {
+		created := now.Add(-age)
+		otp := models.OTPStore{ID: id, Email: "reader@example.com", OTPHash: "hash", CreatedAt: created, ExpiresAt: created.Add(10 * time.Minute)}
+		if err := db.Create(&otp).Error; err != nil {
+			t.Fatalf("store %s: %v", id, err)
+		}
+	}
+}
+
+// seedConversation stores a conversation with one turn at each of the
+// given ages before now, and its summary
+func seedConversation(t *testing.T, db *gorm.DB, now time.Time, userID, conversationID string, ages ...time.Duration) {
+	t.Helper()
+	for i, age := range ages {
+		turn := models.DoctorConversation{
+			ID:             userID + "-" + conversationID + "-" + string(rune('a'+i)),
+			UserID:         userID,
+			ConversationID: conversationID,
+			Message:        "How am I doing?",
+			Response:       "Fine.",
+			IsAI:           true,
+			CreatedAt:      now.Add(-age),
+		}
+		if err := db.Create(&turn).Error; err != nil {
+			t.Fatalf("store turn: %v", err)
+		}
+	}
+	if err := db.Create(&models.ConversationSummary{ConversationID: conversationID, UserID: userID, Summary: "Doing fine", Turns: len(ages)}).Error; err != nil {
+		t.Fatalf("store summary: %v", err)
+	}
+}
+
+func pendingOTPIDs(db *gorm.DB) []string {
+	var ids []string
+	db.Model(&models.OTPStore{}).Order("id").Pluck("id", &ids)
+	return ids
+}
+
+func TestPurgeExpiredOTPs(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}
+	as := newTestAuthService(db, &config.AuthConfig{RateLimitWindow: 900}, clock)
+	seedOTPs(t, db, clock.now, map[string]time.Duration{
+		"live":         5 * time.Minute,  // not expired
+		"in-window":    12 * time.Minute, // expired, but the rate limit still counts it
+		"expired":      20 * time.Minute,
+		"long-expired": 48 * time.Hour,
+		"past-window":  16 * time.Minute,
+	})
+
+	purged, err := as.PurgeExpiredOTPs(context.Background())
+	if err != nil || purged != 3 {
+		t.Fatalf("PurgeExpiredOTPs = %d, %v; want 3", purged, err)
+	}
+	if got := pendingOTPIDs(db); !slices.Equal(got, []string{"in-window", "live"}) {
+		t.Errorf("codes left = %v, want [in-window live]", got)
+	}
+
+	// Without a rate limit every expired code goes
+	unlimited := newTestAuthService(db, &config.AuthConfig{}, clock)
+	if purged, err := unlimited.PurgeExpiredOTPs(context.Background()); err != nil || purged != 1 {
+		t.Errorf("PurgeExpiredOTPs without a rate limit = %d, %v; want 1", purged, err)
+	}
+	if got := pendingOTPIDs(db); !slices.Equal(got, []string{"live"}) {
+		t.Errorf("codes left = %v, want [live]", got)
+	}
+}
+
+func TestPurgeStaleConversations(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	seedConversation(t, db, now, "user-1", "stale", 72*time.Hour, 50*time.Hour)
+	seedConversation(t, db, now, "user-1", "resumed", 72*time.Hour, time.Hour)
+	seedConversation(t, db, now, "user-2", "fresh", time.Hour)
+	// Another user's conversation of the same ID is judged on its own
+	seedConversation(t, db, now, "user-2", "stale", time.Hour)
+	ctx := context.Background()
+
+	// Retention 0 keeps everything
+	if purged, err := newTestAIService(t, db, &config.AIConfig{}).PurgeStaleConversations(ctx); err != nil || purged != 0 {
+		t.Fatalf("PurgeStaleConversations with no retention = %d, %v; want 0", purged, err)
+	}
+
+	as := newTestAIService(t, db, &config.AIConfig{ConversationRetention: 24 * 3600})
+	purged, err := as.PurgeStaleConversations(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeStaleConversations = %d, %v; want 1", purged, err)
+	}
+	if turns := conversationTurns(t, db, "stale"); len(turns) != 1 || turns[0].UserID != "user-2" {
+		t.Errorf("turns of stale conversations left = %+v, want only user-2's", turns)
+	}
+	// A conversation with a recent turn keeps its old turns too
+	if turns := conversationTurns(t, db, "resumed"); len(turns) != 2 {
+		t.Errorf("%d turns of the resumed conversation left, want 2", len(turns))
+	}
+
+	var summaries []string
+	db.Model(&models.ConversationSummary{}).Order("user_id, conversation_id").Pluck("user_id || '/' || conversation_id", &summaries)
+	if !slices.Equal(summaries, []string{"user-1/resumed", "user-2/fresh", "user-2/stale"}) {
+		t.Errorf("summaries left = %v, want the purged conversation's removed", summaries)
+	}
+}
+
+func TestCleanupJobsRunOnSchedule(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	auth := newTestAuthService(db, &config.AuthConfig{}, &testClock{now: now})
+	ai := newTestAIService(t, db, &config.AIConfig{ConversationRetention: 3600})
+	seedOTPs(t, db, now, map[string]time.Duration{"expired": time.Hour, "live": time.Minute})
+	seedConversation(t, db, now, "user-1", "stale", 2*time.Hour)
+	seedConversation(t, db, now, "user-1", "fresh", time.Minute)
+
+	runs := make(chan int, 100)
+	scheduler := jobs.NewScheduler()
+	scheduler.Register("otp-purge", 10*time.Millisecond, func(ctx context.Context) error {
+		n, err := auth.PurgeExpiredOTPs(ctx)
+		runs <- n
+		return err
+	})
+	scheduler.Register("conversation-purge", 10*time.Millisecond, func(ctx context.Context) error {
+		n, err := ai.PurgeStaleConversations(ctx)
+		runs <- n
+		return err
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.Start(ctx)
+
+	removed := 0
+	deadline := time.After(5 * time.Second)
+	for removed < 2 {
+		select {
+		case n := <-runs:
+			removed += n
+		case <-deadline:
+			cancel()
+			t.Fatalf("the jobs removed %d rows, want 2", removed)
+		}
+	}
+
+	// Cancelling stops both jobs
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the jobs did not stop after cancellation")
+	}
+
+	if got := pendingOTPIDs(db); !slices.Equal(got, []string{"live"}) {
+		t.Errorf("codes left = %v, want [live]", got)
+	}
+	if len(conversationTurns(t, db, "stale")) != 0 || len(conversationTurns(t, db, "fresh")) != 1 {
+		t.Error("the conversation job did not remove exactly the stale conversation")
+	}
+	// Later runs find nothing more to remove
+	for len(runs) > 0 {
+		if n := <-runs; n != 0 {
+			t.Errorf("a later run removed %d rows", n)
+		}
+	}
+}
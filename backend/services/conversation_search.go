@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxConversationQueryLength bounds a conversation search query, in bytes
+	maxConversationQueryLength = 200
+
+	// maxConversationMatches bounds how many conversations one search returns
+	maxConversationMatches = 20
+
+	// snippetContext is how many characters a snippet keeps on each side
+	// of the match
+	snippetContext = 40
+)
+
+// likeEscaper escapes LIKE wildcards in a search query. '!' is the escape
+// character because backslash is itself an escape in MySQL string
+// literals but not in Postgres ones.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// ConversationMatch is a conversation found by SearchConversations
+type ConversationMatch struct {
+	ConversationID string
+	Matches        int       // turns that contain the query
+	MessageID      string    // latest matching turn
+	Snippet        string    // text around the match in that turn
+	InResponse     bool      // the snippet is from the assistant's reply
+	MatchedAt      time.Time // when the latest matching turn was added
+}
+
+// SearchConversations finds the user's conversations with a turn whose
+// message or reply contains query, ignoring case. The most recently
+// matched conversations come first, each with a snippet of its latest
+// match. SQLite only folds the case of ASCII letters.
+func (as *AIService) SearchConversations(ctx context.Context, userID, query string) ([]ConversationMatch, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("%w: search query is empty", ErrInvalidArgument)
+	}
+	if len(query) > maxConversationQueryLength {
+		return nil, fmt.Errorf("%w: search query exceeds %d bytes", ErrInvalidArgument, maxConversationQueryLength)
+	}
+
+	pattern := "%" + likeEscaper.Replace(strings.ToLower(query)) + "%"
+	matching := func() *gorm.DB {
+		return as.db.WithContext(ctx).Model(&models.DoctorConversation{}).
+			Where("user_id = ?", userID).
+			Where("(LOWER(message) LIKE ? ESCAPE '!' OR LOWER(response) LIKE ? ESCAPE '!')", pattern, pattern)
+	}
+
+	var groups []struct {
+		ConversationID string
+		Matches        int
+	}
+	if err := matching().
+		Select("conversation_id, COUNT(*) AS matches").
+		Group("conversation_id").
+		Order("MAX(created_at) DESC").
+		Limit(maxConversationMatches).
+		Scan(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to search conversations: %w", err)
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	conversationIDs := make([]string, len(groups))
+	for i, group := range groups {
+		conversationIDs[i] = group.ConversationID
+	}
+	var turns []models.DoctorConversation
+	if err := matching().
+		Where("conversation_id IN ?", conversationIDs).
+		Order("created_at DESC").
+		Find(&turns).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch matching turns: %w", err)
+	}
+	latest := make(map[string]models.DoctorConversation, len(groups))
+	for _, turn := range turns {
+		if _, ok := latest[turn.ConversationID]; !ok {
+			latest[turn.ConversationID] = turn
+		}
+	}
+
+	matches := make([]ConversationMatch, 0, len(groups))
+	for _, group := range groups {
+		turn := latest[group.ConversationID]
+		match := ConversationMatch{
+			ConversationID: group.ConversationID,
+			Matches:        group.Matches,
+			MessageID:      turn.ID,
+			MatchedAt:      turn.CreatedAt,
+		}
+		if snippet, ok := matchSnippet(turn.Message, query); ok {
+			match.Snippet = snippet
+		} else if snippet, ok := matchSnippet(turn.Response, query); ok {
+			match.Snippet, match.InResponse = snippet, true
+		} else {
+			// The database folded case differently; show the start of the turn
+			match.Snippet, _ = matchSnippet(turn.Message, "")
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// matchSnippet returns the text around the first case-insensitive
+// occurrence of query in text, marking cut ends with an ellipsis
+func matchSnippet(text, query string) (string, bool) {
+	// Lowercasing maps rune to rune, so rune offsets carry over to text
+	lowered := strings.ToLower(text)
+	at := strings.Index(lowered, strings.ToLower(query))
+	if at < 0 {
+		return "", false
+	}
+	runes := []rune(text)
+	start := utf8.RuneCountInString(lowered[:at])
+	end := start + utf8.RuneCountInString(query)
+
+	from, to := max(start-snippetContext, 0), min(end+snippetContext, len(runes))
+	snippet := strings.TrimSpace(string(runes[from:to]))
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(runes) {
+		snippet += "…"
+	}
+	return snippet, true
+}
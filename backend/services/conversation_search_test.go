@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// addTurn stores a chat turn of userID's conversation, ago before now
+func addTurn(t *testing.T, db *gorm.DB, id, userID, conversationID, message, response string, ago time.Duration) {
+	t.Helper()
+	turn := models.DoctorConversation{
+		ID:             id,
+		UserID:         userID,
+		ConversationID: conversationID,
+		Message:        message,
+		Response:       response,
+		IsAI:           true,
+		CreatedAt:      time.Now().Add(-ago),
+	}
+	if err := db.Create(&turn).Error; err != nil {
+		t.Fatalf("create turn: %v", err)
+	}
+}
+
+// conversationIDs lists the conversations of matches in order
+func conversationIDs(matches []ConversationMatch) []string {
+	ids := make([]string, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ConversationID
+	}
+	return ids
+}
+
+func TestSearchConversationsMatchesMessagesAndResponses(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	createUser(t, db, "user-1")
+	addTurn(t, db, "t-1", "user-1", "asked", "I have had a Fever since Monday", "Rest and drink fluids.", 3*time.Hour)
+	addTurn(t, db, "t-2", "user-1", "answered", "My head hurts", "A FEVER with a headache can be the flu.", 2*time.Hour)
+	addTurn(t, db, "t-3", "user-1", "unrelated", "How much should I sleep?", "Seven to nine hours.", time.Hour)
+
+	matches, err := as.SearchConversations(context.Background(), "user-1", "fever")
+	if err != nil {
+		t.Fatalf("SearchConversations: %v", err)
+	}
+	// Case is ignored and the latest match comes first
+	if got := strings.Join(conversationIDs(matches), ","); got != "answered,asked" {
+		t.Fatalf("conversations = %s, want answered,asked", got)
+	}
+
+	answered, asked := matches[0], matches[1]
+	if !answered.InResponse || answered.MessageID != "t-2" || answered.Snippet != "A FEVER with a headache can be the flu." {
+		t.Errorf("match in the reply = %+v", answered)
+	}
+	if asked.InResponse || asked.MessageID != "t-1" || asked.Snippet != "I have had a Fever since Monday" {
+		t.Errorf("match in the message = %+v", asked)
+	}
+}
+
+func TestSearchConversationsIsScopedToTheUser(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	addTurn(t, db, "t-1", "user-1", "mine", "Is my rash contagious?", "Probably not.", time.Hour)
+	addTurn(t, db, "t-2", "user-2", "theirs", "A rash on my arm", "Keep it dry.", time.Minute)
+
+	matches, err := as.SearchConversations(context.Background(), "user-1", "rash")
+	if err != nil {
+		t.Fatalf("SearchConversations: %v", err)
+	}
+	if got := strings.Join(conversationIDs(matches), ","); got != "mine" {
+		t.Errorf("user-1 found %s, want only mine", got)
+	}
+
+	// A user with no chats finds nothing, not someone else's
+	createUser(t, db, "user-3")
+	if matches, err := as.SearchConversations(context.Background(), "user-3", "rash"); err != nil || len(matches) != 0 {
+		t.Errorf("user-3 found %v, %v; want nothing", conversationIDs(matches), err)
+	}
+}
+
+func TestSearchConversationsCountsTurnsAndCutsSnippets(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	createUser(t, db, "user-1")
+	long := strings.Repeat("before ", 10) + "insulin" + strings.Repeat(" after", 10)
+	addTurn(t, db, "t-1", "user-1", "diabetes", "When do I take insulin?", "With meals.", 2*time.Hour)
+	addTurn(t, db, "t-2", "user-1", "diabetes", "And at night?", long, time.Hour)
+
+	matches, err := as.SearchConversations(context.Background(), "user-1", "insulin")
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("SearchConversations = %v, %v; want one conversation", conversationIDs(matches), err)
+	}
+	match := matches[0]
+	if match.Matches != 2 || match.MessageID != "t-2" {
+		t.Errorf("match = %d turns, latest %s; want 2, t-2", match.Matches, match.MessageID)
+	}
+	// A long turn is cut around the match
+	if !strings.HasPrefix(match.Snippet, "…") || !strings.HasSuffix(match.Snippet, "…") || !strings.Contains(match.Snippet, "insulin") {
+		t.Errorf("snippet = %q, want the text around insulin with cut ends", match.Snippet)
+	}
+	if n := len([]rune(match.Snippet)); n > 2*snippetContext+len("insulin")+2 {
+		t.Errorf("snippet is %d characters, longer than the context allows", n)
+	}
+}
+
+func TestSearchConversationsQuery(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	createUser(t, db, "user-1")
+	addTurn(t, db, "t-1", "user-1", "dose", "Is a 100% dose safe?", "Yes.", time.Hour)
+	addTurn(t, db, "t-2", "user-1", "other", "Is a 1000 mg dose safe?", "No.", time.Hour)
+
+	// LIKE wildcards in the query are literal
+	matches, err := as.SearchConversations(context.Background(), "user-1", "100%")
+	if err != nil {
+		t.Fatalf("SearchConversations: %v", err)
+	}
+	if got := strings.Join(conversationIDs(matches), ","); got != "dose" {
+		t.Errorf("conversations with 100%% = %s, want dose", got)
+	}
+	if matches, err := as.SearchConversations(context.Background(), "user-1", "mg_dose"); err != nil || len(matches) != 0 {
+		t.Errorf("underscore matched %v, %v", conversationIDs(matches), err)
+	}
+
+	for _, query := range []string{"", "   ", strings.Repeat("a", maxConversationQueryLength+1)} {
+		if _, err := as.SearchConversations(context.Background(), "user-1", query); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("query of %d bytes: %v, want %v", len(query), err, ErrInvalidArgument)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// conversationSummaryPrompt asks for a summary that can stand in for the
+// turns it covers in later prompts
+const conversationSummaryPrompt = "Summarize this conversation between a patient and a doctor assistant " +
+	"in a few sentences. Keep symptoms, medications, advice given and open questions. " +
+	"Reply with the summary only."
+
+// SummarizeConversation has the provider condense a conversation into a
+// summary stored with it. Turns after an earlier summary are sent together
+// with that summary, so each call only sends what is new; with no new
+// turns the stored summary is returned as is. Conversations the user has
+// no turns in are reported as ErrNotFound.
+func (as *AIService) SummarizeConversation(ctx context.Context, userID, conversationID string) (*models.ConversationSummary, error) {
+	if err := as.flags.require(FeatureChat); err != nil {
+		return nil, err
+	}
+
+	summary, err := as.conversationSummary(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	var turns []models.DoctorConversation
+	if err := as.db.WithContext(ctx).
+		Where("user_id = ? AND conversation_id = ? AND created_at > ?", userID, conversationID, summary.SummarizedThrough).
+		Order("created_at ASC").
+		Find(&turns).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+	if len(turns) == 0 {
+		if summary.Summary == "" {
+			return nil, fmt.Errorf("%w: conversation %s", ErrNotFound, conversationID)
+		}
+		return summary, nil
+	}
+
+	prompt := conversationSummaryPrompt + "\n\n"
+	if summary.Summary != "" {
+		prompt += "Summary of the earlier conversation: " + summary.Summary + "\n\n"
+	}
+	prompt += conversationTranscript(turns, as.config.ChatSummaryMaxInput)
+
+	route, err := as.route(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reply string
+	err = route.call(func() error {
+		var err error
+		reply, err = route.provider.DoctorChat(ctx, prompt)
+		if err != nil {
+			return err
+		}
+		return checkReply(route.provider.Name(), reply)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+
+	summary.ConversationID = conversationID
+	summary.UserID = userID
+	summary.Summary = strings.TrimSpace(reply)
+	summary.Turns += len(turns)
+	summary.SummarizedThrough = turns[len(turns)-1].CreatedAt
+	summary.Provider = route.provider.Name()
+	summary.UpdatedAt = time.Now()
+	if err := as.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(summary).Error; err != nil {
+		return nil, fmt.Errorf("failed to store conversation summary: %w", err)
+	}
+	return summary, nil
+}
+
+// conversationSummary returns the user's stored summary of a conversation,
+// or an empty one if it has not been summarized
+func (as *AIService) conversationSummary(ctx context.Context, userID, conversationID string) (*models.ConversationSummary, error) {
+	var summary models.ConversationSummary
+	err := as.db.WithContext(ctx).Where("user_id = ? AND conversation_id = ?", userID, conversationID).First(&summary).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to fetch conversation summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// conversationTranscript writes out turns for the provider, keeping the
+// most recent ones that fit in maxBytes (0 keeps all). The latest turn is
+// always kept.
+func conversationTranscript(turns []models.DoctorConversation, maxBytes int) string {
+	var parts []string
+	size := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		part := "Patient: " + turns[i].Message + "\nAssistant: " + turns[i].Response
+		if maxBytes > 0 && len(parts) > 0 && size+len(part) > maxBytes {
+			break
+		}
+		parts = append(parts, part)
+		size += len(part) + 2
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, "\n\n")
+}
+
+// chatHistory returns the user's latest turns of a conversation after
+// since, up to the configured number, as user and assistant messages
+// oldest first
+func (as *AIService) chatHistory(ctx context.Context, userID, conversationID string, since time.Time) ([]ChatMessage, error) {
+	if as.config.ChatHistoryTurns <= 0 {
+		return nil, nil
+	}
+	var turns []models.DoctorConversation
+	if err := as.db.WithContext(ctx).
+		Where("user_id = ? AND conversation_id = ? AND created_at > ?", userID, conversationID, since).
+		Order("created_at DESC").
+		Limit(as.config.ChatHistoryTurns).
+		Find(&turns).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+	slices.Reverse(turns)
+
+	history := make([]ChatMessage, 0, 2*len(turns))
+	for _, turn := range turns {
+		history = append(history,
+			ChatMessage{Role: ChatRoleUser, Content: turn.Message},
+			ChatMessage{Role: ChatRoleAssistant, Content: turn.Response})
+	}
+	return history, nil
+}
+
+// withConversationContext prefixes a chat message with the summary of the
+// conversation so far and the turns since, if there are any, for providers
+// that take a single prompt
+func withConversationContext(summary string, history []ChatMessage, message string) string {
+	if summary == "" && len(history) == 0 {
+		return message
+	}
+	var prompt strings.Builder
+	if summary != "" {
+		prompt.WriteString("Summary of the conversation so far: " + summary + "\n\n")
+	}
+	for _, turn := range history {
+		speaker := "Patient"
+		if turn.Role == ChatRoleAssistant {
+			speaker = "Assistant"
+		}
+		prompt.WriteString(speaker + ": " + turn.Content + "\n\n")
+	}
+	prompt.WriteString("Patient: " + message)
+	return prompt.String()
+}
+
+// PurgeStaleConversations deletes doctor chat conversations, with their
+// summaries, that have had no new turn for the configured retention. It
+// does nothing when retention is 0, which keeps conversations forever.
+func (as *AIService) PurgeStaleConversations(ctx context.Context) (int, error) {
+	if as.config.ConversationRetention <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-time.Duration(as.config.ConversationRetention) * time.Second)
+
+	type conversation struct {
+		UserID         string
+		ConversationID string
+	}
+	var stale []conversation
+	if err := as.db.WithContext(ctx).Model(&models.DoctorConversation{}).
+		Select("user_id, conversation_id").
+		Group("user_id, conversation_id").
+		Having("MAX(created_at) <= ?", cutoff).
+		Scan(&stale).Error; err != nil {
+		return 0, fmt.Errorf("failed to find stale conversations: %w", err)
+	}
+
+	purged := 0
+	for _, c := range stale {
+		err := as.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// Checked again in case a turn arrived since the scan
+			result := tx.Where("user_id = ? AND conversation_id = ? AND NOT EXISTS (?)", c.UserID, c.ConversationID,
+				tx.Model(&models.DoctorConversation{}).Select("1").
+					Where("user_id = ? AND conversation_id = ? AND created_at > ?", c.UserID, c.ConversationID, cutoff)).
+				Delete(&models.DoctorConversation{})
+			if result.Error != nil {
+				return fmt.Errorf("failed to purge conversation: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+			if err := tx.Where("user_id = ? AND conversation_id = ?", c.UserID, c.ConversationID).
+				Delete(&models.ConversationSummary{}).Error; err != nil {
+				return fmt.Errorf("failed to purge conversation summary: %w", err)
+			}
+			purged++
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d stale conversations", purged)
+	}
+	return purged, nil
+}
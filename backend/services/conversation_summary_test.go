@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// promptProvider is a fakeProvider that keeps the prompts it is sent
+type promptProvider struct {
+	fakeProvider
+	prompts []string
+}
+
+func (pp *promptProvider) DoctorChat(ctx context.Context, message string) (string, error) {
+	pp.prompts = append(pp.prompts, message)
+	return pp.fakeProvider.DoctorChat(ctx, message)
+}
+
+// storeTurns stores chat turns a minute apart, starting at start
+func storeTurns(t *testing.T, db *gorm.DB, userID, conversationID string, start time.Time, messages ...string) {
+	t.Helper()
+	for i, message := range messages {
+		turn := models.DoctorConversation{
+			ID:             fmt.Sprintf("%s-%s-%d-%d", userID, conversationID, start.Unix(), i),
+			UserID:         userID,
+			ConversationID: conversationID,
+			Message:        message,
+			Response:       "reply to " + message,
+			IsAI:           true,
+			CreatedAt:      start.Add(time.Duration(i) * time.Minute),
+		}
+		if err := db.Create(&turn).Error; err != nil {
+			t.Fatalf("store turn: %v", err)
+		}
+	}
+}
+
+func TestSummarizeConversation(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	provider := &promptProvider{fakeProvider: fakeProvider{reply: "  Headache for three days, advised rest.  "}}
+	as.provider = provider
+	ctx := context.Background()
+	start := time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)
+	storeTurns(t, db, "user-1", "conv-1", start, "I have a headache", "It started Monday", "Should I take ibuprofen?")
+
+	summary, err := as.SummarizeConversation(ctx, "user-1", "conv-1")
+	if err != nil {
+		t.Fatalf("SummarizeConversation: %v", err)
+	}
+	if summary.Summary != "Headache for three days, advised rest." || summary.Turns != 3 || summary.Provider != "fake" ||
+		!summary.SummarizedThrough.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("summary = %+v", summary)
+	}
+	prompt := provider.prompts[0]
+	for _, want := range []string{conversationSummaryPrompt, "Patient: I have a headache", "Assistant: reply to It started Monday", "Patient: Should I take ibuprofen?"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("summary prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+
+	// Nothing new: the stored summary is returned without a provider call
+	again, err := as.SummarizeConversation(ctx, "user-1", "conv-1")
+	if err != nil || again.Summary != summary.Summary || len(provider.prompts) != 1 {
+		t.Errorf("second SummarizeConversation = %+v, %v after %d provider calls", again, err, len(provider.prompts))
+	}
+
+	// Later turns are summarized together with the earlier summary only
+	storeTurns(t, db, "user-1", "conv-1", start.Add(time.Hour), "The headache is gone")
+	provider.reply = "Headache for three days, now resolved."
+	updated, err := as.SummarizeConversation(ctx, "user-1", "conv-1")
+	if err != nil {
+		t.Fatalf("SummarizeConversation after a new turn: %v", err)
+	}
+	prompt = provider.prompts[1]
+	if !strings.Contains(prompt, "Summary of the earlier conversation: Headache for three days, advised rest.") ||
+		!strings.Contains(prompt, "Patient: The headache is gone") || strings.Contains(prompt, "I have a headache") {
+		t.Errorf("update prompt should carry the summary and only the new turn:\n%s", prompt)
+	}
+	if updated.Turns != 4 || updated.Summary != "Headache for three days, now resolved." {
+		t.Errorf("updated summary = %+v", updated)
+	}
+	var stored int64
+	db.Model(&models.ConversationSummary{}).Count(&stored)
+	if stored != 1 {
+		t.Errorf("%d summaries stored, want the one updated in place", stored)
+	}
+}
+
+func TestSummarizeConversationIsPerUser(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	provider := &promptProvider{fakeProvider: fakeProvider{reply: "Bob's summary"}}
+	as.provider = provider
+	ctx := context.Background()
+	start := time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)
+	storeTurns(t, db, "user-1", "conv-1", start, "Alice's private question")
+	storeTurns(t, db, "user-2", "conv-1", start, "Bob's question")
+
+	if _, err := as.SummarizeConversation(ctx, "user-3", "conv-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("summarizing a conversation without turns: error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := as.SummarizeConversation(ctx, "user-2", "conv-1"); err != nil {
+		t.Fatalf("SummarizeConversation: %v", err)
+	}
+	if strings.Contains(provider.prompts[0], "Alice") {
+		t.Errorf("bob's summary was built from alice's turns:\n%s", provider.prompts[0])
+	}
+	var alices int64
+	db.Model(&models.ConversationSummary{}).Where("user_id = ?", "user-1").Count(&alices)
+	if alices != 0 {
+		t.Error("bob's summary was stored for alice")
+	}
+}
+
+func TestSummarizeConversationBoundsInput(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{ChatSummaryMaxInput: 200})
+	provider := &promptProvider{fakeProvider: fakeProvider{reply: "summary"}}
+	as.provider = provider
+	start := time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)
+	storeTurns(t, db, "user-1", "conv-1", start, "oldest "+strings.Repeat("a", 60), "middle "+strings.Repeat("b", 60), "newest "+strings.Repeat("c", 60))
+
+	if _, err := as.SummarizeConversation(context.Background(), "user-1", "conv-1"); err != nil {
+		t.Fatalf("SummarizeConversation: %v", err)
+	}
+	prompt := provider.prompts[0]
+	if strings.Contains(prompt, "oldest") || !strings.Contains(prompt, "newest") {
+		t.Errorf("bounded prompt should drop the oldest turns first:\n%s", prompt)
+	}
+}
+
+func TestRefusedSummaryIsNotStored(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	as.provider = &fakeProvider{reply: "I cannot help with that."}
+	storeTurns(t, db, "user-1", "conv-1", time.Now(), "hello")
+
+	if _, err := as.SummarizeConversation(context.Background(), "user-1", "conv-1"); !errors.Is(err, ErrContentRefused) {
+		t.Errorf("refused summary: error = %v, want %v", err, ErrContentRefused)
+	}
+	var stored int64
+	db.Model(&models.ConversationSummary{}).Count(&stored)
+	if stored != 0 {
+		t.Error("a refusal was stored as the summary")
+	}
+}
+
+func TestDoctorChatUsesTheSummaryAsContext(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{ChatSummaryContext: true, ChatHistoryTurns: 10})
+	provider := &promptProvider{fakeProvider: fakeProvider{reply: "Headache on Monday, took ibuprofen."}}
+	as.provider = provider
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	storeTurns(t, db, "user-1", "conv-1", start, "I have a headache", "I took ibuprofen")
+	if _, err := as.SummarizeConversation(ctx, "user-1", "conv-1"); err != nil {
+		t.Fatalf("SummarizeConversation: %v", err)
+	}
+	storeTurns(t, db, "user-1", "conv-1", start.Add(30*time.Minute), "Now I feel dizzy")
+
+	provider.reply = "Sit down and drink water."
+	if _, _, err := as.DoctorChat(ctx, "user-1", "conv-1", "What should I do?"); err != nil {
+		t.Fatalf("DoctorChat: %v", err)
+	}
+	prompt := provider.prompts[len(provider.prompts)-1]
+	if !strings.Contains(prompt, "Summary of the conversation so far: Headache on Monday, took ibuprofen.") {
+		t.Errorf("chat prompt is missing the summary:\n%s", prompt)
+	}
+	if strings.Contains(prompt, "I have a headache") || strings.Contains(prompt, "I took ibuprofen") {
+		t.Errorf("chat prompt repeats turns the summary covers:\n%s", prompt)
+	}
+	if !strings.Contains(prompt, "Patient: Now I feel dizzy") || !strings.HasSuffix(prompt, "Patient: What should I do?") {
+		t.Errorf("chat prompt is missing the turns since the summary or the message:\n%s", prompt)
+	}
+
+	// The stored turn keeps the user's own words
+	turns := conversationTurns(t, db, "conv-1")
+	if last := turns[len(turns)-1]; last.Message != "What should I do?" {
+		t.Errorf("stored message = %q", last.Message)
+	}
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// extractedFields are the metadata keys a prescription scan fills in. An
+// owner's change to one of them on a scanned record is a correction.
+var extractedFields = []string{"medication", "dosage", "frequency", "duration", "indication", "warnings", "refills"}
+
+// A field's confidence is kept in record metadata under "<field>_confidence".
+// Fields without one carry the extraction's confidence; a field the owner
+// corrected is FieldConfidenceCorrected.
+const (
+	confidenceKeySuffix      = "_confidence"
+	FieldConfidenceCorrected = "corrected"
+)
+
+// applyCorrections compares the metadata of an update to the scanned
+// record it replaces. Each extracted field whose value the owner changed
+// becomes a Correction and is marked corrected in the returned metadata.
+// Fields corrected before keep their mark and are not counted again, since
+// their value is no longer the extraction's; fields left out of the update
+// are not counted either. metadata itself is not modified.
+func applyCorrections(before *models.HealthRecord, metadata map[string]string, now time.Time) (map[string]string, []models.Correction, error) {
+	if before.ScanID == "" {
+		return metadata, nil, nil
+	}
+	previous := make(map[string]string)
+	if before.Metadata != "" {
+		if err := json.Unmarshal([]byte(before.Metadata), &previous); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode metadata: %w", err)
+		}
+	}
+
+	corrected := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		corrected[key] = value
+	}
+
+	var corrections []models.Correction
+	for _, field := range extractedFields {
+		confidenceKey := field + confidenceKeySuffix
+		if previous[confidenceKey] == FieldConfidenceCorrected {
+			corrected[confidenceKey] = FieldConfidenceCorrected
+			continue
+		}
+		value, ok := metadata[field]
+		if !ok || value == previous[field] {
+			continue
+		}
+
+		corrected[confidenceKey] = FieldConfidenceCorrected
+		if field == "medication" {
+			delete(corrected, "needs_review")
+		}
+		corrections = append(corrections, models.Correction{
+			ID:                uuid.New().String(),
+			UserID:            before.UserID,
+			RecordID:          before.ID,
+			ScanID:            before.ScanID,
+			Field:             field,
+			OriginalValue:     previous[field],
+			CorrectedValue:    value,
+			Provider:          before.ExtractionProvider,
+			ExtractionVersion: before.ExtractionVersion,
+			CreatedAt:         now,
+		})
+	}
+	return corrected, corrections, nil
+}
+
+// CorrectionService reports the corrections owners made to extracted
+// fields, to measure extraction accuracy and collect regression cases
+type CorrectionService struct {
+	db *gorm.DB
+}
+
+func NewCorrectionService(db *gorm.DB) *CorrectionService {
+	return &CorrectionService{db: db}
+}
+
+// CorrectionFilter narrows ListCorrections. Zero fields match everything;
+// the time range is inclusive of Since and exclusive of Until.
+type CorrectionFilter struct {
+	Field    string
+	Provider string
+	Since    time.Time
+	Until    time.Time
+}
+
+// CorrectionGroup counts the corrections to one field of the records one
+// provider extracted with one pipeline version. ExtractedRecords is how
+// many records that provider and version extracted in all, so
+// Corrections/ExtractedRecords is the field's error rate.
+type CorrectionGroup struct {
+	Field             string
+	Provider          string
+	ExtractionVersion int
+	Corrections       int64
+	ExtractedRecords  int64
+}
+
+// List returns one page of corrections matching filter, newest first, and
+// the cursor for the next page, which is empty on the last page
+func (cs *CorrectionService) List(ctx context.Context, filter CorrectionFilter, cursor string, limit int) ([]models.Correction, string, error) {
+	limit, _ = pageBounds(limit, 0)
+
+	query := cs.filtered(ctx, filter)
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", createdAt, createdAt, id)
+	}
+
+	// One extra row tells us whether another page follows
+	var corrections []models.Correction
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&corrections).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to list corrections: %w", err)
+	}
+
+	next := ""
+	if len(corrections) > limit {
+		corrections = corrections[:limit]
+		last := corrections[limit-1]
+		next = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return corrections, next, nil
+}
+
+// Aggregate counts the corrections matching filter by field, provider and
+// extraction version, most corrected first
+func (cs *CorrectionService) Aggregate(ctx context.Context, filter CorrectionFilter) ([]CorrectionGroup, error) {
+	var groups []CorrectionGroup
+	err := cs.filtered(ctx, filter).
+		Select("field, provider, extraction_version, COUNT(*) AS corrections").
+		Group("field, provider, extraction_version").
+		Order("corrections DESC, field, provider, extraction_version").
+		Scan(&groups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate corrections: %w", err)
+	}
+	if len(groups) == 0 {
+		return groups, nil
+	}
+
+	var totals []struct {
+		ExtractionProvider string
+		ExtractionVersion  int
+		Records            int64
+	}
+	err = cs.db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Select("extraction_provider, extraction_version, COUNT(*) AS records").
+		Where("scan_id <> ''").
+		Group("extraction_provider, extraction_version").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count extracted records: %w", err)
+	}
+	type extraction struct {
+		provider string
+		version  int
+	}
+	records := make(map[extraction]int64, len(totals))
+	for _, total := range totals {
+		records[extraction{total.ExtractionProvider, total.ExtractionVersion}] = total.Records
+	}
+	for i := range groups {
+		groups[i].ExtractedRecords = records[extraction{groups[i].Provider, groups[i].ExtractionVersion}]
+	}
+	return groups, nil
+}
+
+func (cs *CorrectionService) filtered(ctx context.Context, filter CorrectionFilter) *gorm.DB {
+	query := cs.db.WithContext(ctx).Model(&models.Correction{})
+	if filter.Field != "" {
+		query = query.Where("field = ?", filter.Field)
+	}
+	if filter.Provider != "" {
+		query = query.Where("provider = ?", filter.Provider)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	return query
+}
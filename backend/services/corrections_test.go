@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// scannedRecord stores a scan read by provider and creates userID's
+// prescription record from its extraction
+func scannedRecord(t *testing.T, db *gorm.DB, hrs *HealthRecordsService, userID, scanID, provider string, version int, extracted map[string]string) *models.HealthRecord {
+	t.Helper()
+	scan := models.ScanInput{ID: scanID, UserID: userID, Provider: provider, ExtractionVersion: version, CreatedAt: time.Now()}
+	if err := db.Create(&scan).Error; err != nil {
+		t.Fatalf("store scan: %v", err)
+	}
+	metadata := map[string]string{"scan_id": scanID}
+	for key, value := range extracted {
+		metadata[key] = value
+	}
+	record, err := hrs.CreateRecord(context.Background(), userID, "prescription", extracted["medication"], "", metadata)
+	if err != nil {
+		t.Fatalf("CreateRecord from scan: %v", err)
+	}
+	return record
+}
+
+func TestEditingAnExtractedFieldRecordsACorrection(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	hrs := newTestRecordsService(db, nil)
+	ctx := context.Background()
+	record := scannedRecord(t, db, hrs, "user-1", "scan-1", "openai", 3, map[string]string{
+		"medication":   "Metformin",
+		"dosage":       "850mg",
+		"frequency":    "twice daily",
+		"needs_review": "true",
+	})
+
+	// The owner fixes the dosage and adds a note of their own
+	updated, err := hrs.UpdateRecord(ctx, "user-1", record.ID, record.Title, "", map[string]string{
+		"scan_id":      "scan-1",
+		"medication":   "Metformin",
+		"dosage":       "500mg",
+		"frequency":    "twice daily",
+		"needs_review": "true",
+		"note":         "with meals",
+	})
+	if err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+
+	var corrections []models.Correction
+	db.Find(&corrections)
+	if len(corrections) != 1 {
+		t.Fatalf("corrections = %+v, want the dosage only", corrections)
+	}
+	c := corrections[0]
+	if c.Field != "dosage" || c.OriginalValue != "850mg" || c.CorrectedValue != "500mg" ||
+		c.RecordID != record.ID || c.ScanID != "scan-1" || c.Provider != "openai" || c.ExtractionVersion != 3 || c.UserID != "user-1" {
+		t.Errorf("correction = %+v", c)
+	}
+	metadata := recordMetadata(t, updated)
+	if metadata["dosage_confidence"] != FieldConfidenceCorrected || metadata["medication_confidence"] != "" {
+		t.Errorf("confidence after fixing the dosage = %v", metadata)
+	}
+	if metadata["needs_review"] != "true" {
+		t.Error("needs_review cleared without the medication being corrected")
+	}
+
+	// Editing the corrected dosage again is the owner's own change, and
+	// fixing the medication clears its review flag
+	updated, err = hrs.UpdateRecord(ctx, "user-1", record.ID, record.Title, "", map[string]string{
+		"scan_id":      "scan-1",
+		"medication":   "Metformin XR",
+		"dosage":       "1000mg",
+		"frequency":    "twice daily",
+		"needs_review": "true",
+	})
+	if err != nil {
+		t.Fatalf("second UpdateRecord: %v", err)
+	}
+	db.Order("field").Find(&corrections)
+	if len(corrections) != 2 || corrections[0].Field != "dosage" || corrections[1].Field != "medication" || corrections[1].OriginalValue != "Metformin" {
+		t.Errorf("corrections after the second edit = %+v, want the first dosage fix and the medication", corrections)
+	}
+	metadata = recordMetadata(t, updated)
+	if metadata["dosage_confidence"] != FieldConfidenceCorrected || metadata["medication_confidence"] != FieldConfidenceCorrected {
+		t.Errorf("confidence after the second edit = %v", metadata)
+	}
+	if _, ok := metadata["needs_review"]; ok {
+		t.Error("needs_review kept after the medication was corrected")
+	}
+}
+
+func TestEditingARecordNotFromAScanRecordsNothing(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	hrs := newTestRecordsService(db, nil)
+	ctx := context.Background()
+	record, err := hrs.CreateRecord(ctx, "user-1", "prescription", "Metformin", "", map[string]string{"dosage": "850mg"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	updated, err := hrs.UpdateRecord(ctx, "user-1", record.ID, "Metformin", "", map[string]string{"dosage": "500mg"})
+	if err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	var count int64
+	db.Model(&models.Correction{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d corrections for a typed-in record, want none", count)
+	}
+	if _, ok := recordMetadata(t, updated)["dosage_confidence"]; ok {
+		t.Error("typed-in record marked with a confidence")
+	}
+}
+
+func TestCorrectionAggregation(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	hrs := newTestRecordsService(db, nil)
+	cs := NewCorrectionService(db)
+	ctx := context.Background()
+
+	extracted := map[string]string{"medication": "Metformin", "dosage": "850mg", "frequency": "daily"}
+	fix := func(record *models.HealthRecord, changes map[string]string) {
+		t.Helper()
+		metadata := recordMetadata(t, record)
+		for key, value := range changes {
+			metadata[key] = value
+		}
+		if _, err := hrs.UpdateRecord(ctx, "user-1", record.ID, record.Title, "", metadata); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+	}
+
+	// openai v3 read four records and got three dosages and one frequency
+	// wrong; anthropic v3 read two and got one dosage wrong
+	for i, changes := range []map[string]string{
+		{"dosage": "500mg"},
+		{"dosage": "1000mg", "frequency": "twice daily"},
+		{"dosage": "500mg"},
+		nil,
+	} {
+		record := scannedRecord(t, db, hrs, "user-1", fmt.Sprintf("openai-scan-%d", i), "openai", 3, extracted)
+		if changes != nil {
+			fix(record, changes)
+		}
+	}
+	for i, changes := range []map[string]string{{"dosage": "500mg"}, nil} {
+		record := scannedRecord(t, db, hrs, "user-1", fmt.Sprintf("anthropic-scan-%d", i), "anthropic", 3, extracted)
+		if changes != nil {
+			fix(record, changes)
+		}
+	}
+
+	groups, err := cs.Aggregate(ctx, CorrectionFilter{})
+	if err != nil {
+		t.Fatalf("Aggregate: %v", err)
+	}
+	want := []CorrectionGroup{
+		{Field: "dosage", Provider: "openai", ExtractionVersion: 3, Corrections: 3, ExtractedRecords: 4},
+		{Field: "dosage", Provider: "anthropic", ExtractionVersion: 3, Corrections: 1, ExtractedRecords: 2},
+		{Field: "frequency", Provider: "openai", ExtractionVersion: 3, Corrections: 1, ExtractedRecords: 4},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("Aggregate = %+v, want %+v", groups, want)
+	}
+	for i := range want {
+		if groups[i] != want[i] {
+			t.Errorf("group %d = %+v, want %+v", i, groups[i], want[i])
+		}
+	}
+
+	filtered, err := cs.Aggregate(ctx, CorrectionFilter{Field: "dosage", Provider: "anthropic"})
+	if err != nil || len(filtered) != 1 || filtered[0] != want[1] {
+		t.Errorf("Aggregate for anthropic dosages = %+v, %v", filtered, err)
+	}
+	if none, err := cs.Aggregate(ctx, CorrectionFilter{Since: time.Now().Add(time.Hour)}); err != nil || len(none) != 0 {
+		t.Errorf("Aggregate in the future = %+v, %v", none, err)
+	}
+
+	// Paging through the corrections themselves
+	var listed []models.Correction
+	cursor := ""
+	pages := 0
+	for {
+		page, next, err := cs.List(ctx, CorrectionFilter{Provider: "openai"}, cursor, 2)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		listed = append(listed, page...)
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(listed) != 4 || pages != 2 {
+		t.Errorf("listed %d openai corrections over %d pages, want 4 over 2", len(listed), pages)
+	}
+	seen := make(map[string]bool)
+	for _, c := range listed {
+		if c.Provider != "openai" || seen[c.ID] {
+			t.Errorf("listed %+v twice or from another provider", c)
+		}
+		seen[c.ID] = true
+	}
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// dataQualitySampleSize bounds how many affected IDs a check reports
+	dataQualitySampleSize = 10
+
+	// inactiveUserAge is how long a user with no records or chats must
+	// have existed before the inactive-users check reports them
+	inactiveUserAge = 90 * 24 * time.Hour
+)
+
+// DataQualityCheck is one entry in the data-quality registry. Find reports
+// the problem; Fix, when set, repairs it and returns how many rows changed.
+// Only remediations that cannot lose user data belong in Fix.
+type DataQualityCheck struct {
+	Name        string
+	Description string
+	Find        func(ctx context.Context, db *gorm.DB) ([]string, error)
+	Fix         func(ctx context.Context, db *gorm.DB) (int, error)
+}
+
+// DataQualityResult is the outcome of one check in a report
+type DataQualityResult struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Affected    int      `json:"affected"`
+	SampleIDs   []string `json:"sample_ids,omitempty"`
+	Fixed       int      `json:"fixed,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// dataQualityChecks is the registry run by every report. Checks that need
+// the matching rows in Go (e.g. to parse JSON) scan in batches.
+var dataQualityChecks = []DataQualityCheck{
+	{
+		Name:        "empty_record_titles",
+		Description: "Health records whose title is empty or whitespace",
+		Find: func(ctx context.Context, db *gorm.DB) ([]string, error) {
+			return pluckIDs(db.WithContext(ctx).Model(&models.HealthRecord{}).Where("TRIM(title) = ''"))
+		},
+	},
+	{
+		Name:        "invalid_record_metadata",
+		Description: "Health records whose metadata is not a JSON object of strings",
+		Find: func(ctx context.Context, db *gorm.DB) ([]string, error) {
+			return scanRecords(ctx, db, func(record models.HealthRecord) bool {
+				var metadata map[string]string
+				return json.Unmarshal([]byte(record.Metadata), &metadata) != nil
+			})
+		},
+		Fix: fixRecordMetadata,
+	},
+	{
+		Name:        "impossible_vitals",
+		Description: "Health records with vital-sign metadata outside physiological limits",
+		Find: func(ctx context.Context, db *gorm.DB) ([]string, error) {
+			return scanRecords(ctx, db, func(record models.HealthRecord) bool {
+				var metadata map[string]string
+				if json.Unmarshal([]byte(record.Metadata), &metadata) != nil {
+					return false
+				}
+				return hasImpossibleVital(metadata)
+			})
+		},
+	},
+	{
+		Name:        "orphaned_records",
+		Description: "Health records whose owner no longer exists",
+		Find: func(ctx context.Context, db *gorm.DB) ([]string, error) {
+			return pluckIDs(db.WithContext(ctx).Model(&models.HealthRecord{}).
+				Where("user_id NOT IN (?)", db.Model(&models.User{}).Select("id")))
+		},
+	},
+	{
+		Name:        "orphaned_conversation_messages",
+		Description: "Doctor chat messages whose user no longer exists",
+		Find: func(ctx context.Context, db *gorm.DB) ([]string, error) {
+			return pluckIDs(db.WithContext(ctx).Model(&models.DoctorConversation{}).
+				Where("user_id NOT IN (?)", db.Model(&models.User{}).Select("id")))
+		},
+	},
+	{
+		Name:        "orphaned_search_entries",
+		Description: "Search index entries for records that no longer exist",
+		Find: func(ctx context.Context, db *gorm.DB) ([]string, error) {
+			return pluckIDs(db.WithContext(ctx).Model(&models.RecordSearchIndex{}).
+				Where("record_id NOT IN (?)", db.Model(&models.HealthRecord{}).Select("id")), "record_id")
+		},
+		Fix: func(ctx context.Context, db *gorm.DB) (int, error) {
+			result := db.WithContext(ctx).
+				Where("record_id NOT IN (?)", db.Model(&models.HealthRecord{}).Select("id")).
+				Delete(&models.RecordSearchIndex{})
+			return int(result.RowsAffected), result.Error
+		},
+	},
+	{
+		Name:        "inactive_users",
+		Description: "Users older than 90 days with no records or chat messages",
+		Find: func(ctx context.Context, db *gorm.DB) ([]string, error) {
+			return pluckIDs(db.WithContext(ctx).Model(&models.User{}).
+				Where("created_at < ?", time.Now().Add(-inactiveUserAge)).
+				Where("id NOT IN (?)", db.Model(&models.HealthRecord{}).Select("user_id")).
+				Where("id NOT IN (?)", db.Model(&models.DoctorConversation{}).Select("user_id")))
+		},
+	},
+}
+
+// vitalLimits maps vital-sign metadata keys to the physiologically
+// possible range of their values
+var vitalLimits = map[string][2]float64{
+	"heart_rate":       {20, 300},
+	"respiratory_rate": {2, 80},
+	"spo2":             {40, 100},
+	"systolic":         {40, 300},
+	"diastolic":        {20, 200},
+	"temperature":      {25, 46}, // Celsius
+	"weight_kg":        {0.2, 650},
+	"height_cm":        {20, 275},
+}
+
+func hasImpossibleVital(metadata map[string]string) bool {
+	for key, limits := range vitalLimits {
+		raw, ok := metadata[key]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < limits[0] || value > limits[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// fixRecordMetadata rewrites metadata that is recoverable: empty or null
+// metadata becomes {}, and objects with non-string values have those values
+// converted to strings. Metadata that does not parse is left for a person.
+func fixRecordMetadata(ctx context.Context, db *gorm.DB) (int, error) {
+	fixed := 0
+	var updates []models.HealthRecord
+	_, err := scanRecords(ctx, db, func(record models.HealthRecord) bool {
+		var metadata map[string]string
+		if json.Unmarshal([]byte(record.Metadata), &metadata) == nil {
+			return false
+		}
+		repaired, ok := repairMetadata(record.Metadata)
+		if ok {
+			record.Metadata = repaired
+			updates = append(updates, record)
+		}
+		return ok
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, record := range updates {
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.HealthRecord{}).
+				Where("id = ?", record.ID).
+				UpdateColumn("metadata", record.Metadata).Error; err != nil {
+				return err
+			}
+			return stampRecordSync(tx, &record)
+		})
+		if err != nil {
+			return fixed, fmt.Errorf("failed to repair metadata for %s: %w", record.ID, err)
+		}
+		fixed++
+	}
+	return fixed, nil
+}
+
+func repairMetadata(raw string) (string, bool) {
+	if raw == "" || raw == "null" {
+		return "{}", true
+	}
+
+	var loose map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &loose); err != nil {
+		return "", false
+	}
+	metadata := make(map[string]string, len(loose))
+	for key, value := range loose {
+		switch v := value.(type) {
+		case string:
+			metadata[key] = v
+		case nil:
+			metadata[key] = ""
+		default:
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return "", false
+			}
+			metadata[key] = string(encoded)
+		}
+	}
+	repaired, err := json.Marshal(metadata)
+	if err != nil {
+		return "", false
+	}
+	return string(repaired), true
+}
+
+// pluckIDs returns the ID column (or the named column) of every row query
+// matches
+func pluckIDs(query *gorm.DB, column ...string) ([]string, error) {
+	name := "id"
+	if len(column) > 0 {
+		name = column[0]
+	}
+	var ids []string
+	if err := query.Order(name).Pluck(name, &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// scanRecords walks every health record in batches and returns the IDs of
+// those matching the predicate
+func scanRecords(ctx context.Context, db *gorm.DB, match func(models.HealthRecord) bool) ([]string, error) {
+	var ids []string
+	var batch []models.HealthRecord
+	err := db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Select("id", "user_id", "metadata").
+		FindInBatches(&batch, reindexBatchSize, func(tx *gorm.DB, _ int) error {
+			for _, record := range batch {
+				if match(record) {
+					ids = append(ids, record.ID)
+				}
+			}
+			return ctx.Err()
+		}).Error
+	return ids, err
+}
+
+type DataQualityService struct {
+	db      *gorm.DB
+	limiter *jobs.Limiter
+	checks  []DataQualityCheck
+}
+
+func NewDataQualityService(db *gorm.DB, limiter *jobs.Limiter) *DataQualityService {
+	return &DataQualityService{
+		db:      db,
+		limiter: limiter,
+		checks:  dataQualityChecks,
+	}
+}
+
+// StartReport records a new report and runs the checks in the background,
+// applying safe fixes when fix is set. It waits for a batch job slot first
+// so a busy server rejects the request instead of piling up work.
+func (dqs *DataQualityService) StartReport(ctx context.Context, fix bool) (*models.DataQualityReport, error) {
+	release, err := dqs.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := models.DataQualityReport{
+		ID:        uuid.New().String(),
+		Status:    models.ReportStatusRunning,
+		Fix:       fix,
+		Results:   "[]", // MySQL rejects an empty string in a JSON column
+		CreatedAt: time.Now(),
+	}
+	if err := dqs.db.WithContext(ctx).Create(&report).Error; err != nil {
+		release()
+		return nil, fmt.Errorf("failed to create report: %w", err)
+	}
+
+	go func() {
+		defer release()
+		dqs.run(context.WithoutCancel(ctx), &report)
+	}()
+
+	return &report, nil
+}
+
+// GetReport returns a report and its decoded results
+func (dqs *DataQualityService) GetReport(ctx context.Context, id string) (*models.DataQualityReport, []DataQualityResult, error) {
+	var report models.DataQualityReport
+	if err := dqs.db.WithContext(ctx).Where("id = ?", id).First(&report).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to fetch report: %w", err)
+	}
+
+	var results []DataQualityResult
+	if report.Results != "" {
+		if err := json.Unmarshal([]byte(report.Results), &results); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode report results: %w", err)
+		}
+	}
+	return &report, results, nil
+}
+
+// run executes every registered check and stores the results on the report
+func (dqs *DataQualityService) run(ctx context.Context, report *models.DataQualityReport) {
+	results := dqs.RunChecks(ctx, report.Fix)
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":       models.ReportStatusCompleted,
+		"completed_at": now,
+	}
+	encoded, err := json.Marshal(results)
+	if err != nil {
+		updates["status"] = models.ReportStatusFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["results"] = string(encoded)
+	}
+
+	if err := dqs.db.WithContext(ctx).Model(report).Updates(updates).Error; err != nil {
+		log.Printf("Failed to store data quality report %s: %v", report.ID, err)
+		return
+	}
+	log.Printf("Data quality report %s finished", report.ID)
+}
+
+// RunChecks runs the registry synchronously. A failing check is reported
+// in its result and does not stop the others.
+func (dqs *DataQualityService) RunChecks(ctx context.Context, fix bool) []DataQualityResult {
+	results := make([]DataQualityResult, 0, len(dqs.checks))
+	for _, check := range dqs.checks {
+		result := DataQualityResult{Name: check.Name, Description: check.Description}
+
+		if fix && check.Fix != nil {
+			fixed, err := check.Fix(ctx, dqs.db.WithContext(ctx))
+			result.Fixed = fixed
+			if err != nil {
+				result.Error = fmt.Sprintf("fix failed: %v", err)
+			}
+		}
+
+		ids, err := check.Find(ctx, dqs.db.WithContext(ctx))
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Affected = len(ids)
+		if len(ids) > dataQualitySampleSize {
+			ids = ids[:dataQualitySampleSize]
+		}
+		result.SampleIDs = ids
+
+		results = append(results, result)
+	}
+	return results
+}
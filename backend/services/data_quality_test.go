@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/jobs"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// seedMessyDatabase plants one or more problems for every registered check
+// next to rows that are fine
+func seedMessyDatabase(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	createUser(t, db, "active")
+	createUser(t, db, "new-user")
+	old := createUser(t, db, "inactive")
+	db.Model(old).Update("created_at", time.Now().Add(-100*24*time.Hour))
+
+	for _, record := range []models.HealthRecord{
+		{ID: "clean", UserID: "active", Title: "Lipid panel", Metadata: `{"heart_rate":"72","spo2":"98"}`},
+		{ID: "empty-title", UserID: "active", Title: "   ", Metadata: `{}`},
+		{ID: "unparseable-metadata", UserID: "active", Title: "Scan", Metadata: `{"lab": "City`},
+		{ID: "missing-metadata", UserID: "active", Title: "Scan", Metadata: ``},
+		{ID: "numeric-metadata", UserID: "active", Title: "Scan", Metadata: `{"count":3,"note":null}`},
+		{ID: "impossible-heart-rate", UserID: "active", Title: "Vitals", Metadata: `{"heart_rate":"900"}`},
+		{ID: "unreadable-temperature", UserID: "active", Title: "Vitals", Metadata: `{"temperature":"hot"}`},
+		{ID: "orphaned-record", UserID: "deleted-user", Title: "Old scan", Metadata: `{}`},
+	} {
+		record.RecordType = "lab_result"
+		record.CreatedAt, record.UpdatedAt = time.Now(), time.Now()
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatalf("create record %s: %v", record.ID, err)
+		}
+	}
+	for _, turn := range []models.DoctorConversation{
+		{ID: "turn-ok", UserID: "active", ConversationID: "conv-1", Message: "hi", CreatedAt: time.Now()},
+		{ID: "turn-orphaned", UserID: "deleted-user", ConversationID: "conv-2", Message: "hi", CreatedAt: time.Now()},
+	} {
+		if err := db.Create(&turn).Error; err != nil {
+			t.Fatalf("create turn: %v", err)
+		}
+	}
+	for _, entry := range []models.RecordSearchIndex{
+		{RecordID: "clean", UserID: "active", Terms: " lipid panel "},
+		{RecordID: "long-gone", UserID: "active", Terms: " ghost "},
+	} {
+		if err := db.Create(&entry).Error; err != nil {
+			t.Fatalf("create index entry: %v", err)
+		}
+	}
+}
+
+func resultsByName(results []DataQualityResult) map[string]DataQualityResult {
+	byName := make(map[string]DataQualityResult, len(results))
+	for _, result := range results {
+		byName[result.Name] = result
+	}
+	return byName
+}
+
+func TestDataQualityChecksFindPlantedProblems(t *testing.T) {
+	db := newTestDB(t)
+	seedMessyDatabase(t, db)
+	dqs := NewDataQualityService(db, jobs.NewLimiter(0, 0, 0))
+
+	want := map[string][]string{
+		"empty_record_titles":            {"empty-title"},
+		"invalid_record_metadata":        {"missing-metadata", "numeric-metadata", "unparseable-metadata"},
+		"impossible_vitals":              {"impossible-heart-rate", "unreadable-temperature"},
+		"orphaned_records":               {"orphaned-record"},
+		"orphaned_conversation_messages": {"turn-orphaned"},
+		"orphaned_search_entries":        {"long-gone"},
+		"inactive_users":                 {"inactive"},
+	}
+	results := resultsByName(dqs.RunChecks(context.Background(), false))
+	if len(results) != len(dataQualityChecks) || len(want) != len(dataQualityChecks) {
+		t.Fatalf("got %d results for %d checks; every check needs a planted problem", len(results), len(dataQualityChecks))
+	}
+	for name, wantIDs := range want {
+		result := results[name]
+		got := append([]string(nil), result.SampleIDs...)
+		sort.Strings(got)
+		if result.Error != "" || result.Affected != len(wantIDs) || !reflect.DeepEqual(got, wantIDs) {
+			t.Errorf("%s = %+v, want %v", name, result, wantIDs)
+		}
+		if result.Fixed != 0 {
+			t.Errorf("%s fixed %d rows without fix mode", name, result.Fixed)
+		}
+	}
+
+	var metadata string
+	db.Model(&models.HealthRecord{}).Where("id = ?", "numeric-metadata").Pluck("metadata", &metadata)
+	if metadata != `{"count":3,"note":null}` {
+		t.Errorf("report without fix mode changed metadata to %s", metadata)
+	}
+}
+
+func TestDataQualityFixMode(t *testing.T) {
+	db := newTestDB(t)
+	seedMessyDatabase(t, db)
+	dqs := NewDataQualityService(db, jobs.NewLimiter(0, 0, 0))
+
+	results := resultsByName(dqs.RunChecks(context.Background(), true))
+
+	metadata := results["invalid_record_metadata"]
+	if metadata.Fixed != 2 || metadata.Affected != 1 || metadata.SampleIDs[0] != "unparseable-metadata" {
+		t.Errorf("invalid_record_metadata = %+v, want two repaired and the unparseable one left", metadata)
+	}
+	if search := results["orphaned_search_entries"]; search.Fixed != 1 || search.Affected != 0 {
+		t.Errorf("orphaned_search_entries = %+v, want the orphan removed", search)
+	}
+	if titles := results["empty_record_titles"]; titles.Fixed != 0 || titles.Affected != 1 {
+		t.Errorf("empty_record_titles = %+v, want it reported but not touched", titles)
+	}
+
+	for id, want := range map[string]string{
+		"missing-metadata":     `{}`,
+		"numeric-metadata":     `{"count":"3","note":""}`,
+		"unparseable-metadata": `{"lab": "City`,
+	} {
+		var got string
+		db.Model(&models.HealthRecord{}).Where("id = ?", id).Pluck("metadata", &got)
+		if got != want {
+			t.Errorf("%s metadata = %s, want %s", id, got, want)
+		}
+	}
+}
+
+func TestDataQualityReportRunsInBackground(t *testing.T) {
+	db := newTestDB(t)
+	seedMessyDatabase(t, db)
+	dqs := NewDataQualityService(db, jobs.NewLimiter(1, 0, 0))
+	ctx := context.Background()
+
+	report, err := dqs.StartReport(ctx, false)
+	if err != nil {
+		t.Fatalf("StartReport: %v", err)
+	}
+	if report.Status != models.ReportStatusRunning {
+		t.Errorf("new report status = %s", report.Status)
+	}
+
+	var results []DataQualityResult
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, r, err := dqs.GetReport(ctx, report.ID)
+		if err != nil {
+			t.Fatalf("GetReport: %v", err)
+		}
+		if stored.Status == models.ReportStatusCompleted {
+			results = r
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("report still %s", stored.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := resultsByName(results)["orphaned_records"]; got.Affected != 1 {
+		t.Errorf("stored results = %+v", results)
+	}
+
+	if _, _, err := dqs.GetReport(ctx, "no-such-report"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown report: error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize bounds how many deleted records one purge transaction
+// removes
+const purgeBatchSize = 500
+
+// RestoreRecord takes one of userID's records out of the trash. Its search
+// entry is rebuilt and clients pick it up again on their next sync. Its
+// medication schedule comes back, as do its links to records that are not
+// in the trash themselves and the reminders deleting it cancelled that are
+// still to come.
+func (hrs *HealthRecordsService) RestoreRecord(ctx context.Context, userID, recordID string) (*models.HealthRecord, error) {
+	var record models.HealthRecord
+	err := hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var records []models.HealthRecord
+		if err := tx.Unscoped().Scopes(scopeOwner(userID)).
+			Where("id = ? AND deleted_at IS NOT NULL", recordID).
+			Limit(1).Find(&records).Error; err != nil {
+			return fmt.Errorf("failed to fetch record: %w", err)
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("%w: deleted record %s", ErrNotFound, recordID)
+		}
+		record = records[0]
+		deletedAt := record.DeletedAt.Time
+
+		now := time.Now()
+		if err := tx.Unscoped().Model(&models.HealthRecord{}).Where("id = ?", recordID).
+			Updates(map[string]interface{}{"deleted_at": nil, "updated_at": now}).Error; err != nil {
+			return fmt.Errorf("failed to restore record: %w", err)
+		}
+		record.DeletedAt = gorm.DeletedAt{}
+		record.UpdatedAt = now
+
+		// Clients that removed the record get it back as a change, and a
+		// later deletion leaves a fresh tombstone
+		if err := tx.Delete(&models.RecordTombstone{}, "record_id = ?", recordID).Error; err != nil {
+			return fmt.Errorf("failed to remove tombstone: %w", err)
+		}
+		if err := stampRecordSync(tx, &record); err != nil {
+			return err
+		}
+		if err := indexRecord(tx, &record); err != nil {
+			return fmt.Errorf("failed to index record: %w", err)
+		}
+		if err := restoreRecordSchedule(tx, &record, deletedAt, now); err != nil {
+			return err
+		}
+		summary := strings.ReplaceAll(record.RecordType, "_", " ") + " restored"
+		return recordChange(tx, userID, &record, models.ChangeEntityRecord, record.ID, models.ChangeKindRestored, summary)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// restoreRecordSchedule brings back what deleting record at deletedAt put
+// in the trash or cancelled along with it
+func restoreRecordSchedule(tx *gorm.DB, record *models.HealthRecord, deletedAt, now time.Time) error {
+	var medications []models.Medication
+	if err := tx.Unscoped().Where("record_id = ? AND deleted_at IS NOT NULL", record.ID).Find(&medications).Error; err != nil {
+		return fmt.Errorf("failed to fetch medication: %w", err)
+	}
+	for _, medication := range medications {
+		if err := tx.Unscoped().Model(&models.Medication{}).Where("id = ?", medication.ID).Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore medication: %w", err)
+		}
+		if err := recordChange(tx, record.UserID, record, models.ChangeEntityMedication, medication.ID,
+			models.ChangeKindStarted, "prescription restored"); err != nil {
+			return err
+		}
+	}
+
+	// A link stays in the trash while the record at its other end is there
+	live := tx.Model(&models.HealthRecord{}).Select("id")
+	if err := tx.Unscoped().Model(&models.RecordLink{}).
+		Where("(source_id = ? OR target_id = ?) AND deleted_at IS NOT NULL", record.ID, record.ID).
+		Where("source_id IN (?) AND target_id IN (?)", live, live).
+		Update("deleted_at", nil).Error; err != nil {
+		return fmt.Errorf("failed to restore record links: %w", err)
+	}
+
+	// Reminders cancelled before the deletion stay cancelled, and ones due
+	// while the record was in the trash are not sent late
+	if err := tx.Model(&models.Reminder{}).
+		Where("record_id = ? AND sent_at IS NULL AND cancelled_at >= ? AND due_at > ?", record.ID, deletedAt, now).
+		Update("cancelled_at", nil).Error; err != nil {
+		return fmt.Errorf("failed to restore reminders: %w", err)
+	}
+	return nil
+}
+
+// ListDeletedRecords returns the records in the trash, most recently
+// deleted first, and how many there are in all. An empty userID lists
+// every user's. Only identifying columns are loaded, never content.
+func (hrs *HealthRecordsService) ListDeletedRecords(ctx context.Context, userID string, limit, offset int) ([]models.HealthRecord, int64, error) {
+	limit, offset = pageBounds(limit, offset)
+
+	query := hrs.db.WithContext(ctx).Unscoped().Model(&models.HealthRecord{}).Where("deleted_at IS NOT NULL")
+	if userID != "" {
+		query = query.Scopes(scopeOwner(userID))
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count deleted records: %w", err)
+	}
+	var records []models.HealthRecord
+	if err := query.Select("id, user_id, record_type, created_at, deleted_at").Order("deleted_at DESC").Limit(limit).Offset(offset).Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list deleted records: %w", err)
+	}
+	return records, total, nil
+}
+
+// PurgeDeletedRecords permanently removes records that have been in the
+// trash longer than the configured retention
+func (hrs *HealthRecordsService) PurgeDeletedRecords(ctx context.Context) (int, error) {
+	return hrs.PurgeDeletedOlderThan(ctx, time.Duration(hrs.config.DeletedRetention)*time.Second)
+}
+
+// PurgeDeletedOlderThan permanently removes records deleted more than age
+// ago, together with their scans, corrections, revisions, medication and
+// links
+func (hrs *HealthRecordsService) PurgeDeletedOlderThan(ctx context.Context, age time.Duration) (int, error) {
+	cutoff := time.Now().Add(-age)
+
+	purged := 0
+	for {
+		var ids []string
+		if err := hrs.db.WithContext(ctx).Unscoped().Model(&models.HealthRecord{}).
+			Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff).
+			Limit(purgeBatchSize).Pluck("id", &ids).Error; err != nil {
+			return purged, fmt.Errorf("failed to find deleted records: %w", err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		err := hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			scanIDs := tx.Unscoped().Model(&models.HealthRecord{}).Select("scan_id").Where("id IN ?", ids)
+			if err := tx.Where("id IN (?)", scanIDs).Delete(&models.ScanInput{}).Error; err != nil {
+				return fmt.Errorf("failed to remove scans: %w", err)
+			}
+			if err := tx.Where("record_id IN ?", ids).Delete(&models.Correction{}).Error; err != nil {
+				return fmt.Errorf("failed to remove corrections: %w", err)
+			}
+			if err := tx.Where("record_id IN ?", ids).Delete(&models.RecordRevision{}).Error; err != nil {
+				return fmt.Errorf("failed to remove record revisions: %w", err)
+			}
+			if err := tx.Unscoped().Where("record_id IN ?", ids).Delete(&models.Medication{}).Error; err != nil {
+				return fmt.Errorf("failed to remove medication: %w", err)
+			}
+			if err := tx.Unscoped().Where("source_id IN ? OR target_id IN ?", ids, ids).Delete(&models.RecordLink{}).Error; err != nil {
+				return fmt.Errorf("failed to remove record links: %w", err)
+			}
+			if err := tx.Unscoped().Where("id IN ?", ids).Delete(&models.HealthRecord{}).Error; err != nil {
+				return fmt.Errorf("failed to purge records: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += len(ids)
+		if len(ids) < purgeBatchSize {
+			break
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted records", purged)
+	}
+	return purged, nil
+}
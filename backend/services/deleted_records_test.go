@@ -0,0 +1,343 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// deleteRecordAt soft-deletes recordID through the service, then backdates
+// the deletion to at
+func deleteRecordAt(t *testing.T, hrs *HealthRecordsService, db *gorm.DB, userID, recordID string, at time.Time) {
+	t.Helper()
+	if err := hrs.DeleteRecord(context.Background(), userID, recordID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if err := db.Unscoped().Model(&models.HealthRecord{}).Where("id = ?", recordID).Update("deleted_at", at).Error; err != nil {
+		t.Fatalf("backdate deletion: %v", err)
+	}
+}
+
+// recordExists reports whether any row for recordID remains, deleted or not
+func recordExists(t *testing.T, db *gorm.DB, recordID string) bool {
+	t.Helper()
+	var count int64
+	if err := db.Unscoped().Model(&models.HealthRecord{}).Where("id = ?", recordID).Count(&count).Error; err != nil {
+		t.Fatalf("count records: %v", err)
+	}
+	return count > 0
+}
+
+func TestPurgeRemovesAgedDeletedRecords(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, &config.RecordsConfig{DeletedRetention: 7 * 24 * 3600})
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+	now := time.Now()
+
+	var ids []string
+	for _, title := range []string{"Aged", "Recent", "Live"} {
+		record, err := hrs.CreateRecord(ctx, "user-1", "lab_result", title, "", map[string]string{"glucose": "90"})
+		if err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		if _, err := hrs.UpdateRecord(ctx, "user-1", record.ID, title, "Edited", map[string]string{"glucose": "95"}); err != nil {
+			t.Fatalf("UpdateRecord: %v", err)
+		}
+		ids = append(ids, record.ID)
+	}
+	aged, recent, live := ids[0], ids[1], ids[2]
+	deleteRecordAt(t, hrs, db, "user-1", aged, now.Add(-8*24*time.Hour))
+	deleteRecordAt(t, hrs, db, "user-1", recent, now.Add(-6*24*time.Hour))
+
+	purged, err := hrs.PurgeDeletedRecords(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedRecords = %d, %v; want 1", purged, err)
+	}
+	if recordExists(t, db, aged) {
+		t.Error("record deleted 8 days ago was kept")
+	}
+	var revisions int64
+	db.Model(&models.RecordRevision{}).Where("record_id = ?", aged).Count(&revisions)
+	if revisions != 0 {
+		t.Errorf("%d revisions of the purged record kept", revisions)
+	}
+	if !recordExists(t, db, recent) || !recordExists(t, db, live) {
+		t.Error("recently deleted or live record purged")
+	}
+	if _, total, _ := hrs.ListDeletedRecords(ctx, "user-1", 10, 0); total != 1 {
+		t.Errorf("%d records left in the trash, want 1", total)
+	}
+
+	// A purged record is gone for good; a retained one can still come back
+	if _, err := hrs.RestoreRecord(ctx, "user-1", aged); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restore after purge: error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := hrs.RestoreRecord(ctx, "user-1", recent); err != nil {
+		t.Errorf("restore within retention: %v", err)
+	}
+
+	if purged, err := hrs.PurgeDeletedRecords(ctx); err != nil || purged != 0 {
+		t.Errorf("second purge = %d, %v; want nothing", purged, err)
+	}
+}
+
+func TestPurgeDeletedOlderThan(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, &config.RecordsConfig{DeletedRetention: 30 * 24 * 3600})
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	now := time.Now()
+
+	for i, age := range []time.Duration{time.Hour, 3 * time.Hour, 5 * time.Hour} {
+		for _, user := range []string{"user-1", "user-2"} {
+			id := user + "-" + string(rune('a'+i))
+			createRecord(t, db, id, user, models.SensitivityStandard)
+			deleteRecordAt(t, hrs, db, user, id, now.Add(-age))
+		}
+	}
+
+	purged, err := hrs.PurgeDeletedOlderThan(context.Background(), 2*time.Hour)
+	if err != nil || purged != 4 {
+		t.Fatalf("PurgeDeletedOlderThan(2h) = %d, %v; want 4 across both users", purged, err)
+	}
+	for _, id := range []string{"user-1-a", "user-2-a"} {
+		if !recordExists(t, db, id) {
+			t.Errorf("%s, deleted an hour ago, was purged", id)
+		}
+	}
+}
+
+func TestDeleteRecordIsSoft(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	createRecord(t, db, "rec-2", "user-1", models.SensitivityStandard)
+	ctx := context.Background()
+
+	if err := hrs.DeleteRecord(ctx, "user-1", "rec-1"); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if _, err := hrs.GetRecord(ctx, "user-1", "rec-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRecord of a deleted record: %v, want %v", err, ErrNotFound)
+	}
+	records, total, err := hrs.ListRecords(ctx, "user-1", ListRecordsOptions{})
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if got := recordIDs(records); !slices.Equal(got, []string{"rec-2"}) || total != 1 {
+		t.Errorf("ListRecords = %v of %d, want [rec-2] of 1", got, total)
+	}
+
+	// The row and its content stay, marked deleted
+	var stored models.HealthRecord
+	if err := db.Unscoped().First(&stored, "id = ?", "rec-1").Error; err != nil {
+		t.Fatalf("the deleted row is gone: %v", err)
+	}
+	if !stored.DeletedAt.Valid || stored.Title != "Record rec-1" {
+		t.Errorf("deleted row = %+v, want its content kept and deleted_at set", stored)
+	}
+	if err := hrs.DeleteRecord(ctx, "user-1", "rec-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a deleted record: %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestRestoreRecord(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	createRecord(t, db, "rec-2", "user-1", models.SensitivityStandard)
+	ctx := context.Background()
+	if err := hrs.DeleteRecord(ctx, "user-1", "rec-1"); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+
+	// Only the owner can restore, and only what is in the trash
+	for name, r := range map[string]struct{ user, id string }{
+		"another user's record": {"user-2", "rec-1"},
+		"a record not deleted":  {"user-1", "rec-2"},
+		"an unknown record":     {"user-1", "missing"},
+	} {
+		if _, err := hrs.RestoreRecord(ctx, r.user, r.id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("restore of %s: %v, want %v", name, err, ErrNotFound)
+		}
+	}
+
+	restored, err := hrs.RestoreRecord(ctx, "user-1", "rec-1")
+	if err != nil {
+		t.Fatalf("RestoreRecord: %v", err)
+	}
+	if restored.ID != "rec-1" || restored.DeletedAt.Valid {
+		t.Errorf("restored record = %+v", restored)
+	}
+	record, err := hrs.GetRecord(ctx, "user-1", "rec-1")
+	if err != nil || record.Description != "Details of rec-1" {
+		t.Errorf("GetRecord after the restore = %+v, %v", record, err)
+	}
+	if _, total, _ := hrs.ListRecords(ctx, "user-1", ListRecordsOptions{}); total != 2 {
+		t.Errorf("%d records listed after the restore, want 2", total)
+	}
+
+	// Syncing clients get the record back as a change, not a deletion
+	var tombstones int64
+	db.Model(&models.RecordTombstone{}).Where("record_id = ?", "rec-1").Count(&tombstones)
+	if tombstones != 0 {
+		t.Error("the restored record still has a tombstone")
+	}
+	var kinds []string
+	db.Model(&models.ChangeEvent{}).Where("record_id = ?", "rec-1").Order("created_at, id").Pluck("kind", &kinds)
+	if len(kinds) == 0 || kinds[len(kinds)-1] != models.ChangeKindRestored {
+		t.Errorf("changes of the record = %v, want the last to be %q", kinds, models.ChangeKindRestored)
+	}
+
+	if err := hrs.DeleteRecord(ctx, "user-1", "rec-1"); err != nil {
+		t.Errorf("deleting the restored record again: %v", err)
+	}
+}
+
+func TestRestoreRecordBringsBackItsSchedule(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	for _, id := range []string{"rx", "lab", "old"} {
+		createRecord(t, db, id, "user-1", models.SensitivityStandard)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	db.Create(&models.Medication{ID: "med-1", UserID: "user-1", RecordID: "rx", Name: "Amoxicillin", TimesOfDay: "08:00",
+		EveryDays: 1, Timezone: "UTC", StartsAt: now, ScheduledUntil: now.Add(24 * time.Hour)})
+	for _, link := range [][2]string{{"rx", "lab"}, {"old", "rx"}} {
+		if _, err := hrs.LinkRecords(ctx, "user-1", link[0], link[1], "related"); err != nil {
+			t.Fatalf("LinkRecords: %v", err)
+		}
+	}
+	earlier := now.Add(-time.Hour)
+	db.Create(&[]models.Reminder{
+		{ID: "dose", UserID: "user-1", RecordID: "rx", Kind: models.ReminderKindDose, DueAt: now.Add(time.Hour)},
+		{ID: "dismissed", UserID: "user-1", RecordID: "rx", Kind: models.ReminderKindManual, DueAt: now.Add(time.Hour), CancelledAt: &earlier},
+	})
+
+	if err := hrs.DeleteRecord(ctx, "user-1", "old"); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if err := hrs.DeleteRecord(ctx, "user-1", "rx"); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	// In the trash the schedule and links are gone from every read
+	var medications int64
+	db.Model(&models.Medication{}).Count(&medications)
+	if related, _ := hrs.RelatedRecordIDs(ctx, "lab"); medications != 0 || len(related) != 0 {
+		t.Fatalf("with the record in the trash: %d medications, lab related to %v; want none", medications, related)
+	}
+
+	if _, err := hrs.RestoreRecord(ctx, "user-1", "rx"); err != nil {
+		t.Fatalf("RestoreRecord: %v", err)
+	}
+	var medication models.Medication
+	if err := db.First(&medication, "record_id = ?", "rx").Error; err != nil {
+		t.Errorf("medication not restored: %v", err)
+	}
+	// The link to a record still in the trash waits for that one
+	if related, _ := hrs.RelatedRecordIDs(ctx, "rx"); !slices.Equal(related, []string{"lab"}) {
+		t.Errorf("rx related to %v after the restore, want [lab]", related)
+	}
+	cancelled := make(map[string]bool)
+	for _, reminder := range recordReminders(t, db, "rx") {
+		cancelled[reminder.ID] = reminder.CancelledAt != nil
+	}
+	if cancelled["dose"] || !cancelled["dismissed"] {
+		t.Errorf("reminders cancelled after the restore = %v, want only the one cancelled before the deletion", cancelled)
+	}
+
+	if _, err := hrs.RestoreRecord(ctx, "user-1", "old"); err != nil {
+		t.Fatalf("RestoreRecord: %v", err)
+	}
+	if related, _ := hrs.RelatedRecordIDs(ctx, "rx"); !slices.Equal(related, []string{"lab", "old"}) {
+		t.Errorf("rx related to %v after both restores, want [lab old]", related)
+	}
+}
+
+func TestListDeletedRecords(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	createRecord(t, db, "kept", "user-1", models.SensitivityStandard)
+	now := time.Now()
+	for i, r := range []struct{ id, user string }{{"rec-1", "user-1"}, {"rec-2", "user-1"}, {"rec-3", "user-2"}} {
+		createRecord(t, db, r.id, r.user, models.SensitivityStandard)
+		deleteRecordAt(t, hrs, db, r.user, r.id, now.Add(time.Duration(i-3)*time.Minute))
+	}
+	ctx := context.Background()
+
+	records, total, err := hrs.ListDeletedRecords(ctx, "", 10, 0)
+	if err != nil {
+		t.Fatalf("ListDeletedRecords: %v", err)
+	}
+	if got := recordIDs(records); !slices.Equal(got, []string{"rec-3", "rec-2", "rec-1"}) || total != 3 {
+		t.Errorf("every user's deleted records = %v of %d, want [rec-3 rec-2 rec-1] of 3", got, total)
+	}
+	if r := records[0]; r.UserID != "user-2" || r.Title != "" || r.Description != "" {
+		t.Errorf("listed deleted record = %+v, want identifying columns only", r)
+	}
+
+	records, total, _ = hrs.ListDeletedRecords(ctx, "user-1", 1, 1)
+	if got := recordIDs(records); !slices.Equal(got, []string{"rec-1"}) || total != 2 {
+		t.Errorf("second page of user-1's deleted records = %v of %d, want [rec-1] of 2", got, total)
+	}
+}
+
+func TestPurgeRemovesScansAndCorrections(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	now := time.Now()
+	for _, id := range []string{"aged", "recent"} {
+		record := createRecord(t, db, id, "user-1", models.SensitivityStandard)
+		scan := models.ScanInput{ID: "scan-" + id, UserID: "user-1", Image: []byte("image")}
+		db.Create(&scan)
+		db.Model(record).Update("scan_id", scan.ID)
+		db.Create(&models.Correction{ID: "corr-" + id, UserID: "user-1", RecordID: id, ScanID: scan.ID, Field: "title"})
+		db.Create(&models.Medication{ID: "med-" + id, UserID: "user-1", RecordID: id})
+	}
+	createRecord(t, db, "lab", "user-1", models.SensitivityStandard)
+	for _, id := range []string{"aged", "recent"} {
+		if _, err := hrs.LinkRecords(context.Background(), "user-1", id, "lab", "related"); err != nil {
+			t.Fatalf("LinkRecords: %v", err)
+		}
+	}
+	deleteRecordAt(t, hrs, db, "user-1", "aged", now.Add(-48*time.Hour))
+	deleteRecordAt(t, hrs, db, "user-1", "recent", now.Add(-time.Hour))
+
+	// Until the purge a deleted record keeps its scan and corrections
+	var corrections int64
+	db.Model(&models.Correction{}).Count(&corrections)
+	if corrections != 2 {
+		t.Fatalf("%d corrections after deletion, want both kept", corrections)
+	}
+
+	if purged, err := hrs.PurgeDeletedOlderThan(context.Background(), 24*time.Hour); err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedOlderThan = %d, %v; want 1", purged, err)
+	}
+	var scans, kept []string
+	db.Model(&models.ScanInput{}).Order("id").Pluck("id", &scans)
+	db.Model(&models.Correction{}).Order("id").Pluck("id", &kept)
+	if !slices.Equal(scans, []string{"scan-recent"}) || !slices.Equal(kept, []string{"corr-recent"}) {
+		t.Errorf("after the purge scans = %v, corrections = %v; want only the recent record's", scans, kept)
+	}
+	var medications, links []string
+	db.Unscoped().Model(&models.Medication{}).Pluck("id", &medications)
+	db.Unscoped().Model(&models.RecordLink{}).Pluck("source_id", &links)
+	if !slices.Equal(medications, []string{"med-recent"}) || !slices.Equal(links, []string{"recent"}) {
+		t.Errorf("after the purge medications = %v, links from %v; want only the recent record's", medications, links)
+	}
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// deliveryBatchSize bounds how many messages one queue run attempts
+const deliveryBatchSize = 100
+
+// deliveryLease is how long a queue run holds a message it is sending.
+// Other runs, on this instance or another, leave it alone until then, and
+// take it up again if the run holding it stopped before recording the
+// outcome.
+const deliveryLease = 5 * time.Minute
+
+// Sender delivers a queued message on one channel. Senders may be called
+// more than once for the same message, so they should pass d.ID on as an
+// idempotency reference where the transport supports it.
+type Sender interface {
+	Send(ctx context.Context, d *models.Delivery) error
+}
+
+// DeliveryQueue persists outbound email and push messages and sends them
+// with retries, giving at-least-once delivery.
+type DeliveryQueue struct {
+	db         *gorm.DB
+	config     *config.DeliveryConfig
+	senders    map[string]Sender
+	classifier *RetryClassifier // optional; nil uses the default categories
+	now        func() time.Time
+}
+
+func NewDeliveryQueue(db *gorm.DB, cfg *config.DeliveryConfig, senders map[string]Sender, classifier *RetryClassifier) *DeliveryQueue {
+	return &DeliveryQueue{
+		db:         db,
+		config:     cfg,
+		senders:    senders,
+		classifier: classifier,
+		now:        time.Now,
+	}
+}
+
+// Enqueue stores a message for delivery on the next queue run. Callers set
+// Channel, Recipient, Subject, Body and Link, and may set ID up front when
+// the reference has to appear in the message itself. Sensitive marks a
+// body, such as a sign-in code, that must not outlive its delivery. FallbackChannel and
+// FallbackRecipient optionally name where to send the message instead if
+// the first attempt fails. Pass a transaction as tx to queue the message
+// atomically with other writes, or nil to use the queue's own connection.
+func (dq *DeliveryQueue) Enqueue(tx *gorm.DB, delivery *models.Delivery) error {
+	if !dq.HasChannel(delivery.Channel) {
+		return fmt.Errorf("no sender for channel %q", delivery.Channel)
+	}
+	if delivery.FallbackChannel != "" && !dq.HasChannel(delivery.FallbackChannel) {
+		return fmt.Errorf("no sender for fallback channel %q", delivery.FallbackChannel)
+	}
+	if tx == nil {
+		tx = dq.db
+	}
+
+	now := dq.now()
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+	delivery.Status = models.DeliveryStatusPending
+	delivery.Attempts = 0
+	delivery.MaxAttempts = dq.config.MaxAttempts
+	delivery.NextAttemptAt = now
+	delivery.CreatedAt = now
+	delivery.UpdatedAt = now
+	if err := tx.Create(delivery).Error; err != nil {
+		return fmt.Errorf("failed to queue delivery: %w", err)
+	}
+
+	return nil
+}
+
+// HasChannel reports whether the queue has a sender for channel
+func (dq *DeliveryQueue) HasChannel(channel string) bool {
+	_, ok := dq.senders[channel]
+	return ok
+}
+
+// Notify queues a push notification, letting the queue stand in for a
+// Notifier so callers get retries without further changes.
+func (dq *DeliveryQueue) Notify(ctx context.Context, n Notification) error {
+	return dq.Enqueue(dq.db.WithContext(ctx), &models.Delivery{
+		Channel:   models.DeliveryChannelPush,
+		Recipient: n.UserID,
+		Subject:   n.Title,
+		Body:      n.Body,
+		Link:      n.Link,
+	})
+}
+
+// GetDelivery returns a queued message by its reference
+func (dq *DeliveryQueue) GetDelivery(ctx context.Context, id string) (*models.Delivery, error) {
+	var delivery models.Delivery
+	if err := dq.db.WithContext(ctx).Where("id = ?", id).First(&delivery).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+// ProcessDue attempts every pending message whose next attempt is due and
+// returns how many were sent. A message with a fallback moves to it after
+// its first failure and is retried on the next run. Otherwise a failed
+// attempt is rescheduled with exponential backoff until MaxAttempts is
+// reached, then marked failed; failures the classifier does not consider
+// retryable, such as a rejected recipient, are marked failed at once. Each
+// message is claimed before it is sent, so runs on several instances do
+// not send the same message.
+func (dq *DeliveryQueue) ProcessDue(ctx context.Context) (int, error) {
+	var due []models.Delivery
+	if err := dq.db.WithContext(ctx).Where("status = ? AND next_attempt_at <= ?", models.DeliveryStatusPending, dq.now()).
+		Order("next_attempt_at ASC").
+		Limit(deliveryBatchSize).
+		Find(&due).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch due deliveries: %w", err)
+	}
+
+	sent := 0
+	for i := range due {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+		delivery := &due[i]
+		claimed, err := dq.claim(ctx, delivery)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+		if err := dq.attempt(ctx, delivery); err != nil {
+			return sent, err
+		}
+		if delivery.Status == models.DeliveryStatusSent {
+			sent++
+		}
+	}
+
+	if sent > 0 {
+		log.Printf("Delivered %d queued messages", sent)
+	}
+	return sent, nil
+}
+
+// claim leases a due message to this run for deliveryLease, reporting
+// false when another run got to it first
+func (dq *DeliveryQueue) claim(ctx context.Context, delivery *models.Delivery) (bool, error) {
+	now := dq.now()
+	result := dq.db.WithContext(ctx).Model(&models.Delivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", delivery.ID, models.DeliveryStatusPending, now).
+		Update("next_attempt_at", now.Add(deliveryLease))
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim delivery: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// attempt sends one message and records the outcome
+func (dq *DeliveryQueue) attempt(ctx context.Context, delivery *models.Delivery) error {
+	sendErr := fmt.Errorf("no sender for channel %q", delivery.Channel)
+	sender, ok := dq.senders[delivery.Channel]
+	if ok {
+		sendErr = sender.Send(ctx, delivery)
+	}
+
+	now := dq.now()
+	delivery.Attempts++
+	delivery.UpdatedAt = now
+	switch {
+	case sendErr == nil:
+		delivery.Status = models.DeliveryStatusSent
+		delivery.SentAt = &now
+		delivery.LastError = ""
+	case delivery.FallbackChannel != "":
+		delivery.LastError = fmt.Sprintf("%s: %v", delivery.Channel, sendErr)
+		log.Printf("Delivery %s failed on %s, falling back to %s: %v", delivery.ID, delivery.Channel, delivery.FallbackChannel, sendErr)
+		delivery.Channel, delivery.Recipient = delivery.FallbackChannel, delivery.FallbackRecipient
+		delivery.FallbackChannel, delivery.FallbackRecipient = "", ""
+		delivery.NextAttemptAt = now
+	case !dq.classifier.Retryable(delivery.Channel, sender, sendErr):
+		delivery.Status = models.DeliveryStatusFailed
+		delivery.LastError = sendErr.Error()
+		log.Printf("Giving up on delivery %s: %s error is not retryable: %v", delivery.ID, CategorizeError(sender, sendErr), sendErr)
+	case delivery.Attempts >= delivery.MaxAttempts:
+		delivery.Status = models.DeliveryStatusFailed
+		delivery.LastError = sendErr.Error()
+		log.Printf("Giving up on delivery %s after %d attempts: %v", delivery.ID, delivery.Attempts, sendErr)
+	default:
+		delivery.NextAttemptAt = now.Add(dq.backoff(delivery.Attempts))
+		delivery.LastError = sendErr.Error()
+		log.Printf("Delivery %s attempt %d failed, retrying at %s: %v", delivery.ID, delivery.Attempts, delivery.NextAttemptAt.Format(time.RFC3339), sendErr)
+	}
+	if delivery.Sensitive && delivery.Status != models.DeliveryStatusPending {
+		delivery.Body = ""
+	}
+
+	if err := dq.db.WithContext(ctx).Save(delivery).Error; err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	return nil
+}
+
+// PurgeFinished deletes messages sent or given up on longer ago than the
+// configured retention
+func (dq *DeliveryQueue) PurgeFinished(ctx context.Context) (int, error) {
+	cutoff := dq.now().Add(-time.Duration(dq.config.Retention) * time.Second)
+	result := dq.db.WithContext(ctx).
+		Where("status IN ? AND updated_at <= ?", []string{models.DeliveryStatusSent, models.DeliveryStatusFailed}, cutoff).
+		Delete(&models.Delivery{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge deliveries: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Purged %d finished deliveries", result.RowsAffected)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// backoff returns the delay before the retry following the given attempt:
+// BaseBackoff doubled per failed attempt, capped at MaxBackoff.
+func (dq *DeliveryQueue) backoff(attempts int) time.Duration {
+	delay := time.Duration(dq.config.BaseBackoff) * time.Second
+	limit := time.Duration(dq.config.MaxBackoff) * time.Second
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// NewLogEmailSender returns a Sender that only logs emails, for development
+func NewLogEmailSender() Sender {
+	return &logEmailSender{}
+}
+
+type logEmailSender struct{}
+
+func (ls *logEmailSender) Send(ctx context.Context, d *models.Delivery) error {
+	// In production, send via email service
+	log.Printf("Email to %s: %s (ref %s)", d.Recipient, d.Subject, d.ID)
+	return nil
+}
+
+// NewLogWhatsAppSender returns a Sender that only logs WhatsApp messages,
+// for development
+func NewLogWhatsAppSender() Sender {
+	return &logWhatsAppSender{}
+}
+
+type logWhatsAppSender struct{}
+
+func (ls *logWhatsAppSender) Send(ctx context.Context, d *models.Delivery) error {
+	// In production, send via the WhatsApp Business API
+	log.Printf("WhatsApp message to %s (ref %s)", d.Recipient, d.ID)
+	return nil
+}
+
+// NewNotifierSender adapts a Notifier into the push channel Sender
+func NewNotifierSender(notifier Notifier) Sender {
+	return &notifierSender{notifier: notifier}
+}
+
+type notifierSender struct {
+	notifier Notifier
+}
+
+func (ns *notifierSender) Send(ctx context.Context, d *models.Delivery) error {
+	return ns.notifier.Notify(ctx, Notification{
+		UserID: d.Recipient,
+		Title:  d.Subject,
+		Body:   d.Body,
+		Link:   d.Link,
+	})
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// fakeSender fails with errs in turn, then succeeds, recording each
+// message it was asked to send
+type fakeSender struct {
+	errs       []error
+	recipients []string
+	sent       []models.Delivery
+}
+
+func (fs *fakeSender) Send(ctx context.Context, d *models.Delivery) error {
+	fs.recipients = append(fs.recipients, d.Recipient)
+	fs.sent = append(fs.sent, *d)
+	if len(fs.errs) == 0 {
+		return nil
+	}
+	err := fs.errs[0]
+	fs.errs = fs.errs[1:]
+	return err
+}
+
+func newClockedDeliveryQueue(db *gorm.DB, cfg *config.DeliveryConfig, senders map[string]Sender, classifier *RetryClassifier, clock *testClock) *DeliveryQueue {
+	dq := NewDeliveryQueue(db, cfg, senders, classifier)
+	dq.now = clock.Now
+	return dq
+}
+
+// processDue runs the queue once and reloads the delivery
+func processDue(t *testing.T, dq *DeliveryQueue, id string) (int, *models.Delivery) {
+	t.Helper()
+	sent, err := dq.ProcessDue(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+	delivery, err := dq.GetDelivery(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetDelivery: %v", err)
+	}
+	return sent, delivery
+}
+
+var errUnavailable503 = &SendError{StatusCode: 503, Err: errors.New("service unavailable")}
+
+func TestDeliveryQueueRetriesWithBackoff(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	sender := &fakeSender{errs: []error{errUnavailable503, errUnavailable503}}
+	dq := newClockedDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 5, BaseBackoff: 10, MaxBackoff: 60},
+		map[string]Sender{models.DeliveryChannelEmail: sender}, nil, clock)
+
+	delivery := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com", Subject: "Hi", Body: "Hello"}
+	if err := dq.Enqueue(nil, delivery); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	start := clock.now
+
+	sent, got := processDue(t, dq, delivery.ID)
+	if sent != 0 || got.Status != models.DeliveryStatusPending || got.Attempts != 1 || !got.NextAttemptAt.Equal(start.Add(10*time.Second)) {
+		t.Fatalf("after first failure: %+v", got)
+	}
+	if !strings.Contains(got.LastError, "503") {
+		t.Errorf("LastError = %q, want the send failure", got.LastError)
+	}
+
+	clock.Advance(5 * time.Second)
+	if _, got = processDue(t, dq, delivery.ID); got.Attempts != 1 || len(sender.recipients) != 1 {
+		t.Fatalf("retried before the backoff elapsed: %+v", got)
+	}
+
+	clock.Advance(5 * time.Second)
+	if _, got = processDue(t, dq, delivery.ID); got.Attempts != 2 || !got.NextAttemptAt.Equal(clock.now.Add(20*time.Second)) {
+		t.Fatalf("second attempt did not double the backoff: %+v", got)
+	}
+
+	clock.Advance(20 * time.Second)
+	sent, got = processDue(t, dq, delivery.ID)
+	if sent != 1 || got.Status != models.DeliveryStatusSent || got.Attempts != 3 {
+		t.Fatalf("after third attempt: sent %d, %+v", sent, got)
+	}
+	if got.SentAt == nil || !got.SentAt.Equal(clock.now) || got.LastError != "" {
+		t.Errorf("sent delivery = %+v, want SentAt now and no error", got)
+	}
+
+	clock.Advance(time.Hour)
+	if processDue(t, dq, delivery.ID); len(sender.recipients) != 3 {
+		t.Errorf("sent delivery was attempted again")
+	}
+}
+
+func TestDeliveryQueueGivesUpAfterMaxAttempts(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Now()}
+	sender := &fakeSender{errs: []error{errUnavailable503, errUnavailable503, errUnavailable503, errUnavailable503}}
+	dq := newClockedDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 1, MaxBackoff: 60},
+		map[string]Sender{models.DeliveryChannelEmail: sender}, nil, clock)
+
+	delivery := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com"}
+	if err := dq.Enqueue(nil, delivery); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	var got *models.Delivery
+	for i := 0; i < 5; i++ {
+		_, got = processDue(t, dq, delivery.ID)
+		clock.Advance(time.Minute)
+	}
+	if got.Status != models.DeliveryStatusFailed || got.Attempts != 3 || len(sender.recipients) != 3 {
+		t.Errorf("delivery = %+v after %d sends, want failed after 3 attempts", got, len(sender.recipients))
+	}
+}
+
+func TestDeliveryQueueRetryClassification(t *testing.T) {
+	overrides, err := NewRetryClassifier([]string{"email:409=retry"})
+	if err != nil {
+		t.Fatalf("NewRetryClassifier: %v", err)
+	}
+	tests := []struct {
+		name       string
+		err        error
+		classifier *RetryClassifier
+		want       string
+	}{
+		{"server error", errUnavailable503, nil, models.DeliveryStatusPending},
+		{"rate limited", &SendError{StatusCode: 429, Err: errors.New("slow down")}, nil, models.DeliveryStatusPending},
+		{"unknown error", errors.New("connection reset"), nil, models.DeliveryStatusPending},
+		{"rejected recipient", &SendError{StatusCode: 450, Err: errors.New("no such mailbox")}, nil, models.DeliveryStatusFailed},
+		{"validation", ErrInvalidArgument, nil, models.DeliveryStatusFailed},
+		{"overridden client error", &SendError{StatusCode: 409, Err: errors.New("conflict")}, overrides, models.DeliveryStatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			dq := newClockedDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 5, BaseBackoff: 1, MaxBackoff: 60},
+				map[string]Sender{models.DeliveryChannelEmail: &fakeSender{errs: []error{tt.err}}}, tt.classifier, &testClock{now: time.Now()})
+			delivery := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com"}
+			if err := dq.Enqueue(nil, delivery); err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+			if _, got := processDue(t, dq, delivery.ID); got.Status != tt.want || got.Attempts != 1 {
+				t.Errorf("after one failure: status %s, %d attempts, want %s", got.Status, got.Attempts, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliveryQueueFallsBackToEmail(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Now()}
+	whatsapp := &fakeSender{errs: []error{errors.New("template rejected")}}
+	email := &fakeSender{}
+	dq := newClockedDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 60, MaxBackoff: 600}, map[string]Sender{
+		models.DeliveryChannelWhatsApp: whatsapp,
+		models.DeliveryChannelEmail:    email,
+	}, nil, clock)
+
+	delivery := &models.Delivery{
+		Channel:           models.DeliveryChannelWhatsApp,
+		Recipient:         "+4915112345678",
+		FallbackChannel:   models.DeliveryChannelEmail,
+		FallbackRecipient: "a@example.com",
+	}
+	if err := dq.Enqueue(nil, delivery); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	_, got := processDue(t, dq, delivery.ID)
+	if got.Channel != models.DeliveryChannelEmail || got.Recipient != "a@example.com" || got.FallbackChannel != "" {
+		t.Fatalf("after WhatsApp failure: %+v, want it moved to email", got)
+	}
+	if got.Status != models.DeliveryStatusPending || !got.NextAttemptAt.Equal(clock.now) {
+		t.Errorf("fallback not due at once: %+v", got)
+	}
+
+	if sent, got := processDue(t, dq, delivery.ID); sent != 1 || got.Status != models.DeliveryStatusSent {
+		t.Fatalf("fallback email not sent: %+v", got)
+	}
+	if len(email.recipients) != 1 || email.recipients[0] != "a@example.com" {
+		t.Errorf("email sender got %v", email.recipients)
+	}
+}
+
+func TestDeliveryQueueClearsSensitiveBodies(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	sender := &fakeSender{errs: []error{errUnavailable503, &SendError{StatusCode: 400, Err: errors.New("bad recipient")}}}
+	dq := newClockedDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 10, MaxBackoff: 60},
+		map[string]Sender{models.DeliveryChannelEmail: sender}, nil, clock)
+
+	enqueue := func(sensitive bool) string {
+		delivery := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com", Subject: "Code", Body: "Your code is 123456", Sensitive: sensitive}
+		if err := dq.Enqueue(nil, delivery); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		return delivery.ID
+	}
+	failing := enqueue(true)
+
+	// Kept while it is still to be retried
+	if _, delivery := processDue(t, dq, failing); delivery.Status != models.DeliveryStatusPending || delivery.Body == "" {
+		t.Fatalf("after a retryable failure = %s with body %q, want pending with its body", delivery.Status, delivery.Body)
+	}
+	// and cleared once given up on
+	clock.Advance(time.Minute)
+	if _, delivery := processDue(t, dq, failing); delivery.Status != models.DeliveryStatusFailed || delivery.Body != "" {
+		t.Errorf("after giving up = %s with body %q, want failed and cleared", delivery.Status, delivery.Body)
+	}
+
+	sent, ordinary := enqueue(true), enqueue(false)
+	processDue(t, dq, sent)
+	if delivery, _ := dq.GetDelivery(context.Background(), sent); delivery.Status != models.DeliveryStatusSent || delivery.Body != "" {
+		t.Errorf("sensitive message after sending = %s with body %q, want sent and cleared", delivery.Status, delivery.Body)
+	}
+	if delivery, _ := dq.GetDelivery(context.Background(), ordinary); delivery.Body == "" {
+		t.Error("an ordinary message's body was cleared after sending")
+	}
+	if got := sender.sent[len(sender.sent)-2].Body; got != "Your code is 123456" {
+		t.Errorf("sensitive message sent with body %q", got)
+	}
+}
+
+func TestPurgeFinishedDeliveries(t *testing.T) {
+	db := newTestDB(t)
+	// Saving stamps UpdatedAt with the real time, so the clock starts there
+	clock := &testClock{now: time.Now()}
+	sender := &fakeSender{errs: []error{&SendError{StatusCode: 400, Err: errors.New("bad recipient")}}}
+	dq := newClockedDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 10, MaxBackoff: 60, Retention: 3600},
+		map[string]Sender{models.DeliveryChannelEmail: sender}, nil, clock)
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		delivery := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com", Subject: "Hello"}
+		if err := dq.Enqueue(nil, delivery); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		ids = append(ids, delivery.ID)
+	}
+	// One fails for good, one is sent
+	if _, err := dq.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+	// and a later one is still waiting
+	clock.Advance(2 * time.Hour)
+	pending := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com", Subject: "Later"}
+	if err := dq.Enqueue(nil, pending); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	pending.NextAttemptAt = clock.now.Add(time.Hour)
+	db.Save(pending)
+
+	if n, err := dq.PurgeFinished(ctx); err != nil || n != 2 {
+		t.Errorf("PurgeFinished = %d, %v; want the sent and failed messages", n, err)
+	}
+	for _, id := range ids {
+		if _, err := dq.GetDelivery(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("finished delivery %s after the purge: %v", id, err)
+		}
+	}
+	if _, err := dq.GetDelivery(ctx, pending.ID); err != nil {
+		t.Errorf("pending delivery purged: %v", err)
+	}
+}
+
+func TestDeliveryQueueEnqueueRequiresSender(t *testing.T) {
+	dq := newTestDeliveryQueue(newTestDB(t))
+	for _, delivery := range []*models.Delivery{
+		{Channel: models.DeliveryChannelWhatsApp, Recipient: "+4915112345678"},
+		{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com", FallbackChannel: models.DeliveryChannelWhatsApp},
+	} {
+		if err := dq.Enqueue(nil, delivery); err == nil {
+			t.Errorf("queued %+v without a sender for it", delivery)
+		}
+	}
+}
+
+func TestDeliveryBackoff(t *testing.T) {
+	dq := &DeliveryQueue{config: &config.DeliveryConfig{BaseBackoff: 10, MaxBackoff: 60}}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 60 * time.Second},
+		{10, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := dq.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSendOTPQueuesEmailWithReference(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(db, &config.AuthConfig{}, &testClock{now: time.Now()})
+	ctx := context.Background()
+
+	reference, channel, err := as.SendOTP(ctx, "a@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	if channel != models.DeliveryChannelEmail {
+		t.Errorf("channel = %q, want email", channel)
+	}
+	delivery, err := as.deliveries.GetDelivery(ctx, reference)
+	if err != nil {
+		t.Fatalf("GetDelivery: %v", err)
+	}
+	if delivery.Status != models.DeliveryStatusPending || delivery.Attempts != 0 || delivery.Recipient != "a@example.com" {
+		t.Errorf("delivery = %+v, want a queued email", delivery)
+	}
+	if !strings.Contains(delivery.Body, "Reference: "+reference) {
+		t.Errorf("email body does not carry its reference:\n%s", delivery.Body)
+	}
+	if code := sentOTP(t, db, reference); len(code) != 6 {
+		t.Errorf("queued code %q, want 6 digits", code)
+	}
+
+	if _, err := as.deliveries.GetDelivery(ctx, "no-such-reference"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown reference: error = %v, want ErrNotFound", err)
+	}
+}
+
+// blockingSender holds each send until release is closed, telling started
+// when one begins
+type blockingSender struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bs *blockingSender) Send(ctx context.Context, d *models.Delivery) error {
+	bs.started <- struct{}{}
+	<-bs.release
+	return nil
+}
+
+func TestDeliveryQueueRunsDoNotSendTwice(t *testing.T) {
+	db := newTestDB(t)
+	cfg := &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 10, MaxBackoff: 60}
+	blocking := &blockingSender{started: make(chan struct{}, 1), release: make(chan struct{})}
+	first := NewDeliveryQueue(db, cfg, map[string]Sender{models.DeliveryChannelEmail: blocking}, nil)
+	other := &fakeSender{}
+	second := NewDeliveryQueue(db, cfg, map[string]Sender{models.DeliveryChannelEmail: other}, nil)
+
+	delivery := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com", Subject: "Hello"}
+	if err := first.Enqueue(nil, delivery); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	done := make(chan int, 1)
+	go func() {
+		sent, err := first.ProcessDue(context.Background())
+		if err != nil {
+			t.Errorf("first ProcessDue: %v", err)
+		}
+		done <- sent
+	}()
+	<-blocking.started
+
+	// Another instance's run finds the message already being sent
+	if sent, err := second.ProcessDue(context.Background()); err != nil || sent != 0 {
+		t.Errorf("second ProcessDue = %d, %v; want nothing sent", sent, err)
+	}
+	if len(other.sent) != 0 {
+		t.Errorf("second instance sent %d messages, want none", len(other.sent))
+	}
+
+	close(blocking.release)
+	if sent := <-done; sent != 1 {
+		t.Errorf("first ProcessDue sent %d, want 1", sent)
+	}
+	if stored, _ := first.GetDelivery(context.Background(), delivery.ID); stored.Status != models.DeliveryStatusSent || stored.Attempts != 1 {
+		t.Errorf("delivery = %s after %d attempts, want sent after 1", stored.Status, stored.Attempts)
+	}
+}
+
+func TestDeliveryQueueRetakesAnAbandonedClaim(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	sender := &fakeSender{}
+	dq := newClockedDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 10, MaxBackoff: 60},
+		map[string]Sender{models.DeliveryChannelEmail: sender}, nil, clock)
+	delivery := &models.Delivery{Channel: models.DeliveryChannelEmail, Recipient: "a@example.com", Subject: "Hello"}
+	if err := dq.Enqueue(nil, delivery); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	// A run claimed the message and stopped before sending it
+	if claimed, err := dq.claim(context.Background(), delivery); err != nil || !claimed {
+		t.Fatalf("claim = %v, %v", claimed, err)
+	}
+	if claimed, _ := dq.claim(context.Background(), delivery); claimed {
+		t.Error("a claimed message was claimed again")
+	}
+	if sent, _ := processDue(t, dq, delivery.ID); sent != 0 {
+		t.Errorf("sent %d while the claim holds, want 0", sent)
+	}
+
+	clock.Advance(deliveryLease)
+	if sent, stored := processDue(t, dq, delivery.ID); sent != 1 || stored.Status != models.DeliveryStatusSent {
+		t.Errorf("after the claim ran out sent %d, delivery %s; want it sent", sent, stored.Status)
+	}
+	if len(sender.sent) != 1 {
+		t.Errorf("sender called %d times, want 1", len(sender.sent))
+	}
+}
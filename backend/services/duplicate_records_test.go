@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+// newDuplicateTestService returns a records service treating repeated
+// creates within ten minutes as duplicates, with user-1 and user-2
+func newDuplicateTestService(t *testing.T) *HealthRecordsService {
+	t.Helper()
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	return newTestRecordsService(db, &config.RecordsConfig{DuplicateWindow: 600})
+}
+
+// recordCount counts a user's records that are not in the trash
+func recordCount(t *testing.T, hrs *HealthRecordsService, userID string) int64 {
+	t.Helper()
+	var count int64
+	if err := hrs.db.Model(&models.HealthRecord{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		t.Fatalf("count records: %v", err)
+	}
+	return count
+}
+
+func TestRapidDuplicateCreateReturnsExistingRecord(t *testing.T) {
+	hrs := newDuplicateTestService(t)
+	ctx := context.Background()
+
+	first, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Fasting glucose", "92 mg/dL", map[string]string{"value": "92"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	// A retry differing only in case and spacing is the same record
+	retry, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "  fasting   GLUCOSE ", "92 mg/dL", map[string]string{"value": "92"})
+	if err != nil {
+		t.Fatalf("retried CreateRecord: %v", err)
+	}
+	if retry.ID != first.ID {
+		t.Errorf("retry created %s, want the existing %s", retry.ID, first.ID)
+	}
+	if count := recordCount(t, hrs, "user-1"); count != 1 {
+		t.Errorf("%d records after a retried create, want 1", count)
+	}
+}
+
+func TestDistinctCreatesMakeNewRecords(t *testing.T) {
+	hrs := newDuplicateTestService(t)
+	ctx := context.Background()
+
+	first, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Fasting glucose", "92 mg/dL", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	distinct := []struct{ recordType, title, description string }{
+		{"lab_result", "Fasting glucose", "104 mg/dL"},
+		{"lab_result", "Random glucose", "92 mg/dL"},
+		{"symptom", "Fasting glucose", "92 mg/dL"},
+	}
+	for _, d := range distinct {
+		record, err := hrs.CreateRecord(ctx, "user-1", d.recordType, d.title, d.description, nil)
+		if err != nil {
+			t.Fatalf("CreateRecord(%+v): %v", d, err)
+		}
+		if record.ID == first.ID {
+			t.Errorf("create %+v returned the existing record", d)
+		}
+	}
+
+	// Another user's identical record is theirs alone
+	other, err := hrs.CreateRecord(ctx, "user-2", "lab_result", "Fasting glucose", "92 mg/dL", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord by user-2: %v", err)
+	}
+	if other.ID == first.ID || other.UserID != "user-2" {
+		t.Errorf("user-2's create returned %s of %s", other.ID, other.UserID)
+	}
+}
+
+func TestDuplicateOutsideWindowIsANewRecord(t *testing.T) {
+	hrs := newDuplicateTestService(t)
+	ctx := context.Background()
+
+	first, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Fasting glucose", "92 mg/dL", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if err := hrs.db.Model(&models.HealthRecord{}).Where("id = ?", first.ID).Update("created_at", time.Now().Add(-11*time.Minute)).Error; err != nil {
+		t.Fatalf("backdate record: %v", err)
+	}
+
+	// The same reading eleven minutes later is a new measurement
+	later, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Fasting glucose", "92 mg/dL", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if later.ID == first.ID {
+		t.Error("create outside the window returned the old record")
+	}
+}
+
+func TestDuplicateCheckSkipsTrashedAndEditedRecords(t *testing.T) {
+	hrs := newDuplicateTestService(t)
+	ctx := context.Background()
+
+	trashed, err := hrs.CreateRecord(ctx, "user-1", "symptom", "Headache", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if err := hrs.DeleteRecord(ctx, "user-1", trashed.ID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if again, err := hrs.CreateRecord(ctx, "user-1", "symptom", "Headache", "", nil); err != nil || again.ID == trashed.ID {
+		t.Errorf("create after deleting = %v, %v; want a new record", again, err)
+	}
+
+	// An edited record matches its new content, not what it was created with
+	edited, err := hrs.CreateRecord(ctx, "user-1", "symptom", "Dizzy", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if _, err := hrs.UpdateRecord(ctx, "user-1", edited.ID, "Dizzy after standing", "", nil); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if record, err := hrs.CreateRecord(ctx, "user-1", "symptom", "Dizzy", "", nil); err != nil || record.ID == edited.ID {
+		t.Errorf("create of the old content = %v, %v; want a new record", record, err)
+	}
+	if record, err := hrs.CreateRecord(ctx, "user-1", "symptom", "dizzy after standing", "", nil); err != nil || record.ID != edited.ID {
+		t.Errorf("create of the edited content = %v, %v; want the edited record %s", record, err, edited.ID)
+	}
+}
+
+func TestDuplicateCheckOffByDefault(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+
+	first, err := hrs.CreateRecord(ctx, "user-1", "symptom", "Headache", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	second, err := hrs.CreateRecord(ctx, "user-1", "symptom", "Headache", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if second.ID == first.ID {
+		t.Error("identical create returned the existing record with no window configured")
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Course duration parsing for prescription text such as "7 days",
+// "for two weeks", "x10d", or the clinical shorthand "5/7" (5 days),
+// "2/52" (2 weeks), and "3/12" (3 months).
+
+var (
+	openEndedDurationPattern  = regexp.MustCompile(`\b(until|till|til)\s+(finished|complete|gone|done)|\bongoing\b|\bindefinite(ly)?\b|\blong[- ]term\b|\bas needed\b|\bprn\b`)
+	frequencyPhrasePattern    = regexp.MustCompile(`\b(once|twice|thrice|\d+\s*(x|times)|times)\s+(a|an|per|each|every)\s+(day|week|month)\b|\b(per|each|every)\s+(\d+\s+|other\s+)?(days?|weeks?|months?|hours?)\b`)
+	fractionDurationPattern   = regexp.MustCompile(`\b(\d{1,3})\s*/\s*(7|52|12)\b`)
+	durationRangeUpperPattern = regexp.MustCompile(`(?:-|to)\s*(\d{1,3})`)
+	unitDurationPattern       = regexp.MustCompile(`(\d{1,3}|\ba|\ban|\bone|\btwo|\bthree|\bfour|\bfive|\bsix|\bseven|\beight|\bnine|\bten|\beleven|\btwelve|\bfourteen|\bthirty)\s*(?:-|to)?\s*(?:\d{1,3}\s*)?(days?|d\b|weeks?|wks?|w\b|months?|mos?\b|fortnights?)`)
+)
+
+var durationNumberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11,
+	"twelve": 12, "fourteen": 14, "thirty": 30,
+}
+
+// ParseCourseDuration extracts a treatment course length from free text.
+// It returns ok=false for open-ended courses ("until finished", "ongoing")
+// and for text it cannot interpret. Ranges such as "5-7 days" resolve to
+// the upper bound so a follow-up never fires before the course could end.
+func ParseCourseDuration(text string) (time.Duration, bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" || openEndedDurationPattern.MatchString(text) {
+		return 0, false
+	}
+	// Dosing frequency ("twice a day", "every 2 days") is not a course length.
+	text = frequencyPhrasePattern.ReplaceAllString(text, " ")
+
+	if m := fractionDurationPattern.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "7":
+			return days(n), n > 0
+		case "52":
+			return days(7 * n), n > 0
+		case "12":
+			return days(30 * n), n > 0
+		}
+	}
+
+	m := unitDurationPattern.FindStringSubmatch(text)
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		n = durationNumberWords[m[1]]
+	}
+	// For ranges ("5-7 days", "1 to 2 weeks") prefer the upper bound.
+	if upper := durationRangeUpperPattern.FindStringSubmatch(m[0]); upper != nil {
+		if u, err := strconv.Atoi(upper[1]); err == nil && u > n {
+			n = u
+		}
+	}
+	if n <= 0 {
+		return 0, false
+	}
+
+	unit := m[2]
+	switch {
+	case strings.HasPrefix(unit, "fortnight"):
+		return days(14 * n), true
+	case strings.HasPrefix(unit, "w"):
+		return days(7 * n), true
+	case strings.HasPrefix(unit, "mo"):
+		return days(30 * n), true
+	default:
+		return days(n), true
+	}
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
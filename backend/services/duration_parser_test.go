@@ -0,0 +1,60 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCourseDuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		text   string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"7 days", 7 * day, true},
+		{"10 Days", 10 * day, true},
+		{"2 weeks", 14 * day, true},
+		{"a week", 7 * day, true},
+		{"a fortnight", 14 * day, true},
+		{"1 month", 30 * day, true},
+		{"for seven days", 7 * day, true},
+		{"x10d", 10 * day, true},
+		{"5/7", 5 * day, true},
+		{"2/52", 14 * day, true},
+		{"3/12", 90 * day, true},
+		{"Take twice a day for 7 days", 7 * day, true},
+		{"one tablet every 2 days for 3 weeks", 21 * day, true},
+		{"5-7 days", 7 * day, true},
+		{"1 to 2 weeks", 14 * day, true},
+		{"until finished", 0, false},
+		{"ongoing", 0, false},
+		{"as needed", 0, false},
+		{"daily", 0, false},
+		{"take once a day", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, ok := ParseCourseDuration(tt.text)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("ParseCourseDuration(%q) = %v, %v, want %v, %v", tt.text, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func FuzzParseCourseDuration(f *testing.F) {
+	for _, seed := range []string{"7 days", "2/52", "5-7 days", "x10d", "until finished", "99999999999999999999 days", "1 to 2 weeks"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		duration, ok := ParseCourseDuration(text)
+		if ok && duration <= 0 {
+			t.Fatalf("ParseCourseDuration(%q) = %v, want a positive course", text, duration)
+		}
+		if !ok && duration != 0 {
+			t.Fatalf("ParseCourseDuration(%q) = %v with ok=false", text, duration)
+		}
+	})
+}
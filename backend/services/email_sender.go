@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+// smtpDialTimeout bounds connecting to the SMTP server when the send's
+// context has no deadline of its own
+const smtpDialTimeout = 30 * time.Second
+
+// smtpEmailSender sends the email channel through an SMTP server,
+// upgrading the connection with STARTTLS when the server offers it
+type smtpEmailSender struct {
+	addr string
+	host string
+	from *mail.Address
+	auth smtp.Auth // nil when the server takes mail without logging in
+}
+
+// NewSMTPEmailSender returns a Sender that delivers email through the SMTP
+// server in cfg. Credentials are only sent over TLS or to localhost.
+func NewSMTPEmailSender(cfg *config.DeliveryConfig) (Sender, error) {
+	if cfg.SMTPHost == "" {
+		return nil, errors.New("SMTP host is required")
+	}
+	from, err := mail.ParseAddress(cfg.SMTPFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP from address %q: %w", cfg.SMTPFrom, err)
+	}
+
+	s := &smtpEmailSender{
+		addr: net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host: cfg.SMTPHost,
+		from: from,
+	}
+	if cfg.SMTPUsername != "" {
+		s.auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return s, nil
+}
+
+func (s *smtpEmailSender) Send(ctx context.Context, d *models.Delivery) error {
+	to, err := mail.ParseAddress(d.Recipient)
+	if err != nil {
+		return fmt.Errorf("%w: recipient %q is not an email address", ErrInvalidArgument, d.Recipient)
+	}
+	msg := s.message(to, d)
+
+	dialer := net.Dialer{Timeout: smtpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("SMTP STARTTLS failed: %w", err)
+		}
+	}
+	if s.auth != nil {
+		if err := client.Auth(s.auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("SMTP server refused sender: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("SMTP server refused recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP server refused message: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server refused message: %w", err)
+	}
+	return client.Quit()
+}
+
+// message renders d as a plain text email. The delivery ID becomes the
+// Message-ID, so a message retried after an unclear failure can be told
+// apart from a new one.
+func (s *smtpEmailSender) message(to *mail.Address, d *models.Delivery) []byte {
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		// Values come from the delivery, so keep them from adding headers
+		value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", s.from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", d.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", d.ID, s.host))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(strings.ReplaceAll(d.Body, "\r\n", "\n"), "\n", "\r\n")
+	buf.WriteString(body)
+	return buf.Bytes()
+}
+
+// CategorizeError sorts SMTP replies, whose codes mean the reverse of
+// HTTP's: 4xx is a temporary failure worth retrying, 5xx a permanent one
+func (s *smtpEmailSender) CategorizeError(err error) string {
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return ""
+	}
+	switch {
+	case reply.Code >= 500:
+		return ErrorCategoryClient
+	case reply.Code >= 400:
+		return ErrorCategoryServer
+	}
+	return ""
+}
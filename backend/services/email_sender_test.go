@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestSendOTPEmailsTheCode(t *testing.T) {
+	db := newTestDB(t)
+	email := &fakeSender{}
+	as := newChannelTestAuth(db, email, &fakeSender{}, &fakeSender{})
+	ctx := context.Background()
+
+	reference, channel, err := as.SendOTP(ctx, "reader@example.com")
+	if err != nil || channel != models.DeliveryChannelEmail {
+		t.Fatalf("SendOTP = %s, %v", channel, err)
+	}
+	if len(email.sent) != 0 {
+		t.Fatal("the email was sent before the queue ran")
+	}
+	// Read before sending, which clears the code from the queue
+	code := sentOTP(t, db, reference)
+	if _, err := as.deliveries.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+	if len(email.sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(email.sent))
+	}
+
+	sent := email.sent[0]
+	if sent.Recipient != "reader@example.com" || sent.Subject != "Your Clarity sign-in code" {
+		t.Errorf("email to %q about %q, want the sign-in code to reader@example.com", sent.Recipient, sent.Subject)
+	}
+	for _, want := range []string{"sign-in code is " + code + ".", "expires in 10 minutes", "Reference: " + reference} {
+		if !strings.Contains(sent.Body, want) {
+			t.Errorf("email body lacks %q:\n%s", want, sent.Body)
+		}
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", code, "device-1"); err != nil {
+		t.Errorf("the emailed code does not sign in: %v", err)
+	}
+}
+
+func TestSendOTPFailsWhenTheEmailCannotBeQueued(t *testing.T) {
+	db := newTestDB(t)
+	// No sender for the email channel
+	dq := NewDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3}, map[string]Sender{}, nil)
+	as := NewAuthService(db, &config.AuthConfig{JWTSecret: "test-secret", OTPLength: 6, OTPExpiry: 600}, dq, nil)
+
+	if _, _, err := as.SendOTP(context.Background(), "reader@example.com"); err == nil {
+		t.Fatal("SendOTP succeeded with no way to send the email")
+	}
+	var stored int64
+	db.Model(&models.OTPStore{}).Count(&stored)
+	if stored != 0 {
+		t.Errorf("%d codes stored for an email never sent, want none", stored)
+	}
+}
+
+// smtpServer is a minimal SMTP server recording the one session it serves
+type smtpServer struct {
+	listener net.Listener
+	rcptCode int // reply to RCPT TO
+
+	mu       sync.Mutex
+	commands []string
+	data     string
+}
+
+// newSMTPServer starts a server answering RCPT TO with rcptCode
+func newSMTPServer(t *testing.T, rcptCode int) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpServer{listener: listener, rcptCode: rcptCode}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost test SMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			text.PrintfLine("250-localhost")
+			text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			text.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			text.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			if s.rcptCode != 250 {
+				text.PrintfLine("%d 5.1.1 No such user", s.rcptCode)
+				continue
+			}
+			text.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			// Read the message raw, as textproto's dot reader would
+			// hide the line endings the sender used
+			var data strings.Builder
+			for {
+				line, err := text.R.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.mu.Lock()
+			s.data = data.String()
+			s.mu.Unlock()
+			text.PrintfLine("250 2.0.0 Queued")
+		case "QUIT":
+			text.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// session returns the commands the client sent and the message it sent
+func (s *smtpServer) session() ([]string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...), s.data
+}
+
+func newTestSMTPSender(t *testing.T, s *smtpServer, username string) Sender {
+	t.Helper()
+	sender, err := NewSMTPEmailSender(&config.DeliveryConfig{
+		SMTPHost:     "localhost",
+		SMTPPort:     s.port(),
+		SMTPUsername: username,
+		SMTPPassword: "hunter2",
+		SMTPFrom:     "Clarity <no-reply@clarity.example>",
+	})
+	if err != nil {
+		t.Fatalf("NewSMTPEmailSender: %v", err)
+	}
+	return sender
+}
+
+func TestSMTPEmailSender(t *testing.T) {
+	server := newSMTPServer(t, 250)
+	sender := newTestSMTPSender(t, server, "clarity")
+
+	err := sender.Send(context.Background(), &models.Delivery{
+		ID:        "delivery-1",
+		Recipient: "reader@example.com",
+		Subject:   "Your Clarity sign-in code\r\nBcc: victim@example.com",
+		Body:      "Your Clarity sign-in code is 123456.\nReference: delivery-1\n",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	commands, data := server.session()
+	joined := strings.Join(commands, "\n")
+	for _, want := range []string{"AUTH PLAIN", "MAIL FROM:<no-reply@clarity.example>", "RCPT TO:<reader@example.com>", "QUIT"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("SMTP session lacks %q:\n%s", want, joined)
+		}
+	}
+
+	headers, body, ok := strings.Cut(data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header/body separator:\n%s", data)
+	}
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(headers + "\r\n\r\n")))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("read headers: %v", err)
+	}
+	if got := header.Get("To"); got != "<reader@example.com>" {
+		t.Errorf("To = %q", got)
+	}
+	if got := header.Get("From"); !strings.Contains(got, "no-reply@clarity.example") {
+		t.Errorf("From = %q", got)
+	}
+	if got := header.Get("Message-Id"); got != "<delivery-1@localhost>" {
+		t.Errorf("Message-ID = %q, want the delivery ID", got)
+	}
+	if header.Get("Bcc") != "" {
+		t.Error("a subject with a line break added a Bcc header")
+	}
+	if !strings.Contains(body, "sign-in code is 123456.\r\nReference: delivery-1\r\n") {
+		t.Errorf("body = %q, want the delivery body with CRLF line ends", body)
+	}
+}
+
+func TestSMTPEmailSenderWithoutLogin(t *testing.T) {
+	server := newSMTPServer(t, 250)
+	if err := newTestSMTPSender(t, server, "").Send(context.Background(), &models.Delivery{ID: "d", Recipient: "reader@example.com", Body: "hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	commands, _ := server.session()
+	for _, command := range commands {
+		if strings.HasPrefix(command, "AUTH") {
+			t.Errorf("sender without a username logged in: %s", command)
+		}
+	}
+}
+
+func TestSMTPEmailSenderFailures(t *testing.T) {
+	server := newSMTPServer(t, 550)
+	sender := newTestSMTPSender(t, server, "")
+
+	err := sender.Send(context.Background(), &models.Delivery{ID: "d", Recipient: "gone@example.com", Body: "hi"})
+	var reply *textproto.Error
+	if !errors.As(err, &reply) || reply.Code != 550 {
+		t.Fatalf("Send to a refused recipient: %v, want the 550 reply", err)
+	}
+	if got := sender.(ErrorCategorizer).CategorizeError(err); got != ErrorCategoryClient {
+		t.Errorf("550 categorized %q, want %q", got, ErrorCategoryClient)
+	}
+	if got := sender.(ErrorCategorizer).CategorizeError(fmt.Errorf("wrapped: %w", &textproto.Error{Code: 451})); got != ErrorCategoryServer {
+		t.Errorf("451 categorized %q, want %q", got, ErrorCategoryServer)
+	}
+
+	if err := sender.Send(context.Background(), &models.Delivery{ID: "d", Recipient: "not an address"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("Send to a malformed recipient: %v, want %v", err, ErrInvalidArgument)
+	}
+
+	// Nothing listening
+	closed := newSMTPServer(t, 250)
+	closed.listener.Close()
+	if err := newTestSMTPSender(t, closed, "").Send(context.Background(), &models.Delivery{ID: "d", Recipient: "reader@example.com"}); err == nil {
+		t.Error("Send with no SMTP server succeeded")
+	}
+
+	for name, cfg := range map[string]config.DeliveryConfig{
+		"no host":  {SMTPFrom: "no-reply@clarity.example", SMTPPort: 25},
+		"bad from": {SMTPHost: "localhost", SMTPPort: 25, SMTPFrom: "not an address"},
+	} {
+		if _, err := NewSMTPEmailSender(&cfg); err == nil {
+			t.Errorf("%s: NewSMTPEmailSender accepted %+v", name, cfg)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import "errors"
+
+// Sentinel errors returned by the services layer. Handlers map these to
+// gRPC status codes so clients get a meaningful error instead of Unknown.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrAlreadyExists    = errors.New("already exists")
+	ErrInvalidArgument  = errors.New("invalid argument")
+
+	ErrMetadataTooLarge = errors.New("metadata exceeds limit")
+
+	ErrImageQuality = errors.New("image quality too low")
+
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrReauthRequired means a session has ended and the user must sign
+	// in again with a new code
+	ErrReauthRequired = errors.New("re-authentication required")
+
+	ErrPINRequired = errors.New("PIN required")
+	ErrLocked      = errors.New("temporarily locked after too many attempts")
+
+	ErrOTPDailyCapReached = errors.New("daily OTP limit reached, try again tomorrow")
+	ErrOTPRateLimited     = errors.New("too many OTP requests, try again later")
+	ErrOTPAttempts        = errors.New("too many incorrect codes, try again later")
+
+	ErrShareLimitReached = errors.New("record is already shared the maximum number of times")
+
+	ErrInvalidAudio  = errors.New("invalid audio")
+	ErrAudioTooLarge = errors.New("audio exceeds maximum size")
+
+	ErrUnavailable = errors.New("temporarily unavailable")
+
+	// ErrProviderNotConfigured and ErrProviderAuth mean a provider has no
+	// credentials or had them rejected. They come wrapped in a
+	// *ProviderConfigError naming the setting to fix.
+	ErrProviderNotConfigured = errors.New("provider not configured")
+	ErrProviderAuth          = errors.New("provider rejected its credentials")
+
+	// ErrContentRefused means the AI provider declined to answer, usually
+	// because of its safety filters. Providers return it when they report
+	// a refusal; AIService also detects empty and refusing replies.
+	ErrContentRefused = errors.New("AI provider declined the request")
+
+	ErrAccessReasonRequired = errors.New("access reason required")
+
+	// ErrSyncTokenExpired means the changes since a sync token are no longer
+	// known, so the client must discard its copy and sync from scratch
+	ErrSyncTokenExpired = errors.New("sync token expired, full resync required")
+)
@@ -0,0 +1,569 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/events"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/tenancy"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExportSnapshot is the frozen content behind an export link
+type ExportSnapshot struct {
+	PatientName string           `json:"patient_name,omitempty"`
+	Redacted    bool             `json:"redacted,omitempty"` // personal details were removed from this copy
+	CreatedAt   time.Time        `json:"created_at"`
+	ExpiresAt   time.Time        `json:"expires_at"`
+	Summary     string           `json:"summary"`
+	Records     []SnapshotRecord `json:"records"`
+}
+
+// SnapshotRecord is one record as it was when the link was created
+type SnapshotRecord struct {
+	RecordType  string            `json:"record_type"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// CreatedExportLink is returned once, at creation. The token and PIN are
+// not stored and cannot be recovered later.
+type CreatedExportLink struct {
+	Link *models.ExportLink
+	URL  string
+	PIN  string // empty when no PIN was requested
+}
+
+type ExportService struct {
+	db         *gorm.DB
+	config     *config.ExportConfig
+	baseURL    string
+	events     *events.Bus
+	deliveries *DeliveryQueue
+	now        func() time.Time
+}
+
+func NewExportService(db *gorm.DB, cfg *config.ExportConfig, baseURL string, bus *events.Bus, deliveries *DeliveryQueue) *ExportService {
+	return &ExportService{
+		db:         db,
+		config:     cfg,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		events:     bus,
+		deliveries: deliveries,
+		now:        time.Now,
+	}
+}
+
+// CreateExportLink snapshots the selected records and returns a link to
+// them. A zero ttl uses the configured default; longer than the maximum is
+// rejected. If recipientEmail is set the link is emailed there, but the PIN
+// never is: the patient passes it on separately. With redact set, names and
+// contact details are stripped from the snapshot; the records themselves
+// are not changed.
+func (es *ExportService) CreateExportLink(ctx context.Context, userID string, recordIDs []string, ttl time.Duration, requirePIN bool, recipientEmail string, redact bool) (*CreatedExportLink, error) {
+	if len(recordIDs) == 0 {
+		return nil, fmt.Errorf("%w: select at least one record", ErrInvalidArgument)
+	}
+	if es.config.MaxLinkRecords > 0 && len(recordIDs) > es.config.MaxLinkRecords {
+		return nil, fmt.Errorf("%w: at most %d records per link", ErrInvalidArgument, es.config.MaxLinkRecords)
+	}
+	if ttl == 0 {
+		ttl = time.Duration(es.config.LinkDefaultTTL) * time.Second
+	}
+	if maxTTL := time.Duration(es.config.LinkMaxTTL) * time.Second; ttl < 0 || ttl > maxTTL {
+		return nil, fmt.Errorf("%w: expiry must be between 1s and %s", ErrInvalidArgument, maxTTL)
+	}
+
+	var records []models.HealthRecord
+	if err := es.db.WithContext(ctx).Scopes(scopeOwner(userID)).
+		Where("id IN ?", recordIDs).
+		Order("created_at ASC").
+		Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch records: %w", err)
+	}
+	if len(records) != len(uniqueStrings(recordIDs)) {
+		return nil, fmt.Errorf("%w: record", ErrNotFound)
+	}
+
+	var user models.User
+	es.db.WithContext(ctx).Select("name").Where("id = ?", userID).First(&user)
+
+	now := es.now()
+	snapshot := ExportSnapshot{
+		PatientName: user.Name,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+		Summary:     summarizeSnapshot(records),
+	}
+	for _, record := range records {
+		metadata := make(map[string]string)
+		json.Unmarshal([]byte(record.Metadata), &metadata)
+		snapshot.Records = append(snapshot.Records, SnapshotRecord{
+			RecordType:  record.RecordType,
+			Title:       record.Title,
+			Description: record.Description,
+			Metadata:    metadata,
+			CreatedAt:   record.CreatedAt,
+		})
+	}
+	if redact {
+		redactSnapshot(&snapshot)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	sealed, err := sealSnapshot(token, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	link := models.ExportLink{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		TokenHash:   hashToken(token),
+		Snapshot:    sealed,
+		RecordCount: len(records),
+		Redacted:    redact,
+		ExpiresAt:   snapshot.ExpiresAt,
+		CreatedAt:   now,
+	}
+	var pin string
+	if requirePIN {
+		if pin, err = randomPIN(); err != nil {
+			return nil, err
+		}
+		link.PINHash = hashPIN(link.ID, pin)
+	}
+
+	// The gateway has no other way to know whose database holds the link
+	shareURL := es.baseURL + "/share/" + token
+	if tenant, ok := tenancy.FromContext(ctx); ok {
+		shareURL += "?tenant=" + url.QueryEscape(tenant)
+	}
+	err = es.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := es.checkShareLimit(tx, records, now); err != nil {
+			return err
+		}
+		if err := tx.Create(&link).Error; err != nil {
+			return fmt.Errorf("failed to store export link: %w", err)
+		}
+		shared := make([]models.ExportLinkRecord, len(records))
+		for i, record := range records {
+			shared[i] = models.ExportLinkRecord{LinkID: link.ID, RecordID: record.ID}
+		}
+		if err := tx.Create(&shared).Error; err != nil {
+			return fmt.Errorf("failed to store export link records: %w", err)
+		}
+		if recipientEmail == "" {
+			return nil
+		}
+		return es.deliveries.Enqueue(tx, &models.Delivery{
+			Channel:   models.DeliveryChannelEmail,
+			Recipient: recipientEmail,
+			Subject:   "Health records shared with you via Clarity",
+			Body:      exportEmailBody(snapshot.PatientName, shareURL, link.ExpiresAt, requirePIN),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	es.events.Publish(ctx, events.ExportLinkCreated{
+		UserID:    userID,
+		LinkID:    link.ID,
+		Records:   len(records),
+		ExpiresAt: link.ExpiresAt,
+		PIN:       requirePIN,
+		Redacted:  redact,
+	})
+
+	return &CreatedExportLink{Link: &link, URL: shareURL, PIN: pin}, nil
+}
+
+// checkShareLimit fails with ErrShareLimitReached if any of records is
+// already shared through the configured maximum of active links
+func (es *ExportService) checkShareLimit(tx *gorm.DB, records []models.HealthRecord, now time.Time) error {
+	if es.config.MaxRecordShares <= 0 {
+		return nil
+	}
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+
+	var full []string
+	if err := tx.Model(&models.ExportLinkRecord{}).
+		Joins("JOIN export_links ON export_links.id = export_link_records.link_id").
+		Where("export_link_records.record_id IN ?", ids).
+		Where("export_links.revoked_at IS NULL AND export_links.expires_at > ?", now).
+		Group("export_link_records.record_id").
+		Having("COUNT(*) >= ?", es.config.MaxRecordShares).
+		Pluck("export_link_records.record_id", &full).Error; err != nil {
+		return fmt.Errorf("failed to count record shares: %w", err)
+	}
+	if len(full) == 0 {
+		return nil
+	}
+
+	titles := make([]string, 0, len(full))
+	for _, record := range records {
+		for _, id := range full {
+			if record.ID == id {
+				titles = append(titles, fmt.Sprintf("%q", record.Title))
+			}
+		}
+	}
+	return fmt.Errorf("%w (%d active links): %s; revoke an existing link first",
+		ErrShareLimitReached, es.config.MaxRecordShares, strings.Join(titles, ", "))
+}
+
+// OpenExportLink returns the snapshot behind a link token. Unknown,
+// expired, revoked, and used-up links are all reported as not found. Wrong
+// PINs count towards a lockout so a short PIN cannot be brute forced.
+func (es *ExportService) OpenExportLink(ctx context.Context, token, pin, accessor string) (*ExportSnapshot, error) {
+	var link models.ExportLink
+	if err := es.db.WithContext(ctx).Where("token_hash = ?", hashToken(token)).First(&link).Error; err != nil {
+		return nil, fmt.Errorf("%w: link", ErrNotFound)
+	}
+
+	now := es.now()
+	if link.RevokedAt != nil || !now.Before(link.ExpiresAt) {
+		return nil, fmt.Errorf("%w: link has expired or was revoked", ErrNotFound)
+	}
+	if link.LockedUntil != nil && now.Before(*link.LockedUntil) {
+		return nil, ErrLocked
+	}
+	if es.config.MaxLinkAccesses > 0 && link.AccessCount >= es.config.MaxLinkAccesses {
+		return nil, fmt.Errorf("%w: link has reached its access limit", ErrNotFound)
+	}
+
+	if link.PINHash != "" {
+		if pin == "" {
+			return nil, ErrPINRequired
+		}
+		if subtle.ConstantTimeCompare([]byte(hashPIN(link.ID, pin)), []byte(link.PINHash)) != 1 {
+			return nil, es.recordFailedPIN(ctx, &link, now)
+		}
+	}
+
+	if err := es.checkAccessors(ctx, &link, accessor, now); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openSnapshot(token, link.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot ExportSnapshot
+	if err := json.Unmarshal(plaintext, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	// Counting the access is what checks the limit, so concurrent opens
+	// cannot all pass on the count read above
+	record := es.db.WithContext(ctx).Model(&models.ExportLink{}).Where("id = ?", link.ID)
+	if es.config.MaxLinkAccesses > 0 {
+		record = record.Where("access_count < ?", es.config.MaxLinkAccesses)
+	}
+	result := record.Updates(map[string]interface{}{
+		"access_count":        gorm.Expr("access_count + 1"),
+		"last_accessed_at":    now,
+		"failed_pin_attempts": 0,
+		"locked_until":        nil,
+	})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to record access: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: link has reached its access limit", ErrNotFound)
+	}
+	es.events.Publish(ctx, events.ExportLinkAccessed{LinkID: link.ID, Accessor: accessor})
+
+	return &snapshot, nil
+}
+
+// checkAccessors revokes a link opened from more distinct addresses within
+// the configured window than a recipient plausibly uses, which suggests it
+// has leaked or is being scraped, and alerts its owner. Accesses are read
+// back from the audit log, which records ExportLinkAccessed events, so the
+// count survives restarts.
+func (es *ExportService) checkAccessors(ctx context.Context, link *models.ExportLink, accessor string, now time.Time) error {
+	if es.config.SuspiciousAccessors <= 0 || accessor == "" {
+		return nil
+	}
+
+	var seen []string
+	since := now.Add(-time.Duration(es.config.SuspiciousWindow) * time.Second)
+	if err := es.db.WithContext(ctx).Model(&models.AuditLog{}).
+		Where("action = ? AND target_id = ? AND created_at >= ?", AuditActionExportLinkAccess, link.ID, since).
+		Distinct("details").Pluck("details", &seen).Error; err != nil {
+		return fmt.Errorf("failed to check link accessors: %w", err)
+	}
+	accessors := len(seen)
+	if !slices.Contains(seen, "accessor="+accessor) {
+		accessors++
+	}
+	if accessors <= es.config.SuspiciousAccessors {
+		return nil
+	}
+
+	// The caller hanging up must not leave the link open
+	ctx = context.WithoutCancel(ctx)
+	if err := es.db.WithContext(ctx).Model(link).Where("revoked_at IS NULL").
+		Updates(map[string]interface{}{"revoked_at": now, "snapshot": nil}).Error; err != nil {
+		return fmt.Errorf("failed to revoke export link: %w", err)
+	}
+	log.Printf("Export link %s revoked after access from %d addresses", link.ID, accessors)
+	es.events.Publish(ctx, events.ExportLinkRevoked{
+		ActorID: "export_link:" + link.ID,
+		LinkID:  link.ID,
+		Reason:  fmt.Sprintf("suspicious access from %d addresses", accessors),
+	})
+
+	err := es.deliveries.Notify(ctx, Notification{
+		UserID: link.UserID,
+		Title:  "Share link revoked",
+		Body: fmt.Sprintf("A link to %d of your health records was opened from %d different places in a short time, "+
+			"so we switched it off. Create a new link if you still need to share them.", link.RecordCount, accessors),
+		Link: "clarity://settings/security",
+	})
+	if err != nil {
+		log.Printf("Failed to queue link revocation alert for user %s: %v", link.UserID, err)
+	}
+	return fmt.Errorf("%w: link was revoked", ErrNotFound)
+}
+
+// recordFailedPIN counts a wrong PIN and locks the link once the limit is
+// reached. The count is incremented in the database and the lock decided
+// from the stored value, so concurrent wrong PINs cannot all read the same
+// count and slip past the limit. The returned error is what the caller
+// should report. The attempt is counted even if the caller hangs up, so
+// cancelling requests cannot dodge the lockout.
+func (es *ExportService) recordFailedPIN(ctx context.Context, link *models.ExportLink, now time.Time) error {
+	locked := false
+	err := es.db.WithContext(context.WithoutCancel(ctx)).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ExportLink{}).Where("id = ?", link.ID).
+			Update("failed_pin_attempts", gorm.Expr("failed_pin_attempts + 1")).Error; err != nil {
+			return err
+		}
+		if es.config.PINMaxAttempts <= 0 {
+			return nil
+		}
+		// Of concurrent attempts reaching the limit, only one locks the link
+		result := tx.Model(&models.ExportLink{}).
+			Where("id = ? AND failed_pin_attempts >= ?", link.ID, es.config.PINMaxAttempts).
+			Updates(map[string]interface{}{
+				"failed_pin_attempts": 0,
+				"locked_until":        now.Add(time.Duration(es.config.PINLockout) * time.Second),
+			})
+		locked = result.RowsAffected > 0
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record PIN attempt: %w", err)
+	}
+	if locked {
+		log.Printf("Export link %s locked after repeated wrong PINs", link.ID)
+		return ErrLocked
+	}
+	return fmt.Errorf("%w: incorrect PIN", ErrPermissionDenied)
+}
+
+// RevokeExportLink disables a link immediately and discards its snapshot
+func (es *ExportService) RevokeExportLink(ctx context.Context, userID, linkID string) error {
+	result := es.db.WithContext(ctx).Model(&models.ExportLink{}).
+		Scopes(scopeOwner(userID)).
+		Where("id = ? AND revoked_at IS NULL", linkID).
+		Updates(map[string]interface{}{"revoked_at": es.now(), "snapshot": nil})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke export link: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: export link %s", ErrNotFound, linkID)
+	}
+
+	es.events.Publish(ctx, events.ExportLinkRevoked{ActorID: userID, LinkID: linkID})
+	return nil
+}
+
+// PurgeExpired deletes links, and so their snapshots, once they expire
+func (es *ExportService) PurgeExpired(ctx context.Context) (int, error) {
+	now := es.now()
+	var purged int64
+	err := es.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Session(&gorm.Session{NewDB: true}).
+			Model(&models.ExportLink{}).
+			Select("id").
+			Where("expires_at <= ?", now)
+		if err := tx.Where("link_id IN (?)", expired).Delete(&models.ExportLinkRecord{}).Error; err != nil {
+			return fmt.Errorf("failed to purge export link records: %w", err)
+		}
+		result := tx.Where("expires_at <= ?", now).Delete(&models.ExportLink{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge export links: %w", result.Error)
+		}
+		purged = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired export links", purged)
+	}
+	return int(purged), nil
+}
+
+// redactSnapshot strips personal details from a snapshot before it is
+// sealed, including the patient's own name wherever it appears. Only the
+// snapshot copy is changed.
+func redactSnapshot(snapshot *ExportSnapshot) {
+	name := snapshot.PatientName
+	snapshot.PatientName = ""
+	snapshot.Redacted = true
+	snapshot.Summary = redactPII(snapshot.Summary, name)
+	for i := range snapshot.Records {
+		record := &snapshot.Records[i]
+		record.Title = redactPII(record.Title, name)
+		record.Description = redactPII(record.Description, name)
+		for key, value := range record.Metadata {
+			record.Metadata[key] = redactPII(value, name)
+		}
+	}
+}
+
+// summarizeSnapshot describes the shared records by type and date range
+func summarizeSnapshot(records []models.HealthRecord) string {
+	counts := make(map[string]int)
+	for _, record := range records {
+		counts[record.RecordType]++
+	}
+	types := make([]string, 0, len(counts))
+	for recordType := range counts {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+
+	parts := make([]string, len(types))
+	for i, recordType := range types {
+		parts[i] = fmt.Sprintf("%d %s", counts[recordType], strings.ReplaceAll(recordType, "_", " "))
+	}
+
+	first, last := records[0].CreatedAt, records[len(records)-1].CreatedAt
+	return fmt.Sprintf("%d records (%s) from %s to %s.", len(records), strings.Join(parts, ", "),
+		first.Format("2 Jan 2006"), last.Format("2 Jan 2006"))
+}
+
+func exportEmailBody(patientName, url string, expiresAt time.Time, requirePIN bool) string {
+	if patientName == "" {
+		patientName = "A Clarity user"
+	}
+	body := fmt.Sprintf("%s has shared health records with you.\n\nView them at %s\n\nThe link expires on %s.\n",
+		patientName, url, expiresAt.UTC().Format("2 Jan 2006 15:04 MST"))
+	if requirePIN {
+		body += "You will need the PIN the patient gives you separately.\n"
+	}
+	return body
+}
+
+// snapshotKey derives the encryption key from the link token, so a
+// snapshot can only be read by someone holding the link
+func snapshotKey(token string) []byte {
+	sum := sha256.Sum256([]byte("clarity-export-snapshot:" + token))
+	return sum[:]
+}
+
+func sealSnapshot(token string, plaintext []byte) ([]byte, error) {
+	gcm, err := snapshotCipher(token)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openSnapshot(token string, sealed []byte) ([]byte, error) {
+	gcm, err := snapshotCipher(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: snapshot is no longer available", ErrNotFound)
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot: %w", err)
+	}
+	return plaintext, nil
+}
+
+func snapshotCipher(token string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(snapshotKey(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func randomPIN() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate PIN: %w", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashPIN salts the PIN with the link ID so equal PINs hash differently
+func hashPIN(linkID, pin string) string {
+	sum := sha256.Sum256([]byte(linkID + ":" + pin))
+	return hex.EncodeToString(sum[:])
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// newTestExportService returns an ExportService on clock with PINs locking
+// after three wrong attempts for a minute
+func newTestExportService(db *gorm.DB, clock *testClock) *ExportService {
+	es := NewExportService(db, &config.ExportConfig{
+		LinkDefaultTTL: 3600,
+		LinkMaxTTL:     86400,
+		PINMaxAttempts: 3,
+		PINLockout:     60,
+	}, "https://share.example.com/", nil, newTestDeliveryQueue(db))
+	es.now = clock.Now
+	return es
+}
+
+// linkToken returns the token carried by a share URL
+func linkToken(t *testing.T, created *CreatedExportLink) string {
+	t.Helper()
+	_, token, ok := strings.Cut(created.URL, "/share/")
+	if !ok || token == "" {
+		t.Fatalf("share URL %q carries no token", created.URL)
+	}
+	return token
+}
+
+func TestExportSnapshotIsImmutable(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	createRecord(t, db, "rec-2", "user-1", models.SensitivityStandard)
+
+	created, err := es.CreateExportLink(context.Background(), "user-1", []string{"rec-1", "rec-2"}, 0, false, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	if strings.Contains(string(created.Link.Snapshot), "Record rec-1") {
+		t.Fatal("snapshot is stored in the clear")
+	}
+
+	// Edit one shared record and delete the other after sharing
+	if err := db.Model(&models.HealthRecord{}).Where("id = ?", "rec-1").
+		Updates(map[string]interface{}{"title": "Edited", "description": "Changed later"}).Error; err != nil {
+		t.Fatalf("edit record: %v", err)
+	}
+	if err := db.Delete(&models.HealthRecord{}, "id = ?", "rec-2").Error; err != nil {
+		t.Fatalf("delete record: %v", err)
+	}
+
+	snapshot, err := es.OpenExportLink(context.Background(), linkToken(t, created), "", "198.51.100.7")
+	if err != nil {
+		t.Fatalf("OpenExportLink: %v", err)
+	}
+	if len(snapshot.Records) != 2 {
+		t.Fatalf("snapshot has %d records, want 2", len(snapshot.Records))
+	}
+	if got := snapshot.Records[0]; got.Title != "Record rec-1" || got.Description != "Details of rec-1" {
+		t.Errorf("shared record changed with its source: %+v", got)
+	}
+	if got := snapshot.Records[1]; got.Title != "Record rec-2" || got.Metadata["source"] != "test" {
+		t.Errorf("deleted record missing from the snapshot: %+v", got)
+	}
+}
+
+func TestExportLinkPINRateLimit(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+
+	ctx := context.Background()
+	created, err := es.CreateExportLink(ctx, "user-1", []string{"rec-1"}, 0, true, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	if created.PIN == "" {
+		t.Fatal("no PIN returned for a PIN-protected link")
+	}
+	token := linkToken(t, created)
+	wrong := "x" + created.PIN
+
+	if _, err := es.OpenExportLink(ctx, token, "", ""); !errors.Is(err, ErrPINRequired) {
+		t.Fatalf("open without PIN: error = %v, want %v", err, ErrPINRequired)
+	}
+	for i := 1; i < 3; i++ {
+		if _, err := es.OpenExportLink(ctx, token, wrong, ""); !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("wrong PIN %d: error = %v, want %v", i, err, ErrPermissionDenied)
+		}
+	}
+	if _, err := es.OpenExportLink(ctx, token, wrong, ""); !errors.Is(err, ErrLocked) {
+		t.Fatalf("third wrong PIN: error = %v, want %v", err, ErrLocked)
+	}
+
+	// While locked even the right PIN is refused
+	clock.Advance(59 * time.Second)
+	if _, err := es.OpenExportLink(ctx, token, created.PIN, ""); !errors.Is(err, ErrLocked) {
+		t.Fatalf("right PIN while locked: error = %v, want %v", err, ErrLocked)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := es.OpenExportLink(ctx, token, created.PIN, ""); err != nil {
+		t.Fatalf("right PIN after the lockout: %v", err)
+	}
+
+	var link models.ExportLink
+	if err := db.First(&link, "id = ?", created.Link.ID).Error; err != nil {
+		t.Fatalf("load link: %v", err)
+	}
+	if link.AccessCount != 1 || link.FailedPINAttempts != 0 || link.LockedUntil != nil {
+		t.Errorf("after a successful open: accesses %d, failed attempts %d, locked until %v",
+			link.AccessCount, link.FailedPINAttempts, link.LockedUntil)
+	}
+
+	// A success resets the count, so two more wrong PINs do not lock it
+	for i := 0; i < 2; i++ {
+		if _, err := es.OpenExportLink(ctx, token, wrong, ""); !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("wrong PIN after a success: error = %v, want %v", err, ErrPermissionDenied)
+		}
+	}
+}
+
+func TestExportLinkCancelledRequestsStillCountPINAttempts(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+
+	created, err := es.CreateExportLink(context.Background(), "user-1", []string{"rec-1"}, 0, true, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	var link models.ExportLink
+	if err := db.First(&link, "id = ?", created.Link.ID).Error; err != nil {
+		t.Fatalf("load link: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	es.recordFailedPIN(ctx, &link, clock.Now())
+
+	if err := db.First(&link, "id = ?", created.Link.ID).Error; err != nil {
+		t.Fatalf("load link: %v", err)
+	}
+	if link.FailedPINAttempts != 1 {
+		t.Errorf("failed attempts = %d after a cancelled request, want 1", link.FailedPINAttempts)
+	}
+}
+
+func TestConcurrentWrongPINsLockTheLink(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+
+	created, err := es.CreateExportLink(context.Background(), "user-1", []string{"rec-1"}, 0, true, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	// Every request read the link before any of them counted a wrong PIN
+	var link models.ExportLink
+	if err := db.First(&link, "id = ?", created.Link.ID).Error; err != nil {
+		t.Fatalf("load link: %v", err)
+	}
+
+	const attempts = 3
+	var wg sync.WaitGroup
+	results := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stale := link
+			results <- es.recordFailedPIN(context.Background(), &stale, clock.Now())
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	locks := 0
+	for err := range results {
+		if errors.Is(err, ErrLocked) {
+			locks++
+		}
+	}
+	if locks != 1 {
+		t.Errorf("%d of %d wrong PINs locked the link, want 1", locks, attempts)
+	}
+	if _, err := es.OpenExportLink(context.Background(), linkToken(t, created), created.PIN, ""); !errors.Is(err, ErrLocked) {
+		t.Errorf("right PIN after %d concurrent wrong ones: error = %v, want %v", attempts, err, ErrLocked)
+	}
+}
+
+func TestExportLinkLifetime(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	ctx := context.Background()
+
+	if _, err := es.CreateExportLink(ctx, "user-1", []string{"rec-1"}, 48*time.Hour, false, "", false); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expiry over the maximum: error = %v, want %v", err, ErrInvalidArgument)
+	}
+	if _, err := es.CreateExportLink(ctx, "user-2", []string{"rec-1"}, 0, false, "", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("sharing another user's record: error = %v, want %v", err, ErrNotFound)
+	}
+
+	expiring, err := es.CreateExportLink(ctx, "user-1", []string{"rec-1"}, time.Hour, false, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	revoked, err := es.CreateExportLink(ctx, "user-1", []string{"rec-1"}, 2*time.Hour, false, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+
+	if err := es.RevokeExportLink(ctx, "user-2", revoked.Link.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoke by another user: error = %v, want %v", err, ErrNotFound)
+	}
+	if err := es.RevokeExportLink(ctx, "user-1", revoked.Link.ID); err != nil {
+		t.Fatalf("RevokeExportLink: %v", err)
+	}
+	if _, err := es.OpenExportLink(ctx, linkToken(t, revoked), "", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open revoked link: error = %v, want %v", err, ErrNotFound)
+	}
+
+	clock.Advance(time.Hour)
+	if _, err := es.OpenExportLink(ctx, linkToken(t, expiring), "", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open expired link: error = %v, want %v", err, ErrNotFound)
+	}
+
+	purged, err := es.PurgeExpired(ctx)
+	if err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d links, want 1", purged)
+	}
+	var left []string
+	db.Model(&models.ExportLinkRecord{}).Pluck("link_id", &left)
+	if len(left) != 1 || left[0] != revoked.Link.ID {
+		t.Errorf("link records left after purge = %v, want only %s", left, revoked.Link.ID)
+	}
+}
+
+func TestRedactedExportLeavesOriginalsIntact(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	createUser(t, db, "user-1")
+	db.Model(&models.User{}).Where("id = ?", "user-1").Update("name", "Jane Doe")
+	original := models.HealthRecord{
+		ID:          "rec-1",
+		UserID:      "user-1",
+		RecordType:  "lab_result",
+		Title:       "Jane Doe - lipid panel from Dr. Patel",
+		Description: "Call (555) 123-4567 or email jane.doe@example.com; lives at 42 Baker Street. MRN 12345678901. Doe family history.",
+		Metadata:    `{"ldl":"130 mg/dL","contact":"+44 20 7946 0958","ssn":"123-45-6789"}`,
+		CreatedAt:   clock.Now(),
+		UpdatedAt:   clock.Now(),
+	}
+	if err := db.Create(&original).Error; err != nil {
+		t.Fatalf("create record: %v", err)
+	}
+	ctx := context.Background()
+
+	created, err := es.CreateExportLink(ctx, "user-1", []string{"rec-1"}, 0, false, "doctor@example.org", true)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	if !created.Link.Redacted {
+		t.Error("link not marked redacted")
+	}
+	snapshot, err := es.OpenExportLink(ctx, linkToken(t, created), "", "")
+	if err != nil {
+		t.Fatalf("OpenExportLink: %v", err)
+	}
+	if !snapshot.Redacted || snapshot.PatientName != "" {
+		t.Errorf("snapshot header: redacted %v, patient %q", snapshot.Redacted, snapshot.PatientName)
+	}
+	encoded, _ := json.Marshal(snapshot)
+	for _, pii := range []string{"Jane", "Doe", "Patel", "555", "123-4567", "jane.doe@example.com", "Baker", "12345678901", "7946", "123-45-6789"} {
+		if strings.Contains(string(encoded), pii) {
+			t.Errorf("redacted export contains %q: %s", pii, encoded)
+		}
+	}
+	if got := snapshot.Records[0].Metadata["ldl"]; got != "130 mg/dL" {
+		t.Errorf("medical content changed by redaction: ldl = %q", got)
+	}
+
+	var delivery models.Delivery
+	if err := db.First(&delivery, "recipient = ?", "doctor@example.org").Error; err != nil {
+		t.Fatalf("notification: %v", err)
+	}
+	if strings.Contains(delivery.Body, "Jane") {
+		t.Errorf("notification of a redacted link names the patient: %q", delivery.Body)
+	}
+
+	var stored models.HealthRecord
+	if err := db.First(&stored, "id = ?", "rec-1").Error; err != nil {
+		t.Fatalf("load record: %v", err)
+	}
+	if stored.Title != original.Title || stored.Description != original.Description || stored.Metadata != original.Metadata {
+		t.Errorf("stored record changed by a redacted export: %+v", stored)
+	}
+
+	// The same record shared unredacted keeps its details
+	plain, err := es.CreateExportLink(ctx, "user-1", []string{"rec-1"}, 0, false, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	snapshot, err = es.OpenExportLink(ctx, linkToken(t, plain), "", "")
+	if err != nil {
+		t.Fatalf("OpenExportLink: %v", err)
+	}
+	if snapshot.Redacted || snapshot.PatientName != "Jane Doe" || snapshot.Records[0].Title != original.Title {
+		t.Errorf("unredacted snapshot = %+v", snapshot)
+	}
+}
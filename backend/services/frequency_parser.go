@@ -0,0 +1,244 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dosing frequency parsing for prescription text such as "twice daily with
+// meals", "q8h", "BID", "1-0-1", "every morning", or "as needed". The
+// result is a proposed set of clock times the user confirms before any
+// reminders are scheduled, so the parser is deterministic and errs towards
+// Recognized=false rather than guessing.
+
+var (
+	asNeededPattern      = regexp.MustCompile(`\b(as|when|if)\s+(needed|required|necessary)\b|\bprn\b|\bp\.r\.n\b|\bsos\b|\bon demand\b`)
+	doseGridPattern      = regexp.MustCompile(`^\s*([0-9](?:\.5)?|½)\s*-\s*([0-9](?:\.5)?|½)\s*-\s*([0-9](?:\.5)?|½)(?:\s*-\s*([0-9](?:\.5)?|½))?(?:\s|$)`)
+	intervalHoursPattern = regexp.MustCompile(`\bq\s*(\d{1,2})\s*h(?:rs?|ours?)?\b|\bevery\s+(\d{1,2})\s*(?:(?:-|to)\s*(\d{1,2})\s*)?(?:h|hrs?|hours?)\b`)
+	perWeekPattern       = regexp.MustCompile(`\b(twice|thrice|two times|three times|[2-6]\s*(?:x|times))\s+(a|per|each|every)\s+week\b|\b[2-6]\s*x\s*/\s*(?:wk|week)\b`)
+	everyNDaysPattern    = regexp.MustCompile(`\bevery\s+(\d{1,2})\s+days\b`)
+	everyOtherDayPattern = regexp.MustCompile(`\bevery\s+(other|second|alternate)\s+day\b|\balternate\s+days\b|\bqod\b|\bq\.o\.d\b`)
+	weeklyPattern        = regexp.MustCompile(`\bweekly\b|\bonce\s+(a|per|each|every)\s+week\b|\bevery\s+week\b|\bqw(?:k)?\b`)
+	dailyCountPattern    = regexp.MustCompile(`\b(once|twice|thrice|one\s+time|(?:one|two|three|four|five|six)\s+times|[1-6]\s*times|[1-6]\s*x(?:\s+(?:a|per|each|every)\s+day|\s+daily|\s*/\s*day|\s+a\s+day))\b`)
+	abbreviationPattern  = regexp.MustCompile(`\b(od|qd|q\.d|o\.d|daily|qam|qpm|qhs|hs|bid|b\.i\.d|bd|b\.d|tid|t\.i\.d|tds|t\.d\.s|qid|q\.i\.d|qds|q\.d\.s|mane|nocte|nightly)\b`)
+	everyDayPattern      = regexp.MustCompile(`\b(every|each|a|per)\s+day\b|\bdaily\b`)
+	mealsPattern         = regexp.MustCompile(`\b(with|after|before)\s+(meals|food)\b|\b(ac|pc)\b`)
+	morningPattern       = regexp.MustCompile(`\bmornings?\b|\bbreakfast\b|\bqam\b|\bmane\b|\bon waking\b`)
+	noonPattern          = regexp.MustCompile(`\bnoon\b|\bmidday\b|\blunch\b`)
+	eveningPattern       = regexp.MustCompile(`\bevenings?\b|\bdinner\b|\bsupper\b|\bqpm\b`)
+	bedtimePattern       = regexp.MustCompile(`\bbedtime\b|\bbed\b|\bnight\b|\bnightly\b|\bqhs\b|\bhs\b|\bnocte\b`)
+)
+
+// Clock times proposed for each part of the day
+const (
+	doseTimeMorning = "08:00"
+	doseTimeNoon    = "13:00"
+	doseTimeEvening = "18:00"
+	doseTimeBedtime = "22:00"
+)
+
+// defaultDoseTimes spreads n daily doses over waking hours
+var defaultDoseTimes = map[int][]string{
+	1: {"08:00"},
+	2: {"08:00", "20:00"},
+	3: {"08:00", "14:00", "20:00"},
+	4: {"08:00", "12:00", "16:00", "20:00"},
+	5: {"07:00", "11:00", "15:00", "19:00", "23:00"},
+	6: {"06:00", "10:00", "14:00", "18:00", "22:00", "02:00"},
+}
+
+// mealDoseTimes places n daily doses at mealtimes
+var mealDoseTimes = map[int][]string{
+	1: {doseTimeMorning},
+	2: {doseTimeMorning, doseTimeEvening},
+	3: {doseTimeMorning, doseTimeNoon, doseTimeEvening},
+}
+
+// doseCountWords maps spelled-out and Latin-abbreviated daily counts
+var doseCountWords = map[string]int{
+	"once": 1, "one": 1, "od": 1, "qd": 1, "q.d": 1, "o.d": 1, "daily": 1,
+	"twice": 2, "two": 2, "bid": 2, "b.i.d": 2, "bd": 2, "b.d": 2,
+	"thrice": 3, "three": 3, "tid": 3, "t.i.d": 3, "tds": 3, "t.d.s": 3,
+	"four": 4, "qid": 4, "q.i.d": 4, "qds": 4, "q.d.s": 4,
+	"five": 5, "six": 6,
+}
+
+// DoseFrequency is the schedule proposed for a frequency phrase
+type DoseFrequency struct {
+	TimesOfDay []string // "HH:MM" in the user's time zone, ascending
+	EveryDays  int      // 1 daily, 2 every other day, 7 weekly
+	AsNeeded   bool     // taken on demand; never scheduled
+	Recognized bool     // false when the text could not be interpreted
+}
+
+// ParseFrequency turns dosing frequency text into proposed dose times.
+// "As needed" wins over anything else in the text, so "1 tab twice daily
+// as needed" schedules nothing. Explicit intervals ("q8h", "every 6
+// hours") and dose grids ("1-0-1") come next, then daily counts ("BID",
+// "three times a day") combined with meal and part-of-day hints.
+func ParseFrequency(text string) DoseFrequency {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return DoseFrequency{}
+	}
+	if asNeededPattern.MatchString(text) {
+		return DoseFrequency{AsNeeded: true, Recognized: true}
+	}
+	// A few doses a week cannot be expressed as a daily time plus a day
+	// interval without choosing the weekdays, which is for the user.
+	if perWeekPattern.MatchString(text) {
+		return DoseFrequency{}
+	}
+
+	everyDays := parseDayInterval(text)
+
+	if times, ok := parseDoseGrid(text); ok {
+		return DoseFrequency{TimesOfDay: times, EveryDays: everyDays, Recognized: true}
+	}
+
+	if m := intervalHoursPattern.FindStringSubmatch(text); m != nil {
+		hours := firstNumber(m[1], m[3], m[2]) // for "every 4-6 hours" use the longer gap
+		times, ok := intervalDoseTimes(hours)
+		if !ok {
+			return DoseFrequency{}
+		}
+		return DoseFrequency{TimesOfDay: times, EveryDays: 1, Recognized: true}
+	}
+
+	count := parseDailyCount(text)
+	slots := partOfDayTimes(text)
+	withMeals := mealsPattern.MatchString(text)
+
+	var times []string
+	switch {
+	case count == 0 && len(slots) > 0:
+		times = slots
+	case count == 0 && withMeals:
+		times = mealDoseTimes[3]
+	case count == 0 && (everyDays > 1 || everyDayPattern.MatchString(text)):
+		times = defaultDoseTimes[1]
+	case count == 0:
+		return DoseFrequency{}
+	case len(slots) == count:
+		times = slots
+	case withMeals && mealDoseTimes[count] != nil:
+		times = mealDoseTimes[count]
+	default:
+		times = defaultDoseTimes[count]
+	}
+	if times == nil {
+		return DoseFrequency{}
+	}
+
+	return DoseFrequency{TimesOfDay: sortedTimes(times), EveryDays: everyDays, Recognized: true}
+}
+
+// parseDayInterval returns how many days apart dosing days are
+func parseDayInterval(text string) int {
+	switch {
+	case everyOtherDayPattern.MatchString(text):
+		return 2
+	case weeklyPattern.MatchString(text):
+		return 7
+	}
+	if m := everyNDaysPattern.FindStringSubmatch(text); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 {
+			return n
+		}
+	}
+	return 1
+}
+
+// parseDoseGrid reads the morning-noon-evening(-night) notation, e.g.
+// "1-0-1" for one dose in the morning and one in the evening. The numbers
+// are amounts, so only whether each slot is non-zero matters here.
+func parseDoseGrid(text string) ([]string, bool) {
+	m := doseGridPattern.FindStringSubmatch(text)
+	if m == nil {
+		return nil, false
+	}
+
+	slots := []string{doseTimeMorning, "14:00", "20:00"}
+	amounts := m[1:4]
+	if m[4] != "" {
+		slots = []string{doseTimeMorning, doseTimeNoon, doseTimeEvening, doseTimeBedtime}
+		amounts = m[1:5]
+	}
+
+	var times []string
+	for i, amount := range amounts {
+		if amount != "0" {
+			times = append(times, slots[i])
+		}
+	}
+	return times, len(times) > 0
+}
+
+// intervalDoseTimes spaces doses every hours hours from 08:00. Intervals
+// that do not divide the day evenly drift across days, so they are left
+// for the user to schedule.
+func intervalDoseTimes(hours int) ([]string, bool) {
+	if hours < 1 || hours > 24 || 24%hours != 0 {
+		return nil, false
+	}
+	var times []string
+	for h := 8; h < 8+24; h += hours {
+		times = append(times, fmt.Sprintf("%02d:00", h%24))
+	}
+	return sortedTimes(times), true
+}
+
+// parseDailyCount returns the number of doses per day the text states, or
+// 0 when it states none
+func parseDailyCount(text string) int {
+	if m := dailyCountPattern.FindStringSubmatch(text); m != nil {
+		if c := m[1][0]; c >= '1' && c <= '6' {
+			return int(c - '0')
+		}
+		return doseCountWords[strings.Fields(m[1])[0]]
+	}
+	for _, abbr := range abbreviationPattern.FindAllString(text, -1) {
+		if n, ok := doseCountWords[abbr]; ok {
+			return n
+		}
+	}
+	return 0
+}
+
+// partOfDayTimes returns the times for each part of the day the text names,
+// e.g. "every morning and at bedtime"
+func partOfDayTimes(text string) []string {
+	var times []string
+	if morningPattern.MatchString(text) {
+		times = append(times, doseTimeMorning)
+	}
+	if noonPattern.MatchString(text) {
+		times = append(times, doseTimeNoon)
+	}
+	if eveningPattern.MatchString(text) {
+		times = append(times, doseTimeEvening)
+	}
+	if bedtimePattern.MatchString(text) {
+		times = append(times, doseTimeBedtime)
+	}
+	return times
+}
+
+// firstNumber returns the first of values that parses as an integer
+func firstNumber(values ...string) int {
+	for _, v := range values {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+func sortedTimes(times []string) []string {
+	sorted := append([]string(nil), times...)
+	sort.Strings(sorted)
+	return sorted
+}
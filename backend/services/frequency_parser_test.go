@@ -0,0 +1,158 @@
+package services
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"testing"
+)
+
+var doseTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+func TestParseFrequency(t *testing.T) {
+	daily := func(times ...string) DoseFrequency {
+		return DoseFrequency{TimesOfDay: times, EveryDays: 1, Recognized: true}
+	}
+	every := func(days int, times ...string) DoseFrequency {
+		return DoseFrequency{TimesOfDay: times, EveryDays: days, Recognized: true}
+	}
+	asNeeded := DoseFrequency{AsNeeded: true, Recognized: true}
+	unrecognized := DoseFrequency{}
+
+	tests := []struct {
+		text string
+		want DoseFrequency
+	}{
+		// Once a day
+		{"once daily", daily("08:00")},
+		{"OD", daily("08:00")},
+		{"daily", daily("08:00")},
+		{"one time daily", daily("08:00")},
+		{"1 tablet every day", daily("08:00")},
+		{"take one a day", daily("08:00")},
+
+		// Twice a day
+		{"twice daily", daily("08:00", "20:00")},
+		{"BID", daily("08:00", "20:00")},
+		{"b.i.d.", daily("08:00", "20:00")},
+		{"BD", daily("08:00", "20:00")},
+		{"2 times a day", daily("08:00", "20:00")},
+		{"2x daily", daily("08:00", "20:00")},
+		{"2x/day", daily("08:00", "20:00")},
+		{"twice daily with meals", daily("08:00", "18:00")},
+
+		// Three and more times a day
+		{"TID", daily("08:00", "14:00", "20:00")},
+		{"TDS", daily("08:00", "14:00", "20:00")},
+		{"three times a day", daily("08:00", "14:00", "20:00")},
+		{"three times daily with meals", daily("08:00", "13:00", "18:00")},
+		{"3 times a day after food", daily("08:00", "13:00", "18:00")},
+		{"before meals", daily("08:00", "13:00", "18:00")},
+		{"QID", daily("08:00", "12:00", "16:00", "20:00")},
+		{"qds", daily("08:00", "12:00", "16:00", "20:00")},
+		{"four times a day", daily("08:00", "12:00", "16:00", "20:00")},
+		{"five times a day", daily("07:00", "11:00", "15:00", "19:00", "23:00")},
+		{"six times a day", daily("02:00", "06:00", "10:00", "14:00", "18:00", "22:00")},
+
+		// Hour intervals
+		{"q8h", daily("00:00", "08:00", "16:00")},
+		{"Q6H", daily("02:00", "08:00", "14:00", "20:00")},
+		{"q12h", daily("08:00", "20:00")},
+		{"q4h", daily("00:00", "04:00", "08:00", "12:00", "16:00", "20:00")},
+		{"q 8 hrs", daily("00:00", "08:00", "16:00")},
+		{"every 8 hours", daily("00:00", "08:00", "16:00")},
+		{"every 6 hours", daily("02:00", "08:00", "14:00", "20:00")},
+		{"every 4-6 hours", daily("02:00", "08:00", "14:00", "20:00")},
+		{"every 12 hours", daily("08:00", "20:00")},
+		{"every 24 hours", daily("08:00")},
+		{"every 5 hours", unrecognized},
+		{"every 36 hours", unrecognized},
+		{"q0h", unrecognized},
+
+		// Parts of the day
+		{"every morning", daily("08:00")},
+		{"mane", daily("08:00")},
+		{"each evening", daily("18:00")},
+		{"at bedtime", daily("22:00")},
+		{"qhs", daily("22:00")},
+		{"nocte", daily("22:00")},
+		{"once daily at night", daily("22:00")},
+		{"every morning and evening", daily("08:00", "18:00")},
+		{"twice daily morning and bedtime", daily("08:00", "22:00")},
+		{"morning, noon and night", daily("08:00", "13:00", "22:00")},
+
+		// Dose grids
+		{"1-0-1", daily("08:00", "20:00")},
+		{"1-1-1", daily("08:00", "14:00", "20:00")},
+		{"0-0-1", daily("20:00")},
+		{"2-0-1", daily("08:00", "20:00")},
+		{"½-0-½", daily("08:00", "20:00")},
+		{"1-0-0-1", daily("08:00", "22:00")},
+		{"0-0-0", unrecognized},
+
+		// Day intervals
+		{"every other day", every(2, "08:00")},
+		{"qod", every(2, "08:00")},
+		{"alternate days", every(2, "08:00")},
+		{"once daily every other day", every(2, "08:00")},
+		{"every 3 days", every(3, "08:00")},
+		{"weekly", every(7, "08:00")},
+		{"once a week", every(7, "08:00")},
+
+		// As needed always wins
+		{"as needed", asNeeded},
+		{"PRN", asNeeded},
+		{"when required", asNeeded},
+		{"sos", asNeeded},
+		{"q4h prn", asNeeded},
+		{"take 1 tablet twice daily as needed for pain", asNeeded},
+
+		// Left for the user
+		{"twice a week", unrecognized},
+		{"3x per week", unrecognized},
+		{"take with water", unrecognized},
+		{"use as directed", unrecognized},
+		{"", unrecognized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := ParseFrequency(tt.text)
+			if !slices.Equal(got.TimesOfDay, tt.want.TimesOfDay) || got.EveryDays != tt.want.EveryDays ||
+				got.AsNeeded != tt.want.AsNeeded || got.Recognized != tt.want.Recognized {
+				t.Errorf("ParseFrequency(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseFrequency(f *testing.F) {
+	for _, seed := range []string{"twice daily with meals", "q8h", "BID", "1-0-1", "every morning", "as needed", "every 0 hours", "½-0-½-1"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		freq := ParseFrequency(text)
+		if !freq.Recognized {
+			if len(freq.TimesOfDay) != 0 || freq.AsNeeded {
+				t.Fatalf("unrecognized %q still proposed %+v", text, freq)
+			}
+			return
+		}
+		if freq.AsNeeded {
+			if len(freq.TimesOfDay) != 0 {
+				t.Fatalf("as-needed %q scheduled %v", text, freq.TimesOfDay)
+			}
+			return
+		}
+		if len(freq.TimesOfDay) == 0 || freq.EveryDays < 1 {
+			t.Fatalf("recognized %q with no schedule: %+v", text, freq)
+		}
+		for _, clock := range freq.TimesOfDay {
+			if !doseTimePattern.MatchString(clock) {
+				t.Fatalf("%q proposed invalid time %q", text, clock)
+			}
+		}
+		if !sort.StringsAreSorted(freq.TimesOfDay) {
+			t.Fatalf("%q proposed unsorted times %v", text, freq.TimesOfDay)
+		}
+	})
+}
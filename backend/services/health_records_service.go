@@ -1,102 +1,539 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/events"
 	"github.com/clarity/backend/models"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type HealthRecordsService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	config *config.RecordsConfig
+	types  *RecordTypes
+	events *events.Bus
 }
 
-func NewHealthRecordsService(db *gorm.DB) *HealthRecordsService {
-	return &HealthRecordsService{db: db}
+func NewHealthRecordsService(db *gorm.DB, cfg *config.RecordsConfig, types *RecordTypes, bus *events.Bus) *HealthRecordsService {
+	return &HealthRecordsService{
+		db:     db,
+		config: cfg,
+		types:  types,
+		events: bus,
+	}
 }
 
-// CreateRecord creates a new health record
-func (hrs *HealthRecordsService) CreateRecord(userID, recordType, title, description string, metadata map[string]string) (*models.HealthRecord, error) {
-	metadataJSON, err := json.Marshal(metadata)
+// RecordView selects how much of each record ListRecords loads
+type RecordView string
+
+const (
+	// RecordViewFull loads every column, including metadata
+	RecordViewFull RecordView = "full"
+	// RecordViewSummary loads only what a list row shows: no metadata and
+	// a description cut to recordPreviewLength characters
+	RecordViewSummary RecordView = "summary"
+)
+
+// recordPreviewLength is the description length returned by the summary view
+const recordPreviewLength = 100
+
+// ListRecordsOptions controls pagination, ordering and projection for
+// ListRecords
+type ListRecordsOptions struct {
+	Limit     int
+	Offset    int
+	SortBy    string     // created_at (default), updated_at, title, record_type
+	SortOrder string     // desc (default), asc
+	View      RecordView // full (default), summary
+
+	// MetadataRanges keeps only records whose numeric metadata falls in
+	// every range
+	MetadataRanges []MetadataRange
+
+	RecordType string    // empty for every type
+	StartDate  time.Time // records created at or after; zero leaves the range open
+	EndDate    time.Time // records created at or before; zero leaves the range open
+}
+
+// CreateRecord creates a new health record. With a duplicate window
+// configured, a create matching a record the owner made within the window
+// returns that record instead.
+func (hrs *HealthRecordsService) CreateRecord(ctx context.Context, userID, recordType, title, description string, metadata map[string]string) (*models.HealthRecord, error) {
+	record, err := hrs.newRecord(userID, recordType, title, description, metadata)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		return nil, err
 	}
 
-	record := models.HealthRecord{
+	var existing *models.HealthRecord
+	err = hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		duplicate, err := hrs.findDuplicate(tx, record)
+		if err != nil || duplicate != nil {
+			existing = duplicate
+			return err
+		}
+		return insertRecord(tx, record, metadata)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	hrs.events.Publish(ctx, events.RecordCreated{UserID: userID, RecordID: record.ID, RecordType: record.RecordType})
+	return record, nil
+}
+
+// newRecord validates a new record and builds it without saving it
+func (hrs *HealthRecordsService) newRecord(userID, recordType, title, description string, metadata map[string]string) (*models.HealthRecord, error) {
+	if err := hrs.types.validate(recordType); err != nil {
+		return nil, err
+	}
+	if err := hrs.types.validateMetadata(recordType, metadata); err != nil {
+		return nil, err
+	}
+
+	metadataJSON, err := hrs.marshalMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if metadataJSON, err = hrs.addLanguage(metadataJSON, metadata, title, description); err != nil {
+		return nil, err
+	}
+
+	return &models.HealthRecord{
 		ID:          uuid.New().String(),
 		UserID:      userID,
 		RecordType:  recordType,
 		Title:       title,
 		Description: description,
 		Metadata:    string(metadataJSON),
+		Sensitivity: hrs.defaultSensitivity(recordType, metadata),
+		ContentHash: recordContentHash(recordType, title, description),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+	}, nil
+}
+
+// recordContentHash hashes a record's type, title and description,
+// ignoring case and runs of whitespace so a retyped duplicate still matches
+func recordContentHash(recordType, title, description string) string {
+	hash := sha256.New()
+	for _, field := range []string{recordType, title, description} {
+		hash.Write([]byte(strings.ToLower(strings.Join(strings.Fields(field), " "))))
+		hash.Write([]byte{0})
 	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
 
-	if err := hrs.db.Create(&record).Error; err != nil {
-		return nil, fmt.Errorf("failed to create record: %w", err)
+// findDuplicate returns the owner's most recent record with the same
+// content as record created within the duplicate window, or nil. It is a
+// guard against retries, so two creates racing each other may both land.
+func (hrs *HealthRecordsService) findDuplicate(tx *gorm.DB, record *models.HealthRecord) (*models.HealthRecord, error) {
+	if hrs.config.DuplicateWindow <= 0 {
+		return nil, nil
 	}
+	since := record.CreatedAt.Add(-time.Duration(hrs.config.DuplicateWindow) * time.Second)
+	var existing models.HealthRecord
+	err := tx.Scopes(scopeOwner(record.UserID)).
+		Where("content_hash = ? AND created_at >= ?", record.ContentHash, since).
+		Order("created_at DESC").First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for a duplicate record: %w", err)
+	}
+	return &existing, nil
+}
 
-	return &record, nil
+// defaultSensitivity marks records whose type or metadata category is one
+// of the configured sensitive categories
+func (hrs *HealthRecordsService) defaultSensitivity(recordType string, metadata map[string]string) string {
+	category := strings.ToLower(strings.TrimSpace(metadata["category"]))
+	for _, sensitive := range hrs.config.SensitiveCategories {
+		if recordType == sensitive || category == sensitive {
+			return models.SensitivitySensitive
+		}
+	}
+	return models.SensitivityStandard
+}
+
+// insertRecord saves a record built by newRecord inside tx, along with its
+// search entry and follow-up reminders. A scan_id in the metadata links the
+// record to the owner's stored scan for later re-extraction.
+func insertRecord(tx *gorm.DB, record *models.HealthRecord, metadata map[string]string) error {
+	if scanID := metadata["scan_id"]; scanID != "" {
+		var scan models.ScanInput
+		err := tx.Select("id, extraction_version, provider").Scopes(scopeOwner(record.UserID)).First(&scan, "id = ?", scanID).Error
+		if err == nil {
+			record.ScanID = scan.ID
+			record.ExtractionVersion = scan.ExtractionVersion
+			record.ExtractionProvider = scan.Provider
+		}
+	}
+	seq, err := nextSyncSeq(tx, record.UserID)
+	if err != nil {
+		return err
+	}
+	record.SyncSeq = seq
+	if err := tx.Create(record).Error; err != nil {
+		return fmt.Errorf("failed to create record: %w", err)
+	}
+	if err := indexRecord(tx, record); err != nil {
+		return fmt.Errorf("failed to index record: %w", err)
+	}
+	summary := strings.ReplaceAll(record.RecordType, "_", " ") + " added"
+	if err := recordChange(tx, record.UserID, record, models.ChangeEntityRecord, record.ID, models.ChangeKindCreated, summary); err != nil {
+		return err
+	}
+	return createFollowUpReminders(tx, record, metadata)
 }
 
-// GetRecord retrieves a single record
-func (hrs *HealthRecordsService) GetRecord(recordID string) (*models.HealthRecord, error) {
+// GetRecord retrieves one of userID's records. Another user's record is
+// reported as not found, so record IDs cannot be probed.
+func (hrs *HealthRecordsService) GetRecord(ctx context.Context, userID, recordID string) (*models.HealthRecord, error) {
 	var record models.HealthRecord
-	if err := hrs.db.First(&record, "id = ?", recordID).Error; err != nil {
-		return nil, fmt.Errorf("record not found: %w", err)
+	if err := hrs.db.WithContext(ctx).Scopes(scopeOwner(userID)).First(&record, "id = ?", recordID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("%w: record %s", ErrNotFound, recordID)
+		}
+		return nil, fmt.Errorf("failed to fetch record: %w", err)
 	}
 	return &record, nil
 }
 
 // ListRecords retrieves records with pagination
-func (hrs *HealthRecordsService) ListRecords(userID string, limit, offset int) ([]models.HealthRecord, int64, error) {
+func (hrs *HealthRecordsService) ListRecords(ctx context.Context, userID string, opts ListRecordsOptions) ([]models.HealthRecord, int64, error) {
 	var records []models.HealthRecord
 	var total int64
 
-	if err := hrs.db.Where("user_id = ?", userID).Model(&models.HealthRecord{}).Count(&total).Error; err != nil {
+	order, err := recordOrder(opts.SortBy, opts.SortOrder)
+	if err != nil {
+		return nil, 0, err
+	}
+	limit, offset := pageBounds(opts.Limit, opts.Offset)
+	if err := validateMetadataRanges(opts.MetadataRanges); err != nil {
+		return nil, 0, err
+	}
+	if opts.RecordType != "" {
+		if err := hrs.types.validate(opts.RecordType); err != nil {
+			return nil, 0, err
+		}
+	}
+	if !opts.StartDate.IsZero() && !opts.EndDate.IsZero() && opts.EndDate.Before(opts.StartDate) {
+		return nil, 0, fmt.Errorf("%w: date range ends before it starts", ErrInvalidArgument)
+	}
+	filters := []func(*gorm.DB) *gorm.DB{
+		scopeOwner(userID),
+		scopeMetadataRanges(userID, opts.MetadataRanges),
+		scopeRecordFilter(opts.RecordType, opts.StartDate, opts.EndDate),
+	}
+
+	if err := hrs.db.WithContext(ctx).Model(&models.HealthRecord{}).Scopes(filters...).Count(&total).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to count records: %w", err)
 	}
 
-	if err := hrs.db.Where("user_id = ?", userID).
-		Order("created_at DESC").
+	query := hrs.db.WithContext(ctx).Scopes(filters...)
+	switch opts.View {
+	case "", RecordViewFull:
+	case RecordViewSummary:
+		query = query.Select(hrs.summaryColumns())
+	default:
+		return nil, 0, fmt.Errorf("%w: unknown record view %q", ErrInvalidArgument, opts.View)
+	}
+
+	if err := query.
+		Order(order).
 		Limit(limit).
 		Offset(offset).
 		Find(&records).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to list records: %w", err)
 	}
 
+	if opts.View == RecordViewSummary {
+		for i := range records {
+			records[i].Description = truncateRunes(records[i].Description, recordPreviewLength)
+		}
+	}
+
 	return records, total, nil
 }
 
-// UpdateRecord updates an existing record
-func (hrs *HealthRecordsService) UpdateRecord(recordID, title, description string, metadata map[string]string) (*models.HealthRecord, error) {
-	metadataJSON, err := json.Marshal(metadata)
+// scopeRecordFilter keeps records of recordType created between start and
+// end inclusive. Empty or zero arguments do not filter.
+func scopeRecordFilter(recordType string, start, end time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if recordType != "" {
+			db = db.Where("record_type = ?", recordType)
+		}
+		switch {
+		case !start.IsZero() && !end.IsZero():
+			db = db.Where("created_at BETWEEN ? AND ?", start, end)
+		case !start.IsZero():
+			db = db.Where("created_at >= ?", start)
+		case !end.IsZero():
+			db = db.Where("created_at <= ?", end)
+		}
+		return db
+	}
+}
+
+// summaryColumns is the SELECT list for the summary view. Dialects with a
+// character-aware SUBSTR truncate the description in the query so the full
+// text never leaves the database; others load it and ListRecords trims it.
+func (hrs *HealthRecordsService) summaryColumns() string {
+	switch hrs.db.Dialector.Name() {
+	case "sqlite", "postgres", "mysql":
+		return fmt.Sprintf("id, user_id, record_type, title, SUBSTR(description, 1, %d) AS description, sensitivity, created_at, updated_at", recordPreviewLength)
+	default:
+		return "id, user_id, record_type, title, description, sensitivity, created_at, updated_at"
+	}
+}
+
+// truncateRunes cuts s to at most n characters without splitting a rune
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// UpdateRecord updates one of userID's records. Changes to the extracted
+// fields of a record created from a scan are kept as corrections, and those
+// fields are marked corrected in its metadata.
+func (hrs *HealthRecordsService) UpdateRecord(ctx context.Context, userID, recordID, title, description string, metadata map[string]string) (*models.HealthRecord, error) {
+	now := time.Now()
+	var updated models.HealthRecord
+	err := hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var before models.HealthRecord
+		if err := tx.Scopes(scopeOwner(userID)).First(&before, "id = ?", recordID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return fmt.Errorf("%w: record %s", ErrNotFound, recordID)
+			}
+			return fmt.Errorf("failed to fetch record: %w", err)
+		}
+
+		corrected, corrections, err := applyCorrections(&before, metadata, now)
+		if err != nil {
+			return err
+		}
+		if err := hrs.types.validateMetadata(before.RecordType, corrected); err != nil {
+			return err
+		}
+		metadataJSON, err := hrs.marshalMetadata(corrected)
+		if err != nil {
+			return err
+		}
+		if metadataJSON, err = hrs.addLanguage(metadataJSON, corrected, title, description); err != nil {
+			return err
+		}
+		if len(corrections) > 0 {
+			if err := tx.Create(&corrections).Error; err != nil {
+				return fmt.Errorf("failed to save corrections: %w", err)
+			}
+		}
+
+		// Empty fields are left as they were, and the content hash follows
+		// what is stored
+		record := models.HealthRecord{
+			Title:        title,
+			Description:  description,
+			Metadata:     string(metadataJSON),
+			ContentHash:  recordContentHash(before.RecordType, firstNonEmpty(title, before.Title), firstNonEmpty(description, before.Description)),
+			UserEditedAt: &now,
+			UpdatedAt:    now,
+		}
+		if err := tx.Model(&models.HealthRecord{}).Where("id = ?", recordID).Updates(record).Error; err != nil {
+			return fmt.Errorf("failed to update record: %w", err)
+		}
+		if err := tx.First(&updated, "id = ?", recordID).Error; err != nil {
+			return fmt.Errorf("record not found: %w", err)
+		}
+		if err := stampRecordSync(tx, &updated); err != nil {
+			return err
+		}
+		if err := indexRecord(tx, &updated); err != nil {
+			return fmt.Errorf("failed to index record: %w", err)
+		}
+		return recordChange(tx, updated.UserID, &updated, models.ChangeEntityRecord, updated.ID,
+			models.ChangeKindUpdated, describeRecordChange(&before, &updated))
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		return nil, err
 	}
 
-	record := models.HealthRecord{
-		Title:       title,
-		Description: description,
-		Metadata:    string(metadataJSON),
-		UpdatedAt:   time.Now(),
+	return &updated, nil
+}
+
+// SetRecordSensitivity lets the owner change who may read a record:
+// standard records are readable by anyone the owner has shared with,
+// sensitive ones only with a stated reason, and blocked ones by no one else
+func (hrs *HealthRecordsService) SetRecordSensitivity(ctx context.Context, userID, recordID, sensitivity string) (*models.HealthRecord, error) {
+	if !recordSensitivities[sensitivity] {
+		return nil, fmt.Errorf("%w: unknown sensitivity %q", ErrInvalidArgument, sensitivity)
 	}
 
-	if err := hrs.db.Model(&models.HealthRecord{}).Where("id = ?", recordID).Updates(record).Error; err != nil {
-		return nil, fmt.Errorf("failed to update record: %w", err)
+	var record models.HealthRecord
+	err := hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.HealthRecord{}).
+			Scopes(scopeOwner(userID)).
+			Where("id = ?", recordID).
+			Updates(map[string]interface{}{"sensitivity": sensitivity, "updated_at": time.Now()})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update record: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: record %s", ErrNotFound, recordID)
+		}
+		if err := tx.First(&record, "id = ?", recordID).Error; err != nil {
+			return fmt.Errorf("record not found: %w", err)
+		}
+		if err := stampRecordSync(tx, &record); err != nil {
+			return err
+		}
+
+		// Earlier changes to the record follow its new sensitivity, so
+		// blocking a record also hides its history from caregivers
+		if err := tx.Model(&models.ChangeEvent{}).Where("record_id = ?", recordID).
+			Update("sensitivity", sensitivity).Error; err != nil {
+			return fmt.Errorf("failed to update change history: %w", err)
+		}
+		return recordChange(tx, userID, &record, models.ChangeEntityRecord, record.ID, models.ChangeKindUpdated, "sharing changed")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ListRecordAccessLog returns, newest first, the reasons others gave for
+// reading the user's sensitive records
+func (hrs *HealthRecordsService) ListRecordAccessLog(ctx context.Context, userID string, limit, offset int) ([]models.RecordAccessLog, int64, error) {
+	limit, offset = pageBounds(limit, offset)
+
+	var total int64
+	if err := hrs.db.WithContext(ctx).Model(&models.RecordAccessLog{}).Where("owner_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count access log: %w", err)
 	}
 
-	return hrs.GetRecord(recordID)
+	var entries []models.RecordAccessLog
+	if err := hrs.db.WithContext(ctx).Where("owner_id = ?", userID).
+		Order("created_at DESC").Limit(limit).Offset(offset).
+		Find(&entries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list access log: %w", err)
+	}
+	return entries, total, nil
+}
+
+// DeleteRecord moves one of userID's records to the trash. It disappears
+// from every read but can be brought back with RestoreRecord until
+// PurgeDeletedRecords removes it for good; its scan, corrections and
+// revisions are kept until then. What acts on the record from outside stops
+// now: its links and medication schedule go to the trash with it, its
+// pending reminders are cancelled and its search entry is removed.
+func (hrs *HealthRecordsService) DeleteRecord(ctx context.Context, userID, recordID string) error {
+	return hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var records []models.HealthRecord
+		if err := tx.Scopes(scopeOwner(userID)).Limit(1).Find(&records, "id = ?", recordID).Error; err != nil {
+			return fmt.Errorf("failed to fetch record: %w", err)
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("%w: record %s", ErrNotFound, recordID)
+		}
+		if err := recordDeletion(tx, &records[0]); err != nil {
+			return err
+		}
+		if err := tombstoneRecord(tx, &records[0]); err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&models.HealthRecord{}, "id = ?", recordID).Error; err != nil {
+			return fmt.Errorf("failed to delete record: %w", err)
+		}
+		if err := removeFromIndex(tx, recordID); err != nil {
+			return fmt.Errorf("failed to remove record from index: %w", err)
+		}
+		if err := cancelRecordReminders(tx, recordID); err != nil {
+			return fmt.Errorf("failed to cancel reminders: %w", err)
+		}
+		if err := tx.Where("source_id = ? OR target_id = ?", recordID, recordID).Delete(&models.RecordLink{}).Error; err != nil {
+			return fmt.Errorf("failed to remove record links: %w", err)
+		}
+		if err := tx.Where("record_id = ?", recordID).Delete(&models.Medication{}).Error; err != nil {
+			return fmt.Errorf("failed to remove medication: %w", err)
+		}
+		return nil
+	})
+}
+
+// recordDeletion adds change events for a record about to be deleted and
+// for any medication that stops with it
+func recordDeletion(tx *gorm.DB, record *models.HealthRecord) error {
+	var medications []models.Medication
+	if err := tx.Where("record_id = ?", record.ID).Find(&medications).Error; err != nil {
+		return fmt.Errorf("failed to fetch medication: %w", err)
+	}
+	for _, medication := range medications {
+		if err := recordChange(tx, record.UserID, record, models.ChangeEntityMedication, medication.ID,
+			models.ChangeKindStopped, "prescription deleted"); err != nil {
+			return err
+		}
+	}
+	summary := strings.ReplaceAll(record.RecordType, "_", " ") + " deleted"
+	return recordChange(tx, record.UserID, record, models.ChangeEntityRecord, record.ID, models.ChangeKindDeleted, summary)
+}
+
+// addLanguage adds the detected language of title and description to
+// metadata already checked by marshalMetadata. The key is added after the
+// limits are checked so it never pushes a record over them. A language the
+// caller set is kept.
+func (hrs *HealthRecordsService) addLanguage(metadataJSON []byte, metadata map[string]string, title, description string) ([]byte, error) {
+	if !hrs.config.DetectLanguage || metadata[languageMetadataKey] != "" {
+		return metadataJSON, nil
+	}
+
+	withLanguage := make(map[string]string, len(metadata)+1)
+	for key, value := range metadata {
+		withLanguage[key] = value
+	}
+	withLanguage[languageMetadataKey] = detectLanguage(title + "\n" + description)
+
+	encoded, err := json.Marshal(withLanguage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return encoded, nil
 }
 
-// DeleteRecord deletes a record
-func (hrs *HealthRecordsService) DeleteRecord(recordID string) error {
-	if err := hrs.db.Delete(&models.HealthRecord{}, "id = ?", recordID).Error; err != nil {
-		return fmt.Errorf("failed to delete record: %w", err)
+// marshalMetadata serializes record metadata, enforcing the configured key
+// count, entry, and serialized size limits
+func (hrs *HealthRecordsService) marshalMetadata(metadata map[string]string) ([]byte, error) {
+	if hrs.config.MaxMetadataKeys > 0 && len(metadata) > hrs.config.MaxMetadataKeys {
+		return nil, fmt.Errorf("%w: %d keys (max %d)", ErrMetadataTooLarge, len(metadata), hrs.config.MaxMetadataKeys)
 	}
-	return nil
+	if err := validateMetadataEntries(metadata, hrs.config.MaxMetadataKeyLength, hrs.config.MaxMetadataValueLength); err != nil {
+		return nil, err
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if hrs.config.MaxMetadataSize > 0 && len(metadataJSON) > hrs.config.MaxMetadataSize {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrMetadataTooLarge, len(metadataJSON), hrs.config.MaxMetadataSize)
+	}
+
+	return metadataJSON, nil
 }
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestMetadataLimits(t *testing.T) {
+	cfg := &config.RecordsConfig{
+		MaxMetadataSize:        128,
+		MaxMetadataKeys:        4,
+		MaxMetadataKeyLength:   16,
+		MaxMetadataValueLength: 64,
+	}
+	manyKeys := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		manyKeys[fmt.Sprintf("k%d", i)] = "v"
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     error
+	}{
+		{"none", nil, nil},
+		{"normal", map[string]string{"lab": "City Lab", "units": "mg/dL"}, nil},
+		{"at the key limit", map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}, nil},
+		{"too many keys", manyKeys, ErrMetadataTooLarge},
+		{"key too long", map[string]string{strings.Repeat("k", 17): "v"}, ErrMetadataTooLarge},
+		{"value too long", map[string]string{"note": strings.Repeat("x", 65)}, ErrMetadataTooLarge},
+		{"serialized too large", map[string]string{
+			"a": strings.Repeat("x", 60),
+			"b": strings.Repeat("y", 60),
+		}, ErrMetadataTooLarge},
+		{"invalid key", map[string]string{"bad key": "v"}, ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			hrs := newTestRecordsService(db, cfg)
+			ctx := context.Background()
+
+			created, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Lipid panel", "Fasting", tt.metadata)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("CreateRecord() error = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				var count int64
+				db.Model(&models.HealthRecord{}).Count(&count)
+				if count != 0 {
+					t.Errorf("stored %d records with rejected metadata", count)
+				}
+			}
+
+			existing := createRecord(t, db, "existing", "user-1", "")
+			updated, err := hrs.UpdateRecord(ctx, "user-1", existing.ID, existing.Title, existing.Description, tt.metadata)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("UpdateRecord() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil {
+				for key, value := range tt.metadata {
+					if !strings.Contains(created.Metadata, fmt.Sprintf("%q:%q", key, value)) {
+						t.Errorf("created metadata %s lost %s", created.Metadata, key)
+					}
+					if !strings.Contains(updated.Metadata, fmt.Sprintf("%q:%q", key, value)) {
+						t.Errorf("updated metadata %s lost %s", updated.Metadata, key)
+					}
+				}
+				return
+			}
+			var stored models.HealthRecord
+			if err := db.First(&stored, "id = ?", existing.ID).Error; err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+			if stored.Metadata != existing.Metadata {
+				t.Errorf("rejected update changed metadata to %s", stored.Metadata)
+			}
+		})
+	}
+}
+
+func TestMetadataLimitsDisabled(t *testing.T) {
+	hrs := newTestRecordsService(newTestDB(t), nil)
+	metadata := map[string]string{"note": strings.Repeat("x", 10000)}
+	for i := 0; i < 50; i++ {
+		metadata[fmt.Sprintf("k%d", i)] = "v"
+	}
+	if _, err := hrs.CreateRecord(context.Background(), "user-1", "lab_result", "t", "d", metadata); err != nil {
+		t.Fatalf("CreateRecord with no limits configured: %v", err)
+	}
+}
+
+func TestMetadataLimitsIgnoreDetectedLanguage(t *testing.T) {
+	hrs := newTestRecordsService(newTestDB(t), &config.RecordsConfig{MaxMetadataKeys: 1, DetectLanguage: true})
+	record, err := hrs.CreateRecord(context.Background(), "user-1", "lab_result", "Lipid panel", "Cholesterol is high", map[string]string{"lab": "City Lab"})
+	if err != nil {
+		t.Fatalf("CreateRecord at the key limit: %v", err)
+	}
+	if !strings.Contains(record.Metadata, languageMetadataKey) {
+		t.Errorf("metadata %s has no detected language", record.Metadata)
+	}
+}
+
+func TestListRecordsSummaryView(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	long := strings.Repeat("é", recordPreviewLength+50)
+	record := createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	if err := db.Model(record).Update("description", long).Error; err != nil {
+		t.Fatalf("set description: %v", err)
+	}
+
+	full, _, err := hrs.ListRecords(context.Background(), "user-1", ListRecordsOptions{})
+	if err != nil {
+		t.Fatalf("ListRecords(full): %v", err)
+	}
+	summary, total, err := hrs.ListRecords(context.Background(), "user-1", ListRecordsOptions{View: RecordViewSummary})
+	if err != nil {
+		t.Fatalf("ListRecords(summary): %v", err)
+	}
+	if total != 1 || len(full) != 1 || len(summary) != 1 {
+		t.Fatalf("got %d full and %d summary records of %d", len(full), len(summary), total)
+	}
+
+	if full[0].Description != long || full[0].Metadata == "" {
+		t.Errorf("full view dropped data: description %d runes, metadata %q", len([]rune(full[0].Description)), full[0].Metadata)
+	}
+	got := summary[0]
+	if got.Description != strings.Repeat("é", recordPreviewLength) {
+		t.Errorf("summary description has %d runes, want %d", len([]rune(got.Description)), recordPreviewLength)
+	}
+	if got.Metadata != "" {
+		t.Errorf("summary view loaded metadata %q", got.Metadata)
+	}
+	if got.ID != "rec-1" || got.Title != "Record rec-1" || got.RecordType != "lab_result" || got.CreatedAt.IsZero() || got.UpdatedAt.IsZero() {
+		t.Errorf("summary view lost list fields: %+v", got)
+	}
+
+	if _, _, err := hrs.ListRecords(context.Background(), "user-1", ListRecordsOptions{View: "compact"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("unknown view: error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestRecordsAreOnlyReachableByTheirOwner(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	ctx := context.Background()
+
+	mine, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Fasting glucose", "92 mg/dL", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	theirs, err := hrs.CreateRecord(ctx, "user-2", "lab_result", "Cholesterol", "180 mg/dL", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	if record, err := hrs.GetRecord(ctx, "user-1", mine.ID); err != nil || record.Title != "Fasting glucose" {
+		t.Fatalf("GetRecord of the caller's record = %v, %v", record, err)
+	}
+
+	// Another user's record is reported exactly like a missing one
+	for _, id := range []string{theirs.ID, "missing"} {
+		if record, err := hrs.GetRecord(ctx, "user-1", id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetRecord(%s) = %v, %v; want %v", id, record, err, ErrNotFound)
+		}
+		if record, err := hrs.UpdateRecord(ctx, "user-1", id, "Taken over", "", nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateRecord(%s) = %v, %v; want %v", id, record, err, ErrNotFound)
+		}
+		if err := hrs.DeleteRecord(ctx, "user-1", id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteRecord(%s) = %v, want %v", id, err, ErrNotFound)
+		}
+	}
+
+	// and is left as it was for its owner
+	record, err := hrs.GetRecord(ctx, "user-2", theirs.ID)
+	if err != nil {
+		t.Fatalf("GetRecord by the owner: %v", err)
+	}
+	if record.Title != "Cholesterol" || record.Description != "180 mg/dL" || !record.UpdatedAt.Equal(theirs.UpdatedAt) {
+		t.Errorf("user-2's record after user-1's attempts = %+v", record)
+	}
+	if count := recordCount(t, hrs, "user-2"); count != 1 {
+		t.Errorf("user-2 has %d records, want 1", count)
+	}
+}
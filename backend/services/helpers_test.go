@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/database"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns a migrated in-memory SQLite database private to t
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.NewDatabase(&config.DatabaseConfig{
+		Type: "sqlite",
+		Path: "file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared",
+	})
+	if err != nil {
+		t.Fatalf("NewDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db.GetConnection()
+}
+
+// createUser stores a user with the given ID
+func createUser(t *testing.T, db *gorm.DB, id string) *models.User {
+	t.Helper()
+	user := &models.User{ID: id, Email: id + "@example.com", CreatedAt: time.Now()}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("create user %s: %v", id, err)
+	}
+	return user
+}
+
+// createRecord stores a record for userID directly, without the checks
+// CreateRecord makes
+func createRecord(t *testing.T, db *gorm.DB, id, userID, sensitivity string) *models.HealthRecord {
+	t.Helper()
+	record := &models.HealthRecord{
+		ID:          id,
+		UserID:      userID,
+		RecordType:  "lab_result",
+		Title:       "Record " + id,
+		Description: "Details of " + id,
+		Metadata:    `{"source":"test"}`,
+		Sensitivity: sensitivity,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := db.Create(record).Error; err != nil {
+		t.Fatalf("create record %s: %v", id, err)
+	}
+	return record
+}
+
+// newTestDeliveryQueue returns a queue whose email and push senders only
+// log
+func newTestDeliveryQueue(db *gorm.DB) *DeliveryQueue {
+	return NewDeliveryQueue(db, &config.DeliveryConfig{MaxAttempts: 3, BaseBackoff: 1, MaxBackoff: 60}, map[string]Sender{
+		models.DeliveryChannelEmail: NewLogEmailSender(),
+		models.DeliveryChannelPush:  NewNotifierSender(NewLogNotifier()),
+	}, nil)
+}
+
+// newTestAIService returns an AIService with the mock provider and no
+// optional dependencies
+func newTestAIService(t *testing.T, db *gorm.DB, cfg *config.AIConfig) *AIService {
+	t.Helper()
+	if cfg == nil {
+		cfg = &config.AIConfig{}
+	}
+	return NewAIService(db, cfg, nil, nil, nil, nil, 0, nil, nil)
+}
+
+// conversationTurns returns the stored turns of a conversation, oldest first
+func conversationTurns(t *testing.T, db *gorm.DB, conversationID string) []models.DoctorConversation {
+	t.Helper()
+	var turns []models.DoctorConversation
+	if err := db.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&turns).Error; err != nil {
+		t.Fatalf("load conversation: %v", err)
+	}
+	return turns
+}
+
+// newTestRecordsService returns a HealthRecordsService with the built-in
+// record types and no event bus
+func newTestRecordsService(db *gorm.DB, cfg *config.RecordsConfig) *HealthRecordsService {
+	if cfg == nil {
+		cfg = &config.RecordsConfig{}
+	}
+	return NewHealthRecordsService(db, cfg, nil, nil)
+}
+
+// testClock is a settable clock for services that take a now func
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestAuthService returns an AuthService on clock that queues messages
+// on a log-only email sender
+func newTestAuthService(db *gorm.DB, cfg *config.AuthConfig, clock *testClock) *AuthService {
+	if cfg.JWTSecret == "" {
+		cfg.JWTSecret = "test-secret"
+	}
+	if cfg.OTPLength == 0 {
+		cfg.OTPLength = 6
+	}
+	if cfg.OTPExpiry == 0 {
+		cfg.OTPExpiry = 600
+	}
+	as := NewAuthService(db, cfg, newTestDeliveryQueue(db), nil)
+	as.now = clock.Now
+	return as
+}
+
+var otpInBodyPattern = regexp.MustCompile(`sign-in code is (\d+)`)
+
+// sentOTP returns the code carried by the queued delivery reference
+func sentOTP(t *testing.T, db *gorm.DB, reference string) string {
+	t.Helper()
+	var delivery models.Delivery
+	if err := db.First(&delivery, "id = ?", reference).Error; err != nil {
+		t.Fatalf("no delivery %s: %v", reference, err)
+	}
+	m := otpInBodyPattern.FindStringSubmatch(delivery.Body)
+	if m == nil {
+		t.Fatalf("delivery body carries no code: %q", delivery.Body)
+	}
+	return m[1]
+}
+
+// fakeProvider is an AIProvider returning canned results and counting the
+// calls made to it
+type fakeProvider struct {
+	scan    map[string]string
+	scanErr error
+	reply   string
+	chatErr error
+	// summary and summaryErr replace the default summary when either is set
+	summary    *HealthSummary
+	summaryErr error
+
+	scans      int
+	chats      int
+	scanned    []byte                // image of the last scan
+	sections   []string              // of the last summary request
+	summarized []models.HealthRecord // of the last summary request
+}
+
+func (fp *fakeProvider) Name() string { return "fake" }
+
+func (fp *fakeProvider) ScanPrescription(ctx context.Context, imageData []byte) (map[string]string, error) {
+	fp.scans++
+	fp.scanned = imageData
+	if fp.scanErr != nil {
+		return nil, fp.scanErr
+	}
+	data := make(map[string]string, len(fp.scan))
+	for key, value := range fp.scan {
+		data[key] = value
+	}
+	return data, nil
+}
+
+func (fp *fakeProvider) SummarizeHealth(ctx context.Context, records []models.HealthRecord, days int, sections []string) (*HealthSummary, error) {
+	fp.sections = sections
+	fp.summarized = records
+	if fp.summary != nil || fp.summaryErr != nil {
+		return fp.summary, fp.summaryErr
+	}
+	return &HealthSummary{Summary: fmt.Sprintf("%d records", len(records))}, nil
+}
+
+func (fp *fakeProvider) DoctorChat(ctx context.Context, message string) (string, error) {
+	fp.chats++
+	return fp.reply, fp.chatErr
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"strings"
+
+	"github.com/clarity/backend/config"
+)
+
+// Enhancement steps, run in this order whatever order they are configured in
+const (
+	EnhanceDenoise   = "denoise"   // 3x3 median filter, removes sensor speckle
+	EnhanceSharpen   = "sharpen"   // unsharp mask, crisps the strokes of the text
+	EnhanceThreshold = "threshold" // adaptive threshold, black text on white
+)
+
+var enhanceSteps = map[string]func(*image.Gray) *image.Gray{
+	EnhanceDenoise:   medianFilter,
+	EnhanceSharpen:   unsharpMask,
+	EnhanceThreshold: adaptiveThreshold,
+}
+
+// ImageEnhancer prepares a scanned image for text recognition. Enhance
+// returns the image to send to the provider, which may be imageData itself.
+type ImageEnhancer interface {
+	Name() string
+	Enhance(imageData []byte) ([]byte, error)
+}
+
+// NewImageEnhancer returns the enhancer selected in config. It falls back
+// to sending images as taken when the enhancer is unknown.
+func NewImageEnhancer(cfg *config.AIConfig) ImageEnhancer {
+	switch cfg.ImageEnhancer {
+	case "basic":
+		return newBasicEnhancer(cfg.EnhanceSteps, cfg.EnhanceMaxSharpness)
+	case "", "none":
+	default:
+		log.Printf("Warning: unknown image enhancer %q, scanning images as taken", cfg.ImageEnhancer)
+	}
+	return noEnhancer{}
+}
+
+// noEnhancer sends images as taken
+type noEnhancer struct{}
+
+func (noEnhancer) Name() string { return "none" }
+
+func (noEnhancer) Enhance(imageData []byte) ([]byte, error) { return imageData, nil }
+
+// basicEnhancer converts an image to grayscale, runs the configured steps
+// over it, and re-encodes it as PNG. Images already at least maxSharpness
+// sharp are returned untouched, so a clear photo is never made worse.
+type basicEnhancer struct {
+	steps        []func(*image.Gray) *image.Gray
+	maxSharpness float64
+}
+
+func newBasicEnhancer(steps []string, maxSharpness int) *basicEnhancer {
+	enabled := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		step = strings.TrimSpace(step)
+		if _, ok := enhanceSteps[step]; !ok {
+			log.Printf("Warning: unknown image enhancement step %q ignored", step)
+			continue
+		}
+		enabled[step] = true
+	}
+
+	e := &basicEnhancer{maxSharpness: float64(maxSharpness)}
+	for _, step := range []string{EnhanceDenoise, EnhanceSharpen, EnhanceThreshold} {
+		if enabled[step] {
+			e.steps = append(e.steps, enhanceSteps[step])
+		}
+	}
+	return e
+}
+
+func (e *basicEnhancer) Name() string { return "basic" }
+
+func (e *basicEnhancer) Enhance(imageData []byte) ([]byte, error) {
+	if len(e.steps) == 0 {
+		return imageData, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if e.maxSharpness > 0 && measureImageQuality(img).Sharpness >= e.maxSharpness {
+		return imageData, nil
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	for _, step := range e.steps {
+		gray = step(gray)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gray); err != nil {
+		return nil, fmt.Errorf("failed to encode enhanced image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// grayAt returns the pixel at x, y, clamping coordinates to the edges
+func grayAt(img *image.Gray, x, y int) uint8 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x = min(max(x, 0), w-1)
+	y = min(max(y, 0), h-1)
+	return img.Pix[y*img.Stride+x]
+}
+
+// medianFilter replaces each pixel by the median of its 3x3 neighbourhood
+func medianFilter(img *image.Gray) *image.Gray {
+	out := image.NewGray(img.Rect)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	var window [9]uint8
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					v := grayAt(img, x+dx, y+dy)
+					// Insertion sort; the window is tiny
+					i := n
+					for ; i > 0 && window[i-1] > v; i-- {
+						window[i] = window[i-1]
+					}
+					window[i] = v
+					n++
+				}
+			}
+			out.Pix[y*out.Stride+x] = window[4]
+		}
+	}
+	return out
+}
+
+// unsharpMask adds back the difference between each pixel and the mean of
+// its 3x3 neighbourhood, steepening edges
+func unsharpMask(img *image.Gray) *image.Gray {
+	out := image.NewGray(img.Rect)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum int
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					sum += int(grayAt(img, x+dx, y+dy))
+				}
+			}
+			p := int(img.Pix[y*img.Stride+x])
+			out.Pix[y*out.Stride+x] = uint8(min(max(2*p-sum/9, 0), 255))
+		}
+	}
+	return out
+}
+
+// thresholdOffset is how far, in percent, a pixel must fall below the mean
+// of its neighbourhood to count as ink
+const thresholdOffset = 10
+
+// adaptiveThreshold turns each pixel black or white by comparing it with
+// the mean of a window around it, so shadows and uneven light across the
+// page do not swallow the text as a single global threshold would
+func adaptiveThreshold(img *image.Gray) *image.Gray {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	half := max(min(w, h)/32, 7)
+
+	// Summed-area table, one row and column larger than the image
+	integral := make([]uint64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row uint64
+		for x := 0; x < w; x++ {
+			row += uint64(img.Pix[y*img.Stride+x])
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + row
+		}
+	}
+
+	out := image.NewGray(img.Rect)
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-half, 0), min(y+half+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-half, 0), min(x+half+1, w)
+			count := uint64((x1 - x0) * (y1 - y0))
+			sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] - integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			value := uint8(255)
+			if uint64(img.Pix[y*img.Stride+x])*count*100 < sum*(100-thresholdOffset) {
+				value = 0
+			}
+			out.Pix[y*out.Stride+x] = value
+		}
+	}
+	return out
+}
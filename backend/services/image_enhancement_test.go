@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/clarity/backend/config"
+)
+
+// speckle darkens every pixel at a multiple of every in both directions,
+// the isolated noise a phone sensor adds in poor light
+func speckle(img *image.Gray, every int) *image.Gray {
+	out := image.NewGray(img.Rect)
+	copy(out.Pix, img.Pix)
+	for y := 0; y < img.Rect.Dy(); y += every {
+		for x := 0; x < img.Rect.Dx(); x += every {
+			out.Pix[y*out.Stride+x] = 0
+		}
+	}
+	return out
+}
+
+// decodeGray decodes an enhanced image, which must be a grayscale PNG
+func decodeGray(t *testing.T, data []byte) *image.Gray {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("enhanced image is not a PNG: %v", err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("enhanced image is %T, want grayscale", img)
+	}
+	return gray
+}
+
+func TestNewImageEnhancer(t *testing.T) {
+	for enhancer, want := range map[string]string{"": "none", "none": "none", "basic": "basic", "magic": "none"} {
+		if got := NewImageEnhancer(&config.AIConfig{ImageEnhancer: enhancer}).Name(); got != want {
+			t.Errorf("enhancer %q = %s, want %s", enhancer, got, want)
+		}
+	}
+	photo := encodePNG(t, labelImage(64, 64))
+	out, err := NewImageEnhancer(&config.AIConfig{}).Enhance(photo)
+	if err != nil || !bytes.Equal(out, photo) {
+		t.Errorf("no enhancer changed the image: %v", err)
+	}
+}
+
+func TestEnhanceBlurryImage(t *testing.T) {
+	blurry := boxBlur(labelImage(320, 240), 2)
+	before := measureImageQuality(blurry).Sharpness
+	e := newBasicEnhancer([]string{EnhanceDenoise, EnhanceSharpen}, 0)
+
+	out, err := e.Enhance(encodePNG(t, blurry))
+	if err != nil {
+		t.Fatalf("Enhance: %v", err)
+	}
+	enhanced := decodeGray(t, out)
+	if enhanced.Rect.Dx() != 320 || enhanced.Rect.Dy() != 240 {
+		t.Fatalf("enhanced image is %v, want 320x240", enhanced.Rect)
+	}
+	if after := measureImageQuality(enhanced).Sharpness; after <= before {
+		t.Errorf("sharpness %.0f after enhancement, want more than the %.0f before", after, before)
+	}
+}
+
+func TestDenoiseRemovesSpeckle(t *testing.T) {
+	clean := labelImage(120, 120)
+	e := newBasicEnhancer([]string{EnhanceDenoise}, 0)
+	out, err := e.Enhance(encodePNG(t, speckle(clean, 7)))
+	if err != nil {
+		t.Fatalf("Enhance: %v", err)
+	}
+	denoised := decodeGray(t, out)
+
+	// Speckle on open paper is gone and the text survives
+	paper := func(x, y int) bool {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if grayAt(clean, x+dx, y+dy) != 225 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	for y := 0; y < 120; y += 7 {
+		for x := 0; x < 120; x += 7 {
+			if paper(x, y) && denoised.GrayAt(x, y).Y != 225 {
+				t.Fatalf("speckle at %d,%d left after denoising", x, y)
+			}
+		}
+	}
+	if denoised.GrayAt(40, 18).Y != 30 {
+		t.Errorf("text pixel = %d after denoising, want 30", denoised.GrayAt(40, 18).Y)
+	}
+}
+
+func TestThresholdGivesBlackTextOnWhite(t *testing.T) {
+	// A shadow darkening the right half is not taken for ink
+	img := labelImage(200, 120)
+	for y := 0; y < 120; y++ {
+		for x := 100; x < 200; x++ {
+			img.Pix[y*img.Stride+x] -= 20
+		}
+	}
+	e := newBasicEnhancer([]string{EnhanceThreshold}, 0)
+	out, err := e.Enhance(encodePNG(t, img))
+	if err != nil {
+		t.Fatalf("Enhance: %v", err)
+	}
+	binary := decodeGray(t, out)
+	for _, v := range binary.Pix {
+		if v != 0 && v != 255 {
+			t.Fatalf("thresholded image has gray level %d", v)
+		}
+	}
+	for _, p := range []image.Point{{40, 18}, {160, 18}} {
+		if binary.GrayAt(p.X, p.Y).Y != 0 {
+			t.Errorf("text at %v is white after thresholding", p)
+		}
+	}
+	for _, p := range []image.Point{{40, 6}, {160, 6}} {
+		if binary.GrayAt(p.X, p.Y).Y != 255 {
+			t.Errorf("paper at %v is black after thresholding", p)
+		}
+	}
+}
+
+func TestEnhanceLeavesClearImagesAlone(t *testing.T) {
+	clear := encodePNG(t, labelImage(320, 240))
+	if sharpness := measureImageQuality(labelImage(320, 240)).Sharpness; sharpness < 500 {
+		t.Fatalf("test image sharpness %.0f, want a clear image", sharpness)
+	}
+
+	e := newBasicEnhancer([]string{EnhanceDenoise, EnhanceSharpen, EnhanceThreshold}, 500)
+	out, err := e.Enhance(clear)
+	if err != nil || !bytes.Equal(out, clear) {
+		t.Errorf("a clear image was re-encoded: %v", err)
+	}
+
+	// Without steps nothing is done, whatever the image
+	blurry := encodePNG(t, boxBlur(labelImage(320, 240), 2))
+	out, err = newBasicEnhancer([]string{"unknown"}, 0).Enhance(blurry)
+	if err != nil || !bytes.Equal(out, blurry) {
+		t.Errorf("an enhancer without steps changed the image: %v", err)
+	}
+}
+
+func TestScanSendsTheEnhancedImage(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+	blurry := encodePNG(t, boxBlur(labelImage(320, 240), 2))
+
+	as := newTestAIService(t, db, &config.AIConfig{ImageEnhancer: "basic", EnhanceSteps: []string{EnhanceSharpen}})
+	provider := &fakeProvider{scan: map[string]string{"medication": "Amoxicillin"}}
+	as.provider = provider
+	if _, err := as.ScanPrescription(ctx, "user-1", blurry, ScanOptions{Force: true}); err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if bytes.Equal(provider.scanned, blurry) {
+		t.Error("the provider was sent the image as taken")
+	}
+	decodeGray(t, provider.scanned)
+
+	// An image the enhancer cannot read is scanned as taken
+	if _, err := as.ScanPrescription(ctx, "user-1", []byte("not an image"), ScanOptions{Force: true}); err != nil {
+		t.Fatalf("ScanPrescription of an undecodable image: %v", err)
+	}
+	if string(provider.scanned) != "not an image" {
+		t.Errorf("undecodable image sent as %q, want it as taken", provider.scanned)
+	}
+
+	// Disabled, the image goes as taken
+	as = newTestAIService(t, db, &config.AIConfig{ImageEnhancer: "none"})
+	as.provider = provider
+	if _, err := as.ScanPrescription(ctx, "user-1", blurry, ScanOptions{Force: true}); err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if !bytes.Equal(provider.scanned, blurry) {
+		t.Error("the image was changed with enhancement disabled")
+	}
+}
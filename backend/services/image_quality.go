@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	"github.com/clarity/backend/config"
+)
+
+// qualitySampleSize is the longest side images are sampled down to before
+// measuring, so large phone photos are checked in bounded time
+const qualitySampleSize = 1024
+
+// ImageQuality holds the measurements taken by the scan pre-check
+type ImageQuality struct {
+	Width      int
+	Height     int
+	Sharpness  float64 // variance of the Laplacian; low means blurry
+	Brightness float64 // mean luminance, 0-255
+}
+
+// ImageQualityError reports an image that failed the pre-check along with
+// guidance the app can show when asking the user to retake the photo
+type ImageQualityError struct {
+	Quality  ImageQuality
+	Problems []string
+}
+
+func (e *ImageQualityError) Error() string {
+	return "image quality too low: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ImageQualityError) Is(target error) bool {
+	return target == ErrImageQuality
+}
+
+// checkImageQuality decodes a scanned image and rejects it when it is
+// smaller, blurrier, darker, or brighter than the configured thresholds.
+// A zero threshold disables that check.
+func checkImageQuality(imageData []byte, cfg *config.AIConfig) (*ImageQuality, error) {
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: image must be a JPEG or PNG", ErrInvalidArgument)
+	}
+
+	quality := measureImageQuality(img)
+
+	var problems []string
+	if cfg.MinImageDimension > 0 && min(quality.Width, quality.Height) < cfg.MinImageDimension {
+		problems = append(problems, fmt.Sprintf("image resolution too low, move closer or use a higher camera resolution (%dx%d)", quality.Width, quality.Height))
+	}
+	if cfg.MinImageSharpness > 0 && quality.Sharpness < float64(cfg.MinImageSharpness) {
+		problems = append(problems, "image too blurry, hold the camera steady and tap to focus")
+	}
+	if cfg.MinImageBrightness > 0 && quality.Brightness < float64(cfg.MinImageBrightness) {
+		problems = append(problems, "image too dark, move to better light or turn on the flash")
+	}
+	if cfg.MaxImageBrightness > 0 && quality.Brightness > float64(cfg.MaxImageBrightness) {
+		problems = append(problems, "image too bright, avoid glare and direct light on the page")
+	}
+
+	if len(problems) > 0 {
+		return &quality, &ImageQualityError{Quality: quality, Problems: problems}
+	}
+	return &quality, nil
+}
+
+// measureImageQuality computes resolution, mean brightness, and variance of
+// the Laplacian over a grayscale sample of the image
+func measureImageQuality(img image.Image) ImageQuality {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	step := 1
+	if longest := max(width, height); longest > qualitySampleSize {
+		step = (longest + qualitySampleSize - 1) / qualitySampleSize
+	}
+	sw, sh := width/step, height/step
+
+	gray := make([]float64, sw*sh)
+	var total float64
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x*step, bounds.Min.Y+y*step).RGBA()
+			// ITU-R BT.601 luma, scaled from 16-bit channels to 0-255
+			lum := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			gray[y*sw+x] = lum
+			total += lum
+		}
+	}
+
+	quality := ImageQuality{Width: width, Height: height}
+	if len(gray) > 0 {
+		quality.Brightness = total / float64(len(gray))
+	}
+	if sw < 3 || sh < 3 {
+		return quality
+	}
+
+	// 4-neighbour Laplacian over the interior pixels
+	var sum, sumSq float64
+	n := float64((sw - 2) * (sh - 2))
+	for y := 1; y < sh-1; y++ {
+		for x := 1; x < sw-1; x++ {
+			i := y*sw + x
+			lap := gray[i-sw] + gray[i+sw] + gray[i-1] + gray[i+1] - 4*gray[i]
+			sum += lap
+			sumSq += lap * lap
+		}
+	}
+	mean := sum / n
+	quality.Sharpness = sumSq/n - mean*mean
+
+	return quality
+}
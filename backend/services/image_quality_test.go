@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+)
+
+// Fixture images stand in for photos of a printed label: dark text lines
+// on a light page, then blurred, underexposed, or shrunk the way bad
+// phone photos are.
+
+// labelImage draws lines of dark "text" strokes on a light page
+func labelImage(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := uint8(225)
+			if (y/12)%2 == 1 && (x/4)%3 != 2 && x > 20 && x < width-20 {
+				c = 30
+			}
+			img.SetGray(x, y, color.Gray{Y: c})
+		}
+	}
+	return img
+}
+
+// boxBlur averages each pixel with its neighbours within radius
+func boxBlur(src *image.Gray, radius int) *image.Gray {
+	bounds := src.Bounds()
+	dst := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sum, n := 0, 0
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if p := (image.Point{X: x + dx, Y: y + dy}); p.In(bounds) {
+						sum += int(src.GrayAt(p.X, p.Y).Y)
+						n++
+					}
+				}
+			}
+			dst.SetGray(x, y, color.Gray{Y: uint8(sum / n)})
+		}
+	}
+	return dst
+}
+
+// exposed scales every pixel by factor, clamped to white
+func exposed(src *image.Gray, factor float64) *image.Gray {
+	dst := image.NewGray(src.Bounds())
+	for i, v := range src.Pix {
+		dst.Pix[i] = uint8(min(float64(v)*factor, 255))
+	}
+	return dst
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testQualityConfig holds the default pre-check thresholds
+var testQualityConfig = &config.AIConfig{
+	MinImageDimension:  480,
+	MinImageSharpness:  50,
+	MinImageBrightness: 50,
+	MaxImageBrightness: 240,
+}
+
+func TestCheckImageQuality(t *testing.T) {
+	sharp := labelImage(640, 480)
+	tests := []struct {
+		name     string
+		image    []byte
+		problems []string // substrings of the guidance, nil when it passes
+	}{
+		{"sharp PNG", encodePNG(t, sharp), nil},
+		{"sharp JPEG", encodeJPEG(t, sharp), nil},
+		{"blurred", encodePNG(t, boxBlur(sharp, 6)), []string{"too blurry"}},
+		{"underexposed", encodePNG(t, exposed(sharp, 0.15)), []string{"too dark"}},
+		{"overexposed", encodePNG(t, exposed(sharp, 8)), []string{"too bright"}},
+		{"too small", encodePNG(t, labelImage(320, 240)), []string{"resolution too low"}},
+		{"small, blurred and dark", encodePNG(t, exposed(boxBlur(labelImage(320, 240), 6), 0.15)), []string{"resolution too low", "too blurry", "too dark"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quality, err := checkImageQuality(tt.image, testQualityConfig)
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("checkImageQuality() = %v, want it to pass", err)
+				}
+				return
+			}
+
+			var qualityErr *ImageQualityError
+			if !errors.As(err, &qualityErr) || !errors.Is(err, ErrImageQuality) {
+				t.Fatalf("checkImageQuality() error = %v, want an ImageQualityError", err)
+			}
+			if len(qualityErr.Problems) != len(tt.problems) {
+				t.Fatalf("problems = %q, want %d", qualityErr.Problems, len(tt.problems))
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(qualityErr.Problems[i], want) {
+					t.Errorf("problem %d = %q, want it to mention %q", i, qualityErr.Problems[i], want)
+				}
+			}
+			if qualityErr.Quality != *quality {
+				t.Errorf("error carries %+v, measured %+v", qualityErr.Quality, *quality)
+			}
+		})
+	}
+}
+
+func TestMeasureImageQuality(t *testing.T) {
+	sharp := measureImageQuality(labelImage(640, 480))
+	blurred := measureImageQuality(boxBlur(labelImage(640, 480), 6))
+	dark := measureImageQuality(exposed(labelImage(640, 480), 0.15))
+
+	if sharp.Width != 640 || sharp.Height != 480 {
+		t.Errorf("measured %dx%d, want 640x480", sharp.Width, sharp.Height)
+	}
+	if blurred.Sharpness >= sharp.Sharpness/10 {
+		t.Errorf("blurred sharpness %.1f is not far below sharp %.1f", blurred.Sharpness, sharp.Sharpness)
+	}
+	if dark.Brightness >= sharp.Brightness/4 {
+		t.Errorf("underexposed brightness %.1f is not far below %.1f", dark.Brightness, sharp.Brightness)
+	}
+
+	// Large photos are sampled down but keep their real size and roughly
+	// their brightness
+	large := measureImageQuality(labelImage(3000, 2000))
+	if large.Width != 3000 || large.Height != 2000 {
+		t.Errorf("large image measured as %dx%d", large.Width, large.Height)
+	}
+	if diff := large.Brightness - sharp.Brightness; diff > 15 || diff < -15 {
+		t.Errorf("sampled brightness %.1f differs from full %.1f", large.Brightness, sharp.Brightness)
+	}
+}
+
+func TestCheckImageQualityRejectsUndecodable(t *testing.T) {
+	if _, err := checkImageQuality([]byte("not an image"), testQualityConfig); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("checkImageQuality() error = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestScanPrescriptionQualityPreCheck(t *testing.T) {
+	blurred := encodePNG(t, boxBlur(labelImage(640, 480), 6))
+	tests := []struct {
+		name      string
+		image     []byte
+		force     bool
+		want      error
+		wantScans int
+	}{
+		{"sharp", encodePNG(t, labelImage(640, 480)), false, nil, 1},
+		{"blurred", blurred, false, ErrImageQuality, 0},
+		{"blurred with force", blurred, true, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			cfg := *testQualityConfig
+			as := newTestAIService(t, db, &cfg)
+			provider := &fakeProvider{scan: map[string]string{"medication": "Amoxicillin"}}
+			as.provider = provider
+
+			_, err := as.ScanPrescription(context.Background(), "user-1", tt.image, ScanOptions{Force: tt.force})
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("ScanPrescription() error = %v, want %v", err, tt.want)
+			}
+			if provider.scans != tt.wantScans {
+				t.Errorf("provider called %d times, want %d", provider.scans, tt.wantScans)
+			}
+		})
+	}
+}
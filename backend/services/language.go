@@ -0,0 +1,157 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// LanguageUnknown is stored when a record is too short or too mixed to
+// tell its language
+const LanguageUnknown = "unknown"
+
+// languageMetadataKey is the record metadata key holding the detected
+// ISO 639-1 language code
+const languageMetadataKey = "language"
+
+const (
+	// minLanguageWords is the fewest words a Latin-script text needs before
+	// its stopwords say anything
+	minLanguageWords = 3
+	// minLanguageHits is the fewest stopwords the winning language needs
+	minLanguageHits = 2
+	// minScriptRunes is the fewest letters of a non-Latin script needed to
+	// name the language from the script alone
+	minScriptRunes = 4
+)
+
+// languageStopwords are common function words and dosing words, which say
+// more about a language than medical terms shared across languages
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "in", "is", "for", "with", "on", "was", "my", "have", "after", "at", "it", "this", "take", "twice", "daily", "every", "pain"},
+	"es": {"el", "la", "los", "las", "de", "del", "y", "en", "que", "con", "por", "para", "una", "es", "tomar", "cada", "dolor", "mi", "al"},
+	"fr": {"le", "la", "les", "de", "des", "du", "et", "en", "que", "avec", "pour", "une", "est", "par", "fois", "jour", "douleur", "mon", "au"},
+	"de": {"der", "die", "das", "und", "ist", "mit", "ein", "eine", "nicht", "zu", "den", "von", "für", "täglich", "nach", "schmerzen", "mein", "auf"},
+	"it": {"il", "lo", "la", "gli", "di", "e", "che", "con", "per", "una", "è", "del", "della", "ogni", "giorno", "dolore", "mio", "al"},
+	"pt": {"o", "os", "as", "de", "do", "da", "e", "em", "que", "com", "por", "para", "uma", "é", "não", "cada", "dor", "meu", "ao"},
+	"nl": {"de", "het", "een", "en", "van", "met", "is", "niet", "voor", "op", "dat", "per", "dag", "pijn", "mijn", "na"},
+}
+
+var stopwordIndex = func() map[string][]string {
+	index := make(map[string][]string)
+	for language, words := range languageStopwords {
+		for _, word := range words {
+			index[word] = append(index[word], language)
+		}
+	}
+	return index
+}()
+
+// scriptLanguages names the language written in a script used by only one
+// language we support
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// detectLanguage guesses the ISO 639-1 language of text. Non-Latin scripts
+// are identified by script; Latin text by which language's stopwords it
+// uses most. Text that is too short, or ties between languages, gives
+// LanguageUnknown rather than a guess.
+func detectLanguage(text string) string {
+	if language := detectScript(text); language != "" {
+		return language
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if len(words) < minLanguageWords {
+		return LanguageUnknown
+	}
+
+	hits := make(map[string]int)
+	for _, word := range words {
+		for _, language := range stopwordIndex[word] {
+			hits[language]++
+		}
+	}
+
+	best, bestHits, runnerUp := LanguageUnknown, 0, 0
+	for language, n := range hits {
+		switch {
+		case n > bestHits:
+			best, bestHits, runnerUp = language, n, bestHits
+		case n > runnerUp:
+			runnerUp = n
+		}
+	}
+	if bestHits < minLanguageHits || bestHits == runnerUp {
+		return LanguageUnknown
+	}
+	return best
+}
+
+// detectScript names the language of text written mostly in a non-Latin
+// script, or returns "" for Latin text
+func detectScript(text string) string {
+	counts := make(map[string]int)
+	latin, han, kana := 0, 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		default:
+			for _, s := range scriptLanguages {
+				if unicode.Is(s.script, r) {
+					counts[s.language]++
+					break
+				}
+			}
+		}
+	}
+
+	// Japanese mixes kana with Han characters; Han alone is Chinese
+	if kana > 0 && kana+han >= minScriptRunes && kana+han > latin {
+		return "ja"
+	}
+	if han >= minScriptRunes && han > latin {
+		return "zh"
+	}
+	for _, s := range scriptLanguages {
+		if n := counts[s.language]; n >= minScriptRunes && n > latin {
+			return s.language
+		}
+	}
+	if latin == 0 && len(counts) > 0 {
+		return LanguageUnknown
+	}
+	return ""
+}
+
+// recordLanguage returns the language detected for a record, or
+// LanguageUnknown if it has none
+func recordLanguage(metadata map[string]string) string {
+	if language := metadata[languageMetadataKey]; language != "" {
+		return language
+	}
+	return LanguageUnknown
+}
+
+// needsTranslation reports whether a record in language should be
+// translated for a reader of target. Records of unknown language are left
+// as they are.
+func needsTranslation(language, target string) bool {
+	return language != LanguageUnknown && target != "" && language != target
+}
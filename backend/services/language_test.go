@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Take one tablet twice daily with food for the pain", "en"},
+		{"Tomar una pastilla cada ocho horas para el dolor de cabeza", "es"},
+		{"Prendre un comprimé deux fois par jour pour la douleur", "fr"},
+		{"Eine Tablette täglich nach dem Essen gegen die Schmerzen", "de"},
+		{"Prendere una compressa ogni giorno per il dolore alla schiena", "it"},
+		{"Tomar um comprimido para a dor nas costas, não exceder a dose", "pt"},
+		{"Een tablet per dag met water tegen de pijn in het been", "nl"},
+		{"Принимать по одной таблетке два раза в день", "ru"},
+		{"一日二回、食後に服用してください", "ja"},
+		{"每日两次，饭后服用", "zh"},
+		{"하루에 두 번 식후에 복용하십시오", "ko"},
+		{"تناول قرصًا واحدًا مرتين يوميًا", "ar"},
+		{"Λαμβάνετε ένα δισκίο δύο φορές την ημέρα", "el"},
+
+		// Too little to go on
+		{"", LanguageUnknown},
+		{"Amoxicillin", LanguageUnknown},
+		{"Ibuprofen 400mg", LanguageUnknown},
+		{"HbA1c 6.1 LDL 120", LanguageUnknown},
+		{"血糖", LanguageUnknown},
+		// Words shared by several languages, with nothing to break the tie
+		{"de la en", LanguageUnknown},
+		// Drug names with a single stopword
+		{"Metformin and Lisinopril refill", LanguageUnknown},
+	}
+	for _, tt := range tests {
+		if got := detectLanguage(tt.text); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+// recordMetadata decodes a record's stored metadata
+func recordMetadata(t *testing.T, record *models.HealthRecord) map[string]string {
+	t.Helper()
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(record.Metadata), &metadata); err != nil {
+		t.Fatalf("decode metadata: %v", err)
+	}
+	return metadata
+}
+
+func TestRecordLanguageStoredOnCreateAndUpdate(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, &config.RecordsConfig{DetectLanguage: true})
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+
+	record, err := hrs.CreateRecord(ctx, "user-1", "prescription", "Ibuprofeno", "Tomar una pastilla cada ocho horas para el dolor", map[string]string{"dosage": "400mg"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if got := recordMetadata(t, record); got[languageMetadataKey] != "es" || got["dosage"] != "400mg" {
+		t.Errorf("metadata = %v, want language es alongside the caller's keys", got)
+	}
+
+	short, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "HbA1c", "6.1%", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if got := recordMetadata(t, short)[languageMetadataKey]; got != LanguageUnknown {
+		t.Errorf("short record language = %q, want %s", got, LanguageUnknown)
+	}
+
+	// An edit into another language is detected again
+	updated, err := hrs.UpdateRecord(ctx, "user-1", record.ID, "Ibuprofen", "Take one tablet twice daily for the pain", map[string]string{"dosage": "400mg"})
+	if err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if got := recordMetadata(t, updated)[languageMetadataKey]; got != "en" {
+		t.Errorf("language after an English edit = %q, want en", got)
+	}
+
+	// A language the caller sets is kept
+	tagged, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Notes", "Take one tablet twice daily for the pain", map[string]string{languageMetadataKey: "fr"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if got := recordMetadata(t, tagged)[languageMetadataKey]; got != "fr" {
+		t.Errorf("caller's language replaced with %q", got)
+	}
+}
+
+func TestRecordLanguageDetectionDisabled(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, &config.RecordsConfig{DetectLanguage: false})
+	createUser(t, db, "user-1")
+
+	record, err := hrs.CreateRecord(context.Background(), "user-1", "lab_result", "Notes", "Take one tablet twice daily for the pain", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if _, ok := recordMetadata(t, record)[languageMetadataKey]; ok {
+		t.Error("language stored with detection disabled")
+	}
+}
+
+func TestSummaryRecordsTextMarksOtherLanguages(t *testing.T) {
+	created := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	records := []models.HealthRecord{
+		{RecordType: "note", Title: "Dolor", Description: "Dolor de cabeza", Metadata: `{"language":"es"}`, CreatedAt: created},
+		{RecordType: "note", Title: "Headache", Description: "Since Monday", Metadata: `{"language":"en"}`, CreatedAt: created},
+		{RecordType: "lab_result", Title: "HbA1c", Description: "6.1%", Metadata: `{"language":"unknown"}`, CreatedAt: created},
+		{RecordType: "note", Title: "Old", Description: "No language", CreatedAt: created},
+	}
+
+	text := SummaryRecordsText(records, "en")
+	if !strings.Contains(text, "- [note] Dolor: Dolor de cabeza (Date: 2026-10-01) (Language: es)\n") {
+		t.Errorf("Spanish record not marked:\n%s", text)
+	}
+	for _, title := range []string{"Headache", "HbA1c", "Old"} {
+		line := text[strings.Index(text, title):]
+		if line = line[:strings.Index(line, "\n")]; strings.Contains(line, "Language:") {
+			t.Errorf("%s marked for translation: %s", title, line)
+		}
+	}
+	if !strings.Contains(text, `answer in "en"`) {
+		t.Errorf("no translation instruction:\n%s", text)
+	}
+
+	if text := SummaryRecordsText(records[1:], "en"); strings.Contains(text, "Translate") {
+		t.Errorf("translation asked for with nothing to translate:\n%s", text)
+	}
+	if text := SummaryRecordsText(records, ""); strings.Contains(text, "Language:") {
+		t.Errorf("records marked without a reader language:\n%s", text)
+	}
+}
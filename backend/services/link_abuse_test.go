@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/events"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// newAuditedExportService returns an ExportService whose link events are
+// audited, as in production, since accessors are read back from the audit
+// log
+func newAuditedExportService(db *gorm.DB, cfg *config.ExportConfig) *ExportService {
+	bus := events.NewBus()
+	audit := NewAuditService(db)
+	events.Subscribe(bus, "audit", audit.OnExportLinkAccessed)
+	events.Subscribe(bus, "audit", audit.OnExportLinkRevoked)
+	cfg.LinkDefaultTTL, cfg.LinkMaxTTL = 3600, 86400
+	return NewExportService(db, cfg, "https://share.example.com/", bus, newTestDeliveryQueue(db))
+}
+
+func newAbuseTestLink(t *testing.T, db *gorm.DB, es *ExportService) (*CreatedExportLink, string) {
+	t.Helper()
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	created, err := es.CreateExportLink(context.Background(), "user-1", []string{"rec-1"}, time.Hour, false, "", false)
+	if err != nil {
+		t.Fatalf("CreateExportLink: %v", err)
+	}
+	return created, linkToken(t, created)
+}
+
+func TestClinicianOpeningALinkAFewTimes(t *testing.T) {
+	db := newTestDB(t)
+	es := newAuditedExportService(db, &config.ExportConfig{MaxLinkAccesses: 10, SuspiciousAccessors: 3, SuspiciousWindow: 3600})
+	_, token := newAbuseTestLink(t, db, es)
+	ctx := context.Background()
+
+	// From the clinic desk, then the clinic wifi on a phone
+	for i, accessor := range []string{"203.0.113.10", "203.0.113.10", "203.0.113.10", "198.51.100.4", "203.0.113.10"} {
+		if _, err := es.OpenExportLink(ctx, token, "", accessor); err != nil {
+			t.Fatalf("open %d from %s: %v", i+1, accessor, err)
+		}
+	}
+	var deliveries int64
+	db.Model(&models.Delivery{}).Count(&deliveries)
+	if deliveries != 0 {
+		t.Errorf("%d alerts queued for normal use, want none", deliveries)
+	}
+}
+
+func TestLinkAccessCap(t *testing.T) {
+	db := newTestDB(t)
+	es := newAuditedExportService(db, &config.ExportConfig{MaxLinkAccesses: 3})
+	_, token := newAbuseTestLink(t, db, es)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := es.OpenExportLink(ctx, token, "", "203.0.113.10"); err != nil {
+			t.Fatalf("open %d: %v", i+1, err)
+		}
+	}
+	if _, err := es.OpenExportLink(ctx, token, "", "203.0.113.10"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open past the cap: error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestConcurrentOpensRespectTheAccessCap(t *testing.T) {
+	db := newTestDB(t)
+	es := newAuditedExportService(db, &config.ExportConfig{MaxLinkAccesses: 1})
+	created, token := newAbuseTestLink(t, db, es)
+
+	const opens = 8
+	var wg sync.WaitGroup
+	results := make(chan error, opens)
+	for i := 0; i < opens; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := es.OpenExportLink(context.Background(), token, "", "203.0.113.10")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	opened := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			opened++
+		case !errors.Is(err, ErrNotFound):
+			t.Errorf("open past the cap: error = %v, want %v", err, ErrNotFound)
+		}
+	}
+	if opened != 1 {
+		t.Errorf("the link opened %d times, want once", opened)
+	}
+	var link models.ExportLink
+	if err := db.First(&link, "id = ?", created.Link.ID).Error; err != nil {
+		t.Fatalf("load link: %v", err)
+	}
+	if link.AccessCount != 1 {
+		t.Errorf("access count = %d, want 1", link.AccessCount)
+	}
+}
+
+func TestScrapedLinkIsRevoked(t *testing.T) {
+	db := newTestDB(t)
+	es := newAuditedExportService(db, &config.ExportConfig{SuspiciousAccessors: 3, SuspiciousWindow: 3600})
+	created, token := newAbuseTestLink(t, db, es)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		if _, err := es.OpenExportLink(ctx, token, "", fmt.Sprintf("192.0.2.%d", i)); err != nil {
+			t.Fatalf("open from address %d: %v", i, err)
+		}
+	}
+	if _, err := es.OpenExportLink(ctx, token, "", "192.0.2.4"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("open from a fourth address: error = %v, want %v", err, ErrNotFound)
+	}
+
+	// The link stays off, even for an address that opened it before
+	if _, err := es.OpenExportLink(ctx, token, "", "192.0.2.1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open after revocation: error = %v, want %v", err, ErrNotFound)
+	}
+	var link models.ExportLink
+	if err := db.First(&link, "id = ?", created.Link.ID).Error; err != nil {
+		t.Fatalf("load link: %v", err)
+	}
+	if link.RevokedAt == nil || link.Snapshot != nil {
+		t.Errorf("link revoked at %v with %d snapshot bytes, want revoked and wiped", link.RevokedAt, len(link.Snapshot))
+	}
+
+	var revocations int64
+	db.Model(&models.AuditLog{}).Where("action = ? AND target_id = ?", AuditActionExportLinkRevoke, link.ID).Count(&revocations)
+	if revocations != 1 {
+		t.Errorf("%d revocations audited, want 1", revocations)
+	}
+	var alerts []models.Delivery
+	db.Where("channel = ?", models.DeliveryChannelPush).Find(&alerts)
+	if len(alerts) != 1 || alerts[0].Recipient != "user-1" || alerts[0].Subject != "Share link revoked" {
+		t.Errorf("alerts = %+v, want one to the owner", alerts)
+	}
+}
+
+func TestOldAccessorsFallOutOfTheWindow(t *testing.T) {
+	db := newTestDB(t)
+	es := newAuditedExportService(db, &config.ExportConfig{SuspiciousAccessors: 2, SuspiciousWindow: 3600})
+	_, token := newAbuseTestLink(t, db, es)
+	ctx := context.Background()
+
+	for _, accessor := range []string{"192.0.2.1", "192.0.2.2"} {
+		if _, err := es.OpenExportLink(ctx, token, "", accessor); err != nil {
+			t.Fatalf("open from %s: %v", accessor, err)
+		}
+	}
+	// Those opens were yesterday
+	db.Model(&models.AuditLog{}).Where("action = ?", AuditActionExportLinkAccess).
+		Update("created_at", time.Now().Add(-24*time.Hour))
+
+	for _, accessor := range []string{"192.0.2.3", "192.0.2.4"} {
+		if _, err := es.OpenExportLink(ctx, token, "", accessor); err != nil {
+			t.Errorf("open from %s a day later: %v", accessor, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+)
+
+// signInWithAccess is signIn also returning the access token
+func signInWithAccess(t *testing.T, as *AuthService, email, deviceID string) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	reference, _, err := as.SendOTP(ctx, email)
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	_, accessToken, refreshToken, err := as.VerifyOTP(ctx, email, sentOTP(t, as.db, reference), deviceID)
+	if err != nil {
+		t.Fatalf("VerifyOTP: %v", err)
+	}
+	return accessToken, refreshToken
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+	access, refresh := signInWithAccess(t, as, "out@example.com", "phone-1")
+	_, otherRefresh := signInWithAccess(t, as, "out@example.com", "tablet-1")
+
+	if err := as.Logout(ctx, refresh, access, false); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, _, err := as.RefreshToken(ctx, refresh, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("refresh after logout: error = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := as.ValidateToken(ctx, access); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("access token after logout: error = %v, want ErrUnauthenticated", err)
+	}
+	if err := as.Logout(ctx, refresh, "", false); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("second logout: error = %v, want ErrUnauthenticated", err)
+	}
+	if session := sessionOf(t, as.db, refresh); session.RevokedAt == nil || session.RevokeReason != SessionRevokedLogout {
+		t.Errorf("session after logout = %+v, want revoked by logout", session)
+	}
+
+	// The other device stays signed in
+	if _, _, err := as.RefreshToken(ctx, otherRefresh, "tablet-1"); err != nil {
+		t.Errorf("other device after logout: %v", err)
+	}
+}
+
+func TestLogoutAllSessions(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+	_, refresh := signInWithAccess(t, as, "all@example.com", "phone-1")
+	_, otherRefresh := signInWithAccess(t, as, "all@example.com", "tablet-1")
+	_, someoneElse := signInWithAccess(t, as, "else@example.com", "phone-2")
+
+	if err := as.Logout(ctx, refresh, "", true); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, _, err := as.RefreshToken(ctx, otherRefresh, "tablet-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("other device after signing out everywhere: error = %v, want ErrUnauthenticated", err)
+	}
+	if _, _, err := as.RefreshToken(ctx, someoneElse, "phone-2"); err != nil {
+		t.Errorf("another user's session: %v", err)
+	}
+}
+
+func TestLogoutRejectsAnotherUsersAccessToken(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+	_, refresh := signInWithAccess(t, as, "mine@example.com", "phone-1")
+	theirs, _ := signInWithAccess(t, as, "theirs@example.com", "phone-2")
+
+	if err := as.Logout(ctx, refresh, theirs, false); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("Logout with another user's access token: error = %v, want ErrInvalidArgument", err)
+	}
+	if _, err := as.ValidateToken(ctx, theirs); err != nil {
+		t.Errorf("the other user's access token was revoked: %v", err)
+	}
+	if _, _, err := as.RefreshToken(ctx, refresh, "phone-1"); err != nil {
+		t.Errorf("a refused logout ended the session: %v", err)
+	}
+}
+
+func TestPurgeRevokedTokens(t *testing.T) {
+	as, clock := newSessionTestAuth(t)
+	ctx := context.Background()
+	access, refresh := signInWithAccess(t, as, "purge@example.com", "phone-1")
+	if err := as.Logout(ctx, refresh, access, false); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	revoked := func() int64 {
+		var count int64
+		as.db.Model(&models.RevokedToken{}).Count(&count)
+		return count
+	}
+	if got := revoked(); got != 2 {
+		t.Fatalf("%d revoked tokens stored, want 2", got)
+	}
+
+	if n, err := as.PurgeRevokedTokens(ctx); err != nil || n != 0 {
+		t.Errorf("purge before either expires = %d, %v; want 0", n, err)
+	}
+	// The access token lapses first; its row goes, the refresh token's stays
+	clock.Advance(accessTokenTTL)
+	if n, err := as.PurgeRevokedTokens(ctx); err != nil || n != 1 {
+		t.Errorf("purge after the access token expires = %d, %v; want 1", n, err)
+	}
+	if _, _, err := as.RefreshToken(ctx, refresh, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("revoked refresh token accepted after a purge: %v", err)
+	}
+	clock.Advance(refreshTokenTTL)
+	if n, err := as.PurgeRevokedTokens(ctx); err != nil || n != 1 || revoked() != 0 {
+		t.Errorf("purge after both expire = %d, %v, %d left; want 1 purged and none left", n, err, revoked())
+	}
+}
+
+func TestRevocationOutlivesTheRow(t *testing.T) {
+	// Expiry alone refuses a token whose revocation was purged
+	as, clock := newSessionTestAuth(t)
+	ctx := context.Background()
+	access, refresh := signInWithAccess(t, as, "late@example.com", "phone-1")
+	if err := as.Logout(ctx, refresh, access, false); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	clock.Advance(refreshTokenTTL + time.Second)
+	as.PurgeRevokedTokens(ctx)
+	for _, token := range []string{access, refresh} {
+		if _, err := as.ValidateToken(ctx, token); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("token after its revocation was purged: error = %v, want ErrUnauthenticated", err)
+		}
+	}
+}
+
+func TestLogoutAllSessionsEndsOtherDevicesAccessTokens(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+	_, refresh := signInWithAccess(t, as, "all@example.com", "phone-1")
+	tabletAccess, _ := signInWithAccess(t, as, "all@example.com", "tablet-1")
+	elseAccess, _ := signInWithAccess(t, as, "else@example.com", "phone-2")
+
+	if err := as.Logout(ctx, refresh, "", true); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	// The tablet's access token was never presented, but its session ended
+	if _, err := as.ValidateToken(ctx, tabletAccess); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("other device's access token after signing out everywhere: error = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := as.ValidateToken(ctx, elseAccess); err != nil {
+		t.Errorf("another user's access token: %v", err)
+	}
+}
+
+func TestLogoutRefusesStaleRefreshTokens(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+	_, stale := signInWithAccess(t, as, "stale@example.com", "phone-1")
+	tabletAccess, tablet := signInWithAccess(t, as, "stale@example.com", "tablet-1")
+	_, current, err := as.RefreshToken(ctx, stale, "phone-1")
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+
+	// A token already exchanged cannot sign the user out anywhere
+	if err := as.Logout(ctx, stale, "", true); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Logout everywhere with an exchanged token: error = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := as.ValidateToken(ctx, tabletAccess); err != nil {
+		t.Errorf("a refused logout signed out the tablet: %v", err)
+	}
+	if session := sessionOf(t, as.db, current); session.RevokedAt != nil {
+		t.Errorf("a refused logout ended the session: %+v", session)
+	}
+
+	// nor can one from a session already revoked for reuse
+	if _, _, err := as.RefreshToken(ctx, stale, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("replayed RefreshToken: %v, want ErrUnauthenticated", err)
+	}
+	if err := as.Logout(ctx, current, "", true); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Logout everywhere from a revoked session: error = %v, want ErrUnauthenticated", err)
+	}
+	if _, _, err := as.RefreshToken(ctx, tablet, "tablet-1"); err != nil {
+		t.Errorf("the tablet was signed out by a revoked session: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Medication match kinds reported by MedicationNormalizer
+const (
+	MedicationMatchGeneric   = "generic"
+	MedicationMatchBrand     = "brand"
+	MedicationMatchFuzzy     = "fuzzy"
+	MedicationMatchUnmatched = "unmatched"
+)
+
+// dosageTokenPattern strips strengths and forms ("500mg", "tablet") so only
+// the drug name is compared
+var dosageTokenPattern = regexp.MustCompile(`(?i)\b\d+(\.\d+)?\s*(mg|mcg|g|ml|iu|units?|%)\b|\b(tablets?|tabs?|capsules?|caps?|oral|solution|suspension|er|xr|sr|dr|hcl)\b`)
+
+// MedicationMatch is the result of normalizing a scanned medication name
+type MedicationMatch struct {
+	Raw       string
+	Canonical string // generic name from the dataset, empty when unmatched
+	Kind      string // generic, brand, fuzzy, unmatched
+}
+
+// NeedsReview reports whether a person should confirm the medication name
+func (m MedicationMatch) NeedsReview() bool {
+	return m.Kind == MedicationMatchUnmatched || m.Kind == MedicationMatchFuzzy
+}
+
+// MedicationNormalizer maps brand names, generics, and misspellings onto
+// canonical generic names from a reference dataset
+type MedicationNormalizer struct {
+	canonical map[string]string // normalized name (generic or brand) -> generic
+	brands    map[string]bool   // normalized names that are brands
+}
+
+// LoadMedicationDataset loads a CSV dataset with a header row and
+// "generic,brand1;brand2" rows, reloaded by Refresh when the file changes
+// or refreshInterval passes
+func LoadMedicationDataset(path string, refreshInterval time.Duration) (*ReferenceDataset[*MedicationNormalizer], error) {
+	return LoadReferenceDataset("medication", path, refreshInterval, parseMedicationDataset)
+}
+
+func parseMedicationDataset(r io.Reader) (*MedicationNormalizer, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse medication dataset: %w", err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("medication dataset has no entries")
+	}
+
+	mn := &MedicationNormalizer{
+		canonical: make(map[string]string),
+		brands:    make(map[string]bool),
+	}
+	for _, row := range rows[1:] {
+		generic := normalizeMedicationName(row[0])
+		if generic == "" {
+			continue
+		}
+		mn.canonical[generic] = generic
+		for _, brand := range strings.Split(row[1], ";") {
+			if brand = normalizeMedicationName(brand); brand != "" {
+				mn.canonical[brand] = generic
+				mn.brands[brand] = true
+			}
+		}
+	}
+	return mn, nil
+}
+
+// Normalize finds the canonical generic name for a scanned medication name
+func (mn *MedicationNormalizer) Normalize(raw string) MedicationMatch {
+	match := MedicationMatch{Raw: raw, Kind: MedicationMatchUnmatched}
+
+	name := normalizeMedicationName(raw)
+	if name == "" {
+		return match
+	}
+
+	if generic, ok := mn.canonical[name]; ok {
+		match.Canonical = generic
+		match.Kind = MedicationMatchGeneric
+		if mn.brands[name] {
+			match.Kind = MedicationMatchBrand
+		}
+		return match
+	}
+
+	// Fall back to the closest name within an edit distance that scales
+	// with the name's length, so short names must match almost exactly.
+	best, bestDistance := "", maxMedicationEditDistance(name)+1
+	for candidate, generic := range mn.canonical {
+		if d := levenshtein(name, candidate); d < bestDistance || (d == bestDistance && generic < best) {
+			best, bestDistance = generic, d
+		}
+	}
+	if best != "" {
+		match.Canonical = best
+		match.Kind = MedicationMatchFuzzy
+	}
+	return match
+}
+
+func normalizeMedicationName(name string) string {
+	name = dosageTokenPattern.ReplaceAllString(strings.ToLower(name), " ")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func maxMedicationEditDistance(name string) int {
+	switch n := len(name); {
+	case n <= 4:
+		return 0
+	case n <= 7:
+		return 1
+	case n <= 12:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// loadTestMedications parses the medication dataset shipped with the server
+func loadTestMedications(t *testing.T) *MedicationNormalizer {
+	t.Helper()
+	f, err := os.Open("../datasets/medications.csv")
+	if err != nil {
+		t.Fatalf("open dataset: %v", err)
+	}
+	defer f.Close()
+	mn, err := parseMedicationDataset(f)
+	if err != nil {
+		t.Fatalf("parseMedicationDataset: %v", err)
+	}
+	return mn
+}
+
+func TestNormalizeMedication(t *testing.T) {
+	mn := loadTestMedications(t)
+	tests := []struct {
+		raw           string
+		wantCanonical string
+		wantKind      string
+	}{
+		{"Amoxicillin", "amoxicillin", MedicationMatchGeneric},
+		{"AMOXICILLIN 500 mg capsules", "amoxicillin", MedicationMatchGeneric},
+		{"Tylenol", "acetaminophen", MedicationMatchBrand},
+		{"Lipitor 20mg tablet", "atorvastatin", MedicationMatchBrand},
+		{"Insulin Glargine", "insulin glargine", MedicationMatchGeneric},
+		{"Amoxicilin", "amoxicillin", MedicationMatchFuzzy},
+		{"Atorvastatn", "atorvastatin", MedicationMatchFuzzy},
+		{"Ibuprofin 400mg", "ibuprofen", MedicationMatchFuzzy},
+		{"Advl", "", MedicationMatchUnmatched},
+		{"Zorblaxitol", "", MedicationMatchUnmatched},
+		{"500 mg tablet", "", MedicationMatchUnmatched},
+		{"", "", MedicationMatchUnmatched},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			match := mn.Normalize(tt.raw)
+			if match.Raw != tt.raw || match.Canonical != tt.wantCanonical || match.Kind != tt.wantKind {
+				t.Errorf("Normalize(%q) = %+v, want %q (%s)", tt.raw, match, tt.wantCanonical, tt.wantKind)
+			}
+			wantReview := tt.wantKind == MedicationMatchFuzzy || tt.wantKind == MedicationMatchUnmatched
+			if match.NeedsReview() != wantReview {
+				t.Errorf("NeedsReview() = %v, want %v", match.NeedsReview(), wantReview)
+			}
+		})
+	}
+}
+
+func TestApplyMedicationMatch(t *testing.T) {
+	mn := loadTestMedications(t)
+	tests := []struct {
+		raw        string
+		wantReview bool
+	}{
+		{"Motrin", false},
+		{"Motrn", true},
+		{"Zorblaxitol", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			extractedData := map[string]string{"medication": tt.raw}
+			match := mn.Normalize(tt.raw)
+			applyMedicationMatch(extractedData, match)
+
+			if extractedData["medication"] != tt.raw {
+				t.Errorf("raw name overwritten with %q", extractedData["medication"])
+			}
+			if extractedData["medication_canonical"] != match.Canonical || extractedData["medication_match"] != match.Kind {
+				t.Errorf("extracted data = %v, want the %+v match", extractedData, match)
+			}
+			if got := extractedData["needs_review"] == "true"; got != tt.wantReview {
+				t.Errorf("flagged for review = %v, want %v", got, tt.wantReview)
+			}
+		})
+	}
+}
+
+func TestParseMedicationDatasetRejectsMalformed(t *testing.T) {
+	for name, dataset := range map[string]string{
+		"header only":   "generic,brands\n",
+		"missing field": "generic,brands\namoxicillin\n",
+		"empty":         "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseMedicationDataset(strings.NewReader(dataset)); err == nil {
+				t.Error("parseMedicationDataset() accepted a malformed dataset")
+			}
+		})
+	}
+}
+
+func FuzzParseMedicationDataset(f *testing.F) {
+	f.Add("generic,brands\namoxicillin,Amoxil;Trimox\n", "amoxil 500mg")
+	f.Add("generic,brands\n\"ibu,profen\",\"Advil\"\n", "advil")
+	f.Add("generic,brands\n,\n", "")
+	f.Add("generic\n", "x")
+
+	f.Fuzz(func(t *testing.T, dataset, name string) {
+		mn, err := parseMedicationDataset(strings.NewReader(dataset))
+		if err != nil {
+			return
+		}
+		match := mn.Normalize(name)
+		if match.Raw != name {
+			t.Fatalf("Normalize(%q) lost the raw name: %+v", name, match)
+		}
+		if (match.Kind == MedicationMatchUnmatched) != (match.Canonical == "") {
+			t.Fatalf("Normalize(%q) = %+v", name, match)
+		}
+		if match.Canonical != "" && mn.canonical[match.Canonical] != match.Canonical {
+			t.Fatalf("Normalize(%q) returned %q, which is not a generic in the dataset", name, match.Canonical)
+		}
+	})
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/events"
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// doseReminderHorizon is how far ahead dose reminders are created; the
+	// medication-reminders job keeps ongoing schedules topped up
+	doseReminderHorizon = 7 * 24 * time.Hour
+
+	// maxCourseDays and maxDoseInterval bound user edits to a schedule
+	maxCourseDays   = 365
+	maxDoseInterval = 30
+)
+
+// MedicationScheduleDraft is the schedule proposed from a scan for the user
+// to review before ConfirmMedicationSetup
+type MedicationScheduleDraft struct {
+	Medication string
+	Dosage     string
+	Frequency  string
+	DoseFrequency
+	CourseDays int // 0 when the label gives no course length
+}
+
+// DraftMedicationSchedule proposes dose times for scanned prescription
+// fields using the frequency and course-duration parsers
+func DraftMedicationSchedule(extractedData map[string]string) MedicationScheduleDraft {
+	draft := MedicationScheduleDraft{
+		Medication:    extractedData["medication"],
+		Dosage:        extractedData["dosage"],
+		Frequency:     extractedData["frequency"],
+		DoseFrequency: ParseFrequency(extractedData["frequency"]),
+	}
+	if course, ok := ParseCourseDuration(extractedData["duration"]); ok {
+		draft.CourseDays = int(course / (24 * time.Hour))
+	}
+	return draft
+}
+
+// MedicationSetup is a schedule the user confirmed, possibly after editing
+// the draft
+type MedicationSetup struct {
+	Name       string
+	Dosage     string
+	Frequency  string   // label text, kept for reference
+	TimesOfDay []string // "HH:MM"; ignored when AsNeeded
+	EveryDays  int      // 0 means daily
+	AsNeeded   bool
+	CourseDays int       // 0 means ongoing
+	StartsAt   time.Time // zero means now
+	Timezone   string    // IANA name, default UTC
+	ScanID     string    // the scan the draft came from, if any
+}
+
+type MedicationService struct {
+	db      *gorm.DB
+	records *HealthRecordsService
+	now     func() time.Time
+}
+
+func NewMedicationService(db *gorm.DB, records *HealthRecordsService) *MedicationService {
+	return &MedicationService{
+		db:      db,
+		records: records,
+		now:     time.Now,
+	}
+}
+
+// ConfirmMedicationSetup creates the medication, its prescription record,
+// and the first week of dose reminders in one transaction, and returns the
+// medication with the number of reminders scheduled. A setup from a scan
+// uses the record ScanPrescription saved rather than a second one.
+// As-needed medications get no dose reminders.
+func (ms *MedicationService) ConfirmMedicationSetup(ctx context.Context, userID string, setup MedicationSetup) (*models.Medication, int, error) {
+	medication, err := ms.newMedication(userID, setup)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	scanned, err := ms.scannedRecord(ctx, userID, setup.ScanID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if scanned != nil {
+		return ms.confirm(ctx, userID, medication, scanned, nil)
+	}
+
+	metadata := map[string]string{
+		"medication":    medication.Name,
+		"dosage":        medication.Dosage,
+		"frequency":     medication.Frequency,
+		"medication_id": medication.ID,
+	}
+	if setup.CourseDays > 0 {
+		metadata["duration"] = fmt.Sprintf("%d days", setup.CourseDays)
+	}
+	if setup.ScanID != "" {
+		metadata["scan_id"] = setup.ScanID
+	}
+	record, err := ms.records.newRecord(userID, "prescription", medication.Name,
+		strings.TrimSpace(medication.Dosage+" "+medication.Frequency), metadata)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ms.confirm(ctx, userID, medication, record, metadata)
+}
+
+// scannedRecord returns the prescription record saved from one of the
+// user's scans, or nil if there is none
+func (ms *MedicationService) scannedRecord(ctx context.Context, userID, scanID string) (*models.HealthRecord, error) {
+	if scanID == "" {
+		return nil, nil
+	}
+	var record models.HealthRecord
+	err := ms.db.WithContext(ctx).Scopes(scopeOwner(userID)).
+		Where("scan_id = ? AND record_type = ?", scanID, "prescription").First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch scanned record: %w", err)
+	}
+	return &record, nil
+}
+
+// confirm saves a medication linked to record and schedules its first
+// week of dose reminders. A new record is inserted first with its
+// metadata; nil metadata means record is already saved.
+func (ms *MedicationService) confirm(ctx context.Context, userID string, medication *models.Medication, record *models.HealthRecord, metadata map[string]string) (*models.Medication, int, error) {
+	newRecord := metadata != nil
+	medication.RecordID = record.ID
+
+	scheduled := 0
+	err := ms.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if newRecord {
+			if err := insertRecord(tx, record, metadata); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(medication).Error; err != nil {
+			return fmt.Errorf("failed to create medication: %w", err)
+		}
+		if err := recordChange(tx, userID, record, models.ChangeEntityMedication, medication.ID,
+			models.ChangeKindStarted, describeMedicationSchedule(medication)); err != nil {
+			return err
+		}
+		var err error
+		now := ms.now()
+		scheduled, err = scheduleDoseReminders(tx, medication, now, now.Add(doseReminderHorizon))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if newRecord {
+		ms.records.events.Publish(ctx, events.RecordCreated{UserID: userID, RecordID: record.ID, RecordType: record.RecordType})
+	}
+	return medication, scheduled, nil
+}
+
+// newMedication validates a confirmed setup and builds the medication
+func (ms *MedicationService) newMedication(userID string, setup MedicationSetup) (*models.Medication, error) {
+	name := strings.TrimSpace(setup.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: medication name is required", ErrInvalidArgument)
+	}
+
+	timezone := setup.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidArgument, setup.Timezone)
+	}
+
+	everyDays := setup.EveryDays
+	if everyDays == 0 {
+		everyDays = 1
+	}
+	if everyDays < 0 || everyDays > maxDoseInterval {
+		return nil, fmt.Errorf("%w: doses must be 1 to %d days apart", ErrInvalidArgument, maxDoseInterval)
+	}
+	if setup.CourseDays < 0 || setup.CourseDays > maxCourseDays {
+		return nil, fmt.Errorf("%w: course must be 0 to %d days", ErrInvalidArgument, maxCourseDays)
+	}
+
+	var times []string
+	if !setup.AsNeeded {
+		var err error
+		if times, err = normalizeDoseTimes(setup.TimesOfDay); err != nil {
+			return nil, err
+		}
+		if len(times) == 0 {
+			return nil, fmt.Errorf("%w: choose at least one dose time or mark the medication as needed", ErrInvalidArgument)
+		}
+	}
+
+	now := ms.now()
+	startsAt := setup.StartsAt
+	if startsAt.IsZero() {
+		startsAt = now
+	}
+	medication := &models.Medication{
+		ID:             uuid.New().String(),
+		UserID:         userID,
+		Name:           name,
+		Dosage:         strings.TrimSpace(setup.Dosage),
+		Frequency:      strings.TrimSpace(setup.Frequency),
+		TimesOfDay:     strings.Join(times, ","),
+		EveryDays:      everyDays,
+		AsNeeded:       setup.AsNeeded,
+		Timezone:       timezone,
+		StartsAt:       startsAt,
+		ScheduledUntil: startsAt,
+		CreatedAt:      now,
+	}
+	if setup.CourseDays > 0 {
+		endsAt := startsAt.Add(days(setup.CourseDays))
+		medication.EndsAt = &endsAt
+	}
+	return medication, nil
+}
+
+// describeMedicationSchedule summarizes a schedule for the change feed
+// without naming the medication
+func describeMedicationSchedule(medication *models.Medication) string {
+	if medication.AsNeeded {
+		return "taken as needed"
+	}
+	summary := "1 dose"
+	if doses := len(strings.Split(medication.TimesOfDay, ",")); doses > 1 {
+		summary = fmt.Sprintf("%d doses", doses)
+	}
+	if medication.EveryDays > 1 {
+		return fmt.Sprintf("%s every %d days", summary, medication.EveryDays)
+	}
+	return summary + " a day"
+}
+
+// normalizeDoseTimes validates "HH:MM" times and returns them sorted
+// without duplicates
+func normalizeDoseTimes(times []string) ([]string, error) {
+	seen := make(map[string]bool, len(times))
+	var normalized []string
+	for _, raw := range times {
+		clock, err := time.Parse("15:04", strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%w: dose time %q must be HH:MM", ErrInvalidArgument, raw)
+		}
+		formatted := clock.Format("15:04")
+		if !seen[formatted] {
+			seen[formatted] = true
+			normalized = append(normalized, formatted)
+		}
+	}
+	return sortedTimes(normalized), nil
+}
+
+// ExtendDoseReminders tops up dose reminders for ongoing schedules so each
+// stays doseReminderHorizon ahead, and returns how many were created
+func (ms *MedicationService) ExtendDoseReminders(ctx context.Context) (int, error) {
+	now := ms.now()
+	until := now.Add(doseReminderHorizon)
+
+	var medications []models.Medication
+	if err := ms.db.WithContext(ctx).
+		Where("as_needed = ? AND scheduled_until < ?", false, until).
+		Where("ends_at IS NULL OR scheduled_until < ends_at").
+		Find(&medications).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch medications: %w", err)
+	}
+
+	created := 0
+	for i := range medications {
+		if ctx.Err() != nil {
+			return created, ctx.Err()
+		}
+		var n int
+		err := ms.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var err error
+			n, err = scheduleDoseReminders(tx, &medications[i], now, until)
+			return err
+		})
+		if err != nil {
+			return created, err
+		}
+		created += n
+	}
+
+	if created > 0 {
+		log.Printf("Scheduled %d medication dose reminders", created)
+	}
+	return created, nil
+}
+
+// scheduleDoseReminders creates reminders for the medication's doses from
+// its ScheduledUntil up to until (or the end of the course, if sooner) and
+// advances ScheduledUntil. Doses before now are skipped so a backdated
+// start does not fire a burst of reminders.
+func scheduleDoseReminders(tx *gorm.DB, medication *models.Medication, now, until time.Time) (int, error) {
+	if medication.AsNeeded {
+		return 0, nil
+	}
+	if medication.EndsAt != nil && medication.EndsAt.Before(until) {
+		until = *medication.EndsAt
+	}
+	if !medication.ScheduledUntil.Before(until) {
+		return 0, nil
+	}
+
+	from := medication.ScheduledUntil
+	if from.Before(now) {
+		from = now
+	}
+	doses, err := doseTimesBetween(medication, from, until)
+	if err != nil {
+		return 0, err
+	}
+
+	message := "Time to take your " + medication.Name
+	if medication.Dosage != "" {
+		message += " (" + medication.Dosage + ")"
+	}
+	for _, dueAt := range doses {
+		reminder := models.Reminder{
+			ID:        uuid.New().String(),
+			UserID:    medication.UserID,
+			RecordID:  medication.RecordID,
+			Kind:      models.ReminderKindDose,
+			Message:   message,
+			DueAt:     dueAt,
+			CreatedAt: now,
+		}
+		if err := tx.Create(&reminder).Error; err != nil {
+			return 0, fmt.Errorf("failed to create dose reminder: %w", err)
+		}
+	}
+
+	medication.ScheduledUntil = until
+	if err := tx.Model(medication).Update("scheduled_until", until).Error; err != nil {
+		return 0, fmt.Errorf("failed to update medication: %w", err)
+	}
+	return len(doses), nil
+}
+
+// doseTimesBetween returns the medication's dose times in [from, until).
+// Dosing days count from the start date in the medication's time zone, so
+// "every other day" keeps the same rhythm across top-ups.
+func doseTimesBetween(medication *models.Medication, from, until time.Time) ([]time.Time, error) {
+	loc, err := time.LoadLocation(medication.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("medication %s: %w", medication.ID, err)
+	}
+
+	var clocks [][2]int
+	for _, clock := range strings.Split(medication.TimesOfDay, ",") {
+		hour, minute, ok := strings.Cut(clock, ":")
+		h, herr := strconv.Atoi(hour)
+		m, merr := strconv.Atoi(minute)
+		if !ok || herr != nil || merr != nil {
+			return nil, fmt.Errorf("medication %s: bad dose time %q", medication.ID, clock)
+		}
+		clocks = append(clocks, [2]int{h, m})
+	}
+
+	everyDays := max(medication.EveryDays, 1)
+	start := medication.StartsAt.In(loc)
+	var doses []time.Time
+	for day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc); day.Before(until); day = day.AddDate(0, 0, everyDays) {
+		for _, clock := range clocks {
+			dueAt := time.Date(day.Year(), day.Month(), day.Day(), clock[0], clock[1], 0, 0, loc)
+			if !dueAt.Before(from) && dueAt.Before(until) && !dueAt.Before(medication.StartsAt) {
+				doses = append(doses, dueAt)
+			}
+		}
+	}
+	return doses, nil
+}
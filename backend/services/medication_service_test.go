@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// newTestMedicationService returns a MedicationService on clock
+func newTestMedicationService(db *gorm.DB, clock *testClock) *MedicationService {
+	ms := NewMedicationService(db, newTestRecordsService(db, nil))
+	ms.now = clock.Now
+	return ms
+}
+
+// doseReminders returns the user's dose reminders, earliest first
+func doseReminders(t *testing.T, db *gorm.DB, userID string) []models.Reminder {
+	t.Helper()
+	var reminders []models.Reminder
+	if err := db.Where("user_id = ? AND kind = ?", userID, models.ReminderKindDose).Order("due_at ASC").Find(&reminders).Error; err != nil {
+		t.Fatalf("load reminders: %v", err)
+	}
+	return reminders
+}
+
+func TestConfirmMedicationSetupFromDraft(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	ms := newTestMedicationService(db, clock)
+	createUser(t, db, "user-1")
+
+	draft := DraftMedicationSchedule(map[string]string{
+		"medication": "Amoxicillin",
+		"dosage":     "500mg",
+		"frequency":  "twice daily with meals",
+		"duration":   "for 5 days",
+	})
+	if draft.CourseDays != 5 || len(draft.TimesOfDay) != 2 {
+		t.Fatalf("draft = %+v", draft)
+	}
+
+	medication, scheduled, err := ms.ConfirmMedicationSetup(context.Background(), "user-1", MedicationSetup{
+		Name:       draft.Medication,
+		Dosage:     draft.Dosage,
+		Frequency:  draft.Frequency,
+		TimesOfDay: draft.TimesOfDay,
+		EveryDays:  draft.EveryDays,
+		CourseDays: draft.CourseDays,
+		ScanID:     "scan-1",
+	})
+	if err != nil {
+		t.Fatalf("ConfirmMedicationSetup: %v", err)
+	}
+
+	// 18:00 today, both doses on the next four days, 08:00 on the fifth
+	if scheduled != 10 {
+		t.Errorf("scheduled %d reminders, want 10", scheduled)
+	}
+	reminders := doseReminders(t, db, "user-1")
+	if len(reminders) != scheduled {
+		t.Fatalf("stored %d reminders, reported %d", len(reminders), scheduled)
+	}
+	if first := reminders[0].DueAt; !first.Equal(time.Date(2026, 10, 12, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("first dose at %v, want today 18:00", first)
+	}
+	if last := reminders[len(reminders)-1].DueAt; !last.Equal(time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("last dose at %v, want the end of the course", last)
+	}
+
+	var record models.HealthRecord
+	if err := db.First(&record, "id = ?", medication.RecordID).Error; err != nil {
+		t.Fatalf("linked record: %v", err)
+	}
+	if record.RecordType != "prescription" || record.Title != "Amoxicillin" || record.UserID != "user-1" {
+		t.Errorf("linked record = %+v", record)
+	}
+	for _, reminder := range reminders {
+		if reminder.RecordID != record.ID {
+			t.Fatalf("reminder %s is not linked to the record", reminder.ID)
+		}
+	}
+}
+
+func TestConfirmMedicationSetupAsNeeded(t *testing.T) {
+	db := newTestDB(t)
+	ms := newTestMedicationService(db, &testClock{now: time.Now()})
+	createUser(t, db, "user-1")
+
+	draft := DraftMedicationSchedule(map[string]string{"medication": "Ibuprofen", "frequency": "1 tablet every 6 hours as needed"})
+	if !draft.AsNeeded || len(draft.TimesOfDay) != 0 {
+		t.Fatalf("draft = %+v, want as needed", draft)
+	}
+
+	medication, scheduled, err := ms.ConfirmMedicationSetup(context.Background(), "user-1", MedicationSetup{
+		Name:       draft.Medication,
+		AsNeeded:   draft.AsNeeded,
+		TimesOfDay: []string{"08:00"}, // ignored for as-needed medications
+	})
+	if err != nil {
+		t.Fatalf("ConfirmMedicationSetup: %v", err)
+	}
+	if scheduled != 0 || len(doseReminders(t, db, "user-1")) != 0 {
+		t.Errorf("as-needed medication scheduled %d reminders", scheduled)
+	}
+	if !medication.AsNeeded || medication.TimesOfDay != "" {
+		t.Errorf("medication = %+v", medication)
+	}
+
+	if n, err := ms.ExtendDoseReminders(context.Background()); err != nil || n != 0 {
+		t.Errorf("ExtendDoseReminders() = %d, %v; want nothing for as-needed medications", n, err)
+	}
+}
+
+func TestConfirmMedicationSetupInTimezoneEveryOtherDay(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 4, 0, 0, 0, time.UTC)}
+	ms := newTestMedicationService(db, clock)
+	createUser(t, db, "user-1")
+
+	_, scheduled, err := ms.ConfirmMedicationSetup(context.Background(), "user-1", MedicationSetup{
+		Name:       "Methotrexate",
+		TimesOfDay: []string{"8:00"},
+		EveryDays:  2,
+		Timezone:   "Europe/Berlin",
+	})
+	if err != nil {
+		t.Fatalf("ConfirmMedicationSetup: %v", err)
+	}
+	if scheduled != 4 {
+		t.Fatalf("scheduled %d reminders, want 4", scheduled)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	for i, reminder := range doseReminders(t, db, "user-1") {
+		want := time.Date(2026, 10, 12+2*i, 8, 0, 0, 0, berlin)
+		if !reminder.DueAt.Equal(want) {
+			t.Errorf("dose %d at %v, want %v", i, reminder.DueAt, want)
+		}
+	}
+}
+
+func TestConfirmMedicationSetupRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup MedicationSetup
+	}{
+		{"no name", MedicationSetup{TimesOfDay: []string{"08:00"}}},
+		{"no dose times", MedicationSetup{Name: "Aspirin"}},
+		{"bad dose time", MedicationSetup{Name: "Aspirin", TimesOfDay: []string{"8am"}}},
+		{"unknown timezone", MedicationSetup{Name: "Aspirin", TimesOfDay: []string{"08:00"}, Timezone: "Mars/Olympus"}},
+		{"interval too long", MedicationSetup{Name: "Aspirin", TimesOfDay: []string{"08:00"}, EveryDays: maxDoseInterval + 1}},
+		{"course too long", MedicationSetup{Name: "Aspirin", TimesOfDay: []string{"08:00"}, CourseDays: maxCourseDays + 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ms := newTestMedicationService(db, &testClock{now: time.Now()})
+			createUser(t, db, "user-1")
+
+			if _, _, err := ms.ConfirmMedicationSetup(context.Background(), "user-1", tt.setup); !errors.Is(err, ErrInvalidArgument) {
+				t.Fatalf("ConfirmMedicationSetup() error = %v, want %v", err, ErrInvalidArgument)
+			}
+			var medications, records int64
+			db.Model(&models.Medication{}).Count(&medications)
+			db.Model(&models.HealthRecord{}).Count(&records)
+			if medications != 0 || records != 0 {
+				t.Errorf("rejected setup left %d medications and %d records", medications, records)
+			}
+		})
+	}
+}
+
+func TestConfirmMedicationSetupIsAtomic(t *testing.T) {
+	db := newTestDB(t)
+	ms := newTestMedicationService(db, &testClock{now: time.Now()})
+	createUser(t, db, "user-1")
+
+	// Fail the last step, creating the dose reminders
+	errDiskFull := errors.New("disk full")
+	db.Callback().Create().Before("gorm:create").Register("test:fail_reminders", func(tx *gorm.DB) {
+		if tx.Statement.Table == "reminders" {
+			tx.AddError(errDiskFull)
+		}
+	})
+
+	_, _, err := ms.ConfirmMedicationSetup(context.Background(), "user-1", MedicationSetup{Name: "Aspirin", TimesOfDay: []string{"08:00"}})
+	if !errors.Is(err, errDiskFull) {
+		t.Fatalf("ConfirmMedicationSetup() error = %v, want %v", err, errDiskFull)
+	}
+	var medications, records int64
+	db.Model(&models.Medication{}).Count(&medications)
+	db.Model(&models.HealthRecord{}).Count(&records)
+	if medications != 0 || records != 0 {
+		t.Errorf("failed setup left %d medications and %d records", medications, records)
+	}
+}
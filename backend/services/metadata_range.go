@@ -0,0 +1,133 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// maxMetadataRanges bounds how many range filters one query applies
+const maxMetadataRanges = 5
+
+// metadataNumberPattern matches a metadata value that starts with a number,
+// optionally followed by a unit, e.g. "98", "-1.5", "120 mg/dL", "37.2C"
+var metadataNumberPattern = regexp.MustCompile(`^([-+]?(?:\d+\.?\d*|\.\d+))\s*(?:[A-Za-z%µ°][A-Za-z0-9%µ°/.\s]*)?$`)
+
+// MetadataRange selects records whose numeric metadata value for Key lies
+// within [Min, Max]. A nil bound is open.
+type MetadataRange struct {
+	Key string
+	Min *float64
+	Max *float64
+}
+
+// ParseMetadataRange builds a range from decimal bound strings, where an
+// empty string leaves that end open
+func ParseMetadataRange(key, min, max string) (MetadataRange, error) {
+	r := MetadataRange{Key: strings.TrimSpace(key)}
+	for _, bound := range []struct {
+		text string
+		dst  **float64
+	}{{min, &r.Min}, {max, &r.Max}} {
+		text := strings.TrimSpace(bound.text)
+		if text == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return MetadataRange{}, fmt.Errorf("%w: range bound %q for %s is not a number", ErrInvalidArgument, bound.text, r.Key)
+		}
+		*bound.dst = &v
+	}
+	return r, nil
+}
+
+// validateMetadataRanges rejects ranges that cannot match anything useful
+func validateMetadataRanges(ranges []MetadataRange) error {
+	if len(ranges) > maxMetadataRanges {
+		return fmt.Errorf("%w: at most %d metadata ranges", ErrInvalidArgument, maxMetadataRanges)
+	}
+	for _, r := range ranges {
+		if !metadataKeyPattern.MatchString(r.Key) {
+			return fmt.Errorf("%w: invalid metadata key %q", ErrInvalidArgument, r.Key)
+		}
+		if r.Min == nil && r.Max == nil {
+			return fmt.Errorf("%w: range for %s needs a min or max", ErrInvalidArgument, r.Key)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return fmt.Errorf("%w: range for %s has min above max", ErrInvalidArgument, r.Key)
+		}
+	}
+	return nil
+}
+
+// parseMetadataNumber reads the numeric part of a metadata value. Values
+// that are not a number, or a number followed by a unit, are skipped.
+func parseMetadataNumber(value string) (float64, bool) {
+	m := metadataNumberPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// indexMetadataNumbers replaces a record's numeric metadata index entries
+// inside tx
+func indexMetadataNumbers(tx *gorm.DB, record *models.HealthRecord) error {
+	if err := tx.Delete(&models.RecordMetadataNumber{}, "record_id = ?", record.ID).Error; err != nil {
+		return err
+	}
+
+	var metadata map[string]string
+	if record.Metadata == "" || json.Unmarshal([]byte(record.Metadata), &metadata) != nil {
+		return nil
+	}
+
+	var entries []models.RecordMetadataNumber
+	for key, value := range metadata {
+		if v, ok := parseMetadataNumber(value); ok {
+			entries = append(entries, models.RecordMetadataNumber{
+				RecordID: record.ID,
+				Key:      key,
+				UserID:   record.UserID,
+				Value:    v,
+			})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Create(&entries).Error
+}
+
+// scopeMetadataRanges restricts a query on userID's health records to those
+// matching every range. Records without a numeric value for a key never
+// match.
+func scopeMetadataRanges(userID string, ranges []MetadataRange) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, r := range ranges {
+			matches := db.Session(&gorm.Session{NewDB: true}).
+				Model(&models.RecordMetadataNumber{}).
+				Select("record_id").
+				Where("user_id = ? AND metadata_key = ?", userID, r.Key)
+			if r.Min != nil {
+				matches = matches.Where("value >= ?", *r.Min)
+			}
+			if r.Max != nil {
+				matches = matches.Where("value <= ?", *r.Max)
+			}
+			db = db.Where("id IN (?)", matches)
+		}
+		return db
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/clarity/backend/models"
+)
+
+func TestParseMetadataNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		ok    bool
+	}{
+		{"98", 98, true},
+		{"-1.5", -1.5, true},
+		{"+4", 4, true},
+		{".5", 0.5, true},
+		{"120 mg/dL", 120, true},
+		{"37.2C", 37.2, true},
+		{"6.1%", 6.1, true},
+		{" 80 ", 80, true},
+		{"high", 0, false},
+		{"", 0, false},
+		{"120/80", 0, false},
+		{"12-14", 0, false},
+		{"about 5", 0, false},
+		{"NaN", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseMetadataNumber(tt.value)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseMetadataNumber(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMetadataRangeValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		min, max string
+		want     error
+	}{
+		{"closed", "glucose", "80", "120", nil},
+		{"open min", "glucose", "", "120", nil},
+		{"open max", "glucose", "80", "", nil},
+		{"single point", "glucose", "80", "80", nil},
+		{"no bounds", "glucose", "", "", ErrInvalidArgument},
+		{"min above max", "glucose", "120", "80", ErrInvalidArgument},
+		{"bound not a number", "glucose", "eighty", "", ErrInvalidArgument},
+		{"infinite bound", "glucose", "", "Inf", ErrInvalidArgument},
+		{"bad key", "glu cose", "80", "", ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseMetadataRange(tt.key, tt.min, tt.max)
+			if err == nil {
+				err = validateMetadataRanges([]MetadataRange{r})
+			}
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	r, _ := ParseMetadataRange("glucose", "80", "")
+	tooMany := make([]MetadataRange, maxMetadataRanges+1)
+	for i := range tooMany {
+		tooMany[i] = r
+	}
+	if err := validateMetadataRanges(tooMany); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("too many ranges: error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestListAndSearchRecordsByMetadataRange(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	ss := NewSearchService(db, nil)
+	ctx := context.Background()
+
+	seed := []struct {
+		userID   string
+		title    string
+		metadata map[string]string
+	}{
+		{"user-1", "glucose 75", map[string]string{"glucose": "75"}},
+		{"user-1", "glucose 80", map[string]string{"glucose": "80 mg/dL", "hba1c": "5.4%"}},
+		{"user-1", "glucose 100", map[string]string{"glucose": "100", "hba1c": "6.8%"}},
+		{"user-1", "glucose 120", map[string]string{"glucose": "120.0"}},
+		{"user-1", "glucose 121", map[string]string{"glucose": "121"}},
+		{"user-1", "glucose high", map[string]string{"glucose": "high"}},
+		{"user-1", "no glucose", map[string]string{"cholesterol": "100"}},
+		{"user-2", "other user glucose 100", map[string]string{"glucose": "100"}},
+	}
+	ids := make(map[string]string)
+	for _, s := range seed {
+		record, err := hrs.CreateRecord(ctx, s.userID, "lab_result", s.title, "", s.metadata)
+		if err != nil {
+			t.Fatalf("CreateRecord(%s): %v", s.title, err)
+		}
+		ids[record.ID] = s.title
+	}
+	titles := func(records []models.HealthRecord) []string {
+		var got []string
+		for _, record := range records {
+			got = append(got, ids[record.ID])
+		}
+		slices.Sort(got)
+		return got
+	}
+	bound := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name   string
+		ranges []MetadataRange
+		want   []string
+	}{
+		{"inclusive range", []MetadataRange{{Key: "glucose", Min: bound(80), Max: bound(120)}},
+			[]string{"glucose 100", "glucose 120", "glucose 80"}},
+		{"open min", []MetadataRange{{Key: "glucose", Max: bound(80)}},
+			[]string{"glucose 75", "glucose 80"}},
+		{"open max", []MetadataRange{{Key: "glucose", Min: bound(120.5)}},
+			[]string{"glucose 121"}},
+		{"two keys", []MetadataRange{{Key: "glucose", Min: bound(70)}, {Key: "hba1c", Max: bound(6)}},
+			[]string{"glucose 80"}},
+		{"nothing in range", []MetadataRange{{Key: "glucose", Min: bound(200)}}, nil},
+		{"key no record has", []MetadataRange{{Key: "ldl", Min: bound(0)}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listed, total, err := hrs.ListRecords(ctx, "user-1", ListRecordsOptions{MetadataRanges: tt.ranges})
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			if got := titles(listed); !slices.Equal(got, tt.want) || total != int64(len(tt.want)) {
+				t.Errorf("ListRecords = %v (total %d), want %v", got, total, tt.want)
+			}
+
+			found, _, err := ss.SearchRecords(ctx, "user-1", "", tt.ranges, 0, 0)
+			if err != nil {
+				t.Fatalf("SearchRecords: %v", err)
+			}
+			if got := titles(found); !slices.Equal(got, tt.want) {
+				t.Errorf("SearchRecords = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A query narrows the range matches further
+	found, _, err := ss.SearchRecords(ctx, "user-1", "120", []MetadataRange{{Key: "glucose", Min: bound(80)}}, 0, 0)
+	if err != nil {
+		t.Fatalf("SearchRecords: %v", err)
+	}
+	if got := titles(found); !slices.Equal(got, []string{"glucose 120"}) {
+		t.Errorf("SearchRecords with a query = %v, want [glucose 120]", got)
+	}
+}
+
+func TestMetadataRangeFollowsRecordUpdates(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	ctx := context.Background()
+	inRange := []MetadataRange{{Key: "glucose", Max: func(v float64) *float64 { return &v }(120)}}
+
+	record, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Fasting glucose", "", map[string]string{"glucose": "95"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if listed, _, _ := hrs.ListRecords(ctx, "user-1", ListRecordsOptions{MetadataRanges: inRange}); len(listed) != 1 {
+		t.Fatalf("record in range listed %d times, want 1", len(listed))
+	}
+
+	if _, err := hrs.UpdateRecord(ctx, "user-1", record.ID, record.Title, "", map[string]string{"glucose": "180"}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if listed, _, _ := hrs.ListRecords(ctx, "user-1", ListRecordsOptions{MetadataRanges: inRange}); len(listed) != 0 {
+		t.Errorf("record updated out of range still listed")
+	}
+
+	if _, err := hrs.UpdateRecord(ctx, "user-1", record.ID, record.Title, "", map[string]string{"glucose": "pending"}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	var indexed int64
+	db.Model(&models.RecordMetadataNumber{}).Where("record_id = ?", record.ID).Count(&indexed)
+	if indexed != 0 {
+		t.Errorf("non-numeric value left %d index entries", indexed)
+	}
+}
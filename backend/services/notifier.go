@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"log"
+)
+
+// Notification is a user-facing message such as a reminder. Link points the
+// app at the related entity, e.g. "clarity://records/<id>".
+type Notification struct {
+	UserID string
+	Title  string
+	Body   string
+	Link   string
+}
+
+// Notifier delivers notifications to users
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NewLogNotifier returns a Notifier that only logs, for development
+func NewLogNotifier() Notifier {
+	return &logNotifier{}
+}
+
+type logNotifier struct{}
+
+func (ln *logNotifier) Notify(ctx context.Context, n Notification) error {
+	// In production, send via push notification service
+	log.Printf("Notification for user %s: %s (%s)", n.UserID, n.Title, n.Link)
+	return nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// textProvider is a fakeProvider that also reports the text it read
+type textProvider struct {
+	*fakeProvider
+	textScans int
+}
+
+func (tp *textProvider) ScanPrescriptionText(ctx context.Context, imageData []byte) (map[string]string, *OCRText, error) {
+	tp.textScans++
+	data, err := tp.ScanPrescription(ctx, imageData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, ocrText([]string{"Rx " + data["medication"], "twice daily"}, func(i int) float64 { return 0.5 + float64(i)/10 }), nil
+}
+
+func TestScanReturnsRawTextOnlyWhenAsked(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	as := newTestAIService(t, db, nil)
+	ctx := context.Background()
+
+	result, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true})
+	if err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if result.RawText != nil {
+		t.Errorf("raw text returned without being asked for: %+v", result.RawText)
+	}
+
+	result, err = as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true, IncludeRawText: true})
+	if err != nil {
+		t.Fatalf("ScanPrescription with raw text: %v", err)
+	}
+	text := result.RawText
+	if text == nil {
+		t.Fatal("no raw text returned when asked for")
+	}
+	if medication := result.ExtractedData["medication"]; medication == "" || !strings.Contains(text.Text, medication) {
+		t.Errorf("raw text %q does not hold the extracted medication %q", text.Text, medication)
+	}
+	words := strings.Fields(text.Text)
+	if len(text.Tokens) != len(words) {
+		t.Fatalf("%d tokens for %d words", len(text.Tokens), len(words))
+	}
+	for i, token := range text.Tokens {
+		if token.Text != words[i] || token.Confidence <= 0 || token.Confidence > 1 {
+			t.Errorf("token %d = %+v, want %q with a confidence in (0, 1]", i, token, words[i])
+		}
+	}
+}
+
+func TestSandboxRawTextConfidences(t *testing.T) {
+	sp := &sandboxAIProvider{}
+	ctx := context.Background()
+	data, text, err := sp.ScanPrescriptionText(ctx, []byte("label"))
+	if err != nil {
+		t.Fatalf("ScanPrescriptionText: %v", err)
+	}
+	if !strings.Contains(text.Text, data["medication"]) || !strings.Contains(text.Text, sandboxWatermark) {
+		t.Errorf("sandbox text %q does not match its extraction %v", text.Text, data)
+	}
+	for _, token := range text.Tokens {
+		if token.Confidence < 0.75 || token.Confidence >= 1 {
+			t.Errorf("token %+v has a confidence outside [0.75, 1)", token)
+		}
+	}
+	_, again, _ := sp.ScanPrescriptionText(ctx, []byte("label"))
+	for i := range text.Tokens {
+		if again.Tokens[i] != text.Tokens[i] {
+			t.Fatalf("the same image read as %+v then %+v", text.Tokens[i], again.Tokens[i])
+		}
+	}
+}
+
+func TestRawTextNeedsAProviderThatReadsText(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	as := newTestAIService(t, db, nil)
+	as.provider = &fakeProvider{scan: map[string]string{"medication": "Amoxicillin"}}
+
+	result, err := as.ScanPrescription(context.Background(), "user-1", []byte("image"), ScanOptions{Force: true, IncludeRawText: true})
+	if err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if result.RawText != nil || result.ExtractedData["medication"] != "Amoxicillin" {
+		t.Errorf("scan = %+v, want the extraction without raw text", result)
+	}
+}
+
+func TestRawTextScansBypassTheCache(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	as := NewAIService(db, &config.AIConfig{}, nil, nil, nil, NewMemoryCache(0), time.Hour, nil, nil)
+	provider := &textProvider{fakeProvider: &fakeProvider{scan: map[string]string{"medication": "Amoxicillin"}}}
+	as.provider = provider
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true}); err != nil {
+			t.Fatalf("ScanPrescription: %v", err)
+		}
+	}
+	if provider.scans != 1 {
+		t.Fatalf("provider scanned %d times for one image, want the second from the cache", provider.scans)
+	}
+
+	result, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true, IncludeRawText: true})
+	if err != nil {
+		t.Fatalf("ScanPrescription with raw text: %v", err)
+	}
+	if provider.textScans != 1 || result.RawText == nil || result.RawText.Text != "Rx Amoxicillin\ntwice daily" {
+		t.Errorf("raw text scan = %+v after %d text reads, want it read by the provider", result.RawText, provider.textScans)
+	}
+	if got := result.RawText.Tokens[1]; got.Text != "Amoxicillin" || got.Confidence != 0.6 {
+		t.Errorf("second token = %+v, want Amoxicillin at 0.6", got)
+	}
+}
@@ -0,0 +1,501 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	orgInviteTTL = 7 * 24 * time.Hour
+
+	// OrgInviteRolePatient invites a patient rather than a staff member
+	OrgInviteRolePatient = "patient"
+)
+
+type OrganizationService struct {
+	db         *gorm.DB
+	deliveries *DeliveryQueue
+}
+
+func NewOrganizationService(db *gorm.DB, deliveries *DeliveryQueue) *OrganizationService {
+	return &OrganizationService{db: db, deliveries: deliveries}
+}
+
+// CreateOrganization creates an organization with the creator as its first admin
+func (ors *OrganizationService) CreateOrganization(ctx context.Context, creatorID, name string) (*models.Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: organization name is required", ErrInvalidArgument)
+	}
+
+	org := models.Organization{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	err := ors.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var creator models.User
+		if err := tx.First(&creator, "id = ?", creatorID).Error; err != nil {
+			return fmt.Errorf("%w: user %s", ErrNotFound, creatorID)
+		}
+		if creator.OrgID != "" {
+			return fmt.Errorf("%w: user already belongs to an organization", ErrAlreadyExists)
+		}
+
+		if err := tx.Create(&org).Error; err != nil {
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+		membership := models.OrgMembership{
+			ID:        uuid.New().String(),
+			OrgID:     org.ID,
+			UserID:    creatorID,
+			Role:      models.OrgRoleAdmin,
+			CreatedAt: time.Now(),
+		}
+		if err := tx.Create(&membership).Error; err != nil {
+			return fmt.Errorf("failed to create membership: %w", err)
+		}
+		return tx.Model(&creator).Update("org_id", org.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// CreatedInvite is returned at creation. The token is not part of it: it
+// goes only to the invitee, in the queued email, so not even the inviting
+// admin can accept on their behalf.
+type CreatedInvite struct {
+	Invite     *models.OrgInvite
+	DeliveryID string // reference of the queued invite email
+}
+
+// InviteMember creates an invite for email to join the organization as a
+// patient, staff member, or admin, and queues the email carrying its link.
+func (ors *OrganizationService) InviteMember(ctx context.Context, adminID, orgID, email, role string) (*CreatedInvite, error) {
+	if _, err := ors.requireMembership(ctx, adminID, orgID, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	switch role {
+	case OrgInviteRolePatient, models.OrgRoleStaff, models.OrgRoleAdmin:
+	default:
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidArgument, role)
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, fmt.Errorf("%w: email is required", ErrInvalidArgument)
+	}
+
+	var org models.Organization
+	if err := ors.db.WithContext(ctx).First(&org, "id = ?", orgID).Error; err != nil {
+		return nil, fmt.Errorf("%w: organization %s", ErrNotFound, orgID)
+	}
+
+	token, err := generateInviteToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invite := models.OrgInvite{
+		ID:        uuid.New().String(),
+		OrgID:     orgID,
+		Email:     email,
+		Role:      role,
+		TokenHash: hashToken(token),
+		InvitedBy: adminID,
+		ExpiresAt: time.Now().Add(orgInviteTTL),
+		CreatedAt: time.Now(),
+	}
+	delivery := &models.Delivery{
+		Channel:   models.DeliveryChannelEmail,
+		Recipient: email,
+		Subject:   "You're invited to join " + org.Name + " on Clarity",
+		Body:      orgInviteEmailBody(org.Name, role, orgInviteLinkPrefix+token, invite.ExpiresAt),
+		Sensitive: true,
+	}
+	err = ors.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&invite).Error; err != nil {
+			return fmt.Errorf("failed to create invite: %w", err)
+		}
+		return ors.deliveries.Enqueue(tx, delivery)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Queued %s invite to org %s for %s (ref %s)", role, orgID, email, delivery.ID)
+
+	return &CreatedInvite{Invite: &invite, DeliveryID: delivery.ID}, nil
+}
+
+// orgInviteLinkPrefix opens the app's accept-invite screen for the token
+// that follows it
+const orgInviteLinkPrefix = "clarity://org-invites/"
+
+// orgInviteEmailBody renders the invite email. Only an account with the
+// invited email can accept it, so the link is of no use to anyone else.
+func orgInviteEmailBody(orgName, role, link string, expiresAt time.Time) string {
+	joinAs := "as a " + role
+	if role == OrgInviteRolePatient {
+		joinAs = "so its staff can see the records you choose to share"
+	}
+	return fmt.Sprintf("%s has invited you to join them on Clarity %s.\n\n"+
+		"Open %s on your phone to accept. The invite expires on %s.\n",
+		orgName, joinAs, link, expiresAt.UTC().Format("2 Jan 2006 15:04 MST"))
+}
+
+// AcceptInvite joins userID to the inviting organization. The invite must be
+// addressed to the user's email. Staff and admin invites create a membership;
+// patient invites only associate the user with the org, since record access
+// still requires an explicit consent grant.
+func (ors *OrganizationService) AcceptInvite(ctx context.Context, userID, token string) (*models.User, error) {
+	var user models.User
+	err := ors.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invite models.OrgInvite
+		if err := tx.Where("token_hash = ? AND accepted_at IS NULL", hashToken(token)).First(&invite).Error; err != nil {
+			return fmt.Errorf("%w: invite", ErrNotFound)
+		}
+		if time.Now().After(invite.ExpiresAt) {
+			return fmt.Errorf("%w: invite expired", ErrInvalidArgument)
+		}
+
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			return fmt.Errorf("%w: user %s", ErrNotFound, userID)
+		}
+		if !strings.EqualFold(user.Email, invite.Email) {
+			return fmt.Errorf("%w: invite is addressed to a different email", ErrPermissionDenied)
+		}
+		if user.OrgID != "" && user.OrgID != invite.OrgID {
+			return fmt.Errorf("%w: user already belongs to another organization", ErrAlreadyExists)
+		}
+
+		if invite.Role != OrgInviteRolePatient {
+			membership := models.OrgMembership{
+				ID:        uuid.New().String(),
+				OrgID:     invite.OrgID,
+				UserID:    user.ID,
+				Role:      invite.Role,
+				CreatedAt: time.Now(),
+			}
+			if err := tx.Create(&membership).Error; err != nil {
+				return fmt.Errorf("failed to create membership: %w", err)
+			}
+		}
+
+		now := time.Now()
+		if err := tx.Model(&invite).Update("accepted_at", &now).Error; err != nil {
+			return fmt.Errorf("failed to accept invite: %w", err)
+		}
+		user.OrgID = invite.OrgID
+		return tx.Model(&user).Update("org_id", invite.OrgID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GrantConsent lets the organization's staff view patientID's records
+func (ors *OrganizationService) GrantConsent(ctx context.Context, patientID, orgID string) (*models.OrgConsent, error) {
+	if err := ors.db.WithContext(ctx).First(&models.Organization{}, "id = ?", orgID).Error; err != nil {
+		return nil, fmt.Errorf("%w: organization %s", ErrNotFound, orgID)
+	}
+
+	var consent models.OrgConsent
+	err := ors.db.WithContext(ctx).Scopes(scopeOrg(orgID)).Where("patient_id = ?", patientID).First(&consent).Error
+	switch {
+	case err == nil:
+		consent.GrantedAt = time.Now()
+		consent.RevokedAt = nil
+		if err := ors.db.WithContext(ctx).Model(&consent).Select("granted_at", "revoked_at").Updates(&consent).Error; err != nil {
+			return nil, fmt.Errorf("failed to renew consent: %w", err)
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		consent = models.OrgConsent{
+			ID:        uuid.New().String(),
+			OrgID:     orgID,
+			PatientID: patientID,
+			GrantedAt: time.Now(),
+		}
+		if err := ors.db.WithContext(ctx).Create(&consent).Error; err != nil {
+			return nil, fmt.Errorf("failed to grant consent: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("failed to fetch consent: %w", err)
+	}
+
+	return &consent, nil
+}
+
+// RevokeConsent withdraws a patient's consent; staff lose access immediately
+func (ors *OrganizationService) RevokeConsent(ctx context.Context, patientID, orgID string) error {
+	result := ors.db.WithContext(ctx).Model(&models.OrgConsent{}).
+		Scopes(scopeOrg(orgID)).
+		Where("patient_id = ? AND revoked_at IS NULL", patientID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke consent: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: active consent", ErrNotFound)
+	}
+	return nil
+}
+
+// ListConsentingPatients returns the patients who currently grant the staff member's org access
+func (ors *OrganizationService) ListConsentingPatients(ctx context.Context, staffID, orgID string) ([]models.User, error) {
+	if _, err := ors.requireMembership(ctx, staffID, orgID, models.OrgRoleStaff, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var patients []models.User
+	consented := ors.db.WithContext(ctx).Model(&models.OrgConsent{}).
+		Select("patient_id").
+		Scopes(scopeOrg(orgID)).
+		Where("revoked_at IS NULL")
+	if err := ors.db.WithContext(ctx).Where("id IN (?)", consented).Order("name ASC").Find(&patients).Error; err != nil {
+		return nil, fmt.Errorf("failed to list patients: %w", err)
+	}
+	return patients, nil
+}
+
+// OrgStats counts an organization's members and the data its staff can see
+type OrgStats struct {
+	Admins             int64
+	Staff              int64
+	ConsentingPatients int64
+	Records            int64 // records of consenting patients, less blocked ones
+	SensitiveRecords   int64
+}
+
+// GetOrgStats returns an organization's counts to one of its admins. Record
+// counts only cover patients who currently grant the org consent, so they
+// never include data the org's staff cannot see.
+func (ors *OrganizationService) GetOrgStats(ctx context.Context, adminID, orgID string) (*OrgStats, error) {
+	if _, err := ors.requireMembership(ctx, adminID, orgID, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var stats OrgStats
+	var roles []struct {
+		Role  string
+		Count int64
+	}
+	if err := ors.db.WithContext(ctx).Model(&models.OrgMembership{}).
+		Select("role, COUNT(*) AS count").
+		Scopes(scopeOrg(orgID)).
+		Group("role").
+		Scan(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to count members: %w", err)
+	}
+	for _, role := range roles {
+		switch role.Role {
+		case models.OrgRoleAdmin:
+			stats.Admins = role.Count
+		case models.OrgRoleStaff:
+			stats.Staff = role.Count
+		}
+	}
+
+	if err := ors.db.WithContext(ctx).Model(&models.OrgConsent{}).
+		Scopes(scopeOrg(orgID)).
+		Where("revoked_at IS NULL").
+		Count(&stats.ConsentingPatients).Error; err != nil {
+		return nil, fmt.Errorf("failed to count consenting patients: %w", err)
+	}
+	if err := ors.db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Scopes(scopeOrgConsented(orgID), scopeNotBlocked()).
+		Count(&stats.Records).Error; err != nil {
+		return nil, fmt.Errorf("failed to count records: %w", err)
+	}
+	if err := ors.db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Scopes(scopeOrgConsented(orgID), scopeNotBlocked()).
+		Where("sensitivity = ?", models.SensitivitySensitive).
+		Count(&stats.SensitiveRecords).Error; err != nil {
+		return nil, fmt.Errorf("failed to count sensitive records: %w", err)
+	}
+	return &stats, nil
+}
+
+// maxOrgExportRecords bounds how many records one organization export
+// returns
+const maxOrgExportRecords = 5000
+
+// ExportRecords returns the records of every patient who grants the org
+// consent to one of its admins, grouped by patient and oldest first.
+// Blocked records are left out and sensitive ones are exported without
+// their contents, since a bulk export has no reason to give for each.
+// truncated is set when there were more than maxOrgExportRecords.
+func (ors *OrganizationService) ExportRecords(ctx context.Context, adminID, orgID string) (records []models.HealthRecord, truncated bool, err error) {
+	if _, err := ors.requireMembership(ctx, adminID, orgID, models.OrgRoleAdmin); err != nil {
+		return nil, false, err
+	}
+
+	// One record past the cap tells whether any were left out
+	if err := ors.db.WithContext(ctx).Scopes(scopeOrgConsented(orgID), scopeNotBlocked()).
+		Order("user_id ASC, created_at ASC").
+		Limit(maxOrgExportRecords + 1).
+		Find(&records).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to export records: %w", err)
+	}
+	if len(records) > maxOrgExportRecords {
+		records, truncated = records[:maxOrgExportRecords], true
+	}
+	for i := range records {
+		if records[i].Sensitivity == models.SensitivitySensitive {
+			redactRecord(&records[i])
+		}
+	}
+
+	log.Printf("Exported %d records of org %s for admin %s", len(records), orgID, adminID)
+	return records, truncated, nil
+}
+
+// ListPatientRecords returns a consenting patient's records to a member of
+// the org. Records the patient has blocked are left out. Sensitive records
+// are listed without their contents unless reason is given, in which case
+// each one returned is logged for the patient to see.
+func (ors *OrganizationService) ListPatientRecords(ctx context.Context, staffID, orgID, patientID, reason string, limit, offset int) ([]models.HealthRecord, int64, error) {
+	if _, err := ors.requireMembership(ctx, staffID, orgID, models.OrgRoleStaff, models.OrgRoleAdmin); err != nil {
+		return nil, 0, err
+	}
+
+	limit, offset = pageBounds(limit, offset)
+	if !ors.hasConsent(ctx, orgID, patientID) {
+		return nil, 0, fmt.Errorf("%w: patient has not granted consent", ErrPermissionDenied)
+	}
+
+	var total int64
+	if err := ors.db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Scopes(scopeOrgConsented(orgID), scopeOwner(patientID), scopeNotBlocked()).
+		Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count records: %w", err)
+	}
+
+	var records []models.HealthRecord
+	if err := ors.db.WithContext(ctx).Scopes(scopeOrgConsented(orgID), scopeOwner(patientID), scopeNotBlocked()).
+		Order("created_at DESC").Limit(limit).Offset(offset).Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list records: %w", err)
+	}
+
+	err := ors.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range records {
+			if err := authorizeRecordRead(staffID, &records[i], reason); err != nil {
+				if !errors.Is(err, ErrAccessReasonRequired) {
+					return err
+				}
+				redactRecord(&records[i])
+				continue
+			}
+			if err := logRecordAccess(tx, staffID, orgID, &records[i], reason); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
+
+// GetPatientRecord returns one of a consenting patient's records to a
+// member of the org. Blocked records are refused, and sensitive ones
+// require a reason, which is logged for the patient to see.
+func (ors *OrganizationService) GetPatientRecord(ctx context.Context, staffID, orgID, recordID, reason string) (*models.HealthRecord, error) {
+	if _, err := ors.requireMembership(ctx, staffID, orgID, models.OrgRoleStaff, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+
+	var record models.HealthRecord
+	if err := ors.db.WithContext(ctx).Scopes(scopeOrgConsented(orgID)).First(&record, "id = ?", recordID).Error; err != nil {
+		return nil, fmt.Errorf("%w: record %s", ErrNotFound, recordID)
+	}
+	if err := authorizeRecordRead(staffID, &record, reason); err != nil {
+		return nil, err
+	}
+	if err := logRecordAccess(ors.db.WithContext(ctx), staffID, orgID, &record, reason); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ListPatientChanges returns the change feed of a consenting patient to a
+// member of the org, oldest first. Changes to blocked records are left
+// out, and changes to sensitive ones are listed without their summary.
+func (ors *OrganizationService) ListPatientChanges(ctx context.Context, staffID, orgID, patientID string, since time.Time, cursor string, limit int) (*ChangePage, error) {
+	if _, err := ors.requireMembership(ctx, staffID, orgID, models.OrgRoleStaff, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+	if !ors.hasConsent(ctx, orgID, patientID) {
+		return nil, fmt.Errorf("%w: patient has not granted consent", ErrPermissionDenied)
+	}
+
+	query := ors.db.WithContext(ctx).Model(&models.ChangeEvent{}).
+		Scopes(scopeOrgConsented(orgID), scopeOwner(patientID), scopeNotBlocked())
+	page, err := listChanges(query, since, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Events {
+		if page.Events[i].Sensitivity == models.SensitivitySensitive {
+			page.Events[i].Summary = ""
+		}
+	}
+	return page, nil
+}
+
+// redactRecord strips the contents of a sensitive record listed without a
+// reason, leaving enough for the reader to know it exists and ask for it
+func redactRecord(record *models.HealthRecord) {
+	record.Title = "Sensitive record"
+	record.Description = ""
+	record.Metadata = ""
+}
+
+// requireMembership returns userID's membership in orgID if it has one of roles
+func (ors *OrganizationService) requireMembership(ctx context.Context, userID, orgID string, roles ...string) (*models.OrgMembership, error) {
+	var membership models.OrgMembership
+	if err := ors.db.WithContext(ctx).Scopes(scopeOrg(orgID)).Where("user_id = ?", userID).First(&membership).Error; err != nil {
+		return nil, fmt.Errorf("%w: not a member of organization", ErrPermissionDenied)
+	}
+	for _, role := range roles {
+		if membership.Role == role {
+			return &membership, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: requires role %s", ErrPermissionDenied, strings.Join(roles, " or "))
+}
+
+func (ors *OrganizationService) hasConsent(ctx context.Context, orgID, patientID string) bool {
+	var count int64
+	ors.db.WithContext(ctx).Model(&models.OrgConsent{}).
+		Scopes(scopeOrg(orgID)).
+		Where("patient_id = ? AND revoked_at IS NULL", patientID).
+		Count(&count)
+	return count > 0
+}
+
+func generateInviteToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate invite token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,463 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// orgFixture is two organizations, each with an admin and a staff member,
+// and patients consenting to one org, the other, or neither
+type orgFixture struct {
+	db  *gorm.DB
+	ors *OrganizationService
+
+	orgA, orgB       string
+	adminA, staffA   string
+	adminB, staffB   string
+	patientA         string // consents to orgA
+	patientB         string // consents to orgB
+	patientNoConsent string
+}
+
+func newOrgFixture(t *testing.T) *orgFixture {
+	t.Helper()
+	db := newTestDB(t)
+	f := &orgFixture{
+		db:               db,
+		ors:              NewOrganizationService(db, newTestDeliveryQueue(db)),
+		adminA:           "admin-a",
+		staffA:           "staff-a",
+		adminB:           "admin-b",
+		staffB:           "staff-b",
+		patientA:         "patient-a",
+		patientB:         "patient-b",
+		patientNoConsent: "patient-none",
+	}
+	for _, id := range []string{f.adminA, f.staffA, f.adminB, f.staffB, f.patientA, f.patientB, f.patientNoConsent} {
+		createUser(t, db, id)
+	}
+	f.orgA = f.createOrg(t, f.adminA, "Clinic A", f.staffA)
+	f.orgB = f.createOrg(t, f.adminB, "Clinic B", f.staffB)
+
+	for patient, org := range map[string]string{f.patientA: f.orgA, f.patientB: f.orgB} {
+		if _, err := f.ors.GrantConsent(context.Background(), patient, org); err != nil {
+			t.Fatalf("GrantConsent: %v", err)
+		}
+	}
+	for _, patient := range []string{f.patientA, f.patientB, f.patientNoConsent} {
+		createRecord(t, db, patient+"-standard", patient, "")
+		createRecord(t, db, patient+"-sensitive", patient, models.SensitivitySensitive)
+		createRecord(t, db, patient+"-blocked", patient, models.SensitivityBlocked)
+	}
+	return f
+}
+
+// createOrg creates an org whose admin invites staff, who accept
+func (f *orgFixture) createOrg(t *testing.T, adminID, name, staffID string) string {
+	t.Helper()
+	ctx := context.Background()
+	org, err := f.ors.CreateOrganization(ctx, adminID, name)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	invite, err := f.ors.InviteMember(ctx, adminID, org.ID, staffID+"@example.com", models.OrgRoleStaff)
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	if _, err := f.ors.AcceptInvite(ctx, staffID, inviteToken(t, f.db, invite)); err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	return org.ID
+}
+
+// inviteToken returns the token carried by invite's queued email
+func inviteToken(t *testing.T, db *gorm.DB, invite *CreatedInvite) string {
+	t.Helper()
+	var delivery models.Delivery
+	if err := db.First(&delivery, "id = ?", invite.DeliveryID).Error; err != nil {
+		t.Fatalf("no delivery %s: %v", invite.DeliveryID, err)
+	}
+	_, rest, ok := strings.Cut(delivery.Body, orgInviteLinkPrefix)
+	if !ok {
+		t.Fatalf("invite email carries no link: %q", delivery.Body)
+	}
+	return strings.Fields(rest)[0]
+}
+
+func recordIDs(records []models.HealthRecord) []string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+func TestInviteMemberQueuesInviteEmail(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	invite, err := f.ors.InviteMember(ctx, f.adminA, f.orgA, " New.Nurse@Example.com ", models.OrgRoleStaff)
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	if invite.Invite.Email != "new.nurse@example.com" {
+		t.Errorf("invite email = %q, want it normalized", invite.Invite.Email)
+	}
+
+	var delivery models.Delivery
+	if err := f.db.First(&delivery, "id = ?", invite.DeliveryID).Error; err != nil {
+		t.Fatalf("no delivery under reference %q: %v", invite.DeliveryID, err)
+	}
+	if delivery.Channel != models.DeliveryChannelEmail || delivery.Recipient != invite.Invite.Email || delivery.Status != models.DeliveryStatusPending {
+		t.Errorf("delivery = %+v, want a pending email to %s", delivery, invite.Invite.Email)
+	}
+	if !strings.Contains(delivery.Body, orgInviteLinkPrefix+inviteToken(t, f.db, invite)) {
+		t.Errorf("email body does not carry the invite link:\n%s", delivery.Body)
+	}
+	// The email carries the only copy of the token
+	if !delivery.Sensitive {
+		t.Error("invite email not marked sensitive, so its token would outlive the send")
+	}
+	var stored models.OrgInvite
+	if err := f.db.First(&stored, "id = ?", invite.Invite.ID).Error; err != nil {
+		t.Fatalf("load invite: %v", err)
+	}
+	if stored.TokenHash != hashToken(inviteToken(t, f.db, invite)) {
+		t.Errorf("stored token hash = %q, want the hash of the token", stored.TokenHash)
+	}
+	if !strings.Contains(delivery.Subject, "Clinic A") {
+		t.Errorf("subject %q does not name the organization", delivery.Subject)
+	}
+}
+
+func TestInviteMemberRequiresOrgAdmin(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		actorID string
+		orgID   string
+		role    string
+		want    error
+	}{
+		{"staff cannot invite", f.staffA, f.orgA, models.OrgRoleStaff, ErrPermissionDenied},
+		{"admin of another org", f.adminB, f.orgA, models.OrgRoleStaff, ErrPermissionDenied},
+		{"patient cannot invite", f.patientA, f.orgA, OrgInviteRolePatient, ErrPermissionDenied},
+		{"unknown role", f.adminA, f.orgA, "owner", ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.ors.InviteMember(ctx, tt.actorID, tt.orgID, "someone@example.com", tt.role)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("InviteMember() error = %v, want %v", err, tt.want)
+			}
+			var count int64
+			f.db.Model(&models.Delivery{}).Where("recipient = ?", "someone@example.com").Count(&count)
+			if count != 0 {
+				t.Errorf("queued %d emails for a refused invite", count)
+			}
+		})
+	}
+}
+
+func TestAcceptInvite(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+	createUser(t, f.db, "newcomer")
+
+	invite, err := f.ors.InviteMember(ctx, f.adminA, f.orgA, "newcomer@example.com", models.OrgRoleStaff)
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	if _, err := f.ors.AcceptInvite(ctx, f.patientNoConsent, inviteToken(t, f.db, invite)); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("accepting someone else's invite: error = %v, want ErrPermissionDenied", err)
+	}
+	if _, err := f.ors.AcceptInvite(ctx, f.staffB, inviteToken(t, f.db, invite)); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("accepting with another email: error = %v, want ErrPermissionDenied", err)
+	}
+
+	user, err := f.ors.AcceptInvite(ctx, "newcomer", inviteToken(t, f.db, invite))
+	if err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	if user.OrgID != f.orgA {
+		t.Errorf("user org = %q, want %q", user.OrgID, f.orgA)
+	}
+	if _, err := f.ors.requireMembership(ctx, "newcomer", f.orgA, models.OrgRoleStaff); err != nil {
+		t.Errorf("accepted staff invite did not create a membership: %v", err)
+	}
+	if _, err := f.ors.AcceptInvite(ctx, "newcomer", inviteToken(t, f.db, invite)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("reusing an accepted invite: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAcceptInviteRejectsMemberOfAnotherOrg(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	invite, err := f.ors.InviteMember(ctx, f.adminA, f.orgA, f.staffB+"@example.com", models.OrgRoleStaff)
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	if _, err := f.ors.AcceptInvite(ctx, f.staffB, inviteToken(t, f.db, invite)); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("AcceptInvite() error = %v, want ErrAlreadyExists", err)
+	}
+}
+
+func TestListPatientRecordsRequiresConsent(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		actorID   string
+		orgID     string
+		patientID string
+		want      error
+	}{
+		{"staff, consenting patient", f.staffA, f.orgA, f.patientA, nil},
+		{"admin, consenting patient", f.adminA, f.orgA, f.patientA, nil},
+		{"patient of another org", f.staffA, f.orgA, f.patientB, ErrPermissionDenied},
+		{"patient without consent", f.staffA, f.orgA, f.patientNoConsent, ErrPermissionDenied},
+		{"staff naming another org", f.staffA, f.orgB, f.patientB, ErrPermissionDenied},
+		{"patient is not staff", f.patientA, f.orgA, f.patientA, ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, _, err := f.ors.ListPatientRecords(ctx, tt.actorID, tt.orgID, tt.patientID, "", 0, 0)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("ListPatientRecords() error = %v, want %v", err, tt.want)
+			}
+			for _, record := range records {
+				if record.UserID != tt.patientID {
+					t.Errorf("returned record %s of %s", record.ID, record.UserID)
+				}
+			}
+		})
+	}
+}
+
+func TestListPatientRecordsHidesBlockedAndRedactsSensitive(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	records, total, err := f.ors.ListPatientRecords(ctx, f.staffA, f.orgA, f.patientA, "", 0, 0)
+	if err != nil {
+		t.Fatalf("ListPatientRecords: %v", err)
+	}
+	if total != 2 || len(records) != 2 {
+		t.Fatalf("got %d of %d records %v, want the standard and sensitive ones", len(records), total, recordIDs(records))
+	}
+	for _, record := range records {
+		switch record.ID {
+		case f.patientA + "-sensitive":
+			if record.Description != "" || record.Metadata != "" {
+				t.Errorf("sensitive record listed without a reason kept its contents: %+v", record)
+			}
+		case f.patientA + "-standard":
+			if record.Description == "" {
+				t.Error("standard record lost its contents")
+			}
+		default:
+			t.Errorf("unexpected record %s", record.ID)
+		}
+	}
+
+	var logged int64
+	f.db.Model(&models.RecordAccessLog{}).Count(&logged)
+	if logged != 0 {
+		t.Errorf("logged %d accesses for a listing without a reason", logged)
+	}
+
+	records, _, err = f.ors.ListPatientRecords(ctx, f.staffA, f.orgA, f.patientA, "follow-up visit", 0, 0)
+	if err != nil {
+		t.Fatalf("ListPatientRecords with reason: %v", err)
+	}
+	for _, record := range records {
+		if record.ID == f.patientA+"-sensitive" && record.Description == "" {
+			t.Error("sensitive record listed with a reason was redacted")
+		}
+	}
+	var entry models.RecordAccessLog
+	if err := f.db.First(&entry).Error; err != nil {
+		t.Fatalf("reading a sensitive record was not logged: %v", err)
+	}
+	if entry.OwnerID != f.patientA || entry.ActorID != f.staffA || entry.OrgID != f.orgA || entry.Reason != "follow-up visit" {
+		t.Errorf("access log entry = %+v", entry)
+	}
+}
+
+func TestGetPatientRecordIsConsentScoped(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		actorID  string
+		orgID    string
+		recordID string
+		reason   string
+		want     error
+	}{
+		{"consenting patient", f.staffA, f.orgA, f.patientA + "-standard", "", nil},
+		{"sensitive without reason", f.staffA, f.orgA, f.patientA + "-sensitive", "", ErrAccessReasonRequired},
+		{"sensitive with reason", f.staffA, f.orgA, f.patientA + "-sensitive", "lab review", nil},
+		{"blocked", f.staffA, f.orgA, f.patientA + "-blocked", "lab review", ErrPermissionDenied},
+		{"record of another org's patient", f.staffA, f.orgA, f.patientB + "-standard", "", ErrNotFound},
+		{"record without consent", f.staffA, f.orgA, f.patientNoConsent + "-standard", "", ErrNotFound},
+		{"staff naming another org", f.staffA, f.orgB, f.patientB + "-standard", "", ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := f.ors.GetPatientRecord(ctx, tt.actorID, tt.orgID, tt.recordID, tt.reason)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("GetPatientRecord() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && record.ID != tt.recordID {
+				t.Errorf("got record %s, want %s", record.ID, tt.recordID)
+			}
+		})
+	}
+}
+
+func TestRevokeConsentEndsAccess(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	if err := f.ors.RevokeConsent(ctx, f.patientA, f.orgA); err != nil {
+		t.Fatalf("RevokeConsent: %v", err)
+	}
+	if _, _, err := f.ors.ListPatientRecords(ctx, f.staffA, f.orgA, f.patientA, "", 0, 0); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("ListPatientRecords after revoke: error = %v, want ErrPermissionDenied", err)
+	}
+	if _, err := f.ors.GetPatientRecord(ctx, f.staffA, f.orgA, f.patientA+"-standard", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPatientRecord after revoke: error = %v, want ErrNotFound", err)
+	}
+	if _, err := f.ors.ListPatientChanges(ctx, f.staffA, f.orgA, f.patientA, time.Time{}, "", 0); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("ListPatientChanges after revoke: error = %v, want ErrPermissionDenied", err)
+	}
+	if err := f.ors.RevokeConsent(ctx, f.patientA, f.orgA); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking twice: error = %v, want ErrNotFound", err)
+	}
+
+	patients, err := f.ors.ListConsentingPatients(ctx, f.staffA, f.orgA)
+	if err != nil {
+		t.Fatalf("ListConsentingPatients: %v", err)
+	}
+	if len(patients) != 0 {
+		t.Errorf("revoked patient still listed: %v", patients)
+	}
+
+	if _, err := f.ors.GrantConsent(ctx, f.patientA, f.orgA); err != nil {
+		t.Fatalf("GrantConsent again: %v", err)
+	}
+	if _, err := f.ors.GetPatientRecord(ctx, f.staffA, f.orgA, f.patientA+"-standard", ""); err != nil {
+		t.Errorf("GetPatientRecord after consenting again: %v", err)
+	}
+}
+
+func TestScopeOrgConsented(t *testing.T) {
+	f := newOrgFixture(t)
+
+	var records []models.HealthRecord
+	if err := f.db.Scopes(scopeOrgConsented(f.orgA)).Order("id").Find(&records).Error; err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	for _, record := range records {
+		if record.UserID != f.patientA {
+			t.Errorf("org A scope returned record %s of %s", record.ID, record.UserID)
+		}
+	}
+	if len(records) != 3 {
+		t.Errorf("org A scope returned %v, want all of %s's records", recordIDs(records), f.patientA)
+	}
+
+	if err := f.db.Scopes(scopeOrgConsented("no-such-org")).Find(&records).Error; err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("unknown org scope returned %v", recordIDs(records))
+	}
+}
+
+func TestGetOrgStatsIsOrgScoped(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	stats, err := f.ors.GetOrgStats(ctx, f.adminA, f.orgA)
+	if err != nil {
+		t.Fatalf("GetOrgStats: %v", err)
+	}
+	want := OrgStats{Admins: 1, Staff: 1, ConsentingPatients: 1, Records: 2, SensitiveRecords: 1}
+	if *stats != want {
+		t.Errorf("stats = %+v, want %+v", *stats, want)
+	}
+
+	// A sensitive record the patient then blocks is no longer counted,
+	// sensitive or otherwise
+	if err := f.db.Model(&models.HealthRecord{}).Where("id = ?", f.patientA+"-sensitive").
+		Update("sensitivity", models.SensitivityBlocked).Error; err != nil {
+		t.Fatalf("block record: %v", err)
+	}
+	stats, err = f.ors.GetOrgStats(ctx, f.adminA, f.orgA)
+	if err != nil {
+		t.Fatalf("GetOrgStats: %v", err)
+	}
+	if stats.Records != 1 || stats.SensitiveRecords != 0 {
+		t.Errorf("stats after blocking the sensitive record = %+v, want 1 record and no sensitive ones", *stats)
+	}
+
+	if err := f.ors.RevokeConsent(ctx, f.patientA, f.orgA); err != nil {
+		t.Fatalf("RevokeConsent: %v", err)
+	}
+	stats, err = f.ors.GetOrgStats(ctx, f.adminA, f.orgA)
+	if err != nil {
+		t.Fatalf("GetOrgStats: %v", err)
+	}
+	if stats.ConsentingPatients != 0 || stats.Records != 0 || stats.SensitiveRecords != 0 {
+		t.Errorf("stats after revoke = %+v, want no patients or records", *stats)
+	}
+
+	for _, actor := range []string{f.staffA, f.adminB} {
+		if _, err := f.ors.GetOrgStats(ctx, actor, f.orgA); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("GetOrgStats as %s: error = %v, want ErrPermissionDenied", actor, err)
+		}
+	}
+}
+
+func TestExportRecordsIsOrgScoped(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+
+	records, truncated, err := f.ors.ExportRecords(ctx, f.adminB, f.orgB)
+	if err != nil {
+		t.Fatalf("ExportRecords: %v", err)
+	}
+	if truncated {
+		t.Error("small export reported as truncated")
+	}
+	if len(records) != 2 {
+		t.Fatalf("exported %v, want %s's standard and sensitive records", recordIDs(records), f.patientB)
+	}
+	for _, record := range records {
+		if record.UserID != f.patientB {
+			t.Errorf("exported record %s of %s", record.ID, record.UserID)
+		}
+		if record.Sensitivity == models.SensitivitySensitive && record.Description != "" {
+			t.Errorf("sensitive record %s exported with its contents", record.ID)
+		}
+	}
+
+	for _, actor := range []string{f.staffB, f.adminA} {
+		if _, _, err := f.ors.ExportRecords(ctx, actor, f.orgB); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("ExportRecords as %s: error = %v, want ErrPermissionDenied", actor, err)
+		}
+	}
+}
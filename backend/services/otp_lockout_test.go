@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// wrongCode returns a code that is not code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func newLockoutTestAuth(t *testing.T, maxAttempts int) (*AuthService, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)}
+	cfg := &config.AuthConfig{OTPMaxAttempts: maxAttempts, OTPLockout: 900}
+	return newTestAuthService(newTestDB(t), cfg, clock), clock
+}
+
+// sendCode requests a code for email and returns it
+func sendCode(t *testing.T, as *AuthService, email string) string {
+	t.Helper()
+	reference, _, err := as.SendOTP(context.Background(), email)
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	return sentOTP(t, as.db, reference)
+}
+
+func TestOTPLockout(t *testing.T) {
+	as, clock := newLockoutTestAuth(t, 5)
+	ctx := context.Background()
+	code := sendCode(t, as, "guess@example.com")
+
+	for i := 1; i < 5; i++ {
+		_, _, _, err := as.VerifyOTP(ctx, "guess@example.com", wrongCode(code), "device-1")
+		if err == nil || errors.Is(err, ErrOTPAttempts) {
+			t.Fatalf("wrong code %d: error = %v, want a plain rejection", i, err)
+		}
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "guess@example.com", wrongCode(code), "device-1"); !errors.Is(err, ErrOTPAttempts) {
+		t.Fatalf("fifth wrong code: error = %v, want %v", err, ErrOTPAttempts)
+	}
+
+	// The code the guesses were aimed at is gone, and the email is locked
+	if _, _, _, err := as.VerifyOTP(ctx, "guess@example.com", code, "device-1"); !errors.Is(err, ErrOTPAttempts) {
+		t.Errorf("right code while locked out: error = %v, want %v", err, ErrOTPAttempts)
+	}
+	if _, _, err := as.SendOTP(ctx, "guess@example.com"); !errors.Is(err, ErrOTPAttempts) {
+		t.Errorf("new code requested mid-lockout: error = %v, want %v", err, ErrOTPAttempts)
+	}
+
+	// Other emails are not affected
+	other := sendCode(t, as, "other@example.com")
+	if _, _, _, err := as.VerifyOTP(ctx, "other@example.com", other, "device-2"); err != nil {
+		t.Errorf("another email during the lockout: %v", err)
+	}
+
+	clock.Advance(900 * time.Second)
+	if _, _, _, err := as.VerifyOTP(ctx, "guess@example.com", code, "device-1"); err == nil || errors.Is(err, ErrOTPAttempts) {
+		t.Errorf("code invalidated by the lockout: error = %v, want a plain rejection", err)
+	}
+	fresh := sendCode(t, as, "guess@example.com")
+	if _, _, _, err := as.VerifyOTP(ctx, "guess@example.com", fresh, "device-1"); err != nil {
+		t.Errorf("new code after the lockout: %v", err)
+	}
+}
+
+func TestOTPRightCodeOnTheLastAttempt(t *testing.T) {
+	as, _ := newLockoutTestAuth(t, 5)
+	ctx := context.Background()
+	code := sendCode(t, as, "last@example.com")
+
+	for i := 1; i < 5; i++ {
+		as.VerifyOTP(ctx, "last@example.com", wrongCode(code), "device-1")
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "last@example.com", code, "device-1"); err != nil {
+		t.Fatalf("right code on the last allowed attempt: %v", err)
+	}
+
+	// Signing in cleared the count
+	code = sendCode(t, as, "last@example.com")
+	for i := 1; i < 5; i++ {
+		if _, _, _, err := as.VerifyOTP(ctx, "last@example.com", wrongCode(code), "device-1"); errors.Is(err, ErrOTPAttempts) {
+			t.Fatalf("wrong code %d after signing in locked the email out", i)
+		}
+	}
+}
+
+func TestOTPFailuresExpireWithTheCodes(t *testing.T) {
+	as, clock := newLockoutTestAuth(t, 3)
+	ctx := context.Background()
+	code := sendCode(t, as, "slow@example.com")
+
+	as.VerifyOTP(ctx, "slow@example.com", wrongCode(code), "device-1")
+	as.VerifyOTP(ctx, "slow@example.com", wrongCode(code), "device-1")
+	// Every code those guesses could have been aimed at has expired
+	clock.Advance(601 * time.Second)
+	code = sendCode(t, as, "slow@example.com")
+	if _, _, _, err := as.VerifyOTP(ctx, "slow@example.com", wrongCode(code), "device-1"); errors.Is(err, ErrOTPAttempts) {
+		t.Error("guesses at expired codes still counted towards the lockout")
+	}
+}
+
+func TestOTPLockoutDisabled(t *testing.T) {
+	as, _ := newLockoutTestAuth(t, 0)
+	ctx := context.Background()
+	code := sendCode(t, as, "open@example.com")
+
+	for i := 0; i < 10; i++ {
+		if _, _, _, err := as.VerifyOTP(ctx, "open@example.com", wrongCode(code), "device-1"); errors.Is(err, ErrOTPAttempts) {
+			t.Fatalf("wrong code %d locked the email out with the limit disabled", i+1)
+		}
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "open@example.com", code, "device-1"); err != nil {
+		t.Errorf("right code after many wrong ones: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// newRateTestAuth returns an AuthService sending at most three codes an
+// email in any 15 minutes, each good for 10
+func newRateTestAuth(t *testing.T) (*AuthService, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)}
+	cfg := &config.AuthConfig{MaxOTPPerWindow: 3, RateLimitWindow: 900, OTPExpiry: 600}
+	return newTestAuthService(newTestDB(t), cfg, clock), clock
+}
+
+func TestSendOTPRateLimit(t *testing.T) {
+	as, clock := newRateTestAuth(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		sendCode(t, as, "a@example.com")
+		clock.Advance(time.Minute)
+	}
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPRateLimited) {
+		t.Fatalf("fourth OTP inside the window: error = %v, want ErrOTPRateLimited", err)
+	}
+	if _, _, err := as.SendOTP(ctx, "b@example.com"); err != nil {
+		t.Fatalf("another email shares the limit: %v", err)
+	}
+
+	// The first code leaves the window 15 minutes after it was sent, and
+	// frees one slot
+	clock.Advance(12*time.Minute + time.Second)
+	sendCode(t, as, "a@example.com")
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPRateLimited) {
+		t.Fatalf("OTP with only one slot freed: error = %v, want ErrOTPRateLimited", err)
+	}
+
+	// Once every code has left the window, the full allowance is back
+	clock.Advance(15 * time.Minute)
+	for i := 0; i < 3; i++ {
+		sendCode(t, as, "a@example.com")
+	}
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPRateLimited) {
+		t.Fatalf("OTP over the limit in the new window: error = %v, want ErrOTPRateLimited", err)
+	}
+}
+
+func TestFailedVerifyDoesNotResetOTPRateLimit(t *testing.T) {
+	as, clock := newRateTestAuth(t)
+	ctx := context.Background()
+
+	var codes []string
+	for i := 0; i < 3; i++ {
+		codes = append(codes, sendCode(t, as, "a@example.com"))
+		clock.Advance(time.Minute)
+	}
+
+	// The first code has expired but is still inside the window
+	clock.Advance(9 * time.Minute)
+	if _, _, _, err := as.VerifyOTP(ctx, "a@example.com", codes[0], "device-1"); err == nil {
+		t.Fatal("expired code signed in")
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "a@example.com", wrongCode(codes[2]), "device-1"); err == nil {
+		t.Fatal("wrong code signed in")
+	}
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPRateLimited) {
+		t.Fatalf("OTP after failed verifies: error = %v, want ErrOTPRateLimited", err)
+	}
+
+	// Nor does the purge job free slots inside the window
+	if _, err := as.PurgeExpiredOTPs(ctx); err != nil {
+		t.Fatalf("PurgeExpiredOTPs: %v", err)
+	}
+	if _, _, err := as.SendOTP(ctx, "a@example.com"); !errors.Is(err, ErrOTPRateLimited) {
+		t.Fatalf("OTP after the purge: error = %v, want ErrOTPRateLimited", err)
+	}
+}
+
+func TestSendOTPRateLimitDisabled(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(db, &config.AuthConfig{RateLimitWindow: 900}, &testClock{now: time.Now()})
+	for i := 0; i < 10; i++ {
+		if _, _, err := as.SendOTP(context.Background(), "a@example.com"); err != nil {
+			t.Fatalf("OTP %d with no limit: %v", i+1, err)
+		}
+	}
+}
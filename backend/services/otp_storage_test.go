@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestStoredOTPIsNotPlaintext(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Now()}
+	as := newTestAuthService(db, &config.AuthConfig{}, clock)
+	ctx := context.Background()
+
+	var codes []string
+	for i := 0; i < 3; i++ {
+		reference, _, err := as.SendOTP(ctx, "reader@example.com")
+		if err != nil {
+			t.Fatalf("SendOTP: %v", err)
+		}
+		codes = append(codes, sentOTP(t, db, reference))
+	}
+
+	// Read the rows as stored, every column, not through the model
+	var rows []map[string]interface{}
+	if err := db.Raw("SELECT * FROM otp_stores").Scan(&rows).Error; err != nil {
+		t.Fatalf("read otp_stores: %v", err)
+	}
+	if len(rows) != len(codes) {
+		t.Fatalf("%d stored codes, want %d", len(rows), len(codes))
+	}
+	for _, row := range rows {
+		if _, ok := row["otp"]; ok {
+			t.Error("otp_stores still has an otp column")
+		}
+		for column, value := range row {
+			if strings.HasSuffix(column, "_at") {
+				continue // timestamps are digits, and may hold a code's by chance
+			}
+			for _, code := range codes {
+				if strings.Contains(fmt.Sprint(value), code) {
+					t.Errorf("column %s holds the code %s: %v", column, code, value)
+				}
+			}
+		}
+		hash := fmt.Sprint(row["otp_hash"])
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+			t.Errorf("otp_hash = %q, want a hex SHA-256", hash)
+		}
+	}
+
+	// The hash is salted per row and keyed with the server secret
+	if as.hashOTP("row-1", "123456") == as.hashOTP("row-2", "123456") {
+		t.Error("the same code hashes the same in two rows")
+	}
+	other := newTestAuthService(db, &config.AuthConfig{JWTSecret: "another-secret"}, clock)
+	if as.hashOTP("row-1", "123456") == other.hashOTP("row-1", "123456") {
+		t.Error("the hash does not depend on the server secret")
+	}
+}
+
+func TestHashedOTPSignsInOnce(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(db, &config.AuthConfig{}, &testClock{now: time.Now()})
+	ctx := context.Background()
+
+	first, _, err := as.SendOTP(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	second, _, err := as.SendOTP(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+
+	// Either pending code signs in, and only once
+	code := sentOTP(t, db, first)
+	user, access, refresh, err := as.VerifyOTP(ctx, "reader@example.com", code, "device-1")
+	if err != nil || user.Email != "reader@example.com" || access == "" || refresh == "" {
+		t.Fatalf("VerifyOTP = %v, %q, %q, %v", user, access, refresh, err)
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", code, "device-1"); err == nil {
+		t.Error("a used code signed in again")
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", sentOTP(t, db, second), "device-2"); err != nil {
+		t.Errorf("the other pending code: %v", err)
+	}
+
+	// A code is only good for the email it was sent to
+	reference, _, err := as.SendOTP(ctx, "other@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", sentOTP(t, db, reference), "device-1"); err == nil {
+		t.Error("another email's code signed in")
+	}
+}
+
+func TestConcurrentUsesOfOneOTP(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(db, &config.AuthConfig{}, &testClock{now: time.Now()})
+	ctx := context.Background()
+	reference, _, err := as.SendOTP(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	code := sentOTP(t, db, reference)
+
+	const attempts = 8
+	var wg sync.WaitGroup
+	results := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, _, err := as.VerifyOTP(ctx, "reader@example.com", code, fmt.Sprintf("device-%d", i))
+			results <- err
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("the code signed in %d times, want once", succeeded)
+	}
+}
+
+func TestExpiredOTPsAreKeptUntilPurged(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Now()}
+	as := newTestAuthService(db, &config.AuthConfig{RateLimitWindow: 900}, clock)
+	ctx := context.Background()
+
+	stale, _, err := as.SendOTP(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	clock.Advance(5 * time.Minute)
+	fresh, _, err := as.SendOTP(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	clock.Advance(6 * time.Minute) // the first code expired, the second has not
+
+	pending := func() []models.OTPStore {
+		var rows []models.OTPStore
+		db.Where("email = ?", "reader@example.com").Find(&rows)
+		return rows
+	}
+
+	// The expired code is refused as expired, but kept while the rate limit
+	// still counts it
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", sentOTP(t, db, stale), "device-1"); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired code: %v, want it refused as expired", err)
+	}
+	if rows := pending(); len(rows) != 2 {
+		t.Fatalf("%d codes pending after an expired one was tried, want 2", len(rows))
+	}
+
+	// A wrong code deletes nothing either
+	clock.Advance(5 * time.Minute)
+	code := sentOTP(t, db, fresh)
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", wrongCode(code), "device-1"); err == nil {
+		t.Fatal("wrong code signed in")
+	}
+	if rows := pending(); len(rows) != 2 {
+		t.Errorf("%d codes pending after a wrong code, want 2", len(rows))
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", code, "device-1"); err == nil {
+		t.Error("an expired code signed in")
+	}
+
+	// Once both have left the rate window, the purge job removes them
+	clock.Advance(10 * time.Minute)
+	if _, err := as.PurgeExpiredOTPs(ctx); err != nil {
+		t.Fatalf("PurgeExpiredOTPs: %v", err)
+	}
+	if rows := pending(); len(rows) != 0 {
+		t.Errorf("%d codes pending after the purge, want none", len(rows))
+	}
+}
+
+func TestSentOTPIsClearedFromTheQueue(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(db, &config.AuthConfig{}, &testClock{now: time.Now()})
+	ctx := context.Background()
+
+	reference, _, err := as.SendOTP(ctx, "reader@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	code := sentOTP(t, db, reference)
+	if _, err := as.deliveries.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+
+	// Read the queue as stored: once sent, no column holds the code
+	var rows []map[string]interface{}
+	if err := db.Raw("SELECT * FROM deliveries").Scan(&rows).Error; err != nil {
+		t.Fatalf("read deliveries: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("%d queued messages, want 1", len(rows))
+	}
+	for column, value := range rows[0] {
+		if strings.HasSuffix(column, "_at") {
+			continue
+		}
+		if strings.Contains(fmt.Sprint(value), code) {
+			t.Errorf("column %s of the sent message holds the code: %v", column, value)
+		}
+	}
+	if rows[0]["status"] != models.DeliveryStatusSent {
+		t.Errorf("message status = %v, want sent", rows[0]["status"])
+	}
+
+	// The code still signs in; only the copy in the queue is gone
+	if _, _, _, err := as.VerifyOTP(ctx, "reader@example.com", code, "device-1"); err != nil {
+		t.Errorf("VerifyOTP after delivery: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestGenerateOTPLength(t *testing.T) {
+	for length := 4; length <= 8; length++ {
+		for i := 0; i < 1000; i++ {
+			code, err := generateOTP(length)
+			if err != nil {
+				t.Fatalf("generateOTP(%d): %v", length, err)
+			}
+			if len(code) != length {
+				t.Fatalf("generateOTP(%d) = %q, want %d digits", length, code, length)
+			}
+			if _, err := strconv.ParseUint(code, 10, 64); err != nil {
+				t.Fatalf("generateOTP(%d) = %q, want only digits", length, code)
+			}
+		}
+	}
+	for _, length := range []int{0, -1} {
+		if code, err := generateOTP(length); err == nil {
+			t.Errorf("generateOTP(%d) = %q, want an error", length, code)
+		}
+	}
+}
+
+// TestGenerateOTPDistribution checks every digit position is uniform over
+// 0-9, leading zeros included, and that codes are spread over the whole
+// space rather than a few hundred values. The chi-squared bound is the
+// 99.99th percentile for 9 degrees of freedom, so a correct generator
+// fails about once in ten thousand runs per position.
+func TestGenerateOTPDistribution(t *testing.T) {
+	const samples = 20000
+	const chiSquaredBound = 33.72
+	for length := 4; length <= 8; length++ {
+		counts := make([][10]int, length)
+		distinct := make(map[string]bool, samples)
+		for i := 0; i < samples; i++ {
+			code, err := generateOTP(length)
+			if err != nil {
+				t.Fatalf("generateOTP(%d): %v", length, err)
+			}
+			distinct[code] = true
+			for pos, digit := range code {
+				counts[pos][digit-'0']++
+			}
+		}
+
+		expected := float64(samples) / 10
+		for pos, digits := range counts {
+			var chiSquared float64
+			for _, n := range digits {
+				d := float64(n) - expected
+				chiSquared += d * d / expected
+			}
+			if chiSquared > chiSquaredBound {
+				t.Errorf("length %d, position %d: digit counts %v are not uniform (chi-squared %.1f)", length, pos, digits, chiSquared)
+			}
+		}
+
+		// Drawing uniformly from the 10^length codes gives
+		// 10^length·(1-e^(-samples/10^length)) distinct codes on average
+		space := math.Pow(10, float64(length))
+		want := space * (1 - math.Exp(-samples/space))
+		if float64(len(distinct)) < 0.98*want {
+			t.Errorf("length %d: %d distinct codes in %d, want about %.0f", length, len(distinct), samples, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Placeholders that replace redacted personal details
+const (
+	redactedName     = "[name]"
+	redactedEmail    = "[email]"
+	redactedPhone    = "[phone]"
+	redactedIDNumber = "[id number]"
+	redactedAddress  = "[address]"
+)
+
+// piiPatterns are replaced in order, so the more specific patterns run
+// before the broad digit runs that would otherwise swallow them
+var piiPatterns = []struct {
+	re          *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), redactedEmail},
+	// 123-45-6789 and similar grouped identifiers
+	{regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), redactedIDNumber},
+	// Starts on a word boundary so the tail of a longer digit run is not
+	// taken for a phone number
+	{regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?(?:\(\d{2,4}\)|\b\d{2,4})[\s.\-]?\d{3,4}[\s.\-]?\d{3,4}\b`), redactedPhone},
+	// Unbroken runs long enough to be an account, insurance or patient number
+	{regexp.MustCompile(`\b\d{8,}\b`), redactedIDNumber},
+	{regexp.MustCompile(`(?i)\b\d{1,5}\s+(?:[A-Z][a-z]+\s+){1,3}(?:street|st|road|rd|avenue|ave|lane|ln|drive|dr|boulevard|blvd|way|court|ct)\b\.?`), redactedAddress},
+	// Titled names such as "Dr. Patel" or "Mrs Jane Doe"
+	{regexp.MustCompile(`\b(?:Dr|Mr|Mrs|Ms|Miss|Prof)\.?\s+[A-Z][a-z]+(?:\s+[A-Z][a-z]+)?`), redactedName},
+}
+
+// redactPII replaces emails, phone numbers, ID numbers, street addresses,
+// titled names and every part of the given names with neutral
+// placeholders. It is one-way: the originals cannot be recovered from the
+// result. Medical content such as dosages and dates is left alone.
+func redactPII(text string, names ...string) string {
+	if text == "" {
+		return text
+	}
+	for _, p := range piiPatterns {
+		text = p.re.ReplaceAllString(text, p.placeholder)
+	}
+	for _, name := range names {
+		for _, part := range strings.Fields(name) {
+			// Initials and short particles would match too much ordinary text
+			if len([]rune(part)) < 2 {
+				continue
+			}
+			re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(part) + `\b`)
+			text = re.ReplaceAllString(text, redactedName)
+		}
+	}
+	return text
+}
@@ -0,0 +1,39 @@
+package services
+
+import "testing"
+
+func TestRedactPII(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		names []string
+		want  string
+	}{
+		{"email", "Results sent to jane.doe+labs@example.co.uk today", nil, "Results sent to [email] today"},
+		{"phone with country code", "Call +44 20 7946 0958 to book", nil, "Call [phone] to book"},
+		{"phone with area code", "Clinic: (555) 123-4567", nil, "Clinic: [phone]"},
+		{"dotted phone", "Pharmacy 555.123.4567", nil, "Pharmacy [phone]"},
+		{"grouped ID number", "SSN 123-45-6789 on file", nil, "SSN [id number] on file"},
+		{"unbroken phone", "Mobile 07946095800", nil, "Mobile [phone]"},
+		{"long ID number", "Insurance no. 98765432101234", nil, "Insurance no. [id number]"},
+		{"street address", "Lives at 42 Baker Street, London", nil, "Lives at [address], London"},
+		{"abbreviated address", "Seen at 1600 Amphitheatre Pkwy and 221 Elm St.", nil, "Seen at 1600 Amphitheatre Pkwy and [address]"},
+		{"titled names", "Referred by Dr. Patel to Mrs Jane Doe", nil, "Referred by [name] to [name]"},
+		{"patient name anywhere", "JANE said doe-related pain; Jane Doe", []string{"Jane Doe"}, "[name] said [name]-related pain; [name] [name]"},
+		{"initials ignored", "J took a dose", []string{"J Smith"}, "J took a dose"},
+		{
+			"medical content kept",
+			"Amoxicillin 500mg twice daily for 7 days from 2026-10-12, HbA1c 6.1%, BP 120/80",
+			[]string{"Jane Doe"},
+			"Amoxicillin 500mg twice daily for 7 days from 2026-10-12, HbA1c 6.1%, BP 120/80",
+		},
+		{"empty", "", []string{"Jane"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactPII(tt.text, tt.names...); got != tt.want {
+				t.Errorf("redactPII(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
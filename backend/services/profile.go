@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// ProfileUpdate holds the profile fields to change. Nil fields are left as
+// they are; an empty string clears the field.
+type ProfileUpdate struct {
+	Name        *string
+	DateOfBirth *string // YYYY-MM-DD
+	Gender      *string
+	BloodType   *string
+}
+
+// GetProfile returns the user's profile
+func (as *AuthService) GetProfile(ctx context.Context, userID string) (*models.User, error) {
+	var user models.User
+	if err := as.db.WithContext(ctx).Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+	return &user, nil
+}
+
+// UpdateProfile changes the fields set in update and returns the updated
+// profile
+func (as *AuthService) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*models.User, error) {
+	updates, err := as.profileUpdates(update)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := as.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return user, nil
+	}
+	updates["updated_at"] = as.now()
+	if err := as.db.WithContext(ctx).Model(user).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update profile: %w", err)
+	}
+	return user, nil
+}
+
+// profileUpdates validates update and returns the columns it changes
+func (as *AuthService) profileUpdates(update ProfileUpdate) (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if utf8.RuneCountInString(name) > maxProfileNameLength {
+			return nil, fmt.Errorf("%w: name must be at most %d characters", ErrInvalidArgument, maxProfileNameLength)
+		}
+		updates["name"] = name
+	}
+	if update.DateOfBirth != nil {
+		dob := strings.TrimSpace(*update.DateOfBirth)
+		if dob != "" {
+			date, err := time.Parse("2006-01-02", dob)
+			if err != nil {
+				return nil, fmt.Errorf("%w: date_of_birth must be a date as YYYY-MM-DD", ErrInvalidArgument)
+			}
+			if date.After(as.now()) {
+				return nil, fmt.Errorf("%w: date_of_birth is in the future", ErrInvalidArgument)
+			}
+		}
+		updates["date_of_birth"] = dob
+	}
+	if update.Gender != nil {
+		if *update.Gender != "" && !profileGenders[*update.Gender] {
+			return nil, fmt.Errorf("%w: unknown gender %q", ErrInvalidArgument, *update.Gender)
+		}
+		updates["gender"] = *update.Gender
+	}
+	if update.BloodType != nil {
+		if *update.BloodType != "" && !profileBloodTypes[*update.BloodType] {
+			return nil, fmt.Errorf("%w: unknown blood type %q", ErrInvalidArgument, *update.BloodType)
+		}
+		updates["blood_type"] = *update.BloodType
+	}
+	return updates, nil
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+func ptr(s string) *string { return &s }
+
+func TestGetProfile(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAuthService(db, &config.AuthConfig{}, &testClock{now: time.Now()})
+	createUser(t, db, "user-1")
+
+	user, err := as.GetProfile(context.Background(), "user-1")
+	if err != nil || user.ID != "user-1" || user.Email != "user-1@example.com" {
+		t.Fatalf("GetProfile = %+v, %v", user, err)
+	}
+	if _, err := as.GetProfile(context.Background(), "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetProfile of an unknown user: %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}
+	as := newTestAuthService(db, &config.AuthConfig{}, clock)
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+
+	user, err := as.UpdateProfile(ctx, "user-1", ProfileUpdate{
+		Name:        ptr("  Ada Lovelace  "),
+		DateOfBirth: ptr("1990-12-10"),
+		Gender:      ptr("female"),
+		BloodType:   ptr("AB-"),
+	})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if user.Name != "Ada Lovelace" || user.DateOfBirth != "1990-12-10" || user.Gender != "female" || user.BloodType != "AB-" {
+		t.Errorf("profile = %+v", user)
+	}
+	if !user.UpdatedAt.Equal(clock.now) {
+		t.Errorf("UpdatedAt = %v, want %v", user.UpdatedAt, clock.now)
+	}
+
+	// Omitted fields are kept
+	clock.Advance(time.Hour)
+	if _, err := as.UpdateProfile(ctx, "user-1", ProfileUpdate{BloodType: ptr("O+")}); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	stored, err := as.GetProfile(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if stored.Name != "Ada Lovelace" || stored.DateOfBirth != "1990-12-10" || stored.Gender != "female" || stored.BloodType != "O+" {
+		t.Errorf("after changing only the blood type, profile = %+v", stored)
+	}
+
+	// An empty string clears a field
+	if _, err := as.UpdateProfile(ctx, "user-1", ProfileUpdate{Gender: ptr(""), DateOfBirth: ptr("")}); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	stored, _ = as.GetProfile(ctx, "user-1")
+	if stored.Gender != "" || stored.DateOfBirth != "" || stored.Name != "Ada Lovelace" {
+		t.Errorf("after clearing gender and date of birth, profile = %+v", stored)
+	}
+
+	// An update with nothing in it changes nothing
+	before := stored.UpdatedAt
+	clock.Advance(time.Hour)
+	user, err = as.UpdateProfile(ctx, "user-1", ProfileUpdate{})
+	if err != nil || !user.UpdatedAt.Equal(before) {
+		t.Errorf("empty update = %+v, %v; want the profile unchanged", user, err)
+	}
+
+	if _, err := as.UpdateProfile(ctx, "nobody", ProfileUpdate{Name: ptr("Ghost")}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateProfile of an unknown user: %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestUpdateProfileValidation(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}
+	as := newTestAuthService(db, &config.AuthConfig{}, clock)
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+	if _, err := as.UpdateProfile(ctx, "user-1", ProfileUpdate{Name: ptr("Ada"), BloodType: ptr("A+")}); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		update  ProfileUpdate
+		wantErr string
+	}{
+		{"name of 100 characters", ProfileUpdate{Name: ptr(strings.Repeat("é", 100))}, ""},
+		{"name of 101 characters", ProfileUpdate{Name: ptr(strings.Repeat("é", 101))}, "at most 100 characters"},
+		{"date of birth today", ProfileUpdate{DateOfBirth: ptr("2026-06-01")}, ""},
+		{"date of birth tomorrow", ProfileUpdate{DateOfBirth: ptr("2026-06-02")}, "in the future"},
+		{"date of birth in another format", ProfileUpdate{DateOfBirth: ptr("10/12/1990")}, "YYYY-MM-DD"},
+		{"date of birth that does not exist", ProfileUpdate{DateOfBirth: ptr("1990-02-30")}, "YYYY-MM-DD"},
+		{"each gender", ProfileUpdate{Gender: ptr("prefer_not_to_say")}, ""},
+		{"unknown gender", ProfileUpdate{Gender: ptr("Female")}, "unknown gender"},
+		{"unknown blood type", ProfileUpdate{BloodType: ptr("C+")}, "unknown blood type"},
+		{"blood type without Rh", ProfileUpdate{BloodType: ptr("AB")}, "unknown blood type"},
+		{"one bad field of several", ProfileUpdate{Name: ptr("Changed"), BloodType: ptr("Z")}, "unknown blood type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := as.UpdateProfile(ctx, "user-1", tt.update)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("UpdateProfile: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidArgument) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("UpdateProfile = %v, want an invalid argument saying %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A rejected update changes none of its fields
+	stored, _ := as.GetProfile(ctx, "user-1")
+	if stored.Name == "Changed" || stored.BloodType != "A+" {
+		t.Errorf("profile after rejected updates = %+v", stored)
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+)
+
+// redirectTransport sends every request to target, whatever host it was
+// addressed to
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// providerAPI starts a server answering every request with status and
+// body, and returns a client sending its requests there
+func providerAPI(t *testing.T, status int, body string) *http.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	return &http.Client{Transport: redirectTransport{target: target}}
+}
+
+// wantConfigError checks err is a ProviderConfigError of kind naming envVar
+func wantConfigError(t *testing.T, err, kind error, envVar string) {
+	t.Helper()
+	var configErr *ProviderConfigError
+	if !errors.As(err, &configErr) || !errors.Is(err, kind) {
+		t.Fatalf("error = %v, want %v", err, kind)
+	}
+	if configErr.EnvVar != envVar || !strings.Contains(err.Error(), envVar) {
+		t.Errorf("error %q names %s, want %s", err, configErr.EnvVar, envVar)
+	}
+}
+
+func TestHostedProviderWithoutKey(t *testing.T) {
+	tests := []struct {
+		provider string
+		cfg      config.AIConfig
+		envVar   string
+	}{
+		{"openai", config.AIConfig{Provider: "openai"}, "AI_API_KEY"},
+		{"gemini", config.AIConfig{Provider: "gemini", APIKey: "  "}, "AI_API_KEY"},
+		{"bedrock", config.AIConfig{Provider: "openai", APIKey: "sk-openai"}, "BEDROCK_API_KEY"},
+		{"gemini", config.AIConfig{Provider: "openai", ProviderAPIKeys: map[string]string{"openai": "sk-openai"}}, "GEMINI_API_KEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider+" "+tt.envVar, func(t *testing.T) {
+			_, err := newNamedAIProvider(tt.provider, &tt.cfg)
+			wantConfigError(t, err, ErrProviderNotConfigured, tt.envVar)
+			if !strings.Contains(err.Error(), "not configured") {
+				t.Errorf("error %q does not say the provider is not configured", err)
+			}
+		})
+	}
+
+	// A provider's own key is used whichever provider is the default
+	if _, err := newNamedAIProvider("gemini", &config.AIConfig{Provider: "openai", ProviderAPIKeys: map[string]string{"gemini": "g-key"}}); err != nil {
+		t.Errorf("gemini with its own key: %v", err)
+	}
+	// Without a key the mock stands in, so development needs no credentials
+	if p := NewAIProvider(&config.AIConfig{Provider: "openai"}); p.Name() != "mock" {
+		t.Errorf("openai without a key = %s, want the mock", p.Name())
+	}
+}
+
+func TestHostedProviderRejectedKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		auth   bool
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"error":{"message":"Incorrect API key provided"}}`, true},
+		{"forbidden", http.StatusForbidden, `{"message":"The security token included in the request is invalid"}`, true},
+		{"gemini invalid key", http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT","details":[{"reason":"API_KEY_INVALID"}]}}`, true},
+		{"other bad request", http.StatusBadRequest, `{"error":{"message":"max_tokens too large"}}`, false},
+		{"server error", http.StatusInternalServerError, `upstream failure`, false},
+	}
+	for _, provider := range []string{"openai", "gemini", "bedrock"} {
+		for _, tt := range tests {
+			t.Run(provider+" "+tt.name, func(t *testing.T) {
+				p, err := newNamedAIProvider(provider, &config.AIConfig{Provider: provider, APIKey: "wrong-key"})
+				if err != nil {
+					t.Fatalf("newNamedAIProvider: %v", err)
+				}
+				httpClient := providerAPI(t, tt.status, tt.body)
+				switch client := p.(*hostedProvider).client.(type) {
+				case *openAIClient:
+					client.http = httpClient
+				case *geminiClient:
+					client.http = httpClient
+				case *bedrockClient:
+					client.http = httpClient
+				}
+
+				_, err = p.DoctorChat(context.Background(), "hello")
+				if tt.auth {
+					wantConfigError(t, err, ErrProviderAuth, "AI_API_KEY")
+					if !strings.Contains(err.Error(), "rejected its credentials") {
+						t.Errorf("error %q does not say the key was rejected", err)
+					}
+					return
+				}
+				if err == nil || errors.Is(err, ErrProviderAuth) || errors.Is(err, ErrProviderNotConfigured) {
+					t.Errorf("error = %v, want a failure that is not about credentials", err)
+				}
+			})
+		}
+	}
+}
+
+func TestTranscriberRejectedKey(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		transcriber := NewTranscriber(&config.AIConfig{STTProvider: "openai", STTAPIKey: "wrong-key"}).(*openAITranscriber)
+		transcriber.client = providerAPI(t, status, `{"error":{"message":"Incorrect API key provided"}}`)
+
+		_, err := transcriber.Transcribe(context.Background(), testWAV, "wav")
+		wantConfigError(t, err, ErrProviderAuth, sttKeyEnv)
+	}
+
+	transcriber := NewTranscriber(&config.AIConfig{STTProvider: "openai", STTAPIKey: "key"}).(*openAITranscriber)
+	transcriber.client = providerAPI(t, http.StatusInternalServerError, "upstream failure")
+	if _, err := transcriber.Transcribe(context.Background(), testWAV, "wav"); err == nil || errors.Is(err, ErrProviderAuth) {
+		t.Errorf("server error = %v, want a failure that is not about credentials", err)
+	}
+}
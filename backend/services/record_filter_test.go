@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// filterDay is the day the filter test records are created around
+var filterDay = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+// createFilterRecords stores user-1's records of several types on several
+// days, and one of user-2's
+func createFilterRecords(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	for _, r := range []struct {
+		id, user, recordType string
+		day                  int
+	}{
+		{"lab-1", "user-1", "lab_result", -20},
+		{"lab-2", "user-1", "lab_result", -5},
+		{"lab-3", "user-1", "lab_result", 0},
+		{"rx-1", "user-1", "prescription", -5},
+		{"appt-1", "user-1", "appointment", 3},
+		{"other-lab", "user-2", "lab_result", -5},
+	} {
+		at := filterDay.AddDate(0, 0, r.day)
+		record := models.HealthRecord{ID: r.id, UserID: r.user, RecordType: r.recordType, Title: r.id, CreatedAt: at, UpdatedAt: at}
+		if err := db.Create(&record).Error; err != nil {
+			t.Fatalf("create %s: %v", r.id, err)
+		}
+	}
+}
+
+func TestListRecordsFilters(t *testing.T) {
+	db := newTestDB(t)
+	createFilterRecords(t, db)
+	hrs := newTestRecordsService(db, nil)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		opts ListRecordsOptions
+		want []string // sorted
+	}{
+		{"no filters", ListRecordsOptions{}, []string{"appt-1", "lab-1", "lab-2", "lab-3", "rx-1"}},
+		{"type", ListRecordsOptions{RecordType: "lab_result"}, []string{"lab-1", "lab-2", "lab-3"}},
+		{"type with no records", ListRecordsOptions{RecordType: "symptom"}, nil},
+		{"from a date", ListRecordsOptions{StartDate: filterDay.AddDate(0, 0, -6)}, []string{"appt-1", "lab-2", "lab-3", "rx-1"}},
+		{"until a date", ListRecordsOptions{EndDate: filterDay.AddDate(0, 0, -1)}, []string{"lab-1", "lab-2", "rx-1"}},
+		{"between dates", ListRecordsOptions{StartDate: filterDay.AddDate(0, 0, -6), EndDate: filterDay.AddDate(0, 0, -1)}, []string{"lab-2", "rx-1"}},
+		{"bounds are inclusive", ListRecordsOptions{StartDate: filterDay.AddDate(0, 0, -5), EndDate: filterDay}, []string{"lab-2", "lab-3", "rx-1"}},
+		{"one instant", ListRecordsOptions{StartDate: filterDay, EndDate: filterDay}, []string{"lab-3"}},
+		{"type between dates", ListRecordsOptions{RecordType: "lab_result", StartDate: filterDay.AddDate(0, 0, -6), EndDate: filterDay}, []string{"lab-2", "lab-3"}},
+		{"with other filters", ListRecordsOptions{RecordType: "lab_result", SortBy: "title", SortOrder: "asc", View: RecordViewSummary}, []string{"lab-1", "lab-2", "lab-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, total, err := hrs.ListRecords(ctx, "user-1", tt.opts)
+			if err != nil {
+				t.Fatalf("ListRecords: %v", err)
+			}
+			got := recordIDs(records)
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("records = %v, want %v", got, tt.want)
+			}
+			if total != int64(len(tt.want)) {
+				t.Errorf("total = %d, want %d", total, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestListRecordsFilteredTotalCountsEveryPage(t *testing.T) {
+	db := newTestDB(t)
+	createFilterRecords(t, db)
+	hrs := newTestRecordsService(db, nil)
+
+	records, total, err := hrs.ListRecords(context.Background(), "user-1", ListRecordsOptions{RecordType: "lab_result", Limit: 2, SortOrder: "asc"})
+	if err != nil {
+		t.Fatalf("ListRecords: %v", err)
+	}
+	if got := recordIDs(records); !slices.Equal(got, []string{"lab-1", "lab-2"}) || total != 3 {
+		t.Errorf("first page = %v of %d, want [lab-1 lab-2] of 3", got, total)
+	}
+}
+
+func TestListRecordsRejectsBadFilters(t *testing.T) {
+	db := newTestDB(t)
+	createFilterRecords(t, db)
+	hrs := newTestRecordsService(db, nil)
+
+	for name, opts := range map[string]ListRecordsOptions{
+		"unknown type":                  {RecordType: "dental"},
+		"range ending before it starts": {StartDate: filterDay, EndDate: filterDay.Add(-time.Second)},
+	} {
+		if _, _, err := hrs.ListRecords(context.Background(), "user-1", opts); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s: %v, want %v", name, err, ErrInvalidArgument)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+)
+
+// LinkRecords records a typed relationship from sourceID to targetID. Both
+// records must belong to userID; a record owned by anyone else is reported
+// as not found so links cannot be used to probe other users' records.
+func (hrs *HealthRecordsService) LinkRecords(ctx context.Context, userID, sourceID, targetID, relationType string) (*models.RecordLink, error) {
+	if err := validateRelationType(relationType); err != nil {
+		return nil, err
+	}
+	if sourceID == targetID {
+		return nil, fmt.Errorf("%w: a record cannot be linked to itself", ErrInvalidArgument)
+	}
+
+	var owned int64
+	if err := hrs.db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Scopes(scopeOwner(userID)).
+		Where("id IN ?", []string{sourceID, targetID}).
+		Count(&owned).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch records: %w", err)
+	}
+	if owned != 2 {
+		return nil, fmt.Errorf("%w: record", ErrNotFound)
+	}
+
+	var existing int64
+	if err := hrs.db.WithContext(ctx).Model(&models.RecordLink{}).
+		Where("source_id = ? AND target_id = ? AND relation_type = ?", sourceID, targetID, relationType).
+		Count(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to check record links: %w", err)
+	}
+	if existing > 0 {
+		return nil, fmt.Errorf("%w: records already linked as %s", ErrAlreadyExists, relationType)
+	}
+
+	link := models.RecordLink{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		SourceID:     sourceID,
+		TargetID:     targetID,
+		RelationType: relationType,
+		CreatedAt:    time.Now(),
+	}
+	if err := hrs.db.WithContext(ctx).Create(&link).Error; err != nil {
+		return nil, fmt.Errorf("failed to link records: %w", err)
+	}
+
+	return &link, nil
+}
+
+// RelatedRecordIDs returns the IDs of records linked to recordID in either
+// direction, oldest link first
+func (hrs *HealthRecordsService) RelatedRecordIDs(ctx context.Context, recordID string) ([]string, error) {
+	var links []models.RecordLink
+	if err := hrs.db.WithContext(ctx).Where("source_id = ? OR target_id = ?", recordID, recordID).
+		Order("created_at ASC").
+		Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch record links: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, link := range links {
+		id := link.TargetID
+		if id == recordID {
+			id = link.SourceID
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLinkRecords(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	ctx := context.Background()
+	for _, id := range []string{"lab", "appointment", "prescription", "symptom"} {
+		createRecord(t, db, id, "user-1", "")
+	}
+	createRecord(t, db, "other-lab", "user-2", "")
+
+	link, err := hrs.LinkRecords(ctx, "user-1", "lab", "appointment", "result_of")
+	if err != nil {
+		t.Fatalf("LinkRecords: %v", err)
+	}
+	if link.UserID != "user-1" || link.SourceID != "lab" || link.TargetID != "appointment" || link.RelationType != "result_of" {
+		t.Errorf("link = %+v", link)
+	}
+	if _, err := hrs.LinkRecords(ctx, "user-1", "prescription", "lab", "prescribed_for"); err != nil {
+		t.Fatalf("LinkRecords: %v", err)
+	}
+	if _, err := hrs.LinkRecords(ctx, "user-1", "lab", "appointment", "related"); err != nil {
+		t.Fatalf("a second relation between the same records: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		source, target string
+		relation       string
+		want           error
+	}{
+		{"same link twice", "user-1", "lab", "appointment", "result_of", ErrAlreadyExists},
+		{"unknown relation", "user-1", "lab", "symptom", "caused_by", ErrInvalidArgument},
+		{"to itself", "user-1", "lab", "lab", "related", ErrInvalidArgument},
+		{"target owned by another user", "user-1", "lab", "other-lab", "related", ErrNotFound},
+		{"source owned by another user", "user-1", "other-lab", "lab", "related", ErrNotFound},
+		{"linking someone else's records", "user-2", "lab", "appointment", "related", ErrNotFound},
+		{"missing record", "user-1", "lab", "no-such-record", "related", ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := hrs.LinkRecords(ctx, tt.userID, tt.source, tt.target, tt.relation); !errors.Is(err, tt.want) {
+				t.Errorf("LinkRecords() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	related, err := hrs.RelatedRecordIDs(ctx, "lab")
+	if err != nil {
+		t.Fatalf("RelatedRecordIDs: %v", err)
+	}
+	if len(related) != 2 || related[0] != "appointment" || related[1] != "prescription" {
+		t.Errorf("related to lab = %v, want both directions once each, oldest first", related)
+	}
+	if related, _ := hrs.RelatedRecordIDs(ctx, "other-lab"); len(related) != 0 {
+		t.Errorf("refused links were stored: %v", related)
+	}
+
+	if err := hrs.DeleteRecord(ctx, "user-1", "appointment"); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if related, _ := hrs.RelatedRecordIDs(ctx, "lab"); len(related) != 1 || related[0] != "prescription" {
+		t.Errorf("after deleting the appointment, related to lab = %v", related)
+	}
+}
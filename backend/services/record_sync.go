@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// Incremental sync for offline clients. Every change to a record takes the
+// next value of its owner's change sequence, inside the transaction making
+// the change, and deletions leave a tombstone with their own sequence
+// value. A sync token is a position in that sequence, so a client pulls
+// exactly the records and tombstones after it. Because the sequence row is
+// locked until the changing transaction commits, a reader can never see a
+// later value committed while an earlier one is still pending.
+
+// SyncPage is one page of changes after a sync token. Records hold the
+// current state of every record created or updated since the token, and
+// Tombstones the IDs of records deleted since, together ordered by
+// sequence. Token is the position to sync from next.
+type SyncPage struct {
+	Records    []models.HealthRecord
+	Tombstones []models.RecordTombstone
+	Token      string
+	HasMore    bool
+}
+
+// syncTokenPrefix versions the token format
+const syncTokenPrefix = "s1|"
+
+func encodeSyncToken(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncTokenPrefix + strconv.FormatInt(seq, 10)))
+}
+
+func decodeSyncToken(token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(raw), syncTokenPrefix) {
+		return 0, fmt.Errorf("%w: malformed sync token", ErrInvalidArgument)
+	}
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(raw), syncTokenPrefix), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("%w: malformed sync token", ErrInvalidArgument)
+	}
+	return seq, nil
+}
+
+// nextSyncSeq advances userID's change sequence inside tx and returns the
+// new value. The update locks the user's sequence row until tx ends.
+func nextSyncSeq(tx *gorm.DB, userID string) (int64, error) {
+	result := tx.Model(&models.SyncState{}).Where("user_id = ?", userID).
+		UpdateColumn("seq", gorm.Expr("seq + 1"))
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to advance sync sequence: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := tx.Create(&models.SyncState{UserID: userID, Seq: 1}).Error; err != nil {
+			return 0, fmt.Errorf("failed to start sync sequence: %w", err)
+		}
+		return 1, nil
+	}
+
+	var state models.SyncState
+	if err := tx.Select("seq").First(&state, "user_id = ?", userID).Error; err != nil {
+		return 0, fmt.Errorf("failed to read sync sequence: %w", err)
+	}
+	return state.Seq, nil
+}
+
+// stampRecordSync gives a record just changed inside tx the next sequence
+// value, so clients holding an older token pull it
+func stampRecordSync(tx *gorm.DB, record *models.HealthRecord) error {
+	seq, err := nextSyncSeq(tx, record.UserID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&models.HealthRecord{}).Where("id = ?", record.ID).
+		UpdateColumn("sync_seq", seq).Error; err != nil {
+		return fmt.Errorf("failed to stamp record: %w", err)
+	}
+	record.SyncSeq = seq
+	return nil
+}
+
+// tombstoneRecord leaves a tombstone for a record deleted inside tx
+func tombstoneRecord(tx *gorm.DB, record *models.HealthRecord) error {
+	seq, err := nextSyncSeq(tx, record.UserID)
+	if err != nil {
+		return err
+	}
+	tombstone := models.RecordTombstone{
+		RecordID:  record.ID,
+		UserID:    record.UserID,
+		SyncSeq:   seq,
+		DeletedAt: time.Now(),
+	}
+	if err := tx.Create(&tombstone).Error; err != nil {
+		return fmt.Errorf("failed to record deletion: %w", err)
+	}
+	return nil
+}
+
+// backfillSyncSeq stamps the user's records that predate sync, oldest
+// first, the first time the user syncs
+func backfillSyncSeq(tx *gorm.DB, userID string) error {
+	var legacy []models.HealthRecord
+	if err := tx.Select("id, user_id").Scopes(scopeOwner(userID)).Where("sync_seq = 0").
+		Order("created_at ASC, id ASC").Find(&legacy).Error; err != nil {
+		return fmt.Errorf("failed to find unsynced records: %w", err)
+	}
+	for i := range legacy {
+		if err := stampRecordSync(tx, &legacy[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncRecords returns the user's changes after token, at most limit
+// records and tombstones in all. An empty token starts a full sync: every
+// record, and no tombstones since the client has nothing to remove. A
+// token older than the tombstone retention window fails with
+// ErrSyncTokenExpired, and the client must start again with an empty token.
+func (hrs *HealthRecordsService) SyncRecords(ctx context.Context, userID, token string, limit int) (*SyncPage, error) {
+	limit, _ = pageBounds(limit, 0)
+
+	var since int64
+	if token != "" {
+		var err error
+		if since, err = decodeSyncToken(token); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &SyncPage{}
+	err := hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := backfillSyncSeq(tx, userID); err != nil {
+			return err
+		}
+
+		// Read the sequence first: every value up to it is committed, so
+		// the queries below see all of them
+		var state models.SyncState
+		if err := tx.Limit(1).Find(&state, "user_id = ?", userID).Error; err != nil {
+			return fmt.Errorf("failed to read sync state: %w", err)
+		}
+		if token != "" && (since < state.PurgedSeq || since > state.Seq) {
+			return ErrSyncTokenExpired
+		}
+
+		var records []models.HealthRecord
+		if err := tx.Scopes(scopeOwner(userID)).Where("sync_seq > ?", since).
+			Order("sync_seq ASC").Limit(limit + 1).Find(&records).Error; err != nil {
+			return fmt.Errorf("failed to fetch changed records: %w", err)
+		}
+		var tombstones []models.RecordTombstone
+		if token != "" {
+			if err := tx.Scopes(scopeOwner(userID)).Where("sync_seq > ?", since).
+				Order("sync_seq ASC").Limit(limit + 1).Find(&tombstones).Error; err != nil {
+				return fmt.Errorf("failed to fetch deletions: %w", err)
+			}
+		}
+
+		// Merge the two by sequence, keeping the first limit changes
+		last := since
+		r, t := 0, 0
+		for r+t < limit && (r < len(records) || t < len(tombstones)) {
+			if t == len(tombstones) || (r < len(records) && records[r].SyncSeq < tombstones[t].SyncSeq) {
+				last = records[r].SyncSeq
+				r++
+			} else {
+				last = tombstones[t].SyncSeq
+				t++
+			}
+		}
+		page.Records, page.Tombstones = records[:r], tombstones[:t]
+		page.HasMore = r < len(records) || t < len(tombstones)
+		if !page.HasMore && state.Seq > last {
+			// Nothing left to pull, so skip ahead past changes this
+			// client has no use for, such as tombstones during a full sync
+			last = state.Seq
+		}
+		page.Token = encodeSyncToken(last)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// PurgeTombstones deletes tombstones older than the retention window and
+// remembers, per user, the newest sequence purged, so clients with older
+// tokens are told to resync instead of silently missing deletions
+func (hrs *HealthRecordsService) PurgeTombstones(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-time.Duration(hrs.config.TombstoneRetention) * time.Second)
+
+	purged := 0
+	err := hrs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		type purge struct {
+			UserID  string
+			MaxSeq  int64
+			Removed int
+		}
+		var purges []purge
+		if err := tx.Model(&models.RecordTombstone{}).
+			Select("user_id, MAX(sync_seq) AS max_seq, COUNT(*) AS removed").
+			Where("deleted_at <= ?", cutoff).Group("user_id").
+			Scan(&purges).Error; err != nil {
+			return fmt.Errorf("failed to find expired tombstones: %w", err)
+		}
+
+		for _, p := range purges {
+			if err := tx.Model(&models.SyncState{}).
+				Where("user_id = ? AND purged_seq < ?", p.UserID, p.MaxSeq).
+				UpdateColumn("purged_seq", p.MaxSeq).Error; err != nil {
+				return fmt.Errorf("failed to update sync state: %w", err)
+			}
+			if err := tx.Where("user_id = ? AND sync_seq <= ?", p.UserID, p.MaxSeq).
+				Delete(&models.RecordTombstone{}).Error; err != nil {
+				return fmt.Errorf("failed to purge tombstones: %w", err)
+			}
+			purged += p.Removed
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired record tombstones", purged)
+	}
+	return purged, nil
+}
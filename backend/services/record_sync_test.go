@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// syncAll follows sync tokens from token until nothing is left, returning
+// the IDs of changed records and tombstones in the order they arrived and
+// the token to persist
+func syncAll(t *testing.T, hrs *HealthRecordsService, userID, token string, limit int) ([]string, []string, string) {
+	t.Helper()
+	var records, tombstones []string
+	for pages := 0; ; pages++ {
+		page, err := hrs.SyncRecords(context.Background(), userID, token, limit)
+		if err != nil {
+			t.Fatalf("SyncRecords: %v", err)
+		}
+		if len(page.Records)+len(page.Tombstones) > limit {
+			t.Fatalf("page of %d changes over the limit of %d", len(page.Records)+len(page.Tombstones), limit)
+		}
+		for _, record := range page.Records {
+			records = append(records, record.ID)
+		}
+		for _, tombstone := range page.Tombstones {
+			tombstones = append(tombstones, tombstone.RecordID)
+		}
+		if page.Token == "" {
+			t.Fatal("page without a token")
+		}
+		token = page.Token
+		if !page.HasMore {
+			return records, tombstones, token
+		}
+		if pages > 50 {
+			t.Fatal("sync never finished")
+		}
+	}
+}
+
+// newSyncFixture returns a records service and user-1 with three records,
+// created through the service so each has a sequence value
+func newSyncFixture(t *testing.T) (*HealthRecordsService, *gorm.DB, []*models.HealthRecord) {
+	t.Helper()
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, &config.RecordsConfig{TombstoneRetention: 3600})
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	var records []*models.HealthRecord
+	for _, title := range []string{"Blood panel", "Chest X-ray", "Allergy test"} {
+		record, err := hrs.CreateRecord(context.Background(), "user-1", "lab_result", title, "", nil)
+		if err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		records = append(records, record)
+	}
+	return hrs, db, records
+}
+
+func TestFullSyncSendsRecordsButNoTombstones(t *testing.T) {
+	hrs, _, records := newSyncFixture(t)
+	ctx := context.Background()
+	if err := hrs.DeleteRecord(ctx, "user-1", records[1].ID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if _, err := hrs.CreateRecord(ctx, "user-2", "lab_result", "Someone else's", "", nil); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	got, tombstones, token := syncAll(t, hrs, "user-1", "", 100)
+	if want := []string{records[0].ID, records[2].ID}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("full sync records = %v, want %v", got, want)
+	}
+	if len(tombstones) != 0 {
+		t.Errorf("full sync sent tombstones %v", tombstones)
+	}
+
+	// Syncing again from the new token finds nothing
+	if got, tombstones, next := syncAll(t, hrs, "user-1", token, 100); len(got)+len(tombstones) != 0 || next != token {
+		t.Errorf("resync = %v, %v, token %q; want nothing and the same token", got, tombstones, next)
+	}
+}
+
+func TestIncrementalSyncAfterInterleavedChanges(t *testing.T) {
+	hrs, _, records := newSyncFixture(t)
+	ctx := context.Background()
+	_, _, token := syncAll(t, hrs, "user-1", "", 100)
+
+	// Interleave updates and deletes; each record should arrive once, in
+	// its latest state, ordered by its last change
+	if _, err := hrs.UpdateRecord(ctx, "user-1", records[0].ID, "Blood panel", "First edit", nil); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if err := hrs.DeleteRecord(ctx, "user-1", records[1].ID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	created, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Vitamin D", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if _, err := hrs.SetRecordSensitivity(ctx, "user-1", records[2].ID, models.SensitivitySensitive); err != nil {
+		t.Fatalf("SetRecordSensitivity: %v", err)
+	}
+	if _, err := hrs.UpdateRecord(ctx, "user-1", records[0].ID, "Blood panel", "Second edit", nil); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	// Created and deleted between syncs: the client only needs the tombstone
+	shortLived, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Mistake", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if err := hrs.DeleteRecord(ctx, "user-1", shortLived.ID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+
+	page, err := hrs.SyncRecords(ctx, "user-1", token, 100)
+	if err != nil {
+		t.Fatalf("SyncRecords: %v", err)
+	}
+	var got []string
+	for _, record := range page.Records {
+		got = append(got, record.ID)
+	}
+	if want := []string{created.ID, records[2].ID, records[0].ID}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("changed records = %v, want %v", got, want)
+	}
+	if last := page.Records[len(page.Records)-1]; last.Description != "Second edit" {
+		t.Errorf("record sent as %q, want its latest state", last.Description)
+	}
+	if page.Records[1].Sensitivity != models.SensitivitySensitive {
+		t.Error("sensitivity change not synced")
+	}
+	var tombstones []string
+	for _, tombstone := range page.Tombstones {
+		tombstones = append(tombstones, tombstone.RecordID)
+	}
+	if want := []string{records[1].ID, shortLived.ID}; strings.Join(tombstones, " ") != strings.Join(want, " ") {
+		t.Errorf("tombstones = %v, want %v", tombstones, want)
+	}
+	if page.HasMore {
+		t.Error("HasMore on a complete page")
+	}
+
+	// Another user's token position says nothing about user-1
+	if other, _, _ := syncAll(t, hrs, "user-2", "", 100); len(other) != 0 {
+		t.Errorf("user-2 synced %v", other)
+	}
+}
+
+func TestSyncPagesMergeRecordsAndTombstones(t *testing.T) {
+	hrs, _, records := newSyncFixture(t)
+	ctx := context.Background()
+	_, _, token := syncAll(t, hrs, "user-1", "", 100)
+
+	var want []string
+	for i := 0; i < 7; i++ {
+		record, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Panel "+string(rune('A'+i)), "", nil)
+		if err != nil {
+			t.Fatalf("CreateRecord: %v", err)
+		}
+		want = append(want, record.ID)
+		if i < len(records) {
+			if err := hrs.DeleteRecord(ctx, "user-1", records[i].ID); err != nil {
+				t.Fatalf("DeleteRecord: %v", err)
+			}
+		}
+	}
+
+	got, tombstones, _ := syncAll(t, hrs, "user-1", token, 2)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("records over pages = %v, want %v", got, want)
+	}
+	if len(tombstones) != len(records) {
+		t.Errorf("tombstones over pages = %v, want %d", tombstones, len(records))
+	}
+}
+
+func TestRestoredRecordSyncsAsAChange(t *testing.T) {
+	hrs, db, records := newSyncFixture(t)
+	ctx := context.Background()
+	_, _, token := syncAll(t, hrs, "user-1", "", 100)
+
+	if err := hrs.DeleteRecord(ctx, "user-1", records[0].ID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	got, tombstones, afterDelete := syncAll(t, hrs, "user-1", token, 100)
+	if len(got) != 0 || len(tombstones) != 1 || tombstones[0] != records[0].ID {
+		t.Fatalf("after delete: records %v, tombstones %v", got, tombstones)
+	}
+
+	if _, err := hrs.RestoreRecord(ctx, "user-1", records[0].ID); err != nil {
+		t.Fatalf("RestoreRecord: %v", err)
+	}
+	got, tombstones, afterRestore := syncAll(t, hrs, "user-1", afterDelete, 100)
+	if len(got) != 1 || got[0] != records[0].ID || len(tombstones) != 0 {
+		t.Errorf("after restore: records %v, tombstones %v; want the record back", got, tombstones)
+	}
+	// A client that missed both sees only the live record
+	got, tombstones, _ = syncAll(t, hrs, "user-1", token, 100)
+	if len(got) != 1 || len(tombstones) != 0 {
+		t.Errorf("delete and restore between syncs: records %v, tombstones %v", got, tombstones)
+	}
+
+	// Deleting it again leaves a fresh tombstone
+	if err := hrs.DeleteRecord(ctx, "user-1", records[0].ID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if _, tombstones, _ := syncAll(t, hrs, "user-1", afterRestore, 100); len(tombstones) != 1 {
+		t.Errorf("second delete: tombstones %v", tombstones)
+	}
+	var count int64
+	db.Model(&models.RecordTombstone{}).Where("record_id = ?", records[0].ID).Count(&count)
+	if count != 1 {
+		t.Errorf("%d tombstones for one record", count)
+	}
+}
+
+func TestExpiredSyncTokenRequiresFullResync(t *testing.T) {
+	hrs, db, records := newSyncFixture(t)
+	ctx := context.Background()
+	_, _, stale := syncAll(t, hrs, "user-1", "", 100)
+
+	for _, record := range records[:2] {
+		if err := hrs.DeleteRecord(ctx, "user-1", record.ID); err != nil {
+			t.Fatalf("DeleteRecord: %v", err)
+		}
+	}
+	_, _, current := syncAll(t, hrs, "user-1", stale, 100)
+
+	// Only the first tombstone is past the retention window
+	db.Model(&models.RecordTombstone{}).Where("record_id = ?", records[0].ID).
+		Update("deleted_at", time.Now().Add(-2*time.Hour))
+	purged, err := hrs.PurgeTombstones(ctx)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeTombstones = %d, %v; want 1", purged, err)
+	}
+	if purged, _ := hrs.PurgeTombstones(ctx); purged != 0 {
+		t.Errorf("second purge removed %d", purged)
+	}
+
+	if _, err := hrs.SyncRecords(ctx, "user-1", stale, 100); !errors.Is(err, ErrSyncTokenExpired) {
+		t.Fatalf("token older than the purge: error = %v, want %v", err, ErrSyncTokenExpired)
+	}
+	// A token taken after the purged deletion is still good
+	if _, err := hrs.SyncRecords(ctx, "user-1", current, 100); err != nil {
+		t.Errorf("token newer than the purge: %v", err)
+	}
+	got, tombstones, fresh := syncAll(t, hrs, "user-1", "", 100)
+	if len(got) != 1 || got[0] != records[2].ID || len(tombstones) != 0 {
+		t.Errorf("full resync = %v, %v", got, tombstones)
+	}
+	if _, err := hrs.SyncRecords(ctx, "user-1", fresh, 100); err != nil {
+		t.Errorf("token from the full resync: %v", err)
+	}
+}
+
+func TestSyncRejectsBadTokens(t *testing.T) {
+	hrs, _, _ := newSyncFixture(t)
+	ctx := context.Background()
+
+	malformed := []string{
+		"garbage!",
+		encodeSyncToken(-1),
+		base64.RawURLEncoding.EncodeToString([]byte("s1|abc")),
+		base64.RawURLEncoding.EncodeToString([]byte("s9|5")),
+	}
+	for _, token := range malformed {
+		if _, err := hrs.SyncRecords(ctx, "user-1", token, 10); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("token %q: error = %v, want %v", token, err, ErrInvalidArgument)
+		}
+	}
+	// A token past the user's sequence came from elsewhere, so the client
+	// cannot trust its copy
+	if _, err := hrs.SyncRecords(ctx, "user-1", encodeSyncToken(1000), 10); !errors.Is(err, ErrSyncTokenExpired) {
+		t.Errorf("token from the future: error = %v, want %v", err, ErrSyncTokenExpired)
+	}
+	if seq, err := decodeSyncToken(encodeSyncToken(42)); err != nil || seq != 42 {
+		t.Errorf("token round trip = %d, %v", seq, err)
+	}
+}
+
+func TestSyncBackfillsRecordsFromBeforeSync(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	createUser(t, db, "user-1")
+	for _, id := range []string{"legacy-1", "legacy-2"} {
+		createRecord(t, db, id, "user-1", models.SensitivityStandard)
+	}
+	created, err := hrs.CreateRecord(context.Background(), "user-1", "lab_result", "New panel", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	// Old records are stamped after the new one, oldest first
+	got, _, token := syncAll(t, hrs, "user-1", "", 100)
+	if want := []string{created.ID, "legacy-1", "legacy-2"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("full sync = %v, want %v", got, want)
+	}
+	if got, _, _ := syncAll(t, hrs, "user-1", token, 100); len(got) != 0 {
+		t.Errorf("backfilled records synced again: %v", got)
+	}
+}
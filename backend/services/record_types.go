@@ -0,0 +1,173 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of value a custom record type's metadata field may hold
+const (
+	FieldKindString  = "string"
+	FieldKindNumber  = "number"
+	FieldKindDate    = "date" // YYYY-MM-DD
+	FieldKindBoolean = "boolean"
+)
+
+// RecordTypeSchema describes the metadata of a custom record type
+type RecordTypeSchema struct {
+	Fields map[string]FieldSchema `json:"fields"`
+	// Strict rejects metadata keys that are not in Fields
+	Strict bool `json:"strict"`
+}
+
+// FieldSchema constrains one metadata field
+type FieldSchema struct {
+	Kind     string   `json:"kind"` // string (default), number, date, boolean
+	Required bool     `json:"required"`
+	Values   []string `json:"values"` // allowed values; empty allows any
+}
+
+// RecordTypes are the record types a deployment accepts: the built-in ones
+// plus any custom types configured for specialists, such as imaging or
+// vaccination, each with a metadata schema. A nil *RecordTypes accepts only
+// the built-in types.
+type RecordTypes struct {
+	custom map[string]RecordTypeSchema
+}
+
+// LoadRecordTypes reads custom record types from a JSON file mapping each
+// type name to its schema, e.g.
+//
+//	{"vaccination": {"fields": {"vaccine": {"required": true}, "dose": {"kind": "number"}}}}
+//
+// An empty path configures no custom types.
+func LoadRecordTypes(path string) (*RecordTypes, error) {
+	if path == "" {
+		return &RecordTypes{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record types: %w", err)
+	}
+	var custom map[string]RecordTypeSchema
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse record types: %w", err)
+	}
+	return NewRecordTypes(custom)
+}
+
+// NewRecordTypes checks custom type definitions and merges them with the
+// built-in types. Custom types may not redefine a built-in one.
+func NewRecordTypes(custom map[string]RecordTypeSchema) (*RecordTypes, error) {
+	for name, schema := range custom {
+		if !metadataKeyPattern.MatchString(name) {
+			return nil, fmt.Errorf("record type %q must contain only letters, digits, '_', '.', or '-'", name)
+		}
+		if builtinRecordTypes[name] {
+			return nil, fmt.Errorf("record type %q is built in", name)
+		}
+		for field, fs := range schema.Fields {
+			switch fs.Kind {
+			case "", FieldKindString, FieldKindNumber, FieldKindDate, FieldKindBoolean:
+			default:
+				return nil, fmt.Errorf("record type %s: field %s has unknown kind %q", name, field, fs.Kind)
+			}
+		}
+	}
+	return &RecordTypes{custom: custom}, nil
+}
+
+// Names returns every accepted type, built-in first, each group sorted
+func (rt *RecordTypes) Names() []string {
+	var builtin, custom []string
+	for name := range builtinRecordTypes {
+		builtin = append(builtin, name)
+	}
+	if rt != nil {
+		for name := range rt.custom {
+			custom = append(custom, name)
+		}
+	}
+	sort.Strings(builtin)
+	sort.Strings(custom)
+	return append(builtin, custom...)
+}
+
+// validate rejects record types that are neither built in nor configured
+func (rt *RecordTypes) validate(recordType string) error {
+	if builtinRecordTypes[recordType] {
+		return nil
+	}
+	if rt != nil {
+		if _, ok := rt.custom[recordType]; ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown record type %q", ErrInvalidArgument, recordType)
+}
+
+// validateMetadata checks a record's metadata against its type's schema.
+// Built-in types have free-form metadata.
+func (rt *RecordTypes) validateMetadata(recordType string, metadata map[string]string) error {
+	if rt == nil {
+		return nil
+	}
+	schema, ok := rt.custom[recordType]
+	if !ok {
+		return nil
+	}
+
+	for field, fs := range schema.Fields {
+		value, present := metadata[field]
+		if !present || strings.TrimSpace(value) == "" {
+			if fs.Required {
+				return fmt.Errorf("%w: %s records need metadata %q", ErrInvalidArgument, recordType, field)
+			}
+			continue
+		}
+		if err := fs.check(value); err != nil {
+			return fmt.Errorf("%w: metadata %q of %s record %v", ErrInvalidArgument, field, recordType, err)
+		}
+	}
+	if schema.Strict {
+		for key := range metadata {
+			if _, ok := schema.Fields[key]; !ok {
+				return fmt.Errorf("%w: %s records do not take metadata %q", ErrInvalidArgument, recordType, key)
+			}
+		}
+	}
+	return nil
+}
+
+// check reports why value does not fit the field, or nil if it does
+func (fs FieldSchema) check(value string) error {
+	value = strings.TrimSpace(value)
+	switch fs.Kind {
+	case FieldKindNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("must be a number")
+		}
+	case FieldKindDate:
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Errorf("must be a date as YYYY-MM-DD")
+		}
+	case FieldKindBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+	}
+	if len(fs.Values) > 0 {
+		for _, allowed := range fs.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(fs.Values, ", "))
+	}
+	return nil
+}
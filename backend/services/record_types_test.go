@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+)
+
+// specialistTypes are the custom types of a deployment serving specialists
+const specialistTypes = `{
+	"vaccination": {
+		"fields": {
+			"vaccine": {"required": true},
+			"dose": {"kind": "number"},
+			"given_on": {"kind": "date"},
+			"booster": {"kind": "boolean"},
+			"route": {"values": ["intramuscular", "oral", "nasal"]}
+		},
+		"strict": true
+	},
+	"imaging": {
+		"fields": {"modality": {"required": true, "values": ["xray", "mri", "ct"]}}
+	}
+}`
+
+// newCustomTypesRecordsService returns a records service accepting the
+// specialist types as well as the built-in ones
+func newCustomTypesRecordsService(t *testing.T) *HealthRecordsService {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "record_types.json")
+	if err := os.WriteFile(path, []byte(specialistTypes), 0o600); err != nil {
+		t.Fatalf("write record types: %v", err)
+	}
+	types, err := LoadRecordTypes(path)
+	if err != nil {
+		t.Fatalf("LoadRecordTypes: %v", err)
+	}
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	return NewHealthRecordsService(db, &config.RecordsConfig{}, types, nil)
+}
+
+func TestLoadRecordTypes(t *testing.T) {
+	hrs := newCustomTypesRecordsService(t)
+	want := []string{"appointment", "lab_result", "prescription", "symptom", "imaging", "vaccination"}
+	if got := hrs.types.Names(); !slices.Equal(got, want) {
+		t.Errorf("Names = %v, want %v", got, want)
+	}
+
+	none, err := LoadRecordTypes("")
+	if err != nil || len(none.Names()) != 4 {
+		t.Errorf("no types file = %v, %v; want the built-in types", none.Names(), err)
+	}
+	var unset *RecordTypes
+	if len(unset.Names()) != 4 || unset.validate("lab_result") != nil || unset.validate("imaging") == nil {
+		t.Error("a nil RecordTypes does not accept exactly the built-in types")
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"not JSON":           `{"imaging": `,
+		"bad type name":      `{"x ray": {}}`,
+		"built-in redefined": `{"lab_result": {"fields": {"panel": {"required": true}}}}`,
+		"unknown field kind": `{"imaging": {"fields": {"modality": {"kind": "enum"}}}}`,
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_")+".json")
+		os.WriteFile(path, []byte(content), 0o600)
+		if _, err := LoadRecordTypes(path); err == nil {
+			t.Errorf("%s: LoadRecordTypes accepted %s", name, content)
+		}
+	}
+	if _, err := LoadRecordTypes(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadRecordTypes accepted a missing file")
+	}
+}
+
+func TestCustomRecordTypesAreAccepted(t *testing.T) {
+	hrs := newCustomTypesRecordsService(t)
+	ctx := context.Background()
+
+	record, err := hrs.CreateRecord(ctx, "user-1", "vaccination", "Flu shot", "", map[string]string{"vaccine": "influenza", "dose": "0.5"})
+	if err != nil {
+		t.Fatalf("create a vaccination record: %v", err)
+	}
+	if record.RecordType != "vaccination" {
+		t.Errorf("record type = %q, want vaccination", record.RecordType)
+	}
+	// Built-in types still work, with free-form metadata
+	if _, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Panel", "", map[string]string{"anything": "goes"}); err != nil {
+		t.Errorf("create a lab result: %v", err)
+	}
+	// Types neither built in nor configured stay rejected
+	if _, err := hrs.CreateRecord(ctx, "user-1", "dental", "Cleaning", "", nil); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("create an unknown type: %v, want %v", err, ErrInvalidArgument)
+	}
+	// Without the configuration a custom type is unknown
+	plain := newTestRecordsService(hrs.db, nil)
+	if _, err := plain.CreateRecord(ctx, "user-1", "vaccination", "Flu shot", "", map[string]string{"vaccine": "influenza"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("create a vaccination record without custom types: %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestCustomRecordTypeMetadataSchema(t *testing.T) {
+	hrs := newCustomTypesRecordsService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		recordType string
+		metadata   map[string]string
+		wantErr    string
+	}{
+		{"every field", "vaccination", map[string]string{"vaccine": "mmr", "dose": "1", "given_on": "2026-03-01", "booster": "true", "route": "oral"}, ""},
+		{"only the required field", "vaccination", map[string]string{"vaccine": "mmr"}, ""},
+		{"required field missing", "vaccination", map[string]string{"dose": "1"}, `metadata "vaccine"`},
+		{"required field blank", "vaccination", map[string]string{"vaccine": "  "}, `metadata "vaccine"`},
+		{"not a number", "vaccination", map[string]string{"vaccine": "mmr", "dose": "half"}, "must be a number"},
+		{"not a date", "vaccination", map[string]string{"vaccine": "mmr", "given_on": "01/03/2026"}, "YYYY-MM-DD"},
+		{"not a boolean", "vaccination", map[string]string{"vaccine": "mmr", "booster": "maybe"}, "true or false"},
+		{"value not allowed", "vaccination", map[string]string{"vaccine": "mmr", "route": "topical"}, "must be one of intramuscular, oral, nasal"},
+		{"unknown key on a strict type", "vaccination", map[string]string{"vaccine": "mmr", "lot": "A1"}, `do not take metadata "lot"`},
+		{"unknown key on a lenient type", "imaging", map[string]string{"modality": "mri", "lot": "A1"}, ""},
+		{"allowed value of a required field", "imaging", map[string]string{"modality": "ct"}, ""},
+		{"other value of a required field", "imaging", map[string]string{"modality": "ultrasound"}, "must be one of"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hrs.CreateRecord(ctx, "user-1", tt.recordType, "Record", "", tt.metadata)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CreateRecord: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidArgument) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CreateRecord = %v, want an invalid argument naming %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCustomRecordUpdatesFollowTheSchema(t *testing.T) {
+	hrs := newCustomTypesRecordsService(t)
+	ctx := context.Background()
+	record, err := hrs.CreateRecord(ctx, "user-1", "vaccination", "Flu shot", "", map[string]string{"vaccine": "influenza"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	// An update replaces the metadata, which must still fit the schema
+	for name, metadata := range map[string]map[string]string{
+		"dose as a word":      {"vaccine": "influenza", "dose": "lots"},
+		"required field gone": {"dose": "0.5"},
+		"key the type lacks":  {"vaccine": "influenza", "lot": "A1"},
+	} {
+		if _, err := hrs.UpdateRecord(ctx, "user-1", record.ID, "Flu shot", "", metadata); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("update with %s: %v, want %v", name, err, ErrInvalidArgument)
+		}
+	}
+	updated, err := hrs.UpdateRecord(ctx, "user-1", record.ID, "Flu shot", "", map[string]string{"vaccine": "influenza", "dose": "0.5"})
+	if err != nil {
+		t.Fatalf("update with a numeric dose: %v", err)
+	}
+	if !strings.Contains(updated.Metadata, `"dose":"0.5"`) || !strings.Contains(updated.Metadata, `"vaccine":"influenza"`) {
+		t.Errorf("metadata after the update = %s", updated.Metadata)
+	}
+}
+
+func TestChatToolsFilterByCustomRecordTypes(t *testing.T) {
+	hrs := newCustomTypesRecordsService(t)
+	ctx := context.Background()
+	if _, err := hrs.CreateRecord(ctx, "user-1", "imaging", "Knee MRI", "", map[string]string{"modality": "mri"}); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if _, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Panel", "", nil); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	tools := enabledChatTools([]string{ToolListRecentRecords}, hrs.types)
+	if desc := tools[0].Parameters["record_type"]; !strings.Contains(desc, "imaging") || !strings.Contains(desc, "vaccination") {
+		t.Errorf("record_type parameter = %q, want the custom types listed", desc)
+	}
+
+	result := runChatTool(ctx, hrs.db, hrs.types, "user-1", tools, ToolCall{ID: "call-1", Name: ToolListRecentRecords, Arguments: map[string]string{"record_type": "imaging"}})
+	if result.IsError || !strings.Contains(result.Content, "Knee MRI") || strings.Contains(result.Content, "Panel") {
+		t.Errorf("imaging records = %+v, want only the MRI", result)
+	}
+	result = runChatTool(ctx, hrs.db, hrs.types, "user-1", tools, ToolCall{ID: "call-2", Name: ToolListRecentRecords, Arguments: map[string]string{"record_type": "dental"}})
+	if !result.IsError {
+		t.Errorf("unknown type filter = %+v, want an error result", result)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReferenceDataset keeps a reference dataset (medication names, and any
+// other file-backed lookup tables) parsed in memory. It is loaded once at
+// startup; Refresh reloads it when the file changes or the refresh interval
+// has passed, so callers never read the file on the request path.
+type ReferenceDataset[T any] struct {
+	name            string
+	path            string
+	parse           func(io.Reader) (T, error)
+	refreshInterval time.Duration // 0 reloads only on file changes
+	now             func() time.Time
+
+	mu       sync.RWMutex
+	value    T
+	modTime  time.Time
+	size     int64
+	loadedAt time.Time
+	loads    int
+}
+
+// LoadReferenceDataset parses the file at path with parse. A missing or
+// unparseable file is an error, so the server refuses to start with a
+// broken dataset rather than silently running without it.
+func LoadReferenceDataset[T any](name, path string, refreshInterval time.Duration, parse func(io.Reader) (T, error)) (*ReferenceDataset[T], error) {
+	rd := &ReferenceDataset[T]{
+		name:            name,
+		path:            path,
+		parse:           parse,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+	}
+	if _, err := rd.reload(true); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// Get returns the current dataset. A nil ReferenceDataset returns T's zero
+// value.
+func (rd *ReferenceDataset[T]) Get() T {
+	if rd == nil {
+		var zero T
+		return zero
+	}
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return rd.value
+}
+
+// Loads returns how many times the dataset has been parsed
+func (rd *ReferenceDataset[T]) Loads() int {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return rd.loads
+}
+
+// Refresh reloads the dataset if its file has changed or the refresh
+// interval has passed, and reports whether it did. If the new file fails to
+// load, the previous dataset stays in use and the error is returned.
+func (rd *ReferenceDataset[T]) Refresh() (bool, error) {
+	rd.mu.RLock()
+	due := rd.refreshInterval > 0 && rd.now().Sub(rd.loadedAt) >= rd.refreshInterval
+	rd.mu.RUnlock()
+	return rd.reload(due)
+}
+
+// reload parses the file when force is set or its size or modification
+// time differ from the loaded copy
+func (rd *ReferenceDataset[T]) reload(force bool) (bool, error) {
+	info, err := os.Stat(rd.path)
+	if err != nil {
+		return false, fmt.Errorf("%s dataset %s: %w", rd.name, rd.path, err)
+	}
+
+	rd.mu.RLock()
+	unchanged := info.ModTime().Equal(rd.modTime) && info.Size() == rd.size
+	rd.mu.RUnlock()
+	if unchanged && !force {
+		return false, nil
+	}
+
+	f, err := os.Open(rd.path)
+	if err != nil {
+		return false, fmt.Errorf("%s dataset %s: %w", rd.name, rd.path, err)
+	}
+	defer f.Close()
+
+	value, err := rd.parse(f)
+	if err != nil {
+		return false, fmt.Errorf("%s dataset %s: %w", rd.name, rd.path, err)
+	}
+
+	rd.mu.Lock()
+	rd.value = value
+	rd.modTime = info.ModTime()
+	rd.size = info.Size()
+	rd.loadedAt = rd.now()
+	rd.loads++
+	rd.mu.Unlock()
+
+	log.Printf("Loaded %s dataset from %s", rd.name, rd.path)
+	return true, nil
+}
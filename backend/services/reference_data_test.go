@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeDataset writes content to path with the given modification time, so
+// a rewrite is seen as a change even on filesystems with coarse timestamps
+func writeDataset(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write dataset: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("set dataset time: %v", err)
+	}
+}
+
+const testMedicationCSV = "generic,brands\nacetaminophen,Tylenol;Panadol\nibuprofen,Advil\n"
+
+func TestReferenceDatasetLoadsOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "medications.csv")
+	writeDataset(t, path, testMedicationCSV, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+
+	medications, err := LoadMedicationDataset(path, 0)
+	if err != nil {
+		t.Fatalf("LoadMedicationDataset: %v", err)
+	}
+	as := newTestAIService(t, newTestDB(t), nil)
+	as.medications = medications
+	as.provider = &fakeProvider{scan: map[string]string{"medication": "Tylenol 500mg"}}
+
+	for i := 0; i < 5; i++ {
+		result, err := as.extract(context.Background(), []byte{byte(i)}, false)
+		if err != nil {
+			t.Fatalf("extract: %v", err)
+		}
+		if got := result.data["medication_canonical"]; got != "acetaminophen" {
+			t.Fatalf("scanned medication_canonical = %q, want acetaminophen", got)
+		}
+	}
+	if loads := medications.Loads(); loads != 1 {
+		t.Errorf("dataset parsed %d times, want once at startup", loads)
+	}
+	if changed, err := medications.Refresh(); changed || err != nil {
+		t.Errorf("Refresh of an unchanged file = %v, %v; want no reload", changed, err)
+	}
+}
+
+func TestReferenceDatasetReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "medications.csv")
+	loaded := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	writeDataset(t, path, testMedicationCSV, loaded)
+
+	medications, err := LoadMedicationDataset(path, 0)
+	if err != nil {
+		t.Fatalf("LoadMedicationDataset: %v", err)
+	}
+	if got := medications.Get().Normalize("Nurofen"); got.Kind == MedicationMatchBrand {
+		t.Fatalf("Nurofen matched before it was added: %+v", got)
+	}
+
+	writeDataset(t, path, testMedicationCSV[:len(testMedicationCSV)-1]+";Nurofen\n", loaded.Add(time.Minute))
+	changed, err := medications.Refresh()
+	if err != nil || !changed {
+		t.Fatalf("Refresh after a change = %v, %v; want a reload", changed, err)
+	}
+	if got := medications.Get().Normalize("Nurofen"); got.Canonical != "ibuprofen" || got.Kind != MedicationMatchBrand {
+		t.Errorf("Normalize(Nurofen) after reload = %+v, want the ibuprofen brand", got)
+	}
+	if loads := medications.Loads(); loads != 2 {
+		t.Errorf("dataset parsed %d times, want 2", loads)
+	}
+}
+
+func TestReferenceDatasetReloadsAfterInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "medications.csv")
+	writeDataset(t, path, testMedicationCSV, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+
+	medications, err := LoadMedicationDataset(path, time.Hour)
+	if err != nil {
+		t.Fatalf("LoadMedicationDataset: %v", err)
+	}
+	clock := &testClock{now: time.Now()}
+	medications.now = clock.Now
+
+	clock.Advance(59 * time.Minute)
+	if changed, _ := medications.Refresh(); changed {
+		t.Error("reloaded before the refresh interval")
+	}
+	clock.Advance(time.Minute)
+	if changed, err := medications.Refresh(); !changed || err != nil {
+		t.Errorf("Refresh after the interval = %v, %v; want a reload", changed, err)
+	}
+	if changed, _ := medications.Refresh(); changed {
+		t.Error("reloaded again straight after a reload")
+	}
+}
+
+func TestReferenceDatasetRejectsBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := LoadMedicationDataset(filepath.Join(dir, "missing.csv"), 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing dataset: error = %v, want %v", err, fs.ErrNotExist)
+	}
+	for name, content := range map[string]string{
+		"wrong-columns.csv": "generic,brands\nacetaminophen\n",
+		"header-only.csv":   "generic,brands\n",
+		"bad-quotes.csv":    "generic,brands\n\"acetaminophen,Tylenol\n",
+	} {
+		path := filepath.Join(dir, name)
+		writeDataset(t, path, content, modTime)
+		if _, err := LoadMedicationDataset(path, 0); err == nil {
+			t.Errorf("%s loaded without an error", name)
+		}
+	}
+
+	// A corrupt rewrite keeps the dataset already in memory
+	path := filepath.Join(dir, "medications.csv")
+	writeDataset(t, path, testMedicationCSV, modTime)
+	medications, err := LoadMedicationDataset(path, 0)
+	if err != nil {
+		t.Fatalf("LoadMedicationDataset: %v", err)
+	}
+	writeDataset(t, path, "generic,brands\nacetaminophen\n", modTime.Add(time.Minute))
+	if changed, err := medications.Refresh(); changed || err == nil {
+		t.Errorf("Refresh of a corrupt file = %v, %v; want an error", changed, err)
+	}
+	if got := medications.Get().Normalize("Tylenol"); got.Canonical != "acetaminophen" {
+		t.Errorf("previous dataset lost after a failed reload: %+v", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove dataset: %v", err)
+	}
+	if _, err := medications.Refresh(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Refresh of a deleted file: error = %v, want %v", err, fs.ErrNotExist)
+	}
+	if got := medications.Get().Normalize("Advil"); got.Canonical != "ibuprofen" {
+		t.Errorf("previous dataset lost after the file was deleted: %+v", got)
+	}
+}
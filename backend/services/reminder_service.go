@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// reminderDispatchBatch bounds how many due reminders one dispatch run sends
+const reminderDispatchBatch = 100
+
+// followUpRule inspects a newly created record and returns a reminder to
+// schedule, or nil if the rule does not apply.
+type followUpRule func(record *models.HealthRecord, metadata map[string]string) *models.Reminder
+
+// followUpRules are evaluated in order whenever a record is created
+var followUpRules = []followUpRule{
+	courseEndFollowUp,
+}
+
+// courseEndFollowUp schedules a check-in for when a prescription's course
+// ends, e.g. "7 days" scanned from the label.
+func courseEndFollowUp(record *models.HealthRecord, metadata map[string]string) *models.Reminder {
+	if record.RecordType != "prescription" {
+		return nil
+	}
+	duration, ok := ParseCourseDuration(metadata["duration"])
+	if !ok {
+		return nil
+	}
+
+	medication := metadata["medication"]
+	if medication == "" {
+		medication = record.Title
+	}
+	dueAt := record.CreatedAt.Add(duration)
+
+	return &models.Reminder{
+		Kind:    models.ReminderKindCourseEnd,
+		Message: fmt.Sprintf("Your %s course ends %s — feeling better?", medication, dueAt.Format("Monday")),
+		DueAt:   dueAt,
+	}
+}
+
+// createFollowUpReminders evaluates follow-up rules for a new record inside tx
+func createFollowUpReminders(tx *gorm.DB, record *models.HealthRecord, metadata map[string]string) error {
+	for _, rule := range followUpRules {
+		reminder := rule(record, metadata)
+		if reminder == nil {
+			continue
+		}
+		reminder.ID = uuid.New().String()
+		reminder.UserID = record.UserID
+		reminder.RecordID = record.ID
+		reminder.CreatedAt = time.Now()
+		if err := tx.Create(reminder).Error; err != nil {
+			return fmt.Errorf("failed to create reminder: %w", err)
+		}
+	}
+	return nil
+}
+
+// cancelRecordReminders cancels pending reminders for a record inside tx
+func cancelRecordReminders(tx *gorm.DB, recordID string) error {
+	return tx.Model(&models.Reminder{}).
+		Where("record_id = ? AND sent_at IS NULL AND cancelled_at IS NULL", recordID).
+		Update("cancelled_at", time.Now()).Error
+}
+
+type ReminderService struct {
+	db       *gorm.DB
+	notifier Notifier
+}
+
+func NewReminderService(db *gorm.DB, notifier Notifier) *ReminderService {
+	return &ReminderService{
+		db:       db,
+		notifier: notifier,
+	}
+}
+
+// SetRecordReminder schedules a manual "review this record" reminder
+func (rs *ReminderService) SetRecordReminder(ctx context.Context, userID, recordID string, remindAt time.Time, note string) (*models.Reminder, error) {
+	if !remindAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: reminder time must be in the future", ErrInvalidArgument)
+	}
+
+	var record models.HealthRecord
+	if err := rs.db.WithContext(ctx).Scopes(scopeOwner(userID)).First(&record, "id = ?", recordID).Error; err != nil {
+		return nil, fmt.Errorf("%w: record %s", ErrNotFound, recordID)
+	}
+
+	message := note
+	if message == "" {
+		message = fmt.Sprintf("Review your record: %s", record.Title)
+	}
+
+	reminder := models.Reminder{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		RecordID:  recordID,
+		Kind:      models.ReminderKindManual,
+		Message:   message,
+		DueAt:     remindAt,
+		CreatedAt: time.Now(),
+	}
+	if err := rs.db.WithContext(ctx).Create(&reminder).Error; err != nil {
+		return nil, fmt.Errorf("failed to create reminder: %w", err)
+	}
+
+	return &reminder, nil
+}
+
+// reminderTitle is the notification title for a reminder kind
+func reminderTitle(kind string) string {
+	if kind == models.ReminderKindDose {
+		return "Medication reminder"
+	}
+	return "Health record reminder"
+}
+
+// DispatchDue sends notifications for reminders that have come due and
+// returns how many were sent. Failed sends stay pending for the next run.
+func (rs *ReminderService) DispatchDue(ctx context.Context) (int, error) {
+	var due []models.Reminder
+	if err := rs.db.WithContext(ctx).Where("due_at <= ? AND sent_at IS NULL AND cancelled_at IS NULL", time.Now()).
+		Order("due_at ASC").
+		Limit(reminderDispatchBatch).
+		Find(&due).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch due reminders: %w", err)
+	}
+
+	sent := 0
+	for _, reminder := range due {
+		err := rs.notifier.Notify(ctx, Notification{
+			UserID: reminder.UserID,
+			Title:  reminderTitle(reminder.Kind),
+			Body:   reminder.Message,
+			Link:   "clarity://records/" + reminder.RecordID,
+		})
+		if err != nil {
+			log.Printf("Failed to send reminder %s: %v", reminder.ID, err)
+			continue
+		}
+		if err := rs.db.WithContext(ctx).Model(&reminder).Update("sent_at", time.Now()).Error; err != nil {
+			return sent, fmt.Errorf("failed to mark reminder sent: %w", err)
+		}
+		sent++
+	}
+
+	if sent > 0 {
+		log.Printf("Dispatched %d reminders", sent)
+	}
+	return sent, nil
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// recordingNotifier keeps the notifications it is asked to send, failing
+// for users in fail
+type recordingNotifier struct {
+	sent []Notification
+	fail map[string]bool
+}
+
+func (rn *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	if rn.fail[n.UserID] {
+		return errors.New("push service down")
+	}
+	rn.sent = append(rn.sent, n)
+	return nil
+}
+
+func recordReminders(t *testing.T, db *gorm.DB, recordID string) []models.Reminder {
+	t.Helper()
+	var reminders []models.Reminder
+	if err := db.Where("record_id = ?", recordID).Order("due_at").Find(&reminders).Error; err != nil {
+		t.Fatalf("load reminders: %v", err)
+	}
+	return reminders
+}
+
+func TestPrescriptionCreatesCourseEndReminder(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	ctx := context.Background()
+
+	record, err := hrs.CreateRecord(ctx, "user-1", "prescription", "Amoxicillin 500mg", "", map[string]string{
+		"medication": "Amoxicillin",
+		"duration":   "7 days",
+	})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	reminders := recordReminders(t, db, record.ID)
+	if len(reminders) != 1 {
+		t.Fatalf("created %d reminders, want 1", len(reminders))
+	}
+	reminder := reminders[0]
+	if reminder.Kind != models.ReminderKindCourseEnd || reminder.UserID != "user-1" {
+		t.Errorf("reminder = %+v", reminder)
+	}
+	if want := record.CreatedAt.Add(7 * 24 * time.Hour); !reminder.DueAt.Equal(want) {
+		t.Errorf("due at %v, want %v", reminder.DueAt, want)
+	}
+	if !strings.Contains(reminder.Message, "Amoxicillin") || !strings.Contains(reminder.Message, reminder.DueAt.Format("Monday")) {
+		t.Errorf("message %q does not name the medication and end day", reminder.Message)
+	}
+}
+
+func TestNoCourseEndReminderWithoutDuration(t *testing.T) {
+	tests := []struct {
+		name       string
+		recordType string
+		metadata   map[string]string
+	}{
+		{"open-ended course", "prescription", map[string]string{"duration": "until finished"}},
+		{"no duration", "prescription", nil},
+		{"not a prescription", "lab_result", map[string]string{"duration": "7 days"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			record, err := newTestRecordsService(db, nil).CreateRecord(context.Background(), "user-1", tt.recordType, "Record", "", tt.metadata)
+			if err != nil {
+				t.Fatalf("CreateRecord: %v", err)
+			}
+			if reminders := recordReminders(t, db, record.ID); len(reminders) != 0 {
+				t.Errorf("created reminders %+v", reminders)
+			}
+		})
+	}
+}
+
+func TestDeleteRecordCancelsReminders(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	rs := NewReminderService(db, &recordingNotifier{})
+	ctx := context.Background()
+
+	record, err := hrs.CreateRecord(ctx, "user-1", "prescription", "Amoxicillin", "", map[string]string{"duration": "5 days"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if _, err := rs.SetRecordReminder(ctx, "user-1", record.ID, time.Now().Add(time.Hour), ""); err != nil {
+		t.Fatalf("SetRecordReminder: %v", err)
+	}
+	if err := hrs.DeleteRecord(ctx, "user-1", record.ID); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+
+	reminders := recordReminders(t, db, record.ID)
+	if len(reminders) != 2 {
+		t.Fatalf("got %d reminders, want 2", len(reminders))
+	}
+	for _, reminder := range reminders {
+		if reminder.CancelledAt == nil {
+			t.Errorf("%s reminder not cancelled with its record", reminder.Kind)
+		}
+	}
+}
+
+func TestSetRecordReminder(t *testing.T) {
+	db := newTestDB(t)
+	rs := NewReminderService(db, &recordingNotifier{})
+	createRecord(t, db, "record-1", "user-1", "")
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		userID   string
+		remindAt time.Time
+		note     string
+		want     error
+		message  string
+	}{
+		{"default message", "user-1", time.Now().Add(time.Hour), "", nil, "Review your record: Record record-1"},
+		{"with note", "user-1", time.Now().Add(time.Hour), "Ask about the results", nil, "Ask about the results"},
+		{"in the past", "user-1", time.Now().Add(-time.Minute), "", ErrInvalidArgument, ""},
+		{"another user's record", "user-2", time.Now().Add(time.Hour), "", ErrNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reminder, err := rs.SetRecordReminder(ctx, tt.userID, "record-1", tt.remindAt, tt.note)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("SetRecordReminder() error = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			if reminder.Kind != models.ReminderKindManual || reminder.Message != tt.message || !reminder.DueAt.Equal(tt.remindAt) {
+				t.Errorf("reminder = %+v", reminder)
+			}
+		})
+	}
+}
+
+func TestDispatchDue(t *testing.T) {
+	db := newTestDB(t)
+	notifier := &recordingNotifier{fail: map[string]bool{"user-down": true}}
+	rs := NewReminderService(db, notifier)
+	ctx := context.Background()
+
+	now := time.Now()
+	cancelled := now.Add(-time.Minute)
+	for _, reminder := range []models.Reminder{
+		{ID: "due", UserID: "user-1", RecordID: "record-1", Kind: models.ReminderKindManual, Message: "Review", DueAt: now.Add(-time.Hour)},
+		{ID: "not-due", UserID: "user-1", RecordID: "record-1", Kind: models.ReminderKindManual, DueAt: now.Add(time.Hour)},
+		{ID: "cancelled", UserID: "user-1", RecordID: "record-2", Kind: models.ReminderKindCourseEnd, DueAt: now.Add(-time.Hour), CancelledAt: &cancelled},
+		{ID: "failing", UserID: "user-down", RecordID: "record-3", Kind: models.ReminderKindManual, DueAt: now.Add(-time.Hour)},
+	} {
+		if err := db.Create(&reminder).Error; err != nil {
+			t.Fatalf("create reminder: %v", err)
+		}
+	}
+
+	sent, err := rs.DispatchDue(ctx)
+	if err != nil {
+		t.Fatalf("DispatchDue: %v", err)
+	}
+	if sent != 1 || len(notifier.sent) != 1 {
+		t.Fatalf("sent %d (%d notifications), want 1", sent, len(notifier.sent))
+	}
+	if n := notifier.sent[0]; n.UserID != "user-1" || n.Body != "Review" || n.Link != "clarity://records/record-1" {
+		t.Errorf("notification = %+v", n)
+	}
+
+	var failing models.Reminder
+	db.First(&failing, "id = ?", "failing")
+	if failing.SentAt != nil {
+		t.Error("failed send marked as sent")
+	}
+
+	if sent, err = rs.DispatchDue(ctx); err != nil || sent != 0 {
+		t.Errorf("second dispatch sent %d, %v, want nothing new", sent, err)
+	}
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Outcomes of re-extracting one record
+const (
+	reprocessImproved  = "improved"
+	reprocessUnchanged = "unchanged"
+	reprocessSkipped   = "skipped"
+	reprocessFailed    = "failed"
+)
+
+// ReprocessCohort selects the records a reprocess job re-extracts. Only
+// records created from a stored scan are eligible.
+type ReprocessCohort struct {
+	RecordType   string    // empty matches every type
+	CreatedFrom  time.Time // zero leaves the range open
+	CreatedTo    time.Time // zero leaves the range open
+	BelowVersion int       // extraction version to upgrade from; 0 means ExtractionVersion
+}
+
+// ReprocessService re-runs the scan extraction pipeline over historical
+// records when it improves. Jobs are stored and advanced in batches by the
+// reprocess scheduler job, so they survive restarts and never exceed the
+// configured provider call rate.
+type ReprocessService struct {
+	db     *gorm.DB
+	ai     *AIService
+	config *config.JobsConfig
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+func NewReprocessService(db *gorm.DB, ai *AIService, cfg *config.JobsConfig) *ReprocessService {
+	return &ReprocessService{
+		db:     db,
+		ai:     ai,
+		config: cfg,
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+// StartJob records a new job for the cohort; the scheduler picks it up on
+// its next run
+func (rs *ReprocessService) StartJob(ctx context.Context, cohort ReprocessCohort) (*models.ReprocessJob, error) {
+	if cohort.BelowVersion == 0 {
+		cohort.BelowVersion = ExtractionVersion
+	}
+	if cohort.BelowVersion < 0 || cohort.BelowVersion > ExtractionVersion {
+		return nil, fmt.Errorf("%w: below_version must be 1 to %d", ErrInvalidArgument, ExtractionVersion)
+	}
+	if cohort.RecordType != "" {
+		if err := rs.ai.recordTypes.validate(cohort.RecordType); err != nil {
+			return nil, err
+		}
+	}
+	if !cohort.CreatedFrom.IsZero() && !cohort.CreatedTo.IsZero() && cohort.CreatedTo.Before(cohort.CreatedFrom) {
+		return nil, fmt.Errorf("%w: created range ends before it starts", ErrInvalidArgument)
+	}
+
+	now := rs.now()
+	job := models.ReprocessJob{
+		ID:           uuid.New().String(),
+		Status:       models.ReportStatusRunning,
+		RecordType:   cohort.RecordType,
+		BelowVersion: cohort.BelowVersion,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if !cohort.CreatedFrom.IsZero() {
+		job.CreatedFrom = &cohort.CreatedFrom
+	}
+	if !cohort.CreatedTo.IsZero() {
+		job.CreatedTo = &cohort.CreatedTo
+	}
+	if err := rs.db.WithContext(ctx).Create(&job).Error; err != nil {
+		return nil, fmt.Errorf("failed to create reprocess job: %w", err)
+	}
+	return &job, nil
+}
+
+// GetJob returns a job and its progress
+func (rs *ReprocessService) GetJob(ctx context.Context, id string) (*models.ReprocessJob, error) {
+	var job models.ReprocessJob
+	if err := rs.db.WithContext(ctx).First(&job, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: reprocess job %s", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to fetch reprocess job: %w", err)
+	}
+	return &job, nil
+}
+
+// RunPending advances the oldest running job by one batch and returns how
+// many records it handled. A job whose cohort is exhausted is completed.
+func (rs *ReprocessService) RunPending(ctx context.Context) (int, error) {
+	var job models.ReprocessJob
+	err := rs.db.WithContext(ctx).Where("status = ?", models.ReportStatusRunning).Order("created_at ASC").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch reprocess jobs: %w", err)
+	}
+	return rs.runBatch(ctx, &job)
+}
+
+// runBatch re-extracts the next batch of the job's cohort, saving progress
+// after every record. It stops early, leaving the job running, when the
+// context ends or the provider becomes unavailable.
+func (rs *ReprocessService) runBatch(ctx context.Context, job *models.ReprocessJob) (int, error) {
+	batchSize := rs.config.ReprocessBatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+	var pace time.Duration
+	if rs.config.ReprocessRatePerMinute > 0 {
+		pace = time.Minute / time.Duration(rs.config.ReprocessRatePerMinute)
+	}
+
+	var records []models.HealthRecord
+	if err := rs.cohortQuery(ctx, job).Limit(batchSize).Find(&records).Error; err != nil {
+		return 0, fmt.Errorf("failed to select reprocess cohort: %w", err)
+	}
+
+	handled := 0
+	for i := range records {
+		if i > 0 && pace > 0 {
+			if err := rs.sleep(ctx, pace); err != nil {
+				return handled, err
+			}
+		}
+
+		record := &records[i]
+		outcome, err := rs.reprocessRecord(ctx, job.ID, record)
+		if errors.Is(err, ErrUnavailable) || ctx.Err() != nil {
+			// Leave the record for the next run rather than counting it
+			return handled, err
+		}
+		if err != nil {
+			log.Printf("Reprocess job %s: record %s failed: %v", job.ID, record.ID, err)
+		}
+
+		switch outcome {
+		case reprocessImproved:
+			job.Improved++
+		case reprocessUnchanged:
+			job.Unchanged++
+		case reprocessSkipped:
+			job.Skipped++
+		default:
+			job.Failed++
+		}
+		job.CursorCreatedAt = record.CreatedAt
+		job.CursorID = record.ID
+		if err := rs.saveProgress(ctx, job); err != nil {
+			return handled, err
+		}
+		handled++
+	}
+
+	if len(records) < batchSize {
+		now := rs.now()
+		job.Status = models.ReportStatusCompleted
+		job.CompletedAt = &now
+		if err := rs.saveProgress(ctx, job); err != nil {
+			return handled, err
+		}
+		log.Printf("Reprocess job %s finished: %d improved, %d unchanged, %d skipped, %d failed",
+			job.ID, job.Improved, job.Unchanged, job.Skipped, job.Failed)
+	}
+	return handled, nil
+}
+
+// cohortQuery selects the job's remaining records in (created_at, id)
+// order after its cursor
+func (rs *ReprocessService) cohortQuery(ctx context.Context, job *models.ReprocessJob) *gorm.DB {
+	query := rs.db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Where("scan_id <> '' AND extraction_version < ?", job.BelowVersion)
+	if job.RecordType != "" {
+		query = query.Where("record_type = ?", job.RecordType)
+	}
+	if job.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *job.CreatedFrom)
+	}
+	if job.CreatedTo != nil {
+		query = query.Where("created_at < ?", *job.CreatedTo)
+	}
+	if job.CursorID != "" {
+		query = query.Where("created_at > ? OR (created_at = ? AND id > ?)",
+			job.CursorCreatedAt, job.CursorCreatedAt, job.CursorID)
+	}
+	return query.Order("created_at ASC, id ASC")
+}
+
+func (rs *ReprocessService) saveProgress(ctx context.Context, job *models.ReprocessJob) error {
+	job.UpdatedAt = rs.now()
+	if err := rs.db.WithContext(ctx).Save(job).Error; err != nil {
+		return fmt.Errorf("failed to save reprocess job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// reprocessRecord re-extracts one record from its stored scan. Records the
+// user has edited are skipped, so a re-extraction never overwrites a
+// correction. A changed extraction replaces the extracted metadata keys and
+// keeps the previous version as a revision; other metadata keys the user
+// or app added are kept.
+func (rs *ReprocessService) reprocessRecord(ctx context.Context, jobID string, record *models.HealthRecord) (string, error) {
+	if record.UserEditedAt != nil {
+		return reprocessSkipped, nil
+	}
+
+	var scan models.ScanInput
+	if err := rs.db.WithContext(ctx).First(&scan, "id = ?", record.ScanID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return reprocessSkipped, nil
+		}
+		return reprocessFailed, err
+	}
+
+	result, err := rs.ai.extract(ctx, scan.Image, false)
+	if err != nil {
+		return reprocessFailed, err
+	}
+	extracted, provider := result.data, result.provider
+
+	current := make(map[string]string)
+	if record.Metadata != "" {
+		if err := json.Unmarshal([]byte(record.Metadata), &current); err != nil {
+			return reprocessFailed, fmt.Errorf("failed to decode metadata: %w", err)
+		}
+	}
+	merged := make(map[string]string, len(current)+len(extracted))
+	for k, v := range current {
+		merged[k] = v
+	}
+	changed := false
+	for k, v := range extracted {
+		if merged[k] != v {
+			merged[k] = v
+			changed = true
+		}
+	}
+
+	outcome := reprocessUnchanged
+	err = rs.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{"extraction_version": ExtractionVersion, "extraction_provider": provider}
+		if changed {
+			encoded, err := json.Marshal(merged)
+			if err != nil {
+				return err
+			}
+			updates["metadata"] = string(encoded)
+			updates["updated_at"] = rs.now()
+
+			revision := models.RecordRevision{
+				ID:                uuid.New().String(),
+				RecordID:          record.ID,
+				Title:             record.Title,
+				Description:       record.Description,
+				Metadata:          record.Metadata,
+				ExtractionVersion: record.ExtractionVersion,
+				ReplacedBy:        jobID,
+				CreatedAt:         rs.now(),
+			}
+			if err := tx.Create(&revision).Error; err != nil {
+				return fmt.Errorf("failed to save revision: %w", err)
+			}
+		}
+
+		// Guard against an edit made while the provider call was running
+		result := tx.Model(&models.HealthRecord{}).
+			Where("id = ? AND user_edited_at IS NULL", record.ID).
+			Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update record: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			outcome = reprocessSkipped
+			return errSkipRecord
+		}
+
+		if changed {
+			var updated models.HealthRecord
+			if err := tx.First(&updated, "id = ?", record.ID).Error; err != nil {
+				return err
+			}
+			if err := stampRecordSync(tx, &updated); err != nil {
+				return err
+			}
+			if err := indexRecord(tx, &updated); err != nil {
+				return fmt.Errorf("failed to index record: %w", err)
+			}
+			if err := recordChange(tx, ChangeActorSystem, &updated, models.ChangeEntityRecord, updated.ID,
+				models.ChangeKindUpdated, describeRecordChange(record, &updated)); err != nil {
+				return err
+			}
+			outcome = reprocessImproved
+		}
+		return nil
+	})
+	if errors.Is(err, errSkipRecord) {
+		return reprocessSkipped, nil
+	}
+	if err != nil {
+		return reprocessFailed, err
+	}
+	return outcome, nil
+}
+
+// errSkipRecord rolls back a re-extraction that lost a race with a user edit
+var errSkipRecord = errors.New("record edited during reprocessing")
+
+// sleepContext waits for d or until ctx ends
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// createScannedRecord stores a prescription record extracted at version
+// from the stored scan scanID
+func createScannedRecord(t *testing.T, db *gorm.DB, id, scanID string, version int, metadata string, createdAt time.Time) *models.HealthRecord {
+	t.Helper()
+	record := &models.HealthRecord{
+		ID:                id,
+		UserID:            "user-1",
+		RecordType:        "prescription",
+		Title:             "Prescription " + id,
+		Metadata:          metadata,
+		Sensitivity:       models.SensitivityStandard,
+		ScanID:            scanID,
+		ExtractionVersion: version,
+		CreatedAt:         createdAt,
+		UpdatedAt:         createdAt,
+	}
+	if err := db.Create(record).Error; err != nil {
+		t.Fatalf("create record %s: %v", id, err)
+	}
+	return record
+}
+
+// newTestReprocessService returns a ReprocessService over a fake provider
+// that never sleeps between calls
+func newTestReprocessService(t *testing.T, db *gorm.DB, provider AIProvider, batchSize int) *ReprocessService {
+	t.Helper()
+	as := newTestAIService(t, db, nil)
+	as.provider = provider
+	rs := NewReprocessService(db, as, &config.JobsConfig{ReprocessBatchSize: batchSize, ReprocessRatePerMinute: 60})
+	rs.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	return rs
+}
+
+func loadRecord(t *testing.T, db *gorm.DB, id string) models.HealthRecord {
+	t.Helper()
+	var record models.HealthRecord
+	if err := db.First(&record, "id = ?", id).Error; err != nil {
+		t.Fatalf("load record %s: %v", id, err)
+	}
+	return record
+}
+
+func TestReprocessJobUpgradesOldExtractions(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	if err := db.Create(&models.ScanInput{ID: "scan-1", UserID: "user-1", Image: []byte("image")}).Error; err != nil {
+		t.Fatalf("create scan: %v", err)
+	}
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	edited := start.Add(time.Hour)
+
+	createScannedRecord(t, db, "rec-improved", "scan-1", 0, `{"medication":"Amoxicilin","note":"with food"}`, start)
+	createScannedRecord(t, db, "rec-unchanged", "scan-1", 0, `{"medication":"Amoxicillin"}`, start.Add(time.Minute))
+	createScannedRecord(t, db, "rec-edited", "scan-1", 0, `{"medication":"Amoxicilin"}`, start.Add(2*time.Minute))
+	db.Model(&models.HealthRecord{}).Where("id = ?", "rec-edited").Update("user_edited_at", edited)
+	createScannedRecord(t, db, "rec-no-scan", "scan-gone", 0, `{"medication":"Amoxicilin"}`, start.Add(3*time.Minute))
+	createScannedRecord(t, db, "rec-current", "scan-1", ExtractionVersion, `{"medication":"Amoxicilin"}`, start.Add(4*time.Minute))
+	createScannedRecord(t, db, "rec-typed", "", 0, `{"medication":"Amoxicilin"}`, start.Add(5*time.Minute))
+
+	provider := &fakeProvider{scan: map[string]string{"medication": "Amoxicillin"}}
+	rs := newTestReprocessService(t, db, provider, 2)
+	ctx := context.Background()
+
+	job, err := rs.StartJob(ctx, ReprocessCohort{})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+
+	// Two records a batch: the third run finds one record and completes
+	for run, want := range []int{2, 2, 0} {
+		handled, err := rs.RunPending(ctx)
+		if err != nil {
+			t.Fatalf("RunPending %d: %v", run+1, err)
+		}
+		if handled != want {
+			t.Errorf("RunPending %d handled %d records, want %d", run+1, handled, want)
+		}
+	}
+
+	job, err = rs.GetJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if job.Status != models.ReportStatusCompleted || job.CompletedAt == nil {
+		t.Errorf("job status = %s, want completed", job.Status)
+	}
+	if job.Improved != 1 || job.Unchanged != 1 || job.Skipped != 2 || job.Failed != 0 {
+		t.Errorf("job counts = %d improved, %d unchanged, %d skipped, %d failed; want 1, 1, 2, 0",
+			job.Improved, job.Unchanged, job.Skipped, job.Failed)
+	}
+	if provider.scans != 2 {
+		t.Errorf("provider called %d times, want 2", provider.scans)
+	}
+
+	improved := loadRecord(t, db, "rec-improved")
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(improved.Metadata), &metadata); err != nil {
+		t.Fatalf("decode metadata: %v", err)
+	}
+	if metadata["medication"] != "Amoxicillin" || metadata["note"] != "with food" {
+		t.Errorf("improved metadata = %v, want the new extraction and the kept note", metadata)
+	}
+	if improved.ExtractionVersion != ExtractionVersion || improved.ExtractionProvider != "fake" {
+		t.Errorf("improved record tagged version %d from %q, want %d from fake",
+			improved.ExtractionVersion, improved.ExtractionProvider, ExtractionVersion)
+	}
+	var revisions []models.RecordRevision
+	db.Where("record_id = ?", "rec-improved").Find(&revisions)
+	if len(revisions) != 1 || revisions[0].ReplacedBy != job.ID || revisions[0].ExtractionVersion != 0 ||
+		revisions[0].Metadata != `{"medication":"Amoxicilin","note":"with food"}` {
+		t.Errorf("revisions of the improved record = %+v", revisions)
+	}
+
+	if unchanged := loadRecord(t, db, "rec-unchanged"); unchanged.ExtractionVersion != ExtractionVersion {
+		t.Errorf("unchanged record tagged version %d, want %d", unchanged.ExtractionVersion, ExtractionVersion)
+	}
+	for _, id := range []string{"rec-edited", "rec-no-scan", "rec-typed"} {
+		if record := loadRecord(t, db, id); record.ExtractionVersion != 0 || record.Metadata != `{"medication":"Amoxicilin"}` {
+			t.Errorf("%s was reprocessed: version %d, metadata %s", id, record.ExtractionVersion, record.Metadata)
+		}
+	}
+	var count int64
+	db.Model(&models.RecordRevision{}).Where("record_id <> ?", "rec-improved").Count(&count)
+	if count != 0 {
+		t.Errorf("%d revisions saved for records that did not change", count)
+	}
+}
+
+func TestReprocessJobCohort(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	db.Create(&models.ScanInput{ID: "scan-1", UserID: "user-1", Image: []byte("image")})
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	createScannedRecord(t, db, "rec-early", "scan-1", 0, `{}`, start)
+	createScannedRecord(t, db, "rec-late", "scan-1", 0, `{}`, start.Add(48*time.Hour))
+	other := createScannedRecord(t, db, "rec-lab", "scan-1", 0, `{}`, start.Add(time.Hour))
+	db.Model(other).Update("record_type", "lab_result")
+
+	provider := &fakeProvider{scan: map[string]string{"medication": "Amoxicillin"}}
+	rs := newTestReprocessService(t, db, provider, 10)
+	ctx := context.Background()
+
+	if _, err := rs.StartJob(ctx, ReprocessCohort{RecordType: "prescription", CreatedTo: start.Add(24 * time.Hour)}); err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	if handled, err := rs.RunPending(ctx); err != nil || handled != 1 {
+		t.Fatalf("RunPending() = %d, %v; want only the early prescription", handled, err)
+	}
+	if record := loadRecord(t, db, "rec-early"); record.ExtractionVersion != ExtractionVersion {
+		t.Errorf("early prescription not reprocessed")
+	}
+	for _, id := range []string{"rec-late", "rec-lab"} {
+		if record := loadRecord(t, db, id); record.ExtractionVersion != 0 {
+			t.Errorf("%s outside the cohort was reprocessed", id)
+		}
+	}
+
+	invalid := []ReprocessCohort{
+		{BelowVersion: ExtractionVersion + 1},
+		{BelowVersion: -1},
+		{RecordType: "no_such_type"},
+		{CreatedFrom: start.Add(time.Hour), CreatedTo: start},
+	}
+	for _, cohort := range invalid {
+		if _, err := rs.StartJob(ctx, cohort); err == nil {
+			t.Errorf("StartJob(%+v) accepted an invalid cohort", cohort)
+		}
+	}
+}
+
+// editingProvider is a fakeProvider whose scan lets the owner edit the
+// record while the provider call is running
+type editingProvider struct {
+	fakeProvider
+	db       *gorm.DB
+	recordID string
+}
+
+func (ep *editingProvider) ScanPrescription(ctx context.Context, imageData []byte) (map[string]string, error) {
+	ep.db.Model(&models.HealthRecord{}).Where("id = ?", ep.recordID).Update("user_edited_at", time.Now())
+	return ep.fakeProvider.ScanPrescription(ctx, imageData)
+}
+
+func TestReprocessNeverOverwritesAnEditMadeDuringExtraction(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	db.Create(&models.ScanInput{ID: "scan-1", UserID: "user-1", Image: []byte("image")})
+	createScannedRecord(t, db, "rec-1", "scan-1", 0, `{"medication":"Amoxicilin"}`, time.Now())
+
+	provider := &editingProvider{fakeProvider: fakeProvider{scan: map[string]string{"medication": "Amoxicillin"}}, db: db, recordID: "rec-1"}
+	rs := newTestReprocessService(t, db, provider, 10)
+	ctx := context.Background()
+
+	job, err := rs.StartJob(ctx, ReprocessCohort{})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	if _, err := rs.RunPending(ctx); err != nil {
+		t.Fatalf("RunPending: %v", err)
+	}
+	if job, _ = rs.GetJob(ctx, job.ID); job.Skipped != 1 || job.Improved != 0 {
+		t.Errorf("job counts = %d improved, %d skipped; want the record skipped", job.Improved, job.Skipped)
+	}
+	if record := loadRecord(t, db, "rec-1"); record.Metadata != `{"medication":"Amoxicilin"}` || record.ExtractionVersion != 0 {
+		t.Errorf("record changed under an edit: version %d, metadata %s", record.ExtractionVersion, record.Metadata)
+	}
+	var count int64
+	db.Model(&models.RecordRevision{}).Count(&count)
+	if count != 0 {
+		t.Errorf("revision saved for a rolled-back re-extraction")
+	}
+}
+
+func TestUpdateRecordMarksRecordUserEdited(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	createScannedRecord(t, db, "rec-1", "scan-1", 0, `{"medication":"Amoxicilin"}`, time.Now())
+	hrs := newTestRecordsService(db, nil)
+
+	if _, err := hrs.UpdateRecord(context.Background(), "user-1", "rec-1", "Corrected", "", map[string]string{"medication": "Amoxicillin"}); err != nil {
+		t.Fatalf("UpdateRecord: %v", err)
+	}
+	if record := loadRecord(t, db, "rec-1"); record.UserEditedAt == nil {
+		t.Error("UpdateRecord did not mark the record as edited by its owner")
+	}
+}
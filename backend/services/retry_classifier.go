@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Categories of failed sends. The delivery queue retries the retryable
+// ones and gives up on the rest at once, since sending the same message
+// again would fail the same way.
+const (
+	ErrorCategoryNetwork     = "network"      // connection failures and timeouts
+	ErrorCategoryRateLimited = "rate_limited" // 429 Too Many Requests
+	ErrorCategoryServer      = "server"       // 5xx
+	ErrorCategoryClient      = "client"       // other 4xx: the transport rejected the message
+	ErrorCategoryValidation  = "validation"   // rejected before it was sent
+	ErrorCategoryUnknown     = "unknown"
+)
+
+// defaultRetryable is whether each category is retried unless configured
+// otherwise. Unknown errors are retried, as every error was before they
+// were classified.
+var defaultRetryable = map[string]bool{
+	ErrorCategoryNetwork:     true,
+	ErrorCategoryRateLimited: true,
+	ErrorCategoryServer:      true,
+	ErrorCategoryClient:      false,
+	ErrorCategoryValidation:  false,
+	ErrorCategoryUnknown:     true,
+}
+
+// SendError is a send the transport answered with a failure status.
+// Senders wrap transport errors in it so the queue can tell a rejected
+// message from an unavailable transport.
+type SendError struct {
+	StatusCode int // HTTP status, or the transport's equivalent
+	Err        error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("status %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCategorizer is implemented by senders whose transport reports
+// failures the default categories do not recognise, such as errors coded
+// in the response body. Returning "" leaves err to the defaults.
+type ErrorCategorizer interface {
+	CategorizeError(err error) string
+}
+
+// RetryClassifier decides which failed sends the delivery queue retries.
+// A nil *RetryClassifier uses the defaults.
+type RetryClassifier struct {
+	overrides map[retryOverrideKey]bool
+}
+
+// retryOverrideKey is a channel, or "*" for every channel, and a category
+// or HTTP status code
+type retryOverrideKey struct {
+	channel string
+	match   string
+}
+
+// NewRetryClassifier reads overrides given as "channel:match=retry" or
+// "channel:match=fail", where channel may be "*" for every channel and
+// match is an error category or an HTTP status code, e.g.
+// "email:409=fail" or "*:client=retry"
+func NewRetryClassifier(overrides []string) (*RetryClassifier, error) {
+	rc := &RetryClassifier{overrides: make(map[retryOverrideKey]bool)}
+	for _, entry := range overrides {
+		target, action, ok := strings.Cut(entry, "=")
+		channel, match, ok2 := strings.Cut(target, ":")
+		if !ok || !ok2 || channel == "" || match == "" {
+			return nil, fmt.Errorf("retry override %q must look like channel:category=retry", entry)
+		}
+		if _, known := defaultRetryable[match]; !known {
+			if _, err := strconv.Atoi(match); err != nil {
+				return nil, fmt.Errorf("retry override %q: unknown category %q", entry, match)
+			}
+		}
+		switch action {
+		case "retry":
+			rc.overrides[retryOverrideKey{channel, match}] = true
+		case "fail":
+			rc.overrides[retryOverrideKey{channel, match}] = false
+		default:
+			return nil, fmt.Errorf("retry override %q: action must be retry or fail", entry)
+		}
+	}
+	return rc, nil
+}
+
+// Retryable reports whether a send on channel that failed with err is
+// worth retrying. An override for the exact status code wins over one for
+// its category, and one for the channel over one for "*".
+func (rc *RetryClassifier) Retryable(channel string, sender Sender, err error) bool {
+	category := CategorizeError(sender, err)
+	if rc != nil {
+		var matches []string
+		var sendErr *SendError
+		if errors.As(err, &sendErr) {
+			matches = append(matches, strconv.Itoa(sendErr.StatusCode))
+		}
+		matches = append(matches, category)
+		for _, match := range matches {
+			for _, scope := range []string{channel, "*"} {
+				if retry, ok := rc.overrides[retryOverrideKey{scope, match}]; ok {
+					return retry
+				}
+			}
+		}
+	}
+	return defaultRetryable[category]
+}
+
+// CategorizeError sorts a failed send into one of the error categories,
+// asking the sender first if it implements ErrorCategorizer
+func CategorizeError(sender Sender, err error) string {
+	if categorizer, ok := sender.(ErrorCategorizer); ok {
+		if category := categorizer.CategorizeError(err); category != "" {
+			return category
+		}
+	}
+
+	var sendErr *SendError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &sendErr):
+		switch {
+		case sendErr.StatusCode == 429:
+			return ErrorCategoryRateLimited
+		case sendErr.StatusCode >= 500:
+			return ErrorCategoryServer
+		case sendErr.StatusCode >= 400:
+			return ErrorCategoryClient
+		}
+	case errors.Is(err, ErrInvalidArgument):
+		return ErrorCategoryValidation
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorCategoryNetwork
+	}
+	return ErrorCategoryUnknown
+}
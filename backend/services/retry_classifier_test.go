@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/models"
+)
+
+// codedSender is a sender whose provider reports rejections as error codes
+// in the response body rather than as statuses
+type codedSender struct{ fakeSender }
+
+func (cs *codedSender) CategorizeError(err error) string {
+	switch {
+	case strings.Contains(err.Error(), "code 131026"): // recipient not on WhatsApp
+		return ErrorCategoryClient
+	case strings.Contains(err.Error(), "code 130429"): // throughput reached
+		return ErrorCategoryRateLimited
+	}
+	return ""
+}
+
+func TestCategorizeError(t *testing.T) {
+	dnsErr := &net.DNSError{Err: "no such host", Name: "smtp.example.com", IsTimeout: true}
+	tests := []struct {
+		name   string
+		sender Sender
+		err    error
+		want   string
+	}{
+		{"dial failure", &fakeSender{}, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ErrorCategoryNetwork},
+		{"DNS timeout", &fakeSender{}, fmt.Errorf("send: %w", dnsErr), ErrorCategoryNetwork},
+		{"deadline", &fakeSender{}, fmt.Errorf("send: %w", context.DeadlineExceeded), ErrorCategoryNetwork},
+		{"cut off", &fakeSender{}, io.ErrUnexpectedEOF, ErrorCategoryNetwork},
+		{"429", &fakeSender{}, &SendError{StatusCode: 429, Err: errors.New("slow down")}, ErrorCategoryRateLimited},
+		{"500", &fakeSender{}, &SendError{StatusCode: 500, Err: errors.New("oops")}, ErrorCategoryServer},
+		{"503 wrapped", &fakeSender{}, fmt.Errorf("push: %w", errUnavailable503), ErrorCategoryServer},
+		{"400", &fakeSender{}, &SendError{StatusCode: 400, Err: errors.New("bad request")}, ErrorCategoryClient},
+		{"404", &fakeSender{}, &SendError{StatusCode: 404, Err: errors.New("no such device")}, ErrorCategoryClient},
+		{"validation", &fakeSender{}, fmt.Errorf("%w: empty recipient", ErrInvalidArgument), ErrorCategoryValidation},
+		{"unknown", &fakeSender{}, errors.New("something odd"), ErrorCategoryUnknown},
+		{"sender's own code", &codedSender{}, errors.New("whatsapp: code 131026"), ErrorCategoryClient},
+		{"sender's rate limit code", &codedSender{}, errors.New("whatsapp: code 130429"), ErrorCategoryRateLimited},
+		{"sender defers to the defaults", &codedSender{}, &SendError{StatusCode: 502, Err: errors.New("bad gateway")}, ErrorCategoryServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CategorizeError(tt.sender, tt.err); got != tt.want {
+				t.Errorf("CategorizeError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryablePerChannel(t *testing.T) {
+	rc, err := NewRetryClassifier([]string{
+		"email:409=retry",     // a status on one channel
+		"push:server=fail",    // a category on one channel
+		"*:rate_limited=fail", // a category on every channel
+		"whatsapp:rate_limited=retry",
+		"*:404=retry",
+		"email:404=fail",
+	})
+	if err != nil {
+		t.Fatalf("NewRetryClassifier: %v", err)
+	}
+	status := func(code int) error { return &SendError{StatusCode: code, Err: errors.New("failed")} }
+
+	tests := []struct {
+		name    string
+		channel string
+		err     error
+		want    bool
+	}{
+		{"default: server error", models.DeliveryChannelEmail, status(500), true},
+		{"default: client error", models.DeliveryChannelEmail, status(400), false},
+		{"default: network", models.DeliveryChannelPush, &net.OpError{Op: "dial", Err: errors.New("refused")}, true},
+		{"default: validation", models.DeliveryChannelWhatsApp, ErrInvalidArgument, false},
+		{"default: unknown", models.DeliveryChannelWhatsApp, errors.New("odd"), true},
+		{"status override on its channel", models.DeliveryChannelEmail, status(409), true},
+		{"status override not on other channels", models.DeliveryChannelPush, status(409), false},
+		{"category override on its channel", models.DeliveryChannelPush, status(503), false},
+		{"category override not on other channels", models.DeliveryChannelEmail, status(503), true},
+		{"every channel", models.DeliveryChannelEmail, status(429), false},
+		{"channel wins over every channel", models.DeliveryChannelWhatsApp, status(429), true},
+		{"status wins over category", models.DeliveryChannelPush, status(404), true},
+		{"channel status wins over every channel's", models.DeliveryChannelEmail, status(404), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rc.Retryable(tt.channel, &fakeSender{}, tt.err); got != tt.want {
+				t.Errorf("Retryable(%s, %v) = %v, want %v", tt.channel, tt.err, got, tt.want)
+			}
+		})
+	}
+
+	var defaults *RetryClassifier
+	if !defaults.Retryable(models.DeliveryChannelEmail, &fakeSender{}, status(502)) || defaults.Retryable(models.DeliveryChannelEmail, &fakeSender{}, status(422)) {
+		t.Error("nil classifier does not use the defaults")
+	}
+}
+
+func TestNewRetryClassifierRejectsMalformedOverrides(t *testing.T) {
+	for _, entry := range []string{
+		"email=retry",
+		"email:server",
+		":server=retry",
+		"email:=retry",
+		"email:timeout=retry",
+		"email:server=maybe",
+	} {
+		if _, err := NewRetryClassifier([]string{entry}); err == nil {
+			t.Errorf("NewRetryClassifier accepted %q", entry)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// prescriptions returns the user's prescription records
+func prescriptions(t *testing.T, db *gorm.DB, userID string) []models.HealthRecord {
+	t.Helper()
+	var records []models.HealthRecord
+	if err := db.Where("user_id = ? AND record_type = ?", userID, "prescription").Find(&records).Error; err != nil {
+		t.Fatalf("load prescriptions: %v", err)
+	}
+	return records
+}
+
+func TestScanPrescriptionSavesRecord(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	createUser(t, db, "user-1")
+
+	result, err := as.ScanPrescription(context.Background(), "user-1", []byte("image"), ScanOptions{Force: true})
+	if err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	if result.RecordID == "" {
+		t.Fatal("no record ID returned")
+	}
+
+	records := prescriptions(t, db, "user-1")
+	if len(records) != 1 || records[0].ID != result.RecordID {
+		t.Fatalf("prescriptions = %v, want the scanned one %s", recordIDs(records), result.RecordID)
+	}
+	record := records[0]
+	if record.Title != "Aspirin" || record.Description != "500mg Twice daily" {
+		t.Errorf("record = %q, %q; want the scanned medication, dosage and frequency", record.Title, record.Description)
+	}
+	if record.ScanID != result.ScanID || record.ExtractionVersion != ExtractionVersion || record.ExtractionProvider != "mock" {
+		t.Errorf("record scan = %s v%d by %s, want %s v%d by mock", record.ScanID, record.ExtractionVersion, record.ExtractionProvider, result.ScanID, ExtractionVersion)
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(record.Metadata), &metadata); err != nil {
+		t.Fatalf("metadata %q: %v", record.Metadata, err)
+	}
+	for key, value := range result.ExtractedData {
+		if metadata[key] != value {
+			t.Errorf("metadata %s = %q, want the extracted %q", key, metadata[key], value)
+		}
+	}
+	if metadata["scan_id"] != result.ScanID {
+		t.Errorf("metadata scan_id = %q, want %s", metadata["scan_id"], result.ScanID)
+	}
+}
+
+func TestRepeatedScanReturnsTheSavedRecord(t *testing.T) {
+	db := newTestDB(t)
+	records := newTestRecordsService(db, &config.RecordsConfig{DuplicateWindow: 600})
+	as := NewAIService(db, &config.AIConfig{}, nil, nil, records, nil, 0, nil, nil)
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+
+	first, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true})
+	if err != nil {
+		t.Fatalf("first scan: %v", err)
+	}
+	second, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true})
+	if err != nil {
+		t.Fatalf("second scan: %v", err)
+	}
+
+	// Within the duplicate window the same prescription is one record,
+	// though each scan's image is kept
+	if second.RecordID != first.RecordID || second.ScanID == first.ScanID {
+		t.Errorf("second scan = record %s, scan %s; want record %s and a new scan", second.RecordID, second.ScanID, first.RecordID)
+	}
+	if records := prescriptions(t, db, "user-1"); len(records) != 1 {
+		t.Errorf("%d prescriptions after scanning twice, want 1", len(records))
+	}
+	var scans int64
+	db.Model(&models.ScanInput{}).Where("user_id = ?", "user-1").Count(&scans)
+	if scans != 2 {
+		t.Errorf("%d stored scans, want 2", scans)
+	}
+
+	// Without the window each scan is its own record
+	as = newTestAIService(t, db, nil)
+	third, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true})
+	if err != nil {
+		t.Fatalf("third scan: %v", err)
+	}
+	if third.RecordID == first.RecordID {
+		t.Error("scan without a duplicate window reused a record")
+	}
+}
+
+func TestFailedScanSavesNothing(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	as.provider = &fakeProvider{scanErr: refusalError("fake", "stopped by content filter")}
+	createUser(t, db, "user-1")
+
+	if _, err := as.ScanPrescription(context.Background(), "user-1", []byte("image"), ScanOptions{Force: true}); !errors.Is(err, ErrContentRefused) {
+		t.Fatalf("ScanPrescription = %v, want a refusal", err)
+	}
+	if records := prescriptions(t, db, "user-1"); len(records) != 0 {
+		t.Errorf("refused scan saved %d records", len(records))
+	}
+}
+
+func TestMedicationSetupFromScanUsesScannedRecord(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	ms := newTestMedicationService(db, &testClock{now: time.Now()})
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	ctx := context.Background()
+
+	scan, err := as.ScanPrescription(ctx, "user-1", []byte("image"), ScanOptions{Force: true})
+	if err != nil {
+		t.Fatalf("ScanPrescription: %v", err)
+	}
+	draft := DraftMedicationSchedule(scan.ExtractedData)
+	setup := MedicationSetup{Name: draft.Medication, Dosage: draft.Dosage, Frequency: draft.Frequency, TimesOfDay: draft.TimesOfDay, ScanID: scan.ScanID}
+
+	medication, _, err := ms.ConfirmMedicationSetup(ctx, "user-1", setup)
+	if err != nil {
+		t.Fatalf("ConfirmMedicationSetup: %v", err)
+	}
+	if medication.RecordID != scan.RecordID {
+		t.Errorf("medication record = %s, want the scanned record %s", medication.RecordID, scan.RecordID)
+	}
+	if records := prescriptions(t, db, "user-1"); len(records) != 1 {
+		t.Errorf("%d prescriptions after confirming a scan, want 1", len(records))
+	}
+
+	// Another user's scan ID does not link to the scanner's record
+	other, _, err := ms.ConfirmMedicationSetup(ctx, "user-2", setup)
+	if err != nil {
+		t.Fatalf("ConfirmMedicationSetup by user-2: %v", err)
+	}
+	if other.RecordID == scan.RecordID {
+		t.Error("user-2's medication was linked to user-1's record")
+	}
+}
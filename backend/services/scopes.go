@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxAccessReasonLength bounds the reason a non-owner gives for reading a
+// sensitive record
+const maxAccessReasonLength = 500
+
+// Query scopes shared by the services layer. Every query that reads or
+// writes user-owned rows should go through one of these so that access is
+// bounded by construction rather than by each caller remembering a WHERE.
+
+// scopeOwner restricts a query to rows owned by userID.
+func scopeOwner(userID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID)
+	}
+}
+
+// scopeOrgConsented restricts a query to rows owned by patients who have an
+// active consent grant for orgID. Callers must have verified the actor is a
+// member of orgID; the org is never taken from the request on its own.
+func scopeOrgConsented(orgID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		consented := db.Session(&gorm.Session{NewDB: true}).
+			Model(&models.OrgConsent{}).
+			Select("patient_id").
+			Where("org_id = ? AND revoked_at IS NULL", orgID)
+		return db.Where("user_id IN (?)", consented)
+	}
+}
+
+// scopeOrg restricts a query on org-owned tables (memberships, invites,
+// consents) to a single organization.
+func scopeOrg(orgID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("org_id = ?", orgID)
+	}
+}
+
+// scopeNotBlocked hides health records their owner has blocked from
+// everyone else, and the change events about them. Apply it to every
+// non-owner read of either.
+func scopeNotBlocked() func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("sensitivity IS NULL OR sensitivity <> ?", models.SensitivityBlocked)
+	}
+}
+
+// authorizeRecordRead decides whether actorID may read record. The owner
+// always may. Anyone else is refused blocked records and must state a
+// reason for sensitive ones; callers log that reason with logRecordAccess.
+func authorizeRecordRead(actorID string, record *models.HealthRecord, reason string) error {
+	if actorID == record.UserID {
+		return nil
+	}
+	switch record.Sensitivity {
+	case models.SensitivityBlocked:
+		return fmt.Errorf("%w: the owner has blocked access to this record", ErrPermissionDenied)
+	case models.SensitivitySensitive:
+		reason = strings.TrimSpace(reason)
+		if reason == "" {
+			return fmt.Errorf("%w: record is sensitive; state why you need to read it", ErrAccessReasonRequired)
+		}
+		if len(reason) > maxAccessReasonLength {
+			return fmt.Errorf("%w: access reason exceeds %d bytes", ErrInvalidArgument, maxAccessReasonLength)
+		}
+	}
+	return nil
+}
+
+// logRecordAccess records a non-owner read of a sensitive record where the
+// owner can see it. Reads of other records are not logged.
+func logRecordAccess(db *gorm.DB, actorID, orgID string, record *models.HealthRecord, reason string) error {
+	if actorID == record.UserID || record.Sensitivity != models.SensitivitySensitive {
+		return nil
+	}
+	entry := models.RecordAccessLog{
+		ID:        uuid.New().String(),
+		OwnerID:   record.UserID,
+		RecordID:  record.ID,
+		ActorID:   actorID,
+		OrgID:     orgID,
+		Reason:    strings.TrimSpace(reason),
+		CreatedAt: time.Now(),
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to log record access: %w", err)
+	}
+	return nil
+}
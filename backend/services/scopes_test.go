@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+func TestAuthorizeRecordRead(t *testing.T) {
+	const owner, other = "patient-1", "staff-1"
+	longReason := strings.Repeat("x", maxAccessReasonLength+1)
+
+	tests := []struct {
+		actor       string
+		sensitivity string
+		reason      string
+		want        error
+	}{
+		{owner, "", "", nil},
+		{owner, models.SensitivityStandard, "", nil},
+		{owner, models.SensitivitySensitive, "", nil},
+		{owner, models.SensitivityBlocked, "", nil},
+		{owner, models.SensitivitySensitive, longReason, nil},
+
+		{other, "", "", nil},
+		{other, models.SensitivityStandard, "", nil},
+		{other, models.SensitivityStandard, "follow-up", nil},
+		{other, models.SensitivitySensitive, "", ErrAccessReasonRequired},
+		{other, models.SensitivitySensitive, "   ", ErrAccessReasonRequired},
+		{other, models.SensitivitySensitive, "follow-up", nil},
+		{other, models.SensitivitySensitive, longReason, ErrInvalidArgument},
+		{other, models.SensitivityBlocked, "", ErrPermissionDenied},
+		{other, models.SensitivityBlocked, "follow-up", ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		record := &models.HealthRecord{ID: "rec-1", UserID: owner, Sensitivity: tt.sensitivity}
+		err := authorizeRecordRead(tt.actor, record, tt.reason)
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("authorizeRecordRead(%s, %q, reason %d bytes) = %v, want %v",
+				tt.actor, tt.sensitivity, len(tt.reason), err, tt.want)
+		}
+	}
+}
+
+func TestLogRecordAccessOnlyLogsOthersReadingSensitiveRecords(t *testing.T) {
+	db := newTestDB(t)
+	tests := []struct {
+		actor       string
+		sensitivity string
+		logged      bool
+	}{
+		{"patient-1", models.SensitivitySensitive, false},
+		{"staff-1", models.SensitivityStandard, false},
+		{"staff-1", models.SensitivitySensitive, true},
+	}
+	for _, tt := range tests {
+		record := &models.HealthRecord{ID: "rec-1", UserID: "patient-1", Sensitivity: tt.sensitivity}
+		if err := logRecordAccess(db, tt.actor, "org-1", record, "  lab review  "); err != nil {
+			t.Fatalf("logRecordAccess: %v", err)
+		}
+	}
+	var entries []models.RecordAccessLog
+	db.Find(&entries)
+	if len(entries) != 1 {
+		t.Fatalf("logged %d accesses, want 1", len(entries))
+	}
+	if got := entries[0]; got.ActorID != "staff-1" || got.OwnerID != "patient-1" || got.Reason != "lab review" {
+		t.Errorf("logged %+v", got)
+	}
+}
+
+func TestGetPatientRecordByRoleSensitivityAndReason(t *testing.T) {
+	sensitivities := []string{models.SensitivityStandard, models.SensitivitySensitive, models.SensitivityBlocked}
+	for _, role := range []string{models.OrgRoleStaff, models.OrgRoleAdmin} {
+		for _, sensitivity := range sensitivities {
+			for _, reason := range []string{"", "medication review"} {
+				var want error
+				switch {
+				case sensitivity == models.SensitivityBlocked:
+					want = ErrPermissionDenied
+				case sensitivity == models.SensitivitySensitive && reason == "":
+					want = ErrAccessReasonRequired
+				}
+				t.Run(role+"/"+sensitivity+"/reason="+reason, func(t *testing.T) {
+					f := newOrgFixture(t)
+					actor := f.staffA
+					if role == models.OrgRoleAdmin {
+						actor = f.adminA
+					}
+					recordID := f.patientA + "-" + sensitivity
+
+					record, err := f.ors.GetPatientRecord(context.Background(), actor, f.orgA, recordID, reason)
+					if !errors.Is(err, want) || (want == nil && err != nil) {
+						t.Fatalf("GetPatientRecord() error = %v, want %v", err, want)
+					}
+					if want == nil && record.Description == "" {
+						t.Error("record was returned without its contents")
+					}
+
+					var logged int64
+					f.db.Model(&models.RecordAccessLog{}).Count(&logged)
+					wantLogged := sensitivity == models.SensitivitySensitive && want == nil
+					if (logged == 1) != wantLogged || logged > 1 {
+						t.Errorf("logged %d accesses, want logged: %v", logged, wantLogged)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestRecordSensitivityDefaultsAndOwnerControl(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, &config.RecordsConfig{SensitiveCategories: []string{"mental_health", "reproductive_health"}})
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     string
+	}{
+		{"no category", nil, models.SensitivityStandard},
+		{"ordinary category", map[string]string{"category": "cardiology"}, models.SensitivityStandard},
+		{"sensitive category", map[string]string{"category": "mental_health"}, models.SensitivitySensitive},
+		{"category case and spacing", map[string]string{"category": " Reproductive_Health "}, models.SensitivitySensitive},
+	}
+	for _, tt := range tests {
+		record, err := hrs.CreateRecord(ctx, "user-1", "lab_result", tt.name, "details", tt.metadata)
+		if err != nil {
+			t.Fatalf("%s: CreateRecord: %v", tt.name, err)
+		}
+		if record.Sensitivity != tt.want {
+			t.Errorf("%s: sensitivity = %q, want %q", tt.name, record.Sensitivity, tt.want)
+		}
+	}
+
+	record := createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	if _, err := hrs.SetRecordSensitivity(ctx, "user-2", record.ID, models.SensitivityBlocked); !errors.Is(err, ErrNotFound) {
+		t.Errorf("non-owner SetRecordSensitivity: error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := hrs.SetRecordSensitivity(ctx, "user-1", record.ID, "secret"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("unknown sensitivity: error = %v, want %v", err, ErrInvalidArgument)
+	}
+	updated, err := hrs.SetRecordSensitivity(ctx, "user-1", record.ID, models.SensitivityBlocked)
+	if err != nil {
+		t.Fatalf("SetRecordSensitivity: %v", err)
+	}
+	if updated.Sensitivity != models.SensitivityBlocked {
+		t.Errorf("sensitivity = %q, want blocked", updated.Sensitivity)
+	}
+
+	// Blocking only shuts others out
+	if _, err := hrs.GetRecord(ctx, "user-1", record.ID); err != nil {
+		t.Errorf("owner reading a blocked record: %v", err)
+	}
+}
+
+func TestListRecordAccessLogShowsOnlyTheOwnersRecords(t *testing.T) {
+	f := newOrgFixture(t)
+	ctx := context.Background()
+	for _, recordID := range []string{f.patientA + "-sensitive", f.patientA + "-sensitive"} {
+		if _, err := f.ors.GetPatientRecord(ctx, f.staffA, f.orgA, recordID, "care plan"); err != nil {
+			t.Fatalf("GetPatientRecord: %v", err)
+		}
+	}
+	if _, err := f.ors.GetPatientRecord(ctx, f.staffB, f.orgB, f.patientB+"-sensitive", "triage"); err != nil {
+		t.Fatalf("GetPatientRecord: %v", err)
+	}
+
+	hrs := newTestRecordsService(f.db, nil)
+	entries, total, err := hrs.ListRecordAccessLog(ctx, f.patientA, 0, 0)
+	if err != nil {
+		t.Fatalf("ListRecordAccessLog: %v", err)
+	}
+	if total != 2 || len(entries) != 2 {
+		t.Fatalf("listed %d of %d entries, want 2", len(entries), total)
+	}
+	for _, entry := range entries {
+		if entry.OwnerID != f.patientA || entry.ActorID != f.staffA || entry.Reason != "care plan" {
+			t.Errorf("entry = %+v", entry)
+		}
+	}
+}
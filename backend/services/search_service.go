@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// reindexBatchSize bounds how many records a reindex pass loads at once
+	reindexBatchSize = 500
+
+	// maxSearchTokens bounds how many LIKE predicates one search builds
+	maxSearchTokens = 10
+)
+
+// tokenize lowercases text and splits it into unique alphanumeric tokens
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	tokens := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if len([]rune(f)) < 2 || seen[f] {
+			continue
+		}
+		seen[f] = true
+		tokens = append(tokens, f)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// searchTerms builds the indexed term string for a record
+func searchTerms(record *models.HealthRecord) string {
+	parts := []string{record.RecordType, record.Title, record.Description}
+
+	var metadata map[string]string
+	if record.Metadata != "" && json.Unmarshal([]byte(record.Metadata), &metadata) == nil {
+		for _, value := range metadata {
+			parts = append(parts, value)
+		}
+	}
+
+	return " " + strings.Join(tokenize(strings.Join(parts, " ")), " ") + " "
+}
+
+// indexRecord upserts a record's search index entry and numeric metadata
+// values inside tx
+func indexRecord(tx *gorm.DB, record *models.HealthRecord) error {
+	entry := models.RecordSearchIndex{
+		RecordID:        record.ID,
+		UserID:          record.UserID,
+		Terms:           searchTerms(record),
+		RecordUpdatedAt: record.UpdatedAt,
+		IndexedAt:       time.Now(),
+	}
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error; err != nil {
+		return err
+	}
+	return indexMetadataNumbers(tx, record)
+}
+
+// removeFromIndex deletes a record's search index entries inside tx
+func removeFromIndex(tx *gorm.DB, recordID string) error {
+	if err := tx.Delete(&models.RecordSearchIndex{}, "record_id = ?", recordID).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.RecordMetadataNumber{}, "record_id = ?", recordID).Error
+}
+
+// ReindexResult reports what a reindex pass changed
+type ReindexResult struct {
+	Indexed int
+	Removed int
+}
+
+type SearchService struct {
+	db    *gorm.DB
+	flags *FeatureFlags // optional
+}
+
+func NewSearchService(db *gorm.DB, flags *FeatureFlags) *SearchService {
+	return &SearchService{db: db, flags: flags}
+}
+
+// SearchRecords returns the user's records matching every token in query
+// and every metadata range. Tokens match as prefixes, so "amox" finds
+// "amoxicillin". The query may be empty when ranges are given.
+func (ss *SearchService) SearchRecords(ctx context.Context, userID, query string, ranges []MetadataRange, limit, offset int) ([]models.HealthRecord, int64, error) {
+	if err := ss.flags.require(FeatureSearch); err != nil {
+		return nil, 0, err
+	}
+	if err := validateMetadataRanges(ranges); err != nil {
+		return nil, 0, err
+	}
+
+	tokens := tokenize(query)
+	if len(tokens) == 0 && len(ranges) == 0 {
+		return nil, 0, fmt.Errorf("%w: search query has no searchable terms", ErrInvalidArgument)
+	}
+	if len(tokens) > maxSearchTokens {
+		tokens = tokens[:maxSearchTokens]
+	}
+	limit, offset = pageBounds(limit, offset)
+
+	matches := ss.db.WithContext(ctx).Model(&models.RecordSearchIndex{}).Select("record_id").Scopes(scopeOwner(userID))
+	for _, token := range tokens {
+		matches = matches.Where("terms LIKE ?", "% "+token+"%")
+	}
+
+	var total int64
+	if err := ss.db.WithContext(ctx).Model(&models.HealthRecord{}).
+		Scopes(scopeOwner(userID), scopeMetadataRanges(userID, ranges)).
+		Where("id IN (?)", matches).
+		Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	var records []models.HealthRecord
+	if err := ss.db.WithContext(ctx).Scopes(scopeOwner(userID), scopeMetadataRanges(userID, ranges)).
+		Where("id IN (?)", matches).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to search records: %w", err)
+	}
+
+	return records, total, nil
+}
+
+// Reindex brings the search index back in line with the records table.
+// An incremental pass only touches records whose index entry is missing or
+// older than the record, plus entries whose record no longer exists; a full
+// pass rebuilds every entry.
+func (ss *SearchService) Reindex(ctx context.Context, full bool) (ReindexResult, error) {
+	var result ReindexResult
+
+	// Drop entries for records that no longer exist
+	orphans := ss.db.WithContext(ctx).
+		Where("record_id NOT IN (?)", ss.db.WithContext(ctx).Model(&models.HealthRecord{}).Select("id")).
+		Delete(&models.RecordSearchIndex{})
+	if orphans.Error != nil {
+		return result, fmt.Errorf("failed to remove orphaned index entries: %w", orphans.Error)
+	}
+	result.Removed = int(orphans.RowsAffected)
+	if err := ss.db.WithContext(ctx).
+		Where("record_id NOT IN (?)", ss.db.WithContext(ctx).Model(&models.HealthRecord{}).Select("id")).
+		Delete(&models.RecordMetadataNumber{}).Error; err != nil {
+		return result, fmt.Errorf("failed to remove orphaned metadata values: %w", err)
+	}
+
+	query := ss.db.WithContext(ctx).Model(&models.HealthRecord{})
+	if !full {
+		query = query.
+			Joins("LEFT JOIN record_search_indices ON record_search_indices.record_id = health_records.id").
+			Where("record_search_indices.record_id IS NULL OR record_search_indices.record_updated_at <> health_records.updated_at OR record_search_indices.user_id <> health_records.user_id")
+	}
+
+	var batch []models.HealthRecord
+	err := query.Select("health_records.*").FindInBatches(&batch, reindexBatchSize, func(_ *gorm.DB, _ int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return ss.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for i := range batch {
+				if err := indexRecord(tx, &batch[i]); err != nil {
+					return err
+				}
+			}
+			result.Indexed += len(batch)
+			return nil
+		})
+	}).Error
+	if err != nil {
+		return result, fmt.Errorf("failed to reindex records: %w", err)
+	}
+
+	if result.Indexed > 0 || result.Removed > 0 {
+		log.Printf("Search reindex (full=%v): %d indexed, %d removed", full, result.Indexed, result.Removed)
+	}
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/clarity/backend/models"
+)
+
+// searchIDs runs a search for userID and returns the matching record IDs
+func searchIDs(t *testing.T, ss *SearchService, userID, query string) []string {
+	t.Helper()
+	records, _, err := ss.SearchRecords(context.Background(), userID, query, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("SearchRecords(%q): %v", query, err)
+	}
+	return recordIDs(records)
+}
+
+func TestSearchRecords(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	ss := NewSearchService(db, nil)
+	ctx := context.Background()
+
+	amox, err := hrs.CreateRecord(ctx, "user-1", "prescription", "Amoxicillin 500mg", "Three times daily", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	lipids, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Lipid panel", "", map[string]string{"lab": "City Lab"})
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	if _, err := hrs.CreateRecord(ctx, "user-2", "prescription", "Amoxicillin 250mg", "", nil); err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"amox", []string{amox.ID}},
+		{"AMOXICILLIN daily", []string{amox.ID}},
+		{"city", []string{lipids.ID}},
+		{"amoxicillin lipid", nil},
+		{"ibuprofen", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := searchIDs(t, ss, "user-1", tt.query)
+			if len(got) != len(tt.want) || (len(got) == 1 && got[0] != tt.want[0]) {
+				t.Errorf("SearchRecords(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := ss.SearchRecords(ctx, "user-1", " - ", nil, 0, 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("query without terms: error = %v, want ErrInvalidArgument", err)
+	}
+	if _, _, err := NewSearchService(db, NewFeatureFlags([]string{FeatureSearch})).SearchRecords(ctx, "user-1", "amox", nil, 0, 0); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("search switched off: error = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestReindexRestoresConsistency(t *testing.T) {
+	db := newTestDB(t)
+	hrs := newTestRecordsService(db, nil)
+	ss := NewSearchService(db, nil)
+	ctx := context.Background()
+
+	missing, err := hrs.CreateRecord(ctx, "user-1", "prescription", "Amoxicillin", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	stale, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Lipid panel", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+	intact, err := hrs.CreateRecord(ctx, "user-1", "lab_result", "Thyroid panel", "", nil)
+	if err != nil {
+		t.Fatalf("CreateRecord: %v", err)
+	}
+
+	// Corrupt the index: lose one entry, leave one out of date, and keep
+	// one for a record that is gone
+	db.Delete(&models.RecordSearchIndex{}, "record_id = ?", missing.ID)
+	db.Model(&models.HealthRecord{}).Where("id = ?", stale.ID).
+		Updates(map[string]any{"title": "Cholesterol panel", "updated_at": time.Now().Add(time.Minute)})
+	db.Create(&models.RecordSearchIndex{RecordID: "gone", UserID: "user-1", Terms: " ghost "})
+
+	if got := searchIDs(t, ss, "user-1", "amoxicillin"); len(got) != 0 {
+		t.Fatalf("corrupted index still finds %v", got)
+	}
+	if got := searchIDs(t, ss, "user-1", "cholesterol"); len(got) != 0 {
+		t.Fatalf("stale index already finds %v", got)
+	}
+
+	result, err := ss.Reindex(ctx, false)
+	if err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	if result.Indexed != 2 || result.Removed != 1 {
+		t.Errorf("incremental reindex = %+v, want 2 indexed and 1 removed", result)
+	}
+	for query, want := range map[string]string{"amoxicillin": missing.ID, "cholesterol": stale.ID, "thyroid": intact.ID} {
+		if got := searchIDs(t, ss, "user-1", query); len(got) != 1 || got[0] != want {
+			t.Errorf("after reindex, %q found %v, want %s", query, got, want)
+		}
+	}
+	if got := searchIDs(t, ss, "user-1", "lipid"); len(got) != 0 {
+		t.Errorf("after reindex, old title still finds %v", got)
+	}
+	var orphans int64
+	db.Model(&models.RecordSearchIndex{}).Where("record_id = ?", "gone").Count(&orphans)
+	if orphans != 0 {
+		t.Error("orphaned index entry survived the reindex")
+	}
+
+	if result, err = ss.Reindex(ctx, false); err != nil || result.Indexed != 0 || result.Removed != 0 {
+		t.Errorf("reindexing a consistent index = %+v, %v, want no changes", result, err)
+	}
+	if result, err = ss.Reindex(ctx, true); err != nil || result.Indexed != 3 {
+		t.Errorf("full reindex = %+v, %v, want every record indexed", result, err)
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range []string{"amox 500mg", "100%_off", "' OR 1=1 --", "Ünïcödé ΑΒΓ", "a b c"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		tokens := tokenize(query)
+		for i, token := range tokens {
+			if len([]rune(token)) < 2 {
+				t.Fatalf("token %q is shorter than two characters", token)
+			}
+			for _, r := range token {
+				// Only letters and digits reach the LIKE pattern, so a
+				// query can never add its own wildcards
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					t.Fatalf("token %q of %q contains %q", token, query, r)
+				}
+			}
+			if token != strings.ToLower(token) {
+				t.Fatalf("token %q is not lowercased", token)
+			}
+			if i > 0 && tokens[i-1] >= token {
+				t.Fatalf("tokens %q are not sorted and unique", tokens)
+			}
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// newIdleTestAuth returns an AuthService whose sessions go idle after an
+// hour unused
+func newIdleTestAuth(t *testing.T) (*AuthService, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Now()}
+	return newTestAuthService(newTestDB(t), &config.AuthConfig{SessionIdleTimeout: 3600}, clock), clock
+}
+
+func TestRefreshWithinIdleTimeout(t *testing.T) {
+	as, clock := newIdleTestAuth(t)
+	ctx := context.Background()
+	_, token := signIn(t, as, "a@example.com", "phone-1")
+
+	// Each refresh is activity, so a session refreshed often never idles
+	for i := 0; i < 3; i++ {
+		clock.Advance(50 * time.Minute)
+		var err error
+		if _, token, err = as.RefreshToken(ctx, token, "phone-1"); err != nil {
+			t.Fatalf("refresh %d, 50 minutes after the last: %v", i+1, err)
+		}
+	}
+	if session := sessionOf(t, as.db, token); !session.LastUsedAt.Equal(clock.now) {
+		t.Errorf("LastUsedAt = %v, want the last refresh %v", session.LastUsedAt, clock.now)
+	}
+}
+
+func TestRefreshAfterIdleTimeoutRequiresSignIn(t *testing.T) {
+	as, clock := newIdleTestAuth(t)
+	ctx := context.Background()
+	_, token := signIn(t, as, "a@example.com", "phone-1")
+
+	clock.Advance(61 * time.Minute)
+	_, _, err := as.RefreshToken(ctx, token, "phone-1")
+	if !errors.Is(err, ErrReauthRequired) {
+		t.Fatalf("refresh after 61 idle minutes: %v, want %v", err, ErrReauthRequired)
+	}
+	session := sessionOf(t, as.db, token)
+	if session.RevokedAt == nil || session.RevokeReason != SessionRevokedIdle {
+		t.Errorf("session = %+v, want it revoked as %q", session, SessionRevokedIdle)
+	}
+
+	// The session stays ended; only a new sign-in works
+	if _, _, err := as.RefreshToken(ctx, token, "phone-1"); err == nil {
+		t.Error("the idle session refreshed on a second try")
+	}
+	_, fresh := signIn(t, as, "a@example.com", "phone-1")
+	if _, _, err := as.RefreshToken(ctx, fresh, "phone-1"); err != nil {
+		t.Errorf("refresh after signing in again: %v", err)
+	}
+}
+
+func TestNoIdleTimeoutByDefault(t *testing.T) {
+	as, clock := newSessionTestAuth(t)
+	_, token := signIn(t, as, "a@example.com", "phone-1")
+
+	clock.Advance(6 * 24 * time.Hour)
+	if _, _, err := as.RefreshToken(context.Background(), token, "phone-1"); err != nil {
+		t.Errorf("refresh after 6 idle days with no idle timeout: %v", err)
+	}
+}
+
+func TestAccessTokenUseKeepsSessionActive(t *testing.T) {
+	as, clock := newIdleTestAuth(t)
+	ctx := context.Background()
+	accessToken, refreshToken := signInWithAccess(t, as, "a@example.com", "phone-1")
+
+	claims, err := as.ValidateToken(ctx, accessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	session := sessionOf(t, as.db, refreshToken)
+	if claims.Session != session.ID {
+		t.Fatalf("access token names session %q, want %q", claims.Session, session.ID)
+	}
+
+	// Calls made with the access token count as use, so the refresh after
+	// two hours of them is allowed
+	for i := 0; i < 4; i++ {
+		clock.Advance(30 * time.Minute)
+		if err := as.RecordActivity(ctx, claims.Subject, claims.Session); err != nil {
+			t.Fatalf("RecordActivity: %v", err)
+		}
+	}
+	if session := sessionOf(t, as.db, refreshToken); !session.LastUsedAt.Equal(clock.now) {
+		t.Errorf("LastUsedAt = %v, want the last call %v", session.LastUsedAt, clock.now)
+	}
+	refreshed, next, err := as.RefreshToken(ctx, refreshToken, "phone-1")
+	if err != nil {
+		t.Fatalf("refresh after two active hours: %v", err)
+	}
+	if refreshedClaims, _ := as.ValidateToken(ctx, refreshed); refreshedClaims == nil || refreshedClaims.Session != session.ID {
+		t.Errorf("refreshed access token claims = %+v, want session %q", refreshedClaims, session.ID)
+	}
+
+	// Another user cannot mark the session used
+	clock.Advance(61 * time.Minute)
+	if err := as.RecordActivity(ctx, "someone-else", claims.Session); err != nil {
+		t.Fatalf("RecordActivity: %v", err)
+	}
+	// and once idle too long, a call does not bring the session back
+	if err := as.RecordActivity(ctx, claims.Subject, claims.Session); err != nil {
+		t.Fatalf("RecordActivity: %v", err)
+	}
+	if _, _, err := as.RefreshToken(ctx, next, "phone-1"); !errors.Is(err, ErrReauthRequired) {
+		t.Errorf("refresh after 61 minutes without calls: %v, want %v", err, ErrReauthRequired)
+	}
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/tenancy"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// refreshTokenTTL is how long an unused refresh token stays valid
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// Session revoke reasons
+const (
+	SessionRevokedTokenReuse = "refresh_token_reuse"
+	SessionRevokedLogout     = "logout"
+	SessionRevokedLogoutAll  = "logout_all"
+	SessionRevokedIdle       = "idle"
+)
+
+// startSession opens a session bound to deviceID and returns it with its
+// first refresh token, carrying scopes for every access token the session
+// issues
+func (as *AuthService) startSession(tx *gorm.DB, userID, deviceID string, scopes []string) (*models.Session, string, error) {
+	now := as.now()
+	session := models.Session{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		DeviceID:   deviceID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if err := tx.Create(&session).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create session: %w", err)
+	}
+	refreshToken, err := as.issueRefreshToken(tx, &session, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, refreshToken, nil
+}
+
+// issueRefreshToken adds a new link to the session's rotation chain
+func (as *AuthService) issueRefreshToken(tx *gorm.DB, session *models.Session, scopes []string) (string, error) {
+	token, err := as.generateToken(tx.Statement.Context, session.UserID, session.ID, TokenTypeRefresh, scopes, refreshTokenTTL)
+	if err != nil {
+		return "", err
+	}
+
+	now := as.now()
+	record := models.RefreshToken{
+		ID:        uuid.New().String(),
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(refreshTokenTTL),
+		CreatedAt: now,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token, retiring the one presented. The token must be a valid
+// refresh-type JWT, and the request must come from the device the session
+// was opened on. Presenting a token that was already exchanged revokes the
+// whole session, since either the client or an attacker is holding a
+// copied token and we cannot tell which. With a session idle timeout
+// configured, a session that has not been used within it is ended and
+// ErrReauthRequired returned, so the user signs in again with a new code.
+func (as *AuthService) RefreshToken(ctx context.Context, refreshToken, deviceID string) (string, string, error) {
+	claims, err := as.ValidateToken(ctx, refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	if claims.Type != TokenTypeRefresh {
+		return "", "", fmt.Errorf("%w: not a refresh token", ErrUnauthenticated)
+	}
+	if tenant, _ := tenancy.FromContext(ctx); claims.Tenant != tenant {
+		return "", "", fmt.Errorf("%w: token belongs to another tenant", ErrUnauthenticated)
+	}
+
+	var record models.RefreshToken
+	if err := as.db.WithContext(ctx).Where("token_hash = ?", hashToken(refreshToken)).First(&record).Error; err != nil {
+		return "", "", fmt.Errorf("%w: invalid refresh token", ErrUnauthenticated)
+	}
+	if record.UserID != claims.Subject {
+		return "", "", fmt.Errorf("%w: invalid refresh token", ErrUnauthenticated)
+	}
+
+	var session models.Session
+	if err := as.db.WithContext(ctx).Where("id = ?", record.SessionID).First(&session).Error; err != nil {
+		return "", "", fmt.Errorf("%w: invalid refresh token", ErrUnauthenticated)
+	}
+	if session.RevokedAt != nil {
+		return "", "", fmt.Errorf("%w: session has been revoked, sign in again", ErrUnauthenticated)
+	}
+
+	now := as.now()
+	if idle := time.Duration(as.config.SessionIdleTimeout) * time.Second; idle > 0 && now.Sub(session.LastUsedAt) > idle {
+		if err := as.revokeSession(ctx, &session, SessionRevokedIdle); err != nil {
+			return "", "", err
+		}
+		return "", "", fmt.Errorf("%w: session was inactive too long, sign in again", ErrReauthRequired)
+	}
+	if record.RotatedAt != nil {
+		if err := as.revokeSession(ctx, &session, SessionRevokedTokenReuse); err != nil {
+			return "", "", err
+		}
+		as.notifySecurity(ctx, session.UserID, "We signed you out of a device because a sign-in token was reused. If this wasn't you, sign in again to secure your account.")
+		return "", "", fmt.Errorf("%w: refresh token already used, session revoked", ErrUnauthenticated)
+	}
+	if now.After(record.ExpiresAt) {
+		return "", "", fmt.Errorf("%w: refresh token expired", ErrUnauthenticated)
+	}
+	if deviceID != session.DeviceID {
+		log.Printf("Refresh for session %s rejected: device mismatch", session.ID)
+		as.notifySecurity(ctx, session.UserID, "We blocked an attempt to use your sign-in from an unrecognized device.")
+		return "", "", fmt.Errorf("%w: refresh token is bound to another device", ErrUnauthenticated)
+	}
+
+	var newRefreshToken string
+	err = as.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Guard against a concurrent exchange of the same token
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL", record.ID).
+			Update("rotated_at", now)
+		if result.Error != nil {
+			return fmt.Errorf("failed to rotate refresh token: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: refresh token already used", ErrUnauthenticated)
+		}
+		if err := tx.Model(&session).Update("last_used_at", now).Error; err != nil {
+			return fmt.Errorf("failed to update session: %w", err)
+		}
+
+		var err error
+		newRefreshToken, err = as.issueRefreshToken(tx, &session, claims.GrantedScopes())
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := as.generateToken(ctx, claims.Subject, session.ID, TokenTypeAccess, claims.GrantedScopes(), accessTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, newRefreshToken, nil
+}
+
+// Logout ends the session refreshToken belongs to and revokes the token,
+// and accessToken when given, so neither is accepted again even before it
+// expires. The session's other refresh tokens were already exchanged, so
+// revoking the session stops them too. With allSessions every session of
+// the user ends, signing out every device along with the access tokens
+// already issued to them. Only the session's current refresh token signs
+// out: one already exchanged, or from a session already ended, is refused,
+// so a copied token cannot be used to sign the user out everywhere.
+func (as *AuthService) Logout(ctx context.Context, refreshToken, accessToken string, allSessions bool) error {
+	claims, err := as.ValidateToken(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	if claims.Type != TokenTypeRefresh {
+		return fmt.Errorf("%w: not a refresh token", ErrUnauthenticated)
+	}
+	revoke := []*Claims{claims}
+	if accessToken != "" {
+		access, err := as.ValidateToken(ctx, accessToken)
+		if err != nil {
+			return err
+		}
+		if access.Type != TokenTypeAccess || access.Subject != claims.Subject {
+			return fmt.Errorf("%w: access token does not belong to this session's user", ErrInvalidArgument)
+		}
+		revoke = append(revoke, access)
+	}
+
+	var record models.RefreshToken
+	if err := as.db.WithContext(ctx).Where("token_hash = ?", hashToken(refreshToken)).First(&record).Error; err != nil {
+		return fmt.Errorf("%w: invalid refresh token", ErrUnauthenticated)
+	}
+	var session models.Session
+	if err := as.db.WithContext(ctx).Where("id = ?", record.SessionID).First(&session).Error; err != nil {
+		return fmt.Errorf("%w: invalid refresh token", ErrUnauthenticated)
+	}
+	if session.RevokedAt != nil {
+		return fmt.Errorf("%w: session has been revoked", ErrUnauthenticated)
+	}
+	if record.RotatedAt != nil {
+		return fmt.Errorf("%w: refresh token already used", ErrUnauthenticated)
+	}
+
+	now := as.now()
+	rows := make([]models.RevokedToken, len(revoke))
+	for i, c := range revoke {
+		rows[i] = models.RevokedToken{
+			TokenID:   c.ID,
+			UserID:    c.Subject,
+			ExpiresAt: time.Unix(c.ExpiresAt, 0),
+			RevokedAt: now,
+		}
+	}
+	if err := as.db.WithContext(context.WithoutCancel(ctx)).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+	if allSessions {
+		return as.revokeUserSessions(ctx, session.UserID, SessionRevokedLogoutAll)
+	}
+	return as.revokeSession(ctx, &session, SessionRevokedLogout)
+}
+
+// PurgeRevokedTokens forgets revoked tokens that have expired, since
+// ValidateToken refuses them anyway
+func (as *AuthService) PurgeRevokedTokens(ctx context.Context) (int, error) {
+	result := as.db.WithContext(ctx).Where("expires_at <= ?", as.now()).Delete(&models.RevokedToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge revoked tokens: %w", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// PurgeExpiredOTPs deletes sign-in codes that have expired unused. Codes
+// still inside the rate limit window are kept, since checkOTPRate counts
+// them.
+func (as *AuthService) PurgeExpiredOTPs(ctx context.Context) (int, error) {
+	now := as.now()
+	windowStart := now.Add(-time.Duration(as.config.RateLimitWindow) * time.Second)
+	result := as.db.WithContext(ctx).
+		Where("expires_at <= ? AND created_at <= ?", now, windowStart).
+		Delete(&models.OTPStore{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge expired OTPs: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Purged %d expired sign-in codes", result.RowsAffected)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// RecordActivity marks userID's session sessionID as used now, so it does
+// not go idle while the user keeps calling with its access tokens. A
+// session already idle longer than the timeout stays so, and RefreshToken
+// ends it. Callers throttle this; the auth middleware records a session
+// at most once a minute.
+func (as *AuthService) RecordActivity(ctx context.Context, userID, sessionID string) error {
+	now := as.now()
+	query := as.db.WithContext(ctx).Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID)
+	if idle := time.Duration(as.config.SessionIdleTimeout) * time.Second; idle > 0 {
+		query = query.Where("last_used_at >= ?", now.Add(-idle))
+	}
+	if err := query.Update("last_used_at", now).Error; err != nil {
+		return fmt.Errorf("failed to record session activity: %w", err)
+	}
+	return nil
+}
+
+// revokeSession ends a session so none of its refresh tokens work again.
+// It completes even if the caller hangs up, so a revocation cannot be
+// dodged by cancelling the request that triggered it.
+func (as *AuthService) revokeSession(ctx context.Context, session *models.Session, reason string) error {
+	now := as.now()
+	if err := as.db.WithContext(context.WithoutCancel(ctx)).Model(session).Updates(map[string]interface{}{
+		"revoked_at":    now,
+		"revoke_reason": reason,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	log.Printf("Revoked session %s for user %s: %s", session.ID, session.UserID, reason)
+	return nil
+}
+
+// revokeUserSessions ends every open session of userID, completing even if
+// the caller hangs up as revokeSession does
+func (as *AuthService) revokeUserSessions(ctx context.Context, userID, reason string) error {
+	result := as.db.WithContext(context.WithoutCancel(ctx)).Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{
+			"revoked_at":    as.now(),
+			"revoke_reason": reason,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", result.Error)
+	}
+	log.Printf("Revoked %d sessions for user %s: %s", result.RowsAffected, userID, reason)
+	return nil
+}
+
+// notifySecurity queues a security alert for the user. Failures are logged
+// rather than returned so they never change the outcome of the auth check.
+func (as *AuthService) notifySecurity(ctx context.Context, userID, body string) {
+	err := as.deliveries.Notify(context.WithoutCancel(ctx), Notification{
+		UserID: userID,
+		Title:  "Security alert",
+		Body:   body,
+		Link:   "clarity://settings/security",
+	})
+	if err != nil {
+		log.Printf("Failed to queue security alert for user %s: %v", userID, err)
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
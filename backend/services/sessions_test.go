@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/permissions"
+	"gorm.io/gorm"
+)
+
+// signIn signs email in on deviceID with an emailed code and returns the
+// user ID and refresh token
+func signIn(t *testing.T, as *AuthService, email, deviceID string) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	reference, _, err := as.SendOTP(ctx, email)
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	user, _, refreshToken, err := as.VerifyOTP(ctx, email, sentOTP(t, as.db, reference), deviceID)
+	if err != nil {
+		t.Fatalf("VerifyOTP: %v", err)
+	}
+	return user.ID, refreshToken
+}
+
+// sessionOf returns the session a refresh token belongs to
+func sessionOf(t *testing.T, db *gorm.DB, refreshToken string) models.Session {
+	t.Helper()
+	var record models.RefreshToken
+	if err := db.First(&record, "token_hash = ?", hashToken(refreshToken)).Error; err != nil {
+		t.Fatalf("refresh token not stored: %v", err)
+	}
+	var session models.Session
+	if err := db.First(&session, "id = ?", record.SessionID).Error; err != nil {
+		t.Fatalf("session not found: %v", err)
+	}
+	return session
+}
+
+// securityAlerts counts the security notifications queued for userID
+func securityAlerts(t *testing.T, db *gorm.DB, userID string) int64 {
+	t.Helper()
+	var count int64
+	db.Model(&models.Delivery{}).Where("channel = ? AND recipient = ? AND subject = ?", models.DeliveryChannelPush, userID, "Security alert").Count(&count)
+	return count
+}
+
+func newSessionTestAuth(t *testing.T) (*AuthService, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Now()}
+	return newTestAuthService(newTestDB(t), &config.AuthConfig{}, clock), clock
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	as, clock := newSessionTestAuth(t)
+	ctx := context.Background()
+	userID, token := signIn(t, as, "a@example.com", "phone-1")
+	first := token
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Hour)
+		access, next, err := as.RefreshToken(ctx, token, "phone-1")
+		if err != nil {
+			t.Fatalf("refresh %d: %v", i+1, err)
+		}
+		if next == token {
+			t.Fatalf("refresh %d returned the same refresh token", i+1)
+		}
+		claims, err := as.ValidateToken(ctx, access)
+		if err != nil || claims.Type != TokenTypeAccess || claims.Subject != userID {
+			t.Fatalf("refresh %d access token = %+v, %v", i+1, claims, err)
+		}
+		token = next
+	}
+
+	session := sessionOf(t, as.db, token)
+	if session.ID != sessionOf(t, as.db, first).ID {
+		t.Error("rotation left the session")
+	}
+	var chain []models.RefreshToken
+	as.db.Where("session_id = ?", session.ID).Order("created_at").Find(&chain)
+	if len(chain) != 4 {
+		t.Fatalf("chain has %d tokens, want 4", len(chain))
+	}
+	for i, link := range chain {
+		if rotated := link.RotatedAt != nil; rotated != (i < 3) {
+			t.Errorf("token %d rotated = %v", i, rotated)
+		}
+	}
+	if !session.LastUsedAt.Equal(clock.now) {
+		t.Errorf("LastUsedAt = %v, want the last refresh", session.LastUsedAt)
+	}
+}
+
+func TestReplayedRefreshTokenRevokesSession(t *testing.T) {
+	tests := []struct {
+		name string
+		// attackerFirst replays the stolen token before the client uses it
+		attackerFirst bool
+	}{
+		{"attacker replays a rotated token", false},
+		{"client presents a token the attacker already rotated", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, _ := newSessionTestAuth(t)
+			ctx := context.Background()
+			userID, stolen := signIn(t, as, "a@example.com", "phone-1")
+			_, otherDevice := signIn(t, as, "a@example.com", "tablet-1")
+
+			// The attacker copied the token and the device ID
+			var live string
+			if tt.attackerFirst {
+				_, attackerToken, err := as.RefreshToken(ctx, stolen, "phone-1")
+				if err != nil {
+					t.Fatalf("attacker refresh: %v", err)
+				}
+				if _, _, err := as.RefreshToken(ctx, stolen, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("client refresh with the rotated token: error = %v, want ErrUnauthenticated", err)
+				}
+				live = attackerToken
+			} else {
+				_, clientToken, err := as.RefreshToken(ctx, stolen, "phone-1")
+				if err != nil {
+					t.Fatalf("client refresh: %v", err)
+				}
+				if _, _, err := as.RefreshToken(ctx, stolen, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("replayed token: error = %v, want ErrUnauthenticated", err)
+				}
+				live = clientToken
+			}
+
+			session := sessionOf(t, as.db, stolen)
+			if session.RevokedAt == nil || session.RevokeReason != SessionRevokedTokenReuse {
+				t.Fatalf("session = %+v, want it revoked for token reuse", session)
+			}
+			if _, _, err := as.RefreshToken(ctx, live, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("newest token of the revoked family: error = %v, want ErrUnauthenticated", err)
+			}
+			if securityAlerts(t, as.db, userID) != 1 {
+				t.Error("no security alert queued for the reuse")
+			}
+
+			if _, _, err := as.RefreshToken(ctx, otherDevice, "tablet-1"); err != nil {
+				t.Errorf("the user's other session was revoked too: %v", err)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenDeviceMismatch(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+	userID, token := signIn(t, as, "a@example.com", "phone-1")
+
+	for _, device := range []string{"attacker-laptop", ""} {
+		if _, _, err := as.RefreshToken(ctx, token, device); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("refresh from %q: error = %v, want ErrUnauthenticated", device, err)
+		}
+	}
+	if got := securityAlerts(t, as.db, userID); got != 2 {
+		t.Errorf("queued %d security alerts, want one per blocked attempt", got)
+	}
+	if session := sessionOf(t, as.db, token); session.RevokedAt != nil {
+		t.Error("a blocked attempt from another device revoked the session")
+	}
+	if _, _, err := as.RefreshToken(ctx, token, "phone-1"); err != nil {
+		t.Errorf("the bound device can no longer refresh: %v", err)
+	}
+}
+
+func TestRefreshTokenRejections(t *testing.T) {
+	as, clock := newSessionTestAuth(t)
+	ctx := context.Background()
+	_, token := signIn(t, as, "a@example.com", "phone-1")
+	access, err := as.generateToken(ctx, "someone", "", TokenTypeAccess, nil, accessTokenTTL)
+	if err != nil {
+		t.Fatalf("generateToken: %v", err)
+	}
+	unstored, err := as.generateToken(ctx, "someone", "", TokenTypeRefresh, nil, refreshTokenTTL)
+	if err != nil {
+		t.Fatalf("generateToken: %v", err)
+	}
+
+	for name, presented := range map[string]string{
+		"access token":             access,
+		"refresh token not issued": unstored,
+		"garbage":                  "not.a.token",
+	} {
+		if _, _, err := as.RefreshToken(ctx, presented, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: error = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+
+	clock.Advance(refreshTokenTTL + time.Minute)
+	if _, _, err := as.RefreshToken(ctx, token, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expired refresh token: error = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+
+	userID, refreshToken := signIn(t, as, "scopes@example.com", "device-1")
+	claims, err := as.ValidateToken(ctx, refreshToken)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if !slices.Equal(claims.GrantedScopes(), permissions.LoginScopes) {
+		t.Errorf("sign-in scopes = %v, want %v", claims.GrantedScopes(), permissions.LoginScopes)
+	}
+
+	// A narrowed session stays narrow through every refresh
+	narrow := []string{permissions.ScopeRecordsRead}
+	_, refreshToken, err = as.startSession(as.db, userID, "device-2", narrow)
+	if err != nil {
+		t.Fatalf("startSession: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		var accessToken string
+		accessToken, refreshToken, err = as.RefreshToken(ctx, refreshToken, "device-2")
+		if err != nil {
+			t.Fatalf("RefreshToken: %v", err)
+		}
+		for _, token := range []string{accessToken, refreshToken} {
+			claims, err := as.ValidateToken(ctx, token)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if !slices.Equal(claims.GrantedScopes(), narrow) {
+				t.Errorf("refresh %d: %s token scopes = %v, want %v", i, claims.Type, claims.GrantedScopes(), narrow)
+			}
+		}
+	}
+
+	// No scopes means none, not the sign-in set
+	empty, err := as.generateToken(ctx, userID, "", TokenTypeAccess, nil, accessTokenTTL)
+	if err != nil {
+		t.Fatalf("generateToken: %v", err)
+	}
+	if claims, err := as.ValidateToken(ctx, empty); err != nil || len(claims.GrantedScopes()) != 0 {
+		t.Errorf("token issued without scopes grants %v, %v", claims.GrantedScopes(), err)
+	}
+
+	// Tokens from before scopes existed have no claim at all
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(
+		`{"sub":%q,"iat":%d,"exp":%d,"type":"access","jti":"legacy"}`, userID, as.now().Unix(), as.now().Add(time.Hour).Unix())))
+	legacy := jwtHeader + "." + payload + "." + as.signToken(jwtHeader+"."+payload)
+	if claims, err := as.ValidateToken(ctx, legacy); err != nil || !slices.Equal(claims.GrantedScopes(), permissions.LoginScopes) {
+		t.Errorf("legacy token grants %v, %v; want the sign-in scopes", claims, err)
+	}
+}
+
+func TestRefreshTokensAreStoredHashed(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	_, token := signIn(t, as, "a@example.com", "phone-1")
+	_, next, err := as.RefreshToken(context.Background(), token, "phone-1")
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+
+	var rows []map[string]interface{}
+	if err := as.db.Raw("SELECT * FROM refresh_tokens").Scan(&rows).Error; err != nil {
+		t.Fatalf("read refresh_tokens: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%d stored refresh tokens, want 2", len(rows))
+	}
+	for _, row := range rows {
+		for column, value := range row {
+			if s := fmt.Sprint(value); strings.Contains(s, token) || strings.Contains(s, next) {
+				t.Errorf("column %s holds a refresh token", column)
+			}
+		}
+	}
+}
+
+func TestConcurrentRefreshOfOneToken(t *testing.T) {
+	as, _ := newSessionTestAuth(t)
+	ctx := context.Background()
+	_, token := signIn(t, as, "a@example.com", "phone-1")
+
+	const attempts = 8
+	var wg sync.WaitGroup
+	issued := make(chan string, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, next, err := as.RefreshToken(ctx, token, "phone-1"); err == nil {
+				issued <- next
+			}
+		}()
+	}
+	wg.Wait()
+	close(issued)
+
+	var tokens []string
+	for next := range issued {
+		tokens = append(tokens, next)
+	}
+	// One exchange wins; any later one is a reuse and ends the session
+	if len(tokens) != 1 {
+		t.Fatalf("the token was exchanged %d times, want once", len(tokens))
+	}
+	var rotated int64
+	as.db.Model(&models.RefreshToken{}).Where("rotated_at IS NOT NULL").Count(&rotated)
+	if rotated != 1 {
+		t.Errorf("%d tokens marked rotated, want 1", rotated)
+	}
+}
+
+func TestLogoutAllSessionsRevokesRotatedTokens(t *testing.T) {
+	as, clock := newSessionTestAuth(t)
+	ctx := context.Background()
+	_, phone := signIn(t, as, "a@example.com", "phone-1")
+	_, tablet := signIn(t, as, "a@example.com", "tablet-1")
+
+	// The tablet has refreshed since signing in, so its live token is not
+	// the one it was first given
+	clock.Advance(time.Hour)
+	_, tabletNow, err := as.RefreshToken(ctx, tablet, "tablet-1")
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+
+	if err := as.Logout(ctx, phone, "", true); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	for device, token := range map[string]string{"phone-1": phone, "tablet-1": tabletNow} {
+		_, _, err := as.RefreshToken(ctx, token, device)
+		if !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), "revoked") {
+			t.Errorf("%s after signing out everywhere: %v, want the session revoked", device, err)
+		}
+		if session := sessionOf(t, as.db, token); session.RevokeReason != SessionRevokedLogoutAll {
+			t.Errorf("%s session revoked for %q, want %q", device, session.RevokeReason, SessionRevokedLogoutAll)
+		}
+	}
+}
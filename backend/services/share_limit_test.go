@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/models"
+)
+
+func TestRecordShareCap(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	es.config.MaxRecordShares = 2
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+	createRecord(t, db, "rec-2", "user-1", models.SensitivityStandard)
+	ctx := context.Background()
+
+	share := func(ids ...string) (*CreatedExportLink, error) {
+		return es.CreateExportLink(ctx, "user-1", ids, time.Hour, false, "", false)
+	}
+
+	first, err := share("rec-1")
+	if err != nil {
+		t.Fatalf("first share: %v", err)
+	}
+	if _, err := share("rec-1", "rec-2"); err != nil {
+		t.Fatalf("second share: %v", err)
+	}
+
+	// rec-1 is at the cap, so a link including it is refused whole
+	_, err = share("rec-2", "rec-1")
+	if !errors.Is(err, ErrShareLimitReached) {
+		t.Fatalf("third share of rec-1: error = %v, want %v", err, ErrShareLimitReached)
+	}
+	if !strings.Contains(err.Error(), `"Record rec-1"`) || strings.Contains(err.Error(), `"Record rec-2"`) {
+		t.Errorf("error %q should name only the record at the cap", err)
+	}
+	var links int64
+	db.Model(&models.ExportLink{}).Count(&links)
+	if links != 2 {
+		t.Errorf("%d links stored, want the refused one left out", links)
+	}
+
+	// rec-2 has a share left
+	if _, err := share("rec-2"); err != nil {
+		t.Errorf("second share of rec-2: %v", err)
+	}
+
+	// Revoking a link frees its slot
+	if err := es.RevokeExportLink(ctx, "user-1", first.Link.ID); err != nil {
+		t.Fatalf("RevokeExportLink: %v", err)
+	}
+	if _, err := share("rec-1"); err != nil {
+		t.Errorf("share after revoking one: %v", err)
+	}
+	if _, err := share("rec-1"); !errors.Is(err, ErrShareLimitReached) {
+		t.Errorf("share past the cap again: error = %v, want %v", err, ErrShareLimitReached)
+	}
+
+	// So does a link expiring, and the purge drops its shared records
+	clock.Advance(2 * time.Hour)
+	if _, err := share("rec-1"); err != nil {
+		t.Errorf("share after the links expired: %v", err)
+	}
+	if _, err := es.PurgeExpired(ctx); err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	var shared int64
+	db.Model(&models.ExportLinkRecord{}).Count(&shared)
+	if shared != 1 {
+		t.Errorf("%d shared records left after the purge, want the live link's 1", shared)
+	}
+}
+
+func TestRecordShareCapDisabled(t *testing.T) {
+	db := newTestDB(t)
+	clock := &testClock{now: time.Date(2026, 10, 12, 10, 0, 0, 0, time.UTC)}
+	es := newTestExportService(db, clock)
+	es.config.MaxRecordShares = 0
+	createUser(t, db, "user-1")
+	createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+
+	for i := 0; i < 5; i++ {
+		if _, err := es.CreateExportLink(context.Background(), "user-1", []string{"rec-1"}, time.Hour, false, "", false); err != nil {
+			t.Fatalf("share %d with no cap: %v", i+1, err)
+		}
+	}
+}
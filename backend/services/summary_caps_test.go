@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+)
+
+// findings returns n numbered findings
+func findings(n int) []string {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = fmt.Sprintf("finding %d", i+1)
+	}
+	return items
+}
+
+func TestCapFindings(t *testing.T) {
+	tests := []struct {
+		name     string
+		findings int
+		max      int
+		want     []string
+	}{
+		{"under the cap", 3, 5, findings(3)},
+		{"at the cap", 5, 5, findings(5)},
+		{"one over", 6, 5, append(findings(4), "… [2 more findings truncated]")},
+		{"far over", 30, 5, append(findings(4), "… [26 more findings truncated]")},
+		{"cap of one", 3, 1, []string{"… [3 more findings truncated]"}},
+		{"no cap", 30, 0, findings(30)},
+		{"none", 0, 5, findings(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := capFindings(findings(tt.findings), tt.max); !slices.Equal(got, tt.want) {
+				t.Errorf("capFindings = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// overProducedSummary is a summary with n findings and recommendations of
+// length bytes, in both its sections and its flat fields
+func overProducedSummary(n, length int) *HealthSummary {
+	return newHealthSummary([]SummarySection{
+		{Name: SummarySectionSummary, Text: "Mostly well"},
+		{Name: SummarySectionFindings, Items: findings(n)},
+		{Name: SummarySectionRecommendations, Text: strings.Repeat("r", length)},
+	})
+}
+
+func TestSummarizeHealthCapsFindingsAndRecommendations(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	ctx := context.Background()
+
+	tests := []struct {
+		name                  string
+		maxFindings, maxRecs  int
+		findings, recsLength  int
+		wantFindings, wantLen int
+		truncated             bool
+	}{
+		{"over both caps", 5, 100, 30, 5000, 5, 100, true},
+		{"normal output", 5, 100, 3, 80, 3, 80, false},
+		{"exactly at the caps", 5, 100, 5, 100, 5, 100, false},
+		{"caps disabled", 0, 0, 30, 5000, 30, 5000, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as := newTestAIService(t, db, &config.AIConfig{MaxSummaryFindings: tt.maxFindings, MaxSummaryRecommendations: tt.maxRecs})
+			as.provider = &fakeProvider{summary: overProducedSummary(tt.findings, tt.recsLength)}
+
+			summary, err := as.SummarizeHealth(ctx, "user-1", 30, nil, nil)
+			if err != nil {
+				t.Fatalf("SummarizeHealth: %v", err)
+			}
+			section := summary.Section(SummarySectionFindings).Items
+			if len(summary.KeyFindings) != tt.wantFindings || !slices.Equal(section, summary.KeyFindings) {
+				t.Errorf("%d key findings, section has %d; want %d in both", len(summary.KeyFindings), len(section), tt.wantFindings)
+			}
+			recommendations := summary.Section(SummarySectionRecommendations).Text
+			if len(summary.Recommendations) != tt.wantLen || recommendations != summary.Recommendations {
+				t.Errorf("recommendations of %d bytes, section of %d; want %d in both", len(summary.Recommendations), len(recommendations), tt.wantLen)
+			}
+
+			last := summary.KeyFindings[len(summary.KeyFindings)-1]
+			if got := strings.HasPrefix(last, "… [") && strings.HasSuffix(last, "more findings truncated]"); got != tt.truncated {
+				t.Errorf("last finding %q, truncation marker %v, want %v", last, got, tt.truncated)
+			}
+			if got := strings.HasSuffix(summary.Recommendations, truncatedMarker); got != tt.truncated {
+				t.Errorf("recommendations end with the marker: %v, want %v", got, tt.truncated)
+			}
+			if !tt.truncated && summary.KeyFindings[0] != "finding 1" {
+				t.Errorf("findings = %q, want them as produced", summary.KeyFindings)
+			}
+			if summary.Section(SummarySectionSummary).Text != "Mostly well" {
+				t.Error("an uncapped section changed")
+			}
+		})
+	}
+}
+
+func TestSummaryCapsDoNotSplitRunes(t *testing.T) {
+	// "é" is two bytes; a cut landing between them backs off to the rune
+	hs := newHealthSummary([]SummarySection{{Name: SummarySectionRecommendations, Text: strings.Repeat("é", 50)}})
+	capSummary(hs, 0, 20)
+	if len(hs.Recommendations) > 20 || !strings.HasSuffix(hs.Recommendations, truncatedMarker) {
+		t.Fatalf("recommendations = %q (%d bytes), want at most 20 ending in the marker", hs.Recommendations, len(hs.Recommendations))
+	}
+	if body := strings.TrimSuffix(hs.Recommendations, truncatedMarker); body != strings.Repeat("é", len(body)/2) {
+		t.Errorf("recommendations cut mid-rune: %q", hs.Recommendations)
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/models"
+)
+
+func TestSummarizeHealthLeavesOutExcludedRecords(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	for _, id := range []string{"kept-1", "kept-2", "erroneous"} {
+		createRecord(t, db, id, "user-1", models.SensitivityStandard)
+	}
+	as := newTestAIService(t, db, nil)
+	provider := &fakeProvider{}
+	as.provider = provider
+
+	summary, err := as.SummarizeHealth(context.Background(), "user-1", 30, nil, []string{"erroneous", "erroneous"})
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if summary.Summary != "2 records" {
+		t.Errorf("summary = %q, want it built from 2 records", summary.Summary)
+	}
+	prompt := SummaryRecordsText(provider.summarized, "")
+	if strings.Contains(prompt, "erroneous") {
+		t.Errorf("excluded record reached the prompt:\n%s", prompt)
+	}
+	for _, id := range []string{"kept-1", "kept-2"} {
+		if !strings.Contains(prompt, "Record "+id) {
+			t.Errorf("prompt is missing %s:\n%s", id, prompt)
+		}
+	}
+
+	// Without exclusions every record is summarized
+	if _, err := as.SummarizeHealth(context.Background(), "user-1", 30, nil, nil); err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if len(provider.summarized) != 3 {
+		t.Errorf("%d records summarized without exclusions, want 3", len(provider.summarized))
+	}
+}
+
+func TestSummaryExclusionsMustBeOwnRecords(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	createUser(t, db, "user-2")
+	createRecord(t, db, "mine", "user-1", models.SensitivityStandard)
+	createRecord(t, db, "theirs", "user-2", models.SensitivityStandard)
+	as := newTestAIService(t, db, nil)
+	provider := &fakeProvider{}
+	as.provider = provider
+
+	tooMany := make([]string, maxSummaryExclusions+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("record-%d", i)
+	}
+	tests := []struct {
+		name    string
+		exclude []string
+		wantErr error
+	}{
+		{"another user's record", []string{"theirs"}, ErrNotFound},
+		{"own and another user's", []string{"mine", "theirs"}, ErrNotFound},
+		{"unknown record", []string{"missing"}, ErrNotFound},
+		{"too many", tooMany, ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.summarized = nil
+			_, err := as.SummarizeHealth(context.Background(), "user-1", 30, nil, tt.exclude)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if provider.summarized != nil {
+				t.Error("a refused request reached the provider")
+			}
+		})
+	}
+
+	// The other user can exclude their own record
+	if _, err := as.SummarizeHealth(context.Background(), "user-2", 30, nil, []string{"theirs"}); err != nil {
+		t.Errorf("owner excluding their record: %v", err)
+	}
+	if len(provider.summarized) != 0 {
+		t.Errorf("%d records summarized, want none", len(provider.summarized))
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+)
+
+// openAIReplying is the OpenAI response carrying content as the reply text
+func openAIReplying(t *testing.T, content string) string {
+	t.Helper()
+	text, err := json.Marshal(content)
+	if err != nil {
+		t.Fatalf("encode reply: %v", err)
+	}
+	return hostedReplies["openai"](string(text[1 : len(text)-1]))
+}
+
+func TestSummarizeHealthKeepsUsableSections(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+	}{
+		{"recommendations missing", `{"summary":"Blood pressure is stable.","findings":["BP 120/80","Glucose normal"]}`},
+		{"recommendations malformed", `{"summary":"Blood pressure is stable.","findings":["BP 120/80","Glucose normal"],"recommendations":{"diet":"less salt"}}`},
+		{"recommendations blank", "```json\n" + `{"summary":"Blood pressure is stable.","findings":["BP 120/80","Glucose normal"],"recommendations":" "}` + "\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			as := newTestAIService(t, db, &config.AIConfig{BreakerThreshold: 1, BreakerCooldown: 60})
+			as.provider, _ = hostedTestProvider(t, config.AIConfig{Provider: "openai"}, openAIReplying(t, tt.reply))
+			createUser(t, db, "user-1")
+			createRecord(t, db, "rec-1", "user-1", models.SensitivityStandard)
+
+			summary, err := as.SummarizeHealth(context.Background(), "user-1", 30, nil, nil)
+			if err != nil {
+				t.Fatalf("SummarizeHealth: %v", err)
+			}
+			// The model's own sections are kept rather than replaced by the
+			// rule-based summary
+			if summary.Degraded {
+				t.Error("partial summary marked degraded")
+			}
+			if summary.Summary != "Blood pressure is stable." || !slices.Equal(summary.KeyFindings, []string{"BP 120/80", "Glucose normal"}) {
+				t.Errorf("summary = %q, findings %q; want the model's", summary.Summary, summary.KeyFindings)
+			}
+			if summary.Recommendations != "" {
+				t.Errorf("Recommendations = %q, want empty", summary.Recommendations)
+			}
+			if want := []string{SummarySectionRecommendations}; !slices.Equal(summary.IncompleteSections, want) {
+				t.Errorf("IncompleteSections = %v, want %v", summary.IncompleteSections, want)
+			}
+			if summary.RecordCount != 1 {
+				t.Errorf("RecordCount = %d, want 1", summary.RecordCount)
+			}
+			if state := as.breaker.State(); state != BreakerClosed {
+				t.Errorf("breaker %s after a partial summary, want closed", state)
+			}
+		})
+	}
+}
+
+func TestSummarizeHealthPartialRequestedSections(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	reply := `{"medications":["Metformin 500mg"],"risks":42,"trends":["Weight down 2kg"]}`
+	as.provider, _ = hostedTestProvider(t, config.AIConfig{Provider: "openai"}, openAIReplying(t, reply))
+	createUser(t, db, "user-1")
+
+	sections := []string{SummarySectionMedications, SummarySectionRisks, SummarySectionTrends}
+	summary, err := as.SummarizeHealth(context.Background(), "user-1", 30, sections, nil)
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if meds := summary.Section(SummarySectionMedications).Items; !slices.Equal(meds, []string{"Metformin 500mg"}) {
+		t.Errorf("medications = %q", meds)
+	}
+	if trends := summary.Section(SummarySectionTrends).Items; !slices.Equal(trends, []string{"Weight down 2kg"}) {
+		t.Errorf("trends = %q", trends)
+	}
+	if risks := summary.Section(SummarySectionRisks); risks.Text != "" || risks.Items != nil {
+		t.Errorf("malformed risks = %+v, want empty", risks)
+	}
+	if want := []string{SummarySectionRisks}; !slices.Equal(summary.IncompleteSections, want) {
+		t.Errorf("IncompleteSections = %v, want %v", summary.IncompleteSections, want)
+	}
+}
+
+func TestSummarizeHealthWithNoUsableSection(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, nil)
+	as.provider, _ = hostedTestProvider(t, config.AIConfig{Provider: "openai"}, openAIReplying(t, `{"summary":"","findings":[],"recommendations":null}`))
+	createUser(t, db, "user-1")
+
+	// Nothing to keep is a refusal, not an all-incomplete summary
+	if summary, err := as.SummarizeHealth(context.Background(), "user-1", 30, nil, nil); !errors.Is(err, ErrContentRefused) {
+		t.Errorf("SummarizeHealth = %+v, %v; want a refusal", summary, err)
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm"
+)
+
+// createAgedRecords creates n records for user-1 named new-1 to new-n,
+// new-1 the most recent and each a day older than the last, and two
+// records older than any summary window here
+func createAgedRecords(t *testing.T, db *gorm.DB, n int) {
+	t.Helper()
+	createUser(t, db, "user-1")
+	age := func(id string, days int) {
+		createRecord(t, db, id, "user-1", "normal")
+		at := time.Now().AddDate(0, 0, -days)
+		if err := db.Exec("UPDATE health_records SET created_at = ? WHERE id = ?", at, id).Error; err != nil {
+			t.Fatalf("age %s: %v", id, err)
+		}
+	}
+	for i := 1; i <= n; i++ {
+		age(fmt.Sprintf("new-%d", i), i)
+	}
+	age("old-1", 60)
+	age("old-2", 61)
+}
+
+// summarizedIDs lists the IDs of the records the provider was last asked
+// to summarize, newest first
+func summarizedIDs(fp *fakeProvider) []string {
+	records := slices.Clone(fp.summarized)
+	slices.SortFunc(records, func(a, b models.HealthRecord) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return recordIDs(records)
+}
+
+// newest returns the IDs of the n most recent records createAgedRecords made
+func newest(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("new-%d", i+1)
+	}
+	return ids
+}
+
+func TestSummarizeHealthCapsRecords(t *testing.T) {
+	db := newTestDB(t)
+	createAgedRecords(t, db, 8)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		max       int
+		want      []string
+		truncated bool
+	}{
+		{"over the cap", 5, newest(5), true},
+		{"cap of one", 1, newest(1), true},
+		{"exactly at the cap", 8, newest(8), false},
+		{"under the cap", 20, newest(8), false},
+		{"cap disabled", 0, newest(8), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as := newTestAIService(t, db, &config.AIConfig{MaxSummaryRecords: tt.max})
+			fp := &fakeProvider{}
+			as.provider = fp
+
+			summary, err := as.SummarizeHealth(ctx, "user-1", 30, nil, nil)
+			if err != nil {
+				t.Fatalf("SummarizeHealth: %v", err)
+			}
+			if got := summarizedIDs(fp); !slices.Equal(got, tt.want) {
+				t.Errorf("summarized %v, want %v", got, tt.want)
+			}
+			if summary.RecordsTruncated != tt.truncated || summary.RecordCount != len(tt.want) {
+				t.Errorf("truncated %v with %d records, want %v with %d", summary.RecordsTruncated, summary.RecordCount, tt.truncated, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestSummaryRecordCapAppliesWithinTheWindow(t *testing.T) {
+	db := newTestDB(t)
+	createAgedRecords(t, db, 8)
+	ctx := context.Background()
+	as := newTestAIService(t, db, &config.AIConfig{MaxSummaryRecords: 5})
+	fp := &fakeProvider{}
+	as.provider = fp
+
+	// The window, not the cap, decides which records are considered
+	summary, err := as.SummarizeHealth(ctx, "user-1", 3, nil, nil)
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if got := summarizedIDs(fp); !slices.Equal(got, newest(2)) || summary.RecordsTruncated {
+		t.Errorf("3 day window summarized %v, truncated %v; want %v, not truncated", got, summary.RecordsTruncated, newest(2))
+	}
+
+	// A long window reaches the old records but the cap keeps the newest
+	if _, err := as.SummarizeHealth(ctx, "user-1", 90, nil, nil); err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if got := summarizedIDs(fp); !slices.Equal(got, newest(5)) {
+		t.Errorf("90 day window summarized %v, want %v", got, newest(5))
+	}
+
+	// Excluded records do not count towards the cap
+	if _, err := as.SummarizeHealth(ctx, "user-1", 30, nil, []string{"new-1", "new-3"}); err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if want := []string{"new-2", "new-4", "new-5", "new-6", "new-7"}; !slices.Equal(summarizedIDs(fp), want) {
+		t.Errorf("summarized %v with exclusions, want %v", summarizedIDs(fp), want)
+	}
+}
+
+func TestDegradedSummaryReportsTruncation(t *testing.T) {
+	db := newTestDB(t)
+	createAgedRecords(t, db, 8)
+	as := newTestAIService(t, db, &config.AIConfig{MaxSummaryRecords: 3})
+	as.provider = &fakeProvider{summaryErr: errors.New("provider unavailable")}
+
+	summary, err := as.SummarizeHealth(context.Background(), "user-1", 30, nil, nil)
+	if err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if !summary.Degraded || !summary.RecordsTruncated || summary.RecordCount != 3 {
+		t.Errorf("degraded %v, truncated %v with %d records; want a degraded summary of 3, truncated", summary.Degraded, summary.RecordsTruncated, summary.RecordCount)
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/clarity/backend/models"
+)
+
+// Health summary sections a caller can request
+const (
+	SummarySectionSummary         = "summary"
+	SummarySectionFindings        = "findings"
+	SummarySectionRecommendations = "recommendations"
+	SummarySectionMedications     = "medications"
+	SummarySectionTrends          = "trends"
+	SummarySectionRisks           = "risks"
+)
+
+// DefaultSummarySections are used when a call requests none
+var DefaultSummarySections = []string{SummarySectionSummary, SummarySectionFindings, SummarySectionRecommendations}
+
+// summarySectionFormats tells the model what to put in each section. List
+// sections are JSON arrays of strings; the rest are a single string.
+var summarySectionFormats = map[string]struct {
+	instruction string
+	list        bool
+}{
+	SummarySectionSummary:         {"a brief health summary (2-3 sentences)", false},
+	SummarySectionFindings:        {"3 key findings", true},
+	SummarySectionRecommendations: {"health recommendations", false},
+	SummarySectionMedications:     {"each current medication and how it is being taken", true},
+	SummarySectionTrends:          {"changes over the period, such as recurring symptoms or shifting results", true},
+	SummarySectionRisks:           {"possible risks worth raising with a doctor", true},
+}
+
+// SummarySection is one section of a health summary. List sections fill
+// Items and the others Text.
+type SummarySection struct {
+	Name  string
+	Text  string
+	Items []string
+}
+
+// Section returns the named section, or an empty one if the summary has
+// none by that name
+func (hs *HealthSummary) Section(name string) SummarySection {
+	for _, section := range hs.Sections {
+		if section.Name == name {
+			return section
+		}
+	}
+	return SummarySection{Name: name}
+}
+
+// normalizeSummarySections validates requested section names, dropping
+// duplicates and keeping the caller's order. No sections means the
+// defaults.
+func normalizeSummarySections(sections []string) ([]string, error) {
+	if len(sections) == 0 {
+		return DefaultSummarySections, nil
+	}
+	seen := make(map[string]bool, len(sections))
+	var normalized []string
+	for _, name := range sections {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := summarySectionFormats[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown summary section %q", ErrInvalidArgument, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized, nil
+}
+
+// SummaryPrompt is the system prompt asking a model for the requested
+// sections as one JSON object. Model-backed providers send it with
+// SummaryRecordsText and read the reply with ParseSummaryResponse.
+func SummaryPrompt(days int, sections []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are a medical assistant AI. Analyze the user's health records from the last %d days and provide:\n", days)
+	for i, name := range sections {
+		fmt.Fprintf(&b, "%d. %s: %s\n", i+1, name, summarySectionFormats[name].instruction)
+	}
+	b.WriteString("\nFormat your response as one JSON object with exactly these keys:\n{\n")
+	for i, name := range sections {
+		value := `"..."`
+		if summarySectionFormats[name].list {
+			value = `["...", "..."]`
+		}
+		sep := ","
+		if i == len(sections)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&b, "  %q: %s%s\n", name, value, sep)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// SummaryRecordsText lists records for the user message of a summary
+// prompt. Records detected in a language other than language are marked
+// with theirs, and the model is asked to answer in language.
+func SummaryRecordsText(records []models.HealthRecord, language string) string {
+	var b strings.Builder
+	b.WriteString("Health Records:\n")
+	translate := false
+	for _, record := range records {
+		var metadata map[string]string
+		if record.Metadata != "" {
+			// Metadata is validated on write; a record that fails to
+			// decode is listed without a language
+			_ = json.Unmarshal([]byte(record.Metadata), &metadata)
+		}
+		tag := ""
+		if detected := recordLanguage(metadata); needsTranslation(detected, language) {
+			tag = " (Language: " + detected + ")"
+			translate = true
+		}
+		fmt.Fprintf(&b, "- [%s] %s: %s (Date: %s)%s\n",
+			record.RecordType, record.Title, record.Description, record.CreatedAt.Format("2006-01-02"), tag)
+	}
+	if translate {
+		fmt.Fprintf(&b, "\nSome records are in another language. Translate them as needed and answer in %q.\n", language)
+	}
+	return b.String()
+}
+
+// ParseSummaryResponse reads a model's JSON reply to SummaryPrompt into a
+// summary with the requested sections, in the requested order. Sections
+// the model left out, left blank or answered with something other than
+// text or a list are empty and listed in IncompleteSections, so the rest of
+// the summary is still usable; keys that were not requested are ignored.
+// A list section answered with a single string becomes a one-item list.
+// Only a reply that is not a JSON object at all fails.
+func ParseSummaryResponse(reply string, sections []string) (*HealthSummary, error) {
+	// Models often wrap JSON in a code fence or a sentence
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("summary response is not JSON")
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse summary response: %w", err)
+	}
+
+	parsed := make([]SummarySection, 0, len(sections))
+	var incomplete []string
+	for _, name := range sections {
+		section := SummarySection{Name: name}
+		if value, ok := raw[name]; ok {
+			var text string
+			var items []string
+			switch {
+			case json.Unmarshal(value, &items) == nil:
+				section.Items = items
+			case json.Unmarshal(value, &text) == nil:
+				if summarySectionFormats[name].list {
+					section.Items = []string{text}
+				} else {
+					section.Text = text
+				}
+			}
+			if !summarySectionFormats[name].list && section.Items != nil {
+				section.Text, section.Items = strings.Join(section.Items, " "), nil
+			}
+		}
+		if strings.TrimSpace(section.Text) == "" && len(section.Items) == 0 {
+			section.Text, section.Items = "", nil
+			incomplete = append(incomplete, name)
+		}
+		parsed = append(parsed, section)
+	}
+	summary := newHealthSummary(parsed)
+	summary.IncompleteSections = incomplete
+	return summary, nil
+}
+
+// newHealthSummary builds a summary from its sections, copying the default
+// sections into the Summary, KeyFindings and Recommendations fields older
+// clients read
+func newHealthSummary(sections []SummarySection) *HealthSummary {
+	hs := &HealthSummary{Sections: sections}
+	hs.Summary = hs.Section(SummarySectionSummary).Text
+	hs.KeyFindings = hs.Section(SummarySectionFindings).Items
+	hs.Recommendations = hs.Section(SummarySectionRecommendations).Text
+	return hs
+}
+
+// capSummary bounds the findings and recommendations of a summary, so a
+// model that over-produces cannot grow it without limit. Findings past
+// maxFindings are replaced by one item saying how many were left out, and
+// the recommendations are cut to maxRecommendations bytes with the
+// truncation marker. Zero disables either cap.
+func capSummary(hs *HealthSummary, maxFindings, maxRecommendations int) {
+	for i := range hs.Sections {
+		switch hs.Sections[i].Name {
+		case SummarySectionFindings:
+			hs.Sections[i].Items = capFindings(hs.Sections[i].Items, maxFindings)
+		case SummarySectionRecommendations:
+			hs.Sections[i].Text = truncateStored(hs.Sections[i].Text, maxRecommendations)
+		}
+	}
+	hs.KeyFindings = capFindings(hs.KeyFindings, maxFindings)
+	hs.Recommendations = truncateStored(hs.Recommendations, maxRecommendations)
+}
+
+// capFindings keeps at most max findings, the last of them a marker
+// counting the ones dropped
+func capFindings(findings []string, max int) []string {
+	if max <= 0 || len(findings) <= max {
+		return findings
+	}
+	kept := append([]string(nil), findings[:max-1]...)
+	return append(kept, fmt.Sprintf("… [%d more findings truncated]", len(findings)-max+1))
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSummarySections(t *testing.T) {
+	tests := []struct {
+		name     string
+		sections []string
+		want     []string
+		wantErr  error
+	}{
+		{"defaults", nil, DefaultSummarySections, nil},
+		{"caller's order", []string{"risks", "summary", "medications"}, []string{"risks", "summary", "medications"}, nil},
+		{"case and spaces", []string{" Trends ", "FINDINGS"}, []string{"trends", "findings"}, nil},
+		{"duplicates", []string{"risks", "risks", "Risks"}, []string{"risks"}, nil},
+		{"unknown", []string{"summary", "horoscope"}, nil, ErrInvalidArgument},
+		{"blank", []string{""}, nil, ErrInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeSummarySections(tt.sections)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("normalizeSummarySections() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("normalizeSummarySections() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummaryPromptListsRequestedSections(t *testing.T) {
+	prompt := SummaryPrompt(14, []string{SummarySectionMedications, SummarySectionSummary})
+
+	for _, want := range []string{
+		"last 14 days",
+		"1. medications: " + summarySectionFormats[SummarySectionMedications].instruction,
+		"2. summary: " + summarySectionFormats[SummarySectionSummary].instruction,
+		`"medications": ["...", "..."],`,
+		`"summary": "..."` + "\n}",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt does not contain %q:\n%s", want, prompt)
+		}
+	}
+	for _, unwanted := range []string{"findings", "recommendations", "risks", "trends"} {
+		if strings.Contains(prompt, unwanted) {
+			t.Errorf("prompt asks for unrequested section %s:\n%s", unwanted, prompt)
+		}
+	}
+}
+
+func TestParseSummaryResponse(t *testing.T) {
+	sections := []string{SummarySectionSummary, SummarySectionFindings, SummarySectionMedications, SummarySectionRisks}
+	tests := []struct {
+		name           string
+		reply          string
+		wantSummary    string
+		wantFindings   []string
+		wantMeds       []string
+		wantIncomplete []string
+		wantErr        bool
+	}{
+		{
+			name:         "every section",
+			reply:        `{"summary":"Stable.","findings":["a","b"],"medications":["Metformin 500mg twice daily"],"risks":["low iron"]}`,
+			wantSummary:  "Stable.",
+			wantFindings: []string{"a", "b"},
+			wantMeds:     []string{"Metformin 500mg twice daily"},
+		},
+		{
+			name:         "in a code fence",
+			reply:        "Here you go:\n```json\n{\"summary\":\"Stable.\",\"findings\":[\"a\"],\"medications\":[],\"risks\":[\"x\"]}\n```",
+			wantSummary:  "Stable.",
+			wantFindings: []string{"a"},
+			// An empty list counts as missing
+			wantIncomplete: []string{SummarySectionMedications},
+		},
+		{
+			name:         "list as a string and text as a list",
+			reply:        `{"summary":["Stable.","Improving."],"findings":"one finding","medications":["m"],"risks":["r"]}`,
+			wantSummary:  "Stable. Improving.",
+			wantFindings: []string{"one finding"},
+			wantMeds:     []string{"m"},
+		},
+		{
+			name:           "missing, blank and malformed sections",
+			reply:          `{"summary":"  ","findings":[1,2],"medications":["m"],"extra":"ignored"}`,
+			wantMeds:       []string{"m"},
+			wantIncomplete: []string{SummarySectionSummary, SummarySectionFindings, SummarySectionRisks},
+		},
+		{name: "not JSON", reply: "I cannot help with that.", wantErr: true},
+		{name: "broken JSON", reply: `{"summary": "Stable."`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSummaryResponse(tt.reply, sections)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSummaryResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Summary != tt.wantSummary {
+				t.Errorf("Summary = %q, want %q", got.Summary, tt.wantSummary)
+			}
+			if !slices.Equal(got.KeyFindings, tt.wantFindings) {
+				t.Errorf("KeyFindings = %q, want %q", got.KeyFindings, tt.wantFindings)
+			}
+			if meds := got.Section(SummarySectionMedications).Items; !slices.Equal(meds, tt.wantMeds) {
+				t.Errorf("medications = %q, want %q", meds, tt.wantMeds)
+			}
+			if !slices.Equal(got.IncompleteSections, tt.wantIncomplete) {
+				t.Errorf("IncompleteSections = %v, want %v", got.IncompleteSections, tt.wantIncomplete)
+			}
+			names := make([]string, len(got.Sections))
+			for i, section := range got.Sections {
+				names[i] = section.Name
+			}
+			if !slices.Equal(names, sections) {
+				t.Errorf("sections = %v, want the requested %v in order", names, sections)
+			}
+		})
+	}
+}
+
+func TestSummarizeHealthPassesRequestedSections(t *testing.T) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	as := newTestAIService(t, db, nil)
+	provider := &fakeProvider{}
+	as.provider = provider
+	ctx := context.Background()
+
+	if _, err := as.SummarizeHealth(ctx, "user-1", 30, nil, nil); err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if !slices.Equal(provider.sections, DefaultSummarySections) {
+		t.Errorf("default request sent sections %v, want %v", provider.sections, DefaultSummarySections)
+	}
+
+	if _, err := as.SummarizeHealth(ctx, "user-1", 30, []string{"Risks", "trends", "risks"}, nil); err != nil {
+		t.Fatalf("SummarizeHealth: %v", err)
+	}
+	if want := []string{SummarySectionRisks, SummarySectionTrends}; !slices.Equal(provider.sections, want) {
+		t.Errorf("provider asked for %v, want %v", provider.sections, want)
+	}
+
+	provider.sections = nil
+	if _, err := as.SummarizeHealth(ctx, "user-1", 30, []string{"horoscope"}, nil); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("unknown section: error = %v, want %v", err, ErrInvalidArgument)
+	}
+	if provider.sections != nil {
+		t.Error("an invalid request reached the provider")
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/clarity/backend/permissions"
+	"github.com/clarity/backend/tenancy"
+	"github.com/google/uuid"
+)
+
+// accessTokenTTL is how long an access token is accepted
+const accessTokenTTL = 24 * time.Hour
+
+// Token types, carried in the "type" claim so a refresh token cannot be
+// used as an access token or the other way round
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// jwtHeader is the encoded header of every token we sign. Only HS256 is
+// issued or accepted.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims are the claims of a token signed by generateToken. ID makes each
+// token unique, so two issued in the same second never collide. Tenant is
+// set in multi-tenant deployments, where a user ID only means something
+// within its tenant. Scopes limit what the token may be used for; a
+// refresh token's scopes pass to the access tokens it is exchanged for.
+// Session is the sign-in session the token was issued in, so using its
+// access tokens keeps the session active.
+type Claims struct {
+	Subject   string   `json:"sub"` // user ID
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	Type      string   `json:"type"`
+	ID        string   `json:"jti"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Session   string   `json:"sid,omitempty"`
+	Scopes    []string `json:"scopes"`
+}
+
+// GrantedScopes returns the scopes the token carries. Tokens signed before
+// scopes were added have no scopes claim at all and were issued by signing
+// in, so they get the sign-in scopes; a token issued with no scopes has an
+// empty claim and gets none.
+func (c *Claims) GrantedScopes() []string {
+	if c.Scopes == nil {
+		return permissions.LoginScopes
+	}
+	return c.Scopes
+}
+
+// generateToken returns a JWT for userID of the given type and scopes,
+// issued in sessionID, for the tenant ctx acts for, signed with the
+// configured secret using HS256
+func (as *AuthService) generateToken(ctx context.Context, userID, sessionID, tokenType string, scopes []string, ttl time.Duration) (string, error) {
+	now := as.now()
+	tenant, _ := tenancy.FromContext(ctx)
+	if scopes == nil {
+		// Encoded as an empty claim, never read back as a legacy token
+		scopes = []string{}
+	}
+	payload, err := json.Marshal(Claims{
+		Subject:   userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Type:      tokenType,
+		ID:        uuid.New().String(),
+		Tenant:    tenant,
+		Session:   sessionID,
+		Scopes:    scopes,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token: %w", err)
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + as.signToken(signingInput), nil
+}
+
+// ValidateToken checks a token's signature and expiry, that it has not
+// been revoked by Logout and that the session it was issued in has not
+// ended, and returns its claims. Ending a session, for a reused refresh
+// token or a logout everywhere, so also stops the access tokens it issued.
+// Callers check the claims' Type is the one they expect.
+func (as *AuthService) ValidateToken(ctx context.Context, token string) (*Claims, error) {
+	claims, err := as.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked int64
+	if err := as.db.WithContext(ctx).Model(&models.RevokedToken{}).Where("token_id = ?", claims.ID).Count(&revoked).Error; err != nil {
+		return nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if revoked > 0 {
+		return nil, fmt.Errorf("%w: token has been revoked", ErrUnauthenticated)
+	}
+
+	// Tokens from before sessions were named in them have none to check
+	if claims.Session != "" {
+		var ended int64
+		if err := as.db.WithContext(ctx).Model(&models.Session{}).Where("id = ? AND revoked_at IS NOT NULL", claims.Session).Count(&ended).Error; err != nil {
+			return nil, fmt.Errorf("failed to check session: %w", err)
+		}
+		if ended > 0 {
+			return nil, fmt.Errorf("%w: session has been revoked, sign in again", ErrUnauthenticated)
+		}
+	}
+	return claims, nil
+}
+
+// ParseToken checks a token's signature and expiry and returns its claims,
+// without the revocation check ValidateToken adds. It is for reading the
+// tenant of a token before there is a database to check it in.
+func (as *AuthService) ParseToken(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+
+	// Checked before the signature so tokens claiming "alg": "none" or a
+	// key type we do not use are never considered
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported token algorithm", ErrUnauthenticated)
+	}
+	expected := as.signToken(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, fmt.Errorf("%w: invalid token signature", ErrUnauthenticated)
+	}
+
+	var claims Claims
+	if err := decodeTokenPart(parts[1], &claims); err != nil || claims.Subject == "" || claims.Type == "" {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	if !as.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+	return &claims, nil
+}
+
+func (as *AuthService) signToken(signingInput string) string {
+	mac := hmac.New(sha256.New, []byte(as.config.JWTSecret))
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+func newTokenTestAuth(t *testing.T, secret string) (*AuthService, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)}
+	return newTestAuthService(newTestDB(t), &config.AuthConfig{JWTSecret: secret}, clock), clock
+}
+
+func TestValidateToken(t *testing.T) {
+	as, _ := newTokenTestAuth(t, "token-secret")
+	ctx := context.Background()
+
+	for _, tokenType := range []string{TokenTypeAccess, TokenTypeRefresh} {
+		token, err := as.generateToken(ctx, "user-1", "", tokenType, nil, time.Hour)
+		if err != nil {
+			t.Fatalf("generateToken: %v", err)
+		}
+		claims, err := as.ValidateToken(ctx, token)
+		if err != nil {
+			t.Fatalf("ValidateToken(%s token): %v", tokenType, err)
+		}
+		if claims.Subject != "user-1" || claims.Type != tokenType || claims.ID == "" {
+			t.Errorf("%s token claims = %+v", tokenType, claims)
+		}
+		if claims.ExpiresAt-claims.IssuedAt != int64(time.Hour/time.Second) || claims.IssuedAt != as.now().Unix() {
+			t.Errorf("%s token issued %d, expires %d; want an hour from now", tokenType, claims.IssuedAt, claims.ExpiresAt)
+		}
+	}
+
+	a, _ := as.generateToken(ctx, "user-1", "", TokenTypeAccess, nil, time.Hour)
+	b, _ := as.generateToken(ctx, "user-1", "", TokenTypeAccess, nil, time.Hour)
+	if a == b {
+		t.Error("two tokens issued in the same second are identical")
+	}
+}
+
+func TestValidateTokenRejectsExpiredTokens(t *testing.T) {
+	as, clock := newTokenTestAuth(t, "token-secret")
+	ctx := context.Background()
+	token, err := as.generateToken(ctx, "user-1", "", TokenTypeAccess, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("generateToken: %v", err)
+	}
+
+	clock.Advance(time.Hour - time.Second)
+	if _, err := as.ValidateToken(ctx, token); err != nil {
+		t.Fatalf("token a second before expiry: %v", err)
+	}
+	clock.Advance(time.Second)
+	if _, err := as.ValidateToken(ctx, token); !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("token at expiry: error = %v, want an expired ErrUnauthenticated", err)
+	}
+}
+
+// reencode returns token with its part i replaced by the encoding of raw,
+// keeping the other parts, signature included
+func reencode(token string, i int, raw string) string {
+	parts := strings.Split(token, ".")
+	parts[i] = base64.RawURLEncoding.EncodeToString([]byte(raw))
+	return strings.Join(parts, ".")
+}
+
+func TestValidateTokenRejectsTamperedTokens(t *testing.T) {
+	as, _ := newTokenTestAuth(t, "token-secret")
+	ctx := context.Background()
+	token, err := as.generateToken(ctx, "user-1", "", TokenTypeRefresh, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("generateToken: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+
+	// The last signature character only partly carries the MAC, so flip
+	// the first
+	flipped := []byte(parts[2])
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	tests := map[string]string{
+		"signature changed":       parts[0] + "." + parts[1] + "." + string(flipped),
+		"signature removed":       parts[0] + "." + parts[1] + ".",
+		"subject changed":         reencode(token, 1, strings.Replace(string(payload), `"sub":"user-1"`, `"sub":"user-2"`, 1)),
+		"type changed":            reencode(token, 1, strings.Replace(string(payload), `"type":"refresh"`, `"type":"access"`, 1)),
+		"expiry extended":         reencode(token, 1, strings.Replace(string(payload), `"exp":`, `"exp":9`, 1)),
+		"algorithm none":          reencode(token, 0, `{"alg":"none","typ":"JWT"}`),
+		"algorithm none unsigned": noneHeader + "." + parts[1] + ".",
+		"other algorithm":         reencode(token, 0, `{"alg":"HS512","typ":"JWT"}`),
+		"two parts":               parts[0] + "." + parts[1],
+		"empty":                   "",
+	}
+	for name, tampered := range tests {
+		if tampered == token {
+			t.Fatalf("%s: token unchanged", name)
+		}
+		if _, err := as.ValidateToken(ctx, tampered); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: error = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+}
+
+func TestTokenTypesAreNotInterchangeable(t *testing.T) {
+	as, _ := newTokenTestAuth(t, "token-secret")
+	ctx := context.Background()
+	userID, refreshToken := signIn(t, as, "types@example.com", "phone-1")
+	access, err := as.generateToken(ctx, userID, "", TokenTypeAccess, nil, accessTokenTTL)
+	if err != nil {
+		t.Fatalf("generateToken: %v", err)
+	}
+
+	// An access token is not exchanged for new tokens...
+	if _, _, err := as.RefreshToken(ctx, access, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("refreshing with an access token: error = %v, want ErrUnauthenticated", err)
+	}
+	// ...nor does it end a session
+	if err := as.Logout(ctx, access, "", false); err == nil {
+		t.Error("Logout accepted an access token as the refresh token")
+	}
+	// The middleware refuses a refresh token as an access token; the
+	// claims it checks name the type each token was issued as
+	for token, want := range map[string]string{access: TokenTypeAccess, refreshToken: TokenTypeRefresh} {
+		claims, err := as.ValidateToken(ctx, token)
+		if err != nil || claims.Type != want {
+			t.Errorf("ValidateToken = %+v, %v; want a %s token", claims, err, want)
+		}
+	}
+	if _, _, err := as.RefreshToken(ctx, refreshToken, "phone-1"); err != nil {
+		t.Errorf("refreshing with the refresh token: %v", err)
+	}
+}
+
+func TestValidateTokenRejectsOtherSecrets(t *testing.T) {
+	ours, _ := newTokenTestAuth(t, "token-secret")
+	theirs, _ := newTokenTestAuth(t, "another-secret")
+	ctx := context.Background()
+
+	for _, tokenType := range []string{TokenTypeAccess, TokenTypeRefresh} {
+		token, err := theirs.generateToken(ctx, "user-1", "", tokenType, nil, time.Hour)
+		if err != nil {
+			t.Fatalf("generateToken: %v", err)
+		}
+		if _, err := ours.ValidateToken(ctx, token); !errors.Is(err, ErrUnauthenticated) || !strings.Contains(err.Error(), "signature") {
+			t.Errorf("%s token signed with another secret: error = %v, want an invalid signature", tokenType, err)
+		}
+		if _, err := theirs.ValidateToken(ctx, token); err != nil {
+			t.Errorf("%s token with the secret it was signed with: %v", tokenType, err)
+		}
+	}
+
+	// A refresh token from another deployment is not exchanged here
+	_, refreshToken := signIn(t, theirs, "secret@example.com", "phone-1")
+	if _, _, err := ours.RefreshToken(ctx, refreshToken, "phone-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("refreshing with another secret's token: error = %v, want ErrUnauthenticated", err)
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/clarity/backend/models"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// totpPeriod is the lifetime of one authenticator code, the default every
+// authenticator app assumes
+const totpPeriod = 30 * time.Second
+
+var totpOpts = totp.ValidateOpts{
+	Period:    uint(totpPeriod / time.Second),
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// EnrollTOTP generates a new authenticator app secret for the user and
+// returns it with its otpauth:// provisioning URI, usually shown as a QR
+// code. The secret is pending until ConfirmTOTP; an enabled secret keeps
+// working until then.
+func (as *AuthService) EnrollTOTP(ctx context.Context, userID string) (string, string, error) {
+	var user models.User
+	if err := as.db.WithContext(ctx).Select("id, email").Where("id = ?", userID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", "", ErrNotFound
+		}
+		return "", "", fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      as.config.TOTPIssuer,
+		AccountName: user.Email,
+		Period:      totpOpts.Period,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	now := as.now()
+	credential := models.TOTPCredential{UserID: userID, CreatedAt: now}
+	err = as.db.WithContext(ctx).Where(models.TOTPCredential{UserID: userID}).
+		Assign(map[string]interface{}{"pending_secret": key.Secret(), "updated_at": now}).
+		FirstOrCreate(&credential).Error
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return key.Secret(), key.URL(), nil
+}
+
+// ConfirmTOTP checks a code generated from the pending secret and, if it
+// matches, makes that secret the one VerifyOTP accepts
+func (as *AuthService) ConfirmTOTP(ctx context.Context, userID, code string) error {
+	var credential models.TOTPCredential
+	if err := as.db.WithContext(ctx).Where("user_id = ? AND pending_secret <> ''", userID).
+		First(&credential).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return fmt.Errorf("%w: TOTP enrollment", ErrNotFound)
+		}
+		return fmt.Errorf("failed to fetch TOTP enrollment: %w", err)
+	}
+
+	step, ok := as.matchTOTP(credential.PendingSecret, code)
+	if !ok {
+		return fmt.Errorf("%w: code does not match", ErrInvalidArgument)
+	}
+
+	now := as.now()
+	if err := as.db.WithContext(ctx).Model(&credential).Updates(map[string]interface{}{
+		"secret":         credential.PendingSecret,
+		"pending_secret": "",
+		"last_step":      step,
+		"enabled_at":     now,
+		"updated_at":     now,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	return nil
+}
+
+// checkTOTP reports whether code is a valid authenticator code for the
+// user with email. Users without TOTP, wrong codes and codes already used
+// all report false. An accepted code's time step is recorded so it cannot
+// be used again.
+func (as *AuthService) checkTOTP(ctx context.Context, email, code string) (bool, error) {
+	var credential models.TOTPCredential
+	err := as.db.WithContext(ctx).
+		Joins("JOIN users ON users.id = totp_credentials.user_id").
+		Where("users.email = ? AND totp_credentials.secret <> ''", email).
+		Limit(1).Find(&credential).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch TOTP credential: %w", err)
+	}
+	if credential.UserID == "" {
+		return false, nil
+	}
+
+	step, ok := as.matchTOTP(credential.Secret, code)
+	if !ok || step <= credential.LastStep {
+		return false, nil
+	}
+
+	// Conditional so two requests racing with the same code accept one
+	result := as.db.WithContext(ctx).Model(&models.TOTPCredential{}).
+		Where("user_id = ? AND last_step < ?", credential.UserID, step).
+		UpdateColumn("last_step", step)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to record TOTP use: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// matchTOTP checks code against secret at the current time step and up to
+// the configured skew either side, to allow for drift between the server's
+// clock and the phone's. It returns the matching step.
+func (as *AuthService) matchTOTP(secret, code string) (int64, bool) {
+	if len(code) != int(totpOpts.Digits) {
+		return 0, false
+	}
+
+	now := as.now()
+	for offset := -as.config.TOTPSkew; offset <= as.config.TOTPSkew; offset++ {
+		at := now.Add(time.Duration(offset) * totpPeriod)
+		expected, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / int64(totpPeriod/time.Second), true
+		}
+	}
+	return 0, false
+}
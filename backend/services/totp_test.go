@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// enrollTOTP enrolls userID and confirms the enrollment, returning the
+// secret its authenticator app holds
+func enrollTOTP(t *testing.T, as *AuthService, userID string) string {
+	t.Helper()
+	secret, _, err := as.EnrollTOTP(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	if err := as.ConfirmTOTP(context.Background(), userID, totpCode(t, secret, as.now())); err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	return secret
+}
+
+// totpCode is the code an authenticator app shows for secret at at
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+	if err != nil {
+		t.Fatalf("generate TOTP code: %v", err)
+	}
+	return code
+}
+
+func newTOTPTestAuth(t *testing.T, skew int) (*AuthService, *gorm.DB, *testClock) {
+	db := newTestDB(t)
+	createUser(t, db, "user-1")
+	// 15 seconds into a time step, so offsets of whole steps stay clear
+	// of step boundaries
+	clock := &testClock{now: time.Date(2026, 10, 16, 12, 0, 15, 0, time.UTC)}
+	return newTestAuthService(db, &config.AuthConfig{TOTPIssuer: "Clarity", TOTPSkew: skew}, clock), db, clock
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	as, _, clock := newTOTPTestAuth(t, 1)
+	ctx := context.Background()
+
+	secret, uri, err := as.EnrollTOTP(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("provisioning URI %q: %v", uri, err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Query().Get("secret") != secret || parsed.Query().Get("issuer") != "Clarity" {
+		t.Errorf("provisioning URI = %s", uri)
+	}
+	if parsed.Path != "/Clarity:user-1@example.com" {
+		t.Errorf("provisioning URI account = %s, want Clarity:user-1@example.com", parsed.Path)
+	}
+
+	// A pending secret does not sign in
+	if _, _, _, err := as.VerifyOTP(ctx, "user-1@example.com", totpCode(t, secret, clock.Now()), "device-1"); err == nil {
+		t.Error("VerifyOTP accepted a code from an unconfirmed secret")
+	}
+
+	wrong := "000000"
+	if totpCode(t, secret, clock.Now()) == wrong {
+		wrong = "111111"
+	}
+	if err := as.ConfirmTOTP(ctx, "user-1", wrong); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("ConfirmTOTP with a wrong code: error = %v, want %v", err, ErrInvalidArgument)
+	}
+	if err := as.ConfirmTOTP(ctx, "user-1", totpCode(t, secret, clock.Now())); err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	if err := as.ConfirmTOTP(ctx, "user-1", totpCode(t, secret, clock.Now())); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second ConfirmTOTP: error = %v, want %v", err, ErrNotFound)
+	}
+	if _, _, err := as.EnrollTOTP(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("EnrollTOTP for a missing user: error = %v, want %v", err, ErrNotFound)
+	}
+
+	clock.Advance(totpPeriod)
+	user, accessToken, _, err := as.VerifyOTP(ctx, "user-1@example.com", totpCode(t, secret, clock.Now()), "device-1")
+	if err != nil || user.ID != "user-1" || accessToken == "" {
+		t.Fatalf("VerifyOTP with an authenticator code = %v, %v", user, err)
+	}
+}
+
+func TestTOTPDriftWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		skew   int
+		drift  time.Duration // of the phone's clock from the server's
+		accept bool
+	}{
+		{"in step", 1, 0, true},
+		{"phone a step behind", 1, -totpPeriod, true},
+		{"phone a step ahead", 1, totpPeriod, true},
+		{"phone two steps behind", 1, -2 * totpPeriod, false},
+		{"phone two steps ahead", 1, 2 * totpPeriod, false},
+		{"two steps with a wider window", 2, 2 * totpPeriod, true},
+		{"a step ahead with no window", 0, totpPeriod, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, _, clock := newTOTPTestAuth(t, tt.skew)
+			secret := enrollTOTP(t, as, "user-1")
+			// Past the step the enrollment code used
+			clock.Advance(10 * totpPeriod)
+
+			code := totpCode(t, secret, clock.Now().Add(tt.drift))
+			_, _, _, err := as.VerifyOTP(context.Background(), "user-1@example.com", code, "device-1")
+			if tt.accept && err != nil {
+				t.Errorf("code from a phone %s off rejected: %v", tt.drift, err)
+			}
+			if !tt.accept && err == nil {
+				t.Errorf("code from a phone %s off accepted", tt.drift)
+			}
+		})
+	}
+}
+
+func TestTOTPCodeIsSingleUse(t *testing.T) {
+	as, _, clock := newTOTPTestAuth(t, 1)
+	secret := enrollTOTP(t, as, "user-1")
+	ctx := context.Background()
+	clock.Advance(10 * totpPeriod)
+
+	code := totpCode(t, secret, clock.Now())
+	if _, _, _, err := as.VerifyOTP(ctx, "user-1@example.com", code, "device-1"); err != nil {
+		t.Fatalf("VerifyOTP: %v", err)
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "user-1@example.com", code, "device-2"); err == nil {
+		t.Error("an authenticator code was accepted twice")
+	}
+	// Nor is an earlier step in the window once a later one was used
+	if _, _, _, err := as.VerifyOTP(ctx, "user-1@example.com", totpCode(t, secret, clock.Now().Add(-totpPeriod)), "device-2"); err == nil {
+		t.Error("a code older than the last one used was accepted")
+	}
+
+	clock.Advance(totpPeriod)
+	if _, _, _, err := as.VerifyOTP(ctx, "user-1@example.com", totpCode(t, secret, clock.Now()), "device-2"); err != nil {
+		t.Errorf("the next step's code: %v", err)
+	}
+}
+
+func TestEmailCodeStillWorksWithTOTP(t *testing.T) {
+	as, db, _ := newTOTPTestAuth(t, 1)
+	enrollTOTP(t, as, "user-1")
+	ctx := context.Background()
+
+	reference, _, err := as.SendOTP(ctx, "user-1@example.com")
+	if err != nil {
+		t.Fatalf("SendOTP: %v", err)
+	}
+	if _, _, _, err := as.VerifyOTP(ctx, "user-1@example.com", sentOTP(t, db, reference), "device-1"); err != nil {
+		t.Errorf("VerifyOTP with an emailed code: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clarity/backend/config"
+)
+
+// Transcriber converts recorded speech into text for the doctor chat.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio []byte, format string) (string, error)
+}
+
+// sttKeyEnv is the setting that holds the speech-to-text API key
+const sttKeyEnv = "STT_API_KEY"
+
+// NewTranscriber returns the speech-to-text provider selected in config.
+// A provider selected without an API key is reported at startup, and every
+// transcription then fails with ErrProviderNotConfigured rather than
+// reaching the provider.
+func NewTranscriber(cfg *config.AIConfig) Transcriber {
+	switch cfg.STTProvider {
+	case "sandbox":
+		return &sandboxTranscriber{}
+	case "openai":
+		apiKey := strings.TrimSpace(cfg.STTAPIKey)
+		if apiKey == "" {
+			err := &ProviderConfigError{Provider: "openai transcriber", EnvVar: sttKeyEnv, Err: ErrProviderNotConfigured}
+			log.Printf("Warning: %v", err)
+			return unconfiguredTranscriber{err: err}
+		}
+		return &openAITranscriber{
+			apiKey: apiKey,
+			client: &http.Client{Timeout: 60 * time.Second},
+		}
+	}
+	return &mockTranscriber{}
+}
+
+// audioSignatures maps supported audio formats to a check on their magic bytes.
+var audioSignatures = map[string]func([]byte) bool{
+	"wav": func(b []byte) bool {
+		return len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WAVE"
+	},
+	"mp3": func(b []byte) bool {
+		return len(b) >= 3 && (string(b[0:3]) == "ID3" || (b[0] == 0xFF && b[1]&0xE0 == 0xE0))
+	},
+	"m4a": func(b []byte) bool {
+		return len(b) >= 8 && string(b[4:8]) == "ftyp"
+	},
+	"ogg": func(b []byte) bool {
+		return len(b) >= 4 && string(b[0:4]) == "OggS"
+	},
+	"webm": func(b []byte) bool {
+		return len(b) >= 4 && bytes.Equal(b[0:4], []byte{0x1A, 0x45, 0xDF, 0xA3})
+	},
+	"flac": func(b []byte) bool {
+		return len(b) >= 4 && string(b[0:4]) == "fLaC"
+	},
+}
+
+// validateAudio checks the declared format is supported, matches the
+// payload's magic bytes, and that the payload fits within maxSize.
+func validateAudio(audio []byte, format string, maxSize int) error {
+	if len(audio) == 0 {
+		return fmt.Errorf("%w: empty audio", ErrInvalidAudio)
+	}
+	if maxSize > 0 && len(audio) > maxSize {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrAudioTooLarge, len(audio), maxSize)
+	}
+	matches, ok := audioSignatures[format]
+	if !ok {
+		return fmt.Errorf("%w: unsupported format %q", ErrInvalidAudio, format)
+	}
+	if !matches(audio) {
+		return fmt.Errorf("%w: content is not %s audio", ErrInvalidAudio, format)
+	}
+	return nil
+}
+
+type mockTranscriber struct{}
+
+func (mt *mockTranscriber) Transcribe(ctx context.Context, audio []byte, format string) (string, error) {
+	return fmt.Sprintf("(transcribed %d bytes of %s audio)", len(audio), format), nil
+}
+
+// unconfiguredTranscriber stands in for a provider that has no credentials
+type unconfiguredTranscriber struct {
+	err error
+}
+
+func (ut unconfiguredTranscriber) Transcribe(ctx context.Context, audio []byte, format string) (string, error) {
+	return "", ut.err
+}
+
+// openAITranscriber calls the OpenAI audio transcription (Whisper) endpoint.
+type openAITranscriber struct {
+	apiKey string
+	client *http.Client
+}
+
+func (ot *openAITranscriber) Transcribe(ctx context.Context, audio []byte, format string) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("model", "whisper-1"); err != nil {
+		return "", err
+	}
+	part, err := writer.CreateFormFile("file", "audio."+format)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(audio); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/audio/transcriptions", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+ot.apiKey)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := ot.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("transcription request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return "", &ProviderConfigError{Provider: "openai transcriber", EnvVar: sttKeyEnv, Err: ErrProviderAuth}
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("transcription failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription: %w", err)
+	}
+	return result.Text, nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/clarity/backend/config"
+)
+
+// fakeTranscriber returns a fixed transcript and records what it was given
+type fakeTranscriber struct {
+	transcript string
+	err        error
+
+	calls  int
+	audio  []byte
+	format string
+}
+
+func (ft *fakeTranscriber) Transcribe(ctx context.Context, audio []byte, format string) (string, error) {
+	ft.calls++
+	ft.audio, ft.format = audio, format
+	return ft.transcript, ft.err
+}
+
+var testWAV = []byte("RIFF\x24\x00\x00\x00WAVEfmt ")
+
+func TestValidateAudio(t *testing.T) {
+	tests := []struct {
+		name    string
+		audio   []byte
+		format  string
+		maxSize int
+		want    error
+	}{
+		{"wav", testWAV, "wav", 0, nil},
+		{"mp3 with ID3 tag", []byte("ID3\x03\x00"), "mp3", 0, nil},
+		{"mp3 frame sync", []byte{0xFF, 0xFB, 0x90, 0x00}, "mp3", 0, nil},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A "), "m4a", 0, nil},
+		{"ogg", []byte("OggS\x00\x02"), "ogg", 0, nil},
+		{"webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x01}, "webm", 0, nil},
+		{"flac", []byte("fLaC\x00"), "flac", 0, nil},
+		{"empty", nil, "wav", 0, ErrInvalidAudio},
+		{"unsupported format", testWAV, "aiff", 0, ErrInvalidAudio},
+		{"content does not match format", testWAV, "ogg", 0, ErrInvalidAudio},
+		{"over the size limit", testWAV, "wav", len(testWAV) - 1, ErrAudioTooLarge},
+		{"at the size limit", testWAV, "wav", len(testWAV), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAudio(tt.audio, tt.format, tt.maxSize)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("validateAudio() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVoiceChatFeedsTranscriptIntoDoctorChat(t *testing.T) {
+	db := newTestDB(t)
+	as := newTestAIService(t, db, &config.AIConfig{MaxAudioSize: 1024})
+	transcriber := &fakeTranscriber{transcript: "my head has hurt since Monday"}
+	as.transcriber = transcriber
+
+	transcript, response, degraded, err := as.VoiceChat(context.Background(), "user-1", "conv-1", testWAV, "wav")
+	if err != nil {
+		t.Fatalf("VoiceChat: %v", err)
+	}
+	if transcriber.calls != 1 || transcriber.format != "wav" || string(transcriber.audio) != string(testWAV) {
+		t.Fatalf("transcriber got %d calls with format %q", transcriber.calls, transcriber.format)
+	}
+	if transcript != transcriber.transcript {
+		t.Errorf("transcript = %q, want %q", transcript, transcriber.transcript)
+	}
+	if degraded {
+		t.Error("reply is degraded")
+	}
+	if !strings.Contains(response, transcriber.transcript) {
+		t.Errorf("response %q does not answer the transcript", response)
+	}
+
+	turns := conversationTurns(t, db, "conv-1")
+	if len(turns) != 1 {
+		t.Fatalf("stored %d turns, want 1", len(turns))
+	}
+	if turns[0].UserID != "user-1" || turns[0].Message != transcriber.transcript || turns[0].Response != response {
+		t.Errorf("stored turn = %+v", turns[0])
+	}
+}
+
+func TestVoiceChatRejectsAudioBeforeTranscribing(t *testing.T) {
+	tests := []struct {
+		name   string
+		audio  []byte
+		format string
+		want   error
+	}{
+		{"too large", append(append([]byte{}, testWAV...), make([]byte, 64)...), "wav", ErrAudioTooLarge},
+		{"wrong format", testWAV, "mp3", ErrInvalidAudio},
+		{"unsupported format", testWAV, "midi", ErrInvalidAudio},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			as := newTestAIService(t, db, &config.AIConfig{MaxAudioSize: 32})
+			transcriber := &fakeTranscriber{transcript: "hello"}
+			as.transcriber = transcriber
+
+			_, _, _, err := as.VoiceChat(context.Background(), "user-1", "conv-1", tt.audio, tt.format)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VoiceChat() error = %v, want %v", err, tt.want)
+			}
+			if transcriber.calls != 0 {
+				t.Error("rejected audio reached the transcriber")
+			}
+			if turns := conversationTurns(t, db, "conv-1"); len(turns) != 0 {
+				t.Errorf("stored %d turns for rejected audio", len(turns))
+			}
+		})
+	}
+}
+
+func TestVoiceChatTranscriptionFailures(t *testing.T) {
+	transcribeErr := errors.New("provider down")
+	tests := []struct {
+		name        string
+		transcriber *fakeTranscriber
+		want        error
+	}{
+		{"no speech", &fakeTranscriber{transcript: ""}, ErrInvalidAudio},
+		{"transcriber error", &fakeTranscriber{err: transcribeErr}, transcribeErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			as := newTestAIService(t, db, nil)
+			as.transcriber = tt.transcriber
+
+			_, _, _, err := as.VoiceChat(context.Background(), "user-1", "conv-1", testWAV, "wav")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VoiceChat() error = %v, want %v", err, tt.want)
+			}
+			if turns := conversationTurns(t, db, "conv-1"); len(turns) != 0 {
+				t.Errorf("stored %d turns without a transcript", len(turns))
+			}
+		})
+	}
+}
+
+func TestNewTranscriberWithoutKey(t *testing.T) {
+	transcriber := NewTranscriber(&config.AIConfig{STTProvider: "openai"})
+	_, err := transcriber.Transcribe(context.Background(), testWAV, "wav")
+
+	var configErr *ProviderConfigError
+	if !errors.As(err, &configErr) || configErr.EnvVar != sttKeyEnv || !errors.Is(err, ErrProviderNotConfigured) {
+		t.Fatalf("Transcribe() error = %v, want a missing %s error", err, sttKeyEnv)
+	}
+}
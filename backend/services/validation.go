@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/clarity/backend/models"
+	"gorm.io/gorm/clause"
+)
+
+// Input validation shared by the services layer. User-supplied strings
+// reach the database only as bound parameters; anything that has to be
+// spliced into SQL structure (column names, sort direction) is looked up in
+// an allow-list here and never copied from the request.
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// builtinRecordTypes are the record types every deployment accepts;
+// RecordTypes adds the configured custom ones
+var builtinRecordTypes = map[string]bool{
+	"prescription": true,
+	"appointment":  true,
+	"lab_result":   true,
+	"symptom":      true,
+}
+
+// recordRelationTypes are the relationships LinkRecords accepts
+var recordRelationTypes = map[string]bool{
+	"related":        true,
+	"result_of":      true, // lab result -> appointment that ordered it
+	"prescribed_for": true, // prescription -> diagnosis or symptom
+	"follow_up_of":   true,
+}
+
+// otpChannels are the channels a user may choose for sign-in codes
+var otpChannels = map[string]bool{
+	models.DeliveryChannelEmail:    true,
+	models.DeliveryChannelPush:     true,
+	models.DeliveryChannelWhatsApp: true,
+}
+
+// maxProfileNameLength bounds a user's display name, in characters
+const maxProfileNameLength = 100
+
+// profileGenders are the genders a user may give in their profile
+var profileGenders = map[string]bool{
+	"female":            true,
+	"male":              true,
+	"non_binary":        true,
+	"other":             true,
+	"prefer_not_to_say": true,
+}
+
+// profileBloodTypes are the ABO and Rh blood types a user may give
+var profileBloodTypes = map[string]bool{
+	"A+": true, "A-": true,
+	"B+": true, "B-": true,
+	"AB+": true, "AB-": true,
+	"O+": true, "O-": true,
+}
+
+// recordSensitivities are the sensitivity levels an owner may set on a record
+var recordSensitivities = map[string]bool{
+	models.SensitivityStandard:  true,
+	models.SensitivitySensitive: true,
+	models.SensitivityBlocked:   true,
+}
+
+// recordSortColumns maps accepted sort keys to the column they order by
+var recordSortColumns = map[string]string{
+	"":            "created_at",
+	"created_at":  "created_at",
+	"updated_at":  "updated_at",
+	"title":       "title",
+	"record_type": "record_type",
+}
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// phonePattern matches E.164 phone numbers
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// validateRelationType rejects record relationships outside the allow-list
+func validateRelationType(relationType string) error {
+	if !recordRelationTypes[relationType] {
+		return fmt.Errorf("%w: unknown relation type %q", ErrInvalidArgument, relationType)
+	}
+	return nil
+}
+
+// recordOrder resolves a client-supplied sort key and direction into an
+// ORDER BY clause built only from allow-listed column names.
+func recordOrder(sortBy, sortOrder string) (clause.OrderByColumn, error) {
+	column, ok := recordSortColumns[strings.ToLower(sortBy)]
+	if !ok {
+		return clause.OrderByColumn{}, fmt.Errorf("%w: cannot sort by %q", ErrInvalidArgument, sortBy)
+	}
+
+	var desc bool
+	switch strings.ToLower(sortOrder) {
+	case "", "desc":
+		desc = true
+	case "asc":
+		desc = false
+	default:
+		return clause.OrderByColumn{}, fmt.Errorf("%w: sort order must be asc or desc", ErrInvalidArgument)
+	}
+
+	return clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc}, nil
+}
+
+// pageBounds clamps client-supplied pagination to sane values
+func pageBounds(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// validateMetadataEntries checks metadata keys are short identifiers and
+// values are bounded and free of control characters (tabs and newlines are
+// allowed in values).
+func validateMetadataEntries(metadata map[string]string, maxKeyLength, maxValueLength int) error {
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: metadata key %q must contain only letters, digits, '_', '.', or '-'", ErrInvalidArgument, key)
+		}
+		if maxKeyLength > 0 && len(key) > maxKeyLength {
+			return fmt.Errorf("%w: metadata key %q exceeds %d characters", ErrMetadataTooLarge, key, maxKeyLength)
+		}
+		if maxValueLength > 0 && len(value) > maxValueLength {
+			return fmt.Errorf("%w: metadata value for %q exceeds %d bytes", ErrMetadataTooLarge, key, maxValueLength)
+		}
+		for _, r := range value {
+			if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+				return fmt.Errorf("%w: metadata value for %q contains control characters", ErrInvalidArgument, key)
+			}
+		}
+	}
+	return nil
+}
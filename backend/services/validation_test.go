@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestRecordOrder(t *testing.T) {
+	tests := []struct {
+		sortBy, sortOrder string
+		wantColumn        string
+		wantDesc          bool
+		wantErr           bool
+	}{
+		{"", "", "created_at", true, false},
+		{"TITLE", "asc", "title", false, false},
+		{"updated_at", "DESC", "updated_at", true, false},
+		{"record_type", "asc", "record_type", false, false},
+		{"user_id", "", "", false, true},
+		{"title; DROP TABLE health_records", "", "", false, true},
+		{"title", "asc, id", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy+" "+tt.sortOrder, func(t *testing.T) {
+			order, err := recordOrder(tt.sortBy, tt.sortOrder)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidArgument) {
+					t.Fatalf("recordOrder() error = %v, want ErrInvalidArgument", err)
+				}
+				return
+			}
+			if err != nil || order.Column.Name != tt.wantColumn || order.Desc != tt.wantDesc {
+				t.Errorf("recordOrder() = %+v, %v, want %s desc=%v", order, err, tt.wantColumn, tt.wantDesc)
+			}
+		})
+	}
+}
+
+func FuzzRecordOrder(f *testing.F) {
+	for _, seed := range []string{"", "title", "created_at", "id); DROP TABLE users;--", "TiTlE"} {
+		f.Add(seed, "asc")
+	}
+	allowed := make(map[string]bool, len(recordSortColumns))
+	for _, column := range recordSortColumns {
+		allowed[column] = true
+	}
+
+	f.Fuzz(func(t *testing.T, sortBy, sortOrder string) {
+		order, err := recordOrder(sortBy, sortOrder)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidArgument) {
+				t.Fatalf("recordOrder(%q, %q) error = %v, want ErrInvalidArgument", sortBy, sortOrder, err)
+			}
+			return
+		}
+		if !allowed[order.Column.Name] || order.Column.Raw {
+			t.Fatalf("recordOrder(%q, %q) orders by %q, outside the allow-list", sortBy, sortOrder, order.Column.Name)
+		}
+	})
+}
+
+func TestValidateMetadataEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     error
+	}{
+		{"identifier keys", map[string]string{"lab.name": "City", "ref_range-low": "3.5"}, nil},
+		{"tabs and newlines in values", map[string]string{"notes": "line one\n\tline two\r\n"}, nil},
+		{"space in key", map[string]string{"lab name": "City"}, ErrInvalidArgument},
+		{"quote in key", map[string]string{`a"b`: "x"}, ErrInvalidArgument},
+		{"empty key", map[string]string{"": "x"}, ErrInvalidArgument},
+		{"control character in value", map[string]string{"notes": "a\x00b"}, ErrInvalidArgument},
+		{"escape sequence in value", map[string]string{"notes": "\x1b[2J"}, ErrInvalidArgument},
+		{"key too long", map[string]string{strings.Repeat("k", 17): "x"}, ErrMetadataTooLarge},
+		{"value too long", map[string]string{"k": strings.Repeat("x", 33)}, ErrMetadataTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMetadataEntries(tt.metadata, 16, 32)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("validateMetadataEntries() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzValidateMetadataEntries(f *testing.F) {
+	f.Add("source", "City Lab")
+	f.Add("bad key", "x")
+	f.Add("notes", "a\x00b")
+	f.Add("k", "‮")
+
+	f.Fuzz(func(t *testing.T, key, value string) {
+		err := validateMetadataEntries(map[string]string{key: value}, 64, 256)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidArgument) && !errors.Is(err, ErrMetadataTooLarge) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+		if !metadataKeyPattern.MatchString(key) || len(key) > 64 || len(value) > 256 {
+			t.Fatalf("accepted key %q with a %d byte value", key, len(value))
+		}
+		for _, r := range value {
+			if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+				t.Fatalf("accepted control character %U in %q", r, value)
+			}
+		}
+	})
+}
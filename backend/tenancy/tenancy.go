@@ -0,0 +1,52 @@
+// Package tenancy carries the tenant a request or background job acts for.
+// In a multi-tenant deployment each tenant's data is in its own database,
+// and the database layer routes every statement to the database of the
+// tenant in its context.
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoTenant is returned for database work whose context carries no
+	// tenant in a multi-tenant deployment
+	ErrNoTenant = errors.New("no tenant in context")
+	// ErrUnknownTenant is returned for a tenant that is not configured
+	ErrUnknownTenant = errors.New("unknown tenant")
+)
+
+type contextKey struct{}
+
+// WithTenant returns ctx acting for tenant id
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant ctx acts for, if any
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Each runs fn once for every tenant, with a context acting for it, and
+// joins their errors. Without tenants it runs fn once with ctx, as a
+// single-database deployment does.
+func Each(ctx context.Context, tenants []string, fn func(ctx context.Context) error) error {
+	if len(tenants) == 0 {
+		return fn(ctx)
+	}
+	var errs []error
+	for _, id := range tenants {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		if err := fn(WithTenant(ctx, id)); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
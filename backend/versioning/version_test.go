@@ -0,0 +1,51 @@
+package versioning
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Version
+		wantErr bool
+	}{
+		{"1", Version{1, 0, 0}, false},
+		{"1.4", Version{1, 4, 0}, false},
+		{"1.4.2", Version{1, 4, 2}, false},
+		{"v2.0.1", Version{2, 0, 1}, false},
+		{" 1.4.2-beta.2 ", Version{1, 4, 2}, false},
+		{"1.4.2+build5", Version{1, 4, 2}, false},
+		{"", Version{}, true},
+		{"v", Version{}, true},
+		{"1.4.2.7", Version{}, true},
+		{"1.x", Version{}, true},
+		{"1.-4", Version{}, true},
+		{"1..2", Version{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{"1.4.2", "1.4.3", true},
+		{"1.4.9", "1.5.0", true},
+		{"1.9.9", "2.0.0", true},
+		{"1.10.0", "1.9.0", false},
+		{"1.4.2", "1.4.2", false},
+		{"2.0.0", "1.99.99", false},
+	}
+	for _, tt := range tests {
+		a, _ := ParseVersion(tt.a)
+		b, _ := ParseVersion(tt.b)
+		if got := a.Less(b); got != tt.less {
+			t.Errorf("%s.Less(%s) = %v, want %v", tt.a, tt.b, got, tt.less)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clarity/backend/config"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Metadata keys clients send with every call: the app release, and the
+// API version it was built against
+const (
+	clientVersionHeader = "x-client-version"
+	apiVersionHeader    = "x-api-version"
+)
+
+// APIVersion is the newest API version this server speaks
+const APIVersion = 1
+
+// UpgradeRequiredReason is the ErrorInfo reason on calls refused because
+// the client is older than the method's minimum version
+const UpgradeRequiredReason = "CLIENT_UPGRADE_REQUIRED"
+
+const errorDomain = "clarity"
+
+// allMethods keys the minimum version that applies to every method
+const allMethods = "*"
+
+// unknownClient stands in for the version of clients that send none
+const unknownClient = "unknown"
+
+// maxUsageEntries bounds the usage table, since clients choose the version
+// strings; further combinations are counted under unknownClient
+const maxUsageEntries = 1000
+
+// Detector reports whether a request relies on deprecated behaviour
+type Detector func(req proto.Message) bool
+
+// FieldSet returns a Detector that fires when a request populates field
+func FieldSet(field string) Detector {
+	name := protoreflect.Name(field)
+	return func(req proto.Message) bool {
+		msg := req.ProtoReflect()
+		fd := msg.Descriptor().Fields().ByName(name)
+		return fd != nil && msg.Has(fd)
+	}
+}
+
+// Usage counts calls to a method that relied on a deprecated field, per
+// client and API version
+type Usage struct {
+	Method        string
+	Field         string
+	ClientVersion string // "unknown" when the client sent none
+	APIVersion    int    // 0 when the client sent none
+	Count         int64
+	LastSeen      time.Time
+}
+
+// Rejection counts calls refused for a client below a method's minimum
+type Rejection struct {
+	Method        string
+	ClientVersion string
+	Count         int64
+	LastSeen      time.Time
+}
+
+// Minimum is the oldest client version a method accepts. Method is "*"
+// for the default applied to every method.
+type Minimum struct {
+	Method  string
+	Version Version
+}
+
+type deprecation struct {
+	field  string
+	detect Detector
+}
+
+type usageKey struct {
+	method, field, client string
+	api                   int
+}
+
+type rejectionKey struct {
+	method, client string
+}
+
+// client is what a call says about the app making it
+type client struct {
+	version Version
+	known   bool
+	api     int
+}
+
+func (c client) label() string {
+	if !c.known {
+		return unknownClient
+	}
+	return c.version.String()
+}
+
+// Registry holds the deprecated request fields of every method and the
+// minimum client versions, and counts how clients use them. Its
+// interceptors read the client's x-client-version and x-api-version
+// metadata, refuse clients older than a method's minimum with
+// FailedPrecondition, and record each deprecated field a request uses.
+type Registry struct {
+	mu           sync.Mutex
+	deprecations map[string][]deprecation // full method name -> fields
+	configured   map[string]Version       // minimums by name as configured
+	minimums     map[string]Version       // full method name or "*" -> minimum
+	usage        map[usageKey]*Usage
+	rejections   map[rejectionKey]*Rejection
+	now          func() time.Time
+}
+
+// NewRegistry reads the configured minimum client versions, given as
+// "Method=1.4.0" by name or full name, with "*=1.2.0" setting the default
+func NewRegistry(cfg *config.VersioningConfig) (*Registry, error) {
+	r := &Registry{
+		deprecations: make(map[string][]deprecation),
+		configured:   make(map[string]Version),
+		minimums:     make(map[string]Version),
+		usage:        make(map[usageKey]*Usage),
+		rejections:   make(map[rejectionKey]*Rejection),
+		now:          time.Now,
+	}
+	for _, entry := range cfg.MinClientVersions {
+		method, raw, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("minimum client version %q must look like Method=1.2.0", entry)
+		}
+		version, err := ParseVersion(raw)
+		if err != nil {
+			return nil, fmt.Errorf("minimum client version for %s: %w", method, err)
+		}
+		r.configured[strings.TrimSpace(method)] = version
+	}
+	return r, nil
+}
+
+// Deprecate registers a detector for a deprecated field of a method's
+// requests
+func (r *Registry) Deprecate(fullMethod, field string, detect Detector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deprecations[fullMethod] = append(r.deprecations[fullMethod], deprecation{field: field, detect: detect})
+}
+
+// DeprecateField registers FieldSet(field) for every method of services
+// whose request message has field, and returns how many it found
+func (r *Registry) DeprecateField(services map[string]grpc.ServiceInfo, field string) int {
+	found := 0
+	for service, info := range services {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+		if err != nil {
+			continue
+		}
+		serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+		for _, method := range info.Methods {
+			methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method.Name))
+			if methodDesc == nil || methodDesc.Input().Fields().ByName(protoreflect.Name(field)) == nil {
+				continue
+			}
+			r.Deprecate("/"+service+"/"+method.Name, field, FieldSet(field))
+			found++
+		}
+	}
+	return found
+}
+
+// Register resolves the configured minimums against the methods of the
+// services on a gRPC server, and checks every deprecation names one of
+// them. Call it after every service is registered and every deprecation
+// is added.
+func (r *Registry) Register(services map[string]grpc.ServiceInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	methods := make(map[string]bool)
+	bare := make(map[string][]string)
+	for service, info := range services {
+		for _, method := range info.Methods {
+			full := "/" + service + "/" + method.Name
+			methods[full] = true
+			bare[method.Name] = append(bare[method.Name], full)
+		}
+	}
+
+	for name, version := range r.configured {
+		full := name
+		if name != allMethods && !methods[name] {
+			switch candidates := bare[name]; len(candidates) {
+			case 0:
+				return fmt.Errorf("minimum client version names unknown method %q", name)
+			case 1:
+				full = candidates[0]
+			default:
+				sort.Strings(candidates)
+				return fmt.Errorf("method %q is ambiguous, use one of %s", name, strings.Join(candidates, ", "))
+			}
+		}
+		r.minimums[full] = version
+	}
+	for method := range r.deprecations {
+		if !methods[method] {
+			return fmt.Errorf("deprecation registered for unknown method %s", method)
+		}
+	}
+	return nil
+}
+
+// Minimums returns the minimum client versions in method order
+func (r *Registry) Minimums() []Minimum {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	minimums := make([]Minimum, 0, len(r.minimums))
+	for method, version := range r.minimums {
+		minimums = append(minimums, Minimum{Method: method, Version: version})
+	}
+	sort.Slice(minimums, func(i, j int) bool { return minimums[i].Method < minimums[j].Method })
+	return minimums
+}
+
+// Usage returns the deprecated field usage seen since startup, most used
+// first
+func (r *Registry) Usage() []Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage := make([]Usage, 0, len(r.usage))
+	for _, u := range r.usage {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Count != usage[j].Count {
+			return usage[i].Count > usage[j].Count
+		}
+		return usage[i].Method+usage[i].Field+usage[i].ClientVersion < usage[j].Method+usage[j].Field+usage[j].ClientVersion
+	})
+	return usage
+}
+
+// Rejections returns the calls refused since startup, most refused first
+func (r *Registry) Rejections() []Rejection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rejections := make([]Rejection, 0, len(r.rejections))
+	for _, rejection := range r.rejections {
+		rejections = append(rejections, *rejection)
+	}
+	sort.Slice(rejections, func(i, j int) bool {
+		if rejections[i].Count != rejections[j].Count {
+			return rejections[i].Count > rejections[j].Count
+		}
+		return rejections[i].Method+rejections[i].ClientVersion < rejections[j].Method+rejections[j].ClientVersion
+	})
+	return rejections
+}
+
+// clientFromContext reads the version metadata of a call. Missing values
+// are allowed; malformed ones and API versions newer than the server's
+// are refused.
+func clientFromContext(ctx context.Context) (client, error) {
+	var c client
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(clientVersionHeader); len(values) > 0 && values[0] != "" {
+		version, err := ParseVersion(values[0])
+		if err != nil {
+			return c, status.Error(codes.InvalidArgument, clientVersionHeader+": "+err.Error())
+		}
+		c.version, c.known = version, true
+	}
+	if values := md.Get(apiVersionHeader); len(values) > 0 && values[0] != "" {
+		api, err := strconv.Atoi(values[0])
+		if err != nil || api < 1 {
+			return c, status.Errorf(codes.InvalidArgument, "%s must be a positive integer", apiVersionHeader)
+		}
+		if api > APIVersion {
+			return c, status.Errorf(codes.FailedPrecondition, "API version %d is not supported, the server speaks up to %d", api, APIVersion)
+		}
+		c.api = api
+	}
+	return c, nil
+}
+
+// checkMinimum refuses clients older than the method's minimum version.
+// Clients that send no version predate the convention and are refused
+// whenever a minimum applies.
+func (r *Registry) checkMinimum(fullMethod string, c client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	minimum, ok := r.minimums[fullMethod]
+	if !ok {
+		if minimum, ok = r.minimums[allMethods]; !ok {
+			return nil
+		}
+	}
+	if c.known && !c.version.Less(minimum) {
+		return nil
+	}
+
+	key := rejectionKey{method: fullMethod, client: c.label()}
+	if _, ok := r.rejections[key]; !ok && len(r.rejections) >= maxUsageEntries {
+		key.client = unknownClient
+	}
+	rejection := r.rejections[key]
+	if rejection == nil {
+		rejection = &Rejection{Method: key.method, ClientVersion: key.client}
+		r.rejections[key] = rejection
+	}
+	rejection.Count++
+	rejection.LastSeen = r.now()
+
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	st := status.New(codes.FailedPrecondition,
+		fmt.Sprintf("this version of the app no longer supports %s, please upgrade to %s or later", name, minimum))
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: UpgradeRequiredReason,
+		Domain: errorDomain,
+		Metadata: map[string]string{
+			"method":         fullMethod,
+			"min_version":    minimum.String(),
+			"client_version": c.label(),
+		},
+	}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// detect runs the method's deprecation detectors on one request message
+func (r *Registry) detect(fullMethod string, c client, req interface{}) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, d := range r.deprecations[fullMethod] {
+		if !d.detect(msg) {
+			continue
+		}
+		key := usageKey{method: fullMethod, field: d.field, client: c.label(), api: c.api}
+		if _, ok := r.usage[key]; !ok && len(r.usage) >= maxUsageEntries {
+			key.client, key.api = unknownClient, 0
+		}
+		usage := r.usage[key]
+		if usage == nil {
+			usage = &Usage{Method: key.method, Field: key.field, ClientVersion: key.client, APIVersion: key.api}
+			r.usage[key] = usage
+		}
+		usage.Count++
+		usage.LastSeen = r.now()
+	}
+}
+
+// UnaryServerInterceptor checks the client version and records
+// deprecated field use
+func (r *Registry) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		c, err := clientFromContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.checkMinimum(info.FullMethod, c); err != nil {
+			return nil, err
+		}
+		r.detect(info.FullMethod, c, req)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor checks the client version when a stream opens
+// and records deprecated field use in every message it receives
+func (r *Registry) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		c, err := clientFromContext(ss.Context())
+		if err != nil {
+			return err
+		}
+		if err := r.checkMinimum(info.FullMethod, c); err != nil {
+			return err
+		}
+		return handler(srv, &detectingStream{ServerStream: ss, registry: r, method: info.FullMethod, client: c})
+	}
+}
+
+type detectingStream struct {
+	grpc.ServerStream
+	registry *Registry
+	method   string
+	client   client
+}
+
+func (ds *detectingStream) RecvMsg(m interface{}) error {
+	if err := ds.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	ds.registry.detect(ds.method, ds.client, m)
+	return nil
+}
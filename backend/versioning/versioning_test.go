@@ -0,0 +1,283 @@
+package versioning
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clarity/backend/config"
+	healthpb "github.com/clarity/backend/gen/go/health"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	listRecords  = "/clarity.health.HealthRecordsService/ListRecords"
+	createRecord = "/clarity.health.HealthRecordsService/CreateRecord"
+)
+
+func healthServices() map[string]grpc.ServiceInfo {
+	server := grpc.NewServer()
+	healthpb.RegisterHealthRecordsServiceServer(server, healthpb.UnimplementedHealthRecordsServiceServer{})
+	return server.GetServiceInfo()
+}
+
+// newTestRegistry returns a registry with the minimums given, the
+// user_id deprecation and a fixed clock
+func newTestRegistry(t *testing.T, minimums ...string) *Registry {
+	t.Helper()
+	r, err := NewRegistry(&config.VersioningConfig{MinClientVersions: minimums})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	r.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+	services := healthServices()
+	if r.DeprecateField(services, "user_id") == 0 {
+		t.Fatal("no health method has a user_id field")
+	}
+	if err := r.Register(services); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	return r
+}
+
+// clientCtx returns an incoming context carrying the version headers
+// given as key, value pairs
+func clientCtx(kv ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+}
+
+// call runs req to method through the unary interceptor, reporting
+// whether the handler ran
+func call(r *Registry, ctx context.Context, method string, req interface{}) (bool, error) {
+	ran := false
+	_, err := r.UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		ran = true
+		return nil, nil
+	})
+	return ran, err
+}
+
+func TestDeprecatedFieldUsageIsCounted(t *testing.T) {
+	r := newTestRegistry(t)
+
+	calls := []struct {
+		ctx context.Context
+		req interface{}
+	}{
+		{clientCtx(clientVersionHeader, "1.2.0", apiVersionHeader, "1"), &healthpb.ListRecordsRequest{UserId: "user-1"}},
+		{clientCtx(clientVersionHeader, "1.2.0", apiVersionHeader, "1"), &healthpb.ListRecordsRequest{UserId: "user-1"}},
+		{clientCtx(clientVersionHeader, "1.3.0"), &healthpb.CreateRecordRequest{UserId: "user-1"}},
+		{context.Background(), &healthpb.ListRecordsRequest{UserId: "user-1"}},
+		// Not using the field is not counted
+		{clientCtx(clientVersionHeader, "1.3.0"), &healthpb.ListRecordsRequest{}},
+		{clientCtx(clientVersionHeader, "1.3.0"), &healthpb.GetRecordRequest{RecordId: "rec-1"}},
+	}
+	for _, c := range calls {
+		if ran, err := call(r, c.ctx, listRecords, c.req); err != nil || !ran {
+			t.Fatalf("call = %v, %v; want it to run", ran, err)
+		}
+	}
+
+	got := r.Usage()
+	want := []Usage{
+		{Method: listRecords, Field: "user_id", ClientVersion: "1.2.0", APIVersion: 1, Count: 2},
+		{Method: listRecords, Field: "user_id", ClientVersion: "1.3.0", Count: 1},
+		{Method: listRecords, Field: "user_id", ClientVersion: unknownClient, Count: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Usage = %+v, want %d entries", got, len(want))
+	}
+	for i := range want {
+		want[i].LastSeen = r.now()
+		if got[i] != want[i] {
+			t.Errorf("Usage[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if len(r.Rejections()) != 0 {
+		t.Errorf("Rejections = %v with no minimums set", r.Rejections())
+	}
+}
+
+func TestFieldSet(t *testing.T) {
+	detect := FieldSet("user_id")
+	if !detect(&healthpb.ListRecordsRequest{UserId: "user-1"}) {
+		t.Error("user_id set was not detected")
+	}
+	if detect(&healthpb.ListRecordsRequest{RecordType: "lab_result"}) {
+		t.Error("user_id left empty was detected")
+	}
+	if detect(&healthpb.GetRecordRequest{RecordId: "rec-1"}) {
+		t.Error("a message without user_id was detected")
+	}
+}
+
+// fakeServerStream is a client stream replaying messages
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages []*healthpb.ListRecordsRequest
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	next := s.messages[0]
+	s.messages = s.messages[1:]
+	m.(*healthpb.ListRecordsRequest).UserId = next.UserId
+	return nil
+}
+
+func TestDeprecatedFieldUsageOnStreams(t *testing.T) {
+	r := newTestRegistry(t)
+	ss := &fakeServerStream{
+		ctx:      clientCtx(clientVersionHeader, "1.2.0"),
+		messages: []*healthpb.ListRecordsRequest{{UserId: "user-1"}, {}, {UserId: "user-1"}},
+	}
+	err := r.StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{FullMethod: listRecords}, func(srv interface{}, stream grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			if err := stream.RecvMsg(&healthpb.ListRecordsRequest{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if usage := r.Usage(); len(usage) != 1 || usage[0].Count != 2 {
+		t.Errorf("Usage = %+v, want 2 messages with user_id", usage)
+	}
+}
+
+func TestMinimumClientVersion(t *testing.T) {
+	r := newTestRegistry(t, "CreateRecord=1.4.0", "*=1.2.0")
+
+	tests := []struct {
+		name    string
+		method  string
+		version string // "" sends no version
+		refused bool
+	}{
+		{"at the method minimum", createRecord, "1.4.0", false},
+		{"above the method minimum", createRecord, "2.0.0", false},
+		{"below the method minimum", createRecord, "1.3.9", true},
+		{"below the method minimum but above the default", createRecord, "1.2.0", true},
+		{"at the default", listRecords, "1.2.0", false},
+		{"below the default", listRecords, "1.1.7", true},
+		{"no version", listRecords, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.version != "" {
+				ctx = clientCtx(clientVersionHeader, tt.version)
+			}
+			ran, err := call(r, ctx, tt.method, &healthpb.ListRecordsRequest{})
+			if !tt.refused {
+				if err != nil || !ran {
+					t.Errorf("call = %v, %v; want it to run", ran, err)
+				}
+				return
+			}
+			if ran {
+				t.Error("handler ran for a refused client")
+			}
+			st := status.Convert(err)
+			if st.Code() != codes.FailedPrecondition || !strings.Contains(st.Message(), "please upgrade") {
+				t.Fatalf("error = %v, want FailedPrecondition asking to upgrade", err)
+			}
+			var info *errdetails.ErrorInfo
+			for _, detail := range st.Details() {
+				if d, ok := detail.(*errdetails.ErrorInfo); ok {
+					info = d
+				}
+			}
+			want := "1.2.0"
+			if tt.method == createRecord {
+				want = "1.4.0"
+			}
+			if info == nil || info.Reason != UpgradeRequiredReason || info.Metadata["min_version"] != want || info.Metadata["method"] != tt.method {
+				t.Errorf("error info = %v, want %s with minimum %s", info, UpgradeRequiredReason, want)
+			}
+		})
+	}
+
+	rejections := r.Rejections()
+	if len(rejections) != 4 {
+		t.Fatalf("Rejections = %+v, want 4", rejections)
+	}
+	var unknown int64
+	for _, rejection := range rejections {
+		if rejection.ClientVersion == unknownClient {
+			unknown += rejection.Count
+		}
+	}
+	if unknown != 1 {
+		t.Errorf("%d rejections of clients without a version, want 1", unknown)
+	}
+
+	minimums := r.Minimums()
+	if len(minimums) != 2 || minimums[0].Method != "*" || minimums[1].Method != createRecord {
+		t.Errorf("Minimums = %+v, want the default and CreateRecord by full name", minimums)
+	}
+}
+
+func TestVersionHeadersAreValidated(t *testing.T) {
+	r := newTestRegistry(t)
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"valid", clientCtx(clientVersionHeader, "v1.2.0-rc.1", apiVersionHeader, "1"), codes.OK},
+		{"malformed client version", clientCtx(clientVersionHeader, "latest"), codes.InvalidArgument},
+		{"malformed API version", clientCtx(apiVersionHeader, "one"), codes.InvalidArgument},
+		{"zero API version", clientCtx(apiVersionHeader, "0"), codes.InvalidArgument},
+		{"newer API version", clientCtx(apiVersionHeader, "2"), codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		ran, err := call(r, tt.ctx, listRecords, &healthpb.ListRecordsRequest{})
+		if status.Code(err) != tt.code || ran != (tt.code == codes.OK) {
+			t.Errorf("%s: call = %v, %v; want %v", tt.name, ran, err, tt.code)
+		}
+	}
+}
+
+func TestRegisterChecksMethodNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		minimums []string
+		wantErr  string
+	}{
+		{"bare name", []string{"ListRecords=1.0"}, ""},
+		{"full name", []string{listRecords + "=1.0"}, ""},
+		{"unknown method", []string{"ListEverything=1.0"}, "unknown method"},
+	}
+	for _, tt := range tests {
+		r, err := NewRegistry(&config.VersioningConfig{MinClientVersions: tt.minimums})
+		if err != nil {
+			t.Fatalf("%s: NewRegistry: %v", tt.name, err)
+		}
+		err = r.Register(healthServices())
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: Register = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	for _, bad := range []string{"ListRecords", "ListRecords=soon"} {
+		if _, err := NewRegistry(&config.VersioningConfig{MinClientVersions: []string{bad}}); err == nil {
+			t.Errorf("NewRegistry accepted minimum %q", bad)
+		}
+	}
+
+	r, _ := NewRegistry(&config.VersioningConfig{})
+	r.Deprecate("/clarity.health.HealthRecordsService/Gone", "user_id", FieldSet("user_id"))
+	if err := r.Register(healthServices()); err == nil {
+		t.Error("Register accepted a deprecation of an unknown method")
+	}
+}